@@ -0,0 +1,260 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
+)
+
+// SessionAuditDigest returns the host-side copy of the session audit digest for the supplied session, which is computed from the
+// commands that have been executed with the session used for auditing. It can be compared against the digest returned from
+// TPMContext.GetSessionAuditDigest using CheckSessionAuditDigest.
+//
+// The host-side digest is not serialized with the session, so it is not preserved across TPMContext.ContextSave and
+// TPMContext.ContextLoad or HandleContext.SerializeToBytes. This returns nil if the session hasn't been used for auditing since
+// it was created or loaded, or if it wasn't created by this package.
+func SessionAuditDigest(session SessionContext) Digest {
+	s, ok := session.(*sessionContext)
+	if !ok || s.host == nil {
+		return nil
+	}
+	return s.host.auditDigest
+}
+
+// CheckSessionAuditDigest checks that the session audit digest in the supplied attestation structure matches the host-side audit
+// digest for the supplied session (see SessionAuditDigest). The attestation structure would typically be obtained from
+// TPMContext.GetSessionAuditDigest. Note that this does not verify the signature of the attestation structure - the caller is
+// responsible for doing this.
+func CheckSessionAuditDigest(attest *Attest, session SessionContext) error {
+	if attest == nil || attest.Type != TagAttestSessionAudit || attest.Attested == nil || attest.Attested.SessionAudit == nil {
+		return errors.New("attestation structure does not contain session audit information")
+	}
+	if !bytes.Equal(attest.Attested.SessionAudit.SessionDigest, SessionAuditDigest(session)) {
+		return errors.New("session audit digest does not match the host-side digest")
+	}
+	return nil
+}
+
+// CommandAuditLogEntry describes a command that has been recorded in a CommandAuditLog.
+type CommandAuditLogEntry struct {
+	CommandCode CommandCode
+	CpHash      Digest // The command parameter digest, computed with the log's digest algorithm
+	RpHash      Digest // The response parameter digest, computed with the log's digest algorithm
+}
+
+// CommandAuditLog is a host-side record of the commands executed by a TPMContext that are audited by the TPM, from which the
+// TPM's command audit digest can be reconstructed. It is created by TPMContext.EnableCommandAuditLog.
+//
+// The log tracks the audit digest algorithm and the list of audited commands as they are changed by
+// TPMContext.SetCommandCodeAuditStatus, and it is cleared whenever the TPM clears its audit digest. It can only reconstruct the
+// digest from the commands executed by the TPMContext that it was enabled on, so it will not match the TPM's digest if audited
+// commands are executed by other means.
+type CommandAuditLog struct {
+	mu       sync.Mutex
+	alg      HashAlgorithmId
+	commands map[CommandCode]struct{}
+	entries  []CommandAuditLogEntry
+	reported *CommandAuditInfo // The information expected in the most recent audit digest report
+}
+
+// DigestAlg returns the digest algorithm that the log expects the TPM to be using for command auditing.
+func (l *CommandAuditLog) DigestAlg() HashAlgorithmId {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.alg
+}
+
+// Entries returns the commands that have been recorded since the TPM's audit digest was last cleared.
+func (l *CommandAuditLog) Entries() []CommandAuditLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]CommandAuditLogEntry(nil), l.entries...)
+}
+
+// Digest returns the audit digest reconstructed from the commands that have been recorded since the TPM's audit digest was last
+// cleared. This is empty if no audited commands have been executed since then.
+func (l *CommandAuditLog) Digest() Digest {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.digest()
+}
+
+func (l *CommandAuditLog) digest() Digest {
+	if len(l.entries) == 0 {
+		return nil
+	}
+
+	digest := make(Digest, l.alg.Size())
+	for _, e := range l.entries {
+		h := l.alg.NewHash()
+		h.Write(digest)
+		h.Write(e.CpHash)
+		h.Write(e.RpHash)
+		digest = h.Sum(nil)
+	}
+	return digest
+}
+
+func (l *CommandAuditLog) commandDigest() Digest {
+	var commands CommandCodeList
+	for c := range l.commands {
+		commands = append(commands, c)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i] < commands[j] })
+
+	h := l.alg.NewHash()
+	for _, c := range commands {
+		binary.Write(h, binary.BigEndian, c)
+	}
+	return h.Sum(nil)
+}
+
+// Check checks that the supplied attestation structure, which would typically be obtained from TPMContext.GetCommandAuditDigest,
+// matches the command audit state that the log expected at the time of the most recent call to TPMContext.GetCommandAuditDigest.
+// Note that this does not verify the signature of the attestation structure - the caller is responsible for doing this.
+func (l *CommandAuditLog) Check(attest *Attest) error {
+	if attest == nil || attest.Type != TagAttestCommandAudit || attest.Attested == nil || attest.Attested.CommandAudit == nil {
+		return errors.New("attestation structure does not contain command audit information")
+	}
+	info := attest.Attested.CommandAudit
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case l.reported == nil:
+		return errors.New("no command audit digest has been obtained")
+	case info.DigestAlg != l.reported.DigestAlg:
+		return errors.New("unexpected command audit digest algorithm")
+	case !bytes.Equal(info.CommandDigest, l.reported.CommandDigest):
+		return errors.New("list of audited commands does not match the host-side list")
+	case !bytes.Equal(info.AuditDigest, l.reported.AuditDigest):
+		return errors.New("command audit digest does not match the host-side digest")
+	}
+	return nil
+}
+
+// setCommandCodeAuditStatus updates the log for a successful TPM2_SetCommandCodeAuditStatus command.
+func (l *CommandAuditLog) setCommandCodeAuditStatus(cpBytes []byte) {
+	var auditAlg HashAlgorithmId
+	var setList, clearList CommandCodeList
+	if _, err := mu.UnmarshalFromBytes(cpBytes, &auditAlg, &setList, &clearList); err != nil {
+		return
+	}
+
+	if auditAlg != HashAlgorithmNull && auditAlg != l.alg {
+		// Changing the digest algorithm clears the digest.
+		l.alg = auditAlg
+		l.entries = nil
+		return
+	}
+
+	changed := false
+	for _, c := range setList {
+		if _, ok := l.commands[c]; !ok {
+			l.commands[c] = struct{}{}
+			changed = true
+		}
+	}
+	for _, c := range clearList {
+		if c == CommandSetCommandCodeAuditStatus {
+			// This command is always audited.
+			continue
+		}
+		if _, ok := l.commands[c]; ok {
+			delete(l.commands, c)
+			changed = true
+		}
+	}
+	if changed {
+		l.entries = nil
+	}
+}
+
+// record updates the log for a command that completed successfully.
+func (l *CommandAuditLog) record(cmd *cmdContext) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch cmd.commandCode {
+	case CommandSetCommandCodeAuditStatus:
+		l.setCommandCodeAuditStatus(cmd.cpBytes)
+	case CommandGetCommandAuditDigest:
+		l.reported = &CommandAuditInfo{
+			DigestAlg:     AlgorithmId(l.alg),
+			AuditDigest:   l.digest(),
+			CommandDigest: l.commandDigest()}
+		// The digest is cleared once it has been reported, unless the signing key is TPM_RH_NULL.
+		if len(cmd.handleNames) == 2 && !(cmd.handleNames[1].IsHandle() && cmd.handleNames[1].Handle() == HandleNull) {
+			l.entries = nil
+		}
+	}
+
+	if _, audited := l.commands[cmd.commandCode]; !audited {
+		return
+	}
+	l.entries = append(l.entries, CommandAuditLogEntry{
+		CommandCode: cmd.commandCode,
+		CpHash:      cryptComputeCpHash(l.alg, cmd.commandCode, cmd.handleNames, cmd.cpBytes),
+		RpHash:      cryptComputeRpHash(l.alg, cmd.responseCode, cmd.commandCode, cmd.rpBytes)})
+}
+
+// EnableCommandAuditLog creates a CommandAuditLog that records every audited command executed by this TPMContext, and returns it.
+// The alg argument must be the digest algorithm currently used by the TPM for command auditing. The list of audited commands is
+// obtained from the TPM.
+//
+// As the log can only reconstruct the audit digest from the point at which it was last cleared, the TPM's audit digest should be
+// cleared after enabling the log before the log can be used to check it. This can be done by calling
+// TPMContext.GetCommandAuditDigest with a signing key.
+//
+// If a log is already enabled, it is replaced.
+func (t *TPMContext) EnableCommandAuditLog(alg HashAlgorithmId) (*CommandAuditLog, error) {
+	if !alg.Supported() {
+		return nil, makeInvalidArgError("alg", "unsupported digest algorithm")
+	}
+
+	commands, err := t.GetCapabilityAuditCommands(CommandFirst, CapabilityMaxProperties)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot obtain list of audited commands: %w", err)
+	}
+
+	log := &CommandAuditLog{alg: alg, commands: make(map[CommandCode]struct{})}
+	for _, c := range commands {
+		log.commands[c] = struct{}{}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.commandAuditLog = log
+	return log, nil
+}
+
+// DisableCommandAuditLog stops recording commands in the log created by TPMContext.EnableCommandAuditLog.
+func (t *TPMContext) DisableCommandAuditLog() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.commandAuditLog = nil
+}
+
+// recordCommandAudit records a command that completed successfully in the command audit log, if one is enabled. It must be called
+// before the response parameters are decrypted.
+func (t *TPMContext) recordCommandAudit(cmd *cmdContext) {
+	t.mu.Lock()
+	log := t.commandAuditLog
+	t.mu.Unlock()
+
+	if log == nil {
+		return
+	}
+	log.record(cmd)
+}
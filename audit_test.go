@@ -0,0 +1,272 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+type auditSuite struct {
+	testutil.TPMTest
+}
+
+var _ = Suite(&auditSuite{TPMTest: testutil.TPMTest{
+	TPMFeatures: testutil.TPMFeatureOwnerHierarchy | testutil.TPMFeatureEndorsementHierarchy | testutil.TPMFeatureSetCommandCodeAuditStatus}})
+
+func (s *auditSuite) SetUpTest(c *C) {
+	s.TPMTest.SetUpTest(c)
+	c.Assert(s.TPM.InitProperties(), IsNil)
+}
+
+func (s *auditSuite) startSession(c *C, symmetric *SymDef) SessionContext {
+	session, err := s.TPM.StartAuthSession(nil, nil, SessionTypeHMAC, symmetric, HashAlgorithmSHA256)
+	c.Assert(err, IsNil)
+	s.AddCleanupFlushContext(c, session)
+	return session
+}
+
+func (s *auditSuite) createSigningKey(c *C) ResourceContext {
+	template := Public{
+		Type:    ObjectTypeECC,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrNoDA | AttrSign,
+		Params: &PublicParamsU{
+			ECCDetail: &ECCParams{
+				Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+				Scheme: ECCScheme{
+					Scheme:  ECCSchemeECDSA,
+					Details: &AsymSchemeU{ECDSA: &SigSchemeECDSA{HashAlg: HashAlgorithmSHA256}}},
+				CurveID: ECCCurveNIST_P256,
+				KDF:     KDFScheme{Scheme: KDFAlgorithmNull}}}}
+	key, _, _, _, _, err := s.TPM.CreatePrimary(s.TPM.EndorsementHandleContext(), nil, &template, nil, nil, nil)
+	c.Assert(err, IsNil)
+	s.AddCleanupFlushContext(c, key)
+	return key
+}
+
+func (s *auditSuite) getSessionAuditDigest(c *C, session SessionContext) *Attest {
+	attest, _, err := s.TPM.GetSessionAuditDigest(s.TPM.EndorsementHandleContext(), nil, session, nil, nil, nil, nil)
+	c.Assert(err, IsNil)
+	return attest
+}
+
+func (s *auditSuite) getCommandAuditDigest(c *C, signContext ResourceContext) *Attest {
+	attest, _, err := s.TPM.GetCommandAuditDigest(s.TPM.EndorsementHandleContext(), signContext, nil, nil, nil, nil)
+	c.Assert(err, IsNil)
+	return attest
+}
+
+// enableCommandAuditLog enables the command audit log and clears the TPM's audit digest so that the log can reconstruct it. The
+// TPM's command audit settings are restored at the end of the test.
+func (s *auditSuite) enableCommandAuditLog(c *C) *CommandAuditLog {
+	c.Assert(s.TPM.SetCommandCodeAuditStatus(s.TPM.OwnerHandleContext(), HashAlgorithmSHA256, nil, nil, nil), IsNil)
+	s.AddCleanup(func() {
+		c.Check(s.TPM.SetCommandCodeAuditStatus(s.TPM.OwnerHandleContext(), HashAlgorithmNull, nil, CommandCodeList{CommandGetRandom}, nil), IsNil)
+		c.Check(s.TPM.SetCommandCodeAuditStatus(s.TPM.OwnerHandleContext(), HashAlgorithmSHA256, nil, nil, nil), IsNil)
+	})
+
+	log, err := s.TPM.EnableCommandAuditLog(HashAlgorithmSHA256)
+	c.Assert(err, IsNil)
+
+	key := s.createSigningKey(c)
+	s.getCommandAuditDigest(c, key)
+
+	return log
+}
+
+func (s *auditSuite) TestSessionAuditDigest(c *C) {
+	session := s.startSession(c, nil)
+	c.Check(SessionAuditDigest(session), IsNil)
+
+	for i := 0; i < 2; i++ {
+		_, err := s.TPM.GetRandom(8, session.WithAttrs(AttrContinueSession|AttrAudit))
+		c.Check(err, IsNil)
+	}
+	c.Check(SessionAuditDigest(session), HasLen, 32)
+
+	attest := s.getSessionAuditDigest(c, session)
+	c.Check(attest.Attested.SessionAudit.SessionDigest, DeepEquals, SessionAuditDigest(session))
+	c.Check(CheckSessionAuditDigest(attest, session), IsNil)
+}
+
+func (s *auditSuite) TestSessionAuditDigestReset(c *C) {
+	session := s.startSession(c, nil)
+
+	_, err := s.TPM.GetRandom(8, session.WithAttrs(AttrContinueSession|AttrAudit))
+	c.Check(err, IsNil)
+	digest := SessionAuditDigest(session)
+
+	_, err = s.TPM.GetRandom(8, session.WithAttrs(AttrContinueSession|AttrAuditReset))
+	c.Check(err, IsNil)
+	c.Check(SessionAuditDigest(session), Not(DeepEquals), digest)
+
+	c.Check(CheckSessionAuditDigest(s.getSessionAuditDigest(c, session), session), IsNil)
+}
+
+func (s *auditSuite) TestSessionAuditDigestWithParameterEncryption(c *C) {
+	session := s.startSession(c, &SymDef{
+		Algorithm: SymAlgorithmAES,
+		KeyBits:   &SymKeyBitsU{Sym: 128},
+		Mode:      &SymModeU{Sym: SymModeCFB}})
+
+	_, err := s.TPM.GetRandom(8, session.WithAttrs(AttrContinueSession|AttrAudit|AttrResponseEncrypt))
+	c.Check(err, IsNil)
+
+	c.Check(CheckSessionAuditDigest(s.getSessionAuditDigest(c, session), session), IsNil)
+}
+
+func (s *auditSuite) TestSessionAuditDigestExported(c *C) {
+	session := s.startSession(c, nil)
+	_, err := s.TPM.GetRandom(8, session.WithAttrs(AttrContinueSession|AttrAudit))
+	c.Check(err, IsNil)
+
+	key := make([]byte, 32)
+	data, err := s.TPM.ExportSessionContext(session, key)
+	c.Assert(err, IsNil)
+	session, err = s.TPM.ImportSessionContext(data, key)
+	c.Assert(err, IsNil)
+
+	_, err = s.TPM.GetRandom(8, session.WithAttrs(AttrContinueSession|AttrAudit))
+	c.Check(err, IsNil)
+
+	c.Check(CheckSessionAuditDigest(s.getSessionAuditDigest(c, session), session), IsNil)
+}
+
+func (s *auditSuite) TestSessionAuditDigestNotSerialized(c *C) {
+	session := s.startSession(c, nil)
+	_, err := s.TPM.GetRandom(8, session.WithAttrs(AttrContinueSession|AttrAudit))
+	c.Check(err, IsNil)
+	c.Check(SessionAuditDigest(session), HasLen, 32)
+
+	// The serialized session must retain the original layout, so that blobs created by earlier versions can still be loaded.
+	type sessionContextData struct {
+		IsAudit        bool
+		IsExclusive    bool
+		HashAlg        HashAlgorithmId
+		SessionType    SessionType
+		PolicyHMACType uint8
+		IsBound        bool
+		BoundEntity    Name
+		SessionKey     []byte
+		NonceCaller    Nonce
+		NonceTPM       Nonce
+		Symmetric      *SymDef
+	}
+	var data []byte
+	_, err = mu.UnmarshalFromBytes(session.SerializeToBytes(), new(HashAlgorithmId), new([]byte), &data)
+	c.Assert(err, IsNil)
+
+	var contextType uint8
+	var handle Handle
+	var name Name
+	var sessionData sessionContextData
+	n, err := mu.UnmarshalFromBytes(data, &contextType, &handle, &name, &sessionData)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, len(data))
+	c.Check(handle, Equals, session.Handle())
+
+	restored, _, err := CreateHandleContextFromBytes(session.SerializeToBytes())
+	c.Assert(err, IsNil)
+	c.Check(SessionAuditDigest(restored.(SessionContext)), IsNil)
+}
+
+func (s *auditSuite) TestSessionAuditDigestMismatch(c *C) {
+	session1 := s.startSession(c, nil)
+	session2 := s.startSession(c, nil)
+
+	_, err := s.TPM.GetRandom(8, session1.WithAttrs(AttrContinueSession|AttrAudit))
+	c.Check(err, IsNil)
+	_, err = s.TPM.GetRandom(8, session2.WithAttrs(AttrContinueSession|AttrAudit))
+	c.Check(err, IsNil)
+
+	c.Check(CheckSessionAuditDigest(s.getSessionAuditDigest(c, session1), session2), ErrorMatches,
+		"session audit digest does not match the host-side digest")
+}
+
+func (s *auditSuite) TestCheckSessionAuditDigestWrongType(c *C) {
+	session := s.startSession(c, nil)
+	c.Check(CheckSessionAuditDigest(&Attest{Type: TagAttestQuote}, session), ErrorMatches,
+		"attestation structure does not contain session audit information")
+}
+
+func (s *auditSuite) TestCommandAuditLog(c *C) {
+	log := s.enableCommandAuditLog(c)
+
+	c.Check(s.TPM.SetCommandCodeAuditStatus(s.TPM.OwnerHandleContext(), HashAlgorithmNull, CommandCodeList{CommandGetRandom}, nil, nil), IsNil)
+	for i := 0; i < 2; i++ {
+		_, err := s.TPM.GetRandom(8)
+		c.Check(err, IsNil)
+	}
+	_, err := s.TPM.GetCapabilityTPMProperties(PropertyManufacturer, 1)
+	c.Check(err, IsNil)
+
+	var commands []CommandCode
+	for _, e := range log.Entries() {
+		commands = append(commands, e.CommandCode)
+	}
+	c.Check(commands, DeepEquals, []CommandCode{CommandSetCommandCodeAuditStatus, CommandGetRandom, CommandGetRandom})
+
+	attest := s.getCommandAuditDigest(c, nil)
+	c.Check(attest.Attested.CommandAudit.AuditDigest, DeepEquals, log.Digest())
+	c.Check(log.Check(attest), IsNil)
+
+	// Obtaining the digest without a signing key doesn't clear it.
+	c.Check(log.Entries(), HasLen, 3)
+	c.Check(log.Check(s.getCommandAuditDigest(c, nil)), IsNil)
+}
+
+func (s *auditSuite) TestCommandAuditLogClearedWhenReported(c *C) {
+	key := s.createSigningKey(c)
+	log := s.enableCommandAuditLog(c)
+
+	c.Check(s.TPM.SetCommandCodeAuditStatus(s.TPM.OwnerHandleContext(), HashAlgorithmNull, CommandCodeList{CommandGetRandom}, nil, nil), IsNil)
+	_, err := s.TPM.GetRandom(8)
+	c.Check(err, IsNil)
+
+	c.Check(log.Check(s.getCommandAuditDigest(c, key)), IsNil)
+	c.Check(log.Entries(), HasLen, 0)
+	c.Check(log.Digest(), IsNil)
+
+	_, err = s.TPM.GetRandom(8)
+	c.Check(err, IsNil)
+	c.Check(log.Check(s.getCommandAuditDigest(c, key)), IsNil)
+}
+
+func (s *auditSuite) TestCommandAuditLogChangeAlgorithm(c *C) {
+	log := s.enableCommandAuditLog(c)
+
+	c.Check(s.TPM.SetCommandCodeAuditStatus(s.TPM.OwnerHandleContext(), HashAlgorithmSHA1, nil, nil, nil), IsNil)
+	c.Check(log.DigestAlg(), Equals, HashAlgorithmSHA1)
+	c.Check(s.TPM.SetCommandCodeAuditStatus(s.TPM.OwnerHandleContext(), HashAlgorithmNull, CommandCodeList{CommandGetRandom}, nil, nil), IsNil)
+	_, err := s.TPM.GetRandom(8)
+	c.Check(err, IsNil)
+
+	c.Check(log.Check(s.getCommandAuditDigest(c, nil)), IsNil)
+}
+
+func (s *auditSuite) TestCommandAuditLogMismatch(c *C) {
+	log := s.enableCommandAuditLog(c)
+
+	c.Check(s.TPM.SetCommandCodeAuditStatus(s.TPM.OwnerHandleContext(), HashAlgorithmNull, CommandCodeList{CommandGetRandom}, nil, nil), IsNil)
+
+	// Execute an audited command that the log doesn't see.
+	b, err := mu.MarshalToBytes(uint16(8))
+	c.Assert(err, IsNil)
+	_, _, _, err = s.TPM.RunCommandBytes(TagNoSessions, CommandGetRandom, b)
+	c.Assert(err, IsNil)
+
+	c.Check(log.Check(s.getCommandAuditDigest(c, nil)), ErrorMatches, "command audit digest does not match the host-side digest")
+}
+
+func (s *auditSuite) TestCommandAuditLogNotReported(c *C) {
+	log, err := s.TPM.EnableCommandAuditLog(HashAlgorithmSHA256)
+	c.Assert(err, IsNil)
+	c.Check(log.Check(&Attest{Type: TagAttestCommandAudit, Attested: &AttestU{CommandAudit: &CommandAuditInfo{}}}), ErrorMatches,
+		"no command audit digest has been obtained")
+}
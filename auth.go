@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"hash"
 	"io"
+	"sync"
 
 	"github.com/canonical/go-tpm2/mu"
 
@@ -186,6 +187,23 @@ func (s *sessionParam) processResponseAuth(resp authResponse, responseCode Respo
 	return nil
 }
 
+// updateAuditDigest extends the host-side copy of the session audit digest with the cpHash and rpHash of a command, in the same
+// way as the TPM. The digest is initialized to zero the first time that the session is used for auditing, or if the command
+// requested that the digest be reset.
+func (s *sessionParam) updateAuditDigest(commandCode CommandCode, commandHandles []Name, cpBytes []byte, responseCode ResponseCode, rpBytes []byte) {
+	data := s.session.Data()
+	host := s.session.host
+	if len(host.auditDigest) == 0 || s.session.attrs&AttrAuditReset > 0 {
+		host.auditDigest = make(Digest, data.HashAlg.Size())
+	}
+
+	h := data.HashAlg.NewHash()
+	h.Write(host.auditDigest)
+	h.Write(cryptComputeCpHash(data.HashAlg, commandCode, commandHandles, cpBytes))
+	h.Write(cryptComputeRpHash(data.HashAlg, responseCode, commandCode, rpBytes))
+	host.auditDigest = h.Sum(nil)
+}
+
 func computeBindName(name Name, auth Auth) Name {
 	if len(auth) > len(name) {
 		auth = auth[0:len(name)]
@@ -201,8 +219,63 @@ func computeBindName(name Name, auth Auth) Name {
 }
 
 type sessionParams struct {
-	commandCode CommandCode
-	sessions    []*sessionParam
+	commandCode    CommandCode
+	commandHandles []Name
+	cpBytes        []byte // The command parameters as sent to the TPM, after any parameter encryption
+	sessions       []*sessionParam
+	acquired       []*handleContext
+}
+
+var (
+	sessionsInUseMu sync.Mutex
+	sessionsInUse   = make(map[*handleContext]struct{})
+)
+
+// acquireSessions marks the sessions associated with p as being in use by a command. An error is returned if any of the sessions
+// are already in use by a command executing in another goroutine.
+func (p *sessionParams) acquireSessions() error {
+	sessionsInUseMu.Lock()
+	defer sessionsInUseMu.Unlock()
+
+	var acquired []*handleContext
+	for i, s := range p.sessions {
+		if s.session == nil {
+			continue
+		}
+		h := s.session.handleContext
+		if _, inUse := sessionsInUse[h]; inUse {
+			alreadyAcquired := false
+			for _, a := range acquired {
+				if a == h {
+					alreadyAcquired = true
+					break
+				}
+			}
+			if alreadyAcquired {
+				continue
+			}
+			for _, a := range acquired {
+				delete(sessionsInUse, a)
+			}
+			return fmt.Errorf("session at index %d (handle 0x%08x) is already in use by a command executing in another goroutine", i, h.Handle())
+		}
+		sessionsInUse[h] = struct{}{}
+		acquired = append(acquired, h)
+	}
+
+	p.acquired = acquired
+	return nil
+}
+
+// releaseSessions marks the sessions acquired with acquireSessions as no longer being in use.
+func (p *sessionParams) releaseSessions() {
+	sessionsInUseMu.Lock()
+	defer sessionsInUseMu.Unlock()
+
+	for _, h := range p.acquired {
+		delete(sessionsInUse, h)
+	}
+	p.acquired = nil
 }
 
 func (p *sessionParams) findSessionWithAttr(attr SessionAttributes) (*sessionParam, int) {
@@ -306,6 +379,8 @@ func (p *sessionParams) buildCommandAuthArea(commandCode CommandCode, commandHan
 
 	p.computeEncryptNonce()
 	p.commandCode = commandCode
+	p.commandHandles = commandHandles
+	p.cpBytes = cpBytes
 
 	var area commandAuthArea
 	for _, s := range p.sessions {
@@ -329,6 +404,15 @@ func (p *sessionParams) invalidateSessionContexts(authResponses []authResponse)
 	}
 }
 
+func (p *sessionParams) invalidateAllSessionContexts() {
+	for _, s := range p.sessions {
+		if s.session == nil {
+			continue
+		}
+		s.session.invalidate()
+	}
+}
+
 func (p *sessionParams) processResponseAuthArea(authResponses []authResponse, responseCode ResponseCode, rpBytes []byte) error {
 	defer p.invalidateSessionContexts(authResponses)
 
@@ -336,6 +420,9 @@ func (p *sessionParams) processResponseAuthArea(authResponses []authResponse, re
 		if err := p.sessions[i].processResponseAuth(resp, responseCode, p.commandCode, rpBytes); err != nil {
 			return fmt.Errorf("encountered an error for session at index %d: %v", i, err)
 		}
+		if resp.SessionAttrs&attrAudit > 0 {
+			p.sessions[i].updateAuditDigest(p.commandCode, p.commandHandles, p.cpBytes, responseCode, rpBytes)
+		}
 	}
 
 	if err := p.decryptResponseParameter(rpBytes); err != nil {
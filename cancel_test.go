@@ -0,0 +1,267 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+type testContextKey struct{}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "timeout" }
+func (timeoutError) Timeout() bool { return true }
+
+// blockingTcti is a TCTI where reads block until the test supplies a response.
+type blockingTcti struct {
+	commands  chan []byte
+	responses chan []byte
+	expired   chan struct{}
+	buf       *bytes.Reader
+}
+
+func newBlockingTcti() *blockingTcti {
+	return &blockingTcti{
+		commands:  make(chan []byte, 10),
+		responses: make(chan []byte, 10),
+		expired:   make(chan struct{}, 1)}
+}
+
+func (t *blockingTcti) Read(data []byte) (int, error) {
+	if t.buf == nil || t.buf.Len() == 0 {
+		select {
+		case rsp := <-t.responses:
+			t.buf = bytes.NewReader(rsp)
+		case <-t.expired:
+			return 0, timeoutError{}
+		}
+	}
+	return t.buf.Read(data)
+}
+
+func (t *blockingTcti) Write(data []byte) (int, error) {
+	t.commands <- append([]byte(nil), data...)
+	return len(data), nil
+}
+
+func (t *blockingTcti) Close() error {
+	return nil
+}
+
+func (t *blockingTcti) SetLocality(locality uint8) error {
+	return errors.New("not implemented")
+}
+
+func (t *blockingTcti) MakeSticky(handle Handle, sticky bool) error {
+	return errors.New("not implemented")
+}
+
+type cancelableTcti struct {
+	*blockingTcti
+	cancelled chan struct{}
+}
+
+func (t *cancelableTcti) Cancel() error {
+	close(t.cancelled)
+	return nil
+}
+
+type deadlineTcti struct {
+	*blockingTcti
+}
+
+func (t *deadlineTcti) SetReadDeadline(deadline time.Time) error {
+	if deadline.IsZero() {
+		select {
+		case <-t.expired:
+		default:
+		}
+		return nil
+	}
+	select {
+	case t.expired <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+type cancelSuite struct {
+	testutil.BaseTest
+}
+
+var _ = Suite(&cancelSuite{})
+
+func (s *cancelSuite) clearResponse(c *C) []byte {
+	return makeScriptedPacket(c, TagSessions, uint32(Success), uint32(0), uint16(0), uint8(1), uint16(0))
+}
+
+func (s *cancelSuite) TestContext(c *C) {
+	tpm, _ := NewTPMContext(newBlockingTcti())
+	c.Check(tpm.Context(), Equals, context.Background())
+
+	ctx := context.WithValue(context.Background(), testContextKey{}, "bar")
+	tpm2 := tpm.WithContext(ctx)
+	c.Check(tpm2.Context(), Equals, ctx)
+	c.Check(tpm2.OwnerHandleContext(), Equals, tpm.OwnerHandleContext())
+}
+
+func (s *cancelSuite) TestAlreadyCanceled(c *C) {
+	tcti := newBlockingTcti()
+	tpm, _ := NewTPMContext(tcti)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := tpm.WithContext(ctx).Clear(tpm.LockoutHandleContext(), nil)
+	c.Check(err, ErrorMatches, "command TPM_CC_Clear was canceled: context canceled")
+	c.Check(errors.Is(err, context.Canceled), testutil.IsTrue)
+	c.Check(tcti.commands, HasLen, 0)
+}
+
+func (s *cancelSuite) TestCancel(c *C) {
+	tcti := &cancelableTcti{blockingTcti: newBlockingTcti(), cancelled: make(chan struct{})}
+	tpm, _ := NewTPMContext(tcti)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-tcti.commands
+		cancel()
+		<-tcti.cancelled
+		tcti.responses <- makeScriptedPacket(c, TagNoSessions, 0x909)
+	}()
+
+	err := tpm.WithContext(ctx).Clear(tpm.LockoutHandleContext(), nil)
+	c.Check(err, ErrorMatches, "command TPM_CC_Clear was canceled: context canceled")
+	c.Assert(err, FitsTypeOf, &CommandCanceledError{})
+	c.Check(err.(*CommandCanceledError).ResponseAbandoned, testutil.IsFalse)
+	c.Check(errors.Is(err, context.Canceled), testutil.IsTrue)
+}
+
+func (s *cancelSuite) TestCancelTooLate(c *C) {
+	tcti := &cancelableTcti{blockingTcti: newBlockingTcti(), cancelled: make(chan struct{})}
+	tpm, _ := NewTPMContext(tcti)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-tcti.commands
+		cancel()
+		<-tcti.cancelled
+		tcti.responses <- s.clearResponse(c)
+	}()
+
+	c.Check(tpm.WithContext(ctx).Clear(tpm.LockoutHandleContext(), nil), IsNil)
+}
+
+func (s *cancelSuite) TestDeadlineAbandonsResponse(c *C) {
+	tcti := &deadlineTcti{blockingTcti: newBlockingTcti()}
+	tpm, _ := NewTPMContext(tcti)
+
+	tcti.responses <- makeScriptedPacket(c, TagNoSessions, uint32(Success), Handle(0x02000000), Nonce(make([]byte, 32)))
+	session, err := tpm.StartAuthSession(nil, nil, SessionTypeHMAC, nil, HashAlgorithmSHA256)
+	c.Assert(err, IsNil)
+	<-tcti.commands
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err = tpm.WithContext(ctx).Clear(tpm.LockoutHandleContext(), session)
+	c.Check(err, ErrorMatches, "command TPM_CC_Clear was abandoned before it completed: context deadline exceeded")
+	c.Assert(err, FitsTypeOf, &CommandCanceledError{})
+	c.Check(err.(*CommandCanceledError).ResponseAbandoned, testutil.IsTrue)
+	c.Check(errors.Is(err, context.DeadlineExceeded), testutil.IsTrue)
+	c.Check(session.Handle(), Equals, HandleUnassigned)
+	<-tcti.commands
+
+	// The abandoned response should be discarded before the next command is submitted.
+	tcti.responses <- makeScriptedPacket(c, TagNoSessions, uint32(ErrorValue))
+	tcti.responses <- s.clearResponse(c)
+	c.Check(tpm.Clear(tpm.LockoutHandleContext(), nil), IsNil)
+	c.Check(tcti.commands, HasLen, 1)
+	c.Check(tcti.responses, HasLen, 0)
+}
+
+func (s *cancelSuite) testDeadlineAbandonsPartialResponse(c *C, split int) {
+	tcti := &deadlineTcti{blockingTcti: newBlockingTcti()}
+	tpm, _ := NewTPMContext(tcti)
+
+	// Supply the first part of the response, so that the deadline fires part way through it.
+	rsp := makeScriptedPacket(c, TagNoSessions, uint32(Success), uint64(1000), uint64(2000), uint32(1), uint32(0), uint8(1))
+	tcti.responses <- rsp[:split]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := tpm.WithContext(ctx).ReadClock()
+	c.Check(err, ErrorMatches, "command TPM_CC_ReadClock was abandoned before it completed: context deadline exceeded")
+	<-tcti.commands
+
+	// Exactly the remainder of the abandoned response should be discarded before the next command is submitted.
+	tcti.responses <- rsp[split:]
+	tcti.responses <- s.clearResponse(c)
+	c.Check(tpm.Clear(tpm.LockoutHandleContext(), nil), IsNil)
+	c.Check(tcti.commands, HasLen, 1)
+	c.Check(tcti.responses, HasLen, 0)
+}
+
+func (s *cancelSuite) TestDeadlineAbandonsResponseMidHeader(c *C) {
+	s.testDeadlineAbandonsPartialResponse(c, 4)
+}
+
+func (s *cancelSuite) TestDeadlineAbandonsResponseMidBody(c *C) {
+	s.testDeadlineAbandonsPartialResponse(c, 16)
+}
+
+func (s *cancelSuite) TestDeadlineForwardedByTestWrappers(c *C) {
+	for _, data := range []struct {
+		desc string
+		wrap func(tcti TCTI) TCTI
+	}{
+		{
+			desc: "FaultInjectionTCTI",
+			wrap: func(tcti TCTI) TCTI { return testutil.NewFaultInjectionTCTI(tcti) },
+		},
+		{
+			desc: "RecordingTCTI",
+			wrap: func(tcti TCTI) TCTI {
+				recorder, err := testutil.NewRecordingTCTI(tcti, filepath.Join(c.MkDir(), "recording"))
+				c.Assert(err, IsNil)
+				return recorder
+			},
+		},
+	} {
+		tcti := &deadlineTcti{blockingTcti: newBlockingTcti()}
+		tpm, _ := NewTPMContext(data.wrap(tcti))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := tpm.WithContext(ctx).Clear(tpm.LockoutHandleContext(), nil)
+		cancel()
+		c.Check(err, ErrorMatches, "command TPM_CC_Clear was abandoned before it completed: context deadline exceeded", Commentf(data.desc))
+	}
+}
+
+func (s *cancelSuite) TestNoCancelOrDeadlineWaitsForResponse(c *C) {
+	tcti := newBlockingTcti()
+	tpm, _ := NewTPMContext(tcti)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-tcti.commands
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+		tcti.responses <- s.clearResponse(c)
+	}()
+
+	c.Check(tpm.WithContext(ctx).Clear(tpm.LockoutHandleContext(), nil), IsNil)
+}
@@ -0,0 +1,102 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+// Section 32 - Attached Components
+
+// ACGetCapability executes the TPM2_AC_GetCapability command, which returns the values of attributes of the attached component (AC)
+// associated with acContext. The acContext parameter should correspond to a handle of type HandleTypeAC, and can be obtained with
+// TPMContext.GetPermanentContext. The capability parameter indicates the first attribute to be returned, and count indicates the
+// number of attributes to be returned. If no attribute of the AC corresponds to capability, then the next attribute is returned.
+//
+// The underlying implementation of TPM2_AC_GetCapability is not required to (or may not be able to) return all of the requested
+// values in a single request. This function will re-execute the TPM2_AC_GetCapability command until all of the requested attributes
+// have been returned. As a consequence, any SessionContext instances provided should have the AttrContinueSession attribute defined.
+//
+// If acContext does not correspond to an AC on this platform, a *TPMHandleError error with an error code of ErrorValue will be
+// returned for handle index 1.
+func (t *TPMContext) ACGetCapability(acContext ResourceContext, capability ACAttribute, count uint32, sessions ...SessionContext) (capabilitiesData ACCapabilityList, err error) {
+	nextCapability := capability
+	remaining := count
+
+	for {
+		var moreData bool
+		var data ACCapabilityList
+
+		if err := t.RunCommand(CommandACGetCapability, sessions,
+			acContext, Delimiter,
+			nextCapability, remaining, Delimiter,
+			Delimiter,
+			&moreData, &data); err != nil {
+			return nil, err
+		}
+
+		capabilitiesData = append(capabilitiesData, data...)
+
+		l := len(data)
+		if l == 0 || !moreData || uint32(l) >= remaining {
+			break
+		}
+
+		nextCapability = data[l-1].Tag + 1
+		remaining -= uint32(l)
+	}
+
+	return capabilitiesData, nil
+}
+
+// ACSend executes the TPM2_AC_Send command in order to send the object associated with sendObjectContext to the attached component
+// (AC) associated with acContext. The acContext parameter should correspond to a handle of type HandleTypeAC, and can be obtained
+// with TPMContext.GetPermanentContext. The acDataIn parameter provides optional additional data, the format of which is specific
+// to the AC.
+//
+// The command requires authorization with the duplication role for sendObjectContext, with session based authorization provided
+// via sendObjectContextAuthSession. In practice, this means that a policy session that includes a TPM2_Policy_AC_SendSelect
+// assertion (see TPMContext.PolicyACSendSelect) must be used.
+//
+// The command also requires authorization with the user auth role for authContext, with session based authorization provided via
+// authContextAuthSession. The authContext parameter should correspond to HandleOwner, HandlePlatform or an NV index. The TPM checks
+// that this authorization is permitted to send objects to the AC.
+//
+// If the object associated with sendObjectContext has the AttrFixedTPM attribute set, a *TPMHandleError error with an error code
+// of ErrorAttributes will be returned for handle index 1. If acContext does not correspond to an AC on this platform, a
+// *TPMHandleError error with an error code of ErrorValue will be returned for handle index 3.
+//
+// On success, the status of the operation is returned as an ACOutput structure. If the Tag field is ACAttributeError, the AC
+// indicated that the operation failed and the Data field will contain an AC specific error code.
+func (t *TPMContext) ACSend(sendObjectContext, authContext, acContext ResourceContext, acDataIn MaxBuffer, sendObjectContextAuthSession, authContextAuthSession SessionContext, sessions ...SessionContext) (acDataOut *ACOutput, err error) {
+	if err := t.RunCommand(CommandACSend, sessions,
+		ResourceContextWithSession{Context: sendObjectContext, Session: sendObjectContextAuthSession},
+		ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}, acContext, Delimiter,
+		acDataIn, Delimiter,
+		Delimiter,
+		&acDataOut); err != nil {
+		return nil, err
+	}
+
+	return acDataOut, nil
+}
+
+// PolicyACSendSelect executes the TPM2_Policy_AC_SendSelect command to allow the policy to be restricted to sending an object to an
+// attached component (AC) with TPMContext.ACSend. The objectName argument corresponds to the name of the object to be sent. The
+// authHandleName argument corresponds to the name of the entity that will authorize the send with the user auth role. The acName
+// argument corresponds to the name of the AC that the object will be sent to. This is a deferred assertion.
+//
+// If the session associated with policySession already has a command parameter digest, name digest or template digest defined, a
+// *TPMError error with an error code of ErrorCpHash will be returned.
+//
+// If the session associated with policySession has already been limited to a specific command code, a *TPMError error with an error
+// code of ErrorCommandCode will be returned.
+//
+// On successful completion, the policy digest of the session context associated with policySession will be extended to include the
+// values of authHandleName, acName and includeObject. If includeObject is true, the policy digest of the session will be extended to
+// also include the value of objectName. A digest of objectName, authHandleName and acName will be recorded as the name hash on the
+// session context to limit usage of the session to those entities, and the CommandACSend command code will be recorded to limit
+// usage of the session to TPMContext.ACSend.
+func (t *TPMContext) PolicyACSendSelect(policySession SessionContext, objectName, authHandleName, acName Name, includeObject bool, sessions ...SessionContext) error {
+	return t.RunCommand(CommandPolicyACSendSelect, sessions,
+		policySession, Delimiter,
+		objectName, authHandleName, acName, includeObject)
+}
@@ -0,0 +1,111 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	_ "crypto/sha256"
+
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+type acSuite struct {
+	testutil.BaseTest
+	tcti *scriptedTcti
+	tpm  *TPMContext
+}
+
+var _ = Suite(&acSuite{})
+
+func (s *acSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	s.tcti = &scriptedTcti{}
+	s.tpm, _ = NewTPMContext(s.tcti)
+}
+
+func (s *acSuite) TestACGetCapability(c *C) {
+	ac := Handle(HandleTypeAC) << 24
+
+	s.tcti.script = []scriptedTctiExchange{
+		{
+			command: makeScriptedPacket(c, TagNoSessions, uint32(CommandACGetCapability), ac, ACAttributeAny, uint32(10)),
+			response: makeScriptedPacket(c, TagNoSessions, uint32(Success), true,
+				ACCapabilityList{{Tag: ACAttributePV1, Data: 1}})},
+		{
+			command: makeScriptedPacket(c, TagNoSessions, uint32(CommandACGetCapability), ac, ACAttributePV1+1, uint32(9)),
+			response: makeScriptedPacket(c, TagNoSessions, uint32(Success), false,
+				ACCapabilityList{{Tag: ACAttributeVendor, Data: 5}})}}
+
+	caps, err := s.tpm.ACGetCapability(s.tpm.GetPermanentContext(ac), ACAttributeAny, 10)
+	c.Check(err, IsNil)
+	c.Check(caps, DeepEquals, ACCapabilityList{{Tag: ACAttributePV1, Data: 1}, {Tag: ACAttributeVendor, Data: 5}})
+	c.Check(s.tcti.script, HasLen, 0)
+}
+
+func (s *acSuite) TestACSend(c *C) {
+	ac := Handle(HandleTypeAC) << 24
+	object := Handle(0x80000001)
+
+	s.tcti.script = []scriptedTctiExchange{
+		{
+			command: makeScriptedPacket(c, TagSessions, uint32(CommandACSend),
+				object, HandleOwner, ac, uint32(21),
+				HandlePW, uint16(0), uint8(1), Auth("foo"),
+				HandlePW, uint16(0), uint8(1), uint16(0),
+				MaxBuffer("bar")),
+			response: makeScriptedPacket(c, TagSessions, uint32(Success), uint32(8),
+				ACOutput{Tag: ACAttributePV1, Data: 2},
+				uint16(0), uint8(1), uint16(0),
+				uint16(0), uint8(1), uint16(0))}}
+
+	pub := Public{
+		Type:    ObjectTypeKeyedHash,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrUserWithAuth,
+		Params:  &PublicParamsU{KeyedHashDetail: &KeyedHashParams{Scheme: KeyedHashScheme{Scheme: KeyedHashSchemeNull}}},
+		Unique:  &PublicIDU{KeyedHash: make(Digest, 32)}}
+	objectContext, err := CreateObjectResourceContextFromPublic(object, &pub)
+	c.Assert(err, IsNil)
+	objectContext.SetAuthValue([]byte("foo"))
+
+	out, err := s.tpm.ACSend(objectContext, s.tpm.OwnerHandleContext(), s.tpm.GetPermanentContext(ac), MaxBuffer("bar"), nil, nil)
+	c.Check(err, IsNil)
+	c.Check(out, DeepEquals, &ACOutput{Tag: ACAttributePV1, Data: 2})
+	c.Check(s.tcti.script, HasLen, 0)
+}
+
+func (s *acSuite) TestPolicyACSendSelect(c *C) {
+	session := Handle(0x03000000)
+	ac := Handle(HandleTypeAC) << 24
+
+	objectName := Name("object")
+	authHandleName := Name("auth")
+	acName := Name(s.tpm.GetPermanentContext(ac).Name())
+
+	s.tcti.script = []scriptedTctiExchange{
+		{
+			command: makeScriptedPacket(c, TagNoSessions, uint32(CommandPolicyACSendSelect),
+				session, objectName, authHandleName, acName, true),
+			response: makeScriptedPacket(c, TagNoSessions, uint32(Success))}}
+
+	c.Check(s.tpm.PolicyACSendSelect(CreateIncompleteSessionContext(session), objectName, authHandleName, acName, true), IsNil)
+	c.Check(s.tcti.script, HasLen, 0)
+}
+
+func (s *acSuite) testTrialPolicyACSendSelect(c *C, includeObject bool, expected Digest) {
+	trial, _ := ComputeAuthPolicy(HashAlgorithmSHA256)
+	trial.PolicyACSendSelect(Name("object"), Name("auth"), Name{0x90, 0x00, 0x00, 0x00}, includeObject)
+	c.Check(trial.GetDigest(), DeepEquals, expected)
+}
+
+func (s *acSuite) TestTrialPolicyACSendSelectIncludeObject(c *C) {
+	s.testTrialPolicyACSendSelect(c, true, testutil.DecodeHexString(c, "3897d606bd8a385c48ea9dd6e422b0b52d5ae72d78cfda27defa63ee8d412665"))
+}
+
+func (s *acSuite) TestTrialPolicyACSendSelectNoIncludeObject(c *C) {
+	s.testTrialPolicyACSendSelect(c, false, testutil.DecodeHexString(c, "3e5e4fb106dc66a76c9cf31d90eada50ed690b56acb7fd5ae3ec974703310b00"))
+}
@@ -249,3 +249,48 @@ func (t *TPMContext) GetTime(privacyAdminContext, signContext ResourceContext, q
 
 	return timeInfoSized.Ptr, signature, nil
 }
+
+// CertifyX509 executes the TPM2_CertifyX509 command, which is used to create a X.509 certificate for the object associated with
+// objectContext, signed by the key associated with signContext. The caller supplies a partial certificate via the
+// partialCertificate parameter, which is a DER encoded SEQUENCE containing the issuer, validity, subject and extensions fields of
+// the certificate, and optionally the signature algorithm identifier before these fields. The TPM adds the remaining fields of
+// the certificate. CreatePartialCertificate can be used to create a partial certificate from a x509.Certificate template.
+//
+// The objectContext parameter corresponds to the object for which to create a certificate. The command requires authorization with
+// the admin role for objectContext, with session based authorization provided via objectContextAuthSession.
+//
+// The command requires authorization with the user auth role for signContext, with session based authorization provided via
+// signContextAuthSession. The reserved parameter is reserved for future use and should be empty.
+//
+// If the object associated with signContext is not a signing key, a *TPMHandleError error with an error code of ErrorKey will be
+// returned for handle index 2.
+//
+// If the scheme of the key associated with signContext is AsymSchemeNull, then inScheme must be provided to specify a valid signing
+// scheme for the key. If it isn't, a *TPMParameterError error with an error code of ErrorScheme will be returned for parameter index
+// 2.
+//
+// If the scheme of the key associated with signContext is not AsymSchemeNull, then inScheme may be nil. If it is provided, then the
+// specified scheme must match that of the signing key, else a *TPMParameterError error with an error code of ErrorScheme will be
+// returned for parameter index 2.
+//
+// If partialCertificate is not correctly formed, or the key usage extension is inconsistent with the attributes of the object
+// associated with objectContext, a *TPMParameterError error with an error code of ErrorValue or ErrorAttributes will be returned for
+// parameter index 3.
+//
+// On success, the DER encoded fields added by the TPM are returned as addedToCertificate, along with the digest of the complete
+// TBSCertificate and the signature. CreateCertificateFromCertifyX509 can be used to assemble these in to a complete certificate.
+func (t *TPMContext) CertifyX509(objectContext, signContext ResourceContext, reserved Data, inScheme *SigScheme, partialCertificate MaxBuffer, objectContextAuthSession, signContextAuthSession SessionContext, sessions ...SessionContext) (addedToCertificate MaxBuffer, tbsDigest Digest, signature *Signature, err error) {
+	if inScheme == nil {
+		inScheme = &SigScheme{Scheme: SigSchemeAlgNull}
+	}
+
+	if err := t.RunCommand(CommandCertifyX509, sessions,
+		ResourceContextWithSession{Context: objectContext, Session: objectContextAuthSession}, ResourceContextWithSession{Context: signContext, Session: signContextAuthSession}, Delimiter,
+		reserved, inScheme, partialCertificate, Delimiter,
+		Delimiter,
+		&addedToCertificate, &tbsDigest, &signature); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return addedToCertificate, tbsDigest, signature, nil
+}
@@ -111,6 +111,12 @@ func (t *TPMContext) GetCapability(capability Capability, property, propertyCoun
 			if l > 0 {
 				p = uint32(data.Data.AuthPolicies[l-1].Handle)
 			}
+		case CapabilityACT:
+			capabilityData.Data.ACTData = append(capabilityData.Data.ACTData, data.Data.ACTData...)
+			l = len(data.Data.ACTData)
+			if l > 0 {
+				p = uint32(data.Data.ACTData[l-1].Handle)
+			}
 		}
 
 		nextProperty += p + 1
@@ -237,6 +243,21 @@ func (t *TPMContext) GetCapabilityAuthPolicies(first Handle, propertyCount uint3
 	return data.Data.AuthPolicies, nil
 }
 
+// GetCapabilityACT is a helper function that wraps around TPMContext.GetCapability, and returns the state of the authenticated
+// countdown timers (ACTs) implemented by the TPM. The first parameter indicates the handle of the first ACT for which to return
+// state. If this ACT isn't implemented, then the state of the next implemented ACT is returned. The propertyCount parameter
+// indicates the number of ACTs for which to return state.
+//
+// If the TPM doesn't support TPM_CAP_ACT, a *TPMParameterError error with an error code of ErrorValue will be returned for
+// parameter index 1.
+func (t *TPMContext) GetCapabilityACT(first Handle, propertyCount uint32, sessions ...SessionContext) (actData ACTList, err error) {
+	data, err := t.GetCapability(CapabilityACT, uint32(first), propertyCount, sessions...)
+	if err != nil {
+		return nil, err
+	}
+	return data.Data.ACTData, nil
+}
+
 // TPMManufacturer corresponds to the TPM manufacturer and is returned when querying the value PropertyManufacturer with
 // TPMContext.GetCapabilityTPMProperties
 type TPMManufacturer uint32
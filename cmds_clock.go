@@ -24,3 +24,22 @@ func (t *TPMContext) ReadClock(sessions ...SessionContext) (currentTime *TimeInf
 
 // func (t *TPMContext) ClockRateAdjust(auth Handle, rateAdjust ClockAdjust, authAuth interface{}) error {
 // }
+
+// ACTSetTimeout executes the TPM2_ACT_SetTimeout command to set the timeout of the authenticated countdown timer (ACT) associated
+// with actContext, which should correspond to one of the handles in the range HandleACT0 to HandleACTF. The ACT will begin
+// counting down from startTimeout seconds, and will signal when it reaches zero. Setting startTimeout to zero whilst the ACT is
+// counting down will cause it to signal immediately. If the ACT has already signaled, setting a non-zero value for startTimeout will
+// clear the signaled state. The current state of ACTs can be obtained with TPMContext.GetCapabilityACT.
+//
+// The command requires authorization with the user auth role for actContext, with session based authorization provided via
+// actContextAuthSession. The authorization value and policy for an ACT are set with TPMContext.HierarchyChangeAuth and
+// TPMContext.SetPrimaryPolicy respectively.
+//
+// If an update to the ACT is already pending, the TPM will respond with TPM_RC_RETRY and the command will be resubmitted
+// automatically. If actContext does not correspond to an ACT implemented by the TPM, a *TPMHandleError error with an error code of
+// ErrorValue will be returned for handle index 1.
+func (t *TPMContext) ACTSetTimeout(actContext ResourceContext, startTimeout uint32, actContextAuthSession SessionContext, sessions ...SessionContext) error {
+	return t.RunCommand(CommandACTSetTimeout, sessions,
+		ResourceContextWithSession{Context: actContext, Session: actContextAuthSession}, Delimiter,
+		startTimeout)
+}
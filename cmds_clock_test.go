@@ -0,0 +1,76 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+type clockSuite struct {
+	testutil.BaseTest
+	tcti *scriptedTcti
+	tpm  *TPMContext
+}
+
+var _ = Suite(&clockSuite{})
+
+func (s *clockSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	s.tcti = &scriptedTcti{}
+	s.tpm, _ = NewTPMContext(s.tcti)
+}
+
+func (s *clockSuite) TestACTSetTimeout(c *C) {
+	s.tcti.script = []scriptedTctiExchange{
+		{
+			command: makeScriptedPacket(c, TagSessions, uint32(CommandACTSetTimeout),
+				HandleACT0+2, uint32(12),
+				HandlePW, uint16(0), uint8(1), Auth("foo"),
+				uint32(60)),
+			response: makeScriptedPacket(c, TagSessions, uint32(Success), uint32(0),
+				uint16(0), uint8(1), uint16(0))}}
+
+	act := s.tpm.GetPermanentContext(HandleACT0 + 2)
+	act.SetAuthValue([]byte("foo"))
+
+	c.Check(s.tpm.ACTSetTimeout(act, 60, nil), IsNil)
+	c.Check(s.tcti.script, HasLen, 0)
+}
+
+func (s *clockSuite) TestACTSetTimeoutRetry(c *C) {
+	command := makeScriptedPacket(c, TagSessions, uint32(CommandACTSetTimeout),
+		HandleACT0, uint32(9),
+		HandlePW, uint16(0), uint8(1), uint16(0),
+		uint32(0))
+
+	s.tcti.script = []scriptedTctiExchange{
+		{
+			command:  command,
+			response: makeScriptedPacket(c, TagNoSessions, 0x922)},
+		{
+			command: command,
+			response: makeScriptedPacket(c, TagSessions, uint32(Success), uint32(0),
+				uint16(0), uint8(1), uint16(0))}}
+
+	c.Check(s.tpm.ACTSetTimeout(s.tpm.GetPermanentContext(HandleACT0), 0, nil), IsNil)
+	c.Check(s.tcti.script, HasLen, 0)
+}
+
+func (s *clockSuite) TestACTSetTimeoutNotImplemented(c *C) {
+	s.tcti.script = []scriptedTctiExchange{
+		{
+			command: makeScriptedPacket(c, TagSessions, uint32(CommandACTSetTimeout),
+				HandleACT0+1, uint32(9),
+				HandlePW, uint16(0), uint8(1), uint16(0),
+				uint32(30)),
+			response: makeScriptedPacket(c, TagNoSessions, 0x184)}}
+
+	err := s.tpm.ACTSetTimeout(s.tpm.GetPermanentContext(HandleACT0+1), 30, nil)
+	c.Check(IsTPMHandleError(err, ErrorValue, CommandACTSetTimeout, 1), testutil.IsTrue)
+	c.Check(s.tcti.script, HasLen, 0)
+}
@@ -56,9 +56,11 @@ func (t *TPMContext) ContextSave(saveContext HandleContext) (context *Context, e
 	switch c := saveContext.(type) {
 	case *sessionContext:
 		c.handleContext.Data.Session = nil
-		if t.exclusiveSession == c {
+		t.mu.Lock()
+		if t.exclusiveSession != nil && t.exclusiveSession.handleContext == c.handleContext {
 			t.exclusiveSession = nil
 		}
+		t.mu.Unlock()
 	}
 
 	return context, nil
@@ -145,6 +147,7 @@ func (t *TPMContext) ContextLoad(context *Context) (loadedContext HandleContext,
 	default:
 		panic("not reached")
 	}
+	t.trackHandleContext(hc)
 	return hc, nil
 }
 
@@ -165,6 +168,7 @@ func (t *TPMContext) FlushContext(flushContext HandleContext) error {
 		return err
 	}
 
+	t.untrackHandle(flushContext.Handle())
 	flushContext.(handleContextPrivate).invalidate()
 	return nil
 }
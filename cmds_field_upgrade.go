@@ -0,0 +1,111 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
+)
+
+// Section 27 - Field Upgrade
+
+// fieldUpgradeDigest is used to unmarshal the TPMT_HA+ response parameters of TPM2_FieldUpgradeData, which may have an
+// algorithm of HashAlgorithmNull and no digest.
+type fieldUpgradeDigest TaggedHash
+
+func (d fieldUpgradeDigest) Marshal(w io.Writer) error {
+	if d.HashAlg != HashAlgorithmNull {
+		return TaggedHash(d).Marshal(w)
+	}
+	if err := binary.Write(w, binary.BigEndian, d.HashAlg); err != nil {
+		return xerrors.Errorf("cannot marshal digest algorithm: %w", err)
+	}
+	return nil
+}
+
+func (d *fieldUpgradeDigest) Unmarshal(r mu.Reader) error {
+	if err := binary.Read(r, binary.BigEndian, &d.HashAlg); err != nil {
+		return xerrors.Errorf("cannot unmarshal digest algorithm: %w", err)
+	}
+	if d.HashAlg == HashAlgorithmNull {
+		d.Digest = nil
+		return nil
+	}
+	if !d.HashAlg.Supported() {
+		return fmt.Errorf("cannot determine digest size for unknown algorithm %v", d.HashAlg)
+	}
+
+	d.Digest = make(Digest, d.HashAlg.Size())
+	if _, err := io.ReadFull(r, d.Digest); err != nil {
+		return xerrors.Errorf("cannot read digest: %w", err)
+	}
+	return nil
+}
+
+// FieldUpgradeStart executes the TPM2_FieldUpgradeStart command to begin a field upgrade of the TPM firmware. The fuDigest
+// parameter is the digest of the first block of the firmware image, and manifestSignature is a signature of fuDigest created by
+// the key associated with keyContext. The key associated with keyContext is normally a public key that is loaded with
+// TPMContext.LoadExternal, and its name must correspond to a value approved by the TPM vendor.
+//
+// The command requires knowledge of the authorization value for the platform hierarchy. The authContext parameter should
+// correspond to HandlePlatform, and the command requires authorization with the user auth role for authContext, with session based
+// authorization provided via authContextAuthSession.
+//
+// If the signature is not valid, a *TPMParameterError error with an error code of ErrorSignature will be returned for parameter
+// index 2. If the key associated with keyContext is not approved by the vendor for field upgrades, a *TPMHandleError error with an
+// error code of ErrorValue may be returned for handle index 2.
+//
+// On success, the TPM will be in field upgrade mode and the firmware image should be sent to the TPM one block at a time with
+// TPMContext.FieldUpgradeData. The format of the image and the behaviour of the TPM whilst it is in field upgrade mode is
+// vendor specific, although the TPM will generally respond to all commands other than TPM2_FieldUpgradeData with a *TPMError
+// error with an error code of ErrorUpgrade. FirmwareUpdater provides a way to perform the complete sequence.
+func (t *TPMContext) FieldUpgradeStart(authContext, keyContext ResourceContext, fuDigest Digest, manifestSignature *Signature, authContextAuthSession SessionContext, sessions ...SessionContext) error {
+	return t.RunCommand(CommandFieldUpgradeStart, sessions,
+		ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}, keyContext, Delimiter,
+		fuDigest, manifestSignature)
+}
+
+// FieldUpgradeData executes the TPM2_FieldUpgradeData command to send the next block of a firmware image to the TPM during a
+// field upgrade started with TPMContext.FieldUpgradeStart. The digest of fuData must match the digest that the TPM is expecting,
+// which is the digest supplied to TPMContext.FieldUpgradeStart for the first block and the nextDigest value returned from the
+// previous call to this function for subsequent blocks. If it doesn't, a *TPMParameterError error with an error code of ErrorValue
+// will be returned for parameter index 1.
+//
+// On success, the digest of the next block expected by the TPM is returned as nextDigest, and the digest of the first block of the
+// image is returned as firstDigest. When the TPM has received the last block of the image, the returned nextDigest will have a
+// HashAlg of HashAlgorithmNull and no digest.
+func (t *TPMContext) FieldUpgradeData(fuData MaxBuffer, sessions ...SessionContext) (nextDigest, firstDigest *TaggedHash, err error) {
+	var next, first fieldUpgradeDigest
+	if err := t.RunCommand(CommandFieldUpgradeData, sessions,
+		Delimiter,
+		fuData, Delimiter,
+		Delimiter,
+		&next, &first); err != nil {
+		return nil, nil, err
+	}
+
+	return (*TaggedHash)(&next), (*TaggedHash)(&first), nil
+}
+
+// FirmwareRead executes the TPM2_FirmwareRead command to read a block of the current firmware image from the TPM. The first block
+// is read with a sequenceNumber of zero and subsequent blocks should be read by incrementing sequenceNumber until the TPM
+// returns an empty block. The format of the returned data is vendor specific. If sequenceNumber is out of range, a
+// *TPMParameterError error with an error code of ErrorValue will be returned for parameter index 1.
+func (t *TPMContext) FirmwareRead(sequenceNumber uint32, sessions ...SessionContext) (fuData MaxBuffer, err error) {
+	if err := t.RunCommand(CommandFirmwareRead, sessions,
+		Delimiter,
+		sequenceNumber, Delimiter,
+		Delimiter,
+		&fuData); err != nil {
+		return nil, err
+	}
+
+	return fuData, nil
+}
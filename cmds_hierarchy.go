@@ -134,6 +134,7 @@ func (t *TPMContext) CreatePrimary(primaryObject ResourceContext, inSensitive *S
 	rc := makeObjectContext(objectHandle, name, public)
 	rc.authValue = make([]byte, len(inSensitive.UserAuth))
 	copy(rc.authValue, inSensitive.UserAuth)
+	t.trackHandleContext(rc)
 
 	return rc, outPublicSized.Ptr, creationDataSized.Ptr, creationHash, creationTicket, nil
 }
@@ -185,6 +186,8 @@ func (t *TPMContext) Clear(authContext ResourceContext, authContextAuthSession S
 			// Clear auth values for the owner, endorsement and lockout hierarchies. If the supplied session is not
 			// bound to authContext, the TPM will response with a HMAC generated with a key derived from the empty
 			// auth value.
+			t.mu.Lock()
+			defer t.mu.Unlock()
 			for _, h := range []Handle{HandleOwner, HandleEndorsement, HandleLockout} {
 				if rc, exists := t.permanentResources[h]; exists {
 					rc.SetAuthValue(nil)
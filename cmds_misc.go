@@ -0,0 +1,24 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+// Section 26 - Miscellaneous Management Functions
+
+// SetAlgorithmSet executes the TPM2_SetAlgorithmSet command to change the set of algorithms available on the TPM. The meaning of
+// the algorithmSet parameter is defined by the TPM vendor. The new setting does not take effect until after the next TPM2_Clear,
+// and will also be applied to the algorithms reported by TPM2_GetCapability at this point.
+//
+// The command requires knowledge of the authorization value for the platform hierarchy. The platformContext parameter should
+// correspond to HandlePlatform, and the command requires authorization with the user auth role for platformContext, with session
+// based authorization provided via platformContextAuthSession.
+//
+// If the TPM does not support the requested algorithm set, a *TPMParameterError error with an error code of ErrorValue will be
+// returned for parameter index 1. If the TPM does not implement this command, a *TPMError error with an error code of
+// ErrorCommandCode will be returned.
+func (t *TPMContext) SetAlgorithmSet(platformContext ResourceContext, algorithmSet uint32, platformContextAuthSession SessionContext, sessions ...SessionContext) error {
+	return t.RunCommand(CommandSetAlgorithmSet, sessions,
+		ResourceContextWithSession{Context: platformContext, Session: platformContextAuthSession}, Delimiter,
+		algorithmSet)
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+type miscSuite struct {
+	testutil.BaseTest
+	tcti *scriptedTcti
+	tpm  *TPMContext
+}
+
+var _ = Suite(&miscSuite{})
+
+func (s *miscSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	s.tcti = &scriptedTcti{}
+	s.tpm, _ = NewTPMContext(s.tcti)
+}
+
+func (s *miscSuite) TestSetAlgorithmSet(c *C) {
+	s.tcti.script = []scriptedTctiExchange{
+		{
+			command: makeScriptedPacket(c, TagSessions, uint32(CommandSetAlgorithmSet),
+				HandlePlatform, uint32(12),
+				HandlePW, uint16(0), uint8(1), Auth("foo"),
+				uint32(5)),
+			response: makeScriptedPacket(c, TagSessions, uint32(Success), uint32(0),
+				uint16(0), uint8(1), uint16(0))}}
+
+	platform := s.tpm.PlatformHandleContext()
+	platform.SetAuthValue([]byte("foo"))
+
+	c.Check(s.tpm.SetAlgorithmSet(platform, 5, nil), IsNil)
+	c.Check(s.tcti.script, HasLen, 0)
+}
+
+func (s *miscSuite) TestSetAlgorithmSetUnsupported(c *C) {
+	s.tcti.script = []scriptedTctiExchange{
+		{
+			command: makeScriptedPacket(c, TagSessions, uint32(CommandSetAlgorithmSet),
+				HandlePlatform, uint32(9),
+				HandlePW, uint16(0), uint8(1), uint16(0),
+				uint32(0xffffffff)),
+			response: makeScriptedPacket(c, TagNoSessions, 0x1c4)}}
+
+	err := s.tpm.SetAlgorithmSet(s.tpm.PlatformHandleContext(), 0xffffffff, nil)
+	c.Check(err, ErrorMatches, "TPM returned an error for parameter 1 whilst executing command TPM_CC_SetAlgorithmSet: TPM_RC_VALUE .*")
+	c.Check(IsTPMParameterError(err, ErrorValue, CommandSetAlgorithmSet, 1), testutil.IsTrue)
+	c.Check(s.tcti.script, HasLen, 0)
+}
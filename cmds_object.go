@@ -210,7 +210,9 @@ func (t *TPMContext) Load(parentContext ResourceContext, inPrivate Private, inPu
 	}
 
 	public, _ := inPublic.copy() // inPublic already marshalled successfully, so ignore errors here
-	return makeObjectContext(objectHandle, name, public), nil
+	rc := makeObjectContext(objectHandle, name, public)
+	t.trackHandleContext(rc)
+	return rc, nil
 }
 
 // LoadExternal executes the TPM2_LoadExternal command in order to load an object that is not a protected object in to the TPM.
@@ -300,6 +302,7 @@ func (t *TPMContext) LoadExternal(inPrivate *Sensitive, inPublic *Public, hierar
 		rc.authValue = make([]byte, len(inPrivate.AuthValue))
 		copy(rc.authValue, inPrivate.AuthValue)
 	}
+	t.trackHandleContext(rc)
 	return rc, nil
 }
 
@@ -601,6 +604,7 @@ func (t *TPMContext) CreateLoaded(parentContext ResourceContext, inSensitive *Se
 	rc := makeObjectContext(objectHandle, name, public)
 	rc.authValue = make([]byte, len(inSensitive.UserAuth))
 	copy(rc.authValue, inSensitive.UserAuth)
+	t.trackHandleContext(rc)
 
 	return rc, outPrivate, outPublicSized.Ptr, nil
 }
@@ -10,7 +10,8 @@ package tpm2
 // random number generator. If the requested bytes cannot be read in a single command, this function will reexecute
 // the TPM2_GetRandom command until all requested bytes have been read.
 func (t *TPMContext) GetRandom(bytesRequested uint16, sessions ...SessionContext) (randomBytes []byte, err error) {
-	if err := t.initPropertiesIfNeeded(); err != nil {
+	props, err := t.initPropertiesIfNeeded()
+	if err != nil {
 		return nil, err
 	}
 
@@ -21,8 +22,8 @@ func (t *TPMContext) GetRandom(bytesRequested uint16, sessions ...SessionContext
 
 	for {
 		sz := remaining
-		if sz > uint16(t.maxDigestSize) {
-			sz = uint16(t.maxDigestSize)
+		if sz > uint16(props.maxDigestSize) {
+			sz = uint16(props.maxDigestSize)
 		}
 
 		var tmpBytes Digest
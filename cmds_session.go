@@ -146,7 +146,9 @@ func (t *TPMContext) StartAuthSession(tpmKey, bind ResourceContext, sessionType
 		data.SessionKey = internal.KDFa(authHash.GetHash(), key, []byte("ATH"), []byte(nonceTPM), nonceCaller, digestSize*8)
 	}
 
-	return makeSessionContext(sessionHandle, data), nil
+	sc := makeSessionContext(sessionHandle, data)
+	t.trackHandleContext(sc)
+	return sc, nil
 }
 
 // PolicyRestart executes the TPM2_PolicyRestart command on the policy session associated with sessionContext, to reset the policy
@@ -0,0 +1,23 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+// Section 33 - Vendor Specific
+
+// VendorTCGTest executes the TPM2_Vendor_TCG_Test command, which is a placeholder vendor-specific command defined by the TCG
+// for testing purposes. The TPM returns the data supplied via testDataIn.
+//
+// Other vendor-specific commands can be executed with TPMContext.RunCommand after registering them with RegisterVendorCommand.
+func (t *TPMContext) VendorTCGTest(testDataIn Data, sessions ...SessionContext) (testDataOut Data, err error) {
+	if err := t.RunCommand(CommandVendorTCGTest, sessions,
+		Delimiter,
+		testDataIn, Delimiter,
+		Delimiter,
+		&testDataOut); err != nil {
+		return nil, err
+	}
+
+	return testDataOut, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+// serialTcti is a TCTI that responds to every command with the same response, and records an error if a command is submitted
+// before the response to the previous command has been fully read.
+type serialTcti struct {
+	mu       sync.Mutex
+	response []byte
+	buf      *bytes.Reader
+	commands int
+	err      error
+}
+
+func (t *serialTcti) Read(data []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.buf == nil {
+		return 0, errors.New("no command submitted")
+	}
+	n, err := t.buf.Read(data)
+	if t.buf.Len() == 0 {
+		t.buf = nil
+	}
+	return n, err
+}
+
+func (t *serialTcti) Write(data []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.buf != nil && t.err == nil {
+		t.err = errors.New("command submitted before the previous response was read")
+	}
+	t.commands++
+	t.buf = bytes.NewReader(t.response)
+	return len(data), nil
+}
+
+func (t *serialTcti) Close() error {
+	return nil
+}
+
+func (t *serialTcti) SetLocality(locality uint8) error {
+	return errors.New("not implemented")
+}
+
+func (t *serialTcti) MakeSticky(handle Handle, sticky bool) error {
+	return errors.New("not implemented")
+}
+
+type concurrencySuite struct {
+	testutil.BaseTest
+}
+
+var _ = Suite(&concurrencySuite{})
+
+func (s *concurrencySuite) TestConcurrentCommands(c *C) {
+	tcti := &serialTcti{response: makeScriptedPacket(c, TagSessions, uint32(Success), uint32(0), uint16(0), uint8(1), uint16(0))}
+	tpm, _ := NewTPMContext(tcti)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				c.Check(tpm.Clear(tpm.GetPermanentContext(HandleLockout), nil), IsNil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	c.Check(tcti.err, IsNil)
+	c.Check(tcti.commands, Equals, 200)
+}
+
+func (s *concurrencySuite) TestConcurrentSessionUse(c *C) {
+	tcti := newBlockingTcti()
+	tpm, _ := NewTPMContext(tcti)
+
+	tcti.responses <- makeScriptedPacket(c, TagNoSessions, uint32(Success), Handle(0x02000000), Nonce(make([]byte, 32)))
+	session, err := tpm.StartAuthSession(nil, nil, SessionTypeHMAC, nil, HashAlgorithmSHA256)
+	c.Assert(err, IsNil)
+	<-tcti.commands
+
+	done := make(chan error)
+	go func() {
+		done <- tpm.Clear(tpm.LockoutHandleContext(), session)
+	}()
+	<-tcti.commands
+
+	// The first command is now waiting for a response.
+	err = tpm.WithContext(tpm.Context()).Clear(tpm.LockoutHandleContext(), session.IncludeAttrs(AttrContinueSession))
+	c.Check(err, ErrorMatches, "cannot use SessionContext parameters for command TPM_CC_Clear: session at index 0 \\(handle 0x02000000\\) "+
+		"is already in use by a command executing in another goroutine")
+
+	tcti.responses <- makeScriptedPacket(c, TagNoSessions, uint32(ErrorValue))
+	c.Check(<-done, ErrorMatches, ".*TPM_RC_VALUE.*")
+	c.Check(tcti.commands, HasLen, 0)
+}
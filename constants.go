@@ -91,6 +91,7 @@ const (
 	CommandNVDefineSpace              CommandCode = 0x0000012A // TPM_CC_NV_DefineSpace
 	CommandPCRAllocate                CommandCode = 0x0000012B // TPM_CC_PCR_Allocate
 	CommandSetPrimaryPolicy           CommandCode = 0x0000012E // TPM_CC_SetPrimaryPolicy
+	CommandFieldUpgradeStart          CommandCode = 0x0000012F // TPM_CC_FieldUpgradeStart
 	CommandClockRateAdjust            CommandCode = 0x00000130 // TPM_CC_ClockRateAdjust
 	CommandCreatePrimary              CommandCode = 0x00000131 // TPM_CC_CreatePrimary
 	CommandNVGlobalWriteLock          CommandCode = 0x00000132 // TPM_CC_NV_GlobalWriteLock
@@ -106,7 +107,9 @@ const (
 	CommandPCREvent                   CommandCode = 0x0000013C // TPM_CC_PCR_Event
 	CommandPCRReset                   CommandCode = 0x0000013D // TPM_CC_PCR_Reset
 	CommandSequenceComplete           CommandCode = 0x0000013E // TPM_CC_SequenceComplete
+	CommandSetAlgorithmSet            CommandCode = 0x0000013F // TPM_CC_SetAlgorithmSet
 	CommandSetCommandCodeAuditStatus  CommandCode = 0x00000140 // TPM_CC_SetCommandCodeAuditStatus
+	CommandFieldUpgradeData           CommandCode = 0x00000141 // TPM_CC_FieldUpgradeData
 	CommandIncrementalSelfTest        CommandCode = 0x00000142 // TPM_CC_IncrementalSelfTest
 	CommandSelfTest                   CommandCode = 0x00000143 // TPM_CC_SelfTest
 	CommandStartup                    CommandCode = 0x00000144 // TPM_CC_Startup
@@ -156,6 +159,7 @@ const (
 	CommandStartAuthSession           CommandCode = 0x00000176 // TPM_CC_StartAuthSession
 	CommandVerifySignature            CommandCode = 0x00000177 // TPM_CC_VerifySignature
 	CommandECCParameters              CommandCode = 0x00000178 // TPM_CC_ECC_Parameters
+	CommandFirmwareRead               CommandCode = 0x00000179 // TPM_CC_FirmwareRead
 	CommandGetCapability              CommandCode = 0x0000017A // TPM_CC_GetCapability
 	CommandGetRandom                  CommandCode = 0x0000017B // TPM_CC_GetRandom
 	CommandGetTestResult              CommandCode = 0x0000017C // TPM_CC_GetTestResult
@@ -173,10 +177,18 @@ const (
 	CommandTestParms                  CommandCode = 0x0000018A // TPM_CC_TestParms
 	CommandCommit                     CommandCode = 0x0000018B // TPM_CC_Commit
 	CommandPolicyPassword             CommandCode = 0x0000018C // TPM_CC_PolicyPassword
+	CommandZGen2Phase                 CommandCode = 0x0000018D // TPM_CC_ZGen_2Phase
 	CommandPolicyNvWritten            CommandCode = 0x0000018F // TPM_CC_PolicyNvWritten
 	CommandPolicyTemplate             CommandCode = 0x00000190 // TPM_CC_PolicyTemplate
 	CommandCreateLoaded               CommandCode = 0x00000191 // TPM_CC_CreateLoaded
 	CommandPolicyAuthorizeNV          CommandCode = 0x00000192 // TPM_CC_PolicyAuthorizeNV
+	CommandACGetCapability            CommandCode = 0x00000194 // TPM_CC_AC_GetCapability
+	CommandACSend                     CommandCode = 0x00000195 // TPM_CC_AC_Send
+	CommandPolicyACSendSelect         CommandCode = 0x00000196 // TPM_CC_Policy_AC_SendSelect
+	CommandCertifyX509                CommandCode = 0x00000197 // TPM_CC_CertifyX509
+	CommandACTSetTimeout              CommandCode = 0x00000198 // TPM_CC_ACT_SetTimeout
+
+	CommandVendorTCGTest CommandCode = 0x20000000 // TPM_CC_Vendor_TCG_Test
 )
 
 const (
@@ -458,6 +470,8 @@ const (
 	HandleEndorsement Handle = 0x4000000b // TPM_RH_ENDORSEMENT
 	HandlePlatform    Handle = 0x4000000c // TPM_RH_PLATFORM
 	HandlePlatformNV  Handle = 0x4000000d // TPM_RH_PLATFORM_NV
+	HandleACT0        Handle = 0x40000110 // TPM_RH_ACT_0
+	HandleACTF        Handle = 0x4000011f // TPM_RH_ACT_F
 )
 
 const (
@@ -470,6 +484,7 @@ const (
 	HandleTypePermanent     HandleType = 0x40 // TPM_HT_PERMANENT
 	HandleTypeTransient     HandleType = 0x80 // TPM_HT_TRANSIENT
 	HandleTypePersistent    HandleType = 0x81 // TPM_HT_PERSISTENT
+	HandleTypeAC            HandleType = 0x90 // TPM_HT_AC
 )
 
 const (
@@ -673,16 +688,24 @@ const (
 )
 
 const (
-	CapabilityAlgs          Capability = 0 // TPM_CAP_ALGS
-	CapabilityHandles       Capability = 1 // TPM_CAP_HANDLES
-	CapabilityCommands      Capability = 2 // TPM_CAP_COMMANDS
-	CapabilityPPCommands    Capability = 3 // TPM_CAP_PP_COMMANDS
-	CapabilityAuditCommands Capability = 4 // TPM_CAP_AUDIT_COMMANDS
-	CapabilityPCRs          Capability = 5 // TPM_CAP_PCRS
-	CapabilityTPMProperties Capability = 6 // TPM_CAP_TPM_PROPERTIES
-	CapabilityPCRProperties Capability = 7 // TPM_CAP_PCR_PROPERTIES
-	CapabilityECCCurves     Capability = 8 // TPM_CAP_ECC_CURVES
-	CapabilityAuthPolicies  Capability = 9 // TPM_CAP_AUTH_POLICIES
+	CapabilityAlgs          Capability = 0  // TPM_CAP_ALGS
+	CapabilityHandles       Capability = 1  // TPM_CAP_HANDLES
+	CapabilityCommands      Capability = 2  // TPM_CAP_COMMANDS
+	CapabilityPPCommands    Capability = 3  // TPM_CAP_PP_COMMANDS
+	CapabilityAuditCommands Capability = 4  // TPM_CAP_AUDIT_COMMANDS
+	CapabilityPCRs          Capability = 5  // TPM_CAP_PCRS
+	CapabilityTPMProperties Capability = 6  // TPM_CAP_TPM_PROPERTIES
+	CapabilityPCRProperties Capability = 7  // TPM_CAP_PCR_PROPERTIES
+	CapabilityECCCurves     Capability = 8  // TPM_CAP_ECC_CURVES
+	CapabilityAuthPolicies  Capability = 9  // TPM_CAP_AUTH_POLICIES
+	CapabilityACT           Capability = 10 // TPM_CAP_ACT
+)
+
+const (
+	ACAttributeAny    ACAttribute = 0x00000000 // TPM_AT_ANY
+	ACAttributeError  ACAttribute = 0x00000001 // TPM_AT_ERROR
+	ACAttributePV1    ACAttribute = 0x00000002 // TPM_AT_PV1
+	ACAttributeVendor ACAttribute = 0x80000000 // TPM_AT_VEND
 )
 
 const (
@@ -812,6 +835,11 @@ const (
 	AttrV         CommandAttributes = 1 << 29
 )
 
+const (
+	AttrACTSignaled         ACTAttributes = 1 << 0 // signaled
+	AttrACTPreserveSignaled ACTAttributes = 1 << 1 // preserveSignaled
+)
+
 const (
 	ECCCurveNIST_P192 ECCCurve = 0x0001 // TPM_ECC_NIST_P192
 	ECCCurveNIST_P224 ECCCurve = 0x0002 // TPM_ECC_NIST_P224
@@ -10,11 +10,11 @@ import (
 	"crypto/cipher"
 	"crypto/ecdsa"
 	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/rsa"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"math/big"
 
 	"github.com/canonical/go-tpm2/internal"
@@ -87,7 +87,7 @@ func cryptComputeRpHash(hashAlg HashAlgorithmId, responseCode ResponseCode, comm
 }
 
 func cryptComputeNonce(nonce []byte) error {
-	_, err := rand.Read(nonce)
+	_, err := io.ReadFull(randomSource{}, nonce)
 	return err
 }
 
@@ -142,7 +142,7 @@ func cryptSecretDecrypt(priv crypto.PrivateKey, hashAlg HashAlgorithmId, label [
 		h := hashAlg.NewHash()
 		label0 := make([]byte, len(label)+1)
 		copy(label0, label)
-		return rsa.DecryptOAEP(h, rand.Reader, p, secret, label0)
+		return rsa.DecryptOAEP(h, randomSource{}, p, secret, label0)
 	case *ecdsa.PrivateKey:
 		var ephPoint ECCPoint
 		if _, err := mu.UnmarshalFromBytes(secret, &ephPoint); err != nil {
@@ -181,14 +181,14 @@ func cryptSecretEncrypt(public *Public, label []byte) (EncryptedSecret, []byte,
 		pub := public.Public().(*rsa.PublicKey)
 
 		secret := make([]byte, digestSize)
-		if _, err := rand.Read(secret); err != nil {
+		if _, err := io.ReadFull(randomSource{}, secret); err != nil {
 			return nil, nil, fmt.Errorf("cannot read random bytes for secret: %v", err)
 		}
 
 		h := public.NameAlg.NewHash()
 		label0 := make([]byte, len(label)+1)
 		copy(label0, label)
-		encryptedSecret, err := rsa.EncryptOAEP(h, rand.Reader, pub, secret, label0)
+		encryptedSecret, err := rsa.EncryptOAEP(h, randomSource{}, pub, secret, label0)
 		return encryptedSecret, secret, err
 	case ObjectTypeECC:
 		pub := public.Public().(*ecdsa.PublicKey)
@@ -199,7 +199,7 @@ func cryptSecretEncrypt(public *Public, label []byte) (EncryptedSecret, []byte,
 			return nil, nil, fmt.Errorf("public key is not on curve")
 		}
 
-		ephPriv, ephX, ephY, err := elliptic.GenerateKey(pub.Curve, rand.Reader)
+		ephPriv, ephX, ephY, err := elliptic.GenerateKey(pub.Curve, randomSource{})
 		if err != nil {
 			return nil, nil, fmt.Errorf("cannot generate ephemeral ECC key: %v", err)
 		}
@@ -0,0 +1,180 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// defaultSession contains the state of the session enabled with TPMContext.EnableDefaultSession.
+type defaultSession struct {
+	mu      sync.Mutex // Held for the duration of a command that uses the session
+	tpmKey  ResourceContext
+	session *sessionContext
+}
+
+// get returns the session, starting a new one if there isn't one or it has been invalidated. The caller must hold s.mu.
+func (s *defaultSession) get(t *TPMContext) (*sessionContext, error) {
+	if s.session != nil && s.session.Handle() != HandleUnassigned {
+		return s.session, nil
+	}
+
+	symmetric := SymDef{
+		Algorithm: SymAlgorithmAES,
+		KeyBits:   &SymKeyBitsU{Sym: 128},
+		Mode:      &SymModeU{Sym: SymModeCFB}}
+	session, err := t.StartAuthSession(s.tpmKey, nil, SessionTypeHMAC, &symmetric, HashAlgorithmSHA256)
+	if err != nil {
+		return nil, err
+	}
+	s.session = session.(*sessionContext)
+	return s.session, nil
+}
+
+// EnableDefaultSession enables a mode where a salted, unbound HMAC session is attached automatically to every command that has
+// an encryptable first command or response parameter, in order to protect sensitive parameters on the bus and to provide integrity
+// protection for the command and response. The session is salted using the key associated with tpmKey, which would typically be
+// the EK or SRK. As the session may need to be restarted, this should correspond to a persistent object.
+//
+// The session is started by this function. If the session is subsequently flushed, it is lost because the TPM is reset, or the TPM
+// rejects it with a handle, value or HMAC error, then a new session is started transparently the next time that it is required.
+//
+// The session is not attached to a command if the caller has already supplied the maximum number of sessions, and the caller can
+// still supply its own session for command or response parameter encryption, in which case the default session is only used
+// for the parameter that the caller's session does not already encrypt. The session is never attached to TPM2_StartAuthSession.
+//
+// If a default session is already enabled, it is flushed and replaced with a new one.
+func (t *TPMContext) EnableDefaultSession(tpmKey ResourceContext) error {
+	if tpmKey == nil {
+		return makeInvalidArgError("tpmKey", "nil value")
+	}
+	if err := t.DisableDefaultSession(); err != nil {
+		return err
+	}
+
+	s := &defaultSession{tpmKey: tpmKey}
+	if _, err := s.get(t); err != nil {
+		return xerrors.Errorf("cannot start default session: %w", err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.defaultSession = s
+	return nil
+}
+
+// DisableDefaultSession disables the mode enabled by TPMContext.EnableDefaultSession, and flushes the session from the TPM.
+func (t *TPMContext) DisableDefaultSession() error {
+	t.mu.Lock()
+	s := t.defaultSession
+	t.defaultSession = nil
+	t.mu.Unlock()
+
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.session == nil || s.session.Handle() == HandleUnassigned {
+		return nil
+	}
+	if err := t.FlushContext(s.session); err != nil && !IsTPMParameterError(err, ErrorHandle, CommandFlushContext, 1) {
+		return xerrors.Errorf("cannot flush default session: %w", err)
+	}
+	s.session = nil
+	return nil
+}
+
+// defaultSessionAttrs returns the attributes with which the default session should be attached to a command with the supplied
+// sessions and parameters, or zero if it shouldn't be attached.
+func defaultSessionAttrs(commandCode CommandCode, sessionParams *sessionParams, commandParams, responseParams []interface{}) SessionAttributes {
+	if !isSessionAllowed(commandCode) || commandCode == CommandStartAuthSession || len(sessionParams.sessions) >= 3 {
+		return 0
+	}
+
+	var attrs SessionAttributes
+	if len(commandParams) > 0 && commandParams[0] != nil && isParamEncryptable(commandParams[0]) && !sessionParams.hasDecryptSession() {
+		attrs |= AttrCommandEncrypt
+	}
+	if s, _ := sessionParams.findEncryptSession(); s == nil && len(responseParams) > 0 && responseParams[0] != nil &&
+		isParamEncryptable(responseParams[0]) {
+		attrs |= AttrResponseEncrypt
+	}
+	if attrs == 0 {
+		return 0
+	}
+	return attrs | AttrContinueSession
+}
+
+// withExtraSession returns a copy of p with the supplied session appended to it.
+func (p *sessionParams) withExtraSession(session *sessionContext) *sessionParams {
+	r := new(sessionParams)
+	for _, s := range p.sessions {
+		c := *s
+		r.sessions = append(r.sessions, &c)
+	}
+	r.sessions = append(r.sessions, &sessionParam{session: session})
+	return r
+}
+
+// runCommandWithDefaultSession executes a command using the default session if one is enabled and is applicable to the command.
+// If the TPM indicates that the default session is no longer loaded, a new session is started and the command is resubmitted.
+func (t *TPMContext) runCommandWithDefaultSession(commandCode CommandCode, sessionParams *sessionParams, responseCb func(), commandHandles, commandParams, responseHandles, responseParams []interface{}) error {
+	t.mu.Lock()
+	s := t.defaultSession
+	t.mu.Unlock()
+
+	var attrs SessionAttributes
+	if s != nil {
+		attrs = defaultSessionAttrs(commandCode, sessionParams, commandParams, responseParams)
+	}
+	if attrs == 0 {
+		return t.runCommandWithSessions(commandCode, sessionParams, responseCb, commandHandles, commandParams, responseHandles, responseParams)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for restarted := false; ; restarted = true {
+		session, err := s.get(t)
+		if err != nil {
+			return xerrors.Errorf("cannot start default session for command %s: %w", commandCode, err)
+		}
+
+		p := sessionParams.withExtraSession(session.WithAttrs(attrs).(*sessionContext))
+		err = t.runCommandWithSessions(commandCode, p, responseCb, commandHandles, commandParams, responseHandles, responseParams)
+		if restarted {
+			return err
+		}
+
+		index := len(p.sessions)
+		switch {
+		case IsTPMWarning(err, WarningReferenceS0+WarningCode(index-1), commandCode):
+			// The session is no longer loaded, most likely because the TPM has been reset.
+			session.invalidate()
+		case isDefaultSessionError(err, commandCode, index):
+			// The TPM rejected the session without executing the command, most likely because its state is no longer in
+			// sync with ours. Try to flush it, although it may not be loaded any more, and start a new one.
+			t.FlushContext(session)
+			session.invalidate()
+		default:
+			return err
+		}
+	}
+}
+
+// isDefaultSessionError indicates whether err is an error returned from the TPM for the default session at the supplied index
+// in the authorization area, which indicates that the session should be restarted.
+func isDefaultSessionError(err error, commandCode CommandCode, index int) bool {
+	for _, code := range []ErrorCode{ErrorValue, ErrorHandle, ErrorAuthFail, ErrorBadAuth} {
+		if IsTPMSessionError(err, code, commandCode, index) {
+			return true
+		}
+	}
+	return false
+}
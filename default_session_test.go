@@ -0,0 +1,286 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+// commandRecorderTcti records every command that is written to the underlying TCTI.
+type commandRecorderTcti struct {
+	TCTI
+	commands [][]byte
+}
+
+func (t *commandRecorderTcti) Write(data []byte) (int, error) {
+	t.commands = append(t.commands, append([]byte(nil), data...))
+	return t.TCTI.Write(data)
+}
+
+// defaultSessionSuiteBase records the commands sent to the TPM and enables a default session salted with a persistent key.
+type defaultSessionSuiteBase struct {
+	testutil.TPMTestBase
+	tcti *commandRecorderTcti
+}
+
+func (s *defaultSessionSuiteBase) setUpTest(c *C, tcti TCTI) {
+	s.tcti = &commandRecorderTcti{TCTI: tcti}
+	s.TCTI = s.tcti
+	s.TPM, _ = NewTPMContext(s.tcti)
+	s.TPMTestBase.SetUpTest(c)
+	c.Assert(s.TPM.InitProperties(), IsNil)
+
+	template := Public{
+		Type:    ObjectTypeRSA,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrNoDA | AttrRestricted | AttrDecrypt,
+		Params: &PublicParamsU{
+			RSADetail: &RSAParams{
+				Symmetric: SymDefObject{
+					Algorithm: SymObjectAlgorithmAES,
+					KeyBits:   &SymKeyBitsU{Sym: 128},
+					Mode:      &SymModeU{Sym: SymModeCFB}},
+				Scheme:  RSAScheme{Scheme: RSASchemeNull},
+				KeyBits: 2048}}}
+	primary, _, _, _, _, err := s.TPM.CreatePrimary(s.TPM.OwnerHandleContext(), nil, &template, nil, nil, nil)
+	c.Assert(err, IsNil)
+	srk, err := s.TPM.PersistObject(s.TPM.OwnerHandleContext(), primary, PersistentOwnerRange, nil)
+	c.Assert(err, IsNil)
+	c.Assert(s.TPM.FlushContext(primary), IsNil)
+	s.AddFixtureCleanup(func(c *C) {
+		_, err := s.TPM.EvictControl(s.TPM.OwnerHandleContext(), srk, srk.Handle(), nil)
+		c.Check(err, IsNil)
+	})
+
+	c.Assert(s.TPM.EnableDefaultSession(srk), IsNil)
+	s.AddFixtureCleanup(func(c *C) {
+		c.Check(s.TPM.DisableDefaultSession(), IsNil)
+	})
+	s.tcti.commands = nil
+}
+
+func (s *defaultSessionSuiteBase) lastCommand(c *C) *DecodedCommand {
+	c.Assert(s.tcti.commands, Not(HasLen), 0)
+	cmd, err := DecodeCommand(s.tcti.commands[len(s.tcti.commands)-1])
+	c.Assert(err, IsNil)
+	return cmd
+}
+
+func (s *defaultSessionSuiteBase) countCommands(c *C, code CommandCode) (n int) {
+	for _, b := range s.tcti.commands {
+		cmd, err := DecodeCommand(b)
+		c.Assert(err, IsNil)
+		if cmd.CommandCode == code {
+			n++
+		}
+	}
+	return n
+}
+
+type defaultSessionSuite struct {
+	defaultSessionSuiteBase
+}
+
+var _ = Suite(&defaultSessionSuite{})
+
+func (s *defaultSessionSuite) SetUpTest(c *C) {
+	tcti, err := testutil.NewTCTI(testutil.TPMFeatureOwnerPersist | testutil.TPMFeatureChangeOwnerAuth)
+	c.Assert(err, IsNil)
+	if tcti == nil {
+		c.Skip("no TPM available for the test")
+	}
+	s.setUpTest(c, tcti)
+}
+
+func (s *defaultSessionSuite) TestResponseEncrypt(c *C) {
+	b, err := s.TPM.GetRandom(16)
+	c.Check(err, IsNil)
+	c.Check(b, HasLen, 16)
+
+	cmd := s.lastCommand(c)
+	c.Assert(cmd.AuthArea, HasLen, 1)
+	c.Check(cmd.AuthArea[0].SessionHandle.Type(), Equals, HandleTypeHMACSession)
+	c.Check(cmd.AuthArea[0].SessionAttrs, Equals, AttrContinueSession|AttrResponseEncrypt)
+}
+
+func (s *defaultSessionSuite) TestCommandEncrypt(c *C) {
+	c.Check(s.TPM.HierarchyChangeAuth(s.TPM.OwnerHandleContext(), Auth("foo"), nil), IsNil)
+
+	cmd := s.lastCommand(c)
+	c.Assert(cmd.AuthArea, HasLen, 2)
+	c.Check(cmd.AuthArea[0].SessionHandle, Equals, HandlePW)
+	c.Check(cmd.AuthArea[1].SessionHandle.Type(), Equals, HandleTypeHMACSession)
+	c.Check(cmd.AuthArea[1].SessionAttrs, Equals, AttrContinueSession|AttrCommandEncrypt)
+	c.Assert(cmd.Params, HasLen, 1)
+	c.Check(cmd.Params[0].Encrypted, testutil.IsTrue)
+	c.Check(cmd.Params[0].Value, Not(DeepEquals), []byte("foo"))
+
+	// Check that the TPM decrypted the new authorization value correctly.
+	c.Check(s.TPM.HierarchyChangeAuth(s.TPM.OwnerHandleContext(), nil, nil), IsNil)
+}
+
+func (s *defaultSessionSuite) TestNotAttachedWithoutEncryptableParams(c *C) {
+	_, err := s.TPM.GetCapabilityTPMProperties(PropertyManufacturer, 1)
+	c.Check(err, IsNil)
+	c.Check(s.lastCommand(c).AuthArea, HasLen, 0)
+}
+
+func (s *defaultSessionSuite) TestNotAttachedWithCallerEncryptSession(c *C) {
+	session, err := s.TPM.StartAuthSession(nil, nil, SessionTypeHMAC, &SymDef{
+		Algorithm: SymAlgorithmAES,
+		KeyBits:   &SymKeyBitsU{Sym: 128},
+		Mode:      &SymModeU{Sym: SymModeCFB}}, HashAlgorithmSHA256)
+	c.Assert(err, IsNil)
+	s.AddCleanupFlushContext(c, session)
+
+	_, err = s.TPM.GetRandom(16, session.WithAttrs(AttrContinueSession|AttrResponseEncrypt))
+	c.Check(err, IsNil)
+
+	cmd := s.lastCommand(c)
+	c.Assert(cmd.AuthArea, HasLen, 1)
+	c.Check(cmd.AuthArea[0].SessionHandle, Equals, session.Handle())
+}
+
+func (s *defaultSessionSuite) TestRestartAfterFlush(c *C) {
+	_, err := s.TPM.GetRandom(16)
+	c.Check(err, IsNil)
+	handle := s.lastCommand(c).AuthArea[0].SessionHandle
+
+	// Flush the session behind the back of the TPMContext.
+	b, err := mu.MarshalToBytes(handle)
+	c.Assert(err, IsNil)
+	rc, _, _, err := s.TPM.RunCommandBytes(TagNoSessions, CommandFlushContext, b)
+	c.Assert(err, IsNil)
+	c.Assert(rc, Equals, ResponseCode(Success))
+
+	s.tcti.commands = nil
+	_, err = s.TPM.GetRandom(16)
+	c.Check(err, IsNil)
+	c.Check(s.countCommands(c, CommandStartAuthSession), Equals, 1)
+	c.Check(s.countCommands(c, CommandGetRandom), Equals, 2)
+}
+
+func (s *defaultSessionSuite) TestDisable(c *C) {
+	c.Check(s.TPM.DisableDefaultSession(), IsNil)
+
+	handles, err := s.TPM.GetCapabilityHandles(HandleTypeHMACSession.BaseHandle(), CapabilityMaxProperties)
+	c.Check(err, IsNil)
+	c.Check(handles, HasLen, 0)
+
+	_, err = s.TPM.GetRandom(16)
+	c.Check(err, IsNil)
+	c.Check(s.lastCommand(c).AuthArea, HasLen, 0)
+}
+
+type defaultSessionSimulatorSuite struct {
+	defaultSessionSuiteBase
+	mssim *TctiMssim
+}
+
+var _ = Suite(&defaultSessionSimulatorSuite{})
+
+func (s *defaultSessionSimulatorSuite) SetUpTest(c *C) {
+	_, tcti, err := testutil.NewTPMSimulatorContext()
+	c.Assert(err, IsNil)
+	if tcti == nil {
+		c.Skip("no TPM available for the test")
+	}
+	s.mssim = tcti
+	s.setUpTest(c, tcti)
+}
+
+func (s *defaultSessionSimulatorSuite) TestRestartAfterReset(c *C) {
+	c.Assert(testutil.ResetTPMSimulator(s.TPM, s.mssim), IsNil)
+
+	_, err := s.TPM.GetRandom(16)
+	c.Check(err, IsNil)
+	c.Check(s.countCommands(c, CommandStartAuthSession), Equals, 1)
+	c.Check(s.lastCommand(c).AuthArea, HasLen, 1)
+}
+
+type defaultSessionFaultSuite struct {
+	defaultSessionSuiteBase
+	faults *testutil.FaultInjectionTCTI
+}
+
+var _ = Suite(&defaultSessionFaultSuite{})
+
+func (s *defaultSessionFaultSuite) SetUpTest(c *C) {
+	tcti, err := testutil.NewTCTI(testutil.TPMFeatureOwnerPersist | testutil.TPMFeatureChangeOwnerAuth)
+	c.Assert(err, IsNil)
+	if tcti == nil {
+		c.Skip("no TPM available for the test")
+	}
+	s.faults = testutil.NewFaultInjectionTCTI(tcti)
+	s.setUpTest(c, s.faults)
+	s.AddCleanup(s.faults.ClearRules)
+}
+
+func (s *defaultSessionFaultSuite) testRestartAfterSessionError(c *C, rc ResponseCode) {
+	s.faults.AddRule(&testutil.FaultRule{
+		Command:      CommandGetRandom,
+		Fault:        testutil.FaultResponseCode,
+		Count:        1,
+		ResponseCode: rc})
+
+	b, err := s.TPM.GetRandom(16)
+	c.Check(err, IsNil)
+	c.Check(b, HasLen, 16)
+	c.Check(s.countCommands(c, CommandGetRandom), Equals, 2)
+	c.Check(s.countCommands(c, CommandFlushContext), Equals, 1)
+	c.Check(s.countCommands(c, CommandStartAuthSession), Equals, 1)
+	c.Check(s.lastCommand(c).AuthArea, HasLen, 1)
+}
+
+func (s *defaultSessionFaultSuite) TestRestartAfterSessionHandleError(c *C) {
+	// TPM_RC_HANDLE + TPM_RC_S + TPM_RC_1
+	s.testRestartAfterSessionError(c, 0x98b)
+}
+
+func (s *defaultSessionFaultSuite) TestRestartAfterSessionValueError(c *C) {
+	// TPM_RC_VALUE + TPM_RC_S + TPM_RC_1
+	s.testRestartAfterSessionError(c, 0x984)
+}
+
+func (s *defaultSessionFaultSuite) TestRestartAfterSessionAuthFail(c *C) {
+	// TPM_RC_AUTH_FAIL + TPM_RC_S + TPM_RC_1
+	s.testRestartAfterSessionError(c, 0x98e)
+}
+
+func (s *defaultSessionFaultSuite) TestRestartAfterSessionBadAuth(c *C) {
+	// TPM_RC_BAD_AUTH + TPM_RC_S + TPM_RC_1
+	s.testRestartAfterSessionError(c, 0x9a2)
+}
+
+func (s *defaultSessionFaultSuite) TestRestartOnlyOnce(c *C) {
+	s.faults.AddRule(&testutil.FaultRule{
+		Command:      CommandGetRandom,
+		Fault:        testutil.FaultResponseCode,
+		ResponseCode: 0x98b})
+
+	_, err := s.TPM.GetRandom(16)
+	c.Check(IsTPMSessionError(err, ErrorHandle, CommandGetRandom, 1), testutil.IsTrue)
+	c.Check(s.countCommands(c, CommandGetRandom), Equals, 2)
+	c.Check(s.countCommands(c, CommandStartAuthSession), Equals, 1)
+}
+
+func (s *defaultSessionFaultSuite) TestNoRestartForCallerSessionError(c *C) {
+	// TPM_RC_HANDLE + TPM_RC_S + TPM_RC_1, where session 1 is the password authorization for the owner hierarchy and the
+	// default session is session 2.
+	s.faults.AddRule(&testutil.FaultRule{
+		Command:      CommandHierarchyChangeAuth,
+		Fault:        testutil.FaultResponseCode,
+		Count:        1,
+		ResponseCode: 0x98b})
+
+	err := s.TPM.HierarchyChangeAuth(s.TPM.OwnerHandleContext(), Auth("foo"), nil)
+	c.Check(IsTPMSessionError(err, ErrorHandle, CommandHierarchyChangeAuth, 1), testutil.IsTrue)
+	c.Check(s.countCommands(c, CommandHierarchyChangeAuth), Equals, 1)
+	c.Check(s.countCommands(c, CommandStartAuthSession), Equals, 0)
+}
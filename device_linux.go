@@ -9,6 +9,10 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
 
 	"golang.org/x/sys/unix"
 	"golang.org/x/xerrors"
@@ -18,21 +22,72 @@ const (
 	maxCommandSize int = 4096
 )
 
+var devicePathRE = regexp.MustCompile(`^tpm(?:rm)?([0-9]+)$`)
+
+type deadlineExceededError struct{}
+
+func (deadlineExceededError) Error() string   { return "deadline exceeded" }
+func (deadlineExceededError) Timeout() bool   { return true }
+func (deadlineExceededError) Temporary() bool { return true }
+
 // tctiDeviceLinux represents a connection to a Linux TPM character device.
 type TctiDeviceLinux struct {
 	f   *os.File
 	buf *bytes.Reader
+
+	cancelPath string // Path of the sysfs cancel attribute for this device
+
+	deadlineMu sync.Mutex
+	deadline   time.Time
+	wakeFds    [2]int // Non-blocking pipe used to wake up a poll when the deadline changes
 }
 
-func (d *TctiDeviceLinux) readMoreData() error {
-	fds := []unix.PollFd{unix.PollFd{Fd: int32(d.f.Fd()), Events: unix.POLLIN}}
-	_, err := unix.Ppoll(fds, nil, nil)
-	if err != nil {
-		return xerrors.Errorf("polling device failed: %w", err)
+func (d *TctiDeviceLinux) pollTimeout() (*unix.Timespec, error) {
+	d.deadlineMu.Lock()
+	defer d.deadlineMu.Unlock()
+
+	if d.deadline.IsZero() {
+		return nil, nil
 	}
+	remaining := time.Until(d.deadline)
+	if remaining <= 0 {
+		return nil, deadlineExceededError{}
+	}
+	ts := unix.NsecToTimespec(remaining.Nanoseconds())
+	return &ts, nil
+}
+
+func (d *TctiDeviceLinux) readMoreData() error {
+	for {
+		timeout, err := d.pollTimeout()
+		if err != nil {
+			return err
+		}
+
+		fds := []unix.PollFd{
+			unix.PollFd{Fd: int32(d.f.Fd()), Events: unix.POLLIN},
+			unix.PollFd{Fd: int32(d.wakeFds[0]), Events: unix.POLLIN}}
+		if _, err := unix.Ppoll(fds, timeout, nil); err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return xerrors.Errorf("polling device failed: %w", err)
+		}
 
-	if fds[0].Events != fds[0].Revents {
-		return fmt.Errorf("invalid poll events returned: %d", fds[0].Revents)
+		if fds[1].Revents&unix.POLLIN != 0 {
+			// The deadline was changed - drain the pipe and poll again.
+			var scratch [16]byte
+			unix.Read(d.wakeFds[0], scratch[:])
+			continue
+		}
+		if fds[0].Revents == 0 {
+			// Timed out - the deadline will be checked on the next iteration.
+			continue
+		}
+		if fds[0].Events != fds[0].Revents {
+			return fmt.Errorf("invalid poll events returned: %d", fds[0].Revents)
+		}
+		break
 	}
 
 	buf := make([]byte, maxCommandSize)
@@ -60,9 +115,41 @@ func (d *TctiDeviceLinux) Write(data []byte) (int, error) {
 }
 
 func (d *TctiDeviceLinux) Close() error {
+	unix.Close(d.wakeFds[0])
+	unix.Close(d.wakeFds[1])
 	return d.f.Close()
 }
 
+// Cancel implements TCTICanceler.Cancel, and requests that the currently executing command is cancelled using the cancel
+// attribute in sysfs. Note that this attribute is not provided by all kernel drivers, in which case an error is returned.
+func (d *TctiDeviceLinux) Cancel() error {
+	if d.cancelPath == "" {
+		return errors.New("cancellation is not supported for this device")
+	}
+	f, err := os.OpenFile(d.cancelPath, os.O_WRONLY, 0)
+	if err != nil {
+		return xerrors.Errorf("cannot open cancel attribute: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte("1")); err != nil {
+		return xerrors.Errorf("cannot write to cancel attribute: %w", err)
+	}
+	return nil
+}
+
+// SetReadDeadline implements TCTIReadDeadliner.SetReadDeadline. Once the deadline is exceeded, Read will return an error that
+// implements a Timeout method that returns true. A subsequent Read will wait for the pending response.
+func (d *TctiDeviceLinux) SetReadDeadline(t time.Time) error {
+	d.deadlineMu.Lock()
+	d.deadline = t
+	d.deadlineMu.Unlock()
+
+	// Wake up a poll that might be in progress so that it observes the new deadline. The pipe is non-blocking, and it
+	// doesn't matter if this fails because the pipe is full.
+	unix.Write(d.wakeFds[1], []byte{0})
+	return nil
+}
+
 func (d *TctiDeviceLinux) SetLocality(locality uint8) error {
 	return errors.New("not implemented")
 }
@@ -89,5 +176,13 @@ func OpenTPMDevice(path string) (*TctiDeviceLinux, error) {
 		return nil, fmt.Errorf("unsupported file mode %v", s.Mode())
 	}
 
-	return &TctiDeviceLinux{f: f}, nil
+	d := &TctiDeviceLinux{f: f}
+	if err := unix.Pipe2(d.wakeFds[:], unix.O_NONBLOCK|unix.O_CLOEXEC); err != nil {
+		f.Close()
+		return nil, xerrors.Errorf("cannot create pipe: %w", err)
+	}
+	if m := devicePathRE.FindStringSubmatch(filepath.Base(path)); m != nil {
+		d.cancelPath = filepath.Join("/sys/class/tpm", "tpm"+m[1], "device/cancel")
+	}
+	return d, nil
 }
@@ -0,0 +1,326 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
+)
+
+// CommandAuth corresponds to a decoded TPMS_AUTH_COMMAND structure from the authorization area of a command.
+type CommandAuth struct {
+	SessionHandle Handle            // The handle of the session, or HandlePW for a password authorization
+	Nonce         Nonce             // The caller nonce
+	SessionAttrs  SessionAttributes // The session attributes. Reserved bits are ignored.
+	HMAC          Auth              // The HMAC or password
+}
+
+// ResponseAuth corresponds to a decoded TPMS_AUTH_RESPONSE structure from the authorization area of a response.
+type ResponseAuth struct {
+	Nonce        Nonce             // The TPM nonce
+	SessionAttrs SessionAttributes // The session attributes. Reserved bits are ignored.
+	HMAC         Auth              // The HMAC
+}
+
+// DecodedHandle is a named handle from the handle area of a decoded command or response.
+type DecodedHandle struct {
+	Name   string // The name of the handle, as it appears in part 3 of the TPM Library Specification
+	Handle Handle
+}
+
+// DecodedParam is a named parameter from the parameter area of a decoded command or response.
+type DecodedParam struct {
+	Name string // The name of the parameter, as it appears in part 3 of the TPM Library Specification
+
+	// Value is the decoded parameter, using the same Go type as the corresponding argument or return value of the TPMContext
+	// method for the command. If Encrypted is true, this is the encrypted contents of the parameter as a []byte.
+	Value interface{}
+
+	// Encrypted indicates that the parameter is encrypted with a session. The parameter's size field is not encrypted, so the
+	// remaining parameters can still be decoded.
+	Encrypted bool
+
+	// Sensitive indicates that the parameter contains secret data, such as a symmetric key, even though its type is one that
+	// isn't generally used for secrets.
+	Sensitive bool
+}
+
+// DecodedCommand is the result of decoding a command packet with DecodeCommand.
+type DecodedCommand struct {
+	Tag         StructTag
+	CommandSize uint32
+	CommandCode CommandCode
+
+	Handles  []DecodedHandle
+	AuthArea []CommandAuth
+
+	// Params contains the decoded parameters. This will be nil if the command code is not recognized.
+	Params []DecodedParam
+
+	// Unparsed contains any bytes that follow the decoded fields. For a command code that isn't recognized, this is everything
+	// after the header. For a well formed command with a recognized command code, this will be empty.
+	Unparsed []byte
+}
+
+// DecodedResponse is the result of decoding a response packet with DecodeResponse.
+type DecodedResponse struct {
+	Tag          StructTag
+	ResponseSize uint32
+	ResponseCode ResponseCode
+
+	Handles []DecodedHandle
+
+	// Params contains the decoded parameters. This will be nil if the response code indicates an error or if the command code
+	// is not recognized.
+	Params []DecodedParam
+
+	AuthArea []ResponseAuth
+
+	// Unparsed contains any bytes from the parameter area that weren't decoded, followed by any other bytes that follow the
+	// decoded fields. For a response to a command code that isn't recognized, this is everything after the header. For a well
+	// formed response to a recognized command code, this will be empty.
+	Unparsed []byte
+}
+
+// toSessionAttributes converts the supplied TPMA_SESSION value to SessionAttributes.
+func (a sessionAttrs) toSessionAttributes() SessionAttributes {
+	var attrs SessionAttributes
+	for _, m := range []struct {
+		from sessionAttrs
+		to   SessionAttributes
+	}{
+		{attrContinueSession, AttrContinueSession},
+		{attrAuditExclusive, AttrAuditExclusive},
+		{attrAuditReset, AttrAuditReset},
+		{attrDecrypt, AttrCommandEncrypt},
+		{attrEncrypt, AttrResponseEncrypt},
+		{attrAudit, AttrAudit}} {
+		if a&m.from != 0 {
+			attrs |= m.to
+		}
+	}
+	return attrs
+}
+
+func decodeHandles(r *bytes.Reader, names []string) (out []DecodedHandle, err error) {
+	for _, name := range names {
+		var handle Handle
+		if _, err := mu.UnmarshalFromReader(r, &handle); err != nil {
+			return out, xerrors.Errorf("cannot unmarshal %s: %w", name, err)
+		}
+		out = append(out, DecodedHandle{Name: name, Handle: handle})
+	}
+	return out, nil
+}
+
+// decodedParamValue converts a decoded parameter from the type used internally to unmarshal it to the type used by the
+// corresponding TPMContext method.
+func decodedParamValue(v interface{}) interface{} {
+	switch p := v.(type) {
+	case publicSized:
+		return p.Ptr
+	case attestSized:
+		return p.Ptr
+	case sensitiveCreateSized:
+		return p.Ptr
+	case creationDataSized:
+		return p.Ptr
+	case nvPublicSized:
+		return p.Ptr
+	case sensitiveSized:
+		return p.Ptr
+	case fieldUpgradeDigest:
+		d := TaggedHash(p)
+		return &d
+	default:
+		return v
+	}
+}
+
+func decodeParams(r *bytes.Reader, schema *commandSchema, params []commandSchemaParam, encrypted bool) (out []DecodedParam, err error) {
+	out = make([]DecodedParam, 0, len(params))
+	for i, p := range params {
+		v := reflect.New(p.typ)
+		if i == 0 && encrypted && isParamEncryptable(v.Elem().Interface()) {
+			var data []byte
+			if _, err := mu.UnmarshalFromReader(r, &data); err != nil {
+				return out, xerrors.Errorf("cannot unmarshal %s: %w", p.name, err)
+			}
+			out = append(out, DecodedParam{Name: p.name, Value: data, Encrypted: true, Sensitive: schema.isSensitive(p.name)})
+			continue
+		}
+		if _, err := mu.UnmarshalFromReader(r, v.Interface()); err != nil {
+			return out, xerrors.Errorf("cannot unmarshal %s: %w", p.name, err)
+		}
+		out = append(out, DecodedParam{Name: p.name, Value: decodedParamValue(v.Elem().Interface()), Sensitive: schema.isSensitive(p.name)})
+	}
+	return out, nil
+}
+
+func remainingBytes(r *bytes.Reader) []byte {
+	if r.Len() == 0 {
+		return nil
+	}
+	b := make([]byte, r.Len())
+	r.Read(b)
+	return b
+}
+
+// DecodeCommand decodes the supplied command packet, which must be a complete command including the header, as written to
+// a TCTI or passed to TPMContext.RunCommandBytes along with a header. The handles and parameters are named and decoded using
+// the types that this package uses for the corresponding command, including for vendor-specific commands registered with
+// RegisterVendorCommand.
+//
+// If a session in the authorization area has the AttrCommandEncrypt attribute set, the first command parameter is encrypted.
+// In this case, it is returned as a []byte, and the DecodedParam.Encrypted field is set.
+//
+// If the command cannot be decoded, an error will be returned along with the fields that were decoded successfully, with the
+// rest of the command in the Unparsed field. An error is not returned for an unrecognized command code.
+func DecodeCommand(cmd []byte) (*DecodedCommand, error) {
+	r := bytes.NewReader(cmd)
+	var hdr commandHeader
+	if _, err := mu.UnmarshalFromReader(r, &hdr); err != nil {
+		return nil, xerrors.Errorf("cannot unmarshal header: %w", err)
+	}
+
+	out := &DecodedCommand{Tag: hdr.Tag, CommandSize: hdr.CommandSize, CommandCode: hdr.CommandCode}
+	err := out.decode(r)
+	out.Unparsed = remainingBytes(r)
+	return out, err
+}
+
+func (c *DecodedCommand) decode(r *bytes.Reader) (err error) {
+	schema := lookupCommandSchema(c.CommandCode)
+	if schema == nil {
+		return nil
+	}
+
+	c.Handles, err = decodeHandles(r, schema.handles)
+	if err != nil {
+		return err
+	}
+
+	encrypted := false
+	if c.Tag == TagSessions {
+		var authSize uint32
+		if _, err := mu.UnmarshalFromReader(r, &authSize); err != nil {
+			return xerrors.Errorf("cannot unmarshal auth area size: %w", err)
+		}
+		if int64(authSize) > int64(r.Len()) {
+			return fmt.Errorf("invalid auth area size (%d)", authSize)
+		}
+		authBytes := make([]byte, authSize)
+		r.Read(authBytes)
+		ar := bytes.NewReader(authBytes)
+		for i := 1; ar.Len() > 0; i++ {
+			var auth authCommand
+			if _, err := mu.UnmarshalFromReader(ar, &auth); err != nil {
+				return xerrors.Errorf("cannot unmarshal session %d: %w", i, err)
+			}
+			c.AuthArea = append(c.AuthArea, CommandAuth{
+				SessionHandle: auth.SessionHandle,
+				Nonce:         auth.Nonce,
+				SessionAttrs:  auth.SessionAttrs.toSessionAttributes(),
+				HMAC:          auth.HMAC})
+			if auth.SessionAttrs&attrDecrypt != 0 {
+				encrypted = true
+			}
+		}
+	}
+
+	if schema.params == nil {
+		return nil
+	}
+	c.Params, err = decodeParams(r, schema, schema.params, encrypted)
+	return err
+}
+
+// DecodeResponse decodes the supplied response packet, which must be a complete response including the header, as read from a
+// TCTI. As a response does not identify the command it is for, the command code must be supplied. The handles and parameters
+// are named and decoded using the types that this package uses for the corresponding command, including for vendor-specific
+// commands registered with RegisterVendorCommand.
+//
+// If a session in the authorization area has the AttrResponseEncrypt attribute set, the first response parameter is encrypted.
+// In this case, it is returned as a []byte, and the DecodedParam.Encrypted field is set.
+//
+// If the response cannot be decoded, an error will be returned along with the fields that were decoded successfully, with the
+// rest of the response in the Unparsed field. An error is not returned for an unrecognized command code.
+func DecodeResponse(commandCode CommandCode, rsp []byte) (*DecodedResponse, error) {
+	r := bytes.NewReader(rsp)
+	var hdr responseHeader
+	if _, err := mu.UnmarshalFromReader(r, &hdr); err != nil {
+		return nil, xerrors.Errorf("cannot unmarshal header: %w", err)
+	}
+
+	out := &DecodedResponse{Tag: hdr.Tag, ResponseSize: hdr.ResponseSize, ResponseCode: hdr.ResponseCode}
+	pr, err := out.decode(commandCode, r)
+	if pr != r {
+		out.Unparsed = remainingBytes(pr)
+	}
+	out.Unparsed = append(out.Unparsed, remainingBytes(r)...)
+	return out, err
+}
+
+// decode decodes the body of a response, and returns the reader for the parameter area.
+func (d *DecodedResponse) decode(commandCode CommandCode, r *bytes.Reader) (pr *bytes.Reader, err error) {
+	if d.ResponseCode != ResponseCode(Success) {
+		return r, nil
+	}
+
+	schema := lookupCommandSchema(commandCode)
+	if schema == nil {
+		return r, nil
+	}
+
+	d.Handles, err = decodeHandles(r, schema.rspHandles)
+	if err != nil {
+		return r, err
+	}
+
+	pr = r
+	if d.Tag == TagSessions {
+		var paramSize uint32
+		if _, err := mu.UnmarshalFromReader(r, &paramSize); err != nil {
+			return r, xerrors.Errorf("cannot unmarshal parameter area size: %w", err)
+		}
+		if int64(paramSize) > int64(r.Len()) {
+			return r, fmt.Errorf("invalid parameter area size (%d)", paramSize)
+		}
+		paramBytes := make([]byte, paramSize)
+		r.Read(paramBytes)
+		pr = bytes.NewReader(paramBytes)
+
+		// The auth area follows the parameter area, but it has to be decoded first in order to determine whether the first
+		// parameter is encrypted.
+		for i := 1; r.Len() > 0; i++ {
+			var auth authResponse
+			if _, err := mu.UnmarshalFromReader(r, &auth); err != nil {
+				return pr, xerrors.Errorf("cannot unmarshal session %d: %w", i, err)
+			}
+			d.AuthArea = append(d.AuthArea, ResponseAuth{
+				Nonce:        auth.Nonce,
+				SessionAttrs: auth.SessionAttrs.toSessionAttributes(),
+				HMAC:         auth.HMAC})
+		}
+	}
+
+	encrypted := false
+	for _, auth := range d.AuthArea {
+		if auth.SessionAttrs&AttrResponseEncrypt != 0 {
+			encrypted = true
+		}
+	}
+
+	if schema.rspParams == nil {
+		return pr, nil
+	}
+	d.Params, err = decodeParams(pr, schema, schema.rspParams, encrypted)
+	return pr, err
+}
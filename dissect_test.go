@@ -0,0 +1,189 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+type dissectSuite struct{}
+
+var _ = Suite(&dissectSuite{})
+
+func (s *dissectSuite) TestDecodeCommandWithSessions(c *C) {
+	cmd := makeScriptedPacket(c, TagSessions, uint32(CommandHierarchyChangeAuth),
+		HandleOwner, uint32(9), HandlePW, uint16(0), uint8(1), uint16(0), Auth("foo"))
+
+	d, err := DecodeCommand(cmd)
+	c.Check(err, IsNil)
+	c.Check(d, DeepEquals, &DecodedCommand{
+		Tag:         TagSessions,
+		CommandSize: uint32(len(cmd)),
+		CommandCode: CommandHierarchyChangeAuth,
+		Handles:     []DecodedHandle{{Name: "authHandle", Handle: HandleOwner}},
+		AuthArea:    []CommandAuth{{SessionHandle: HandlePW, SessionAttrs: AttrContinueSession}},
+		Params:      []DecodedParam{{Name: "newAuth", Value: Auth("foo")}}})
+}
+
+func (s *dissectSuite) TestDecodeCommandWithEncryptedParam(c *C) {
+	cmd := makeScriptedPacket(c, TagSessions, uint32(CommandHierarchyChangeAuth),
+		HandleOwner, uint32(13), Handle(0x02000000), Nonce{1, 2}, uint8(0x21), Auth{3, 4}, Auth{5, 6, 7})
+
+	d, err := DecodeCommand(cmd)
+	c.Check(err, IsNil)
+	c.Check(d.AuthArea, DeepEquals, []CommandAuth{
+		{SessionHandle: 0x02000000, Nonce: Nonce{1, 2}, SessionAttrs: AttrContinueSession | AttrCommandEncrypt, HMAC: Auth{3, 4}}})
+	c.Check(d.Params, DeepEquals, []DecodedParam{{Name: "newAuth", Value: []byte{5, 6, 7}, Encrypted: true}})
+}
+
+func (s *dissectSuite) marshal(c *C, v interface{}) []byte {
+	b, err := mu.MarshalToBytes(v)
+	c.Assert(err, IsNil)
+	return b
+}
+
+func (s *dissectSuite) testPublic() *Public {
+	return &Public{
+		Type:    ObjectTypeKeyedHash,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrUserWithAuth,
+		Params:  &PublicParamsU{KeyedHashDetail: &KeyedHashParams{Scheme: KeyedHashScheme{Scheme: KeyedHashSchemeNull}}},
+		Unique:  &PublicIDU{KeyedHash: Digest{1, 2, 3}}}
+}
+
+func (s *dissectSuite) TestDecodeCommandWithObjects(c *C) {
+	sensitive := &SensitiveCreate{UserAuth: Auth("foo"), Data: SensitiveData("bar")}
+	public := s.testPublic()
+
+	cmd := makeScriptedPacket(c, TagSessions, uint32(CommandCreatePrimary),
+		HandleOwner, uint32(9), HandlePW, uint16(0), uint8(1), uint16(0),
+		s.marshal(c, sensitive), s.marshal(c, public), Data{}, PCRSelectionList{})
+
+	d, err := DecodeCommand(cmd)
+	c.Check(err, IsNil)
+	c.Check(d.Unparsed, IsNil)
+	c.Assert(d.Params, HasLen, 4)
+
+	c.Check(d.Params[0].Name, Equals, "inSensitive")
+	c.Assert(d.Params[0].Value, FitsTypeOf, (*SensitiveCreate)(nil))
+	c.Check(d.Params[0].Value.(*SensitiveCreate).UserAuth, DeepEquals, Auth("foo"))
+	c.Check(d.Params[0].Value.(*SensitiveCreate).Data, DeepEquals, SensitiveData("bar"))
+
+	c.Check(d.Params[1].Name, Equals, "inPublic")
+	c.Assert(d.Params[1].Value, FitsTypeOf, (*Public)(nil))
+	c.Check(s.marshal(c, d.Params[1].Value), DeepEquals, s.marshal(c, public))
+}
+
+func (s *dissectSuite) TestDecodeResponseWithObjects(c *C) {
+	public := s.testPublic()
+	creationData := &CreationData{
+		PCRSelect:     PCRSelectionList{},
+		PCRDigest:     Digest{},
+		ParentNameAlg: AlgorithmNull,
+		ParentName:    Name{0x40, 0x00, 0x00, 0x01},
+		OutsideInfo:   Data{4, 5}}
+	name, err := public.Name()
+	c.Assert(err, IsNil)
+
+	params, err := mu.MarshalToBytes(s.marshal(c, public), s.marshal(c, creationData), Digest{6},
+		TkCreation{Tag: TagCreation, Hierarchy: HandleOwner, Digest: Digest{7}}, name)
+	c.Assert(err, IsNil)
+	rsp := makeScriptedPacket(c, TagSessions, uint32(Success), Handle(0x80000000), uint32(len(params)), mu.RawBytes(params),
+		Nonce{}, uint8(1), Auth{})
+
+	d, err := DecodeResponse(CommandCreatePrimary, rsp)
+	c.Check(err, IsNil)
+	c.Check(d.Unparsed, IsNil)
+	c.Assert(d.Params, HasLen, 5)
+
+	c.Check(d.Params[0].Name, Equals, "outPublic")
+	c.Assert(d.Params[0].Value, FitsTypeOf, (*Public)(nil))
+	c.Check(s.marshal(c, d.Params[0].Value), DeepEquals, s.marshal(c, public))
+
+	c.Check(d.Params[1].Name, Equals, "creationData")
+	c.Assert(d.Params[1].Value, FitsTypeOf, (*CreationData)(nil))
+	c.Check(d.Params[1].Value.(*CreationData).ParentName, DeepEquals, Name{0x40, 0x00, 0x00, 0x01})
+	c.Check(d.Params[1].Value.(*CreationData).OutsideInfo, DeepEquals, Data{4, 5})
+
+	c.Check(d.Params[4], DeepEquals, DecodedParam{Name: "name", Value: name})
+}
+
+func (s *dissectSuite) TestDecodeCommandUnrecognized(c *C) {
+	cmd := makeScriptedPacket(c, TagNoSessions, 0x2000ffff, uint32(0xa5a5a5a5))
+
+	d, err := DecodeCommand(cmd)
+	c.Check(err, IsNil)
+	c.Check(d.CommandCode, Equals, CommandCode(0x2000ffff))
+	c.Check(d.Params, IsNil)
+	c.Check(d.Unparsed, DeepEquals, []byte{0xa5, 0xa5, 0xa5, 0xa5})
+}
+
+func (s *dissectSuite) TestDecodeCommandTruncated(c *C) {
+	cmd := makeScriptedPacket(c, TagSessions, uint32(CommandHierarchyChangeAuth), HandleOwner, uint32(20), HandlePW)
+
+	d, err := DecodeCommand(cmd)
+	c.Check(err, ErrorMatches, `invalid auth area size \(20\)`)
+	c.Assert(d, NotNil)
+	c.Check(d.Handles, DeepEquals, []DecodedHandle{{Name: "authHandle", Handle: HandleOwner}})
+	c.Check(d.Unparsed, DeepEquals, []byte{0x40, 0x00, 0x00, 0x09})
+}
+
+func (s *dissectSuite) TestDecodeCommandInvalidHeader(c *C) {
+	_, err := DecodeCommand([]byte{0x80, 0x01, 0x00})
+	c.Check(err, ErrorMatches, `cannot unmarshal header: .*`)
+}
+
+func (s *dissectSuite) TestDecodeResponseWithSessions(c *C) {
+	rsp := makeScriptedPacket(c, TagSessions, uint32(Success), uint32(6), Digest{1, 2, 3, 4}, Nonce{5}, uint8(1), Auth{6})
+
+	d, err := DecodeResponse(CommandGetRandom, rsp)
+	c.Check(err, IsNil)
+	c.Check(d, DeepEquals, &DecodedResponse{
+		Tag:          TagSessions,
+		ResponseSize: uint32(len(rsp)),
+		ResponseCode: ResponseCode(Success),
+		Params:       []DecodedParam{{Name: "randomBytes", Value: Digest{1, 2, 3, 4}}},
+		AuthArea:     []ResponseAuth{{Nonce: Nonce{5}, SessionAttrs: AttrContinueSession, HMAC: Auth{6}}}})
+}
+
+func (s *dissectSuite) TestDecodeResponseHandles(c *C) {
+	rsp := makeScriptedPacket(c, TagNoSessions, uint32(Success), Handle(0x03000000), Nonce{1, 2})
+
+	d, err := DecodeResponse(CommandStartAuthSession, rsp)
+	c.Check(err, IsNil)
+	c.Check(d.Handles, DeepEquals, []DecodedHandle{{Name: "sessionHandle", Handle: 0x03000000}})
+	c.Check(d.Params, DeepEquals, []DecodedParam{{Name: "nonceTPM", Value: Nonce{1, 2}}})
+}
+
+func (s *dissectSuite) TestDecodeResponseWithEncryptedParam(c *C) {
+	rsp := makeScriptedPacket(c, TagSessions, uint32(Success), uint32(6), Digest{1, 2, 3, 4}, Nonce{5}, uint8(0x41), Auth{6})
+
+	d, err := DecodeResponse(CommandGetRandom, rsp)
+	c.Check(err, IsNil)
+	c.Check(d.Params, DeepEquals, []DecodedParam{{Name: "randomBytes", Value: []byte{1, 2, 3, 4}, Encrypted: true}})
+}
+
+func (s *dissectSuite) TestDecodeResponseError(c *C) {
+	rsp := makeScriptedPacket(c, TagNoSessions, 0x1c4)
+
+	d, err := DecodeResponse(CommandGetRandom, rsp)
+	c.Check(err, IsNil)
+	c.Check(d.ResponseCode, Equals, ResponseCode(0x1c4))
+	c.Check(d.Params, IsNil)
+	c.Check(d.Unparsed, IsNil)
+}
+
+func (s *dissectSuite) TestDecodeResponseUndecodedParams(c *C) {
+	rsp := makeScriptedPacket(c, TagSessions, uint32(Success), uint32(8), Digest{1, 2, 3, 4}, uint16(0xa5a5), Nonce{}, uint8(1), Auth{})
+
+	d, err := DecodeResponse(CommandGetRandom, rsp)
+	c.Check(err, IsNil)
+	c.Check(d.Params, DeepEquals, []DecodedParam{{Name: "randomBytes", Value: Digest{1, 2, 3, 4}}})
+	c.Check(d.AuthArea, HasLen, 1)
+	c.Check(d.Unparsed, DeepEquals, []byte{0xa5, 0xa5})
+}
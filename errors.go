@@ -83,6 +83,48 @@ func (e *TctiError) Unwrap() error {
 	return e.err
 }
 
+// CommandCanceledError is returned from any TPMContext method that executes a TPM command if the context.Context associated with
+// the TPMContext (see TPMContext.WithContext) is done before the command completes. It wraps the error returned from the
+// context's Err method, so errors.Is can be used to test for context.Canceled or context.DeadlineExceeded.
+//
+// If ResponseAbandoned is false, then the command was either never submitted to the TPM, or the TPM cancelled it and responded
+// with a TPM_RC_CANCELED warning. In this case, any sessions used in the command remain valid.
+//
+// If ResponseAbandoned is true, then the command was submitted to the TPM but the response was abandoned, and the outcome of the
+// command is unknown. Any sessions used in the command are invalidated in this case. The abandoned response is discarded before
+// the next command is submitted. If the command allocates objects on the TPM, it is possible that these objects were allocated
+// without a corresponding HandleContext being created. If the command removes objects from the TPM, it is possible that these
+// objects were removed and any associated HandleContexts should be considered stale.
+type CommandCanceledError struct {
+	Command           CommandCode
+	ResponseAbandoned bool
+	err               error
+}
+
+func (e *CommandCanceledError) Error() string {
+	if e.ResponseAbandoned {
+		return fmt.Sprintf("command %s was abandoned before it completed: %v", e.Command, e.err)
+	}
+	return fmt.Sprintf("command %s was canceled: %v", e.Command, e.err)
+}
+
+func (e *CommandCanceledError) Unwrap() error {
+	return e.err
+}
+
+// UnprotectedParameterError is returned from any TPMContext method that executes a TPM command if strict mode is enabled with
+// TPMContext.SetStrictMode and the command has a sensitive command or response parameter that would not be protected by session
+// based parameter encryption. The command is not submitted to the TPM in this case.
+type UnprotectedParameterError struct {
+	Command  CommandCode
+	Response bool // Whether the unprotected parameter is a response parameter
+	msg      string
+}
+
+func (e *UnprotectedParameterError) Error() string {
+	return fmt.Sprintf("command %s was not submitted because of an unprotected sensitive parameter: %s", e.Command, e.msg)
+}
+
 // TPM1Error is returned from DecodeResponseCode and any TPMContext method that executes a command on the TPM if the TPM response code
 // indicates an error from a TPM 1.2 device.
 type TPM1Error struct {
@@ -95,13 +137,18 @@ func (e *TPM1Error) Error() string {
 }
 
 // TPMVendorError is returned from DecodeResponseCode and and TPMContext method that executes a command on the TPM if the TPM response
-// code indicates a vendor-specific error.
+// code indicates a vendor-specific error. If the command is a vendor-specific command registered with RegisterVendorCommand, the
+// Vendor field is set to the name of the vendor that defines the command.
 type TPMVendorError struct {
 	Command CommandCode  // Command code associated with this error
 	Code    ResponseCode // Response code
+	Vendor  string       // Name of the vendor associated with this error, if known
 }
 
 func (e *TPMVendorError) Error() string {
+	if e.Vendor != "" {
+		return fmt.Sprintf("TPM returned a vendor defined error (%s) whilst executing command %s: 0x%08x", e.Vendor, e.Command, e.Code)
+	}
 	return fmt.Sprintf("TPM returned a vendor defined error whilst executing command %s: 0x%08x", e.Command, e.Code)
 }
 
@@ -321,7 +368,11 @@ func DecodeResponseCode(command CommandCode, resp ResponseCode) error {
 		case resp&fmt0VersionMask == 0:
 			return &TPM1Error{command, resp}
 		case resp&fmt0VendorMask > 0:
-			return &TPMVendorError{command, resp}
+			err := &TPMVendorError{Command: command, Code: resp}
+			if cmd := lookupVendorCommand(command); cmd != nil {
+				err.Vendor = cmd.Vendor
+			}
+			return err
 		case resp&fmt0SeverityMask > 0:
 			return &TPMWarning{command, WarningCode(resp & fmt0ErrorCodeMask)}
 		default:
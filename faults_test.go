@@ -0,0 +1,129 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"io"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+type faultsSuite struct {
+	testutil.FaultInjectionTPMTest
+}
+
+var _ = Suite(&faultsSuite{})
+
+func (s *faultsSuite) SetUpTest(c *C) {
+	s.FaultInjectionTPMTest.SetUpTest(c)
+	c.Check(s.TPM.InitProperties(), IsNil)
+}
+
+func (s *faultsSuite) TestResponseCodeRetried(c *C) {
+	s.FaultTCTI.AddRule(&testutil.FaultRule{
+		Command:      CommandGetRandom,
+		Fault:        testutil.FaultResponseCode,
+		Count:        2,
+		ResponseCode: ResponseCode(0x900) | ResponseCode(WarningRetry)})
+
+	b, err := s.TPM.GetRandom(8)
+	c.Check(err, IsNil)
+	c.Check(b, HasLen, 8)
+	c.Check(s.FaultTCTI.Injected(), Equals, 2)
+}
+
+func (s *faultsSuite) TestResponseCodeExhaustsSubmissions(c *C) {
+	s.FaultTCTI.AddRule(&testutil.FaultRule{
+		Command:      CommandGetRandom,
+		Fault:        testutil.FaultResponseCode,
+		ResponseCode: ResponseCode(0x900) | ResponseCode(WarningYielded)})
+	s.TPM.SetMaxSubmissions(3)
+
+	_, err := s.TPM.GetRandom(8)
+	c.Check(IsTPMWarning(err, WarningYielded, CommandGetRandom), testutil.IsTrue)
+	c.Check(s.FaultTCTI.Injected(), Equals, 3)
+}
+
+func (s *faultsSuite) TestResponseCodeNotRetried(c *C) {
+	s.FaultTCTI.AddRule(&testutil.FaultRule{
+		Command:      AnyCommandCode,
+		Fault:        testutil.FaultResponseCode,
+		ResponseCode: ResponseCode(0x900) | ResponseCode(WarningLockout)})
+
+	_, err := s.TPM.GetRandom(8)
+	c.Check(IsTPMWarning(err, WarningLockout, CommandGetRandom), testutil.IsTrue)
+	c.Check(s.FaultTCTI.Injected(), Equals, 1)
+}
+
+func (s *faultsSuite) TestSkip(c *C) {
+	s.FaultTCTI.AddRule(&testutil.FaultRule{
+		Command:      CommandGetRandom,
+		Fault:        testutil.FaultResponseCode,
+		Skip:         1,
+		Count:        1,
+		ResponseCode: ResponseCode(0x900) | ResponseCode(WarningLockout)})
+
+	_, err := s.TPM.GetRandom(8)
+	c.Check(err, IsNil)
+	_, err = s.TPM.GetRandom(8)
+	c.Check(IsTPMWarning(err, WarningLockout, CommandGetRandom), testutil.IsTrue)
+	_, err = s.TPM.GetRandom(8)
+	c.Check(err, IsNil)
+}
+
+func (s *faultsSuite) TestShortWrite(c *C) {
+	s.FaultTCTI.AddRule(&testutil.FaultRule{Command: CommandGetRandom, Fault: testutil.FaultShortWrite, Count: 1})
+
+	_, err := s.TPM.GetRandom(8)
+	c.Assert(err, FitsTypeOf, &TctiError{})
+	c.Check(err.(*TctiError).Op, Equals, "write")
+	c.Check(err.(*TctiError).Unwrap(), Equals, io.ErrShortWrite)
+
+	_, err = s.TPM.GetRandom(8)
+	c.Check(err, IsNil)
+}
+
+func (s *faultsSuite) TestShortRead(c *C) {
+	s.FaultTCTI.AddRule(&testutil.FaultRule{Command: CommandGetRandom, Fault: testutil.FaultShortRead})
+
+	b, err := s.TPM.GetRandom(32)
+	c.Check(err, IsNil)
+	c.Check(b, HasLen, 32)
+}
+
+func (s *faultsSuite) TestTruncateResponse(c *C) {
+	s.FaultTCTI.AddRule(&testutil.FaultRule{Command: CommandGetRandom, Fault: testutil.FaultTruncateResponse, Size: 4})
+
+	_, err := s.TPM.GetRandom(8)
+	c.Check(err, ErrorMatches, "TPM returned an invalid response for command TPM_CC_GetRandom: insufficient bytes for response "+
+		"payload \\(got 6, expected 10\\)")
+	c.Check(err, FitsTypeOf, &InvalidResponseError{})
+}
+
+func (s *faultsSuite) TestCorruptResponseHMAC(c *C) {
+	session, err := s.TPM.StartAuthSession(nil, nil, SessionTypeHMAC, nil, HashAlgorithmSHA256)
+	c.Assert(err, IsNil)
+	s.AddCleanupFlushContext(c, session)
+
+	s.FaultTCTI.AddRule(&testutil.FaultRule{Command: CommandGetRandom, Fault: testutil.FaultCorruptResponseHMAC})
+
+	_, err = s.TPM.GetRandom(8, session.WithAttrs(AttrContinueSession|AttrAudit))
+	c.Check(err, ErrorMatches, "TPM returned an invalid response for command TPM_CC_GetRandom: cannot process response auth "+
+		"area: .*")
+	c.Check(err, FitsTypeOf, &InvalidResponseError{})
+}
+
+func (s *faultsSuite) TestDelay(c *C) {
+	s.FaultTCTI.AddRule(&testutil.FaultRule{Command: CommandGetRandom, Fault: testutil.FaultDelay, Delay: 20 * time.Millisecond})
+
+	start := time.Now()
+	_, err := s.TPM.GetRandom(8)
+	c.Check(err, IsNil)
+	c.Check(time.Since(start) >= 20*time.Millisecond, testutil.IsTrue)
+}
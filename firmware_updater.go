@@ -0,0 +1,174 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+// FirmwareImage provides the blocks of a firmware image to a FirmwareUpdater. The format of firmware images is vendor specific,
+// and so this provides a way for vendor specific image formats to be plugged in to FirmwareUpdater.
+type FirmwareImage interface {
+	// NextBlock returns the next block of the image to send to the TPM with TPM2_FieldUpgradeData. The expected parameter is the
+	// digest of the block that the TPM expects to receive next. The returned block must not be larger than maxSize bytes.
+	// Implementations should return io.EOF when there are no more blocks.
+	NextBlock(expected *TaggedHash, maxSize int) ([]byte, error)
+}
+
+type firmwareImageReader struct {
+	r         io.Reader
+	blockSize int
+}
+
+func (r *firmwareImageReader) NextBlock(expected *TaggedHash, maxSize int) ([]byte, error) {
+	n := r.blockSize
+	if n <= 0 || n > maxSize {
+		n = maxSize
+	}
+
+	block := make([]byte, n)
+	n, err := io.ReadFull(r.r, block)
+	switch {
+	case err == io.EOF:
+		return nil, io.EOF
+	case err == io.ErrUnexpectedEOF:
+		// Last block is short
+	case err != nil:
+		return nil, err
+	}
+	return block[:n], nil
+}
+
+// NewFirmwareImageReader returns a FirmwareImage that splits the image read from r in to blocks of blockSize bytes, with the
+// exception of the last block which may be shorter. If blockSize is zero or larger than the maximum size supported by the TPM,
+// the image is split in to blocks of the maximum size supported by the TPM.
+func NewFirmwareImageReader(r io.Reader, blockSize int) FirmwareImage {
+	return &firmwareImageReader{r: r, blockSize: blockSize}
+}
+
+// FirmwareUpdateProgress describes the progress of a field upgrade performed by FirmwareUpdater.
+type FirmwareUpdateProgress struct {
+	Blocks      int         // The number of blocks accepted by the TPM so far
+	BytesSent   int64       // The number of bytes accepted by the TPM so far
+	NextDigest  *TaggedHash // The digest of the next block expected by the TPM, or HashAlgorithmNull if the upgrade is complete
+	FirstDigest *TaggedHash // The digest of the first block of the image, as returned by the TPM
+}
+
+// Complete indicates whether the TPM has received the entire firmware image.
+func (p *FirmwareUpdateProgress) Complete() bool {
+	return p.NextDigest != nil && p.NextDigest.HashAlg == HashAlgorithmNull
+}
+
+// FirmwareUpdater performs a complete field upgrade of the TPM firmware, using TPMContext.FieldUpgradeStart followed by
+// a sequence of TPMContext.FieldUpgradeData commands.
+type FirmwareUpdater struct {
+	tpm *TPMContext
+
+	// MaxBlockSize is the maximum size of a block passed to TPMContext.FieldUpgradeData. If this is zero, it is obtained from
+	// the PropertyInputBuffer property of the TPM before the upgrade begins.
+	MaxBlockSize int
+
+	// Progress is called each time a block is accepted by the TPM. Any vendor specific progress indication should be derived
+	// from the supplied FirmwareUpdateProgress.
+	Progress func(progress *FirmwareUpdateProgress)
+}
+
+// NewFirmwareUpdater returns a new FirmwareUpdater for the supplied TPMContext.
+func NewFirmwareUpdater(tpm *TPMContext) *FirmwareUpdater {
+	return &FirmwareUpdater{tpm: tpm}
+}
+
+// Update performs a field upgrade of the TPM firmware with the supplied image. The fuDigest, manifestSignature and keyContext
+// parameters are passed to TPMContext.FieldUpgradeStart, and fuDigest must correspond to the digest of the first block of the
+// image. The command requires knowledge of the authorization value for the platform hierarchy. The authContext parameter should
+// correspond to HandlePlatform, with session based authorization provided via authContextAuthSession.
+//
+// Each block of the image is obtained from image and then sent to the TPM with TPMContext.FieldUpgradeData. Before each block is
+// sent, its digest is checked against the digest that the TPM expects to receive next, and an error is returned without sending the
+// block if it doesn't match. The digest of the first block is checked using the digest algorithm associated with
+// manifestSignature. The digest of the first block returned by the TPM after each block is also checked against fuDigest, and an
+// error is returned if it doesn't match.
+//
+// If image has no more blocks before the TPM indicates that the upgrade is complete, an error is returned. Note that in this case,
+// the TPM will remain in field upgrade mode.
+func (u *FirmwareUpdater) Update(authContext, keyContext ResourceContext, fuDigest Digest, manifestSignature *Signature, image FirmwareImage, authContextAuthSession SessionContext, sessions ...SessionContext) error {
+	maxSize := u.MaxBlockSize
+	if maxSize == 0 {
+		props, err := u.tpm.GetCapabilityTPMProperties(PropertyInputBuffer, 1, sessions...)
+		if err != nil {
+			return xerrors.Errorf("cannot obtain maximum block size: %w", err)
+		}
+		if len(props) == 0 || props[0].Property != PropertyInputBuffer {
+			return &InvalidResponseError{Command: CommandGetCapability, msg: "expected TPM_PT_INPUT_BUFFER property"}
+		}
+		maxSize = int(props[0].Value)
+	}
+
+	expected := &TaggedHash{HashAlg: HashAlgorithmNull, Digest: fuDigest}
+	if manifestSignature != nil && manifestSignature.Signature != nil {
+		if scheme := manifestSignature.Signature.Any(); scheme != nil {
+			expected.HashAlg = scheme.HashAlg
+		}
+	}
+
+	firstExpected := *expected
+
+	if err := u.tpm.FieldUpgradeStart(authContext, keyContext, fuDigest, manifestSignature, authContextAuthSession, sessions...); err != nil {
+		return err
+	}
+
+	progress := new(FirmwareUpdateProgress)
+
+	for {
+		block, err := image.NextBlock(expected, maxSize)
+		switch {
+		case err == io.EOF:
+			return errors.New("firmware image ended before the TPM indicated that the upgrade is complete")
+		case err != nil:
+			return xerrors.Errorf("cannot obtain block %d of firmware image: %w", progress.Blocks, err)
+		}
+
+		if len(block) > maxSize {
+			return fmt.Errorf("block %d of firmware image is too large (%d bytes)", progress.Blocks, len(block))
+		}
+		if expected.HashAlg.Available() {
+			h := expected.HashAlg.NewHash()
+			h.Write(block)
+			if !bytes.Equal(h.Sum(nil), expected.Digest) {
+				return fmt.Errorf("block %d of firmware image has an unexpected digest", progress.Blocks)
+			}
+		}
+
+		next, first, err := u.tpm.FieldUpgradeData(block, sessions...)
+		if err != nil {
+			return xerrors.Errorf("cannot send block %d of firmware image: %w", progress.Blocks, err)
+		}
+		if first == nil || !bytes.Equal(first.Digest, firstExpected.Digest) ||
+			(firstExpected.HashAlg != HashAlgorithmNull && first.HashAlg != firstExpected.HashAlg) {
+			return fmt.Errorf("TPM returned an unexpected first block digest after block %d of firmware image", progress.Blocks)
+		}
+
+		progress.Blocks += 1
+		progress.BytesSent += int64(len(block))
+		progress.NextDigest = next
+		progress.FirstDigest = first
+		if u.Progress != nil {
+			p := *progress
+			u.Progress(&p)
+		}
+
+		if progress.Complete() {
+			break
+		}
+		expected = next
+	}
+
+	return nil
+}
@@ -0,0 +1,291 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+type scriptedTctiExchange struct {
+	command  []byte
+	response []byte
+}
+
+// scriptedTcti is a TCTI that checks that each command matches the next command in a script, and then
+// returns the associated response.
+type scriptedTcti struct {
+	script []scriptedTctiExchange
+	rsp    *bytes.Reader
+}
+
+func (t *scriptedTcti) Read(data []byte) (int, error) {
+	if t.rsp == nil {
+		return 0, io.EOF
+	}
+	return t.rsp.Read(data)
+}
+
+func (t *scriptedTcti) Write(data []byte) (int, error) {
+	if len(t.script) == 0 {
+		return 0, errors.New("unexpected command")
+	}
+	e := t.script[0]
+	t.script = t.script[1:]
+	if !bytes.Equal(data, e.command) {
+		return 0, fmt.Errorf("unexpected command %x (expected %x)", data, e.command)
+	}
+	t.rsp = bytes.NewReader(e.response)
+	return len(data), nil
+}
+
+func (t *scriptedTcti) Close() error {
+	return nil
+}
+
+func (t *scriptedTcti) SetLocality(locality uint8) error {
+	return nil
+}
+
+func (t *scriptedTcti) MakeSticky(handle Handle, sticky bool) error {
+	return nil
+}
+
+func makeScriptedPacket(c *C, tag StructTag, code uint32, body ...interface{}) []byte {
+	b, err := mu.MarshalToBytes(body...)
+	c.Assert(err, IsNil)
+	h, err := mu.MarshalToBytes(tag, uint32(10+len(b)), code)
+	c.Assert(err, IsNil)
+	return append(h, b...)
+}
+
+type firmwareUpdaterSuite struct {
+	testutil.BaseTest
+	tcti *scriptedTcti
+	tpm  *TPMContext
+	key  ResourceContext
+}
+
+var _ = Suite(&firmwareUpdaterSuite{})
+
+func (s *firmwareUpdaterSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	s.tcti = &scriptedTcti{}
+	s.tpm, _ = NewTPMContext(s.tcti)
+
+	pub := Public{
+		Type:    ObjectTypeRSA,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrSign,
+		Params: &PublicParamsU{
+			RSADetail: &RSAParams{
+				Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+				Scheme:    RSAScheme{Scheme: RSASchemeNull},
+				KeyBits:   2048}},
+		Unique: &PublicIDU{RSA: make(PublicKeyRSA, 256)}}
+	var err error
+	s.key, err = CreateObjectResourceContextFromPublic(0x80000001, &pub)
+	c.Assert(err, IsNil)
+}
+
+func (s *firmwareUpdaterSuite) makeImage(n int) (image []byte, blocks [][]byte) {
+	for i := 0; i < n; i++ {
+		block := bytes.Repeat([]byte{byte(i + 1)}, 16)
+		image = append(image, block...)
+		blocks = append(blocks, block)
+	}
+	return image, blocks
+}
+
+func (s *firmwareUpdaterSuite) addFieldUpgradeStart(c *C, fuDigest Digest, sig *Signature) {
+	s.tcti.script = append(s.tcti.script, scriptedTctiExchange{
+		command: makeScriptedPacket(c, TagSessions, uint32(CommandFieldUpgradeStart),
+			HandlePlatform, s.key.Handle(), uint32(9), HandlePW, uint16(0), uint8(1), uint16(0),
+			fuDigest, sig),
+		response: makeScriptedPacket(c, TagSessions, uint32(Success), uint32(0), uint16(0), uint8(1), uint16(0))})
+}
+
+func (s *firmwareUpdaterSuite) addFieldUpgradeData(c *C, block []byte, next, first Digest) {
+	var nextDigest []interface{}
+	if next == nil {
+		nextDigest = []interface{}{HashAlgorithmNull}
+	} else {
+		nextDigest = []interface{}{HashAlgorithmSHA256, mu.RawBytes(next)}
+	}
+	body := append([]interface{}{}, nextDigest...)
+	body = append(body, HashAlgorithmSHA256, mu.RawBytes(first))
+
+	s.tcti.script = append(s.tcti.script, scriptedTctiExchange{
+		command:  makeScriptedPacket(c, TagNoSessions, uint32(CommandFieldUpgradeData), MaxBuffer(block)),
+		response: makeScriptedPacket(c, TagNoSessions, uint32(Success), body...)})
+}
+
+func (s *firmwareUpdaterSuite) signature() *Signature {
+	return &Signature{
+		SigAlg: SigSchemeAlgRSASSA,
+		Signature: &SignatureU{
+			RSASSA: &SignatureRSASSA{Hash: HashAlgorithmSHA256, Sig: make(PublicKeyRSA, 256)}}}
+}
+
+func (s *firmwareUpdaterSuite) TestUpdate(c *C) {
+	image, blocks := s.makeImage(3)
+
+	var digests []Digest
+	for _, b := range blocks {
+		h := sha256.Sum256(b)
+		digests = append(digests, h[:])
+	}
+
+	sig := s.signature()
+	s.addFieldUpgradeStart(c, digests[0], sig)
+	s.addFieldUpgradeData(c, blocks[0], digests[1], digests[0])
+	s.addFieldUpgradeData(c, blocks[1], digests[2], digests[0])
+	s.addFieldUpgradeData(c, blocks[2], nil, digests[0])
+
+	var progress []FirmwareUpdateProgress
+	updater := NewFirmwareUpdater(s.tpm)
+	updater.MaxBlockSize = 1024
+	updater.Progress = func(p *FirmwareUpdateProgress) {
+		progress = append(progress, *p)
+	}
+
+	c.Check(updater.Update(s.tpm.PlatformHandleContext(), s.key, digests[0], sig, NewFirmwareImageReader(bytes.NewReader(image), 16), nil), IsNil)
+	c.Check(s.tcti.script, HasLen, 0)
+
+	c.Assert(progress, HasLen, 3)
+	for i, p := range progress {
+		c.Check(p.Blocks, Equals, i+1)
+		c.Check(p.BytesSent, Equals, int64(16*(i+1)))
+		c.Check(p.FirstDigest, DeepEquals, &TaggedHash{HashAlg: HashAlgorithmSHA256, Digest: digests[0]})
+		if i < 2 {
+			c.Check(p.Complete(), testutil.IsFalse)
+			c.Check(p.NextDigest, DeepEquals, &TaggedHash{HashAlg: HashAlgorithmSHA256, Digest: digests[i+1]})
+		} else {
+			c.Check(p.Complete(), testutil.IsTrue)
+		}
+	}
+}
+
+func (s *firmwareUpdaterSuite) TestUpdateDigestMismatch(c *C) {
+	image, blocks := s.makeImage(2)
+
+	d0 := sha256.Sum256(blocks[0])
+
+	sig := s.signature()
+	s.addFieldUpgradeStart(c, d0[:], sig)
+	s.addFieldUpgradeData(c, blocks[0], make(Digest, 32), d0[:])
+
+	updater := NewFirmwareUpdater(s.tpm)
+	updater.MaxBlockSize = 1024
+	err := updater.Update(s.tpm.PlatformHandleContext(), s.key, d0[:], sig, NewFirmwareImageReader(bytes.NewReader(image), 16), nil)
+	c.Check(err, ErrorMatches, "block 1 of firmware image has an unexpected digest")
+	c.Check(s.tcti.script, HasLen, 0)
+}
+
+func (s *firmwareUpdaterSuite) TestUpdateTruncatedImage(c *C) {
+	image, blocks := s.makeImage(2)
+
+	d0 := sha256.Sum256(blocks[0])
+	d1 := sha256.Sum256(blocks[1])
+
+	sig := s.signature()
+	s.addFieldUpgradeStart(c, d0[:], sig)
+	s.addFieldUpgradeData(c, blocks[0], d1[:], d0[:])
+
+	updater := NewFirmwareUpdater(s.tpm)
+	updater.MaxBlockSize = 1024
+	err := updater.Update(s.tpm.PlatformHandleContext(), s.key, d0[:], sig, NewFirmwareImageReader(bytes.NewReader(image[:16]), 16), nil)
+	c.Check(err, ErrorMatches, "firmware image ended before the TPM indicated that the upgrade is complete")
+	c.Check(s.tcti.script, HasLen, 0)
+}
+
+func (s *firmwareUpdaterSuite) TestUpdateFirstDigestMismatch(c *C) {
+	image, blocks := s.makeImage(2)
+
+	d0 := sha256.Sum256(blocks[0])
+	d1 := sha256.Sum256(blocks[1])
+
+	sig := s.signature()
+	s.addFieldUpgradeStart(c, d0[:], sig)
+	s.addFieldUpgradeData(c, blocks[0], d1[:], d1[:])
+
+	updater := NewFirmwareUpdater(s.tpm)
+	updater.MaxBlockSize = 1024
+	err := updater.Update(s.tpm.PlatformHandleContext(), s.key, d0[:], sig, NewFirmwareImageReader(bytes.NewReader(image), 16), nil)
+	c.Check(err, ErrorMatches, "TPM returned an unexpected first block digest after block 0 of firmware image")
+	c.Check(s.tcti.script, HasLen, 0)
+}
+
+func (s *firmwareUpdaterSuite) addGetInputBuffer(c *C, response []byte) {
+	s.tcti.script = append(s.tcti.script, scriptedTctiExchange{
+		command:  makeScriptedPacket(c, TagNoSessions, uint32(CommandGetCapability), CapabilityTPMProperties, PropertyInputBuffer, uint32(1)),
+		response: response})
+}
+
+func (s *firmwareUpdaterSuite) TestUpdateMaxBlockSizeFromTPM(c *C) {
+	image, blocks := s.makeImage(2)
+
+	d0 := sha256.Sum256(blocks[0])
+	d1 := sha256.Sum256(blocks[1])
+
+	s.addGetInputBuffer(c, makeScriptedPacket(c, TagNoSessions, uint32(Success), false, CapabilityTPMProperties,
+		TaggedTPMPropertyList{{Property: PropertyInputBuffer, Value: 16}}))
+	sig := s.signature()
+	s.addFieldUpgradeStart(c, d0[:], sig)
+	s.addFieldUpgradeData(c, blocks[0], d1[:], d0[:])
+	s.addFieldUpgradeData(c, blocks[1], nil, d0[:])
+
+	updater := NewFirmwareUpdater(s.tpm)
+	c.Check(updater.Update(s.tpm.PlatformHandleContext(), s.key, d0[:], sig, NewFirmwareImageReader(bytes.NewReader(image), 0), nil), IsNil)
+	c.Check(s.tcti.script, HasLen, 0)
+}
+
+func (s *firmwareUpdaterSuite) TestUpdateMaxBlockSizeFromTPMError(c *C) {
+	image, blocks := s.makeImage(1)
+
+	d0 := sha256.Sum256(blocks[0])
+
+	s.addGetInputBuffer(c, makeScriptedPacket(c, TagNoSessions, 0x1c4))
+
+	updater := NewFirmwareUpdater(s.tpm)
+	err := updater.Update(s.tpm.PlatformHandleContext(), s.key, d0[:], s.signature(), NewFirmwareImageReader(bytes.NewReader(image), 0), nil)
+	c.Check(err, ErrorMatches, "cannot obtain maximum block size: TPM returned an error for parameter 1 whilst executing command "+
+		"TPM_CC_GetCapability: TPM_RC_VALUE .*")
+	c.Check(IsTPMParameterError(err, ErrorValue, CommandGetCapability, 1), testutil.IsTrue)
+	c.Check(s.tcti.script, HasLen, 0)
+}
+
+func (s *firmwareUpdaterSuite) TestUpdateMaxBlockSizeFromTPMMissingProperty(c *C) {
+	image, blocks := s.makeImage(1)
+
+	d0 := sha256.Sum256(blocks[0])
+
+	s.addGetInputBuffer(c, makeScriptedPacket(c, TagNoSessions, uint32(Success), false, CapabilityTPMProperties,
+		TaggedTPMPropertyList{{Property: PropertyInputBuffer + 1, Value: 16}}))
+
+	updater := NewFirmwareUpdater(s.tpm)
+	err := updater.Update(s.tpm.PlatformHandleContext(), s.key, d0[:], s.signature(), NewFirmwareImageReader(bytes.NewReader(image), 0), nil)
+	c.Check(err, ErrorMatches, "TPM returned an invalid response for command TPM_CC_GetCapability: expected TPM_PT_INPUT_BUFFER property")
+	c.Check(s.tcti.script, HasLen, 0)
+}
+
+func (s *firmwareUpdaterSuite) TestFirmwareRead(c *C) {
+	s.tcti.script = append(s.tcti.script, scriptedTctiExchange{
+		command:  makeScriptedPacket(c, TagNoSessions, uint32(CommandFirmwareRead), uint32(2)),
+		response: makeScriptedPacket(c, TagNoSessions, uint32(Success), MaxBuffer("foo"))})
+
+	data, err := s.tpm.FirmwareRead(2)
+	c.Check(err, IsNil)
+	c.Check(data, DeepEquals, MaxBuffer("foo"))
+}
@@ -0,0 +1,162 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// CommandInfo describes a command that is being executed by TPMContext. It is passed to interceptors registered with
+// TPMContext.AddInterceptor.
+type CommandInfo struct {
+	CommandCode CommandCode
+	HandleNames []Name // The names of the command handles
+
+	// Sessions contains the sessions for the command, in the order in which they appear in the authorization area. A
+	// password authorization is represented by a nil entry.
+	Sessions []SessionContext
+
+	cpBytes []byte
+}
+
+// CpHash returns the command parameter digest for the command using the specified algorithm. This is computed from the
+// unencrypted command parameters, in the same way as ComputeCpHash.
+func (i *CommandInfo) CpHash(alg HashAlgorithmId) Digest {
+	return cryptComputeCpHash(alg, i.CommandCode, i.HandleNames, i.cpBytes)
+}
+
+// ResponseInfo describes the outcome of a command executed by TPMContext. It is passed to interceptors registered with
+// TPMContext.AddInterceptor.
+type ResponseInfo struct {
+	ResponseCode ResponseCode // The response code from the last submission of the command, or zero if no response was received
+
+	// Err is the error that will be returned to the caller, or nil if the command succeeded. If the command was rejected
+	// by an interceptor, this is the error returned from the BeforeCommand method of that interceptor.
+	Err error
+
+	// Duration is the time from when the command was about to be submitted to the TPM until the response was processed.
+	// This includes the time spent resubmitting the command if the TPM responded with a warning.
+	Duration time.Duration
+
+	commandCode CommandCode
+	rpBytes     []byte
+}
+
+// RpHash returns the response parameter digest for the command using the specified algorithm. This is computed from the
+// unencrypted response parameters. It returns nil if the command did not succeed.
+func (i *ResponseInfo) RpHash(alg HashAlgorithmId) Digest {
+	if i.Err != nil {
+		return nil
+	}
+	return cryptComputeRpHash(alg, i.ResponseCode, i.commandCode, i.rpBytes)
+}
+
+// Interceptor observes and optionally rejects commands executed by TPMContext. Interceptors are registered with
+// TPMContext.AddInterceptor, and can be used for purposes such as collecting metrics, enforcing a policy about which commands
+// may be executed, or audit logging.
+//
+// The methods of an Interceptor are called with the TPMContext's command lock held, so they must not execute commands on the
+// same TPMContext.
+type Interceptor interface {
+	// BeforeCommand is called after the command parameters have been marshalled, but before the command is submitted to the
+	// TPM. Returning an error prevents the command from being submitted, and the error will be wrapped and returned to the
+	// caller.
+	BeforeCommand(cmd *CommandInfo) error
+
+	// AfterResponse is called once the response to a command has been processed, or execution of the command has failed.
+	// It is called for every interceptor whose BeforeCommand method was called for the command, including one that rejected
+	// it.
+	AfterResponse(cmd *CommandInfo, rsp *ResponseInfo)
+}
+
+// AddInterceptor registers an interceptor that sees every command executed via TPMContext.RunCommand and the methods that are
+// built on it. BeforeCommand methods are called in the order in which the interceptors were registered, and AfterResponse
+// methods are called in the reverse order. Commands executed with TPMContext.RunCommandBytes are not intercepted.
+func (t *TPMContext) AddInterceptor(interceptor Interceptor) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.interceptors = append(t.interceptors, interceptor)
+}
+
+// interceptorChain tracks the interceptors that have seen a single command.
+type interceptorChain struct {
+	interceptors []Interceptor
+	called       int
+	cmd          CommandInfo
+	rsp          ResponseInfo
+	start        time.Time
+}
+
+// newInterceptorChain returns a new interceptorChain for the supplied command, or nil if there are no registered interceptors.
+func (t *TPMContext) newInterceptorChain(commandCode CommandCode, sessionParams *sessionParams) *interceptorChain {
+	t.mu.Lock()
+	interceptors := t.interceptors
+	t.mu.Unlock()
+
+	if len(interceptors) == 0 {
+		return nil
+	}
+
+	c := &interceptorChain{interceptors: interceptors, cmd: CommandInfo{CommandCode: commandCode}}
+	for _, s := range sessionParams.sessions {
+		if s.session == nil {
+			c.cmd.Sessions = append(c.cmd.Sessions, nil)
+		} else {
+			c.cmd.Sessions = append(c.cmd.Sessions, s.session)
+		}
+	}
+	return c
+}
+
+// before calls the BeforeCommand method of each interceptor, stopping if one of them rejects the command.
+func (c *interceptorChain) before(handleNames []Name, cpBytes []byte) error {
+	if c == nil {
+		return nil
+	}
+
+	c.cmd.HandleNames = handleNames
+	// Take a copy, as the parameters may be encrypted in place.
+	c.cmd.cpBytes = append([]byte(nil), cpBytes...)
+
+	for _, i := range c.interceptors {
+		c.called++
+		if err := i.BeforeCommand(&c.cmd); err != nil {
+			c.rsp.Err = err
+			return xerrors.Errorf("command %s was rejected by an interceptor: %w", c.cmd.CommandCode, err)
+		}
+	}
+	c.start = time.Now()
+	return nil
+}
+
+// responseReceived records the response code for the last submission of the command.
+func (c *interceptorChain) responseReceived(rc ResponseCode) {
+	if c == nil {
+		return
+	}
+	c.rsp.ResponseCode = rc
+}
+
+// after calls the AfterResponse method of each interceptor that has seen the command, in reverse order.
+func (c *interceptorChain) after(cmd *cmdContext, err error) {
+	if c == nil || c.called == 0 {
+		return
+	}
+
+	if !c.start.IsZero() {
+		c.rsp.Err = err
+		c.rsp.Duration = time.Since(c.start)
+	}
+	c.rsp.commandCode = c.cmd.CommandCode
+	if err == nil {
+		c.rsp.rpBytes = cmd.rpBytes
+	}
+
+	for i := c.called - 1; i >= 0; i-- {
+		c.interceptors[i].AfterResponse(&c.cmd, &c.rsp)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	. "gopkg.in/check.v1"
+
+	"golang.org/x/xerrors"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+type interceptorEvent struct {
+	name string
+	cmd  *CommandInfo
+	rsp  *ResponseInfo
+}
+
+type mockInterceptor struct {
+	name   string
+	events *[]interceptorEvent
+	veto   map[CommandCode]error
+}
+
+func (i *mockInterceptor) BeforeCommand(cmd *CommandInfo) error {
+	*i.events = append(*i.events, interceptorEvent{name: i.name + ".before", cmd: cmd})
+	return i.veto[cmd.CommandCode]
+}
+
+func (i *mockInterceptor) AfterResponse(cmd *CommandInfo, rsp *ResponseInfo) {
+	*i.events = append(*i.events, interceptorEvent{name: i.name + ".after", cmd: cmd, rsp: rsp})
+}
+
+type interceptorSuite struct {
+	testutil.TPMTest
+	events []interceptorEvent
+}
+
+var _ = Suite(&interceptorSuite{TPMTest: testutil.TPMTest{TPMFeatures: testutil.TPMFeatureChangeOwnerAuth}})
+
+func (s *interceptorSuite) SetUpTest(c *C) {
+	s.TPMTest.SetUpTest(c)
+	c.Check(s.TPM.InitProperties(), IsNil)
+	s.events = nil
+}
+
+func (s *interceptorSuite) addInterceptor(name string, veto map[CommandCode]error) {
+	s.TPM.AddInterceptor(&mockInterceptor{name: name, events: &s.events, veto: veto})
+}
+
+func (s *interceptorSuite) eventNames() (names []string) {
+	for _, e := range s.events {
+		names = append(names, e.name)
+	}
+	return names
+}
+
+func (s *interceptorSuite) TestOrder(c *C) {
+	s.addInterceptor("1", nil)
+	s.addInterceptor("2", nil)
+
+	_, err := s.TPM.GetRandom(8)
+	c.Check(err, IsNil)
+	c.Check(s.eventNames(), DeepEquals, []string{"1.before", "2.before", "2.after", "1.after"})
+}
+
+func (s *interceptorSuite) TestCommandInfo(c *C) {
+	s.addInterceptor("1", nil)
+
+	c.Check(s.TPM.HierarchyChangeAuth(s.TPM.OwnerHandleContext(), Auth("foo"), nil), IsNil)
+	s.AddCleanup(func() {
+		c.Check(s.TPM.HierarchyChangeAuth(s.TPM.OwnerHandleContext(), nil, nil), IsNil)
+	})
+	c.Assert(s.events, HasLen, 2)
+
+	cmd := s.events[0].cmd
+	c.Check(cmd.CommandCode, Equals, CommandHierarchyChangeAuth)
+	c.Check(cmd.HandleNames, DeepEquals, []Name{s.TPM.OwnerHandleContext().Name()})
+	c.Check(cmd.Sessions, DeepEquals, []SessionContext{nil})
+
+	expectedCpHash, err := ComputeCpHash(HashAlgorithmSHA256, CommandHierarchyChangeAuth, s.TPM.OwnerHandleContext(), Delimiter,
+		Auth("foo"))
+	c.Check(err, IsNil)
+	c.Check(cmd.CpHash(HashAlgorithmSHA256), DeepEquals, expectedCpHash)
+}
+
+func (s *interceptorSuite) TestResponseInfo(c *C) {
+	s.addInterceptor("1", nil)
+
+	b, err := s.TPM.GetRandom(8)
+	c.Check(err, IsNil)
+	c.Assert(s.events, HasLen, 2)
+
+	rsp := s.events[1].rsp
+	c.Check(rsp.ResponseCode, Equals, ResponseCode(Success))
+	c.Check(rsp.Err, IsNil)
+	c.Check(rsp.Duration > 0, testutil.IsTrue)
+
+	rpBytes, err := mu.MarshalToBytes(Digest(b))
+	c.Check(err, IsNil)
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, ResponseCode(Success))
+	binary.Write(h, binary.BigEndian, CommandGetRandom)
+	h.Write(rpBytes)
+	c.Check(rsp.RpHash(HashAlgorithmSHA256), DeepEquals, Digest(h.Sum(nil)))
+}
+
+func (s *interceptorSuite) TestErrorResponse(c *C) {
+	s.addInterceptor("1", nil)
+
+	_, err := s.TPM.GetCapability(Capability(0xff), 0, 1)
+	c.Check(err, NotNil)
+	c.Assert(s.events, HasLen, 2)
+
+	rsp := s.events[1].rsp
+	c.Check(rsp.ResponseCode, Not(Equals), ResponseCode(Success))
+	c.Check(rsp.Err, Equals, err)
+	c.Check(rsp.RpHash(HashAlgorithmSHA256), IsNil)
+}
+
+func (s *interceptorSuite) TestVeto(c *C) {
+	errNotAllowed := errors.New("not allowed")
+	s.addInterceptor("1", nil)
+	s.addInterceptor("2", map[CommandCode]error{CommandClear: errNotAllowed})
+	s.addInterceptor("3", nil)
+
+	err := s.TPM.Clear(s.TPM.LockoutHandleContext(), nil)
+	c.Check(err, ErrorMatches, "command TPM_CC_Clear was rejected by an interceptor: not allowed")
+	c.Check(xerrors.Is(err, errNotAllowed), testutil.IsTrue)
+	c.Check(s.eventNames(), DeepEquals, []string{"1.before", "2.before", "2.after", "1.after"})
+
+	rsp := s.events[3].rsp
+	c.Check(rsp.ResponseCode, Equals, ResponseCode(0))
+	c.Check(rsp.Err, Equals, errNotAllowed)
+}
@@ -0,0 +1,22 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package internal
+
+import (
+	"encoding/binary"
+)
+
+// packetHeaderSize is the size of the header of a command or response - a 2 byte tag, a 4 byte size and a 4 byte command or
+// response code.
+const packetHeaderSize = 10
+
+// IsCompletePacket indicates whether the supplied buffer contains a complete command or response, based on the size field in the
+// header.
+func IsCompletePacket(b []byte) bool {
+	if len(b) < packetHeaderSize {
+		return false
+	}
+	return uint32(len(b)) >= binary.BigEndian.Uint32(b[2:])
+}
@@ -0,0 +1,31 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package internal_test
+
+import (
+	"testing"
+
+	. "github.com/canonical/go-tpm2/internal"
+)
+
+func TestIsCompletePacket(t *testing.T) {
+	for _, data := range []struct {
+		desc     string
+		b        []byte
+		complete bool
+	}{
+		{desc: "Empty", b: nil},
+		{desc: "PartialHeader", b: []byte{0x80, 0x01, 0x00, 0x00, 0x00}},
+		{desc: "HeaderOnly", b: []byte{0x80, 0x01, 0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x01, 0x44}, complete: true},
+		{desc: "PartialBody", b: []byte{0x80, 0x01, 0x00, 0x00, 0x00, 0x0c, 0x00, 0x00, 0x01, 0x44, 0x00}},
+		{desc: "CompleteBody", b: []byte{0x80, 0x01, 0x00, 0x00, 0x00, 0x0c, 0x00, 0x00, 0x01, 0x44, 0x00, 0x00}, complete: true},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			if IsCompletePacket(data.b) != data.complete {
+				t.Errorf("Unexpected result")
+			}
+		})
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/canonical/go-tpm2/mu"
 
@@ -18,12 +20,14 @@ import (
 )
 
 const (
-	cmdPowerOn        uint32 = 1
-	cmdTPMSendCommand uint32 = 8
-	cmdNVOn           uint32 = 11
-	cmdReset          uint32 = 17
-	cmdSessionEnd     uint32 = 20
-	cmdStop           uint32 = 21
+	cmdPowerOn         uint32 = 1
+	cmdTPMSendCommand  uint32 = 8
+	cmdSignalCancelOn  uint32 = 9
+	cmdSignalCancelOff uint32 = 10
+	cmdNVOn            uint32 = 11
+	cmdReset           uint32 = 17
+	cmdSessionEnd      uint32 = 20
+	cmdStop            uint32 = 21
 )
 
 // PlatformCommandError corresponds to an error code in response to a platform command executed on a TPM simulator.
@@ -41,28 +45,45 @@ type TctiMssim struct {
 	locality uint8 // Locality of commands submitted to the simulator on this interface
 
 	tpm      net.Conn
-	platform net.Conn
+	platform net.Conn // Optional platform channel
 
-	buf *bytes.Reader
+	platformMu    sync.Mutex // Protects the platform channel, which may be used from another goroutine by Cancel
+	cancelPending bool       // The cancel signal has been asserted and needs to be cleared before the next command
+
+	frame []byte // The part of the current response frame that has been read from the TPM command channel so far
+	buf   *bytes.Reader
+}
+
+// readFrame reads from the TPM command channel until the current response frame contains n bytes. On error, the bytes that were
+// read are retained so that a read that is interrupted by a read deadline can be resumed later.
+func (t *TctiMssim) readFrame(n int) error {
+	start := len(t.frame)
+	if start >= n {
+		return nil
+	}
+	t.frame = append(t.frame, make([]byte, n-start)...)
+	m, err := io.ReadFull(t.tpm, t.frame[start:])
+	t.frame = t.frame[:start+m]
+	return err
 }
 
 func (t *TctiMssim) readMoreData() error {
-	var size uint32
-	if err := binary.Read(t.tpm, binary.BigEndian, &size); err != nil {
+	// The response frame consists of a 32-bit size, the response and 4 zero bytes.
+	if err := t.readFrame(4); err != nil {
 		return xerrors.Errorf("cannot read response size from TPM command channel: %w", err)
 	}
+	size := int(binary.BigEndian.Uint32(t.frame))
 
-	buf := make([]byte, size)
-	if _, err := io.ReadFull(t.tpm, buf); err != nil {
+	if err := t.readFrame(4 + size); err != nil {
 		return xerrors.Errorf("cannot read response from TPM command channel: %w", err)
 	}
 
-	t.buf = bytes.NewReader(buf)
-
-	var trash uint32
-	if err := binary.Read(t.tpm, binary.BigEndian, &trash); err != nil {
+	if err := t.readFrame(8 + size); err != nil {
 		return xerrors.Errorf("cannot read zero bytes from TPM command channel after response: %w", err)
 	}
+
+	t.buf = bytes.NewReader(t.frame[4 : 4+size])
+	t.frame = nil
 	return nil
 }
 
@@ -76,6 +97,10 @@ func (t *TctiMssim) Read(data []byte) (int, error) {
 }
 
 func (t *TctiMssim) Write(data []byte) (int, error) {
+	if err := t.clearCancel(); err != nil {
+		return 0, xerrors.Errorf("cannot clear cancel signal: %w", err)
+	}
+
 	buf, err := mu.MarshalToBytes(cmdTPMSendCommand, t.locality, uint32(len(data)), mu.RawBytes(data))
 	if err != nil {
 		panic(fmt.Sprintf("cannot marshal command: %v", err))
@@ -99,14 +124,18 @@ func sendSessionEnd(conn net.Conn) error {
 }
 
 func (t *TctiMssim) Close() (out error) {
-	if err := sendSessionEnd(t.platform); err != nil {
-		out = xerrors.Errorf("cannot send session end command on platform channel: %w", err)
+	if t.platform != nil {
+		if err := sendSessionEnd(t.platform); err != nil {
+			out = xerrors.Errorf("cannot send session end command on platform channel: %w", err)
+		}
 	}
 	if err := sendSessionEnd(t.tpm); err != nil {
 		out = xerrors.Errorf("cannot send session end command on TPM command channel: %w", err)
 	}
-	if err := t.platform.Close(); err != nil {
-		out = xerrors.Errorf("cannot close platform channel: %w", err)
+	if t.platform != nil {
+		if err := t.platform.Close(); err != nil {
+			out = xerrors.Errorf("cannot close platform channel: %w", err)
+		}
 	}
 	if err := t.tpm.Close(); err != nil {
 		out = xerrors.Errorf("cannot close TPM command channel: %w", err)
@@ -123,7 +152,50 @@ func (t *TctiMssim) MakeSticky(handle Handle, sticky bool) error {
 	return errors.New("not implemented")
 }
 
+// Cancel implements TCTICanceler.Cancel, and asserts the cancel signal on the platform channel. The signal is cleared before
+// the next command is submitted. This will fail if there is no platform channel.
+func (t *TctiMssim) Cancel() error {
+	t.platformMu.Lock()
+	defer t.platformMu.Unlock()
+
+	if err := t.platformCommandLocked(cmdSignalCancelOn); err != nil {
+		return err
+	}
+	t.cancelPending = true
+	return nil
+}
+
+func (t *TctiMssim) clearCancel() error {
+	t.platformMu.Lock()
+	defer t.platformMu.Unlock()
+
+	if !t.cancelPending {
+		return nil
+	}
+	if err := t.platformCommandLocked(cmdSignalCancelOff); err != nil {
+		return err
+	}
+	t.cancelPending = false
+	return nil
+}
+
+// SetReadDeadline implements TCTIReadDeadliner.SetReadDeadline, and sets the read deadline on the TPM command channel. If the
+// deadline expires part way through a response, the part that has been read is retained and the next Read continues from it.
+func (t *TctiMssim) SetReadDeadline(deadline time.Time) error {
+	return t.tpm.SetReadDeadline(deadline)
+}
+
 func (t *TctiMssim) platformCommand(cmd uint32) error {
+	t.platformMu.Lock()
+	defer t.platformMu.Unlock()
+	return t.platformCommandLocked(cmd)
+}
+
+func (t *TctiMssim) platformCommandLocked(cmd uint32) error {
+	if t.platform == nil {
+		return errors.New("no platform channel")
+	}
+
 	if err := binary.Write(t.platform, binary.BigEndian, cmd); err != nil {
 		return xerrors.Errorf("cannot send command: %w", err)
 	}
@@ -140,7 +212,7 @@ func (t *TctiMssim) platformCommand(cmd uint32) error {
 }
 
 // Reset submits the reset command on the platform connection, which initiates a reset of the TPM simulator and results in the
-// execution of _TPM_Init().
+// execution of _TPM_Init(). This will fail if there is no platform channel.
 func (t *TctiMssim) Reset() error {
 	return t.platformCommand(cmdReset)
 }
@@ -151,8 +223,10 @@ func sendStop(conn net.Conn) error {
 
 // Stop submits a stop command on both the TPM command and platform channels, which initiates a shutdown of the TPM simulator.
 func (t *TctiMssim) Stop() (out error) {
-	if err := sendStop(t.platform); err != nil {
-		out = xerrors.Errorf("cannot send stop command on platform channel: %w", err)
+	if t.platform != nil {
+		if err := sendStop(t.platform); err != nil {
+			out = xerrors.Errorf("cannot send stop command on platform channel: %w", err)
+		}
 	}
 	if err := sendStop(t.tpm); err != nil {
 		out = xerrors.Errorf("cannot send stop command on TPM command channel: %w", err)
@@ -160,6 +234,61 @@ func (t *TctiMssim) Stop() (out error) {
 	return nil
 }
 
+func newMssim(tpm, platform net.Conn) (*TctiMssim, error) {
+	tcti := &TctiMssim{
+		locality: 3,
+		tpm:      tpm,
+		platform: platform}
+
+	if platform == nil {
+		return tcti, nil
+	}
+
+	if err := tcti.platformCommand(cmdPowerOn); err != nil {
+		return nil, xerrors.Errorf("cannot complete power on command: %w", err)
+	}
+	if err := tcti.platformCommand(cmdNVOn); err != nil {
+		return nil, xerrors.Errorf("cannot complete NV on command: %w", err)
+	}
+
+	return tcti, nil
+}
+
+// NewMssimFromConn returns a new TctiMssim instance that uses the supplied connections to a TPM simulator. The tpm argument is
+// the connection to the TPM command server. The platform argument is the connection to the platform server, and is optional.
+// If it is provided, the simulator is powered on. If it is not provided, methods that require the platform channel will fail.
+//
+// The returned TctiMssim takes ownership of the supplied connections, and will close them when it is closed.
+func NewMssimFromConn(tpm, platform net.Conn) (*TctiMssim, error) {
+	if tpm == nil {
+		return nil, errors.New("no TPM command channel")
+	}
+	return newMssim(tpm, platform)
+}
+
+// OpenMssimUnix attempts to open a connection to a TPM simulator that is listening on Unix domain sockets. tpmPath is the path
+// of the socket for the TPM command server. platformPath is the path of the socket for the platform server. If platformPath is
+// an empty string, no platform channel is opened and methods that require it will fail.
+//
+// If successful, it returns a new TctiMssim instance which can be passed to NewTPMContext.
+func OpenMssimUnix(tpmPath, platformPath string) (*TctiMssim, error) {
+	tpm, err := net.Dial("unix", tpmPath)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot connect to TPM socket: %w", err)
+	}
+
+	var platform net.Conn
+	if platformPath != "" {
+		platform, err = net.Dial("unix", platformPath)
+		if err != nil {
+			tpm.Close()
+			return nil, xerrors.Errorf("cannot connect to platform socket: %w", err)
+		}
+	}
+
+	return newMssim(tpm, platform)
+}
+
 // OpenMssim attempts to open a connection to a TPM simulator on the specified host. tpmPort is the port on which the TPM command
 // server is listening. platformPort is the port on which the platform server is listening. If host is an empty string, it defaults
 // to "localhost".
@@ -170,31 +299,19 @@ func OpenMssim(host string, tpmPort, platformPort uint) (*TctiMssim, error) {
 		host = "localhost"
 	}
 
-	tpmAddress := fmt.Sprintf("%s:%d", host, tpmPort)
-	platformAddress := fmt.Sprintf("%s:%d", host, platformPort)
-
-	tcti := new(TctiMssim)
-	tcti.locality = 3
+	tpmAddress := net.JoinHostPort(host, fmt.Sprint(tpmPort))
+	platformAddress := net.JoinHostPort(host, fmt.Sprint(platformPort))
 
 	tpm, err := net.Dial("tcp", tpmAddress)
 	if err != nil {
 		return nil, xerrors.Errorf("cannot connect to TPM socket: %w", err)
 	}
-	tcti.tpm = tpm
 
 	platform, err := net.Dial("tcp", platformAddress)
 	if err != nil {
-		tcti.tpm.Close()
+		tpm.Close()
 		return nil, xerrors.Errorf("cannot connect to platform socket: %w", err)
 	}
-	tcti.platform = platform
 
-	if err := tcti.platformCommand(cmdPowerOn); err != nil {
-		return nil, xerrors.Errorf("cannot complete power on command: %w", err)
-	}
-	if err := tcti.platformCommand(cmdNVOn); err != nil {
-		return nil, xerrors.Errorf("cannot complete NV on command: %w", err)
-	}
-
-	return tcti, nil
+	return newMssim(tpm, platform)
 }
@@ -0,0 +1,107 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+type mssimSuite struct {
+	testutil.BaseTest
+}
+
+var _ = Suite(&mssimSuite{})
+
+func (s *mssimSuite) TestNoPlatformChannel(c *C) {
+	client, server := net.Pipe()
+	tcti, err := NewMssimFromConn(client, nil)
+	c.Assert(err, IsNil)
+
+	go func() {
+		var cmd uint32
+		var locality uint8
+		var size uint32
+		_, err := mu.UnmarshalFromReader(server, &cmd, &locality, &size)
+		c.Check(err, IsNil)
+		c.Check(cmd, Equals, uint32(8))
+		c.Check(locality, Equals, uint8(3))
+		_, err = io.ReadFull(server, make([]byte, size))
+		c.Check(err, IsNil)
+
+		rsp := makeScriptedPacket(c, TagSessions, uint32(Success), uint32(0), uint16(0), uint8(1), uint16(0))
+		_, err = mu.MarshalToWriter(server, uint32(len(rsp)), mu.RawBytes(rsp), uint32(0))
+		c.Check(err, IsNil)
+
+		_, err = mu.UnmarshalFromReader(server, &cmd)
+		c.Check(err, IsNil)
+		c.Check(cmd, Equals, uint32(20))
+	}()
+
+	tpm, _ := NewTPMContext(tcti)
+	c.Check(tpm.Clear(tpm.LockoutHandleContext(), nil), IsNil)
+	c.Check(tcti.Reset(), ErrorMatches, "no platform channel")
+	c.Check(tcti.Cancel(), ErrorMatches, "no platform channel")
+	c.Check(tpm.Close(), IsNil)
+}
+
+func (s *mssimSuite) TestPlatformChannelPowersOn(c *C) {
+	client, _ := net.Pipe()
+	platformClient, platformServer := net.Pipe()
+
+	go func() {
+		for _, expected := range []uint32{1, 11} {
+			var cmd uint32
+			c.Check(binary.Read(platformServer, binary.BigEndian, &cmd), IsNil)
+			c.Check(cmd, Equals, expected)
+			c.Check(binary.Write(platformServer, binary.BigEndian, uint32(0)), IsNil)
+		}
+	}()
+
+	tcti, err := NewMssimFromConn(client, platformClient)
+	c.Assert(err, IsNil)
+	c.Check(tcti, NotNil)
+}
+
+func (s *mssimSuite) TestReadDeadlineMidResponse(c *C) {
+	client, server := net.Pipe()
+	tcti, err := NewMssimFromConn(client, nil)
+	c.Assert(err, IsNil)
+
+	rsp := makeScriptedPacket(c, TagNoSessions, uint32(Success), Digest(make([]byte, 16)))
+	frame, err := mu.MarshalToBytes(uint32(len(rsp)), mu.RawBytes(rsp), uint32(0))
+	c.Assert(err, IsNil)
+
+	resume := make(chan struct{})
+	go func() {
+		_, err := server.Write(frame[:16])
+		c.Check(err, IsNil)
+		<-resume
+		_, err = server.Write(frame[16:])
+		c.Check(err, IsNil)
+	}()
+
+	c.Check(tcti.SetReadDeadline(time.Now().Add(10*time.Millisecond)), IsNil)
+	_, err = tcti.Read(make([]byte, len(rsp)))
+	c.Check(errors.Is(err, os.ErrDeadlineExceeded), testutil.IsTrue)
+
+	// The next read should continue from where the previous one stopped.
+	c.Check(tcti.SetReadDeadline(time.Time{}), IsNil)
+	close(resume)
+	data := make([]byte, len(rsp))
+	_, err = io.ReadFull(tcti, data)
+	c.Check(err, IsNil)
+	c.Check(data, DeepEquals, rsp)
+}
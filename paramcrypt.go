@@ -18,6 +18,96 @@ func isParamEncryptable(param interface{}) bool {
 	return mu.DetermineTPMKind(param) == mu.TPMKindSized
 }
 
+// sensitiveCommandParams contains the commands for which the first command parameter is sensitive and must be encrypted in
+// strict mode.
+var sensitiveCommandParams = map[CommandCode]bool{
+	CommandNVDefineSpace:       true,
+	CommandHierarchyChangeAuth: true,
+	CommandCreatePrimary:       true,
+	CommandNVChangeAuth:        true,
+	CommandDuplicate:           true,
+	CommandObjectChangeAuth:    true,
+	CommandCreate:              true,
+	CommandImport:              true,
+	CommandHMACStart:           true,
+	CommandLoadExternal:        true,
+	CommandHashSequenceStart:   true,
+	CommandCreateLoaded:        true}
+
+// sensitiveResponseParams contains the commands for which the first response parameter is sensitive and must be encrypted in
+// strict mode.
+var sensitiveResponseParams = map[CommandCode]bool{
+	CommandActivateCredential: true,
+	CommandDuplicate:          true,
+	CommandECDHKeyGen:         true,
+	CommandECDHZGen:           true,
+	CommandRSADecrypt:         true,
+	CommandUnseal:             true,
+	CommandZGen2Phase:         true}
+
+// isSessionSaltedOrBound indicates whether the supplied session is salted or bound. If it is neither, then its session key is
+// empty and anything derived from it can be computed by an adversary that can observe the communication with the TPM.
+func isSessionSaltedOrBound(s *sessionParam) bool {
+	return len(s.session.Data().SessionKey) > 0
+}
+
+// checkSessionProtectsParam checks that the supplied session protects the parameter that it is used to encrypt from an adversary
+// that can observe the communication with the TPM.
+func checkSessionProtectsParam(s *sessionParam) error {
+	if !isSessionSaltedOrBound(s) {
+		return errors.New("the session used for parameter encryption is unsalted and unbound")
+	}
+	return nil
+}
+
+// checkStrictParamEncryption returns an error if the command has a sensitive command or response parameter that would not be
+// protected by a suitable session based parameter encryption. A sensitive command parameter with a zero size is permitted. It
+// also returns an error if a command with a sensitive command parameter uses a password authorization, as the authorization
+// value is sent to the TPM in the clear, and if any HMAC session is unsalted and unbound, as the HMAC key for such a session is
+// derived only from the authorization value of the associated resource and values that are exchanged with the TPM.
+func (p *sessionParams) checkStrictParamEncryption(commandCode CommandCode, cpBytes []byte) error {
+	if sensitiveCommandParams[commandCode] && len(cpBytes) >= 2 && binary.BigEndian.Uint16(cpBytes) > 0 {
+		s, _ := p.findDecryptSession()
+		if s == nil {
+			return &UnprotectedParameterError{Command: commandCode, msg: "the first command parameter must be encrypted"}
+		}
+		if err := checkSessionProtectsParam(s); err != nil {
+			return &UnprotectedParameterError{Command: commandCode, msg: err.Error()}
+		}
+	}
+
+	if sensitiveResponseParams[commandCode] {
+		s, _ := p.findEncryptSession()
+		if s == nil {
+			return &UnprotectedParameterError{Command: commandCode, Response: true, msg: "the first response parameter must be encrypted"}
+		}
+		if err := checkSessionProtectsParam(s); err != nil {
+			return &UnprotectedParameterError{Command: commandCode, Response: true, msg: err.Error()}
+		}
+	}
+
+	if sensitiveCommandParams[commandCode] {
+		for i, s := range p.sessions {
+			if s.session == nil {
+				return &UnprotectedParameterError{Command: commandCode,
+					msg: fmt.Sprintf("the authorization at index %d is a password authorization", i)}
+			}
+		}
+	}
+
+	for i, s := range p.sessions {
+		if s.session == nil || s.session.Data().SessionType != SessionTypeHMAC {
+			continue
+		}
+		if !isSessionSaltedOrBound(s) {
+			return &UnprotectedParameterError{Command: commandCode,
+				msg: fmt.Sprintf("the HMAC session at index %d is unsalted and unbound", i)}
+		}
+	}
+
+	return nil
+}
+
 func (s *sessionParam) computeSessionValue() []byte {
 	var key []byte
 	key = append(key, s.session.Data().SessionKey...)
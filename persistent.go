@@ -0,0 +1,233 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/xerrors"
+)
+
+// HandleRange describes an inclusive range of handles.
+type HandleRange struct {
+	First Handle
+	Last  Handle
+}
+
+// Contains indicates whether the specified handle is within this range.
+func (r HandleRange) Contains(handle Handle) bool {
+	return handle >= r.First && handle <= r.Last
+}
+
+func (r HandleRange) String() string {
+	return fmt.Sprintf("0x%08x-0x%08x", uint32(r.First), uint32(r.Last))
+}
+
+// Ranges of persistent object and NV index handles, as assigned by the TCG Registry of Reserved TPM 2.0 Handles and Localities.
+var (
+	PersistentOwnerRange           = HandleRange{0x81000000, 0x817fffff} // Persistent objects in the owner and endorsement hierarchies
+	PersistentSRKRange             = HandleRange{0x81000000, 0x8100ffff} // Storage primary keys (SRK)
+	PersistentEKRange              = HandleRange{0x81010000, 0x8101ffff} // Endorsement primary keys (EK)
+	PersistentPlatformRange        = HandleRange{0x81800000, 0x81ffffff} // Persistent objects in the platform hierarchy
+	PersistentPlatformPrimaryRange = HandleRange{0x81800000, 0x8180ffff} // Platform primary keys
+
+	NVOwnerRange               = HandleRange{0x01000000, 0x013fffff} // NV indices defined by the owner
+	NVPlatformRange            = HandleRange{0x01400000, 0x017fffff} // NV indices defined by the platform
+	NVEKCertificateRange       = HandleRange{0x01c00000, 0x01c07fff} // EK certificates and templates
+	NVPlatformCertificateRange = HandleRange{0x01c08000, 0x01c0ffff} // Platform certificates
+)
+
+// reservedHandleRanges are ranges that are reserved for specific keys within the ranges assigned to a hierarchy. Handles in these
+// ranges are skipped when allocating from a range that contains them, so they are only allocated when the caller requests one of
+// these ranges explicitly.
+var reservedHandleRanges = []HandleRange{PersistentSRKRange, PersistentEKRange, PersistentPlatformPrimaryRange}
+
+// ErrNoFreeHandle is returned from TPMContext.NextFreePersistentHandle, TPMContext.NextFreeNVIndex and TPMContext.PersistObject
+// if every handle in the requested range is in use.
+var ErrNoFreeHandle = errors.New("no free handle in the requested range")
+
+// PersistentObject describes a persistent object on the TPM.
+type PersistentObject struct {
+	Context ResourceContext
+	Public  *Public
+}
+
+// NVIndex describes a NV index on the TPM.
+type NVIndex struct {
+	Context ResourceContext
+	Public  *NVPublic
+}
+
+// listResourceContexts returns a ResourceContext for every resource on the TPM of the specified type. Resources that disappear
+// between listing the handles and reading their public areas are omitted.
+func (t *TPMContext) listResourceContexts(handleType HandleType, sessions ...SessionContext) (out []ResourceContext, err error) {
+	handles, err := t.GetCapabilityHandles(handleType.BaseHandle(), CapabilityMaxProperties, sessions...)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot obtain handles: %w", err)
+	}
+
+	for _, h := range handles {
+		if h.Type() != handleType {
+			break
+		}
+		rc, err := t.CreateResourceContextFromTPM(h, sessions...)
+		switch {
+		case IsResourceUnavailableError(err, h):
+			continue
+		case err != nil:
+			return nil, xerrors.Errorf("cannot create context for handle %v: %w", h, err)
+		}
+		out = append(out, rc)
+	}
+
+	return out, nil
+}
+
+// ListPersistentObjects returns every persistent object on the TPM along with its public area. If this function is called with
+// sessions, they are used to obtain an assurance that each object actually lives on the TPM - see
+// TPMContext.CreateResourceContextFromTPM.
+func (t *TPMContext) ListPersistentObjects(sessions ...SessionContext) (objects []PersistentObject, err error) {
+	contexts, err := t.listResourceContexts(HandleTypePersistent, sessions...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rc := range contexts {
+		public, err := rc.(*objectContext).GetPublic().copy()
+		if err != nil {
+			return nil, fmt.Errorf("cannot copy public area of object %v: %v", rc.Handle(), err)
+		}
+		objects = append(objects, PersistentObject{Context: rc, Public: public})
+	}
+	return objects, nil
+}
+
+// ListNVIndices returns every NV index on the TPM along with its public area. If this function is called with sessions, they are
+// used to obtain an assurance that each index actually lives on the TPM - see TPMContext.CreateResourceContextFromTPM.
+func (t *TPMContext) ListNVIndices(sessions ...SessionContext) (indices []NVIndex, err error) {
+	contexts, err := t.listResourceContexts(HandleTypeNVIndex, sessions...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rc := range contexts {
+		public := *rc.(*nvIndexContext).GetPublic()
+		public.AuthPolicy = make(Digest, len(public.AuthPolicy))
+		copy(public.AuthPolicy, rc.(*nvIndexContext).GetPublic().AuthPolicy)
+		indices = append(indices, NVIndex{Context: rc, Public: &public})
+	}
+	return indices, nil
+}
+
+// skipReservedHandles returns the first handle from the supplied handle that isn't in one of the reserved ranges, ignoring any
+// reserved range that contains all of r.
+func skipReservedHandles(handle Handle, r HandleRange) Handle {
+	for _, reserved := range reservedHandleRanges {
+		if reserved.Contains(r.First) && reserved.Contains(r.Last) {
+			continue
+		}
+		if reserved.Contains(handle) {
+			handle = reserved.Last + 1
+		}
+	}
+	return handle
+}
+
+// nextFreeHandle returns the first handle in the specified range that isn't in use. Handles in reserved ranges are skipped
+// unless r is within the reserved range.
+func (t *TPMContext) nextFreeHandle(r HandleRange, handleType HandleType, sessions ...SessionContext) (Handle, error) {
+	if r.First.Type() != handleType || r.Last.Type() != handleType || r.First > r.Last {
+		return HandleUnassigned, makeInvalidArgError("r", fmt.Sprintf("not a valid range of %v handles", handleType))
+	}
+
+	handles, err := t.GetCapabilityHandles(r.First, CapabilityMaxProperties, sessions...)
+	if err != nil {
+		return HandleUnassigned, xerrors.Errorf("cannot obtain handles: %w", err)
+	}
+
+	candidate := skipReservedHandles(r.First, r)
+	for _, h := range handles {
+		if candidate > r.Last {
+			break
+		}
+		if h < candidate {
+			continue
+		}
+		if h != candidate {
+			break
+		}
+		candidate = skipReservedHandles(candidate+1, r)
+	}
+
+	if candidate > r.Last {
+		return HandleUnassigned, ErrNoFreeHandle
+	}
+	return candidate, nil
+}
+
+// NextFreePersistentHandle returns the lowest persistent handle in the specified range that isn't currently in use. The range
+// should normally be one of the ranges reserved for the intended hierarchy and purpose of the object, such as PersistentSRKRange or
+// PersistentOwnerRange. If every handle in the range is in use, ErrNoFreeHandle is returned.
+//
+// The ranges reserved for specific keys (PersistentSRKRange, PersistentEKRange and PersistentPlatformPrimaryRange) are skipped
+// when allocating from a larger range that contains them, such as PersistentOwnerRange. A handle from one of these ranges is
+// only returned if r is within that range.
+//
+// As the TPM may be shared with other processes, the returned handle may be in use by the time it is used. TPMContext.PersistObject
+// handles this.
+func (t *TPMContext) NextFreePersistentHandle(r HandleRange, sessions ...SessionContext) (Handle, error) {
+	return t.nextFreeHandle(r, HandleTypePersistent, sessions...)
+}
+
+// NextFreeNVIndex returns the lowest NV index handle in the specified range that isn't currently in use. The range should
+// normally be one of the ranges reserved for the intended hierarchy, such as NVOwnerRange or NVPlatformRange. If every handle in
+// the range is in use, ErrNoFreeHandle is returned.
+func (t *TPMContext) NextFreeNVIndex(r HandleRange, sessions ...SessionContext) (Handle, error) {
+	return t.nextFreeHandle(r, HandleTypeNVIndex, sessions...)
+}
+
+// PersistObject persists the transient object associated with object to the lowest unused handle in the specified range, using
+// TPMContext.EvictControl. It will never replace an existing persistent object - if another process persists an object at the
+// selected handle first, the next unused handle is tried. If every handle in the range is in use, ErrNoFreeHandle is returned.
+// Handles are selected in the same way as TPMContext.NextFreePersistentHandle, so the ranges reserved for specific keys are
+// only used if r is within one of them.
+//
+// The auth parameter should correspond to HandleOwner for objects within the storage or endorsement hierarchies, in which case r
+// should be within PersistentOwnerRange, or HandlePlatform for objects within the platform hierarchy, in which case r should be
+// within PersistentPlatformRange. The auth handle requires authorization with the user auth role, with session based
+// authorization provided via authAuthSession.
+//
+// On success, a ResourceContext corresponding to the persistent object is returned.
+func (t *TPMContext) PersistObject(auth, object ResourceContext, r HandleRange, authAuthSession SessionContext, sessions ...SessionContext) (ResourceContext, error) {
+	if object == nil {
+		return nil, makeInvalidArgError("object", "nil value")
+	}
+	if object.Handle().Type() != HandleTypeTransient {
+		return nil, makeInvalidArgError("object", "not a transient object")
+	}
+
+	last := HandleUnassigned
+	for {
+		handle, err := t.NextFreePersistentHandle(r, sessions...)
+		if err != nil {
+			return nil, err
+		}
+		if handle == last {
+			return nil, fmt.Errorf("handle %v is reported as both free and in use", handle)
+		}
+		last = handle
+
+		rc, err := t.EvictControl(auth, object, handle, authAuthSession, sessions...)
+		switch {
+		case IsTPMError(err, ErrorNVDefined, CommandEvictControl):
+			// Another process persisted an object at this handle. Try again.
+			continue
+		case err != nil:
+			return nil, err
+		}
+		return rc, nil
+	}
+}
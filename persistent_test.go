@@ -0,0 +1,209 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+// raceInterceptor calls a function before the first TPM2_EvictControl command is executed.
+type raceInterceptor struct {
+	fn func() error
+}
+
+func (i *raceInterceptor) BeforeCommand(cmd *CommandInfo) error {
+	if cmd.CommandCode != CommandEvictControl || i.fn == nil {
+		return nil
+	}
+	fn := i.fn
+	i.fn = nil
+	return fn()
+}
+
+func (*raceInterceptor) AfterResponse(cmd *CommandInfo, rsp *ResponseInfo) {}
+
+type persistentSuite struct {
+	testutil.TPMTest
+}
+
+var _ = Suite(&persistentSuite{TPMTest: testutil.TPMTest{TPMFeatures: testutil.TPMFeatureOwnerPersist}})
+
+// addCleanupPersistentObject ensures that the supplied persistent object is evicted at the end of the test.
+func (s *persistentSuite) addCleanupPersistentObject(c *C, object ResourceContext) {
+	s.AddCleanup(func() {
+		_, err := s.TPM.EvictControl(s.TPM.OwnerHandleContext(), object, object.Handle(), nil)
+		c.Check(err, IsNil)
+	})
+}
+
+func (s *persistentSuite) createPrimary(c *C, tpm *TPMContext) ResourceContext {
+	template := Public{
+		Type:    ObjectTypeECC,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrNoDA | AttrRestricted | AttrDecrypt,
+		Params: &PublicParamsU{
+			ECCDetail: &ECCParams{
+				Symmetric: SymDefObject{
+					Algorithm: SymObjectAlgorithmAES,
+					KeyBits:   &SymKeyBitsU{Sym: 128},
+					Mode:      &SymModeU{Sym: SymModeCFB}},
+				Scheme:  ECCScheme{Scheme: ECCSchemeNull},
+				CurveID: ECCCurveNIST_P256,
+				KDF:     KDFScheme{Scheme: KDFAlgorithmNull}}}}
+	primary, _, _, _, _, err := tpm.CreatePrimary(tpm.OwnerHandleContext(), nil, &template, nil, nil, nil)
+	c.Assert(err, IsNil)
+	s.AddCleanupFlushContext(c, primary)
+	return primary
+}
+
+func (s *persistentSuite) TestHandleRangeContains(c *C) {
+	c.Check(PersistentSRKRange.Contains(0x81000001), testutil.IsTrue)
+	c.Check(PersistentSRKRange.Contains(0x81010001), testutil.IsFalse)
+	c.Check(PersistentEKRange.Contains(0x81010001), testutil.IsTrue)
+	c.Check(NVEKCertificateRange.Contains(0x01c00002), testutil.IsTrue)
+}
+
+func (s *persistentSuite) TestPersistObject(c *C) {
+	primary := s.createPrimary(c, s.TPM)
+
+	persistent1, err := s.TPM.PersistObject(s.TPM.OwnerHandleContext(), primary, PersistentSRKRange, nil)
+	c.Assert(err, IsNil)
+	s.addCleanupPersistentObject(c, persistent1)
+	c.Check(persistent1.Handle(), Equals, Handle(0x81000000))
+	c.Check(persistent1.Name(), DeepEquals, primary.Name())
+
+	persistent2, err := s.TPM.PersistObject(s.TPM.OwnerHandleContext(), primary, PersistentSRKRange, nil)
+	c.Assert(err, IsNil)
+	s.addCleanupPersistentObject(c, persistent2)
+	c.Check(persistent2.Handle(), Equals, Handle(0x81000001))
+
+	handle, err := s.TPM.NextFreePersistentHandle(PersistentSRKRange)
+	c.Check(err, IsNil)
+	c.Check(handle, Equals, Handle(0x81000002))
+}
+
+func (s *persistentSuite) TestNextFreePersistentHandleSkipsReservedRanges(c *C) {
+	for _, data := range []struct {
+		r        HandleRange
+		expected Handle
+	}{
+		{r: PersistentOwnerRange, expected: 0x81020000},
+		{r: PersistentPlatformRange, expected: 0x81810000},
+		{r: HandleRange{0x8100fffe, 0x81020001}, expected: 0x81020000},
+		{r: PersistentSRKRange, expected: 0x81000000},
+		{r: PersistentEKRange, expected: 0x81010000},
+		{r: HandleRange{0x81000010, 0x81000020}, expected: 0x81000010},
+	} {
+		handle, err := s.TPM.NextFreePersistentHandle(data.r)
+		c.Check(err, IsNil)
+		c.Check(handle, Equals, data.expected, Commentf("range %v", data.r))
+	}
+}
+
+func (s *persistentSuite) TestPersistObjectOwnerRange(c *C) {
+	primary := s.createPrimary(c, s.TPM)
+
+	persistent, err := s.TPM.PersistObject(s.TPM.OwnerHandleContext(), primary, PersistentOwnerRange, nil)
+	c.Assert(err, IsNil)
+	s.addCleanupPersistentObject(c, persistent)
+	c.Check(persistent.Handle(), Equals, Handle(0x81020000))
+
+	persistent, err = s.TPM.PersistObject(s.TPM.OwnerHandleContext(), primary, PersistentOwnerRange, nil)
+	c.Assert(err, IsNil)
+	s.addCleanupPersistentObject(c, persistent)
+	c.Check(persistent.Handle(), Equals, Handle(0x81020001))
+}
+
+func (s *persistentSuite) TestNextFreePersistentHandleReservedRangeOnly(c *C) {
+	_, err := s.TPM.NextFreePersistentHandle(HandleRange{0x81000000, 0x8101ffff})
+	c.Check(err, Equals, ErrNoFreeHandle)
+}
+
+func (s *persistentSuite) TestPersistObjectRace(c *C) {
+	other, _ := NewTPMContext(s.TCTI)
+	otherPrimary := s.createPrimary(c, other)
+	primary := s.createPrimary(c, s.TPM)
+
+	// Persist an object from another TPMContext just before this one executes TPM2_EvictControl for the first time.
+	s.TPM.AddInterceptor(&raceInterceptor{fn: func() error {
+		persistent, err := other.EvictControl(other.OwnerHandleContext(), otherPrimary, 0x81000000, nil)
+		if err == nil {
+			s.addCleanupPersistentObject(c, persistent)
+		}
+		return err
+	}})
+
+	persistent, err := s.TPM.PersistObject(s.TPM.OwnerHandleContext(), primary, PersistentSRKRange, nil)
+	c.Assert(err, IsNil)
+	s.addCleanupPersistentObject(c, persistent)
+	c.Check(persistent.Handle(), Equals, Handle(0x81000001))
+}
+
+func (s *persistentSuite) TestPersistObjectNoFreeHandle(c *C) {
+	primary := s.createPrimary(c, s.TPM)
+	r := HandleRange{0x81000000, 0x81000000}
+
+	persistent, err := s.TPM.PersistObject(s.TPM.OwnerHandleContext(), primary, r, nil)
+	c.Assert(err, IsNil)
+	s.addCleanupPersistentObject(c, persistent)
+
+	_, err = s.TPM.PersistObject(s.TPM.OwnerHandleContext(), primary, r, nil)
+	c.Check(err, Equals, ErrNoFreeHandle)
+}
+
+func (s *persistentSuite) TestNextFreePersistentHandleInvalidRange(c *C) {
+	_, err := s.TPM.NextFreePersistentHandle(NVOwnerRange)
+	c.Check(err, ErrorMatches, "invalid r argument: not a valid range of .* handles")
+}
+
+func (s *persistentSuite) TestListPersistentObjects(c *C) {
+	primary := s.createPrimary(c, s.TPM)
+	public, _, _, err := s.TPM.ReadPublic(primary)
+	c.Assert(err, IsNil)
+
+	persistent, err := s.TPM.EvictControl(s.TPM.OwnerHandleContext(), primary, 0x81000001, nil)
+	c.Assert(err, IsNil)
+	s.addCleanupPersistentObject(c, persistent)
+	persistent, err = s.TPM.EvictControl(s.TPM.OwnerHandleContext(), primary, 0x81010001, nil)
+	c.Assert(err, IsNil)
+	s.addCleanupPersistentObject(c, persistent)
+
+	objects, err := s.TPM.ListPersistentObjects()
+	c.Assert(err, IsNil)
+	c.Assert(objects, HasLen, 2)
+	c.Check(objects[0].Context.Handle(), Equals, Handle(0x81000001))
+	c.Check(objects[0].Context.Name(), DeepEquals, primary.Name())
+	c.Check(objects[0].Public, DeepEquals, public)
+	c.Check(objects[1].Context.Handle(), Equals, Handle(0x81010001))
+}
+
+func (s *persistentSuite) TestNVIndices(c *C) {
+	index, err := s.TPM.NextFreeNVIndex(NVOwnerRange)
+	c.Assert(err, IsNil)
+	c.Check(index, Equals, Handle(0x01000000))
+
+	pub := NVPublic{
+		Index:   index,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   NVTypeOrdinary.WithAttrs(AttrNVAuthWrite | AttrNVAuthRead),
+		Size:    8}
+	indexContext, err := s.TPM.NVDefineSpace(s.TPM.OwnerHandleContext(), nil, &pub, nil)
+	c.Assert(err, IsNil)
+	s.AddCleanupNVSpace(c, s.TPM.OwnerHandleContext(), indexContext)
+
+	index, err = s.TPM.NextFreeNVIndex(NVOwnerRange)
+	c.Check(err, IsNil)
+	c.Check(index, Equals, Handle(0x01000001))
+
+	indices, err := s.TPM.ListNVIndices()
+	c.Assert(err, IsNil)
+	c.Assert(indices, HasLen, 1)
+	c.Check(indices[0].Context.Handle(), Equals, pub.Index)
+	c.Check(indices[0].Public.Size, Equals, pub.Size)
+	c.Check(indices[0].Public.Attrs, Equals, pub.Attrs)
+}
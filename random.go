@@ -0,0 +1,43 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"crypto/rand"
+	"io"
+	"sync"
+)
+
+var (
+	randMu     sync.Mutex
+	randReader io.Reader = rand.Reader
+)
+
+// randomSource is an io.Reader that reads from the source of random bytes configured with SetRandomReader.
+type randomSource struct{}
+
+func (randomSource) Read(data []byte) (int, error) {
+	randMu.Lock()
+	defer randMu.Unlock()
+	return randReader.Read(data)
+}
+
+// SetRandomReader sets the source of random bytes used by this package for generating nonces, salts and other secret values,
+// and returns the previous source. Passing nil restores the default source, which is crypto/rand.Reader.
+//
+// This is intended for testing, where a deterministic source allows a sequence of commands to be reproduced exactly, such as
+// when replaying a recording with testutil.NewReplayTCTI. It must not be used outside of tests, and the supplied source does
+// not need to be safe for concurrent use.
+func SetRandomReader(r io.Reader) io.Reader {
+	randMu.Lock()
+	defer randMu.Unlock()
+
+	if r == nil {
+		r = rand.Reader
+	}
+	orig := randReader
+	randReader = r
+	return orig
+}
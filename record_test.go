@@ -0,0 +1,136 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+// responderTcti is a TCTI that returns a fixed response for each command code, regardless of the command parameters.
+type responderTcti struct {
+	responses map[CommandCode][]byte
+	rsp       *bytes.Reader
+}
+
+func (t *responderTcti) Read(data []byte) (int, error) {
+	if t.rsp == nil {
+		return 0, io.EOF
+	}
+	return t.rsp.Read(data)
+}
+
+func (t *responderTcti) Write(data []byte) (int, error) {
+	var hdr struct {
+		Tag         StructTag
+		CommandSize uint32
+		CommandCode CommandCode
+	}
+	if _, err := mu.UnmarshalFromBytes(data, &hdr); err != nil {
+		return 0, err
+	}
+	t.rsp = bytes.NewReader(t.responses[hdr.CommandCode])
+	return len(data), nil
+}
+
+func (t *responderTcti) Close() error {
+	return nil
+}
+
+func (t *responderTcti) SetLocality(locality uint8) error {
+	return nil
+}
+
+func (t *responderTcti) MakeSticky(handle Handle, sticky bool) error {
+	return nil
+}
+
+type recordSuite struct {
+	testutil.BaseTest
+	path string
+}
+
+var _ = Suite(&recordSuite{})
+
+func (s *recordSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	s.path = filepath.Join(c.MkDir(), "recording")
+	s.AddCleanup(func() {
+		SetRandomReader(nil)
+	})
+}
+
+func (s *recordSuite) runSession(tcti TCTI) error {
+	tpm, _ := NewTPMContext(tcti)
+	session, err := tpm.StartAuthSession(nil, nil, SessionTypeHMAC, nil, HashAlgorithmSHA256)
+	if err != nil {
+		return err
+	}
+	if err := tpm.FlushContext(session); err != nil {
+		return err
+	}
+	return tpm.Close()
+}
+
+func (s *recordSuite) record(c *C, seed string) {
+	SetRandomReader(testutil.NewDeterministicRandomReader([]byte(seed)))
+
+	tcti, err := testutil.NewRecordingTCTI(&responderTcti{responses: map[CommandCode][]byte{
+		CommandStartAuthSession: makeScriptedPacket(c, TagNoSessions, uint32(Success), Handle(0x02000000), make(Nonce, 32)),
+		CommandFlushContext:     makeScriptedPacket(c, TagNoSessions, uint32(Success))}}, s.path)
+	c.Assert(err, IsNil)
+	c.Assert(tcti.SetLocality(3), IsNil)
+	c.Assert(s.runSession(tcti), IsNil)
+}
+
+func (s *recordSuite) TestRecordAndReplay(c *C) {
+	s.record(c, "foo")
+
+	recording, err := ioutil.ReadFile(s.path)
+	c.Assert(err, IsNil)
+	c.Check(string(recording), Matches, "locality 3\n> 8001[0-9a-f]*\n< 8001[0-9a-f]*\n> 8001[0-9a-f]*\n< 8001[0-9a-f]*\n")
+
+	SetRandomReader(testutil.NewDeterministicRandomReader([]byte("foo")))
+	tcti, err := testutil.NewReplayTCTI(s.path)
+	c.Assert(err, IsNil)
+	c.Check(tcti.SetLocality(3), IsNil)
+	c.Check(s.runSession(tcti), IsNil)
+}
+
+func (s *recordSuite) TestReplayDivergence(c *C) {
+	s.record(c, "foo")
+
+	SetRandomReader(testutil.NewDeterministicRandomReader([]byte("bar")))
+	tcti, err := testutil.NewReplayTCTI(s.path)
+	c.Assert(err, IsNil)
+	c.Check(tcti.SetLocality(3), IsNil)
+	c.Check(s.runSession(tcti), ErrorMatches,
+		"cannot complete write operation on TCTI: recording diverges at line 2: unexpected command [0-9a-f]* \\(expected [0-9a-f]*\\)")
+}
+
+func (s *recordSuite) TestReplayIncomplete(c *C) {
+	s.record(c, "foo")
+
+	tcti, err := testutil.NewReplayTCTI(s.path)
+	c.Assert(err, IsNil)
+	c.Check(tcti.SetLocality(3), IsNil)
+	c.Check(tcti.Close(), ErrorMatches, "recording was not fully replayed \\(next entry is on line 2\\)")
+}
+
+func (s *recordSuite) TestReplayUnexpectedLocality(c *C) {
+	s.record(c, "foo")
+
+	tcti, err := testutil.NewReplayTCTI(s.path)
+	c.Assert(err, IsNil)
+	c.Check(tcti.SetLocality(0), ErrorMatches, "recording diverges at line 1: unexpected locality 0 \\(expected 3\\)")
+}
@@ -0,0 +1,722 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	rmVirtualHandleFirst Handle = 0x80ff0000 // First virtual handle allocated for transient objects
+	rmVirtualHandleLast  Handle = 0x80ffffff // Last virtual handle allocated for transient objects
+)
+
+// rmObject tracks a transient object that is managed by TctiResourceManager.
+type rmObject struct {
+	handle   Handle   // The handle of the object on the TPM, or HandleUnassigned if it isn't loaded
+	saved    *Context // The saved context of the object if it isn't loaded
+	sticky   bool     // The object should not be evicted from the TPM
+	lastUsed uint64
+}
+
+// rmSession tracks a session that is managed by TctiResourceManager.
+type rmSession struct {
+	saved    *Context // The saved context of the session if it isn't loaded
+	sticky   bool     // The session should not be evicted from the TPM
+	lastUsed uint64
+}
+
+// TctiResourceManager is a TCTI that wraps another TCTI in order to provide a userspace resource manager, for use in environments
+// where a resource manager isn't provided by the platform (such as when using a TPM simulator via TctiMssim or when using the Linux
+// /dev/tpm0 device).
+//
+// Transient objects are virtualized, so that the handles returned to the caller are not the handles of the objects on the TPM.
+// Handles are rewritten in commands and responses as required. When the TPM indicates that it doesn't have enough memory to
+// load an object or session, the least recently used object or session that isn't required by the current command is saved
+// with TPM2_ContextSave and evicted from the TPM, and the command is retried. Evicted objects and sessions are loaded again with
+// TPM2_ContextLoad when they are next used. This allows more objects to be used than the TPM has slots for. Objects and sessions
+// can be protected from eviction with TctiResourceManager.MakeSticky.
+//
+// Commands that reference transient objects or sessions that were not created or loaded via this resource manager are rejected
+// with a TPM_RC_HANDLE error.
+//
+// Each call to Write must supply a single complete command, and the response must be read before the next command is written.
+//
+// TctiResourceManager does not track the effects of TPM2_Startup, so it should not be used across a TPM reset or restart.
+type TctiResourceManager struct {
+	tcti TCTI
+
+	commands map[CommandCode]CommandAttributes
+	objects  map[Handle]*rmObject  // Indexed by virtual handle
+	sessions map[Handle]*rmSession // Indexed by session handle
+
+	nextVirtualHandle Handle
+	sequence          uint64
+
+	rsp *bytes.Reader
+}
+
+// NewTctiResourceManager returns a new TctiResourceManager that wraps the supplied TCTI. The supplied TCTI must not be used
+// directly once it has been wrapped.
+func NewTctiResourceManager(tcti TCTI) *TctiResourceManager {
+	return &TctiResourceManager{
+		tcti:              tcti,
+		objects:           make(map[Handle]*rmObject),
+		sessions:          make(map[Handle]*rmSession),
+		nextVirtualHandle: rmVirtualHandleFirst}
+}
+
+func makeRMResponse(rc ResponseCode, body ...interface{}) []byte {
+	b, err := mu.MarshalToBytes(body...)
+	if err != nil {
+		panic(fmt.Sprintf("cannot marshal response body: %v", err))
+	}
+	rsp, err := mu.MarshalToBytes(TagNoSessions, uint32(binary.Size(responseHeader{})+len(b)), rc, mu.RawBytes(b))
+	if err != nil {
+		panic(fmt.Sprintf("cannot marshal response: %v", err))
+	}
+	return rsp
+}
+
+// transmit sends the supplied command to the TPM and returns the complete response.
+func (t *TctiResourceManager) transmit(cmd []byte) ([]byte, error) {
+	if _, err := t.tcti.Write(cmd); err != nil {
+		return nil, xerrors.Errorf("cannot send command: %w", err)
+	}
+
+	var hdr responseHeader
+	hdrBytes := make([]byte, binary.Size(hdr))
+	if _, err := io.ReadFull(t.tcti, hdrBytes); err != nil {
+		return nil, xerrors.Errorf("cannot read response header: %w", err)
+	}
+	if _, err := mu.UnmarshalFromBytes(hdrBytes, &hdr); err != nil {
+		return nil, xerrors.Errorf("cannot unmarshal response header: %w", err)
+	}
+	if int(hdr.ResponseSize) < len(hdrBytes) {
+		return nil, fmt.Errorf("invalid response size (%d)", hdr.ResponseSize)
+	}
+
+	rsp := make([]byte, hdr.ResponseSize)
+	copy(rsp, hdrBytes)
+	if _, err := io.ReadFull(t.tcti, rsp[len(hdrBytes):]); err != nil {
+		return nil, xerrors.Errorf("cannot read response: %w", err)
+	}
+	return rsp, nil
+}
+
+// runCommand executes a command without sessions on the TPM on behalf of the resource manager, returning the response code and
+// the response handles and parameters.
+func (t *TctiResourceManager) runCommand(commandCode CommandCode, handles []Handle, params ...interface{}) (ResponseCode, []byte, error) {
+	var body []interface{}
+	for _, h := range handles {
+		body = append(body, h)
+	}
+	body = append(body, params...)
+
+	b, err := mu.MarshalToBytes(body...)
+	if err != nil {
+		return 0, nil, xerrors.Errorf("cannot marshal command: %w", err)
+	}
+	cmd, err := mu.MarshalToBytes(commandHeader{TagNoSessions, uint32(binary.Size(commandHeader{}) + len(b)), commandCode}, mu.RawBytes(b))
+	if err != nil {
+		return 0, nil, xerrors.Errorf("cannot marshal command: %w", err)
+	}
+
+	rsp, err := t.transmit(cmd)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var hdr responseHeader
+	n, _ := mu.UnmarshalFromBytes(rsp, &hdr)
+	return hdr.ResponseCode, rsp[n:], nil
+}
+
+func (t *TctiResourceManager) initCommands() error {
+	if t.commands != nil {
+		return nil
+	}
+
+	commands := make(map[CommandCode]CommandAttributes)
+	next := uint32(CommandFirst)
+	for {
+		rc, rsp, err := t.runCommand(CommandGetCapability, nil, CapabilityCommands, next, CapabilityMaxProperties)
+		if err != nil {
+			return xerrors.Errorf("cannot execute TPM2_GetCapability: %w", err)
+		}
+		if rc != ResponseCode(Success) {
+			return xerrors.Errorf("cannot execute TPM2_GetCapability: %w", DecodeResponseCode(CommandGetCapability, rc))
+		}
+
+		var moreData bool
+		var data CapabilityData
+		if _, err := mu.UnmarshalFromBytes(rsp, &moreData, &data); err != nil {
+			return xerrors.Errorf("cannot unmarshal TPM2_GetCapability response: %w", err)
+		}
+		if data.Capability != CapabilityCommands {
+			return errors.New("TPM2_GetCapability returned the wrong capability")
+		}
+		for _, attrs := range data.Data.Command {
+			commands[attrs.CommandCode()] = attrs
+		}
+
+		if !moreData || len(data.Data.Command) == 0 {
+			break
+		}
+		next = uint32(data.Data.Command[len(data.Data.Command)-1].CommandCode()) + 1
+	}
+
+	t.commands = commands
+	return nil
+}
+
+func (t *TctiResourceManager) allocateVirtualHandle() (Handle, error) {
+	for i := 0; i <= int(rmVirtualHandleLast-rmVirtualHandleFirst); i++ {
+		h := t.nextVirtualHandle
+		t.nextVirtualHandle++
+		if t.nextVirtualHandle > rmVirtualHandleLast {
+			t.nextVirtualHandle = rmVirtualHandleFirst
+		}
+		if _, exists := t.objects[h]; !exists {
+			return h, nil
+		}
+	}
+	return HandleUnassigned, errors.New("no virtual handles available")
+}
+
+// saveObject saves and flushes the loaded object associated with the supplied virtual handle. It returns false if the object
+// could not be saved or flushed.
+func (t *TctiResourceManager) saveObject(handle Handle) (bool, error) {
+	o := t.objects[handle]
+
+	rc, rsp, err := t.runCommand(CommandContextSave, []Handle{o.handle})
+	if err != nil {
+		return false, xerrors.Errorf("cannot save context: %w", err)
+	}
+	if rc != ResponseCode(Success) {
+		return false, nil
+	}
+	var context Context
+	if _, err := mu.UnmarshalFromBytes(rsp, &context); err != nil {
+		return false, xerrors.Errorf("cannot unmarshal saved context: %w", err)
+	}
+
+	rc, _, err = t.runCommand(CommandFlushContext, nil, o.handle)
+	if err != nil {
+		return false, xerrors.Errorf("cannot flush context: %w", err)
+	}
+	if rc != ResponseCode(Success) {
+		return false, nil
+	}
+
+	o.handle = HandleUnassigned
+	o.saved = &context
+	return true, nil
+}
+
+// saveSession saves the loaded session associated with the supplied handle. It returns false if the session could not be
+// saved.
+func (t *TctiResourceManager) saveSession(handle Handle) (bool, error) {
+	rc, rsp, err := t.runCommand(CommandContextSave, []Handle{handle})
+	if err != nil {
+		return false, xerrors.Errorf("cannot save context: %w", err)
+	}
+	if rc != ResponseCode(Success) {
+		return false, nil
+	}
+	var context Context
+	if _, err := mu.UnmarshalFromBytes(rsp, &context); err != nil {
+		return false, xerrors.Errorf("cannot unmarshal saved context: %w", err)
+	}
+
+	t.sessions[handle].saved = &context
+	return true, nil
+}
+
+// evictObject saves and flushes the least recently used loaded object that isn't sticky and isn't in the supplied set of
+// handles. It returns false if no object could be evicted.
+func (t *TctiResourceManager) evictObject(inUse map[Handle]bool) (bool, error) {
+	var candidates []Handle
+	for h, o := range t.objects {
+		if o.handle == HandleUnassigned || o.sticky || inUse[h] {
+			continue
+		}
+		candidates = append(candidates, h)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return t.objects[candidates[i]].lastUsed < t.objects[candidates[j]].lastUsed
+	})
+
+	for _, h := range candidates {
+		saved, err := t.saveObject(h)
+		if err != nil || saved {
+			return saved, err
+		}
+	}
+
+	return false, nil
+}
+
+// evictSession saves the least recently used loaded session that isn't sticky and isn't in the supplied set of handles. It
+// returns false if no session could be evicted.
+func (t *TctiResourceManager) evictSession(inUse map[Handle]bool) (bool, error) {
+	var candidates []Handle
+	for h, s := range t.sessions {
+		if s.saved != nil || s.sticky || inUse[h] {
+			continue
+		}
+		candidates = append(candidates, h)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return t.sessions[candidates[i]].lastUsed < t.sessions[candidates[j]].lastUsed
+	})
+
+	for _, h := range candidates {
+		saved, err := t.saveSession(h)
+		if err != nil || saved {
+			return saved, err
+		}
+	}
+
+	return false, nil
+}
+
+// evict evicts an object or session in response to the supplied response code. It returns false if the response code doesn't
+// indicate a memory shortage, or if nothing could be evicted.
+func (t *TctiResourceManager) evict(rc ResponseCode, inUse map[Handle]bool) (bool, error) {
+	switch rc {
+	case fmt0VersionMask | fmt0SeverityMask | ResponseCode(WarningObjectMemory):
+		return t.evictObject(inUse)
+	case fmt0VersionMask | fmt0SeverityMask | ResponseCode(WarningSessionMemory):
+		return t.evictSession(inUse)
+	default:
+		return false, nil
+	}
+}
+
+// loadContext loads the supplied context, evicting other objects and sessions if required.
+func (t *TctiResourceManager) loadContext(context *Context, inUse map[Handle]bool) (Handle, ResponseCode, error) {
+	for {
+		rc, rsp, err := t.runCommand(CommandContextLoad, nil, context)
+		if err != nil {
+			return HandleUnassigned, 0, xerrors.Errorf("cannot load context: %w", err)
+		}
+		if rc == ResponseCode(Success) {
+			var handle Handle
+			if _, err := mu.UnmarshalFromBytes(rsp, &handle); err != nil {
+				return HandleUnassigned, 0, xerrors.Errorf("cannot unmarshal loaded handle: %w", err)
+			}
+			return handle, rc, nil
+		}
+
+		evicted, err := t.evict(rc, inUse)
+		if err != nil {
+			return HandleUnassigned, 0, err
+		}
+		if !evicted {
+			return HandleUnassigned, rc, nil
+		}
+	}
+}
+
+// loadResources ensures that the objects and sessions in the supplied set are loaded, returning a response code other than
+// Success if any could not be loaded.
+func (t *TctiResourceManager) loadResources(inUse map[Handle]bool) (ResponseCode, error) {
+	for h := range inUse {
+		switch h.Type() {
+		case HandleTypeTransient:
+			o := t.objects[h]
+			o.lastUsed = t.sequence
+			if o.handle != HandleUnassigned {
+				continue
+			}
+			handle, rc, err := t.loadContext(o.saved, inUse)
+			if err != nil || rc != ResponseCode(Success) {
+				return rc, err
+			}
+			o.handle = handle
+			o.saved = nil
+		case HandleTypeHMACSession, HandleTypePolicySession:
+			s := t.sessions[h]
+			s.lastUsed = t.sequence
+			if s.saved == nil {
+				continue
+			}
+			_, rc, err := t.loadContext(s.saved, inUse)
+			if err != nil || rc != ResponseCode(Success) {
+				return rc, err
+			}
+			s.saved = nil
+		}
+	}
+	return ResponseCode(Success), nil
+}
+
+// trackResponseHandle starts tracking the object or session associated with the supplied handle, returned from the TPM in a
+// response. It returns the handle that should be returned to the caller.
+func (t *TctiResourceManager) trackResponseHandle(handle Handle) (Handle, error) {
+	switch handle.Type() {
+	case HandleTypeTransient:
+		v, err := t.allocateVirtualHandle()
+		if err != nil {
+			return HandleUnassigned, err
+		}
+		t.objects[v] = &rmObject{handle: handle, lastUsed: t.sequence}
+		return v, nil
+	case HandleTypeHMACSession, HandleTypePolicySession:
+		t.sessions[handle] = &rmSession{lastUsed: t.sequence}
+	}
+	return handle, nil
+}
+
+func (t *TctiResourceManager) flushContext(params []byte) ([]byte, error) {
+	var handle Handle
+	if _, err := mu.UnmarshalFromBytes(params, &handle); err != nil {
+		return makeRMResponse(ResponseCode(ErrorInsufficient) | fmt1ParameterMask | (1 << fmt1IndexShift)), nil
+	}
+
+	switch handle.Type() {
+	case HandleTypeTransient:
+		o, exists := t.objects[handle]
+		if !exists {
+			return makeRMResponse(ResponseCode(ErrorHandle) | fmt1ParameterMask | (1 << fmt1IndexShift)), nil
+		}
+		if o.handle == HandleUnassigned {
+			delete(t.objects, handle)
+			return makeRMResponse(ResponseCode(Success)), nil
+		}
+		handle = o.handle
+	case HandleTypeHMACSession, HandleTypePolicySession:
+		if _, exists := t.sessions[handle]; !exists {
+			return makeRMResponse(ResponseCode(ErrorHandle) | fmt1ParameterMask | (1 << fmt1IndexShift)), nil
+		}
+	}
+
+	rc, _, err := t.runCommand(CommandFlushContext, nil, handle)
+	if err != nil {
+		return nil, err
+	}
+	if rc == ResponseCode(Success) {
+		for v, o := range t.objects {
+			if o.handle == handle {
+				delete(t.objects, v)
+			}
+		}
+		delete(t.sessions, handle)
+	}
+	return makeRMResponse(rc), nil
+}
+
+func (t *TctiResourceManager) getTransientHandles(params []byte) ([]byte, bool) {
+	var capability Capability
+	var property, propertyCount uint32
+	if _, err := mu.UnmarshalFromBytes(params, &capability, &property, &propertyCount); err != nil {
+		return nil, false
+	}
+	if capability != CapabilityHandles || Handle(property).Type() != HandleTypeTransient {
+		return nil, false
+	}
+
+	var handles HandleList
+	for h := range t.objects {
+		if h >= Handle(property) {
+			handles = append(handles, h)
+		}
+	}
+	sort.Slice(handles, func(i, j int) bool { return handles[i] < handles[j] })
+
+	moreData := false
+	if uint32(len(handles)) > propertyCount {
+		handles = handles[:propertyCount]
+		moreData = true
+	}
+
+	return makeRMResponse(ResponseCode(Success), moreData,
+		&CapabilityData{Capability: CapabilityHandles, Data: &CapabilitiesU{Handles: handles}}), true
+}
+
+func (t *TctiResourceManager) processCommand(cmd []byte) ([]byte, error) {
+	if err := t.initCommands(); err != nil {
+		return nil, xerrors.Errorf("cannot obtain command attributes: %w", err)
+	}
+
+	var hdr commandHeader
+	n, err := mu.UnmarshalFromBytes(cmd, &hdr)
+	if err != nil || int(hdr.CommandSize) != len(cmd) {
+		// Let the TPM deal with this.
+		return t.transmit(cmd)
+	}
+
+	attrs, known := t.commands[hdr.CommandCode]
+	if !known {
+		return t.transmit(cmd)
+	}
+
+	r := bytes.NewReader(cmd[n:])
+
+	handles := make([]Handle, attrs.NumberOfCommandHandles())
+	for i := range handles {
+		if _, err := mu.UnmarshalFromReader(r, &handles[i]); err != nil {
+			return t.transmit(cmd)
+		}
+	}
+
+	var authArea []byte
+	var auths []authCommand
+	if hdr.Tag == TagSessions {
+		var authSize uint32
+		if _, err := mu.UnmarshalFromReader(r, &authSize); err != nil || int(authSize) > r.Len() {
+			return t.transmit(cmd)
+		}
+		authArea = make([]byte, authSize)
+		r.Read(authArea)
+
+		ar := bytes.NewReader(authArea)
+		for ar.Len() > 0 {
+			var auth authCommand
+			if _, err := mu.UnmarshalFromReader(ar, &auth); err != nil {
+				return t.transmit(cmd)
+			}
+			auths = append(auths, auth)
+		}
+	}
+
+	params := make([]byte, r.Len())
+	r.Read(params)
+
+	t.sequence++
+
+	switch {
+	case hdr.CommandCode == CommandFlushContext:
+		return t.flushContext(params)
+	case hdr.CommandCode == CommandGetCapability && hdr.Tag == TagNoSessions:
+		if rsp, ok := t.getTransientHandles(params); ok {
+			return rsp, nil
+		}
+	}
+
+	inUse := make(map[Handle]bool)
+	for i, h := range handles {
+		switch h.Type() {
+		case HandleTypeTransient:
+			if _, exists := t.objects[h]; !exists {
+				return makeRMResponse(ResponseCode(ErrorHandle) | ResponseCode(i+1)<<fmt1IndexShift), nil
+			}
+			inUse[h] = true
+		case HandleTypeHMACSession, HandleTypePolicySession:
+			if _, exists := t.sessions[h]; !exists {
+				return makeRMResponse(ResponseCode(ErrorHandle) | ResponseCode(i+1)<<fmt1IndexShift), nil
+			}
+			inUse[h] = true
+		}
+	}
+	for i, auth := range auths {
+		switch auth.SessionHandle.Type() {
+		case HandleTypeHMACSession, HandleTypePolicySession:
+			if _, exists := t.sessions[auth.SessionHandle]; !exists {
+				return makeRMResponse(ResponseCode(ErrorHandle) | fmt1SessionMask | ResponseCode(i+1)<<fmt1IndexShift), nil
+			}
+			inUse[auth.SessionHandle] = true
+		}
+	}
+
+	var rsp []byte
+	for {
+		rc, err := t.loadResources(inUse)
+		if err != nil {
+			return nil, err
+		}
+		if rc != ResponseCode(Success) {
+			return makeRMResponse(rc), nil
+		}
+
+		physicalCmd := new(bytes.Buffer)
+		physicalCmd.Write(cmd[:n])
+		for _, h := range handles {
+			if h.Type() == HandleTypeTransient {
+				h = t.objects[h].handle
+			}
+			binary.Write(physicalCmd, binary.BigEndian, h)
+		}
+		if hdr.Tag == TagSessions {
+			binary.Write(physicalCmd, binary.BigEndian, uint32(len(authArea)))
+			physicalCmd.Write(authArea)
+		}
+		physicalCmd.Write(params)
+
+		rsp, err = t.transmit(physicalCmd.Bytes())
+		if err != nil {
+			return nil, err
+		}
+
+		var rspHdr responseHeader
+		mu.UnmarshalFromBytes(rsp, &rspHdr)
+		if rspHdr.ResponseCode == ResponseCode(Success) {
+			break
+		}
+
+		evicted, err := t.evict(rspHdr.ResponseCode, inUse)
+		if err != nil {
+			return nil, err
+		}
+		if !evicted {
+			return rsp, nil
+		}
+	}
+
+	// The command succeeded.
+	for _, auth := range auths {
+		if auth.SessionAttrs&attrContinueSession == 0 {
+			delete(t.sessions, auth.SessionHandle)
+		}
+	}
+	switch {
+	case hdr.CommandCode == CommandContextSave && len(handles) == 1:
+		// The caller takes ownership of a saved session.
+		delete(t.sessions, handles[0])
+	case attrs&AttrFlushed != 0:
+		for _, h := range handles {
+			if h.Type() == HandleTypeTransient {
+				delete(t.objects, h)
+			}
+		}
+	}
+
+	if attrs&AttrRHandle != 0 && len(rsp) >= binary.Size(responseHeader{})+binary.Size(Handle(0)) {
+		offset := binary.Size(responseHeader{})
+		handle := Handle(binary.BigEndian.Uint32(rsp[offset:]))
+		v, err := t.trackResponseHandle(handle)
+		if err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint32(rsp[offset:], uint32(v))
+	}
+
+	return rsp, nil
+}
+
+// EvictAll saves and flushes all of the transient objects managed by this resource manager from the TPM, and saves all of the
+// sessions managed by this resource manager, including those that have been marked as sticky. This is useful where the
+// underlying TPM is shared with other users, so that the resources used by this resource manager do not occupy the TPM between
+// commands. Objects and sessions are loaded again when they are next used.
+func (t *TctiResourceManager) EvictAll() error {
+	for h, o := range t.objects {
+		if o.handle == HandleUnassigned {
+			continue
+		}
+		saved, err := t.saveObject(h)
+		if err != nil {
+			return err
+		}
+		if !saved {
+			return fmt.Errorf("cannot evict object with handle 0x%08x", h)
+		}
+	}
+	for h, s := range t.sessions {
+		if s.saved != nil {
+			continue
+		}
+		saved, err := t.saveSession(h)
+		if err != nil {
+			return err
+		}
+		if !saved {
+			return fmt.Errorf("cannot evict session with handle 0x%08x", h)
+		}
+	}
+	return nil
+}
+
+// Read implements io.Reader, and reads the response to the last command.
+func (t *TctiResourceManager) Read(data []byte) (int, error) {
+	if t.rsp == nil {
+		return 0, io.EOF
+	}
+	return t.rsp.Read(data)
+}
+
+// Write implements io.Writer, and submits a command. The supplied data must contain a single complete command. Handles in the
+// command are translated, and any objects or sessions required by the command are loaded before the command is submitted to the
+// TPM.
+func (t *TctiResourceManager) Write(data []byte) (int, error) {
+	rsp, err := t.processCommand(data)
+	if err != nil {
+		return 0, err
+	}
+	t.rsp = bytes.NewReader(rsp)
+	return len(data), nil
+}
+
+// Close flushes all objects and sessions managed by this resource manager from the TPM and then closes the underlying TCTI.
+func (t *TctiResourceManager) Close() error {
+	for _, o := range t.objects {
+		if o.handle == HandleUnassigned {
+			continue
+		}
+		t.runCommand(CommandFlushContext, nil, o.handle)
+	}
+	for h := range t.sessions {
+		t.runCommand(CommandFlushContext, nil, h)
+	}
+	t.objects = nil
+	t.sessions = nil
+	return t.tcti.Close()
+}
+
+// SetLocality sets the locality of the underlying TCTI.
+func (t *TctiResourceManager) SetLocality(locality uint8) error {
+	return t.tcti.SetLocality(locality)
+}
+
+// MakeSticky requests that the resource manager does not evict the transient object or session associated with the supplied
+// handle in order to make room for other objects or sessions. If sticky is false, the object or session may be evicted again.
+func (t *TctiResourceManager) MakeSticky(handle Handle, sticky bool) error {
+	switch handle.Type() {
+	case HandleTypeTransient:
+		o, exists := t.objects[handle]
+		if !exists {
+			return fmt.Errorf("no object with handle 0x%08x", handle)
+		}
+		o.sticky = sticky
+	case HandleTypeHMACSession, HandleTypePolicySession:
+		s, exists := t.sessions[handle]
+		if !exists {
+			return fmt.Errorf("no session with handle 0x%08x", handle)
+		}
+		s.sticky = sticky
+	default:
+		return errors.New("invalid handle type")
+	}
+	return nil
+}
+
+// Cancel implements TCTICanceler.Cancel, and forwards the request to the underlying TCTI if it is supported.
+func (t *TctiResourceManager) Cancel() error {
+	c, ok := t.tcti.(TCTICanceler)
+	if !ok {
+		return errors.New("cancellation is not supported by the underlying TCTI")
+	}
+	return c.Cancel()
+}
+
+// SetReadDeadline implements TCTIReadDeadliner.SetReadDeadline, and forwards the request to the underlying TCTI if it is
+// supported.
+func (t *TctiResourceManager) SetReadDeadline(deadline time.Time) error {
+	d, ok := t.tcti.(TCTIReadDeadliner)
+	if !ok {
+		return errors.New("read deadlines are not supported by the underlying TCTI")
+	}
+	return d.SetReadDeadline(deadline)
+}
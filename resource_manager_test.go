@@ -0,0 +1,334 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+// rmTestTpm is a TCTI that emulates just enough of a TPM with a limited number of object slots to test TctiResourceManager.
+type rmTestTpm struct {
+	c          *C
+	slots      int
+	objects    map[Handle]*Public
+	nextHandle Handle
+	commands   map[CommandCode]int
+	rsp        *bytes.Reader
+	closed     bool
+}
+
+func newRmTestTpm(c *C, slots int) *rmTestTpm {
+	return &rmTestTpm{
+		c:          c,
+		slots:      slots,
+		objects:    make(map[Handle]*Public),
+		nextHandle: 0x80000000,
+		commands:   make(map[CommandCode]int)}
+}
+
+func (t *rmTestTpm) loadObject(public *Public) (Handle, bool) {
+	if len(t.objects) >= t.slots {
+		return HandleUnassigned, false
+	}
+	h := t.nextHandle
+	t.nextHandle++
+	t.objects[h] = public
+	return h, true
+}
+
+func (t *rmTestTpm) processCommand(cmd []byte) []byte {
+	var tag StructTag
+	var size uint32
+	var code CommandCode
+	n, err := mu.UnmarshalFromBytes(cmd, &tag, &size, &code)
+	t.c.Assert(err, IsNil)
+	t.c.Assert(tag, Equals, TagNoSessions)
+	params := cmd[n:]
+
+	t.commands[code]++
+
+	switch code {
+	case CommandGetCapability:
+		attrs := CommandAttributesList{
+			CommandAttributes(CommandContextLoad) | AttrRHandle,
+			CommandAttributes(CommandContextSave) | (1 << 25),
+			CommandAttributes(CommandFlushContext),
+			CommandAttributes(CommandLoadExternal) | AttrRHandle,
+			CommandAttributes(CommandReadPublic) | (1 << 25),
+			CommandAttributes(CommandGetCapability)}
+		return makeScriptedPacket(t.c, TagNoSessions, uint32(Success), false,
+			&CapabilityData{Capability: CapabilityCommands, Data: &CapabilitiesU{Command: attrs}})
+	case CommandLoadExternal:
+		var inPrivate struct {
+			Ptr *Sensitive `tpm2:"sized"`
+		}
+		var inPublic struct {
+			Ptr *Public `tpm2:"sized"`
+		}
+		var hierarchy Handle
+		_, err := mu.UnmarshalFromBytes(params, &inPrivate, &inPublic, &hierarchy)
+		t.c.Assert(err, IsNil)
+		h, ok := t.loadObject(inPublic.Ptr)
+		if !ok {
+			return makeScriptedPacket(t.c, TagNoSessions, 0x902)
+		}
+		name, err := inPublic.Ptr.Name()
+		t.c.Assert(err, IsNil)
+		return makeScriptedPacket(t.c, TagNoSessions, uint32(Success), h, name)
+	case CommandReadPublic:
+		var h Handle
+		_, err := mu.UnmarshalFromBytes(params, &h)
+		t.c.Assert(err, IsNil)
+		public, ok := t.objects[h]
+		if !ok {
+			return makeScriptedPacket(t.c, TagNoSessions, 0x18b)
+		}
+		name, err := public.Name()
+		t.c.Assert(err, IsNil)
+		return makeScriptedPacket(t.c, TagNoSessions, uint32(Success), struct {
+			Ptr *Public `tpm2:"sized"`
+		}{public}, name, name)
+	case CommandContextSave:
+		var h Handle
+		_, err := mu.UnmarshalFromBytes(params, &h)
+		t.c.Assert(err, IsNil)
+		public, ok := t.objects[h]
+		if !ok {
+			return makeScriptedPacket(t.c, TagNoSessions, 0x18b)
+		}
+		blob, err := mu.MarshalToBytes(public)
+		t.c.Assert(err, IsNil)
+		return makeScriptedPacket(t.c, TagNoSessions, uint32(Success),
+			&Context{Sequence: uint64(t.commands[code]), SavedHandle: 0x80000000, Hierarchy: HandleOwner, Blob: blob})
+	case CommandContextLoad:
+		var context Context
+		_, err := mu.UnmarshalFromBytes(params, &context)
+		t.c.Assert(err, IsNil)
+		var public *Public
+		_, err = mu.UnmarshalFromBytes(context.Blob, &public)
+		t.c.Assert(err, IsNil)
+		h, ok := t.loadObject(public)
+		if !ok {
+			return makeScriptedPacket(t.c, TagNoSessions, 0x902)
+		}
+		return makeScriptedPacket(t.c, TagNoSessions, uint32(Success), h)
+	case CommandFlushContext:
+		var h Handle
+		_, err := mu.UnmarshalFromBytes(params, &h)
+		t.c.Assert(err, IsNil)
+		if _, ok := t.objects[h]; !ok {
+			return makeScriptedPacket(t.c, TagNoSessions, 0x1cb)
+		}
+		delete(t.objects, h)
+		return makeScriptedPacket(t.c, TagNoSessions, uint32(Success))
+	default:
+		return makeScriptedPacket(t.c, TagNoSessions, uint32(ErrorCommandCode))
+	}
+}
+
+func (t *rmTestTpm) Read(data []byte) (int, error) {
+	if t.rsp == nil {
+		return 0, errors.New("no command submitted")
+	}
+	return t.rsp.Read(data)
+}
+
+func (t *rmTestTpm) Write(data []byte) (int, error) {
+	t.rsp = bytes.NewReader(t.processCommand(data))
+	return len(data), nil
+}
+
+func (t *rmTestTpm) Close() error {
+	t.closed = true
+	return nil
+}
+
+func (t *rmTestTpm) SetLocality(locality uint8) error {
+	return errors.New("not implemented")
+}
+
+func (t *rmTestTpm) MakeSticky(handle Handle, sticky bool) error {
+	return errors.New("not implemented")
+}
+
+type resourceManagerSuite struct {
+	testutil.BaseTest
+	tpmDevice *rmTestTpm
+	rm        *TctiResourceManager
+	tpm       *TPMContext
+}
+
+var _ = Suite(&resourceManagerSuite{})
+
+func (s *resourceManagerSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	s.tpmDevice = newRmTestTpm(c, 2)
+	s.rm = NewTctiResourceManager(s.tpmDevice)
+	s.tpm, _ = NewTPMContext(s.rm)
+}
+
+func (s *resourceManagerSuite) loadObject(c *C, i byte) (ResourceContext, *Public) {
+	public := &Public{
+		Type:    ObjectTypeKeyedHash,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrUserWithAuth,
+		Params:  &PublicParamsU{KeyedHashDetail: &KeyedHashParams{Scheme: KeyedHashScheme{Scheme: KeyedHashSchemeNull}}},
+		Unique:  &PublicIDU{KeyedHash: bytes.Repeat([]byte{i}, 32)}}
+	object, err := s.tpm.LoadExternal(nil, public, HandleOwner)
+	c.Assert(err, IsNil)
+	return object, public
+}
+
+func (s *resourceManagerSuite) TestLoadMoreObjectsThanSlots(c *C) {
+	var objects []ResourceContext
+	var publics []*Public
+	for i := 0; i < 5; i++ {
+		object, public := s.loadObject(c, byte(i))
+		c.Check(object.Handle().Type(), Equals, HandleTypeTransient)
+		objects = append(objects, object)
+		publics = append(publics, public)
+	}
+	c.Check(s.tpmDevice.objects, HasLen, 2)
+	c.Check(s.tpmDevice.commands[CommandContextSave], Equals, 3)
+
+	for i, object := range objects {
+		public, name, _, err := s.tpm.ReadPublic(object)
+		c.Check(err, IsNil)
+		c.Check(public.Unique, DeepEquals, publics[i].Unique)
+		c.Check(name, DeepEquals, object.Name())
+	}
+	c.Check(s.tpmDevice.objects, HasLen, 2)
+}
+
+func (s *resourceManagerSuite) TestMakeSticky(c *C) {
+	sticky, _ := s.loadObject(c, 0)
+	c.Check(s.rm.MakeSticky(sticky.Handle(), true), IsNil)
+
+	for i := 1; i < 5; i++ {
+		s.loadObject(c, byte(i))
+	}
+
+	loadCount := s.tpmDevice.commands[CommandContextLoad]
+	_, _, _, err := s.tpm.ReadPublic(sticky)
+	c.Check(err, IsNil)
+	c.Check(s.tpmDevice.commands[CommandContextLoad], Equals, loadCount)
+}
+
+func (s *resourceManagerSuite) TestMakeStickyUnknownHandle(c *C) {
+	c.Check(s.rm.MakeSticky(0x80ff0000, true), ErrorMatches, "no object with handle 0x80ff0000")
+}
+
+func (s *resourceManagerSuite) TestFlushContext(c *C) {
+	var objects []ResourceContext
+	for i := 0; i < 3; i++ {
+		object, _ := s.loadObject(c, byte(i))
+		objects = append(objects, object)
+	}
+
+	// The first object has been evicted and the last object is loaded.
+	for _, object := range []ResourceContext{objects[0], objects[2]} {
+		c.Check(s.tpm.FlushContext(object), IsNil)
+	}
+	c.Check(s.tpmDevice.objects, HasLen, 1)
+
+	handles, err := s.tpm.GetCapabilityHandles(HandleTypeTransient.BaseHandle(), CapabilityMaxProperties)
+	c.Check(err, IsNil)
+	c.Check(handles, DeepEquals, HandleList{objects[1].Handle()})
+}
+
+func (s *resourceManagerSuite) TestGetCapabilityHandles(c *C) {
+	var expected HandleList
+	for i := 0; i < 4; i++ {
+		object, _ := s.loadObject(c, byte(i))
+		expected = append(expected, object.Handle())
+	}
+
+	handles, err := s.tpm.GetCapabilityHandles(HandleTypeTransient.BaseHandle(), CapabilityMaxProperties)
+	c.Check(err, IsNil)
+	c.Check(handles, DeepEquals, expected)
+}
+
+func (s *resourceManagerSuite) TestUnknownHandle(c *C) {
+	object, _ := s.loadObject(c, 0)
+	handle := object.Handle()
+	c.Check(s.tpm.FlushContext(object), IsNil)
+
+	cmd, err := mu.MarshalToBytes(handle)
+	c.Assert(err, IsNil)
+	rc, _, _, err := s.tpm.RunCommandBytes(TagNoSessions, CommandReadPublic, cmd)
+	c.Check(err, IsNil)
+	c.Check(rc, Equals, ResponseCode(0x18b))
+	c.Check(s.tpmDevice.commands[CommandReadPublic], Equals, 0)
+}
+
+func (s *resourceManagerSuite) TestClose(c *C) {
+	for i := 0; i < 3; i++ {
+		s.loadObject(c, byte(i))
+	}
+	c.Check(s.rm.Close(), IsNil)
+	c.Check(s.tpmDevice.objects, HasLen, 0)
+	c.Check(s.tpmDevice.closed, testutil.IsTrue)
+}
+
+func (s *resourceManagerSuite) TestEvictAll(c *C) {
+	var objects []ResourceContext
+	for i := 0; i < 2; i++ {
+		object, _ := s.loadObject(c, byte(i))
+		c.Check(s.rm.MakeSticky(object.Handle(), true), IsNil)
+		objects = append(objects, object)
+	}
+	c.Check(s.tpmDevice.objects, HasLen, 2)
+
+	c.Check(s.rm.EvictAll(), IsNil)
+	c.Check(s.tpmDevice.objects, HasLen, 0)
+
+	for _, object := range objects {
+		_, name, _, err := s.tpm.ReadPublic(object)
+		c.Check(err, IsNil)
+		c.Check(name, DeepEquals, object.Name())
+	}
+}
+
+func (s *resourceManagerSuite) TestFlushUnknownSession(c *C) {
+	cmd, err := mu.MarshalToBytes(Handle(0x02000000))
+	c.Assert(err, IsNil)
+	rc, _, _, err := s.tpm.RunCommandBytes(TagNoSessions, CommandFlushContext, cmd)
+	c.Check(err, IsNil)
+	c.Check(rc, Equals, ResponseCode(0x1cb))
+	c.Check(s.tpmDevice.commands[CommandFlushContext], Equals, 0)
+}
+
+// deadlineRecorderTcti is a TCTI that records the read deadlines that are set on it.
+type deadlineRecorderTcti struct {
+	*rmTestTpm
+	deadlines []time.Time
+}
+
+func (t *deadlineRecorderTcti) SetReadDeadline(deadline time.Time) error {
+	t.deadlines = append(t.deadlines, deadline)
+	return nil
+}
+
+func (s *resourceManagerSuite) TestSetReadDeadline(c *C) {
+	tcti := &deadlineRecorderTcti{rmTestTpm: s.tpmDevice}
+	rm := NewTctiResourceManager(tcti)
+
+	deadline := time.Now()
+	c.Check(rm.SetReadDeadline(deadline), IsNil)
+	c.Check(rm.SetReadDeadline(time.Time{}), IsNil)
+	c.Check(tcti.deadlines, DeepEquals, []time.Time{deadline, {}})
+}
+
+func (s *resourceManagerSuite) TestSetReadDeadlineNotSupported(c *C) {
+	c.Check(s.rm.SetReadDeadline(time.Now()), ErrorMatches, "read deadlines are not supported by the underlying TCTI")
+}
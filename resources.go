@@ -198,7 +198,7 @@ func (h *handleContext) checkConsistency() error {
 	switch h.Type {
 	case handleContextTypePermanent:
 		switch h.Handle().Type() {
-		case HandleTypePCR, HandleTypePermanent:
+		case HandleTypePCR, HandleTypePermanent, HandleTypeAC:
 		default:
 			return errors.New("inconsistent handle type for permanent context")
 		}
@@ -414,9 +414,16 @@ func (t *TPMContext) makeNVIndexContextFromTPM(context ResourceContext, sessions
 	return makeNVIndexContext(name, pub), nil
 }
 
+// sessionHostState contains host-side state for a session that is shared between all SessionContexts for it, but which isn't
+// serialized.
+type sessionHostState struct {
+	auditDigest Digest
+}
+
 type sessionContext struct {
 	*handleContext
 	attrs SessionAttributes
+	host  *sessionHostState
 }
 
 func (r *sessionContext) NonceTPM() Nonce {
@@ -448,15 +455,15 @@ func (r *sessionContext) SetAttrs(attrs SessionAttributes) {
 }
 
 func (r *sessionContext) WithAttrs(attrs SessionAttributes) SessionContext {
-	return &sessionContext{handleContext: r.handleContext, attrs: attrs}
+	return &sessionContext{handleContext: r.handleContext, attrs: attrs, host: r.host}
 }
 
 func (r *sessionContext) IncludeAttrs(attrs SessionAttributes) SessionContext {
-	return &sessionContext{handleContext: r.handleContext, attrs: r.attrs | attrs}
+	return &sessionContext{handleContext: r.handleContext, attrs: r.attrs | attrs, host: r.host}
 }
 
 func (r *sessionContext) ExcludeAttrs(attrs SessionAttributes) SessionContext {
-	return &sessionContext{handleContext: r.handleContext, attrs: r.attrs &^ attrs}
+	return &sessionContext{handleContext: r.handleContext, attrs: r.attrs &^ attrs, host: r.host}
 }
 
 func (r *sessionContext) Data() *sessionContextData {
@@ -494,7 +501,8 @@ func makeSessionContext(handle Handle, data *sessionContextData) *sessionContext
 			Type: handleContextTypeSession,
 			H:    handle,
 			N:    name,
-			Data: &handleContextU{Session: data}}}
+			Data: &handleContextU{Session: data}},
+		host: new(sessionHostState)}
 }
 
 // CreateResourceContextFromTPM creates and returns a new ResourceContext for the specified handle. It will execute a command to read
@@ -558,15 +566,18 @@ func CreateIncompleteSessionContext(handle Handle) SessionContext {
 	}
 }
 
-// GetPermanentContext returns a ResourceContext for the specified permanent handle or PCR handle.
+// GetPermanentContext returns a ResourceContext for the specified permanent handle, PCR handle or attached component (AC) handle.
 //
-// This function will panic if handle does not correspond to a permanent or PCR handle.
+// This function will panic if handle does not correspond to a permanent, PCR or AC handle.
 //
 // If subsequent use of the returned ResourceContext requires knowledge of the authorization value of the corresponding TPM resource,
 // this should be provided by calling ResourceContext.SetAuthValue.
 func (t *TPMContext) GetPermanentContext(handle Handle) ResourceContext {
 	switch handle.Type() {
-	case HandleTypePermanent, HandleTypePCR:
+	case HandleTypePermanent, HandleTypePCR, HandleTypeAC:
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
 		if rc, exists := t.permanentResources[handle]; exists {
 			return rc
 		}
@@ -667,7 +678,7 @@ func CreateHandleContextFromReader(r io.Reader) (HandleContext, error) {
 	case handleContextTypeNvIndex:
 		hc = &nvIndexContext{resourceContext: resourceContext{handleContext: *data}}
 	case handleContextTypeSession:
-		hc = &sessionContext{handleContext: data}
+		hc = &sessionContext{handleContext: data, host: new(sessionHostState)}
 	default:
 		panic("not reached")
 	}
@@ -0,0 +1,78 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"time"
+)
+
+const (
+	defaultRetryNVInitialDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay       = 2 * time.Second
+)
+
+// RetryPolicy decides whether a command that the TPM responded to with a warning should be resubmitted. It can be set with
+// TPMContext.SetRetryPolicy. Regardless of the policy, a command is never submitted more than the number of times set with
+// TPMContext.SetMaxSubmissions.
+type RetryPolicy interface {
+	// Retry is called when the TPM responds to a command with a warning. The submissions argument is the number of times that
+	// the command has been submitted so far. It returns whether the command should be resubmitted, and how long to wait before
+	// resubmitting it.
+	Retry(warning *TPMWarning, submissions uint) (retry bool, delay time.Duration)
+}
+
+// RetryHook is a callback that is called before a command is resubmitted because the TPM responded with a warning. The
+// submissions argument is the number of times that the command has been submitted so far, and delay is the time that will
+// elapse before it is resubmitted. It can be set with TPMContext.SetRetryHook.
+type RetryHook func(command CommandCode, warning *TPMWarning, submissions uint, delay time.Duration)
+
+// BackoffRetryPolicy is the default RetryPolicy. It resubmits commands that the TPM did not execute because of a condition that
+// is expected to clear on its own, waiting for an exponentially increasing delay between submissions. These are commands that
+// fail with one of the following warnings:
+//  * WarningYielded, WarningTesting and WarningRetry, using InitialDelay as the first delay. By default, these are resubmitted
+//    immediately.
+//  * WarningNVRate and WarningNVUnavailable, using NVInitialDelay as the first delay. These generally take longer to clear,
+//    particularly on discrete TPMs which rate limit NV writes.
+//
+// Commands that fail with WarningLockout are not resubmitted. The DA lockout only clears after the TPM's recovery time, which is
+// typically too long to wait for, and resubmitting commands will not help to clear it.
+//
+// The zero value uses the default delays.
+//
+// Note that TPMContext continues to serialize access to the TPM whilst it waits to resubmit a command, so a long delay also
+// delays other commands submitted from other goroutines.
+type BackoffRetryPolicy struct {
+	InitialDelay   time.Duration // The delay before the first resubmission for transient warnings. The default is 0.
+	NVInitialDelay time.Duration // The delay before the first resubmission for NV warnings. The default is 100ms.
+	MaxDelay       time.Duration // The maximum delay between submissions. The default is 2s.
+}
+
+func (p *BackoffRetryPolicy) Retry(warning *TPMWarning, submissions uint) (retry bool, delay time.Duration) {
+	nvInitialDelay := p.NVInitialDelay
+	if nvInitialDelay == 0 {
+		nvInitialDelay = defaultRetryNVInitialDelay
+	}
+	maxDelay := p.MaxDelay
+	if maxDelay == 0 {
+		maxDelay = defaultRetryMaxDelay
+	}
+
+	switch warning.Code {
+	case WarningYielded, WarningTesting, WarningRetry:
+		delay = p.InitialDelay
+	case WarningNVRate, WarningNVUnavailable:
+		delay = nvInitialDelay
+	default:
+		return false, 0
+	}
+
+	for i := uint(1); i < submissions && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return true, delay
+}
@@ -0,0 +1,170 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"context"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+type backoffRetryPolicySuite struct{}
+
+var _ = Suite(&backoffRetryPolicySuite{})
+
+type testBackoffRetryPolicyData struct {
+	policy      *BackoffRetryPolicy
+	code        WarningCode
+	submissions uint
+	retry       bool
+	delay       time.Duration
+}
+
+func (s *backoffRetryPolicySuite) testBackoffRetryPolicy(c *C, data *testBackoffRetryPolicyData) {
+	retry, delay := data.policy.Retry(&TPMWarning{Command: CommandNVWrite, Code: data.code}, data.submissions)
+	c.Check(retry, Equals, data.retry)
+	c.Check(delay, Equals, data.delay)
+}
+
+func (s *backoffRetryPolicySuite) TestBackoffRetryPolicyRetry(c *C) {
+	s.testBackoffRetryPolicy(c, &testBackoffRetryPolicyData{
+		policy:      &BackoffRetryPolicy{},
+		code:        WarningRetry,
+		submissions: 1,
+		retry:       true,
+		delay:       0})
+}
+
+func (s *backoffRetryPolicySuite) TestBackoffRetryPolicyTestingNoDelay(c *C) {
+	s.testBackoffRetryPolicy(c, &testBackoffRetryPolicyData{
+		policy:      &BackoffRetryPolicy{},
+		code:        WarningTesting,
+		submissions: 3,
+		retry:       true,
+		delay:       0})
+}
+
+func (s *backoffRetryPolicySuite) TestBackoffRetryPolicyYieldedBackoff(c *C) {
+	s.testBackoffRetryPolicy(c, &testBackoffRetryPolicyData{
+		policy:      &BackoffRetryPolicy{InitialDelay: 10 * time.Millisecond},
+		code:        WarningYielded,
+		submissions: 3,
+		retry:       true,
+		delay:       40 * time.Millisecond})
+}
+
+func (s *backoffRetryPolicySuite) TestBackoffRetryPolicyNVRate(c *C) {
+	s.testBackoffRetryPolicy(c, &testBackoffRetryPolicyData{
+		policy:      &BackoffRetryPolicy{},
+		code:        WarningNVRate,
+		submissions: 2,
+		retry:       true,
+		delay:       200 * time.Millisecond})
+}
+
+func (s *backoffRetryPolicySuite) TestBackoffRetryPolicyNVUnavailableMaxDelay(c *C) {
+	s.testBackoffRetryPolicy(c, &testBackoffRetryPolicyData{
+		policy:      &BackoffRetryPolicy{MaxDelay: time.Second},
+		code:        WarningNVUnavailable,
+		submissions: 10,
+		retry:       true,
+		delay:       time.Second})
+}
+
+func (s *backoffRetryPolicySuite) TestBackoffRetryPolicyLockout(c *C) {
+	s.testBackoffRetryPolicy(c, &testBackoffRetryPolicyData{
+		policy:      &BackoffRetryPolicy{},
+		code:        WarningLockout,
+		submissions: 1,
+		retry:       false})
+}
+
+type retrySuite struct {
+	testutil.FaultInjectionTPMTest
+}
+
+var _ = Suite(&retrySuite{})
+
+func (s *retrySuite) SetUpTest(c *C) {
+	s.FaultInjectionTPMTest.SetUpTest(c)
+	c.Check(s.TPM.InitProperties(), IsNil)
+	s.TPM.SetRetryPolicy(&BackoffRetryPolicy{InitialDelay: time.Millisecond, NVInitialDelay: 2 * time.Millisecond})
+}
+
+func (s *retrySuite) addWarningRule(code WarningCode, skip, count int) {
+	s.FaultTCTI.AddRule(&testutil.FaultRule{
+		Command:      CommandGetRandom,
+		Fault:        testutil.FaultResponseCode,
+		Skip:         skip,
+		Count:        count,
+		ResponseCode: ResponseCode(0x900) | ResponseCode(code)})
+}
+
+func (s *retrySuite) TestRetryNVRateWithHook(c *C) {
+	s.addWarningRule(WarningNVRate, 0, 2)
+
+	type retryEvent struct {
+		command     CommandCode
+		code        WarningCode
+		submissions uint
+		delay       time.Duration
+	}
+	var events []retryEvent
+	s.TPM.SetRetryHook(func(command CommandCode, warning *TPMWarning, submissions uint, delay time.Duration) {
+		events = append(events, retryEvent{command, warning.Code, submissions, delay})
+	})
+
+	_, err := s.TPM.GetRandom(8)
+	c.Check(err, IsNil)
+	c.Check(events, DeepEquals, []retryEvent{
+		{CommandGetRandom, WarningNVRate, 1, 2 * time.Millisecond},
+		{CommandGetRandom, WarningNVRate, 2, 4 * time.Millisecond}})
+}
+
+type mockRetryPolicy struct {
+	warnings []WarningCode
+}
+
+func (p *mockRetryPolicy) Retry(warning *TPMWarning, submissions uint) (bool, time.Duration) {
+	p.warnings = append(p.warnings, warning.Code)
+	return warning.Code == WarningLockout, 0
+}
+
+func (s *retrySuite) TestCustomRetryPolicy(c *C) {
+	s.addWarningRule(WarningLockout, 0, 1)
+	s.addWarningRule(WarningRetry, 1, 1)
+
+	policy := new(mockRetryPolicy)
+	s.TPM.SetRetryPolicy(policy)
+
+	_, err := s.TPM.GetRandom(8)
+	c.Check(IsTPMWarning(err, WarningRetry, CommandGetRandom), testutil.IsTrue)
+	c.Check(policy.warnings, DeepEquals, []WarningCode{WarningLockout, WarningRetry})
+}
+
+func (s *retrySuite) TestMaxSubmissionsLimitsPolicy(c *C) {
+	s.addWarningRule(WarningRetry, 0, 0)
+	s.TPM.SetMaxSubmissions(2)
+
+	_, err := s.TPM.GetRandom(8)
+	c.Check(IsTPMWarning(err, WarningRetry, CommandGetRandom), testutil.IsTrue)
+	c.Check(s.FaultTCTI.Injected(), Equals, 2)
+}
+
+func (s *retrySuite) TestRetryCanceled(c *C) {
+	s.addWarningRule(WarningNVRate, 0, 0)
+	s.TPM.SetRetryPolicy(&BackoffRetryPolicy{NVInitialDelay: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := s.TPM.WithContext(ctx).GetRandom(8)
+	c.Check(err, ErrorMatches, "command TPM_CC_GetRandom was canceled: context deadline exceeded")
+	c.Check(s.FaultTCTI.Injected(), Equals, 1)
+}
@@ -0,0 +1,343 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+/*
+Package server implements a TPM proxy server that speaks the protocol implemented by the Microsoft TPM2 simulator, which is the
+protocol used by tpm2.TctiMssim. This allows a single TPM to be shared between multiple clients, such as containers or virtual
+machines, which connect to it with tpm2.OpenMssim.
+
+Commands received from clients are forwarded to any tpm2.TCTI implementation, such as a Linux TPM character device, a TPM
+simulator or a tpm2.TctiResourceManager. Commands are serialized so that only a single command is executed at a time.
+
+Each client connection has its own resource manager, so transient objects and sessions created by one client cannot be
+accessed by another client. The transient objects and sessions belonging to a client are evicted from the TPM after each
+command, and are loaded again when they are next used. The set of commands that clients are permitted to execute can be
+restricted with an allow-list.
+*/
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	cmdPowerOn             uint32 = 1
+	cmdPowerOff            uint32 = 2
+	cmdPhysicalPresenceOn  uint32 = 3
+	cmdPhysicalPresenceOff uint32 = 4
+	cmdTPMSendCommand      uint32 = 8
+	cmdSignalCancelOn      uint32 = 9
+	cmdSignalCancelOff     uint32 = 10
+	cmdNVOn                uint32 = 11
+	cmdNVOff               uint32 = 12
+	cmdReset               uint32 = 17
+	cmdRestart             uint32 = 18
+	cmdSessionEnd          uint32 = 20
+	cmdStop                uint32 = 21
+	cmdTestFailureMode     uint32 = 30
+)
+
+const (
+	// maxCommandSize is the maximum size of a command that will be accepted from a client.
+	maxCommandSize = 65536
+
+	// platformCommandDenied is the response code for platform commands that clients are not permitted to execute.
+	platformCommandDenied uint32 = 1
+
+	// rcCommandCode corresponds to TPM_RC_COMMAND_CODE, which is returned to clients for commands that are not permitted.
+	rcCommandCode tpm2.ResponseCode = 0x100 | tpm2.ResponseCode(tpm2.ErrorCommandCode)
+)
+
+// ErrServerClosed is returned from Server.Serve and Server.ListenAndServe after a call to Server.Close.
+var ErrServerClosed = errors.New("server closed")
+
+// sharedTcti is used to share the TCTI associated with a server between the resource managers associated with each connection.
+type sharedTcti struct {
+	tcti tpm2.TCTI
+}
+
+func (t *sharedTcti) Read(data []byte) (int, error) {
+	return t.tcti.Read(data)
+}
+
+func (t *sharedTcti) Write(data []byte) (int, error) {
+	return t.tcti.Write(data)
+}
+
+func (t *sharedTcti) Close() error {
+	// The underlying TCTI is owned by the server.
+	return nil
+}
+
+func (t *sharedTcti) SetLocality(locality uint8) error {
+	return errors.New("not implemented")
+}
+
+func (t *sharedTcti) MakeSticky(handle tpm2.Handle, sticky bool) error {
+	return errors.New("not implemented")
+}
+
+// Server is a TPM proxy server that speaks the protocol implemented by the Microsoft TPM2 simulator. It listens for client
+// connections on a TPM command channel and a platform channel.
+//
+// Only TPM commands are forwarded to the underlying TPM. Platform commands that are required by tpm2.OpenMssim and
+// tpm2.TctiMssim (power on, NV on and the cancel signal) succeed without having any effect, and all other platform commands
+// fail. Commands are submitted at the locality of the underlying TCTI, regardless of the locality requested by the client.
+type Server struct {
+	tcti    tpm2.TCTI
+	allowed map[tpm2.CommandCode]bool
+
+	tpmMu sync.Mutex // Serializes access to tcti
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// NewServer returns a new server that forwards commands to the supplied TCTI. The caller retains ownership of the supplied TCTI,
+// and it must not be used for anything else whilst the server is running.
+//
+// If allowedCommands is not nil, clients are only permitted to execute the commands in this list, and other commands will fail
+// with a TPM_RC_COMMAND_CODE error. If allowedCommands is nil, clients are permitted to execute any command.
+func NewServer(tcti tpm2.TCTI, allowedCommands []tpm2.CommandCode) *Server {
+	s := &Server{
+		tcti:      tcti,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{})}
+	if allowedCommands != nil {
+		s.allowed = make(map[tpm2.CommandCode]bool)
+		for _, c := range allowedCommands {
+			s.allowed[c] = true
+		}
+	}
+	return s
+}
+
+func (s *Server) commandAllowed(command tpm2.CommandCode) bool {
+	return s.allowed == nil || s.allowed[command]
+}
+
+func (s *Server) trackListener(l net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackListener(l net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.listeners, l)
+}
+
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conn.Close()
+	delete(s.conns, conn)
+	s.wg.Done()
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// runCommand executes the supplied command from a client on the underlying TPM, using the resource manager associated with the
+// client's connection.
+func (s *Server) runCommand(rm *tpm2.TctiResourceManager, cmd []byte) ([]byte, error) {
+	var tag tpm2.StructTag
+	var commandSize uint32
+	var commandCode tpm2.CommandCode
+	if _, err := mu.UnmarshalFromBytes(cmd, &tag, &commandSize, &commandCode); err == nil && !s.commandAllowed(commandCode) {
+		return mu.MarshalToBytes(tpm2.TagNoSessions, uint32(10), rcCommandCode)
+	}
+
+	s.tpmMu.Lock()
+	defer s.tpmMu.Unlock()
+
+	if _, err := rm.Write(cmd); err != nil {
+		return nil, xerrors.Errorf("cannot send command: %w", err)
+	}
+	rsp, err := ioutil.ReadAll(rm)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot read response: %w", err)
+	}
+	if err := rm.EvictAll(); err != nil {
+		return nil, xerrors.Errorf("cannot evict resources: %w", err)
+	}
+	return rsp, nil
+}
+
+func (s *Server) serveTPMConn(conn net.Conn) {
+	rm := tpm2.NewTctiResourceManager(&sharedTcti{s.tcti})
+	defer func() {
+		s.tpmMu.Lock()
+		defer s.tpmMu.Unlock()
+		rm.Close()
+	}()
+
+	for {
+		var cmd uint32
+		if err := binary.Read(conn, binary.BigEndian, &cmd); err != nil {
+			return
+		}
+
+		switch cmd {
+		case cmdTPMSendCommand:
+			var locality uint8
+			var size uint32
+			if _, err := mu.UnmarshalFromReader(conn, &locality, &size); err != nil {
+				return
+			}
+			if size > maxCommandSize {
+				return
+			}
+			command := make([]byte, size)
+			if _, err := io.ReadFull(conn, command); err != nil {
+				return
+			}
+
+			rsp, err := s.runCommand(rm, command)
+			if err != nil {
+				return
+			}
+			if _, err := mu.MarshalToWriter(conn, uint32(len(rsp)), mu.RawBytes(rsp), uint32(0)); err != nil {
+				return
+			}
+		default:
+			// This includes cmdSessionEnd and cmdStop. Other commands are not supported on the TPM command channel.
+			return
+		}
+	}
+}
+
+func (s *Server) servePlatformConn(conn net.Conn) {
+	for {
+		var cmd uint32
+		if err := binary.Read(conn, binary.BigEndian, &cmd); err != nil {
+			return
+		}
+
+		var rc uint32
+		switch cmd {
+		case cmdPowerOn, cmdNVOn, cmdSignalCancelOn, cmdSignalCancelOff:
+			// These are sent by tpm2.TctiMssim. Canceling commands is not supported, as the signal can't be associated
+			// with a command.
+		case cmdPowerOff, cmdPhysicalPresenceOn, cmdPhysicalPresenceOff, cmdNVOff, cmdReset, cmdRestart, cmdTestFailureMode:
+			rc = platformCommandDenied
+		default:
+			// This includes cmdSessionEnd and cmdStop. Other commands may have arguments, so we can't respond to them.
+			return
+		}
+
+		if err := binary.Write(conn, binary.BigEndian, rc); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) acceptLoop(l net.Listener, serveConn func(net.Conn)) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		if !s.trackConn(conn) {
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer s.untrackConn(conn)
+			serveConn(conn)
+		}()
+	}
+}
+
+// Serve accepts client connections for the TPM command channel on tpmListener, and client connections for the platform channel
+// on platformListener. It blocks until either listener fails or Close is called, and then closes both listeners. It always
+// returns a non-nil error. After Close is called, it returns ErrServerClosed.
+func (s *Server) Serve(tpmListener, platformListener net.Listener) error {
+	defer tpmListener.Close()
+	defer platformListener.Close()
+
+	if !s.trackListener(tpmListener) || !s.trackListener(platformListener) {
+		return ErrServerClosed
+	}
+	defer s.untrackListener(tpmListener)
+	defer s.untrackListener(platformListener)
+
+	errs := make(chan error, 2)
+	go func() { errs <- s.acceptLoop(tpmListener, s.serveTPMConn) }()
+	go func() { errs <- s.acceptLoop(platformListener, s.servePlatformConn) }()
+
+	err := <-errs
+	tpmListener.Close()
+	platformListener.Close()
+	<-errs
+
+	if s.isClosed() {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// ListenAndServe listens on the specified network addresses and then calls Serve. The network must be a stream oriented network
+// such as "tcp" or "unix". It always returns a non-nil error.
+func (s *Server) ListenAndServe(network, tpmAddress, platformAddress string) error {
+	tpmListener, err := net.Listen(network, tpmAddress)
+	if err != nil {
+		return xerrors.Errorf("cannot listen on TPM command channel address: %w", err)
+	}
+	platformListener, err := net.Listen(network, platformAddress)
+	if err != nil {
+		tpmListener.Close()
+		return xerrors.Errorf("cannot listen on platform channel address: %w", err)
+	}
+	return s.Serve(tpmListener, platformListener)
+}
+
+// Close closes all listeners and client connections, and waits for any executing command to complete. It does not close the
+// underlying TCTI.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
@@ -0,0 +1,299 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package server_test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+	. "github.com/canonical/go-tpm2/server"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+func init() {
+	testutil.AddCommandLineFlags()
+}
+
+func Test(t *testing.T) { TestingT(t) }
+
+func makePacket(c *C, code tpm2.ResponseCode, body ...interface{}) []byte {
+	b, err := mu.MarshalToBytes(body...)
+	c.Assert(err, IsNil)
+	h, err := mu.MarshalToBytes(tpm2.TagNoSessions, uint32(10+len(b)), code)
+	c.Assert(err, IsNil)
+	return append(h, b...)
+}
+
+// testTpm is a TCTI that emulates just enough of a TPM to test the server.
+type testTpm struct {
+	c *C
+
+	mu         sync.Mutex
+	objects    map[tpm2.Handle]*tpm2.Public
+	nextHandle tpm2.Handle
+	commands   map[tpm2.CommandCode]int
+	rsp        *bytes.Reader
+}
+
+func newTestTpm(c *C) *testTpm {
+	return &testTpm{
+		c:          c,
+		objects:    make(map[tpm2.Handle]*tpm2.Public),
+		nextHandle: 0x80000000,
+		commands:   make(map[tpm2.CommandCode]int)}
+}
+
+func (t *testTpm) numObjects() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.objects)
+}
+
+func (t *testTpm) numCommands(code tpm2.CommandCode) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.commands[code]
+}
+
+func (t *testTpm) loadObject(public *tpm2.Public) tpm2.Handle {
+	h := t.nextHandle
+	t.nextHandle++
+	t.objects[h] = public
+	return h
+}
+
+func (t *testTpm) processCommand(cmd []byte) []byte {
+	var tag tpm2.StructTag
+	var size uint32
+	var code tpm2.CommandCode
+	n, err := mu.UnmarshalFromBytes(cmd, &tag, &size, &code)
+	t.c.Assert(err, IsNil)
+	params := cmd[n:]
+
+	t.commands[code]++
+
+	switch code {
+	case tpm2.CommandGetCapability:
+		attrs := tpm2.CommandAttributesList{
+			tpm2.CommandAttributes(tpm2.CommandContextLoad) | tpm2.AttrRHandle,
+			tpm2.CommandAttributes(tpm2.CommandContextSave) | (1 << 25),
+			tpm2.CommandAttributes(tpm2.CommandFlushContext),
+			tpm2.CommandAttributes(tpm2.CommandLoadExternal) | tpm2.AttrRHandle,
+			tpm2.CommandAttributes(tpm2.CommandReadPublic) | (1 << 25),
+			tpm2.CommandAttributes(tpm2.CommandGetCapability)}
+		return makePacket(t.c, tpm2.ResponseCode(tpm2.Success), false,
+			&tpm2.CapabilityData{Capability: tpm2.CapabilityCommands, Data: &tpm2.CapabilitiesU{Command: attrs}})
+	case tpm2.CommandLoadExternal:
+		var inPrivate struct {
+			Ptr *tpm2.Sensitive `tpm2:"sized"`
+		}
+		var inPublic struct {
+			Ptr *tpm2.Public `tpm2:"sized"`
+		}
+		var hierarchy tpm2.Handle
+		_, err := mu.UnmarshalFromBytes(params, &inPrivate, &inPublic, &hierarchy)
+		t.c.Assert(err, IsNil)
+		name, err := inPublic.Ptr.Name()
+		t.c.Assert(err, IsNil)
+		return makePacket(t.c, tpm2.ResponseCode(tpm2.Success), t.loadObject(inPublic.Ptr), name)
+	case tpm2.CommandReadPublic:
+		var h tpm2.Handle
+		_, err := mu.UnmarshalFromBytes(params, &h)
+		t.c.Assert(err, IsNil)
+		public, ok := t.objects[h]
+		if !ok {
+			return makePacket(t.c, 0x18b)
+		}
+		name, err := public.Name()
+		t.c.Assert(err, IsNil)
+		return makePacket(t.c, tpm2.ResponseCode(tpm2.Success), struct {
+			Ptr *tpm2.Public `tpm2:"sized"`
+		}{public}, name, name)
+	case tpm2.CommandContextSave:
+		var h tpm2.Handle
+		_, err := mu.UnmarshalFromBytes(params, &h)
+		t.c.Assert(err, IsNil)
+		public, ok := t.objects[h]
+		if !ok {
+			return makePacket(t.c, 0x18b)
+		}
+		blob, err := mu.MarshalToBytes(public)
+		t.c.Assert(err, IsNil)
+		return makePacket(t.c, tpm2.ResponseCode(tpm2.Success),
+			&tpm2.Context{SavedHandle: 0x80000000, Hierarchy: tpm2.HandleOwner, Blob: blob})
+	case tpm2.CommandContextLoad:
+		var context tpm2.Context
+		_, err := mu.UnmarshalFromBytes(params, &context)
+		t.c.Assert(err, IsNil)
+		var public *tpm2.Public
+		_, err = mu.UnmarshalFromBytes(context.Blob, &public)
+		t.c.Assert(err, IsNil)
+		return makePacket(t.c, tpm2.ResponseCode(tpm2.Success), t.loadObject(public))
+	case tpm2.CommandFlushContext:
+		var h tpm2.Handle
+		_, err := mu.UnmarshalFromBytes(params, &h)
+		t.c.Assert(err, IsNil)
+		if _, ok := t.objects[h]; !ok {
+			return makePacket(t.c, 0x1cb)
+		}
+		delete(t.objects, h)
+		return makePacket(t.c, tpm2.ResponseCode(tpm2.Success))
+	default:
+		return makePacket(t.c, 0x143)
+	}
+}
+
+func (t *testTpm) Read(data []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.rsp == nil {
+		return 0, errors.New("no command submitted")
+	}
+	return t.rsp.Read(data)
+}
+
+func (t *testTpm) Write(data []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rsp = bytes.NewReader(t.processCommand(data))
+	return len(data), nil
+}
+
+func (t *testTpm) Close() error {
+	return nil
+}
+
+func (t *testTpm) SetLocality(locality uint8) error {
+	return errors.New("not implemented")
+}
+
+func (t *testTpm) MakeSticky(handle tpm2.Handle, sticky bool) error {
+	return errors.New("not implemented")
+}
+
+type serverSuite struct {
+	testutil.BaseTest
+	tpmDevice    *testTpm
+	server       *Server
+	tpmPort      uint
+	platformPort uint
+	serveErr     chan error
+}
+
+var _ = Suite(&serverSuite{})
+
+func (s *serverSuite) startServer(c *C, allowedCommands []tpm2.CommandCode) {
+	s.tpmDevice = newTestTpm(c)
+	s.server = NewServer(s.tpmDevice, allowedCommands)
+
+	tpmListener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	platformListener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	s.tpmPort = uint(tpmListener.Addr().(*net.TCPAddr).Port)
+	s.platformPort = uint(platformListener.Addr().(*net.TCPAddr).Port)
+
+	s.serveErr = make(chan error, 1)
+	go func() {
+		s.serveErr <- s.server.Serve(tpmListener, platformListener)
+	}()
+
+	s.AddCleanup(func() {
+		c.Check(s.server.Close(), IsNil)
+		c.Check(<-s.serveErr, Equals, ErrServerClosed)
+	})
+}
+
+func (s *serverSuite) connect(c *C) (*tpm2.TPMContext, *tpm2.TctiMssim) {
+	tcti, err := tpm2.OpenMssim("127.0.0.1", s.tpmPort, s.platformPort)
+	c.Assert(err, IsNil)
+	tpm, _ := tpm2.NewTPMContext(tcti)
+	s.AddCleanup(func() {
+		tpm.Close()
+	})
+	return tpm, tcti
+}
+
+func (s *serverSuite) loadObject(c *C, tpm *tpm2.TPMContext) (tpm2.ResourceContext, *tpm2.Public) {
+	public := &tpm2.Public{
+		Type:    tpm2.ObjectTypeKeyedHash,
+		NameAlg: tpm2.HashAlgorithmSHA256,
+		Attrs:   tpm2.AttrUserWithAuth,
+		Params:  &tpm2.PublicParamsU{KeyedHashDetail: &tpm2.KeyedHashParams{Scheme: tpm2.KeyedHashScheme{Scheme: tpm2.KeyedHashSchemeNull}}},
+		Unique:  &tpm2.PublicIDU{KeyedHash: make(tpm2.Digest, 32)}}
+	object, err := tpm.LoadExternal(nil, public, tpm2.HandleOwner)
+	c.Assert(err, IsNil)
+	return object, public
+}
+
+func (s *serverSuite) TestForwardCommands(c *C) {
+	s.startServer(c, nil)
+	tpm, _ := s.connect(c)
+
+	object, public := s.loadObject(c, tpm)
+	c.Check(object.Handle().Type(), Equals, tpm2.HandleTypeTransient)
+
+	outPublic, name, _, err := tpm.ReadPublic(object)
+	c.Check(err, IsNil)
+	c.Check(outPublic.Unique, DeepEquals, public.Unique)
+	c.Check(name, DeepEquals, object.Name())
+}
+
+func (s *serverSuite) TestResourcesEvictedBetweenCommands(c *C) {
+	s.startServer(c, nil)
+	tpm, _ := s.connect(c)
+
+	object, _ := s.loadObject(c, tpm)
+	c.Check(s.tpmDevice.numObjects(), Equals, 0)
+
+	_, _, _, err := tpm.ReadPublic(object)
+	c.Check(err, IsNil)
+	c.Check(s.tpmDevice.numObjects(), Equals, 0)
+	c.Check(s.tpmDevice.numCommands(tpm2.CommandContextLoad), Equals, 1)
+}
+
+func (s *serverSuite) TestConnectionIsolation(c *C) {
+	s.startServer(c, nil)
+	tpmA, _ := s.connect(c)
+	tpmB, _ := s.connect(c)
+
+	object, _ := s.loadObject(c, tpmA)
+
+	cmd, err := mu.MarshalToBytes(object.Handle())
+	c.Assert(err, IsNil)
+	rc, _, _, err := tpmB.RunCommandBytes(tpm2.TagNoSessions, tpm2.CommandReadPublic, cmd)
+	c.Check(err, IsNil)
+	c.Check(rc, Equals, tpm2.ResponseCode(0x18b))
+	c.Check(s.tpmDevice.numCommands(tpm2.CommandReadPublic), Equals, 0)
+
+	_, _, _, err = tpmA.ReadPublic(object)
+	c.Check(err, IsNil)
+}
+
+func (s *serverSuite) TestAllowedCommands(c *C) {
+	s.startServer(c, []tpm2.CommandCode{tpm2.CommandLoadExternal})
+	tpm, _ := s.connect(c)
+
+	object, _ := s.loadObject(c, tpm)
+
+	_, _, _, err := tpm.ReadPublic(object)
+	c.Check(tpm2.IsTPMError(err, tpm2.ErrorCommandCode, tpm2.CommandReadPublic), testutil.IsTrue)
+	c.Check(s.tpmDevice.numCommands(tpm2.CommandReadPublic), Equals, 0)
+}
+
+func (s *serverSuite) TestPlatformCommandDenied(c *C) {
+	s.startServer(c, nil)
+	_, tcti := s.connect(c)
+
+	err := tcti.Reset()
+	c.Check(err, ErrorMatches, "received error code 1 in response to platform command 17")
+}
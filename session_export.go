@@ -0,0 +1,199 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
+)
+
+// sessionExportLabel is the additional authenticated data used when encrypting an exported session context.
+var sessionExportLabel = []byte("SESSION-CONTEXT")
+
+// sessionExport is the format of a session context exported by TPMContext.ExportSessionContext or
+// TPMContext.ExportSessionContextSealed.
+type sessionExport struct {
+	SealedKeyPrivate Private     // The private area of the sealed object containing the key, if the key is sealed to the TPM
+	SealedKeyPublic  publicSized // The public area of the sealed object containing the key, if the key is sealed to the TPM
+	Nonce            []byte
+	Ciphertext       []byte // The encrypted Context returned from TPMContext.ContextSave
+}
+
+func newSessionExportAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// saveAndEncryptSessionContext saves the context of the supplied session and encrypts it with the supplied key.
+func (t *TPMContext) saveAndEncryptSessionContext(session SessionContext, key []byte, export *sessionExport) ([]byte, error) {
+	aead, err := newSessionExportAEAD(key)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot create cipher: %w", err)
+	}
+
+	// The host-side audit digest isn't part of the saved context, so it is exported alongside it.
+	auditDigest := SessionAuditDigest(session)
+
+	context, err := t.ContextSave(session)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot save session context: %w", err)
+	}
+	data, err := mu.MarshalToBytes(context, auditDigest)
+	if err != nil {
+		panic(fmt.Sprintf("cannot marshal context: %v", err))
+	}
+
+	export.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(export.Nonce); err != nil {
+		return nil, xerrors.Errorf("cannot obtain nonce: %w", err)
+	}
+	export.Ciphertext = aead.Seal(nil, export.Nonce, data, sessionExportLabel)
+
+	b, err := mu.MarshalToBytes(export)
+	if err != nil {
+		panic(fmt.Sprintf("cannot marshal exported session context: %v", err))
+	}
+	return b, nil
+}
+
+// decryptAndLoadSessionContext decrypts the supplied exported session context with the supplied key and loads it in to the TPM.
+func (t *TPMContext) decryptAndLoadSessionContext(export *sessionExport, key []byte) (SessionContext, error) {
+	aead, err := newSessionExportAEAD(key)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot create cipher: %w", err)
+	}
+	if len(export.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce size")
+	}
+
+	data, err := aead.Open(nil, export.Nonce, export.Ciphertext, sessionExportLabel)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot decrypt session context: %w", err)
+	}
+
+	var context Context
+	var auditDigest Digest
+	if _, err := mu.UnmarshalFromBytes(data, &context, &auditDigest); err != nil {
+		return nil, xerrors.Errorf("cannot unmarshal context: %w", err)
+	}
+
+	hc, err := t.ContextLoad(&context)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot load session context: %w", err)
+	}
+	session, ok := hc.(*sessionContext)
+	if !ok {
+		return nil, errors.New("context does not correspond to a session")
+	}
+	if len(auditDigest) > 0 {
+		session.host.auditDigest = auditDigest
+	}
+	return session, nil
+}
+
+func unmarshalSessionExport(data []byte) (*sessionExport, error) {
+	var export sessionExport
+	if _, err := mu.UnmarshalFromBytes(data, &export); err != nil {
+		return nil, xerrors.Errorf("cannot unmarshal exported session context: %w", err)
+	}
+	return &export, nil
+}
+
+// ExportSessionContext saves the context of the supplied session with TPMContext.ContextSave and returns it in a form that is
+// encrypted and integrity protected with the supplied key, so that it can be stored or handed to another process and restored with
+// TPMContext.ImportSessionContext. Unlike the blob returned from TPMContext.ContextSave, the host-side state of the session
+// (including the session key and nonces) is not exposed. The key must be a valid AES key (16, 24 or 32 bytes).
+//
+// As with TPMContext.ContextSave, session can only be passed to TPMContext.FlushContext on success. The session remains on the TPM
+// as a saved session, and it can only be restored whilst the TPM retains it. It will not survive a TPM reset or restart, and it
+// will no longer be restorable if it is flushed.
+func (t *TPMContext) ExportSessionContext(session SessionContext, key []byte) ([]byte, error) {
+	if session == nil {
+		return nil, makeInvalidArgError("session", "nil value")
+	}
+	return t.saveAndEncryptSessionContext(session, key, new(sessionExport))
+}
+
+// ImportSessionContext restores a session context that was previously exported with TPMContext.ExportSessionContext, using the
+// same key that was used to export it. An error will be returned if the exported context cannot be decrypted or its integrity
+// check fails. On success, the session is loaded back on to the TPM and a new SessionContext is returned.
+func (t *TPMContext) ImportSessionContext(data, key []byte) (SessionContext, error) {
+	export, err := unmarshalSessionExport(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(export.SealedKeyPrivate) > 0 {
+		return nil, errors.New("exported session context is sealed to the TPM")
+	}
+	return t.decryptAndLoadSessionContext(export, key)
+}
+
+// ExportSessionContextSealed is similar to TPMContext.ExportSessionContext, except that the key used to protect the session
+// context is generated randomly and sealed to the TPM using the storage key associated with parent. The exported context can
+// only be restored with TPMContext.ImportSessionContextSealed on the same TPM, whilst parent is available. The parent handle
+// requires authorization with the user auth role, with session based authorization provided via parentAuthSession.
+//
+// The sessions parameter can be used to supply a session for encrypting the sealed key in transit to the TPM.
+func (t *TPMContext) ExportSessionContextSealed(session SessionContext, parent ResourceContext, parentAuthSession SessionContext, sessions ...SessionContext) ([]byte, error) {
+	if session == nil {
+		return nil, makeInvalidArgError("session", "nil value")
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, xerrors.Errorf("cannot obtain key: %w", err)
+	}
+
+	template := Public{
+		Type:    ObjectTypeKeyedHash,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrUserWithAuth | AttrNoDA,
+		Params:  &PublicParamsU{KeyedHashDetail: &KeyedHashParams{Scheme: KeyedHashScheme{Scheme: KeyedHashSchemeNull}}}}
+	priv, pub, _, _, _, err := t.Create(parent, &SensitiveCreate{Data: key}, &template, nil, nil, parentAuthSession, sessions...)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot seal key: %w", err)
+	}
+
+	return t.saveAndEncryptSessionContext(session, key, &sessionExport{SealedKeyPrivate: priv, SealedKeyPublic: publicSized{pub}})
+}
+
+// ImportSessionContextSealed restores a session context that was previously exported with TPMContext.ExportSessionContextSealed.
+// The key used to protect the session context is unsealed using the storage key associated with parent, which must be the same
+// key that was used to export it. The parent handle requires authorization with the user auth role, with session based
+// authorization provided via parentAuthSession.
+//
+// The sessions parameter can be used to supply a session for encrypting the unsealed key in transit from the TPM.
+func (t *TPMContext) ImportSessionContextSealed(data []byte, parent ResourceContext, parentAuthSession SessionContext, sessions ...SessionContext) (SessionContext, error) {
+	export, err := unmarshalSessionExport(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(export.SealedKeyPrivate) == 0 || export.SealedKeyPublic.Ptr == nil {
+		return nil, errors.New("exported session context is not sealed to the TPM")
+	}
+
+	object, err := t.Load(parent, export.SealedKeyPrivate, export.SealedKeyPublic.Ptr, parentAuthSession)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot load sealed key: %w", err)
+	}
+	defer t.FlushContext(object)
+
+	key, err := t.Unseal(object, nil, sessions...)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot unseal key: %w", err)
+	}
+
+	return t.decryptAndLoadSessionContext(export, key)
+}
@@ -0,0 +1,150 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+type sessionExportSuite struct {
+	testutil.TPMTest
+	key []byte
+}
+
+var _ = Suite(&sessionExportSuite{TPMTest: testutil.TPMTest{TPMFeatures: testutil.TPMFeatureOwnerHierarchy}})
+
+func (s *sessionExportSuite) SetUpTest(c *C) {
+	s.TPMTest.SetUpTest(c)
+	c.Assert(s.TPM.InitProperties(), IsNil)
+	s.key = testutil.DecodeHexString(c, "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+}
+
+func (s *sessionExportSuite) startAuditSession(c *C) SessionContext {
+	session, err := s.TPM.StartAuthSession(nil, nil, SessionTypeHMAC, nil, HashAlgorithmSHA256)
+	c.Assert(err, IsNil)
+	s.AddCleanupFlushContext(c, session)
+	_, err = s.TPM.GetRandom(8, session.WithAttrs(AttrContinueSession|AttrAudit))
+	c.Assert(err, IsNil)
+	return session
+}
+
+// newProcess returns a new TPMContext for the same TPM, in order to simulate the session being restored by another process.
+func (s *sessionExportSuite) newProcess() *TPMContext {
+	tpm, _ := NewTPMContext(s.TCTI)
+	return tpm
+}
+
+func (s *sessionExportSuite) createPrimary(c *C) ResourceContext {
+	template := Public{
+		Type:    ObjectTypeECC,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrNoDA | AttrRestricted | AttrDecrypt,
+		Params: &PublicParamsU{
+			ECCDetail: &ECCParams{
+				Symmetric: SymDefObject{
+					Algorithm: SymObjectAlgorithmAES,
+					KeyBits:   &SymKeyBitsU{Sym: 128},
+					Mode:      &SymModeU{Sym: SymModeCFB}},
+				Scheme:  ECCScheme{Scheme: ECCSchemeNull},
+				CurveID: ECCCurveNIST_P256,
+				KDF:     KDFScheme{Scheme: KDFAlgorithmNull}}}}
+	primary, _, _, _, _, err := s.TPM.CreatePrimary(s.TPM.OwnerHandleContext(), nil, &template, nil, nil, nil)
+	c.Assert(err, IsNil)
+	s.AddCleanupFlushContext(c, primary)
+	return primary
+}
+
+func (s *sessionExportSuite) TestExportImport(c *C) {
+	session := s.startAuditSession(c)
+	handle := session.Handle()
+
+	data, err := s.TPM.ExportSessionContext(session, s.key)
+	c.Assert(err, IsNil)
+	c.Check(session.NonceTPM(), IsNil)
+
+	tpm := s.newProcess()
+	restored, err := tpm.ImportSessionContext(data, s.key)
+	c.Assert(err, IsNil)
+	c.Check(restored.Handle(), Equals, handle)
+	c.Check(restored.IsAudit(), testutil.IsTrue)
+
+	// Check that the session can continue to be used, which requires the session key and nonces to have been restored.
+	_, err = tpm.GetRandom(8, restored.WithAttrs(AttrContinueSession|AttrAudit))
+	c.Check(err, IsNil)
+}
+
+func (s *sessionExportSuite) TestImportWrongKey(c *C) {
+	session := s.startAuditSession(c)
+	data, err := s.TPM.ExportSessionContext(session, s.key)
+	c.Assert(err, IsNil)
+
+	key := make([]byte, len(s.key))
+	_, err = s.TPM.ImportSessionContext(data, key)
+	c.Check(err, ErrorMatches, "cannot decrypt session context: cipher: message authentication failed")
+}
+
+func (s *sessionExportSuite) TestImportTampered(c *C) {
+	session := s.startAuditSession(c)
+	data, err := s.TPM.ExportSessionContext(session, s.key)
+	c.Assert(err, IsNil)
+
+	data[len(data)-1] ^= 0xff
+	_, err = s.TPM.ImportSessionContext(data, s.key)
+	c.Check(err, ErrorMatches, "cannot decrypt session context: cipher: message authentication failed")
+}
+
+func (s *sessionExportSuite) TestExportInvalidKey(c *C) {
+	session := s.startAuditSession(c)
+	_, err := s.TPM.ExportSessionContext(session, []byte("foo"))
+	c.Check(err, ErrorMatches, "cannot create cipher: crypto/aes: invalid key size 3")
+
+	// Check that the session wasn't saved.
+	_, err = s.TPM.GetRandom(8, session.WithAttrs(AttrContinueSession|AttrAudit))
+	c.Check(err, IsNil)
+}
+
+func (s *sessionExportSuite) TestExportImportSealed(c *C) {
+	primary := s.createPrimary(c)
+	session := s.startAuditSession(c)
+
+	data, err := s.TPM.ExportSessionContextSealed(session, primary, nil)
+	c.Assert(err, IsNil)
+
+	restored, err := s.TPM.ImportSessionContextSealed(data, primary, nil)
+	c.Assert(err, IsNil)
+	c.Check(restored.IsAudit(), testutil.IsTrue)
+
+	_, err = s.TPM.GetRandom(8, restored.WithAttrs(AttrContinueSession|AttrAudit))
+	c.Check(err, IsNil)
+
+	handles, err := s.TPM.GetCapabilityHandles(HandleTypeTransient.BaseHandle(), CapabilityMaxProperties)
+	c.Check(err, IsNil)
+	c.Check(handles, DeepEquals, HandleList{primary.Handle()})
+}
+
+func (s *sessionExportSuite) TestImportSealedWithKey(c *C) {
+	primary := s.createPrimary(c)
+	session := s.startAuditSession(c)
+
+	data, err := s.TPM.ExportSessionContextSealed(session, primary, nil)
+	c.Assert(err, IsNil)
+
+	_, err = s.TPM.ImportSessionContext(data, s.key)
+	c.Check(err, ErrorMatches, "exported session context is sealed to the TPM")
+}
+
+func (s *sessionExportSuite) TestImportUnsealedWithParent(c *C) {
+	primary := s.createPrimary(c)
+	session := s.startAuditSession(c)
+
+	data, err := s.TPM.ExportSessionContext(session, s.key)
+	c.Assert(err, IsNil)
+
+	_, err = s.TPM.ImportSessionContextSealed(data, primary, nil)
+	c.Check(err, ErrorMatches, "exported session context is not sealed to the TPM")
+}
@@ -0,0 +1,383 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"math/big"
+
+	"github.com/canonical/go-tpm2"
+)
+
+// maxCommits is the number of outstanding TPM2_Commit values that the TPM keeps track of.
+const maxCommits = 16
+
+// eccPointSized corresponds to the TPM2B_ECC_POINT type.
+type eccPointSized struct {
+	Ptr *tpm2.ECCPoint `tpm2:"sized"`
+}
+
+// algorithmDetailECC corresponds to the TPMS_ALGORITHM_DETAIL_ECC type.
+type algorithmDetailECC struct {
+	CurveID tpm2.ECCCurve
+	KeySize uint16
+	KDF     tpm2.KDFScheme
+	Sign    tpm2.ECCScheme
+	P       tpm2.ECCParameter
+	A       tpm2.ECCParameter
+	B       tpm2.ECCParameter
+	GX      tpm2.ECCParameter
+	GY      tpm2.ECCParameter
+	N       tpm2.ECCParameter
+	H       tpm2.ECCParameter
+}
+
+// makeECCPoint returns the TPMS_ECC_POINT representation of the supplied point.
+func makeECCPoint(curve elliptic.Curve, x, y *big.Int) *tpm2.ECCPoint {
+	sz := (curve.Params().BitSize + 7) / 8
+	return &tpm2.ECCPoint{X: zeroExtend(x, sz), Y: zeroExtend(y, sz)}
+}
+
+// unmarshalECCPoint returns the coordinates of the supplied point, and indicates whether it is on the supplied curve.
+func unmarshalECCPoint(curve elliptic.Curve, p *tpm2.ECCPoint) (*big.Int, *big.Int, bool) {
+	if p == nil {
+		return nil, nil, false
+	}
+	x := new(big.Int).SetBytes(p.X)
+	y := new(big.Int).SetBytes(p.Y)
+	return x, y, curve.IsOnCurve(x, y)
+}
+
+// lookupKeyForDecrypt returns the key associated with the command handle at the specified index, and checks that it is
+// an unrestricted decryption key of the specified type.
+func (t *TPM) lookupKeyForDecrypt(c *command, index int, typ tpm2.ObjectTypeId) (*object, tpm2.ResponseCode) {
+	key := t.lookupObject(c.handles[index-1])
+	if key == nil || key.seq != nil || key.public.Type != typ {
+		return nil, rcHandle(tpm2.ErrorKey, index)
+	}
+	if key.public.Attrs&(tpm2.AttrDecrypt|tpm2.AttrRestricted) != tpm2.AttrDecrypt {
+		return nil, rcHandle(tpm2.ErrorAttributes, index)
+	}
+	return key, tpm2.Success
+}
+
+// selectRSADecryptScheme determines the padding scheme for TPM2_RSA_Encrypt and TPM2_RSA_Decrypt.
+func selectRSADecryptScheme(key *object, in *tpm2.RSAScheme) (*tpm2.RSAScheme, bool) {
+	scheme := &key.public.Params.RSADetail.Scheme
+	if scheme.Scheme == tpm2.RSASchemeNull {
+		scheme = in
+	} else if in.Scheme != tpm2.RSASchemeNull && in.Scheme != scheme.Scheme {
+		return nil, false
+	}
+	switch scheme.Scheme {
+	case tpm2.RSASchemeNull, tpm2.RSASchemeRSAES:
+		return scheme, true
+	case tpm2.RSASchemeOAEP:
+		if scheme.Details == nil || scheme.Details.OAEP == nil || !isSupportedHash(scheme.Details.OAEP.HashAlg) {
+			return nil, false
+		}
+		return scheme, true
+	default:
+		return nil, false
+	}
+}
+
+// oaepLabel returns the supplied label with a terminating zero byte, which is added if it isn't already present.
+func oaepLabel(label tpm2.Data) []byte {
+	if len(label) == 0 || label[len(label)-1] == 0 {
+		return label
+	}
+	return append(append([]byte(nil), label...), 0)
+}
+
+func (t *TPM) rsaEncrypt(c *command) tpm2.ResponseCode {
+	var message tpm2.PublicKeyRSA
+	var inScheme tpm2.RSAScheme
+	var label tpm2.Data
+	if rc := c.unmarshal(&message, &inScheme, &label); rc != tpm2.Success {
+		return rc
+	}
+
+	key := t.lookupObject(c.handles[0])
+	if key == nil || key.seq != nil || key.public.Type != tpm2.ObjectTypeRSA {
+		return rcHandle(tpm2.ErrorKey, 1)
+	}
+	if key.public.Attrs&tpm2.AttrDecrypt == 0 {
+		return rcHandle(tpm2.ErrorAttributes, 1)
+	}
+	scheme, ok := selectRSADecryptScheme(key, &inScheme)
+	if !ok {
+		return rcParam(tpm2.ErrorScheme, 2)
+	}
+
+	pub := key.public.Public().(*rsa.PublicKey)
+	var outData []byte
+	var err error
+	switch scheme.Scheme {
+	case tpm2.RSASchemeOAEP:
+		outData, err = rsa.EncryptOAEP(scheme.Details.OAEP.HashAlg.NewHash(), rand.Reader, pub, message, oaepLabel(label))
+	case tpm2.RSASchemeRSAES:
+		outData, err = rsa.EncryptPKCS1v15(rand.Reader, pub, message)
+	default:
+		m := new(big.Int).SetBytes(message)
+		if m.Cmp(pub.N) >= 0 {
+			return rcParam(tpm2.ErrorValue, 1)
+		}
+		outData = zeroExtend(m.Exp(m, big.NewInt(int64(pub.E)), pub.N), pub.Size())
+	}
+	if err != nil {
+		return rcParam(tpm2.ErrorValue, 1)
+	}
+
+	return c.respond(tpm2.PublicKeyRSA(outData))
+}
+
+func (t *TPM) rsaDecrypt(c *command) tpm2.ResponseCode {
+	var cipherText tpm2.PublicKeyRSA
+	var inScheme tpm2.RSAScheme
+	var label tpm2.Data
+	if rc := c.unmarshal(&cipherText, &inScheme, &label); rc != tpm2.Success {
+		return rc
+	}
+
+	key, rc := t.lookupKeyForDecrypt(c, 1, tpm2.ObjectTypeRSA)
+	if rc != tpm2.Success {
+		return rc
+	}
+	scheme, ok := selectRSADecryptScheme(key, &inScheme)
+	if !ok {
+		return rcParam(tpm2.ErrorScheme, 2)
+	}
+
+	priv := key.priv.(*rsa.PrivateKey)
+	if len(cipherText) != priv.Size() {
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+
+	var message []byte
+	var err error
+	switch scheme.Scheme {
+	case tpm2.RSASchemeOAEP:
+		message, err = rsa.DecryptOAEP(scheme.Details.OAEP.HashAlg.NewHash(), rand.Reader, priv, cipherText, oaepLabel(label))
+	case tpm2.RSASchemeRSAES:
+		message, err = rsa.DecryptPKCS1v15(rand.Reader, priv, cipherText)
+	default:
+		m := new(big.Int).SetBytes(cipherText)
+		if m.Cmp(priv.N) >= 0 {
+			return rcParam(tpm2.ErrorValue, 1)
+		}
+		message = zeroExtend(m.Exp(m, priv.D, priv.N), priv.Size())
+	}
+	if err != nil {
+		return rcParam(tpm2.ErrorValue, 1)
+	}
+
+	return c.respond(tpm2.PublicKeyRSA(message))
+}
+
+func (t *TPM) ecdhKeyGen(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+
+	key := t.lookupObject(c.handles[0])
+	if key == nil || key.seq != nil || key.public.Type != tpm2.ObjectTypeECC {
+		return rcHandle(tpm2.ErrorKey, 1)
+	}
+
+	pub := key.public.Public().(*ecdsa.PublicKey)
+	eph := generateECCKey(nil, pub.Curve)
+	zx, zy := pub.Curve.ScalarMult(pub.X, pub.Y, eph.D.Bytes())
+
+	return c.respond(eccPointSized{makeECCPoint(pub.Curve, zx, zy)}, eccPointSized{makeECCPoint(pub.Curve, eph.X, eph.Y)})
+}
+
+func (t *TPM) ecdhZGen(c *command) tpm2.ResponseCode {
+	var inPoint eccPointSized
+	if rc := c.unmarshal(&inPoint); rc != tpm2.Success {
+		return rc
+	}
+
+	key, rc := t.lookupKeyForDecrypt(c, 1, tpm2.ObjectTypeECC)
+	if rc != tpm2.Success {
+		return rc
+	}
+	switch key.public.Params.ECCDetail.Scheme.Scheme {
+	case tpm2.ECCSchemeNull, tpm2.ECCSchemeECDH:
+	default:
+		return rcHandle(tpm2.ErrorScheme, 1)
+	}
+
+	priv := key.priv.(*ecdsa.PrivateKey)
+	x, y, ok := unmarshalECCPoint(priv.Curve, inPoint.Ptr)
+	if !ok {
+		return rcParam(tpm2.ErrorECCPoint, 1)
+	}
+	zx, zy := priv.Curve.ScalarMult(x, y, priv.D.Bytes())
+
+	return c.respond(eccPointSized{makeECCPoint(priv.Curve, zx, zy)})
+}
+
+func (t *TPM) eccParameters(c *command) tpm2.ResponseCode {
+	var curveID tpm2.ECCCurve
+	if rc := c.unmarshal(&curveID); rc != tpm2.Success {
+		return rc
+	}
+
+	curve := eccCurve(curveID)
+	if curve == nil {
+		return rcParam(tpm2.ErrorValue, 1)
+	}
+	params := curve.Params()
+	sz := (params.BitSize + 7) / 8
+
+	// All of the supported curves have a = -3.
+	a := new(big.Int).Sub(params.P, big.NewInt(3))
+
+	return c.respond(&algorithmDetailECC{
+		CurveID: curveID,
+		KeySize: uint16(params.BitSize),
+		KDF:     tpm2.KDFScheme{Scheme: tpm2.KDFAlgorithmNull},
+		Sign:    tpm2.ECCScheme{Scheme: tpm2.ECCSchemeNull},
+		P:       zeroExtend(params.P, sz),
+		A:       zeroExtend(a, sz),
+		B:       zeroExtend(params.B, sz),
+		GX:      zeroExtend(params.Gx, sz),
+		GY:      zeroExtend(params.Gy, sz),
+		N:       zeroExtend(params.N, (params.N.BitLen()+7)/8),
+		H:       tpm2.ECCParameter{0x01}})
+}
+
+// generateCommitR derives the ephemeral private value associated with the specified commit counter and key.
+func (t *TPM) generateCommitR(key *object, counter uint16) *big.Int {
+	n := key.priv.(*ecdsa.PrivateKey).Curve.Params().N
+	r := new(big.Int).SetBytes(kdfa(ticketHashAlg, t.commitNonce, "ECDAA Commit", key.name, uint64Bytes(uint64(counter)),
+		n.BitLen()+64))
+	return r.Mod(r, n)
+}
+
+func (t *TPM) commit(c *command) tpm2.ResponseCode {
+	var p1 eccPointSized
+	var s2 tpm2.SensitiveData
+	var y2 tpm2.ECCParameter
+	if rc := c.unmarshal(&p1, &s2, &y2); rc != tpm2.Success {
+		return rc
+	}
+
+	key := t.lookupObject(c.handles[0])
+	if key == nil || key.seq != nil || key.public.Type != tpm2.ObjectTypeECC || key.sensitive == nil {
+		return rcHandle(tpm2.ErrorKey, 1)
+	}
+	if key.public.Attrs&tpm2.AttrSign == 0 {
+		return rcHandle(tpm2.ErrorAttributes, 1)
+	}
+	if key.public.Params.ECCDetail.Scheme.Scheme != tpm2.ECCSchemeECDAA {
+		return rcHandle(tpm2.ErrorScheme, 1)
+	}
+	if (len(s2) == 0) != (len(y2) == 0) {
+		return rcParam(tpm2.ErrorSize, 3)
+	}
+
+	priv := key.priv.(*ecdsa.PrivateKey)
+	curve := priv.Curve
+	params := curve.Params()
+
+	var p1x, p1y *big.Int
+	if p1.Ptr != nil && (len(p1.Ptr.X) > 0 || len(p1.Ptr.Y) > 0) {
+		var ok bool
+		p1x, p1y, ok = unmarshalECCPoint(curve, p1.Ptr)
+		if !ok {
+			return rcParam(tpm2.ErrorECCPoint, 1)
+		}
+	}
+
+	var mx, my *big.Int
+	if len(s2) > 0 {
+		h := key.public.NameAlg.NewHash()
+		h.Write(s2)
+		mx = new(big.Int).SetBytes(h.Sum(nil))
+		mx.Mod(mx, params.P)
+		my = new(big.Int).SetBytes(y2)
+		if !curve.IsOnCurve(mx, my) {
+			return rcParam(tpm2.ErrorECCPoint, 2)
+		}
+	}
+
+	counter := t.commitCounter
+	r := t.generateCommitR(key, counter).Bytes()
+
+	var k, l, e *tpm2.ECCPoint
+	if mx != nil {
+		kx, ky := curve.ScalarMult(mx, my, priv.D.Bytes())
+		k = makeECCPoint(curve, kx, ky)
+		lx, ly := curve.ScalarMult(mx, my, r)
+		l = makeECCPoint(curve, lx, ly)
+	}
+	switch {
+	case p1x != nil:
+		ex, ey := curve.ScalarMult(p1x, p1y, r)
+		e = makeECCPoint(curve, ex, ey)
+	case mx == nil:
+		ex, ey := curve.ScalarBaseMult(r)
+		e = makeECCPoint(curve, ex, ey)
+	}
+
+	t.commitCounter++
+	t.commits[counter] = struct{}{}
+	delete(t.commits, counter-maxCommits)
+
+	return c.respond(eccPointSized{k}, eccPointSized{l}, eccPointSized{e}, counter)
+}
+
+// signECDAA signs the supplied digest using the ECDAA scheme, with the ephemeral value created by a previous call to
+// TPM2_Commit. The value can only be used once.
+func (t *TPM) signECDAA(key *object, scheme signScheme, digest []byte) (*tpm2.Signature, bool) {
+	if _, ok := t.commits[scheme.count]; !ok {
+		return nil, false
+	}
+	delete(t.commits, scheme.count)
+
+	priv := key.priv.(*ecdsa.PrivateKey)
+	n := priv.Curve.Params().N
+	r := t.generateCommitR(key, scheme.count)
+
+	// T = H(nonceK || digest), s = (r + T*d) mod n
+	nonceK := randomBytes(scheme.hashAlg.Size())
+	h := scheme.hashAlg.NewHash()
+	h.Write(nonceK)
+	h.Write(digest)
+	s := new(big.Int).SetBytes(h.Sum(nil))
+	s.Mul(s, priv.D)
+	s.Add(s, r)
+	s.Mod(s, n)
+
+	return &tpm2.Signature{
+		SigAlg: tpm2.SigSchemeAlgECDAA,
+		Signature: &tpm2.SignatureU{
+			ECDAA: &tpm2.SignatureECDAA{
+				Hash:       scheme.hashAlg,
+				SignatureR: nonceK,
+				SignatureS: zeroExtend(s, (n.BitLen()+7)/8)}}}, true
+}
+
+// sign signs the supplied digest with the supplied key and scheme. The schemeIndex is the parameter index of the signing
+// scheme, and is used to construct the returned error.
+func (t *TPM) sign(key *object, scheme signScheme, digest []byte, schemeIndex int) (*tpm2.Signature, tpm2.ResponseCode) {
+	if scheme.alg == tpm2.SigSchemeAlgECDAA {
+		sig, ok := t.signECDAA(key, scheme, digest)
+		if !ok {
+			return nil, rcParam(tpm2.ErrorValue, schemeIndex)
+		}
+		return sig, tpm2.Success
+	}
+	sig, err := signDigest(key, scheme, digest)
+	if err != nil {
+		return nil, rcError(tpm2.ErrorFailure)
+	}
+	return sig, tpm2.Success
+}
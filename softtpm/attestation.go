@@ -0,0 +1,293 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+// attestSized corresponds to the TPM2B_ATTEST type.
+type attestSized struct {
+	Ptr *tpm2.Attest `tpm2:"sized"`
+}
+
+// attestSigner describes the key used to sign an attestation structure.
+type attestSigner struct {
+	key         *object // nil if the signing handle is TPM_RH_NULL
+	scheme      signScheme
+	schemeIndex int // The parameter index of the inScheme parameter
+}
+
+// checkAttestSigner checks the signing key associated with the command handle at the specified index, and selects the
+// signing scheme. The schemeIndex is the parameter index of the inScheme parameter.
+func (t *TPM) checkAttestSigner(c *command, index int, inScheme *tpm2.SigScheme, schemeIndex int) (*attestSigner, tpm2.ResponseCode) {
+	h := c.handles[index-1]
+	if h == tpm2.HandleNull {
+		return &attestSigner{}, tpm2.Success
+	}
+
+	key := t.lookupObject(h)
+	if key == nil || key.seq != nil {
+		return nil, rcHandle(tpm2.ErrorHandle, index)
+	}
+	if key.public.Attrs&tpm2.AttrSign == 0 {
+		return nil, rcHandle(tpm2.ErrorKey, index)
+	}
+	if key.sensitive == nil {
+		return nil, rcHandle(tpm2.ErrorKey, index)
+	}
+	scheme, ok := selectSignScheme(key.public, inScheme)
+	if !ok {
+		return nil, rcParam(tpm2.ErrorScheme, schemeIndex)
+	}
+	return &attestSigner{key: key, scheme: scheme, schemeIndex: schemeIndex}, tpm2.Success
+}
+
+// hashAlg returns the digest algorithm associated with the signing scheme.
+func (s *attestSigner) hashAlg() tpm2.HashAlgorithmId {
+	if s.key == nil {
+		return tpm2.HashAlgorithmNull
+	}
+	return s.scheme.hashAlg
+}
+
+// makeAttest creates an attestation structure of the specified type. The reset count, restart count and firmware version
+// are obfuscated if the signing key is not in the endorsement or platform hierarchy.
+func (t *TPM) makeAttest(signer *attestSigner, tag tpm2.StructTag, qualifyingData tpm2.Data, attested *tpm2.AttestU) *tpm2.Attest {
+	attest := &tpm2.Attest{
+		Magic:           tpm2.TPMGeneratedValue,
+		Type:            tag,
+		ExtraData:       qualifyingData,
+		ClockInfo:       t.clockInfo(),
+		FirmwareVersion: uint64(firmwareVersion1)<<32 | uint64(firmwareVersion2),
+		Attested:        attested}
+	if signer.key == nil {
+		attest.QualifiedSigner = handleName(tpm2.HandleNull)
+		return attest
+	}
+	attest.QualifiedSigner = signer.key.qualifiedName
+
+	switch signer.key.hierarchy {
+	case tpm2.HandleEndorsement, tpm2.HandlePlatform:
+	default:
+		obfuscate := kdfa(ticketHashAlg, t.hierarchyProof(tpm2.HandleOwner), "OBFUSCATE", attest.QualifiedSigner, nil, 128)
+		attest.ClockInfo.ResetCount += binary.BigEndian.Uint32(obfuscate[0:])
+		attest.ClockInfo.RestartCount += binary.BigEndian.Uint32(obfuscate[4:])
+		attest.FirmwareVersion += binary.BigEndian.Uint64(obfuscate[8:])
+		if tag == tpm2.TagAttestTime {
+			attested.Time.FirmwareVersion = attest.FirmwareVersion
+		}
+	}
+	return attest
+}
+
+// signAttest signs the supplied attestation structure and returns the response.
+func (t *TPM) signAttest(c *command, signer *attestSigner, attest *tpm2.Attest) tpm2.ResponseCode {
+	if signer.key == nil {
+		return c.respond(attestSized{attest}, &tpm2.Signature{SigAlg: tpm2.SigSchemeAlgNull, Signature: &tpm2.SignatureU{}})
+	}
+
+	h := signer.scheme.hashAlg.NewHash()
+	if _, err := mu.MarshalToWriter(h, attest); err != nil {
+		return rcError(tpm2.ErrorFailure)
+	}
+	sig, rc := t.sign(signer.key, signer.scheme, h.Sum(nil), signer.schemeIndex)
+	if rc != tpm2.Success {
+		return rc
+	}
+	return c.respond(attestSized{attest}, sig)
+}
+
+func (t *TPM) certify(c *command) tpm2.ResponseCode {
+	var qualifyingData tpm2.Data
+	var inScheme tpm2.SigScheme
+	if rc := c.unmarshal(&qualifyingData, &inScheme); rc != tpm2.Success {
+		return rc
+	}
+
+	signer, rc := t.checkAttestSigner(c, 2, &inScheme, 2)
+	if rc != tpm2.Success {
+		return rc
+	}
+	o := t.lookupObject(c.handles[0])
+
+	attest := t.makeAttest(signer, tpm2.TagAttestCertify, qualifyingData, &tpm2.AttestU{
+		Certify: &tpm2.CertifyInfo{Name: o.name, QualifiedName: o.qualifiedName}})
+	return t.signAttest(c, signer, attest)
+}
+
+func (t *TPM) certifyCreation(c *command) tpm2.ResponseCode {
+	var qualifyingData tpm2.Data
+	var creationHash tpm2.Digest
+	var inScheme tpm2.SigScheme
+	var creationTicket tpm2.TkCreation
+	if rc := c.unmarshal(&qualifyingData, &creationHash, &inScheme, &creationTicket); rc != tpm2.Success {
+		return rc
+	}
+
+	signer, rc := t.checkAttestSigner(c, 1, &inScheme, 3)
+	if rc != tpm2.Success {
+		return rc
+	}
+	o := t.lookupObject(c.handles[1])
+
+	if creationTicket.Tag != tpm2.TagCreation || !t.isValidTicketHierarchy(creationTicket.Hierarchy) ||
+		!hmac.Equal(creationTicket.Digest, t.computeCreationTicket(creationTicket.Hierarchy, o.name, creationHash)) {
+		return rcParam(tpm2.ErrorTicket, 4)
+	}
+
+	attest := t.makeAttest(signer, tpm2.TagAttestCreation, qualifyingData, &tpm2.AttestU{
+		Creation: &tpm2.CreationInfo{ObjectName: o.name, CreationHash: creationHash}})
+	return t.signAttest(c, signer, attest)
+}
+
+func (t *TPM) quote(c *command) tpm2.ResponseCode {
+	var qualifyingData tpm2.Data
+	var inScheme tpm2.SigScheme
+	var pcrSelect tpm2.PCRSelectionList
+	if rc := c.unmarshal(&qualifyingData, &inScheme, &pcrSelect); rc != tpm2.Success {
+		return rc
+	}
+
+	if c.handles[0] == tpm2.HandleNull {
+		return rcHandle(tpm2.ErrorValue, 1)
+	}
+	signer, rc := t.checkAttestSigner(c, 1, &inScheme, 2)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if rc := t.checkPCRSelection(pcrSelect, 3); rc != tpm2.Success {
+		return rc
+	}
+
+	// Remove banks that aren't allocated.
+	selection := tpm2.PCRSelectionList{}
+	for _, s := range pcrSelect {
+		if !isPCRBankAllocated(s.Hash) {
+			continue
+		}
+		selection = append(selection, tpm2.PCRSelection{Hash: s.Hash, Select: selectedPCRs(s)})
+	}
+
+	attest := t.makeAttest(signer, tpm2.TagAttestQuote, qualifyingData, &tpm2.AttestU{
+		Quote: &tpm2.QuoteInfo{PCRSelect: selection, PCRDigest: t.computePCRDigest(signer.hashAlg(), selection)}})
+	return t.signAttest(c, signer, attest)
+}
+
+func (t *TPM) getTime(c *command) tpm2.ResponseCode {
+	var qualifyingData tpm2.Data
+	var inScheme tpm2.SigScheme
+	if rc := c.unmarshal(&qualifyingData, &inScheme); rc != tpm2.Success {
+		return rc
+	}
+
+	if c.handles[0] != tpm2.HandleEndorsement {
+		return rcHandle(tpm2.ErrorHierarchy, 1)
+	}
+	signer, rc := t.checkAttestSigner(c, 2, &inScheme, 2)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	info := &tpm2.TimeAttestInfo{
+		Time:            tpm2.TimeInfo{Time: t.time(), ClockInfo: t.clockInfo()},
+		FirmwareVersion: uint64(firmwareVersion1)<<32 | uint64(firmwareVersion2)}
+	attest := t.makeAttest(signer, tpm2.TagAttestTime, qualifyingData, &tpm2.AttestU{Time: info})
+	attest.ClockInfo = info.Time.ClockInfo
+	return t.signAttest(c, signer, attest)
+}
+
+func (t *TPM) getSessionAuditDigest(c *command) tpm2.ResponseCode {
+	var qualifyingData tpm2.Data
+	var inScheme tpm2.SigScheme
+	if rc := c.unmarshal(&qualifyingData, &inScheme); rc != tpm2.Success {
+		return rc
+	}
+
+	if c.handles[0] != tpm2.HandleEndorsement {
+		return rcHandle(tpm2.ErrorHierarchy, 1)
+	}
+	signer, rc := t.checkAttestSigner(c, 2, &inScheme, 2)
+	if rc != tpm2.Success {
+		return rc
+	}
+	s := t.lookupSession(c.handles[2])
+	if s.isPolicy() || !s.isAudit {
+		return rcHandle(tpm2.ErrorType, 3)
+	}
+
+	attest := t.makeAttest(signer, tpm2.TagAttestSessionAudit, qualifyingData, &tpm2.AttestU{
+		SessionAudit: &tpm2.SessionAuditInfo{
+			ExclusiveSession: t.exclusiveAuditSession == s,
+			SessionDigest:    s.auditDigest}})
+	return t.signAttest(c, signer, attest)
+}
+
+func (t *TPM) getCommandAuditDigest(c *command) tpm2.ResponseCode {
+	var qualifyingData tpm2.Data
+	var inScheme tpm2.SigScheme
+	if rc := c.unmarshal(&qualifyingData, &inScheme); rc != tpm2.Success {
+		return rc
+	}
+
+	if c.handles[0] != tpm2.HandleEndorsement {
+		return rcHandle(tpm2.ErrorHierarchy, 1)
+	}
+	signer, rc := t.checkAttestSigner(c, 2, &inScheme, 2)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	attest := t.makeAttest(signer, tpm2.TagAttestCommandAudit, qualifyingData, &tpm2.AttestU{
+		CommandAudit: &tpm2.CommandAuditInfo{
+			AuditCounter:  t.auditCounter,
+			DigestAlg:     tpm2.AlgorithmId(t.auditHashAlg),
+			AuditDigest:   t.auditDigest,
+			CommandDigest: t.computeAuditCommandsDigest()}})
+	if rc := t.signAttest(c, signer, attest); rc != tpm2.Success {
+		return rc
+	}
+
+	// The digest is cleared once it has been reported in a signed attestation.
+	if c.handles[1] != tpm2.HandleNull {
+		t.auditDigest = nil
+	}
+	return tpm2.Success
+}
+
+func (t *TPM) nvCertify(c *command) tpm2.ResponseCode {
+	var qualifyingData tpm2.Data
+	var inScheme tpm2.SigScheme
+	var size, offset uint16
+	if rc := c.unmarshal(&qualifyingData, &inScheme, &size, &offset); rc != tpm2.Success {
+		return rc
+	}
+
+	signer, rc := t.checkAttestSigner(c, 1, &inScheme, 2)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if rc := t.nvReadAccessChecks(c.handles[1], c.handles[2]); rc != tpm2.Success {
+		return rc
+	}
+	n := t.nvIndices[c.handles[2]]
+	switch {
+	case size > maxNVBuffer:
+		return rcParam(tpm2.ErrorValue, 3)
+	case int(offset)+int(size) > len(n.data):
+		return rcError(tpm2.ErrorNVRange)
+	}
+
+	attest := t.makeAttest(signer, tpm2.TagAttestNV, qualifyingData, &tpm2.AttestU{
+		NV: &tpm2.NVCertifyInfo{
+			IndexName:  n.name(),
+			Offset:     offset,
+			NVContents: tpm2.MaxNVBuffer(n.data[offset : offset+size])}})
+	return t.signAttest(c, signer, attest)
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/canonical/go-tpm2"
+)
+
+// auditedCommands returns the list of audited commands, sorted by command code.
+func (t *TPM) auditedCommands() (out tpm2.CommandCodeList) {
+	for code := range t.auditCommands {
+		out = append(out, code)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// computeAuditCommandsDigest computes the digest of the list of audited commands.
+func (t *TPM) computeAuditCommandsDigest() tpm2.Digest {
+	h := t.auditHashAlg.NewHash()
+	for _, code := range t.auditedCommands() {
+		binary.Write(h, binary.BigEndian, code)
+	}
+	return h.Sum(nil)
+}
+
+// updateCommandAudit extends the command audit digest for the supplied command if it is audited.
+func (t *TPM) updateCommandAudit(c *command) {
+	if _, ok := t.auditCommands[c.code]; !ok {
+		return
+	}
+
+	if len(t.auditDigest) == 0 {
+		// This is the first audited command since the digest was last cleared.
+		t.auditCounter++
+		t.auditDigest = make(tpm2.Digest, t.auditHashAlg.Size())
+	}
+
+	h := t.auditHashAlg.NewHash()
+	h.Write(t.auditDigest)
+	h.Write(t.computeCpHash(t.auditHashAlg, c))
+	h.Write(computeRpHash(t.auditHashAlg, c))
+	t.auditDigest = h.Sum(nil)
+}
+
+func (t *TPM) setCommandCodeAuditStatus(c *command) tpm2.ResponseCode {
+	var auditAlg tpm2.HashAlgorithmId
+	var setList, clearList tpm2.CommandCodeList
+	if rc := c.unmarshal(&auditAlg, &setList, &clearList); rc != tpm2.Success {
+		return rc
+	}
+
+	if c.handles[0] != tpm2.HandleOwner && c.handles[0] != tpm2.HandlePlatform {
+		return rcHandle(tpm2.ErrorHierarchy, 1)
+	}
+	if auditAlg != tpm2.HashAlgorithmNull && !isSupportedHash(auditAlg) {
+		return rcParam(tpm2.ErrorHash, 1)
+	}
+
+	if auditAlg != tpm2.HashAlgorithmNull && auditAlg != t.auditHashAlg {
+		// Changing the digest algorithm clears the digest, and the command lists are ignored.
+		t.auditHashAlg = auditAlg
+		t.auditDigest = nil
+		return c.respond()
+	}
+
+	changed := false
+	for _, code := range setList {
+		if _, ok := commands[code]; !ok {
+			continue
+		}
+		if _, ok := t.auditCommands[code]; !ok {
+			t.auditCommands[code] = struct{}{}
+			changed = true
+		}
+	}
+	for _, code := range clearList {
+		if code == tpm2.CommandSetCommandCodeAuditStatus {
+			continue
+		}
+		if _, ok := t.auditCommands[code]; ok {
+			delete(t.auditCommands, code)
+			changed = true
+		}
+	}
+	if changed {
+		t.auditDigest = nil
+	}
+	return c.respond()
+}
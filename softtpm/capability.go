@@ -0,0 +1,423 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/canonical/go-tpm2"
+)
+
+// maxCapData is the size of the capability data that can be returned from TPM2_GetCapability, which is the size of the
+// capability buffer excluding the capability selector and the count of returned items.
+const maxCapData = maxCapBuffer - 8
+
+// algorithms is the list of algorithms reported by TPM2_GetCapability, which must be sorted by algorithm ID.
+var algorithms = tpm2.AlgorithmPropertyList{
+	{Alg: tpm2.AlgorithmRSA, Properties: tpm2.AttrAsymmetric | tpm2.AttrObject},
+	{Alg: tpm2.AlgorithmSHA1, Properties: tpm2.AttrHash},
+	{Alg: tpm2.AlgorithmHMAC, Properties: tpm2.AttrHash | tpm2.AttrSigning},
+	{Alg: tpm2.AlgorithmAES, Properties: tpm2.AttrSymmetric},
+	{Alg: tpm2.AlgorithmMGF1, Properties: tpm2.AttrHash | tpm2.AttrMethod},
+	{Alg: tpm2.AlgorithmKeyedHash, Properties: tpm2.AttrHash | tpm2.AttrEncrypting | tpm2.AttrSigning | tpm2.AttrObject},
+	{Alg: tpm2.AlgorithmXOR, Properties: tpm2.AttrHash | tpm2.AttrSymmetric},
+	{Alg: tpm2.AlgorithmSHA256, Properties: tpm2.AttrHash},
+	{Alg: tpm2.AlgorithmSHA384, Properties: tpm2.AttrHash},
+	{Alg: tpm2.AlgorithmSHA512, Properties: tpm2.AttrHash},
+	{Alg: tpm2.AlgorithmRSASSA, Properties: tpm2.AttrAsymmetric | tpm2.AttrSigning},
+	{Alg: tpm2.AlgorithmRSAES, Properties: tpm2.AttrAsymmetric | tpm2.AttrEncrypting},
+	{Alg: tpm2.AlgorithmRSAPSS, Properties: tpm2.AttrAsymmetric | tpm2.AttrSigning},
+	{Alg: tpm2.AlgorithmOAEP, Properties: tpm2.AttrAsymmetric | tpm2.AttrEncrypting},
+	{Alg: tpm2.AlgorithmECDSA, Properties: tpm2.AttrAsymmetric | tpm2.AttrSigning},
+	{Alg: tpm2.AlgorithmECDH, Properties: tpm2.AttrAsymmetric | tpm2.AttrMethod},
+	{Alg: tpm2.AlgorithmECDAA, Properties: tpm2.AttrAsymmetric | tpm2.AttrSigning},
+	{Alg: tpm2.AlgorithmECSCHNORR, Properties: tpm2.AttrAsymmetric | tpm2.AttrSigning},
+	{Alg: tpm2.AlgorithmKDF1_SP800_108, Properties: tpm2.AttrHash | tpm2.AttrMethod},
+	{Alg: tpm2.AlgorithmECC, Properties: tpm2.AttrAsymmetric | tpm2.AttrObject},
+	{Alg: tpm2.AlgorithmSymCipher, Properties: tpm2.AttrObject},
+	{Alg: tpm2.AlgorithmCFB, Properties: tpm2.AttrSymmetric | tpm2.AttrEncrypting}}
+
+// permanentHandles is the list of permanent handles reported by TPM2_GetCapability, sorted by handle.
+var permanentHandles = tpm2.HandleList{
+	tpm2.HandleOwner,
+	tpm2.HandleNull,
+	tpm2.HandlePW,
+	tpm2.HandleLockout,
+	tpm2.HandleEndorsement,
+	tpm2.HandlePlatform,
+	tpm2.HandlePlatformNV}
+
+// eccCurves is the list of ECC curves reported by TPM2_GetCapability, sorted by curve ID.
+var eccCurves = tpm2.ECCCurveList{tpm2.ECCCurveNIST_P256, tpm2.ECCCurveNIST_P384, tpm2.ECCCurveNIST_P521}
+
+// vendorString converts the supplied 4 character string to a property value.
+func vendorString(s string) uint32 {
+	var b [4]byte
+	copy(b[:], s)
+	return binary.BigEndian.Uint32(b[:])
+}
+
+func sortHandles(handles tpm2.HandleList) {
+	sort.Slice(handles, func(i, j int) bool { return handles[i] < handles[j] })
+}
+
+// commandAttributes returns the TPMA_CC value for the supplied command.
+func commandAttributes(code tpm2.CommandCode, info *commandInfo) tpm2.CommandAttributes {
+	attrs := tpm2.CommandAttributes(code) | tpm2.CommandAttributes((info.handles&7)<<25) | info.attrs
+	if info.rHandle {
+		attrs |= tpm2.AttrRHandle
+	}
+	return attrs
+}
+
+func (t *TPM) capabilityHandles(property uint32) (out tpm2.HandleList) {
+	switch tpm2.Handle(property).Type() {
+	case tpm2.HandleTypePCR:
+		for i := 0; i < numPCRs; i++ {
+			out = append(out, tpm2.Handle(i))
+		}
+	case tpm2.HandleTypeNVIndex:
+		for h := range t.nvIndices {
+			out = append(out, h)
+		}
+	case tpm2.HandleTypeLoadedSession:
+		for _, s := range t.sessions {
+			if s.loaded {
+				out = append(out, s.handle)
+			}
+		}
+	case tpm2.HandleTypeSavedSession:
+		for _, s := range t.sessions {
+			if !s.loaded {
+				out = append(out, s.handle)
+			}
+		}
+	case tpm2.HandleTypePermanent:
+		out = append(out, permanentHandles...)
+	case tpm2.HandleTypeTransient:
+		for h := range t.objects {
+			out = append(out, h)
+		}
+	case tpm2.HandleTypePersistent:
+		for h := range t.persistent {
+			out = append(out, h)
+		}
+	}
+	sortHandles(out)
+
+	for len(out) > 0 && out[0] < tpm2.Handle(property) {
+		out = out[1:]
+	}
+	return out
+}
+
+func (t *TPM) fixedProperties() tpm2.TaggedTPMPropertyList {
+	return tpm2.TaggedTPMPropertyList{
+		{Property: tpm2.PropertyFamilyIndicator, Value: 0x322e3000},
+		{Property: tpm2.PropertyLevel, Value: 0},
+		{Property: tpm2.PropertyRevision, Value: 159},
+		{Property: tpm2.PropertyDayOfYear, Value: 1},
+		{Property: tpm2.PropertyYear, Value: 2021},
+		{Property: tpm2.PropertyManufacturer, Value: uint32(tpm2.TPMManufacturerIBM)},
+		{Property: tpm2.PropertyVendorString1, Value: vendorString("SW  ")},
+		{Property: tpm2.PropertyVendorString2, Value: vendorString(" TPM")},
+		{Property: tpm2.PropertyVendorString3, Value: vendorString("go-t")},
+		{Property: tpm2.PropertyVendorString4, Value: vendorString("pm2")},
+		{Property: tpm2.PropertyVendorTPMType, Value: 1},
+		{Property: tpm2.PropertyFirmwareVersion1, Value: firmwareVersion1},
+		{Property: tpm2.PropertyFirmwareVersion2, Value: firmwareVersion2},
+		{Property: tpm2.PropertyInputBuffer, Value: maxDigestBuffer},
+		{Property: tpm2.PropertyHRTransientMin, Value: maxLoadedObjects},
+		{Property: tpm2.PropertyHRPersistentMin, Value: maxPersistent},
+		{Property: tpm2.PropertyHRLoadedMin, Value: maxLoadedSessions},
+		{Property: tpm2.PropertyActiveSessionsMax, Value: maxActiveSessions},
+		{Property: tpm2.PropertyPCRCount, Value: numPCRs},
+		{Property: tpm2.PropertyPCRSelectMin, Value: 3},
+		{Property: tpm2.PropertyContextGapMax, Value: contextGapMax},
+		{Property: tpm2.PropertyNVCountersMax, Value: 0},
+		{Property: tpm2.PropertyNVIndexMax, Value: maxNVIndexSize},
+		{Property: tpm2.PropertyMemory, Value: 0},
+		{Property: tpm2.PropertyClockUpdate, Value: 4096},
+		{Property: tpm2.PropertyContextHash, Value: uint32(tpm2.HashAlgorithmSHA256)},
+		{Property: tpm2.PropertyContextSym, Value: uint32(tpm2.SymAlgorithmAES)},
+		{Property: tpm2.PropertyContextSymSize, Value: 256},
+		{Property: tpm2.PropertyOrderlyCount, Value: 255},
+		{Property: tpm2.PropertyMaxCommandSize, Value: maxCommandSize},
+		{Property: tpm2.PropertyMaxResponseSize, Value: maxResponseSize},
+		{Property: tpm2.PropertyMaxDigest, Value: 64},
+		{Property: tpm2.PropertyMaxObjectContext, Value: 1536},
+		{Property: tpm2.PropertyMaxSessionContext, Value: 512},
+		{Property: tpm2.PropertyPSFamilyIndicator, Value: 1},
+		{Property: tpm2.PropertyPSLevel, Value: 0},
+		{Property: tpm2.PropertyPSRevision, Value: 0x100},
+		{Property: tpm2.PropertyPSDayOfYear, Value: 0},
+		{Property: tpm2.PropertyPSYear, Value: 0},
+		{Property: tpm2.PropertySplitMax, Value: maxCommits},
+		{Property: tpm2.PropertyTotalCommands, Value: uint32(len(commands))},
+		{Property: tpm2.PropertyLibraryCommands, Value: uint32(len(commands))},
+		{Property: tpm2.PropertyVendorCommands, Value: 0},
+		{Property: tpm2.PropertyNVBufferMax, Value: maxNVBuffer},
+		{Property: tpm2.PropertyModes, Value: 0},
+		{Property: tpm2.PropertyMaxCapBuffer, Value: maxCapBuffer}}
+}
+
+func (t *TPM) variableProperties() tpm2.TaggedTPMPropertyList {
+	var permanent tpm2.PermanentAttributes
+	if len(t.hierarchies[tpm2.HandleOwner].authValue) > 0 {
+		permanent |= tpm2.AttrOwnerAuthSet
+	}
+	if len(t.hierarchies[tpm2.HandleEndorsement].authValue) > 0 {
+		permanent |= tpm2.AttrEndorsementAuthSet
+	}
+	if len(t.hierarchies[tpm2.HandleLockout].authValue) > 0 {
+		permanent |= tpm2.AttrLockoutAuthSet
+	}
+	if t.disableClear {
+		permanent |= tpm2.AttrDisableClear
+	}
+	if t.inLockout() {
+		permanent |= tpm2.AttrInLockout
+	}
+	permanent |= tpm2.AttrTPMGeneratedEPS
+
+	var startupClear tpm2.StartupClearAttributes
+	if t.phEnable {
+		startupClear |= tpm2.AttrPhEnable
+	}
+	if t.shEnable {
+		startupClear |= tpm2.AttrShEnable
+	}
+	if t.ehEnable {
+		startupClear |= tpm2.AttrEhEnable
+	}
+	if t.phEnableNV {
+		startupClear |= tpm2.AttrPhEnableNV
+	}
+
+	var loadedSessions int
+	for _, s := range t.sessions {
+		if s.loaded {
+			loadedSessions++
+		}
+	}
+
+	return tpm2.TaggedTPMPropertyList{
+		{Property: tpm2.PropertyPermanent, Value: uint32(permanent)},
+		{Property: tpm2.PropertyStartupClear, Value: uint32(startupClear)},
+		{Property: tpm2.PropertyHRNVIndex, Value: uint32(len(t.nvIndices))},
+		{Property: tpm2.PropertyHRLoaded, Value: uint32(loadedSessions)},
+		{Property: tpm2.PropertyHRLoadedAvail, Value: uint32(maxLoadedSessions - loadedSessions)},
+		{Property: tpm2.PropertyHRActive, Value: uint32(len(t.sessions) - loadedSessions)},
+		{Property: tpm2.PropertyHRActiveAvail, Value: uint32(maxActiveSessions - len(t.sessions))},
+		{Property: tpm2.PropertyHRTransientAvail, Value: uint32(maxLoadedObjects - len(t.objects))},
+		{Property: tpm2.PropertyHRPersistent, Value: uint32(len(t.persistent))},
+		{Property: tpm2.PropertyHRPersistentAvail, Value: uint32(maxPersistent - len(t.persistent))},
+		{Property: tpm2.PropertyNVCounters, Value: 0},
+		{Property: tpm2.PropertyNVCountersAvail, Value: 0},
+		{Property: tpm2.PropertyAlgorithmSet, Value: 0},
+		{Property: tpm2.PropertyLoadedCurves, Value: uint32(len(eccCurves))},
+		{Property: tpm2.PropertyLockoutCounter, Value: t.da.failedTries},
+		{Property: tpm2.PropertyMaxAuthFail, Value: t.da.maxTries},
+		{Property: tpm2.PropertyLockoutInterval, Value: t.da.recoveryTime},
+		{Property: tpm2.PropertyLockoutRecovery, Value: t.da.lockoutRecovery},
+		{Property: tpm2.PropertyNVWriteRecovery, Value: 0},
+		{Property: tpm2.PropertyAuditCounter0, Value: uint32(t.auditCounter >> 32)},
+		{Property: tpm2.PropertyAuditCounter1, Value: uint32(t.auditCounter)}}
+}
+
+func pcrProperties() (out tpm2.TaggedPCRPropertyList) {
+	add := func(tag tpm2.PropertyPCR, m pcrMask) {
+		out = append(out, tpm2.TaggedPCRSelect{Tag: tag, Select: m.indices()})
+	}
+	add(tpm2.PropertyPCRSave, pcrSave)
+	for i := 0; i < len(pcrExtend); i++ {
+		add(tpm2.PropertyPCRExtendL0+tpm2.PropertyPCR(i*2), pcrExtend[i])
+		add(tpm2.PropertyPCRResetL0+tpm2.PropertyPCR(i*2), pcrReset[i])
+	}
+	add(tpm2.PropertyPCRNoIncrement, pcrNoIncrement)
+	add(tpm2.PropertyPCRDRTMReset, pcrDRTMReset)
+	add(tpm2.PropertyPCRPolicy, 0)
+	add(tpm2.PropertyPCRAuth, 0)
+	return out
+}
+
+func (t *TPM) getCapability(c *command) tpm2.ResponseCode {
+	var capability tpm2.Capability
+	var property, propertyCount uint32
+	if rc := c.unmarshal(&capability, &property, &propertyCount); rc != tpm2.Success {
+		return rc
+	}
+
+	// limit truncates the number of items to the requested count and the maximum that fit in the response, and
+	// indicates whether there are more items.
+	limit := func(n, itemSize int) (int, bool) {
+		max := maxCapData / itemSize
+		if uint32(max) > propertyCount {
+			max = int(propertyCount)
+		}
+		if n > max {
+			return max, true
+		}
+		return n, false
+	}
+
+	data := &tpm2.CapabilityData{Capability: capability, Data: new(tpm2.CapabilitiesU)}
+	var moreData bool
+
+	switch capability {
+	case tpm2.CapabilityAlgs:
+		var algs tpm2.AlgorithmPropertyList
+		for _, a := range algorithms {
+			if a.Alg >= tpm2.AlgorithmId(property) {
+				algs = append(algs, a)
+			}
+		}
+		var n int
+		n, moreData = limit(len(algs), 6)
+		data.Data.Algorithms = algs[:n]
+	case tpm2.CapabilityHandles:
+		switch tpm2.Handle(property).Type() {
+		case tpm2.HandleTypePCR, tpm2.HandleTypeNVIndex, tpm2.HandleTypeLoadedSession, tpm2.HandleTypeSavedSession,
+			tpm2.HandleTypePermanent, tpm2.HandleTypeTransient, tpm2.HandleTypePersistent:
+		default:
+			return rcParam(tpm2.ErrorHandle, 2)
+		}
+		handles := t.capabilityHandles(property)
+		var n int
+		n, moreData = limit(len(handles), 4)
+		data.Data.Handles = handles[:n]
+	case tpm2.CapabilityCommands:
+		var cmds tpm2.CommandAttributesList
+		for code, info := range commands {
+			if code >= tpm2.CommandCode(property) {
+				cmds = append(cmds, commandAttributes(code, info))
+			}
+		}
+		sort.Slice(cmds, func(i, j int) bool { return cmds[i].CommandCode() < cmds[j].CommandCode() })
+		var n int
+		n, moreData = limit(len(cmds), 4)
+		data.Data.Command = cmds[:n]
+	case tpm2.CapabilityPPCommands:
+		data.Data.PPCommands = tpm2.CommandCodeList{}
+	case tpm2.CapabilityAuditCommands:
+		var cmds tpm2.CommandCodeList
+		for _, code := range t.auditedCommands() {
+			if code >= tpm2.CommandCode(property) {
+				cmds = append(cmds, code)
+			}
+		}
+		var n int
+		n, moreData = limit(len(cmds), 4)
+		data.Data.AuditCommands = cmds[:n]
+	case tpm2.CapabilityPCRs:
+		for _, alg := range pcrBanks {
+			data.Data.AssignedPCR = append(data.Data.AssignedPCR, tpm2.PCRSelection{Hash: alg, Select: makePCRMaskRange(0, numPCRs-1).indices()})
+		}
+	case tpm2.CapabilityTPMProperties:
+		// Only properties from the same group as the requested property are returned.
+		end := tpm2.Property(property&^0xff) + 0x100
+		var props tpm2.TaggedTPMPropertyList
+		for _, p := range append(t.fixedProperties(), t.variableProperties()...) {
+			if p.Property >= tpm2.Property(property) && p.Property < end {
+				props = append(props, p)
+			}
+		}
+		var n int
+		n, moreData = limit(len(props), 8)
+		data.Data.TPMProperties = props[:n]
+	case tpm2.CapabilityPCRProperties:
+		var props tpm2.TaggedPCRPropertyList
+		for _, p := range pcrProperties() {
+			if p.Tag >= tpm2.PropertyPCR(property) {
+				props = append(props, p)
+			}
+		}
+		var n int
+		n, moreData = limit(len(props), 8)
+		data.Data.PCRProperties = props[:n]
+	case tpm2.CapabilityECCCurves:
+		var curves tpm2.ECCCurveList
+		for _, curve := range eccCurves {
+			if curve >= tpm2.ECCCurve(property) {
+				curves = append(curves, curve)
+			}
+		}
+		var n int
+		n, moreData = limit(len(curves), 2)
+		data.Data.ECCCurves = curves[:n]
+	case tpm2.CapabilityAuthPolicies:
+		var policies tpm2.TaggedPolicyList
+		for _, h := range []tpm2.Handle{tpm2.HandleOwner, tpm2.HandleLockout, tpm2.HandleEndorsement, tpm2.HandlePlatform} {
+			if h < tpm2.Handle(property) {
+				continue
+			}
+			hr := t.hierarchies[h]
+			policies = append(policies, tpm2.TaggedPolicy{
+				Handle:     h,
+				PolicyHash: tpm2.TaggedHash{HashAlg: hr.policyAlg, Digest: hr.authPolicy}})
+		}
+		var n int
+		n, moreData = limit(len(policies), 70)
+		data.Data.AuthPolicies = policies[:n]
+	default:
+		return rcParam(tpm2.ErrorValue, 1)
+	}
+
+	return c.respond(moreData, data)
+}
+
+func (t *TPM) testParms(c *command) tpm2.ResponseCode {
+	var parameters tpm2.PublicParams
+	if rc := c.unmarshal(&parameters); rc != tpm2.Success {
+		return rc
+	}
+
+	switch parameters.Type {
+	case tpm2.ObjectTypeRSA:
+		params := parameters.Parameters.RSADetail
+		if code := checkSymmetric(&params.Symmetric, true, false); code != 0 {
+			return rcParam(code, 1)
+		}
+		switch params.KeyBits {
+		case 1024, 2048, 3072:
+		default:
+			return rcParam(tpm2.ErrorValue, 1)
+		}
+		if params.Exponent != 0 && params.Exponent != tpm2.DefaultRSAExponent {
+			return rcParam(tpm2.ErrorValue, 1)
+		}
+	case tpm2.ObjectTypeECC:
+		params := parameters.Parameters.ECCDetail
+		if code := checkSymmetric(&params.Symmetric, true, false); code != 0 {
+			return rcParam(code, 1)
+		}
+		if eccCurve(params.CurveID) == nil {
+			return rcParam(tpm2.ErrorCurve, 1)
+		}
+		if params.KDF.Scheme != tpm2.KDFAlgorithmNull {
+			return rcParam(tpm2.ErrorKDF, 1)
+		}
+	case tpm2.ObjectTypeKeyedHash:
+		scheme := parameters.Parameters.KeyedHashDetail.Scheme
+		switch scheme.Scheme {
+		case tpm2.KeyedHashSchemeHMAC:
+			if !isSupportedHash(scheme.Details.HMAC.HashAlg) {
+				return rcParam(tpm2.ErrorHash, 1)
+			}
+		case tpm2.KeyedHashSchemeXOR:
+			if !isSupportedHash(scheme.Details.XOR.HashAlg) {
+				return rcParam(tpm2.ErrorHash, 1)
+			}
+		}
+	case tpm2.ObjectTypeSymCipher:
+		if code := checkSymmetric(&parameters.Parameters.SymDetail.Sym, false, false); code != 0 {
+			return rcParam(code, 1)
+		}
+	default:
+		return rcParam(tpm2.ErrorType, 1)
+	}
+	return c.respond()
+}
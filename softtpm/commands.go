@@ -0,0 +1,122 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"github.com/canonical/go-tpm2"
+)
+
+var (
+	authUser      = []authRole{roleUser}
+	authUserUser  = []authRole{roleUser, roleUser}
+	authAdmin     = []authRole{roleAdmin}
+	authAdminUser = []authRole{roleAdmin, roleUser}
+	authDup       = []authRole{roleDup}
+)
+
+// commands is the table of commands implemented by the TPM. It is populated in init because some command implementations
+// refer to it.
+var commands map[tpm2.CommandCode]*commandInfo
+
+func init() {
+	commands = map[tpm2.CommandCode]*commandInfo{
+		tpm2.CommandNVUndefineSpaceSpecial:     {handles: 2, auths: authAdminUser, attrs: tpm2.AttrNV, fn: (*TPM).nvUndefineSpaceSpecial},
+		tpm2.CommandEvictControl:               {handles: 2, auths: authUser, attrs: tpm2.AttrNV, fn: (*TPM).evictControl},
+		tpm2.CommandHierarchyControl:           {handles: 1, auths: authUser, attrs: tpm2.AttrNV | tpm2.AttrExtensive, fn: (*TPM).hierarchyControl},
+		tpm2.CommandNVUndefineSpace:            {handles: 2, auths: authUser, attrs: tpm2.AttrNV, fn: (*TPM).nvUndefineSpace},
+		tpm2.CommandClear:                      {handles: 1, auths: authUser, attrs: tpm2.AttrNV | tpm2.AttrExtensive, fn: (*TPM).clear},
+		tpm2.CommandClearControl:               {handles: 1, auths: authUser, attrs: tpm2.AttrNV, fn: (*TPM).clearControl},
+		tpm2.CommandClockSet:                   {handles: 1, auths: authUser, attrs: tpm2.AttrNV, fn: (*TPM).clockSet},
+		tpm2.CommandHierarchyChangeAuth:        {handles: 1, auths: authUser, decrypt: true, attrs: tpm2.AttrNV, fn: (*TPM).hierarchyChangeAuth},
+		tpm2.CommandNVDefineSpace:              {handles: 1, auths: authUser, decrypt: true, attrs: tpm2.AttrNV, fn: (*TPM).nvDefineSpace},
+		tpm2.CommandPCRAllocate:                {handles: 1, auths: authUser, attrs: tpm2.AttrNV, fn: (*TPM).pcrAllocate},
+		tpm2.CommandSetPrimaryPolicy:           {handles: 1, auths: authUser, decrypt: true, attrs: tpm2.AttrNV, fn: (*TPM).setPrimaryPolicy},
+		tpm2.CommandClockRateAdjust:            {handles: 1, auths: authUser, fn: (*TPM).clockRateAdjust},
+		tpm2.CommandCreatePrimary:              {handles: 1, auths: authUser, decrypt: true, encrypt: true, rHandle: true, fn: (*TPM).createPrimary},
+		tpm2.CommandNVGlobalWriteLock:          {handles: 1, auths: authUser, fn: (*TPM).nvGlobalWriteLock},
+		tpm2.CommandGetCommandAuditDigest:      {handles: 2, auths: authUserUser, decrypt: true, encrypt: true, attrs: tpm2.AttrNV, fn: (*TPM).getCommandAuditDigest},
+		tpm2.CommandNVIncrement:                {handles: 2, auths: authUser, attrs: tpm2.AttrNV, fn: (*TPM).nvIncrement},
+		tpm2.CommandNVSetBits:                  {handles: 2, auths: authUser, attrs: tpm2.AttrNV, fn: (*TPM).nvSetBits},
+		tpm2.CommandNVExtend:                   {handles: 2, auths: authUser, decrypt: true, attrs: tpm2.AttrNV, fn: (*TPM).nvExtend},
+		tpm2.CommandNVWrite:                    {handles: 2, auths: authUser, decrypt: true, attrs: tpm2.AttrNV, fn: (*TPM).nvWrite},
+		tpm2.CommandNVWriteLock:                {handles: 2, auths: authUser, attrs: tpm2.AttrNV, fn: (*TPM).nvWriteLock},
+		tpm2.CommandDictionaryAttackLockReset:  {handles: 1, auths: authUser, attrs: tpm2.AttrNV, fn: (*TPM).dictionaryAttackLockReset},
+		tpm2.CommandDictionaryAttackParameters: {handles: 1, auths: authUser, attrs: tpm2.AttrNV, fn: (*TPM).dictionaryAttackParameters},
+		tpm2.CommandNVChangeAuth:               {handles: 1, auths: authAdmin, decrypt: true, attrs: tpm2.AttrNV, fn: (*TPM).nvChangeAuth},
+		tpm2.CommandPCREvent:                   {handles: 1, auths: authUser, decrypt: true, attrs: tpm2.AttrNV, fn: (*TPM).pcrEvent},
+		tpm2.CommandPCRReset:                   {handles: 1, auths: authUser, attrs: tpm2.AttrNV, fn: (*TPM).pcrReset},
+		tpm2.CommandSequenceComplete:           {handles: 1, auths: authUser, decrypt: true, encrypt: true, attrs: tpm2.AttrFlushed, fn: (*TPM).sequenceComplete},
+		tpm2.CommandSetCommandCodeAuditStatus:  {handles: 1, auths: authUser, attrs: tpm2.AttrNV, fn: (*TPM).setCommandCodeAuditStatus},
+		tpm2.CommandIncrementalSelfTest:        {attrs: tpm2.AttrNV, fn: (*TPM).incrementalSelfTest},
+		tpm2.CommandSelfTest:                   {attrs: tpm2.AttrNV, fn: (*TPM).selfTest},
+		tpm2.CommandStartup:                    {attrs: tpm2.AttrNV, fn: (*TPM).startup},
+		tpm2.CommandShutdown:                   {attrs: tpm2.AttrNV, fn: (*TPM).shutdown},
+		tpm2.CommandStirRandom:                 {decrypt: true, attrs: tpm2.AttrNV, fn: (*TPM).stirRandom},
+		tpm2.CommandActivateCredential:         {handles: 2, auths: authAdminUser, decrypt: true, encrypt: true, fn: (*TPM).activateCredential},
+		tpm2.CommandCertify:                    {handles: 2, auths: authAdminUser, decrypt: true, encrypt: true, fn: (*TPM).certify},
+		tpm2.CommandPolicyNV:                   {handles: 3, auths: authUser, decrypt: true, fn: (*TPM).policyNV},
+		tpm2.CommandCertifyCreation:            {handles: 2, auths: authUser, decrypt: true, encrypt: true, fn: (*TPM).certifyCreation},
+		tpm2.CommandDuplicate:                  {handles: 2, auths: authDup, decrypt: true, encrypt: true, fn: (*TPM).duplicate},
+		tpm2.CommandGetTime:                    {handles: 2, auths: authUserUser, decrypt: true, encrypt: true, fn: (*TPM).getTime},
+		tpm2.CommandGetSessionAuditDigest:      {handles: 3, auths: authUserUser, decrypt: true, encrypt: true, fn: (*TPM).getSessionAuditDigest},
+		tpm2.CommandNVRead:                     {handles: 2, auths: authUser, encrypt: true, fn: (*TPM).nvRead},
+		tpm2.CommandNVReadLock:                 {handles: 2, auths: authUser, attrs: tpm2.AttrNV, fn: (*TPM).nvReadLock},
+		tpm2.CommandObjectChangeAuth:           {handles: 2, auths: authAdmin, decrypt: true, encrypt: true, fn: (*TPM).objectChangeAuth},
+		tpm2.CommandPolicySecret:               {handles: 2, auths: authUser, decrypt: true, encrypt: true, fn: (*TPM).policySecret},
+		tpm2.CommandCreate:                     {handles: 1, auths: authUser, decrypt: true, encrypt: true, fn: (*TPM).create},
+		tpm2.CommandECDHZGen:                   {handles: 1, auths: authUser, decrypt: true, encrypt: true, fn: (*TPM).ecdhZGen},
+		tpm2.CommandHMAC:                       {handles: 1, auths: authUser, decrypt: true, encrypt: true, fn: (*TPM).hmac},
+		tpm2.CommandImport:                     {handles: 1, auths: authUser, decrypt: true, encrypt: true, fn: (*TPM).importObject},
+		tpm2.CommandLoad:                       {handles: 1, auths: authUser, decrypt: true, encrypt: true, rHandle: true, fn: (*TPM).load},
+		tpm2.CommandQuote:                      {handles: 1, auths: authUser, decrypt: true, encrypt: true, fn: (*TPM).quote},
+		tpm2.CommandRSADecrypt:                 {handles: 1, auths: authUser, decrypt: true, encrypt: true, fn: (*TPM).rsaDecrypt},
+		tpm2.CommandHMACStart:                  {handles: 1, auths: authUser, decrypt: true, rHandle: true, fn: (*TPM).hmacStart},
+		tpm2.CommandSequenceUpdate:             {handles: 1, auths: authUser, decrypt: true, fn: (*TPM).sequenceUpdate},
+		tpm2.CommandSign:                       {handles: 1, auths: authUser, decrypt: true, fn: (*TPM).signCommand},
+		tpm2.CommandUnseal:                     {handles: 1, auths: authUser, encrypt: true, fn: (*TPM).unseal},
+		tpm2.CommandPolicySigned:               {handles: 2, decrypt: true, encrypt: true, fn: (*TPM).policySigned},
+		tpm2.CommandContextLoad:                {rHandle: true, fn: (*TPM).contextLoad},
+		tpm2.CommandContextSave:                {handles: 1, fn: (*TPM).contextSave},
+		tpm2.CommandECDHKeyGen:                 {handles: 1, encrypt: true, fn: (*TPM).ecdhKeyGen},
+		tpm2.CommandFlushContext:               {fn: (*TPM).flushContext},
+		tpm2.CommandLoadExternal:               {decrypt: true, encrypt: true, rHandle: true, fn: (*TPM).loadExternal},
+		tpm2.CommandMakeCredential:             {handles: 1, decrypt: true, encrypt: true, fn: (*TPM).makeCredential},
+		tpm2.CommandNVReadPublic:               {handles: 1, encrypt: true, fn: (*TPM).nvReadPublic},
+		tpm2.CommandPolicyAuthorize:            {handles: 1, decrypt: true, fn: (*TPM).policyAuthorize},
+		tpm2.CommandPolicyAuthValue:            {handles: 1, fn: (*TPM).policyAuthValue},
+		tpm2.CommandPolicyCommandCode:          {handles: 1, fn: (*TPM).policyCommandCode},
+		tpm2.CommandPolicyCounterTimer:         {handles: 1, decrypt: true, fn: (*TPM).policyCounterTimer},
+		tpm2.CommandPolicyCpHash:               {handles: 1, decrypt: true, fn: (*TPM).policyCpHash},
+		tpm2.CommandPolicyLocality:             {handles: 1, fn: (*TPM).policyLocality},
+		tpm2.CommandPolicyNameHash:             {handles: 1, decrypt: true, fn: (*TPM).policyNameHash},
+		tpm2.CommandPolicyOR:                   {handles: 1, fn: (*TPM).policyOR},
+		tpm2.CommandPolicyTicket:               {handles: 1, decrypt: true, fn: (*TPM).policyTicket},
+		tpm2.CommandReadPublic:                 {handles: 1, encrypt: true, fn: (*TPM).readPublic},
+		tpm2.CommandRSAEncrypt:                 {handles: 1, decrypt: true, encrypt: true, fn: (*TPM).rsaEncrypt},
+		tpm2.CommandStartAuthSession:           {handles: 2, decrypt: true, encrypt: true, rHandle: true, fn: (*TPM).startAuthSession},
+		tpm2.CommandVerifySignature:            {handles: 1, decrypt: true, fn: (*TPM).verifySignatureCommand},
+		tpm2.CommandECCParameters:              {fn: (*TPM).eccParameters},
+		tpm2.CommandGetCapability:              {fn: (*TPM).getCapability},
+		tpm2.CommandGetRandom:                  {encrypt: true, fn: (*TPM).getRandom},
+		tpm2.CommandGetTestResult:              {encrypt: true, fn: (*TPM).getTestResult},
+		tpm2.CommandHash:                       {decrypt: true, encrypt: true, fn: (*TPM).hash},
+		tpm2.CommandPCRRead:                    {fn: (*TPM).pcrRead},
+		tpm2.CommandPolicyPCR:                  {handles: 1, decrypt: true, fn: (*TPM).policyPCR},
+		tpm2.CommandPolicyRestart:              {handles: 1, fn: (*TPM).policyRestart},
+		tpm2.CommandReadClock:                  {fn: (*TPM).readClock},
+		tpm2.CommandPCRExtend:                  {handles: 1, auths: authUser, attrs: tpm2.AttrNV, fn: (*TPM).pcrExtend},
+		tpm2.CommandNVCertify:                  {handles: 3, auths: authUserUser, decrypt: true, encrypt: true, fn: (*TPM).nvCertify},
+		tpm2.CommandEventSequenceComplete:      {handles: 2, auths: authUserUser, decrypt: true, attrs: tpm2.AttrNV | tpm2.AttrFlushed, fn: (*TPM).eventSequenceComplete},
+		tpm2.CommandHashSequenceStart:          {decrypt: true, rHandle: true, fn: (*TPM).hashSequenceStart},
+		tpm2.CommandPolicyDuplicationSelect:    {handles: 1, decrypt: true, fn: (*TPM).policyDuplicationSelect},
+		tpm2.CommandPolicyGetDigest:            {handles: 1, encrypt: true, fn: (*TPM).policyGetDigest},
+		tpm2.CommandTestParms:                  {fn: (*TPM).testParms},
+		tpm2.CommandCommit:                     {handles: 1, auths: authUser, decrypt: true, encrypt: true, fn: (*TPM).commit},
+		tpm2.CommandPolicyPassword:             {handles: 1, fn: (*TPM).policyPassword},
+		tpm2.CommandPolicyNvWritten:            {handles: 1, fn: (*TPM).policyNvWritten},
+		tpm2.CommandPolicyTemplate:             {handles: 1, decrypt: true, fn: (*TPM).policyTemplate},
+		tpm2.CommandCreateLoaded:               {handles: 1, auths: authUser, decrypt: true, encrypt: true, rHandle: true, fn: (*TPM).createLoaded},
+		tpm2.CommandPolicyAuthorizeNV:          {handles: 3, auths: authUser, fn: (*TPM).policyAuthorizeNV}}
+}
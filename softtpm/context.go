@@ -0,0 +1,245 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"crypto/hmac"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+const (
+	savedHandleObject   tpm2.Handle = 0x80000000
+	savedHandleStClear  tpm2.Handle = 0x80000001
+	savedHandleSequence tpm2.Handle = 0x80000002
+
+	contextIdSize = 16
+)
+
+// contextBlob is the contents of TPMS_CONTEXT.contextBlob. Rather than containing the encrypted state of the saved
+// resource, it contains an identifier that references the state held by the TPM.
+type contextBlob struct {
+	Integrity tpm2.Digest
+	Id        []byte
+}
+
+// computeContextIntegrity computes the integrity value for a saved context.
+func computeContextIntegrity(key []byte, sequence uint64, savedHandle tpm2.Handle, id []byte) tpm2.Digest {
+	return hmacDigest(ticketHashAlg, key, uint64Bytes(sequence), uint32Bytes(uint32(savedHandle)), id)
+}
+
+func (t *TPM) contextSave(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+
+	h := c.handles[0]
+	sequence := t.contextSequence
+
+	var context tpm2.Context
+	switch h.Type() {
+	case tpm2.HandleTypeTransient:
+		o := t.objects[h]
+		id := randomBytes(contextIdSize)
+
+		context.SavedHandle = savedHandleObject
+		switch {
+		case o.seq != nil:
+			context.SavedHandle = savedHandleSequence
+		case o.public.Attrs&tpm2.AttrStClear != 0:
+			context.SavedHandle = savedHandleStClear
+		}
+		context.Hierarchy = o.hierarchy
+
+		saved := *o
+		if o.seq != nil {
+			saved.seq = o.seq.copy()
+		}
+		if len(t.savedObjectIds) >= maxSavedObjects {
+			delete(t.savedObjects, t.savedObjectIds[0])
+			t.savedObjectIds = t.savedObjectIds[1:]
+		}
+		t.savedObjects[string(id)] = &saved
+		t.savedObjectIds = append(t.savedObjectIds, string(id))
+
+		context.Blob, _ = mu.MarshalToBytes(contextBlob{
+			Integrity: computeContextIntegrity(t.hierarchyProof(o.hierarchy), sequence, context.SavedHandle, id),
+			Id:        id})
+	case tpm2.HandleTypeHMACSession, tpm2.HandleTypePolicySession:
+		s := t.lookupSession(h)
+		if s.handle != h {
+			return rcHandle(tpm2.ErrorHandle, 1)
+		}
+		s.loaded = false
+		s.contextSeq = sequence
+		if t.exclusiveAuditSession == s {
+			t.exclusiveAuditSession = nil
+		}
+
+		context.SavedHandle = h
+		context.Hierarchy = tpm2.HandleNull
+		context.Blob, _ = mu.MarshalToBytes(contextBlob{
+			Integrity: computeContextIntegrity(t.contextKey, sequence, h, nil)})
+	default:
+		return rcHandle(tpm2.ErrorHandle, 1)
+	}
+
+	t.contextSequence++
+	context.Sequence = sequence
+	return c.respond(context)
+}
+
+func (t *TPM) contextLoad(c *command) tpm2.ResponseCode {
+	var context tpm2.Context
+	if rc := c.unmarshal(&context); rc != tpm2.Success {
+		return rc
+	}
+
+	var blob contextBlob
+	if _, err := mu.UnmarshalFromBytes(context.Blob, &blob); err != nil {
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+
+	switch context.SavedHandle.Type() {
+	case tpm2.HandleTypeHMACSession, tpm2.HandleTypePolicySession:
+		s, ok := t.sessions[sessionSlot(context.SavedHandle)]
+		if !ok || s.handle != context.SavedHandle || s.loaded {
+			return rcParam(tpm2.ErrorHandle, 1)
+		}
+		if !hmac.Equal(blob.Integrity, computeContextIntegrity(t.contextKey, context.Sequence, context.SavedHandle, nil)) ||
+			s.contextSeq != context.Sequence {
+			return rcParam(tpm2.ErrorIntegrity, 1)
+		}
+
+		loaded := 0
+		for _, s := range t.sessions {
+			if s.loaded {
+				loaded++
+			}
+		}
+		if loaded >= maxLoadedSessions {
+			return rcWarning(tpm2.WarningSessionMemory)
+		}
+
+		s.loaded = true
+		c.rHandle = s.handle
+	case tpm2.HandleTypeTransient:
+		if context.Hierarchy != tpm2.HandleNull && !isHierarchy(context.Hierarchy) {
+			return rcParam(tpm2.ErrorHierarchy, 1)
+		}
+		if !t.hierarchyEnabled(context.Hierarchy) {
+			return rcParam(tpm2.ErrorHierarchy, 1)
+		}
+		if !hmac.Equal(blob.Integrity, computeContextIntegrity(t.hierarchyProof(context.Hierarchy), context.Sequence,
+			context.SavedHandle, blob.Id)) {
+			return rcParam(tpm2.ErrorIntegrity, 1)
+		}
+		saved, ok := t.savedObjects[string(blob.Id)]
+		if !ok {
+			return rcParam(tpm2.ErrorIntegrity, 1)
+		}
+
+		o := *saved
+		if saved.seq != nil {
+			o.seq = saved.seq.copy()
+		}
+		handle, rc := t.loadObject(&o)
+		if rc != tpm2.Success {
+			return rc
+		}
+		c.rHandle = handle
+	default:
+		return rcParam(tpm2.ErrorHandle, 1)
+	}
+
+	return c.respond()
+}
+
+func (t *TPM) flushContext(c *command) tpm2.ResponseCode {
+	var flushHandle tpm2.Handle
+	if rc := c.unmarshal(&flushHandle); rc != tpm2.Success {
+		return rc
+	}
+
+	switch flushHandle.Type() {
+	case tpm2.HandleTypeTransient:
+		if _, ok := t.objects[flushHandle]; !ok {
+			return rcParam(tpm2.ErrorHandle, 1)
+		}
+		delete(t.objects, flushHandle)
+	case tpm2.HandleTypeHMACSession, tpm2.HandleTypePolicySession:
+		s := t.lookupSession(flushHandle)
+		if s == nil {
+			return rcParam(tpm2.ErrorHandle, 1)
+		}
+		t.flushSession(s)
+	default:
+		return rcParam(tpm2.ErrorHandle, 1)
+	}
+
+	return c.respond()
+}
+
+// isPersistentHandleInRange indicates whether the supplied persistent handle can be used with the specified hierarchy.
+func isPersistentHandleInRange(auth, h tpm2.Handle) bool {
+	if h.Type() != tpm2.HandleTypePersistent {
+		return false
+	}
+	if auth == tpm2.HandlePlatform {
+		return h >= 0x81800000
+	}
+	return h < 0x81800000
+}
+
+func (t *TPM) evictControl(c *command) tpm2.ResponseCode {
+	var persistentHandle tpm2.Handle
+	if rc := c.unmarshal(&persistentHandle); rc != tpm2.Success {
+		return rc
+	}
+
+	auth := c.handles[0]
+	if auth != tpm2.HandleOwner && auth != tpm2.HandlePlatform {
+		return rcHandle(tpm2.ErrorHierarchy, 1)
+	}
+
+	h := c.handles[1]
+	o := t.lookupObject(h)
+
+	if h.Type() == tpm2.HandleTypePersistent {
+		if persistentHandle != h {
+			return rcParam(tpm2.ErrorHandle, 1)
+		}
+		if auth == tpm2.HandleOwner && o.hierarchy == tpm2.HandlePlatform {
+			return rcHandle(tpm2.ErrorHierarchy, 2)
+		}
+		delete(t.persistent, h)
+		return c.respond()
+	}
+
+	switch {
+	case o.seq != nil:
+		return rcHandle(tpm2.ErrorType, 2)
+	case o.external, o.public.Attrs&tpm2.AttrStClear != 0:
+		return rcHandle(tpm2.ErrorAttributes, 2)
+	case o.hierarchy == tpm2.HandleNull:
+		return rcHandle(tpm2.ErrorHierarchy, 2)
+	case auth == tpm2.HandleOwner && o.hierarchy == tpm2.HandlePlatform:
+		return rcHandle(tpm2.ErrorHierarchy, 2)
+	case !isPersistentHandleInRange(auth, persistentHandle):
+		return rcParam(tpm2.ErrorRange, 1)
+	}
+	if _, exists := t.persistent[persistentHandle]; exists {
+		return rcError(tpm2.ErrorNVDefined)
+	}
+	if len(t.persistent) >= maxPersistent || t.nvSpaceUsed()+512 > maxNVSpace {
+		return rcError(tpm2.ErrorNVSpace)
+	}
+
+	p := *o
+	p.evictHandle = persistentHandle
+	t.persistent[persistentHandle] = &p
+	return c.respond()
+}
@@ -0,0 +1,583 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"math/big"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/internal"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+// ticketHashAlg is the digest algorithm used to compute tickets and to protect saved contexts.
+const ticketHashAlg = tpm2.HashAlgorithmSHA256
+
+// isSupportedHash indicates whether the supplied digest algorithm is implemented by the TPM.
+func isSupportedHash(alg tpm2.HashAlgorithmId) bool {
+	for _, a := range supportedHashAlgs {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// eccCurve returns the elliptic curve associated with the supplied curve ID, or nil if it is not supported.
+func eccCurve(id tpm2.ECCCurve) elliptic.Curve {
+	switch id {
+	case tpm2.ECCCurveNIST_P256:
+		return elliptic.P256()
+	case tpm2.ECCCurveNIST_P384:
+		return elliptic.P384()
+	case tpm2.ECCCurveNIST_P521:
+		return elliptic.P521()
+	default:
+		return nil
+	}
+}
+
+// zeroExtend returns the big-endian representation of x, zero extended to l bytes.
+func zeroExtend(x *big.Int, l int) []byte {
+	b := make([]byte, l)
+	x.FillBytes(b)
+	return b
+}
+
+func hmacDigest(alg tpm2.HashAlgorithmId, key []byte, data ...[]byte) []byte {
+	h := hmac.New(func() hash.Hash { return alg.NewHash() }, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// symmetricCrypt encrypts or decrypts the supplied data in place using AES in CFB mode with the supplied key and a zero IV,
+// which is used for all of the secret sharing operations in the TPM.
+func symmetricCrypt(key, data []byte, encrypt bool) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if encrypt {
+		cipher.NewCFBEncrypter(block, iv).XORKeyStream(data, data)
+	} else {
+		cipher.NewCFBDecrypter(block, iv).XORKeyStream(data, data)
+	}
+	return nil
+}
+
+// kdfReader is an io.Reader that produces a deterministic stream of bytes from a seed, and is used to derive primary
+// objects.
+type kdfReader struct {
+	alg     tpm2.HashAlgorithmId
+	seed    []byte
+	label   []byte
+	context []byte
+	counter uint32
+	buf     []byte
+}
+
+func (r *kdfReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			r.counter++
+			var c [4]byte
+			binary.BigEndian.PutUint32(c[:], r.counter)
+			r.buf = hmacDigest(r.alg, r.seed, c[:], r.label, []byte{0}, r.context)
+		}
+		m := copy(p[n:], r.buf)
+		r.buf = r.buf[m:]
+		n += m
+	}
+	return n, nil
+}
+
+// generatePrime generates a prime with the specified number of bits from the supplied reader, which is suitable for use
+// with the supplied public exponent.
+func generatePrime(r *kdfReader, bits int, e int64) *big.Int {
+	b := make([]byte, (bits+7)/8)
+	for {
+		r.Read(b)
+		b[0] |= 0xc0
+		b[len(b)-1] |= 1
+		p := new(big.Int).SetBytes(b)
+		if new(big.Int).GCD(nil, nil, new(big.Int).Sub(p, big.NewInt(1)), big.NewInt(e)).Cmp(big.NewInt(1)) != 0 {
+			continue
+		}
+		if p.ProbablyPrime(20) {
+			return p
+		}
+	}
+}
+
+// generateRSAKey generates a RSA key. If r is not nil, the key is derived deterministically from it.
+func generateRSAKey(r *kdfReader, bits int, e int) (*rsa.PrivateKey, error) {
+	if r == nil {
+		r = &kdfReader{alg: tpm2.HashAlgorithmSHA256, seed: randomBytes(32), label: []byte("RSA")}
+	}
+	for {
+		p := generatePrime(r, bits/2, int64(e))
+		q := generatePrime(r, bits/2, int64(e))
+		if p.Cmp(q) == 0 {
+			continue
+		}
+		key := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: new(big.Int).Mul(p, q), E: e},
+			Primes:    []*big.Int{p, q}}
+		if key.N.BitLen() != bits {
+			continue
+		}
+		phi := new(big.Int).Mul(new(big.Int).Sub(p, big.NewInt(1)), new(big.Int).Sub(q, big.NewInt(1)))
+		key.D = new(big.Int).ModInverse(big.NewInt(int64(e)), phi)
+		if key.D == nil {
+			continue
+		}
+		key.Precompute()
+		return key, nil
+	}
+}
+
+// rsaKeyFromPrime reconstructs a RSA private key from the public modulus and one of its prime factors.
+func rsaKeyFromPrime(n *big.Int, e int, p *big.Int) (*rsa.PrivateKey, error) {
+	if p.Sign() <= 0 {
+		return nil, errors.New("invalid prime")
+	}
+	q, m := new(big.Int).DivMod(n, p, new(big.Int))
+	if m.Sign() != 0 || q.Cmp(big.NewInt(1)) <= 0 {
+		return nil, errors.New("prime is not a factor of the modulus")
+	}
+	phi := new(big.Int).Mul(new(big.Int).Sub(p, big.NewInt(1)), new(big.Int).Sub(q, big.NewInt(1)))
+	d := new(big.Int).ModInverse(big.NewInt(int64(e)), phi)
+	if d == nil {
+		return nil, errors.New("invalid exponent")
+	}
+	key := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: n, E: e},
+		D:         d,
+		Primes:    []*big.Int{p, q}}
+	key.Precompute()
+	return key, nil
+}
+
+// generateECCKey generates an ECC key. If r is not nil, the key is derived deterministically from it.
+func generateECCKey(r *kdfReader, curve elliptic.Curve) *ecdsa.PrivateKey {
+	params := curve.Params()
+	var d *big.Int
+	if r == nil {
+		for {
+			var err error
+			d, err = rand.Int(rand.Reader, params.N)
+			if err != nil {
+				panic(err)
+			}
+			if d.Sign() > 0 {
+				break
+			}
+		}
+	} else {
+		b := make([]byte, (params.BitSize+7)/8+8)
+		r.Read(b)
+		d = new(big.Int).SetBytes(b)
+		d.Mod(d, new(big.Int).Sub(params.N, big.NewInt(1)))
+		d.Add(d, big.NewInt(1))
+	}
+	key := &ecdsa.PrivateKey{D: d}
+	key.Curve = curve
+	key.X, key.Y = curve.ScalarBaseMult(d.Bytes())
+	return key
+}
+
+// rsaExponent returns the public exponent of the supplied RSA public area.
+func rsaExponent(pub *tpm2.Public) int {
+	if pub.Params.RSADetail.Exponent == 0 {
+		return tpm2.DefaultRSAExponent
+	}
+	return int(pub.Params.RSADetail.Exponent)
+}
+
+// decryptSecret decrypts a secret that was encrypted with the public part of the supplied object, using the label to
+// identify its purpose.
+func decryptSecret(o *object, label []byte, secret tpm2.EncryptedSecret) ([]byte, error) {
+	label0 := make([]byte, len(label)+1)
+	copy(label0, label)
+
+	switch k := o.priv.(type) {
+	case *rsa.PrivateKey:
+		return rsa.DecryptOAEP(o.public.NameAlg.NewHash(), rand.Reader, k, secret, label0)
+	case *ecdsa.PrivateKey:
+		var point tpm2.ECCPoint
+		if _, err := mu.UnmarshalFromBytes(secret, &point); err != nil {
+			return nil, err
+		}
+		x := new(big.Int).SetBytes(point.X)
+		y := new(big.Int).SetBytes(point.Y)
+		if !k.Curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		sz := (k.Curve.Params().BitSize + 7) / 8
+		z, _ := k.Curve.ScalarMult(x, y, k.D.Bytes())
+		return internal.KDFe(o.public.NameAlg.GetHash(), zeroExtend(z, sz), label, point.X, zeroExtend(k.X, sz),
+			o.public.NameAlg.Size()*8), nil
+	default:
+		return nil, errors.New("invalid key type")
+	}
+}
+
+// encryptSecret creates a seed and encrypts it with the supplied public key, using the label to identify its purpose.
+func encryptSecret(pub *tpm2.Public, label []byte) (tpm2.EncryptedSecret, []byte, error) {
+	label0 := make([]byte, len(label)+1)
+	copy(label0, label)
+
+	switch pub.Type {
+	case tpm2.ObjectTypeRSA:
+		seed := randomBytes(pub.NameAlg.Size())
+		secret, err := rsa.EncryptOAEP(pub.NameAlg.NewHash(), rand.Reader, pub.Public().(*rsa.PublicKey), seed, label0)
+		return secret, seed, err
+	case tpm2.ObjectTypeECC:
+		k := pub.Public().(*ecdsa.PublicKey)
+		eph := generateECCKey(nil, k.Curve)
+		sz := (k.Curve.Params().BitSize + 7) / 8
+		secret, err := mu.MarshalToBytes(&tpm2.ECCPoint{X: zeroExtend(eph.X, sz), Y: zeroExtend(eph.Y, sz)})
+		if err != nil {
+			return nil, nil, err
+		}
+		z, _ := k.Curve.ScalarMult(k.X, k.Y, eph.D.Bytes())
+		seed := internal.KDFe(pub.NameAlg.GetHash(), zeroExtend(z, sz), label, zeroExtend(eph.X, sz), zeroExtend(k.X, sz),
+			pub.NameAlg.Size()*8)
+		return secret, seed, nil
+	default:
+		return nil, nil, errors.New("invalid key type")
+	}
+}
+
+// signScheme is a signing scheme and the associated digest algorithm.
+type signScheme struct {
+	alg     tpm2.SigSchemeId
+	hashAlg tpm2.HashAlgorithmId
+	count   uint16 // The commit counter for ECDAA
+}
+
+// keySignScheme returns the signing scheme from the public area of the supplied key.
+func keySignScheme(pub *tpm2.Public) signScheme {
+	switch pub.Type {
+	case tpm2.ObjectTypeRSA:
+		s := pub.Params.RSADetail.Scheme
+		if s.Scheme == tpm2.RSASchemeNull || s.Details == nil || s.Details.Any() == nil {
+			return signScheme{alg: tpm2.SigSchemeAlgNull}
+		}
+		return signScheme{alg: tpm2.SigSchemeId(s.Scheme), hashAlg: s.Details.Any().HashAlg}
+	case tpm2.ObjectTypeECC:
+		s := pub.Params.ECCDetail.Scheme
+		if s.Scheme == tpm2.ECCSchemeNull || s.Details == nil || s.Details.Any() == nil {
+			return signScheme{alg: tpm2.SigSchemeAlgNull}
+		}
+		out := signScheme{alg: tpm2.SigSchemeId(s.Scheme), hashAlg: s.Details.Any().HashAlg}
+		if s.Scheme == tpm2.ECCSchemeECDAA {
+			out.count = s.Details.ECDAA.Count
+		}
+		return out
+	case tpm2.ObjectTypeKeyedHash:
+		s := pub.Params.KeyedHashDetail.Scheme
+		if s.Scheme != tpm2.KeyedHashSchemeHMAC {
+			return signScheme{alg: tpm2.SigSchemeAlgNull}
+		}
+		return signScheme{alg: tpm2.SigSchemeAlgHMAC, hashAlg: s.Details.HMAC.HashAlg}
+	default:
+		return signScheme{alg: tpm2.SigSchemeAlgNull}
+	}
+}
+
+// sigSchemeFromParam converts the supplied TPMT_SIG_SCHEME to a signScheme.
+func sigSchemeFromParam(in *tpm2.SigScheme) signScheme {
+	if in.Scheme == tpm2.SigSchemeAlgNull || in.Details == nil || in.Details.Any() == nil {
+		return signScheme{alg: tpm2.SigSchemeAlgNull}
+	}
+	out := signScheme{alg: in.Scheme, hashAlg: in.Details.Any().HashAlg}
+	if in.Scheme == tpm2.SigSchemeAlgECDAA {
+		out.count = in.Details.ECDAA.Count
+	}
+	return out
+}
+
+// isValidSignScheme indicates whether the supplied signing scheme can be used with the supplied key type.
+func isValidSignScheme(objectType tpm2.ObjectTypeId, scheme signScheme) bool {
+	if !isSupportedHash(scheme.hashAlg) {
+		return false
+	}
+	switch objectType {
+	case tpm2.ObjectTypeRSA:
+		return scheme.alg == tpm2.SigSchemeAlgRSASSA || scheme.alg == tpm2.SigSchemeAlgRSAPSS
+	case tpm2.ObjectTypeECC:
+		switch scheme.alg {
+		case tpm2.SigSchemeAlgECDSA, tpm2.SigSchemeAlgECDAA, tpm2.SigSchemeAlgECSCHNORR:
+			return true
+		default:
+			return false
+		}
+	case tpm2.ObjectTypeKeyedHash:
+		return scheme.alg == tpm2.SigSchemeAlgHMAC
+	default:
+		return false
+	}
+}
+
+// selectSignScheme determines the signing scheme to use for the supplied key and requested scheme. It returns false if
+// the combination is invalid.
+func selectSignScheme(pub *tpm2.Public, in *tpm2.SigScheme) (signScheme, bool) {
+	keyScheme := keySignScheme(pub)
+	inScheme := sigSchemeFromParam(in)
+	switch {
+	case keyScheme.alg == tpm2.SigSchemeAlgNull:
+		return inScheme, isValidSignScheme(pub.Type, inScheme)
+	case inScheme.alg == tpm2.SigSchemeAlgNull:
+		return keyScheme, isValidSignScheme(pub.Type, keyScheme)
+	case keyScheme.alg == tpm2.SigSchemeAlgECDAA:
+		// The count for ECDAA always comes from the supplied scheme.
+		return inScheme, inScheme.alg == keyScheme.alg && inScheme.hashAlg == keyScheme.hashAlg &&
+			isValidSignScheme(pub.Type, keyScheme)
+	default:
+		return keyScheme, inScheme == keyScheme && isValidSignScheme(pub.Type, keyScheme)
+	}
+}
+
+// signDigest signs the supplied digest with the supplied key.
+func signDigest(o *object, scheme signScheme, digest []byte) (*tpm2.Signature, error) {
+	sig := &tpm2.Signature{SigAlg: scheme.alg, Signature: new(tpm2.SignatureU)}
+
+	switch scheme.alg {
+	case tpm2.SigSchemeAlgRSASSA, tpm2.SigSchemeAlgRSAPSS:
+		k, ok := o.priv.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("invalid key")
+		}
+		var s []byte
+		var err error
+		if scheme.alg == tpm2.SigSchemeAlgRSASSA {
+			s, err = rsa.SignPKCS1v15(rand.Reader, k, scheme.hashAlg.GetHash(), digest)
+			sig.Signature.RSASSA = &tpm2.SignatureRSASSA{Hash: scheme.hashAlg, Sig: s}
+		} else {
+			s, err = rsa.SignPSS(rand.Reader, k, scheme.hashAlg.GetHash(), digest,
+				&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+			sig.Signature.RSAPSS = &tpm2.SignatureRSAPSS{Hash: scheme.hashAlg, Sig: s}
+		}
+		if err != nil {
+			return nil, err
+		}
+	case tpm2.SigSchemeAlgECDSA:
+		k, ok := o.priv.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("invalid key")
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			return nil, err
+		}
+		sz := (k.Curve.Params().BitSize + 7) / 8
+		sig.Signature.ECDSA = &tpm2.SignatureECDSA{Hash: scheme.hashAlg, SignatureR: zeroExtend(r, sz), SignatureS: zeroExtend(s, sz)}
+	case tpm2.SigSchemeAlgECSCHNORR:
+		k, ok := o.priv.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("invalid key")
+		}
+		params := k.Curve.Params()
+		nonce := generateECCKey(nil, k.Curve)
+		e := computeSchnorrChallenge(params, scheme.hashAlg, nonce.X, digest)
+		// s = (k + e*d) mod n
+		s := new(big.Int).Mul(e, k.D)
+		s.Add(s, nonce.D)
+		s.Mod(s, params.N)
+		sz := (params.N.BitLen() + 7) / 8
+		sig.Signature.ECSCHNORR = &tpm2.SignatureECSCHNORR{Hash: scheme.hashAlg, SignatureR: zeroExtend(e, sz),
+			SignatureS: zeroExtend(s, sz)}
+	case tpm2.SigSchemeAlgHMAC:
+		sig.Signature.HMAC = &tpm2.TaggedHash{
+			HashAlg: scheme.hashAlg,
+			Digest:  hmacDigest(scheme.hashAlg, o.sensitive.Sensitive.Bits, digest)}
+	default:
+		return nil, errors.New("unsupported scheme")
+	}
+
+	return sig, nil
+}
+
+// computeSchnorrChallenge computes the challenge for an EC-Schnorr signature, which is the digest of the x coordinate
+// of the nonce point and the message digest, truncated to the size of the order of the curve.
+func computeSchnorrChallenge(params *elliptic.CurveParams, hashAlg tpm2.HashAlgorithmId, x *big.Int, digest []byte) *big.Int {
+	h := hashAlg.NewHash()
+	h.Write(zeroExtend(x, (params.BitSize+7)/8))
+	h.Write(digest)
+	e := h.Sum(nil)
+	if sz := (params.N.BitLen() + 7) / 8; len(e) > sz {
+		e = e[:sz]
+	}
+	return new(big.Int).SetBytes(e)
+}
+
+// signatureHashAlg returns the digest algorithm associated with the supplied signature.
+func signatureHashAlg(sig *tpm2.Signature) tpm2.HashAlgorithmId {
+	if sig.Signature == nil || sig.Signature.Any() == nil {
+		return tpm2.HashAlgorithmNull
+	}
+	return sig.Signature.Any().HashAlg
+}
+
+// verifySignature verifies the supplied signature against the supplied digest using the specified key. The index is
+// the parameter index of the signature, and is used to construct the returned error.
+func verifySignature(o *object, digest []byte, sig *tpm2.Signature, index int) tpm2.ResponseCode {
+	scheme := signScheme{alg: sig.SigAlg, hashAlg: signatureHashAlg(sig)}
+	if !isValidSignScheme(o.public.Type, scheme) {
+		return rcParam(tpm2.ErrorScheme, index)
+	}
+
+	ok := false
+	switch scheme.alg {
+	case tpm2.SigSchemeAlgRSASSA:
+		ok = rsa.VerifyPKCS1v15(o.public.Public().(*rsa.PublicKey), scheme.hashAlg.GetHash(), digest,
+			sig.Signature.RSASSA.Sig) == nil
+	case tpm2.SigSchemeAlgRSAPSS:
+		ok = rsa.VerifyPSS(o.public.Public().(*rsa.PublicKey), scheme.hashAlg.GetHash(), digest, sig.Signature.RSAPSS.Sig,
+			&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}) == nil
+	case tpm2.SigSchemeAlgECDSA:
+		s := sig.Signature.ECDSA
+		ok = ecdsa.Verify(o.public.Public().(*ecdsa.PublicKey), digest, new(big.Int).SetBytes(s.SignatureR),
+			new(big.Int).SetBytes(s.SignatureS))
+	case tpm2.SigSchemeAlgECSCHNORR:
+		s := sig.Signature.ECSCHNORR
+		k := o.public.Public().(*ecdsa.PublicKey)
+		params := k.Curve.Params()
+		e := new(big.Int).SetBytes(s.SignatureR)
+		sv := new(big.Int).SetBytes(s.SignatureS)
+		if e.Sign() == 0 || sv.Sign() == 0 || sv.Cmp(params.N) >= 0 {
+			break
+		}
+		// R = [s]G - [e]Q
+		x1, y1 := k.Curve.ScalarBaseMult(sv.Bytes())
+		x2, y2 := k.Curve.ScalarMult(k.X, k.Y, e.Bytes())
+		y2.Neg(y2)
+		y2.Mod(y2, params.P)
+		x, _ := k.Curve.Add(x1, y1, x2, y2)
+		ok = computeSchnorrChallenge(params, scheme.hashAlg, x, digest).Cmp(e) == 0
+	case tpm2.SigSchemeAlgECDAA:
+		return rcParam(tpm2.ErrorScheme, index)
+	case tpm2.SigSchemeAlgHMAC:
+		if o.sensitive == nil {
+			return rcHandle(tpm2.ErrorKey, 1)
+		}
+		ok = hmac.Equal(hmacDigest(scheme.hashAlg, o.sensitive.Sensitive.Bits, digest), sig.Signature.HMAC.Digest)
+	}
+	if !ok {
+		return rcParam(tpm2.ErrorSignature, index)
+	}
+	return tpm2.Success
+}
+
+// entityHierarchy returns the hierarchy that the entity associated with the supplied handle belongs to.
+func (t *TPM) entityHierarchy(h tpm2.Handle) tpm2.Handle {
+	switch h.Type() {
+	case tpm2.HandleTypePermanent:
+		switch h {
+		case tpm2.HandlePlatform, tpm2.HandleEndorsement, tpm2.HandleNull:
+			return h
+		default:
+			return tpm2.HandleOwner
+		}
+	case tpm2.HandleTypeTransient, tpm2.HandleTypePersistent:
+		if o := t.lookupObject(h); o != nil {
+			return o.hierarchy
+		}
+	case tpm2.HandleTypeNVIndex:
+		if n, ok := t.nvIndices[h]; ok && n.public.Attrs&tpm2.AttrNVPlatformCreate != 0 {
+			return tpm2.HandlePlatform
+		}
+	}
+	return tpm2.HandleOwner
+}
+
+// isValidTicketHierarchy indicates whether tickets produced by the supplied hierarchy can currently be verified.
+func (t *TPM) isValidTicketHierarchy(h tpm2.Handle) bool {
+	switch h {
+	case tpm2.HandleOwner, tpm2.HandleEndorsement, tpm2.HandlePlatform, tpm2.HandleNull:
+		return t.hierarchyEnabled(h)
+	default:
+		return false
+	}
+}
+
+func uint16Bytes(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func uint32Bytes(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func uint64Bytes(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// computeAuthTicket computes the digest for a TPMT_TK_AUTH ticket.
+func (t *TPM) computeAuthTicket(tag tpm2.StructTag, hierarchy tpm2.Handle, timeout uint64, expiresOnReset bool,
+	cpHashA tpm2.Digest, policyRef tpm2.Nonce, authName tpm2.Name) tpm2.Digest {
+	var resetCount []byte
+	if timeout != 0 && expiresOnReset {
+		resetCount = uint32Bytes(t.resetCount)
+	}
+	return hmacDigest(ticketHashAlg, t.hierarchyProof(hierarchy), uint16Bytes(uint16(tag)), uint64Bytes(timeout), resetCount,
+		cpHashA, policyRef, authName)
+}
+
+// computeVerifiedTicket computes the digest for a TPMT_TK_VERIFIED ticket.
+func (t *TPM) computeVerifiedTicket(hierarchy tpm2.Handle, digest tpm2.Digest, keyName tpm2.Name) tpm2.Digest {
+	return hmacDigest(ticketHashAlg, t.hierarchyProof(hierarchy), uint16Bytes(uint16(tpm2.TagVerified)), digest, keyName)
+}
+
+// computeCreationTicket computes the digest for a TPMT_TK_CREATION ticket.
+func (t *TPM) computeCreationTicket(hierarchy tpm2.Handle, name tpm2.Name, creationHash tpm2.Digest) tpm2.Digest {
+	return hmacDigest(ticketHashAlg, t.hierarchyProof(hierarchy), uint16Bytes(uint16(tpm2.TagCreation)), name, creationHash)
+}
+
+// computeHashcheckTicket computes the digest for a TPMT_TK_HASHCHECK ticket.
+func (t *TPM) computeHashcheckTicket(hierarchy tpm2.Handle, hashAlg tpm2.HashAlgorithmId, digest tpm2.Digest) tpm2.Digest {
+	return hmacDigest(ticketHashAlg, t.hierarchyProof(hierarchy), uint16Bytes(uint16(tpm2.TagHashcheck)),
+		uint16Bytes(uint16(hashAlg)), digest)
+}
+
+// checkSigningDigest checks the validation ticket for a digest to be signed with the supplied key and scheme.
+func (t *TPM) checkSigningDigest(key *object, scheme signScheme, digest tpm2.Digest, validation *tpm2.TkHashcheck,
+	digestIndex, validationIndex int) tpm2.ResponseCode {
+	if len(validation.Digest) > 0 || key.public.Attrs&tpm2.AttrRestricted != 0 {
+		if validation.Tag != tpm2.TagHashcheck || !t.isValidTicketHierarchy(validation.Hierarchy) ||
+			!hmac.Equal(validation.Digest, t.computeHashcheckTicket(validation.Hierarchy, scheme.hashAlg, digest)) {
+			return rcParam(tpm2.ErrorTicket, validationIndex)
+		}
+	} else if len(digest) != scheme.hashAlg.Size() {
+		return rcParam(tpm2.ErrorSize, digestIndex)
+	}
+	return tpm2.Success
+}
+
+// kdfa is a convenience wrapper around internal.KDFa.
+func kdfa(alg tpm2.HashAlgorithmId, key []byte, label string, contextU, contextV []byte, sizeInBits int) []byte {
+	return internal.KDFa(alg.GetHash(), key, []byte(label), contextU, contextV, sizeInBits)
+}
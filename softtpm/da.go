@@ -0,0 +1,106 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"github.com/canonical/go-tpm2"
+)
+
+// daState contains the state of the dictionary attack protection logic.
+type daState struct {
+	failedTries     uint32
+	maxTries        uint32
+	recoveryTime    uint32 // The time in seconds for failedTries to be decremented
+	lockoutRecovery uint32 // The time in seconds before the lockout hierarchy can be used after an authorization failure
+	lockoutAuthOK   bool
+
+	selfHealTimer uint64 // The clock value when failedTries was last decremented
+	lockoutTimer  uint64 // The clock value when the last lockout hierarchy authorization failure occurred
+}
+
+// updateDA decrements the failed authorization count and restores the use of the lockout hierarchy if the required time has
+// passed.
+func (t *TPM) updateDA() {
+	now := t.clock()
+	if t.da.recoveryTime != 0 {
+		interval := uint64(t.da.recoveryTime) * 1000
+		for t.da.failedTries > 0 && now-t.da.selfHealTimer >= interval {
+			t.da.failedTries--
+			t.da.selfHealTimer += interval
+		}
+	}
+	if !t.da.lockoutAuthOK && t.da.lockoutRecovery != 0 && now-t.da.lockoutTimer >= uint64(t.da.lockoutRecovery)*1000 {
+		t.da.lockoutAuthOK = true
+	}
+}
+
+// inLockout indicates whether the TPM is in dictionary attack lockout mode.
+func (t *TPM) inLockout() bool {
+	t.updateDA()
+	return t.da.failedTries >= t.da.maxTries
+}
+
+// checkLockout checks whether the entity associated with the supplied handle can be authorized with its authorization
+// value.
+func (t *TPM) checkLockout(h tpm2.Handle) tpm2.ResponseCode {
+	t.updateDA()
+	switch {
+	case h == tpm2.HandleLockout:
+		if !t.da.lockoutAuthOK {
+			return rcWarning(tpm2.WarningLockout)
+		}
+	case t.entityIsDAProtected(h):
+		if t.inLockout() {
+			return rcWarning(tpm2.WarningLockout)
+		}
+	}
+	return tpm2.Success
+}
+
+// authFailure records an authorization failure for the supplied session, and returns the appropriate response code.
+func (t *TPM) authFailure(cs *commandSession, index int) tpm2.ResponseCode {
+	if !cs.usesAuthValue() {
+		return rcSession(tpm2.ErrorBadAuth, index)
+	}
+
+	switch {
+	case cs.authHandle == tpm2.HandleLockout:
+		t.da.lockoutAuthOK = false
+		t.da.lockoutTimer = t.clock()
+		return rcSession(tpm2.ErrorAuthFail, index)
+	case t.entityIsDAProtected(cs.authHandle):
+		if t.da.failedTries == 0 {
+			t.da.selfHealTimer = t.clock()
+		}
+		if t.da.failedTries < t.da.maxTries {
+			t.da.failedTries++
+		}
+		return rcSession(tpm2.ErrorAuthFail, index)
+	default:
+		return rcSession(tpm2.ErrorBadAuth, index)
+	}
+}
+
+func (t *TPM) dictionaryAttackLockReset(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+	t.da.failedTries = 0
+	return tpm2.Success
+}
+
+func (t *TPM) dictionaryAttackParameters(c *command) tpm2.ResponseCode {
+	var newMaxTries, newRecoveryTime, lockoutRecovery uint32
+	if rc := c.unmarshal(&newMaxTries, &newRecoveryTime, &lockoutRecovery); rc != tpm2.Success {
+		return rc
+	}
+	t.da.maxTries = newMaxTries
+	t.da.recoveryTime = newRecoveryTime
+	t.da.lockoutRecovery = lockoutRecovery
+	if t.da.failedTries > t.da.maxTries {
+		t.da.failedTries = t.da.maxTries
+	}
+	return tpm2.Success
+}
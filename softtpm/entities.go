@@ -0,0 +1,222 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/canonical/go-tpm2"
+)
+
+// hierarchy contains the persistent state associated with a permanent hierarchy.
+type hierarchy struct {
+	seed       []byte // The primary seed, or nil for the lockout hierarchy
+	proof      []byte // The proof value, used for tickets and context protection
+	authValue  tpm2.Auth
+	authPolicy tpm2.Digest
+	policyAlg  tpm2.HashAlgorithmId
+}
+
+func newHierarchy(h tpm2.Handle) *hierarchy {
+	out := &hierarchy{policyAlg: tpm2.HashAlgorithmNull}
+	if h != tpm2.HandleLockout {
+		out.seed = randomBytes(64)
+		out.proof = randomBytes(64)
+	}
+	return out
+}
+
+// handleName returns the name of a handle that doesn't have a public area.
+func handleName(h tpm2.Handle) tpm2.Name {
+	n := make(tpm2.Name, binary.Size(h))
+	binary.BigEndian.PutUint32(n, uint32(h))
+	return n
+}
+
+// trimAuth removes trailing zeros from the supplied authorization value.
+func trimAuth(auth tpm2.Auth) tpm2.Auth {
+	return tpm2.Auth(bytes.TrimRight(auth, "\x00"))
+}
+
+// isHierarchy indicates whether the supplied handle corresponds to a hierarchy that can be used as the parent of a primary
+// object.
+func isHierarchy(h tpm2.Handle) bool {
+	switch h {
+	case tpm2.HandleOwner, tpm2.HandleEndorsement, tpm2.HandlePlatform, tpm2.HandleNull:
+		return true
+	default:
+		return false
+	}
+}
+
+// hierarchyEnabled indicates whether the supplied hierarchy is enabled.
+func (t *TPM) hierarchyEnabled(h tpm2.Handle) bool {
+	switch h {
+	case tpm2.HandleOwner:
+		return t.shEnable
+	case tpm2.HandleEndorsement:
+		return t.ehEnable
+	case tpm2.HandlePlatform:
+		return t.phEnable
+	default:
+		return true
+	}
+}
+
+// hierarchySeed returns the primary seed for the supplied hierarchy.
+func (t *TPM) hierarchySeed(h tpm2.Handle) []byte {
+	if h == tpm2.HandleNull {
+		return t.nullSeed
+	}
+	return t.hierarchies[h].seed
+}
+
+// hierarchyProof returns the proof value for the supplied hierarchy.
+func (t *TPM) hierarchyProof(h tpm2.Handle) []byte {
+	if h == tpm2.HandleNull {
+		return t.nullProof
+	}
+	return t.hierarchies[h].proof
+}
+
+// checkHandle checks that the supplied command handle references a valid entity. The index is used to construct the
+// returned error and starts from 1.
+func (t *TPM) checkHandle(h tpm2.Handle, index int) tpm2.ResponseCode {
+	switch h.Type() {
+	case tpm2.HandleTypePCR:
+		if h >= numPCRs {
+			return rcHandle(tpm2.ErrorValue, index)
+		}
+	case tpm2.HandleTypeNVIndex:
+		if _, ok := t.nvIndices[h]; !ok {
+			return rcHandle(tpm2.ErrorHandle, index)
+		}
+		if t.nvIndices[h].public.Attrs&tpm2.AttrNVPlatformCreate != 0 {
+			if !t.phEnableNV {
+				return rcHandle(tpm2.ErrorHandle, index)
+			}
+		} else if !t.shEnable {
+			return rcHandle(tpm2.ErrorHandle, index)
+		}
+	case tpm2.HandleTypeHMACSession, tpm2.HandleTypePolicySession:
+		s := t.lookupSession(h)
+		if s == nil || s.handle != h || !s.loaded {
+			return rcWarning(tpm2.WarningReferenceH0 + tpm2.WarningCode(index-1))
+		}
+	case tpm2.HandleTypePermanent:
+		switch h {
+		case tpm2.HandleOwner, tpm2.HandleEndorsement, tpm2.HandlePlatform:
+			if !t.hierarchyEnabled(h) {
+				return rcHandle(tpm2.ErrorHierarchy, index)
+			}
+		case tpm2.HandlePlatformNV:
+			if !t.phEnable {
+				return rcHandle(tpm2.ErrorHierarchy, index)
+			}
+		case tpm2.HandleNull, tpm2.HandleLockout:
+		default:
+			return rcHandle(tpm2.ErrorValue, index)
+		}
+	case tpm2.HandleTypeTransient:
+		o, ok := t.objects[h]
+		if !ok {
+			return rcWarning(tpm2.WarningReferenceH0 + tpm2.WarningCode(index-1))
+		}
+		if !t.hierarchyEnabled(o.hierarchy) {
+			return rcHandle(tpm2.ErrorHierarchy, index)
+		}
+	case tpm2.HandleTypePersistent:
+		o, ok := t.persistent[h]
+		if !ok || !t.hierarchyEnabled(o.hierarchy) {
+			return rcHandle(tpm2.ErrorHandle, index)
+		}
+	default:
+		return rcHandle(tpm2.ErrorHandle, index)
+	}
+	return tpm2.Success
+}
+
+// lookupObject returns the transient or persistent object associated with the supplied handle, or nil if it doesn't exist.
+func (t *TPM) lookupObject(h tpm2.Handle) *object {
+	switch h.Type() {
+	case tpm2.HandleTypeTransient:
+		return t.objects[h]
+	case tpm2.HandleTypePersistent:
+		return t.persistent[h]
+	default:
+		return nil
+	}
+}
+
+// entityName returns the name of the entity associated with the supplied handle.
+func (t *TPM) entityName(h tpm2.Handle) tpm2.Name {
+	switch h.Type() {
+	case tpm2.HandleTypeTransient, tpm2.HandleTypePersistent:
+		if o := t.lookupObject(h); o != nil {
+			return o.name
+		}
+	case tpm2.HandleTypeNVIndex:
+		if n, ok := t.nvIndices[h]; ok {
+			return n.name()
+		}
+	}
+	return handleName(h)
+}
+
+// entityAuthValue returns the authorization value of the entity associated with the supplied handle, with trailing zeros
+// removed. It returns nil if the entity doesn't exist.
+func (t *TPM) entityAuthValue(h tpm2.Handle) tpm2.Auth {
+	switch h.Type() {
+	case tpm2.HandleTypePermanent:
+		if hr, ok := t.hierarchies[h]; ok {
+			return hr.authValue
+		}
+	case tpm2.HandleTypeTransient, tpm2.HandleTypePersistent:
+		if o := t.lookupObject(h); o != nil {
+			return o.authValue()
+		}
+	case tpm2.HandleTypeNVIndex:
+		if n, ok := t.nvIndices[h]; ok {
+			return n.authValue
+		}
+	}
+	return nil
+}
+
+// entityAuthPolicy returns the authorization policy of the entity associated with the supplied handle.
+func (t *TPM) entityAuthPolicy(h tpm2.Handle) (tpm2.HashAlgorithmId, tpm2.Digest) {
+	switch h.Type() {
+	case tpm2.HandleTypePermanent:
+		if hr, ok := t.hierarchies[h]; ok {
+			return hr.policyAlg, hr.authPolicy
+		}
+	case tpm2.HandleTypeTransient, tpm2.HandleTypePersistent:
+		if o := t.lookupObject(h); o != nil && o.public != nil {
+			return o.public.NameAlg, o.public.AuthPolicy
+		}
+	case tpm2.HandleTypeNVIndex:
+		if n, ok := t.nvIndices[h]; ok {
+			return n.public.NameAlg, n.public.AuthPolicy
+		}
+	}
+	return tpm2.HashAlgorithmNull, nil
+}
+
+// entityIsDAProtected indicates whether authorization failures for the entity associated with the supplied handle
+// should be counted by the dictionary attack protection logic.
+func (t *TPM) entityIsDAProtected(h tpm2.Handle) bool {
+	switch h.Type() {
+	case tpm2.HandleTypeTransient, tpm2.HandleTypePersistent:
+		if o := t.lookupObject(h); o != nil && o.public != nil {
+			return o.public.Attrs&tpm2.AttrNoDA == 0
+		}
+	case tpm2.HandleTypeNVIndex:
+		if n, ok := t.nvIndices[h]; ok {
+			return n.public.Attrs&tpm2.AttrNVNoDA == 0
+		}
+	}
+	return false
+}
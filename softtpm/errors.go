@@ -0,0 +1,61 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"github.com/canonical/go-tpm2"
+)
+
+const (
+	// rcBadTag corresponds to TPM_RC_BAD_TAG, which is defined with the format of a TPM 1.2 response code.
+	rcBadTag tpm2.ResponseCode = 0x1e
+
+	rcVer1       tpm2.ResponseCode = 0x100
+	rcWarn       tpm2.ResponseCode = 0x900
+	rcFmt1       tpm2.ResponseCode = 0x80
+	rcParamBit   tpm2.ResponseCode = 0x40
+	rcSessBit    tpm2.ResponseCode = 0x800
+	rcIndexShift                   = 8
+)
+
+// rcError returns the response code for the supplied error code, without any handle, session or parameter index.
+func rcError(code tpm2.ErrorCode) tpm2.ResponseCode {
+	if tpm2.ResponseCode(code)&rcFmt1 != 0 {
+		return tpm2.ResponseCode(code)
+	}
+	return rcVer1 | tpm2.ResponseCode(code)
+}
+
+// rcWarning returns the response code for the supplied warning code.
+func rcWarning(code tpm2.WarningCode) tpm2.ResponseCode {
+	return rcWarn | tpm2.ResponseCode(code)
+}
+
+// rcParam returns the response code for an error associated with the parameter at the specified index, starting from 1.
+func rcParam(code tpm2.ErrorCode, index int) tpm2.ResponseCode {
+	rc := rcError(code)
+	if rc&rcFmt1 == 0 {
+		return rc
+	}
+	return rc | rcParamBit | tpm2.ResponseCode(index)<<rcIndexShift
+}
+
+// rcHandle returns the response code for an error associated with the handle at the specified index, starting from 1.
+func rcHandle(code tpm2.ErrorCode, index int) tpm2.ResponseCode {
+	rc := rcError(code)
+	if rc&rcFmt1 == 0 {
+		return rc
+	}
+	return rc | tpm2.ResponseCode(index)<<rcIndexShift
+}
+
+// rcSession returns the response code for an error associated with the session at the specified index, starting from 1.
+func rcSession(code tpm2.ErrorCode, index int) tpm2.ResponseCode {
+	rc := rcError(code)
+	if rc&rcFmt1 == 0 {
+		return rc
+	}
+	return rc | rcSessBit | tpm2.ResponseCode(index)<<rcIndexShift
+}
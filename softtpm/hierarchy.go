@@ -0,0 +1,182 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"github.com/canonical/go-tpm2"
+)
+
+// flushHierarchy flushes all transient objects and saved object contexts that belong to the supplied hierarchy.
+func (t *TPM) flushHierarchy(h tpm2.Handle) {
+	for handle, o := range t.objects {
+		if o.hierarchy == h {
+			delete(t.objects, handle)
+		}
+	}
+	var ids []string
+	for _, id := range t.savedObjectIds {
+		if t.savedObjects[id].hierarchy == h {
+			delete(t.savedObjects, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	t.savedObjectIds = ids
+}
+
+func (t *TPM) hierarchyChangeAuth(c *command) tpm2.ResponseCode {
+	var newAuth tpm2.Auth
+	if rc := c.unmarshal(&newAuth); rc != tpm2.Success {
+		return rc
+	}
+
+	hr, ok := t.hierarchies[c.handles[0]]
+	if !ok {
+		return rcHandle(tpm2.ErrorValue, 1)
+	}
+	newAuth = trimAuth(newAuth)
+	if len(newAuth) > ticketHashAlg.Size() {
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+
+	hr.authValue = newAuth
+	return c.respond()
+}
+
+func (t *TPM) hierarchyControl(c *command) tpm2.ResponseCode {
+	var enable tpm2.Handle
+	var state bool
+	if rc := c.unmarshal(&enable, &state); rc != tpm2.Success {
+		return rc
+	}
+
+	authHandle := c.handles[0]
+	switch authHandle {
+	case tpm2.HandleOwner, tpm2.HandleEndorsement, tpm2.HandlePlatform:
+	default:
+		return rcHandle(tpm2.ErrorHierarchy, 1)
+	}
+
+	var flag *bool
+	switch enable {
+	case tpm2.HandleOwner:
+		flag = &t.shEnable
+	case tpm2.HandleEndorsement:
+		flag = &t.ehEnable
+	case tpm2.HandlePlatform:
+		flag = &t.phEnable
+	case tpm2.HandlePlatformNV:
+		flag = &t.phEnableNV
+	default:
+		return rcParam(tpm2.ErrorValue, 1)
+	}
+
+	switch {
+	case state && authHandle != tpm2.HandlePlatform:
+		return rcError(tpm2.ErrorAuthType)
+	case !state && authHandle != tpm2.HandlePlatform && authHandle != enable:
+		return rcError(tpm2.ErrorAuthType)
+	}
+
+	*flag = state
+	if !state {
+		switch enable {
+		case tpm2.HandleOwner, tpm2.HandleEndorsement, tpm2.HandlePlatform:
+			t.flushHierarchy(enable)
+		}
+	}
+	return c.respond()
+}
+
+func (t *TPM) setPrimaryPolicy(c *command) tpm2.ResponseCode {
+	var authPolicy tpm2.Digest
+	var hashAlg tpm2.HashAlgorithmId
+	if rc := c.unmarshal(&authPolicy, &hashAlg); rc != tpm2.Success {
+		return rc
+	}
+
+	hr, ok := t.hierarchies[c.handles[0]]
+	if !ok {
+		return rcHandle(tpm2.ErrorValue, 1)
+	}
+	switch {
+	case hashAlg == tpm2.HashAlgorithmNull:
+		if len(authPolicy) != 0 {
+			return rcParam(tpm2.ErrorSize, 1)
+		}
+	case !isSupportedHash(hashAlg):
+		return rcParam(tpm2.ErrorHash, 2)
+	case len(authPolicy) != hashAlg.Size():
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+
+	hr.authPolicy = authPolicy
+	hr.policyAlg = hashAlg
+	return c.respond()
+}
+
+func (t *TPM) clear(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+
+	authHandle := c.handles[0]
+	if authHandle != tpm2.HandleLockout && authHandle != tpm2.HandlePlatform {
+		return rcHandle(tpm2.ErrorHierarchy, 1)
+	}
+	if t.disableClear {
+		return rcError(tpm2.ErrorDisabled)
+	}
+
+	for _, h := range []tpm2.Handle{tpm2.HandleOwner, tpm2.HandleEndorsement} {
+		t.flushHierarchy(h)
+	}
+	for h, o := range t.persistent {
+		if o.hierarchy != tpm2.HandlePlatform {
+			delete(t.persistent, h)
+		}
+	}
+	for h, n := range t.nvIndices {
+		if n.public.Attrs&tpm2.AttrNVPlatformCreate == 0 {
+			t.undefineSpace(h)
+		}
+	}
+
+	// The storage primary seed is replaced, and the proof values for the storage and endorsement hierarchies are
+	// replaced in order to invalidate existing tickets and saved contexts. The endorsement primary seed is retained.
+	owner := newHierarchy(tpm2.HandleOwner)
+	t.hierarchies[tpm2.HandleOwner] = owner
+	endorsement := t.hierarchies[tpm2.HandleEndorsement]
+	endorsement.proof = randomBytes(64)
+	endorsement.authValue = nil
+	endorsement.authPolicy = nil
+	endorsement.policyAlg = tpm2.HashAlgorithmNull
+	t.hierarchies[tpm2.HandleLockout] = newHierarchy(tpm2.HandleLockout)
+
+	t.shEnable = true
+	t.ehEnable = true
+	t.da.failedTries = 0
+	return c.respond()
+}
+
+func (t *TPM) clearControl(c *command) tpm2.ResponseCode {
+	var disable bool
+	if rc := c.unmarshal(&disable); rc != tpm2.Success {
+		return rc
+	}
+
+	switch c.handles[0] {
+	case tpm2.HandleLockout:
+		if !disable {
+			return rcError(tpm2.ErrorAuthFail)
+		}
+	case tpm2.HandlePlatform:
+	default:
+		return rcHandle(tpm2.ErrorHierarchy, 1)
+	}
+
+	t.disableClear = disable
+	return c.respond()
+}
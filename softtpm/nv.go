@@ -0,0 +1,455 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"encoding/binary"
+
+	"github.com/canonical/go-tpm2"
+)
+
+// nvAttrsReserved are the reserved bits of TPMA_NV.
+const nvAttrsReserved tpm2.NVAttributes = 0x01f00300
+
+// nvIndex contains the state of a NV index.
+type nvIndex struct {
+	public    *tpm2.NVPublic
+	authValue tpm2.Auth
+	data      []byte
+}
+
+// name returns the name of the index, which depends on its current attributes.
+func (n *nvIndex) name() tpm2.Name {
+	name, _ := n.public.Name()
+	return name
+}
+
+func (n *nvIndex) written() bool {
+	return n.public.Attrs&tpm2.AttrNVWritten != 0
+}
+
+// nvIndexSize returns the number of bytes of NV memory consumed by the supplied index.
+func nvIndexSize(pub *tpm2.NVPublic) int {
+	return int(pub.Size) + 64
+}
+
+// nvSpaceUsed returns the number of bytes of NV memory used by NV indices and persistent objects.
+func (t *TPM) nvSpaceUsed() (n int) {
+	for _, index := range t.nvIndices {
+		n += nvIndexSize(index.public)
+	}
+	return n + len(t.persistent)*512
+}
+
+// nvWriteAccessChecks checks that the index can be written with the supplied authorization handle.
+func (t *TPM) nvWriteAccessChecks(authHandle, nvHandle tpm2.Handle) tpm2.ResponseCode {
+	n := t.nvIndices[nvHandle]
+	attrs := n.public.Attrs
+	if attrs&tpm2.AttrNVWriteLocked != 0 {
+		return rcError(tpm2.ErrorNVLocked)
+	}
+	switch authHandle {
+	case tpm2.HandleOwner:
+		if attrs&tpm2.AttrNVOwnerWrite == 0 {
+			return rcError(tpm2.ErrorNVAuthorization)
+		}
+	case tpm2.HandlePlatform:
+		if attrs&tpm2.AttrNVPPWrite == 0 {
+			return rcError(tpm2.ErrorNVAuthorization)
+		}
+	case nvHandle:
+	default:
+		return rcError(tpm2.ErrorNVAuthorization)
+	}
+	return tpm2.Success
+}
+
+// nvReadAccessChecks checks that the index can be read with the supplied authorization handle.
+func (t *TPM) nvReadAccessChecks(authHandle, nvHandle tpm2.Handle) tpm2.ResponseCode {
+	n := t.nvIndices[nvHandle]
+	attrs := n.public.Attrs
+	if attrs&tpm2.AttrNVReadLocked != 0 {
+		return rcError(tpm2.ErrorNVLocked)
+	}
+	switch authHandle {
+	case tpm2.HandleOwner:
+		if attrs&tpm2.AttrNVOwnerRead == 0 {
+			return rcError(tpm2.ErrorNVAuthorization)
+		}
+	case tpm2.HandlePlatform:
+		if attrs&tpm2.AttrNVPPRead == 0 {
+			return rcError(tpm2.ErrorNVAuthorization)
+		}
+	case nvHandle:
+	default:
+		return rcError(tpm2.ErrorNVAuthorization)
+	}
+	if !n.written() {
+		return rcError(tpm2.ErrorNVUninitialized)
+	}
+	return tpm2.Success
+}
+
+// nvWritten marks the supplied index as written.
+func (t *TPM) nvWritten(n *nvIndex) {
+	n.public.Attrs |= tpm2.AttrNVWritten
+	if n.public.Attrs.Type() == tpm2.NVTypeCounter {
+		if v := binary.BigEndian.Uint64(n.data); v > t.maxCounter {
+			t.maxCounter = v
+		}
+	}
+}
+
+// nvStartup updates the state of NV indices on TPM2_Startup.
+func (t *TPM) nvStartup(clear bool) {
+	for _, n := range t.nvIndices {
+		attrs := n.public.Attrs
+		attrs &^= tpm2.AttrNVReadLocked
+		if attrs&tpm2.AttrNVWriteStClear != 0 && (attrs&tpm2.AttrNVWriteDefine == 0 || !n.written()) {
+			attrs &^= tpm2.AttrNVWriteLocked
+		}
+		if clear && attrs&tpm2.AttrNVClearStClear != 0 {
+			attrs &^= tpm2.AttrNVWritten
+		}
+		n.public.Attrs = attrs
+	}
+}
+
+func (t *TPM) nvDefineSpace(c *command) tpm2.ResponseCode {
+	var auth tpm2.Auth
+	var publicInfo struct {
+		Ptr *tpm2.NVPublic `tpm2:"sized"`
+	}
+	if rc := c.unmarshal(&auth, &publicInfo); rc != tpm2.Success {
+		return rc
+	}
+
+	authHandle := c.handles[0]
+	if authHandle != tpm2.HandleOwner && authHandle != tpm2.HandlePlatform {
+		return rcHandle(tpm2.ErrorHierarchy, 1)
+	}
+
+	pub := publicInfo.Ptr
+	if pub == nil {
+		return rcParam(tpm2.ErrorSize, 2)
+	}
+	attrs := pub.Attrs
+
+	switch {
+	case pub.Index.Type() != tpm2.HandleTypeNVIndex:
+		return rcParam(tpm2.ErrorValue, 2)
+	case !isSupportedHash(pub.NameAlg):
+		return rcParam(tpm2.ErrorHash, 2)
+	case len(trimAuth(auth)) > pub.NameAlg.Size():
+		return rcParam(tpm2.ErrorSize, 1)
+	case len(pub.AuthPolicy) > 0 && len(pub.AuthPolicy) != pub.NameAlg.Size():
+		return rcParam(tpm2.ErrorSize, 2)
+	case attrs&nvAttrsReserved != 0:
+		return rcParam(tpm2.ErrorReservedBits, 2)
+	case attrs&(tpm2.AttrNVPPWrite|tpm2.AttrNVOwnerWrite|tpm2.AttrNVAuthWrite|tpm2.AttrNVPolicyWrite) == 0,
+		attrs&(tpm2.AttrNVPPRead|tpm2.AttrNVOwnerRead|tpm2.AttrNVAuthRead|tpm2.AttrNVPolicyRead) == 0,
+		attrs&(tpm2.AttrNVWritten|tpm2.AttrNVWriteLocked|tpm2.AttrNVReadLocked) != 0,
+		(attrs&tpm2.AttrNVPlatformCreate != 0) != (authHandle == tpm2.HandlePlatform),
+		attrs&tpm2.AttrNVPolicyDelete != 0 && len(pub.AuthPolicy) == 0:
+		return rcParam(tpm2.ErrorAttributes, 2)
+	}
+
+	switch attrs.Type() {
+	case tpm2.NVTypeOrdinary:
+		if int(pub.Size) > maxNVIndexSize {
+			return rcParam(tpm2.ErrorSize, 2)
+		}
+	case tpm2.NVTypeCounter, tpm2.NVTypeBits, tpm2.NVTypePinFail, tpm2.NVTypePinPass:
+		if pub.Size != 8 {
+			return rcParam(tpm2.ErrorSize, 2)
+		}
+		if attrs.Type() == tpm2.NVTypeCounter && attrs&tpm2.AttrNVClearStClear != 0 {
+			return rcParam(tpm2.ErrorAttributes, 2)
+		}
+		if attrs&tpm2.AttrNVWriteAll != 0 {
+			return rcParam(tpm2.ErrorAttributes, 2)
+		}
+	case tpm2.NVTypeExtend:
+		if int(pub.Size) != pub.NameAlg.Size() {
+			return rcParam(tpm2.ErrorSize, 2)
+		}
+	default:
+		return rcParam(tpm2.ErrorAttributes, 2)
+	}
+
+	if _, exists := t.nvIndices[pub.Index]; exists {
+		return rcError(tpm2.ErrorNVDefined)
+	}
+	if len(t.nvIndices) >= maxNVIndices || t.nvSpaceUsed()+nvIndexSize(pub) > maxNVSpace {
+		return rcError(tpm2.ErrorNVSpace)
+	}
+
+	n := &nvIndex{
+		public:    pub,
+		authValue: trimAuth(auth),
+		data:      make([]byte, pub.Size)}
+	for i := range n.data {
+		n.data[i] = 0xff
+	}
+	t.nvIndices[pub.Index] = n
+
+	return c.respond()
+}
+
+// undefineSpace removes the specified NV index.
+func (t *TPM) undefineSpace(h tpm2.Handle) {
+	delete(t.nvIndices, h)
+}
+
+func (t *TPM) nvUndefineSpace(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+
+	authHandle := c.handles[0]
+	if authHandle != tpm2.HandleOwner && authHandle != tpm2.HandlePlatform {
+		return rcHandle(tpm2.ErrorHierarchy, 1)
+	}
+
+	n := t.nvIndices[c.handles[1]]
+	if n.public.Attrs&tpm2.AttrNVPolicyDelete != 0 {
+		return rcHandle(tpm2.ErrorAttributes, 2)
+	}
+	if n.public.Attrs&tpm2.AttrNVPlatformCreate != 0 && authHandle != tpm2.HandlePlatform {
+		return rcError(tpm2.ErrorNVAuthorization)
+	}
+
+	t.undefineSpace(c.handles[1])
+	return c.respond()
+}
+
+func (t *TPM) nvUndefineSpaceSpecial(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+
+	if c.handles[1] != tpm2.HandlePlatform {
+		return rcHandle(tpm2.ErrorHierarchy, 2)
+	}
+	n := t.nvIndices[c.handles[0]]
+	if n.public.Attrs&tpm2.AttrNVPolicyDelete == 0 {
+		return rcHandle(tpm2.ErrorAttributes, 1)
+	}
+
+	t.undefineSpace(c.handles[0])
+	return c.respond()
+}
+
+func (t *TPM) nvReadPublic(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+
+	n := t.nvIndices[c.handles[0]]
+	return c.respond(struct {
+		Ptr *tpm2.NVPublic `tpm2:"sized"`
+	}{n.public}, n.name())
+}
+
+func (t *TPM) nvWrite(c *command) tpm2.ResponseCode {
+	var data tpm2.MaxNVBuffer
+	var offset uint16
+	if rc := c.unmarshal(&data, &offset); rc != tpm2.Success {
+		return rc
+	}
+
+	if rc := t.nvWriteAccessChecks(c.handles[0], c.handles[1]); rc != tpm2.Success {
+		return rc
+	}
+
+	n := t.nvIndices[c.handles[1]]
+	if n.public.Attrs.Type() != tpm2.NVTypeOrdinary {
+		return rcError(tpm2.ErrorAttributes)
+	}
+	if len(data) > maxNVBuffer {
+		return rcParam(tpm2.ErrorValue, 1)
+	}
+	if int(offset)+len(data) > int(n.public.Size) {
+		return rcError(tpm2.ErrorNVRange)
+	}
+	if n.public.Attrs&tpm2.AttrNVWriteAll != 0 && len(data) != int(n.public.Size) {
+		return rcError(tpm2.ErrorNVRange)
+	}
+
+	copy(n.data[offset:], data)
+	t.nvWritten(n)
+	return c.respond()
+}
+
+func (t *TPM) nvIncrement(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+
+	if rc := t.nvWriteAccessChecks(c.handles[0], c.handles[1]); rc != tpm2.Success {
+		return rc
+	}
+
+	n := t.nvIndices[c.handles[1]]
+	if n.public.Attrs.Type() != tpm2.NVTypeCounter {
+		return rcHandle(tpm2.ErrorAttributes, 2)
+	}
+
+	v := t.maxCounter
+	if n.written() {
+		v = binary.BigEndian.Uint64(n.data)
+	}
+	binary.BigEndian.PutUint64(n.data, v+1)
+	t.nvWritten(n)
+	return c.respond()
+}
+
+func (t *TPM) nvExtend(c *command) tpm2.ResponseCode {
+	var data tpm2.MaxNVBuffer
+	if rc := c.unmarshal(&data); rc != tpm2.Success {
+		return rc
+	}
+
+	if rc := t.nvWriteAccessChecks(c.handles[0], c.handles[1]); rc != tpm2.Success {
+		return rc
+	}
+
+	n := t.nvIndices[c.handles[1]]
+	if n.public.Attrs.Type() != tpm2.NVTypeExtend {
+		return rcHandle(tpm2.ErrorAttributes, 2)
+	}
+
+	h := n.public.NameAlg.NewHash()
+	if n.written() {
+		h.Write(n.data)
+	} else {
+		h.Write(make([]byte, len(n.data)))
+	}
+	h.Write(data)
+	n.data = h.Sum(nil)
+	t.nvWritten(n)
+	return c.respond()
+}
+
+func (t *TPM) nvSetBits(c *command) tpm2.ResponseCode {
+	var bits uint64
+	if rc := c.unmarshal(&bits); rc != tpm2.Success {
+		return rc
+	}
+
+	if rc := t.nvWriteAccessChecks(c.handles[0], c.handles[1]); rc != tpm2.Success {
+		return rc
+	}
+
+	n := t.nvIndices[c.handles[1]]
+	if n.public.Attrs.Type() != tpm2.NVTypeBits {
+		return rcHandle(tpm2.ErrorAttributes, 2)
+	}
+
+	var v uint64
+	if n.written() {
+		v = binary.BigEndian.Uint64(n.data)
+	}
+	binary.BigEndian.PutUint64(n.data, v|bits)
+	t.nvWritten(n)
+	return c.respond()
+}
+
+func (t *TPM) nvWriteLock(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+
+	n := t.nvIndices[c.handles[1]]
+	if n.public.Attrs&(tpm2.AttrNVWriteDefine|tpm2.AttrNVWriteStClear) == 0 {
+		return rcHandle(tpm2.ErrorAttributes, 2)
+	}
+	if rc := t.nvWriteAccessChecks(c.handles[0], c.handles[1]); rc != tpm2.Success {
+		if rc == rcError(tpm2.ErrorNVLocked) {
+			return c.respond()
+		}
+		return rc
+	}
+
+	n.public.Attrs |= tpm2.AttrNVWriteLocked
+	return c.respond()
+}
+
+func (t *TPM) nvGlobalWriteLock(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+
+	if c.handles[0] != tpm2.HandleOwner && c.handles[0] != tpm2.HandlePlatform {
+		return rcHandle(tpm2.ErrorHierarchy, 1)
+	}
+
+	for _, n := range t.nvIndices {
+		if n.public.Attrs&tpm2.AttrNVGlobalLock != 0 {
+			n.public.Attrs |= tpm2.AttrNVWriteLocked
+		}
+	}
+	return c.respond()
+}
+
+func (t *TPM) nvRead(c *command) tpm2.ResponseCode {
+	var size, offset uint16
+	if rc := c.unmarshal(&size, &offset); rc != tpm2.Success {
+		return rc
+	}
+
+	if rc := t.nvReadAccessChecks(c.handles[0], c.handles[1]); rc != tpm2.Success {
+		return rc
+	}
+
+	n := t.nvIndices[c.handles[1]]
+	if int(size) > maxNVBuffer {
+		return rcParam(tpm2.ErrorValue, 1)
+	}
+	if int(offset)+int(size) > int(n.public.Size) {
+		return rcError(tpm2.ErrorNVRange)
+	}
+
+	return c.respond(tpm2.MaxNVBuffer(n.data[offset : int(offset)+int(size)]))
+}
+
+func (t *TPM) nvReadLock(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+
+	n := t.nvIndices[c.handles[1]]
+	if n.public.Attrs&tpm2.AttrNVReadStClear == 0 {
+		return rcHandle(tpm2.ErrorAttributes, 2)
+	}
+	if rc := t.nvReadAccessChecks(c.handles[0], c.handles[1]); rc != tpm2.Success {
+		switch rc {
+		case rcError(tpm2.ErrorNVLocked):
+			return c.respond()
+		case rcError(tpm2.ErrorNVUninitialized):
+		default:
+			return rc
+		}
+	}
+
+	n.public.Attrs |= tpm2.AttrNVReadLocked
+	return c.respond()
+}
+
+func (t *TPM) nvChangeAuth(c *command) tpm2.ResponseCode {
+	var newAuth tpm2.Auth
+	if rc := c.unmarshal(&newAuth); rc != tpm2.Success {
+		return rc
+	}
+
+	n := t.nvIndices[c.handles[0]]
+	newAuth = trimAuth(newAuth)
+	if len(newAuth) > n.public.NameAlg.Size() {
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+
+	n.authValue = newAuth
+	return c.respond()
+}
@@ -0,0 +1,1138 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"math/big"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+const objectAttrsReserved tpm2.ObjectAttributes = 0xfff8f309
+
+type publicSized struct {
+	Ptr *tpm2.Public `tpm2:"sized"`
+}
+
+type sensitiveSized struct {
+	Ptr *tpm2.Sensitive `tpm2:"sized"`
+}
+
+type sensitiveCreateSized struct {
+	Ptr *tpm2.SensitiveCreate `tpm2:"sized"`
+}
+
+type creationDataSized struct {
+	Ptr *tpm2.CreationData `tpm2:"sized"`
+}
+
+// object is a transient or persistent object, or a sequence object.
+type object struct {
+	public        *tpm2.Public
+	sensitive     *tpm2.Sensitive   // nil if only the public area is loaded
+	priv          crypto.PrivateKey // The private key for asymmetric objects with a sensitive area
+	name          tpm2.Name
+	qualifiedName tpm2.Name
+	hierarchy     tpm2.Handle
+	evictHandle   tpm2.Handle // The persistent handle for a persistent object, or 0
+	external      bool        // The object was loaded with TPM2_LoadExternal
+	seq           *sequence   // The sequence state for a sequence object
+}
+
+// authValue returns the authorization value of the object, with trailing zeros removed.
+func (o *object) authValue() tpm2.Auth {
+	switch {
+	case o.seq != nil:
+		return o.seq.authValue
+	case o.sensitive == nil:
+		return nil
+	default:
+		return trimAuth(o.sensitive.AuthValue)
+	}
+}
+
+// isStorageParent indicates whether the object can be used as the parent of other objects.
+func (o *object) isStorageParent() bool {
+	if o.seq != nil || o.public.Attrs&(tpm2.AttrRestricted|tpm2.AttrDecrypt|tpm2.AttrSign) != tpm2.AttrRestricted|tpm2.AttrDecrypt {
+		return false
+	}
+	switch o.public.Type {
+	case tpm2.ObjectTypeRSA, tpm2.ObjectTypeECC, tpm2.ObjectTypeSymCipher:
+		return true
+	default:
+		return false
+	}
+}
+
+// symmetricDef returns the symmetric algorithm used to protect the children of the supplied storage parent.
+func symmetricDef(pub *tpm2.Public) *tpm2.SymDefObject {
+	switch pub.Type {
+	case tpm2.ObjectTypeRSA, tpm2.ObjectTypeECC:
+		return &pub.Params.AsymDetail().Symmetric
+	case tpm2.ObjectTypeSymCipher:
+		return &pub.Params.SymDetail.Sym
+	default:
+		return nil
+	}
+}
+
+// computeQualifiedName computes the qualified name of an object from its name and the qualified name of its parent.
+func computeQualifiedName(name, parentQN tpm2.Name) tpm2.Name {
+	alg := name.Algorithm()
+	h := alg.NewHash()
+	h.Write(parentQN)
+	h.Write(name)
+	qn, _ := mu.MarshalToBytes(alg, mu.RawBytes(h.Sum(nil)))
+	return qn
+}
+
+// parentQualifiedName returns the qualified name of the supplied parent, which may be a hierarchy.
+func (t *TPM) parentQualifiedName(h tpm2.Handle) tpm2.Name {
+	if isHierarchy(h) {
+		return handleName(h)
+	}
+	return t.lookupObject(h).qualifiedName
+}
+
+// allocateObjectHandle returns the lowest free transient handle.
+func (t *TPM) allocateObjectHandle() (tpm2.Handle, tpm2.ResponseCode) {
+	for i := tpm2.Handle(0); i < maxLoadedObjects; i++ {
+		h := tpm2.Handle(tpm2.HandleTypeTransient)<<24 | i
+		if _, ok := t.objects[h]; !ok {
+			return h, tpm2.Success
+		}
+	}
+	return tpm2.HandleUnassigned, rcWarning(tpm2.WarningObjectMemory)
+}
+
+// loadObject loads the supplied object in to a free transient slot.
+func (t *TPM) loadObject(o *object) (tpm2.Handle, tpm2.ResponseCode) {
+	h, rc := t.allocateObjectHandle()
+	if rc != tpm2.Success {
+		return h, rc
+	}
+	t.objects[h] = o
+	return h, tpm2.Success
+}
+
+// finalizeObject computes the name and the private key of an object.
+func finalizeObject(o *object) tpm2.ErrorCode {
+	name, err := o.public.Name()
+	if err != nil {
+		return tpm2.ErrorHash
+	}
+	o.name = name
+
+	if o.sensitive == nil {
+		return 0
+	}
+
+	switch o.public.Type {
+	case tpm2.ObjectTypeRSA:
+		if len(o.sensitive.Sensitive.RSA) == 0 {
+			return tpm2.ErrorKey
+		}
+		key, err := rsaKeyFromPrime(new(big.Int).SetBytes(o.public.Unique.RSA), rsaExponent(o.public),
+			new(big.Int).SetBytes(o.sensitive.Sensitive.RSA))
+		if err != nil {
+			return tpm2.ErrorBinding
+		}
+		o.priv = key
+	case tpm2.ObjectTypeECC:
+		curve := eccCurve(o.public.Params.ECCDetail.CurveID)
+		d := new(big.Int).SetBytes(o.sensitive.Sensitive.ECC)
+		if d.Sign() <= 0 || d.Cmp(curve.Params().N) >= 0 {
+			return tpm2.ErrorKey
+		}
+		key := &ecdsa.PrivateKey{D: d}
+		key.Curve = curve
+		key.X, key.Y = curve.ScalarBaseMult(d.Bytes())
+		if o.public.Unique.ECC == nil || key.X.Cmp(new(big.Int).SetBytes(o.public.Unique.ECC.X)) != 0 ||
+			key.Y.Cmp(new(big.Int).SetBytes(o.public.Unique.ECC.Y)) != 0 {
+			return tpm2.ErrorBinding
+		}
+		o.priv = key
+	case tpm2.ObjectTypeKeyedHash:
+		h := o.public.NameAlg.NewHash()
+		h.Write(o.sensitive.SeedValue)
+		h.Write(o.sensitive.Sensitive.Bits)
+		if !bytes.Equal(h.Sum(nil), o.public.Unique.KeyedHash) {
+			return tpm2.ErrorBinding
+		}
+	case tpm2.ObjectTypeSymCipher:
+		h := o.public.NameAlg.NewHash()
+		h.Write(o.sensitive.SeedValue)
+		h.Write(o.sensitive.Sensitive.Sym)
+		if !bytes.Equal(h.Sum(nil), o.public.Unique.Sym) {
+			return tpm2.ErrorBinding
+		}
+		if len(o.sensitive.Sensitive.Sym)*8 != int(o.public.Params.SymDetail.Sym.KeyBits.Sym) {
+			return tpm2.ErrorKeySize
+		}
+	}
+	return 0
+}
+
+// checkSymmetric checks the symmetric definition for a storage key or symmetric cipher object.
+func checkSymmetric(sym *tpm2.SymDefObject, allowNull, requireCFB bool) tpm2.ErrorCode {
+	switch sym.Algorithm {
+	case tpm2.SymObjectAlgorithmAES:
+		switch sym.KeyBits.Sym {
+		case 128, 192, 256:
+		default:
+			return tpm2.ErrorKeySize
+		}
+		switch sym.Mode.Sym {
+		case tpm2.SymModeCFB:
+		case tpm2.SymModeCTR, tpm2.SymModeOFB, tpm2.SymModeCBC, tpm2.SymModeECB, tpm2.SymModeNull:
+			if requireCFB {
+				return tpm2.ErrorMode
+			}
+		default:
+			return tpm2.ErrorMode
+		}
+	case tpm2.SymObjectAlgorithmNull:
+		if !allowNull {
+			return tpm2.ErrorSymmetric
+		}
+	default:
+		return tpm2.ErrorSymmetric
+	}
+	return 0
+}
+
+// checkPublic validates a public area for a new or loaded object. If parent is nil, the object is a primary object or is
+// being loaded with TPM2_LoadExternal. The index is the parameter index of the public area, and is used to construct the
+// returned error.
+func checkPublic(pub *tpm2.Public, parent *object, index int) tpm2.ResponseCode {
+	attrs := pub.Attrs
+	if attrs&objectAttrsReserved != 0 {
+		return rcParam(tpm2.ErrorReservedBits, index)
+	}
+	if !isSupportedHash(pub.NameAlg) {
+		return rcParam(tpm2.ErrorHash, index)
+	}
+	if len(pub.AuthPolicy) != 0 && len(pub.AuthPolicy) != pub.NameAlg.Size() {
+		return rcParam(tpm2.ErrorSize, index)
+	}
+	if attrs&tpm2.AttrFixedTPM != 0 && attrs&tpm2.AttrFixedParent == 0 {
+		return rcParam(tpm2.ErrorAttributes, index)
+	}
+	if parent != nil {
+		if attrs&tpm2.AttrFixedTPM != 0 && parent.public.Attrs&tpm2.AttrFixedTPM == 0 {
+			return rcParam(tpm2.ErrorAttributes, index)
+		}
+		if attrs&tpm2.AttrFixedParent == 0 && parent.public.Attrs&tpm2.AttrEncryptedDuplication != 0 &&
+			attrs&tpm2.AttrEncryptedDuplication == 0 {
+			return rcParam(tpm2.ErrorAttributes, index)
+		}
+	}
+
+	sign := attrs&tpm2.AttrSign != 0
+	decrypt := attrs&tpm2.AttrDecrypt != 0
+	restricted := attrs&tpm2.AttrRestricted != 0
+	if restricted && sign == decrypt {
+		return rcParam(tpm2.ErrorAttributes, index)
+	}
+
+	switch pub.Type {
+	case tpm2.ObjectTypeRSA, tpm2.ObjectTypeECC:
+		params := pub.Params.AsymDetail()
+		if restricted && decrypt {
+			if code := checkSymmetric(&params.Symmetric, false, true); code != 0 {
+				return rcParam(code, index)
+			}
+		} else if params.Symmetric.Algorithm != tpm2.SymObjectAlgorithmNull {
+			return rcParam(tpm2.ErrorSymmetric, index)
+		}
+
+		scheme := params.Scheme.Scheme
+		var schemeHash tpm2.HashAlgorithmId
+		if params.Scheme.Details != nil && params.Scheme.Details.Any() != nil {
+			schemeHash = params.Scheme.Details.Any().HashAlg
+		}
+		switch {
+		case scheme == tpm2.AsymSchemeNull:
+			if restricted && sign {
+				return rcParam(tpm2.ErrorScheme, index)
+			}
+		case sign && decrypt, restricted && decrypt:
+			return rcParam(tpm2.ErrorScheme, index)
+		case sign:
+			if !isValidSignScheme(pub.Type, signScheme{alg: tpm2.SigSchemeId(scheme), hashAlg: schemeHash}) {
+				return rcParam(tpm2.ErrorScheme, index)
+			}
+		case decrypt:
+			switch {
+			case pub.Type == tpm2.ObjectTypeRSA && scheme == tpm2.AsymSchemeRSAES:
+			case pub.Type == tpm2.ObjectTypeRSA && scheme == tpm2.AsymSchemeOAEP && isSupportedHash(schemeHash):
+			case pub.Type == tpm2.ObjectTypeECC && scheme == tpm2.AsymSchemeECDH && isSupportedHash(schemeHash):
+			default:
+				return rcParam(tpm2.ErrorScheme, index)
+			}
+		default:
+			return rcParam(tpm2.ErrorScheme, index)
+		}
+
+		if pub.Type == tpm2.ObjectTypeRSA {
+			switch pub.Params.RSADetail.KeyBits {
+			case 1024, 2048, 3072:
+			default:
+				return rcParam(tpm2.ErrorKeySize, index)
+			}
+			if e := pub.Params.RSADetail.Exponent; e != 0 && e != tpm2.DefaultRSAExponent {
+				return rcParam(tpm2.ErrorValue, index)
+			}
+		} else {
+			if eccCurve(pub.Params.ECCDetail.CurveID) == nil {
+				return rcParam(tpm2.ErrorCurve, index)
+			}
+			if pub.Params.ECCDetail.KDF.Scheme != tpm2.KDFAlgorithmNull {
+				return rcParam(tpm2.ErrorKDF, index)
+			}
+		}
+	case tpm2.ObjectTypeKeyedHash:
+		scheme := pub.Params.KeyedHashDetail.Scheme
+		switch {
+		case restricted && decrypt:
+			// Derivation parents are not supported
+			return rcParam(tpm2.ErrorType, index)
+		case scheme.Scheme == tpm2.KeyedHashSchemeNull:
+			if restricted {
+				return rcParam(tpm2.ErrorScheme, index)
+			}
+		case sign && decrypt, !sign && !decrypt:
+			return rcParam(tpm2.ErrorScheme, index)
+		case sign:
+			if scheme.Scheme != tpm2.KeyedHashSchemeHMAC || !isSupportedHash(scheme.Details.HMAC.HashAlg) {
+				return rcParam(tpm2.ErrorScheme, index)
+			}
+		default:
+			if scheme.Scheme != tpm2.KeyedHashSchemeXOR || !isSupportedHash(scheme.Details.XOR.HashAlg) {
+				return rcParam(tpm2.ErrorScheme, index)
+			}
+		}
+	case tpm2.ObjectTypeSymCipher:
+		if sign {
+			return rcParam(tpm2.ErrorAttributes, index)
+		}
+		if code := checkSymmetric(&pub.Params.SymDetail.Sym, false, restricted); code != 0 {
+			return rcParam(code, index)
+		}
+	default:
+		return rcParam(tpm2.ErrorType, index)
+	}
+
+	return tpm2.Success
+}
+
+// checkSensitiveCreate validates the sensitive data supplied for a new object against its template.
+func checkSensitiveCreate(pub *tpm2.Public, sensitive *tpm2.SensitiveCreate) tpm2.ResponseCode {
+	if len(sensitive.UserAuth) > pub.NameAlg.Size() {
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+
+	sdo := pub.Attrs&tpm2.AttrSensitiveDataOrigin != 0
+	switch pub.Type {
+	case tpm2.ObjectTypeRSA, tpm2.ObjectTypeECC:
+		if len(sensitive.Data) > 0 {
+			return rcParam(tpm2.ErrorSize, 1)
+		}
+		if !sdo {
+			return rcParam(tpm2.ErrorAttributes, 2)
+		}
+	case tpm2.ObjectTypeKeyedHash:
+		sealed := pub.Attrs&(tpm2.AttrSign|tpm2.AttrDecrypt) == 0
+		switch {
+		case sdo && len(sensitive.Data) > 0:
+			return rcParam(tpm2.ErrorAttributes, 2)
+		case sealed && sdo:
+			return rcParam(tpm2.ErrorAttributes, 2)
+		case !sealed && !sdo && len(sensitive.Data) == 0:
+			return rcParam(tpm2.ErrorAttributes, 2)
+		case len(sensitive.Data) > maxSymData:
+			return rcParam(tpm2.ErrorSize, 1)
+		}
+	case tpm2.ObjectTypeSymCipher:
+		switch {
+		case sdo && len(sensitive.Data) > 0:
+			return rcParam(tpm2.ErrorAttributes, 2)
+		case !sdo && len(sensitive.Data)*8 != int(pub.Params.SymDetail.Sym.KeyBits.Sym):
+			return rcParam(tpm2.ErrorKeySize, 1)
+		}
+	}
+	return tpm2.Success
+}
+
+// maxSymData is the maximum size of the data that can be sealed in a keyed hash object.
+const maxSymData = 128
+
+// createObject creates the sensitive area and private key for a new object, and completes its public area. If seed is not
+// nil, the object is a primary object and is derived from the seed and the template, which must be the marshalled form of
+// the supplied public area.
+func (t *TPM) createObject(pub *tpm2.Public, in *tpm2.SensitiveCreate, seed, template []byte) *object {
+	var derive func(label string) *kdfReader
+	if seed != nil {
+		h := pub.NameAlg.NewHash()
+		h.Write(template)
+		context := append(h.Sum(nil), in.Data...)
+		derive = func(label string) *kdfReader {
+			return &kdfReader{alg: pub.NameAlg, seed: seed, label: []byte(label), context: context}
+		}
+	}
+	bytesFor := func(label string, n int) []byte {
+		if derive == nil {
+			return randomBytes(n)
+		}
+		b := make([]byte, n)
+		derive(label).Read(b)
+		return b
+	}
+
+	sensitive := &tpm2.Sensitive{
+		Type:      pub.Type,
+		AuthValue: trimAuth(in.UserAuth),
+		Sensitive: new(tpm2.SensitiveCompositeU)}
+	o := &object{public: pub, sensitive: sensitive}
+
+	if pub.Attrs&(tpm2.AttrRestricted|tpm2.AttrDecrypt) == tpm2.AttrRestricted|tpm2.AttrDecrypt ||
+		pub.Type == tpm2.ObjectTypeKeyedHash || pub.Type == tpm2.ObjectTypeSymCipher {
+		sensitive.SeedValue = bytesFor("SEED", pub.NameAlg.Size())
+	}
+
+	switch pub.Type {
+	case tpm2.ObjectTypeRSA:
+		bits := int(pub.Params.RSADetail.KeyBits)
+		var key *rsa.PrivateKey
+		if derive != nil {
+			h := sha256.New()
+			h.Write(seed)
+			h.Write(template)
+			h.Write(in.Data)
+			cacheKey := string(h.Sum(nil))
+			key = t.rsaKeyCache[cacheKey]
+			if key == nil {
+				key, _ = generateRSAKey(derive("RSA"), bits, rsaExponent(pub))
+				t.rsaKeyCache[cacheKey] = key
+			}
+		} else {
+			key, _ = generateRSAKey(nil, bits, rsaExponent(pub))
+		}
+		pub.Unique = &tpm2.PublicIDU{RSA: zeroExtend(key.N, bits/8)}
+		sensitive.Sensitive.RSA = zeroExtend(key.Primes[0], bits/16)
+		o.priv = key
+	case tpm2.ObjectTypeECC:
+		curve := eccCurve(pub.Params.ECCDetail.CurveID)
+		var r *kdfReader
+		if derive != nil {
+			r = derive("ECC")
+		}
+		key := generateECCKey(r, curve)
+		sz := (curve.Params().BitSize + 7) / 8
+		pub.Unique = &tpm2.PublicIDU{ECC: &tpm2.ECCPoint{X: zeroExtend(key.X, sz), Y: zeroExtend(key.Y, sz)}}
+		sensitive.Sensitive.ECC = zeroExtend(key.D, sz)
+		o.priv = key
+	case tpm2.ObjectTypeKeyedHash:
+		data := in.Data
+		if pub.Attrs&tpm2.AttrSensitiveDataOrigin != 0 {
+			data = bytesFor("KEYEDHASH", pub.NameAlg.Size())
+		}
+		sensitive.Sensitive.Bits = data
+		h := pub.NameAlg.NewHash()
+		h.Write(sensitive.SeedValue)
+		h.Write(data)
+		pub.Unique = &tpm2.PublicIDU{KeyedHash: h.Sum(nil)}
+	case tpm2.ObjectTypeSymCipher:
+		key := tpm2.SymKey(in.Data)
+		if pub.Attrs&tpm2.AttrSensitiveDataOrigin != 0 {
+			key = bytesFor("SYMCIPHER", int(pub.Params.SymDetail.Sym.KeyBits.Sym)/8)
+		}
+		sensitive.Sensitive.Sym = key
+		h := pub.NameAlg.NewHash()
+		h.Write(sensitive.SeedValue)
+		h.Write(key)
+		pub.Unique = &tpm2.PublicIDU{Sym: h.Sum(nil)}
+	}
+
+	o.name, _ = pub.Name()
+	return o
+}
+
+// wrapOuter applies an outer wrapper to the supplied data using a seed and the name of the protected object.
+func wrapOuter(nameAlg tpm2.HashAlgorithmId, sym *tpm2.SymDefObject, seed []byte, name tpm2.Name, data []byte) []byte {
+	data = append([]byte(nil), data...)
+	symKey := kdfa(nameAlg, seed, "STORAGE", name, nil, int(sym.KeyBits.Sym))
+	symmetricCrypt(symKey, data, true)
+
+	hmacKey := kdfa(nameAlg, seed, "INTEGRITY", nil, nil, nameAlg.Size()*8)
+	integrity := hmacDigest(nameAlg, hmacKey, data, name)
+
+	out, _ := mu.MarshalToBytes(integrity, mu.RawBytes(data))
+	return out
+}
+
+// unwrapOuter removes an outer wrapper from the supplied data.
+func unwrapOuter(nameAlg tpm2.HashAlgorithmId, sym *tpm2.SymDefObject, seed []byte, name tpm2.Name, data []byte) ([]byte, tpm2.ErrorCode) {
+	var integrity []byte
+	n, err := mu.UnmarshalFromBytes(data, &integrity)
+	if err != nil {
+		return nil, tpm2.ErrorInsufficient
+	}
+	if len(integrity) != nameAlg.Size() {
+		return nil, tpm2.ErrorSize
+	}
+	data = append([]byte(nil), data[n:]...)
+
+	hmacKey := kdfa(nameAlg, seed, "INTEGRITY", nil, nil, nameAlg.Size()*8)
+	if !hmac.Equal(hmacDigest(nameAlg, hmacKey, data, name), integrity) {
+		return nil, tpm2.ErrorIntegrity
+	}
+
+	symKey := kdfa(nameAlg, seed, "STORAGE", name, nil, int(sym.KeyBits.Sym))
+	symmetricCrypt(symKey, data, false)
+	return data, 0
+}
+
+// protectSensitive wraps the sensitive area of an object so that it can only be loaded by the supplied parent.
+func protectSensitive(parent *object, name tpm2.Name, sensitive *tpm2.Sensitive) (tpm2.Private, error) {
+	b, err := mu.MarshalToBytes(sensitiveSized{sensitive})
+	if err != nil {
+		return nil, err
+	}
+	return wrapOuter(parent.public.NameAlg, symmetricDef(parent.public), parent.sensitive.SeedValue, name, b), nil
+}
+
+// unmarshalSensitive unmarshals a TPM2B_SENSITIVE structure, checking that it is consistent with the supplied public area.
+func unmarshalSensitive(pub *tpm2.Public, b []byte) (*tpm2.Sensitive, tpm2.ErrorCode) {
+	var sensitive sensitiveSized
+	n, err := mu.UnmarshalFromBytes(b, &sensitive)
+	if err != nil || n != len(b) || sensitive.Ptr == nil {
+		return nil, tpm2.ErrorSensitive
+	}
+	if sensitive.Ptr.Type != pub.Type {
+		return nil, tpm2.ErrorType
+	}
+	if len(sensitive.Ptr.AuthValue) > pub.NameAlg.Size() {
+		return nil, tpm2.ErrorSize
+	}
+	return sensitive.Ptr, 0
+}
+
+// creationData builds the creation data for a new object.
+func (t *TPM) creationData(c *command, pub *tpm2.Public, parent tpm2.Handle, outsideInfo tpm2.Data, creationPCR tpm2.PCRSelectionList) *tpm2.CreationData {
+	parentName := t.entityName(parent)
+	return &tpm2.CreationData{
+		PCRSelect:           creationPCR,
+		PCRDigest:           t.computePCRDigest(pub.NameAlg, creationPCR),
+		Locality:            tpm2.Locality(1 << c.locality),
+		ParentNameAlg:       tpm2.AlgorithmId(parentName.Algorithm()),
+		ParentName:          parentName,
+		ParentQualifiedName: t.parentQualifiedName(parent),
+		OutsideInfo:         outsideInfo}
+}
+
+// creationTicket computes the creation hash and ticket for a new object.
+func (t *TPM) creationTicket(hierarchy tpm2.Handle, name tpm2.Name, nameAlg tpm2.HashAlgorithmId, data *tpm2.CreationData) (tpm2.Digest, *tpm2.TkCreation) {
+	h := nameAlg.NewHash()
+	mu.MarshalToWriter(h, data)
+	creationHash := h.Sum(nil)
+	return creationHash, &tpm2.TkCreation{
+		Tag:       tpm2.TagCreation,
+		Hierarchy: hierarchy,
+		Digest:    t.computeCreationTicket(hierarchy, name, creationHash)}
+}
+
+// checkParent checks that the object at the specified handle index can be used as a storage parent.
+func (t *TPM) checkParent(c *command, index int) (*object, tpm2.ResponseCode) {
+	parent := t.lookupObject(c.handles[index-1])
+	if parent == nil || parent.sensitive == nil || !parent.isStorageParent() {
+		return nil, rcHandle(tpm2.ErrorType, index)
+	}
+	return parent, tpm2.Success
+}
+
+func (t *TPM) createPrimary(c *command) tpm2.ResponseCode {
+	var inSensitive sensitiveCreateSized
+	var inPublic publicSized
+	var outsideInfo tpm2.Data
+	var creationPCR tpm2.PCRSelectionList
+	if rc := c.unmarshal(&inSensitive, &inPublic, &outsideInfo, &creationPCR); rc != tpm2.Success {
+		return rc
+	}
+
+	hierarchy := c.handles[0]
+	if !isHierarchy(hierarchy) {
+		return rcHandle(tpm2.ErrorValue, 1)
+	}
+
+	if inSensitive.Ptr == nil {
+		inSensitive.Ptr = new(tpm2.SensitiveCreate)
+	}
+	if inPublic.Ptr == nil {
+		return rcParam(tpm2.ErrorSize, 2)
+	}
+	pub := inPublic.Ptr
+	if rc := checkPublic(pub, nil, 2); rc != tpm2.Success {
+		return rc
+	}
+	if rc := checkSensitiveCreate(pub, inSensitive.Ptr); rc != tpm2.Success {
+		return rc
+	}
+	if rc := t.checkPCRSelection(creationPCR, 4); rc != tpm2.Success {
+		return rc
+	}
+
+	template, err := mu.MarshalToBytes(pub)
+	if err != nil {
+		return rcError(tpm2.ErrorFailure)
+	}
+	o := t.createObject(pub, inSensitive.Ptr, t.hierarchySeed(hierarchy), template)
+	o.hierarchy = hierarchy
+	o.qualifiedName = computeQualifiedName(o.name, handleName(hierarchy))
+
+	handle, rc := t.loadObject(o)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	data := t.creationData(c, pub, hierarchy, outsideInfo, creationPCR)
+	creationHash, ticket := t.creationTicket(hierarchy, o.name, pub.NameAlg, data)
+
+	c.rHandle = handle
+	return c.respond(publicSized{pub}, creationDataSized{data}, creationHash, ticket, o.name)
+}
+
+func (t *TPM) create(c *command) tpm2.ResponseCode {
+	var inSensitive sensitiveCreateSized
+	var inPublic publicSized
+	var outsideInfo tpm2.Data
+	var creationPCR tpm2.PCRSelectionList
+	if rc := c.unmarshal(&inSensitive, &inPublic, &outsideInfo, &creationPCR); rc != tpm2.Success {
+		return rc
+	}
+
+	parent, rc := t.checkParent(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	if inSensitive.Ptr == nil {
+		inSensitive.Ptr = new(tpm2.SensitiveCreate)
+	}
+	if inPublic.Ptr == nil {
+		return rcParam(tpm2.ErrorSize, 2)
+	}
+	pub := inPublic.Ptr
+	if rc := checkPublic(pub, parent, 2); rc != tpm2.Success {
+		return rc
+	}
+	if rc := checkSensitiveCreate(pub, inSensitive.Ptr); rc != tpm2.Success {
+		return rc
+	}
+	if rc := t.checkPCRSelection(creationPCR, 4); rc != tpm2.Success {
+		return rc
+	}
+
+	o := t.createObject(pub, inSensitive.Ptr, nil, nil)
+	outPrivate, err := protectSensitive(parent, o.name, o.sensitive)
+	if err != nil {
+		return rcError(tpm2.ErrorFailure)
+	}
+
+	data := t.creationData(c, pub, c.handles[0], outsideInfo, creationPCR)
+	creationHash, ticket := t.creationTicket(parent.hierarchy, o.name, pub.NameAlg, data)
+
+	return c.respond(outPrivate, publicSized{pub}, creationDataSized{data}, creationHash, ticket)
+}
+
+func (t *TPM) createLoaded(c *command) tpm2.ResponseCode {
+	var inSensitive sensitiveCreateSized
+	var inPublic tpm2.Template
+	if rc := c.unmarshal(&inSensitive, &inPublic); rc != tpm2.Success {
+		return rc
+	}
+
+	var pub *tpm2.Public
+	if n, err := mu.UnmarshalFromBytes(inPublic, &pub); err != nil || n != len(inPublic) {
+		return rcParam(tpm2.ErrorSize, 2)
+	}
+
+	if inSensitive.Ptr == nil {
+		inSensitive.Ptr = new(tpm2.SensitiveCreate)
+	}
+
+	parentHandle := c.handles[0]
+	var parent *object
+	if !isHierarchy(parentHandle) {
+		var rc tpm2.ResponseCode
+		parent, rc = t.checkParent(c, 1)
+		if rc != tpm2.Success {
+			return rc
+		}
+	}
+
+	if rc := checkPublic(pub, parent, 2); rc != tpm2.Success {
+		return rc
+	}
+	if rc := checkSensitiveCreate(pub, inSensitive.Ptr); rc != tpm2.Success {
+		return rc
+	}
+
+	var o *object
+	var outPrivate tpm2.Private
+	if parent == nil {
+		o = t.createObject(pub, inSensitive.Ptr, t.hierarchySeed(parentHandle), inPublic)
+		o.hierarchy = parentHandle
+	} else {
+		o = t.createObject(pub, inSensitive.Ptr, nil, nil)
+		o.hierarchy = parent.hierarchy
+		var err error
+		outPrivate, err = protectSensitive(parent, o.name, o.sensitive)
+		if err != nil {
+			return rcError(tpm2.ErrorFailure)
+		}
+	}
+	o.qualifiedName = computeQualifiedName(o.name, t.parentQualifiedName(parentHandle))
+
+	handle, rc := t.loadObject(o)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	c.rHandle = handle
+	return c.respond(outPrivate, publicSized{pub}, o.name)
+}
+
+func (t *TPM) load(c *command) tpm2.ResponseCode {
+	var inPrivate tpm2.Private
+	var inPublic publicSized
+	if rc := c.unmarshal(&inPrivate, &inPublic); rc != tpm2.Success {
+		return rc
+	}
+
+	parent, rc := t.checkParent(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	if inPublic.Ptr == nil {
+		return rcParam(tpm2.ErrorSize, 2)
+	}
+	pub := inPublic.Ptr
+	if rc := checkPublic(pub, parent, 2); rc != tpm2.Success {
+		return rc
+	}
+
+	o := &object{public: pub, hierarchy: parent.hierarchy}
+	name, err := pub.Name()
+	if err != nil {
+		return rcParam(tpm2.ErrorHash, 2)
+	}
+
+	data, code := unwrapOuter(parent.public.NameAlg, symmetricDef(parent.public), parent.sensitive.SeedValue, name, inPrivate)
+	if code != 0 {
+		return rcParam(code, 1)
+	}
+	o.sensitive, code = unmarshalSensitive(pub, data)
+	if code != 0 {
+		return rcParam(code, 1)
+	}
+	if code := finalizeObject(o); code != 0 {
+		return rcParam(code, 1)
+	}
+	o.qualifiedName = computeQualifiedName(o.name, parent.qualifiedName)
+
+	handle, rc := t.loadObject(o)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	c.rHandle = handle
+	return c.respond(o.name)
+}
+
+func (t *TPM) loadExternal(c *command) tpm2.ResponseCode {
+	var inPrivate sensitiveSized
+	var inPublic publicSized
+	var hierarchy tpm2.Handle
+	if rc := c.unmarshal(&inPrivate, &inPublic, &hierarchy); rc != tpm2.Success {
+		return rc
+	}
+
+	if !isHierarchy(hierarchy) {
+		return rcParam(tpm2.ErrorValue, 3)
+	}
+	if !t.hierarchyEnabled(hierarchy) {
+		return rcParam(tpm2.ErrorHierarchy, 3)
+	}
+
+	if inPublic.Ptr == nil {
+		return rcParam(tpm2.ErrorSize, 2)
+	}
+	pub := inPublic.Ptr
+
+	o := &object{public: pub, hierarchy: hierarchy, external: true}
+
+	if inPrivate.Ptr != nil {
+		if hierarchy != tpm2.HandleNull {
+			return rcParam(tpm2.ErrorHierarchy, 3)
+		}
+		if pub.Attrs&(tpm2.AttrFixedTPM|tpm2.AttrFixedParent) != 0 {
+			return rcParam(tpm2.ErrorAttributes, 2)
+		}
+		if rc := checkPublic(pub, nil, 2); rc != tpm2.Success {
+			return rc
+		}
+		if inPrivate.Ptr.Type != pub.Type {
+			return rcParam(tpm2.ErrorType, 1)
+		}
+		o.sensitive = inPrivate.Ptr
+	} else {
+		if pub.Attrs&objectAttrsReserved != 0 {
+			return rcParam(tpm2.ErrorReservedBits, 2)
+		}
+		if !isSupportedHash(pub.NameAlg) {
+			return rcParam(tpm2.ErrorHash, 2)
+		}
+		switch pub.Type {
+		case tpm2.ObjectTypeRSA:
+			if pub.Params.RSADetail.KeyBits == 0 || len(pub.Unique.RSA) != int(pub.Params.RSADetail.KeyBits)/8 {
+				return rcParam(tpm2.ErrorKey, 2)
+			}
+		case tpm2.ObjectTypeECC:
+			curve := eccCurve(pub.Params.ECCDetail.CurveID)
+			if curve == nil {
+				return rcParam(tpm2.ErrorCurve, 2)
+			}
+			if pub.Unique.ECC == nil ||
+				!curve.IsOnCurve(new(big.Int).SetBytes(pub.Unique.ECC.X), new(big.Int).SetBytes(pub.Unique.ECC.Y)) {
+				return rcParam(tpm2.ErrorECCPoint, 2)
+			}
+		}
+	}
+
+	if code := finalizeObject(o); code != 0 {
+		if o.sensitive != nil {
+			return rcParam(code, 1)
+		}
+		return rcParam(code, 2)
+	}
+	o.qualifiedName = computeQualifiedName(o.name, handleName(hierarchy))
+
+	handle, rc := t.loadObject(o)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	c.rHandle = handle
+	return c.respond(o.name)
+}
+
+func (t *TPM) readPublic(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+
+	o := t.lookupObject(c.handles[0])
+	if o.seq != nil {
+		return rcHandle(tpm2.ErrorSequence, 1)
+	}
+	return c.respond(publicSized{o.public}, o.name, o.qualifiedName)
+}
+
+func (t *TPM) objectChangeAuth(c *command) tpm2.ResponseCode {
+	var newAuth tpm2.Auth
+	if rc := c.unmarshal(&newAuth); rc != tpm2.Success {
+		return rc
+	}
+
+	o := t.lookupObject(c.handles[0])
+	if c.handles[0].Type() != tpm2.HandleTypeTransient || o.seq != nil {
+		return rcHandle(tpm2.ErrorType, 1)
+	}
+	parent, rc := t.checkParent(c, 2)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if !bytes.Equal(computeQualifiedName(o.name, parent.qualifiedName), o.qualifiedName) {
+		return rcHandle(tpm2.ErrorType, 2)
+	}
+	if len(trimAuth(newAuth)) > o.public.NameAlg.Size() {
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+
+	sensitive := *o.sensitive
+	sensitive.AuthValue = trimAuth(newAuth)
+	outPrivate, err := protectSensitive(parent, o.name, &sensitive)
+	if err != nil {
+		return rcError(tpm2.ErrorFailure)
+	}
+	return c.respond(outPrivate)
+}
+
+func (t *TPM) unseal(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+
+	o := t.lookupObject(c.handles[0])
+	if o.seq != nil || o.public.Type != tpm2.ObjectTypeKeyedHash {
+		return rcHandle(tpm2.ErrorType, 1)
+	}
+	if o.public.Attrs&(tpm2.AttrSign|tpm2.AttrDecrypt|tpm2.AttrRestricted) != 0 {
+		return rcHandle(tpm2.ErrorAttributes, 1)
+	}
+	return c.respond(o.sensitive.Sensitive.Bits)
+}
+
+// credentialSymmetric returns the outer wrapper parameters for TPM2_MakeCredential and TPM2_ActivateCredential.
+func credentialSymmetric(key *object) *tpm2.SymDefObject {
+	return symmetricDef(key.public)
+}
+
+func (t *TPM) makeCredential(c *command) tpm2.ResponseCode {
+	var credential tpm2.Digest
+	var objectName tpm2.Name
+	if rc := c.unmarshal(&credential, &objectName); rc != tpm2.Success {
+		return rc
+	}
+
+	key := t.lookupObject(c.handles[0])
+	if key.seq != nil || key.public.Attrs&(tpm2.AttrRestricted|tpm2.AttrDecrypt|tpm2.AttrSign) != tpm2.AttrRestricted|tpm2.AttrDecrypt {
+		return rcHandle(tpm2.ErrorType, 1)
+	}
+	switch key.public.Type {
+	case tpm2.ObjectTypeRSA, tpm2.ObjectTypeECC:
+	default:
+		return rcHandle(tpm2.ErrorKey, 1)
+	}
+	if len(credential) > key.public.NameAlg.Size() {
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+
+	secret, seed, err := encryptSecret(key.public, []byte("IDENTITY"))
+	if err != nil {
+		return rcError(tpm2.ErrorFailure)
+	}
+	b, _ := mu.MarshalToBytes(credential)
+	blob := wrapOuter(key.public.NameAlg, credentialSymmetric(key), seed, objectName, b)
+
+	return c.respond(tpm2.IDObjectRaw(blob), secret)
+}
+
+func (t *TPM) activateCredential(c *command) tpm2.ResponseCode {
+	var credentialBlob tpm2.IDObjectRaw
+	var secret tpm2.EncryptedSecret
+	if rc := c.unmarshal(&credentialBlob, &secret); rc != tpm2.Success {
+		return rc
+	}
+
+	activate := t.lookupObject(c.handles[0])
+	key := t.lookupObject(c.handles[1])
+	if key.seq != nil || key.sensitive == nil || key.public.Attrs&(tpm2.AttrRestricted|tpm2.AttrDecrypt|tpm2.AttrSign) != tpm2.AttrRestricted|tpm2.AttrDecrypt {
+		return rcHandle(tpm2.ErrorType, 2)
+	}
+	switch key.public.Type {
+	case tpm2.ObjectTypeRSA, tpm2.ObjectTypeECC:
+	default:
+		return rcHandle(tpm2.ErrorKey, 2)
+	}
+
+	seed, err := decryptSecret(key, []byte("IDENTITY"), secret)
+	if err != nil {
+		return rcParam(tpm2.ErrorValue, 2)
+	}
+
+	data, code := unwrapOuter(key.public.NameAlg, credentialSymmetric(key), seed, activate.name, credentialBlob)
+	if code != 0 {
+		return rcParam(code, 1)
+	}
+	var certInfo tpm2.Digest
+	if n, err := mu.UnmarshalFromBytes(data, &certInfo); err != nil || n != len(data) {
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+
+	return c.respond(certInfo)
+}
+
+func (t *TPM) duplicate(c *command) tpm2.ResponseCode {
+	var encryptionKeyIn tpm2.Data
+	var symmetricAlg tpm2.SymDefObject
+	if rc := c.unmarshal(&encryptionKeyIn, &symmetricAlg); rc != tpm2.Success {
+		return rc
+	}
+
+	o := t.lookupObject(c.handles[0])
+	if o.seq != nil || o.sensitive == nil {
+		return rcHandle(tpm2.ErrorType, 1)
+	}
+	if o.public.Attrs&tpm2.AttrFixedParent != 0 {
+		return rcHandle(tpm2.ErrorAttributes, 1)
+	}
+
+	var newParent *object
+	if c.handles[1] != tpm2.HandleNull {
+		// The new parent only needs a public area.
+		newParent = t.lookupObject(c.handles[1])
+		if newParent.seq != nil || !newParent.isStorageParent() {
+			return rcHandle(tpm2.ErrorType, 2)
+		}
+	}
+
+	if code := checkSymmetric(&symmetricAlg, true, true); code != 0 {
+		return rcParam(code, 2)
+	}
+	if o.public.Attrs&tpm2.AttrEncryptedDuplication != 0 {
+		if symmetricAlg.Algorithm == tpm2.SymObjectAlgorithmNull {
+			return rcParam(tpm2.ErrorSymmetric, 2)
+		}
+		if newParent == nil {
+			return rcHandle(tpm2.ErrorHierarchy, 2)
+		}
+	}
+
+	// The authorization value is padded to the size of the name algorithm digest in the duplicated sensitive area.
+	sensitive := *o.sensitive
+	sensitive.AuthValue = make(tpm2.Auth, o.public.NameAlg.Size())
+	copy(sensitive.AuthValue, o.sensitive.AuthValue)
+
+	dup, err := mu.MarshalToBytes(sensitiveSized{&sensitive})
+	if err != nil {
+		return rcError(tpm2.ErrorFailure)
+	}
+
+	var encryptionKeyOut tpm2.Data
+	if symmetricAlg.Algorithm != tpm2.SymObjectAlgorithmNull {
+		keySize := int(symmetricAlg.KeyBits.Sym) / 8
+		switch {
+		case len(encryptionKeyIn) == 0:
+			encryptionKeyIn = randomBytes(keySize)
+			encryptionKeyOut = encryptionKeyIn
+		case len(encryptionKeyIn) != keySize:
+			return rcParam(tpm2.ErrorSize, 1)
+		}
+
+		h := o.public.NameAlg.NewHash()
+		h.Write(dup)
+		h.Write(o.name)
+		dup, _ = mu.MarshalToBytes(h.Sum(nil), mu.RawBytes(dup))
+		symmetricCrypt(encryptionKeyIn, dup, true)
+	} else if len(encryptionKeyIn) > 0 {
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+
+	var outSymSeed tpm2.EncryptedSecret
+	if newParent != nil {
+		var seed []byte
+		outSymSeed, seed, err = encryptSecret(newParent.public, []byte("DUPLICATE"))
+		if err != nil {
+			return rcError(tpm2.ErrorFailure)
+		}
+		dup = wrapOuter(newParent.public.NameAlg, symmetricDef(newParent.public), seed, o.name, dup)
+	}
+
+	return c.respond(encryptionKeyOut, tpm2.Private(dup), outSymSeed)
+}
+
+func (t *TPM) importObject(c *command) tpm2.ResponseCode {
+	var encryptionKey tpm2.Data
+	var objectPublic publicSized
+	var duplicate tpm2.Private
+	var inSymSeed tpm2.EncryptedSecret
+	var symmetricAlg tpm2.SymDefObject
+	if rc := c.unmarshal(&encryptionKey, &objectPublic, &duplicate, &inSymSeed, &symmetricAlg); rc != tpm2.Success {
+		return rc
+	}
+
+	parent, rc := t.checkParent(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	if objectPublic.Ptr == nil {
+		return rcParam(tpm2.ErrorSize, 2)
+	}
+	pub := objectPublic.Ptr
+	if pub.Attrs&(tpm2.AttrFixedTPM|tpm2.AttrFixedParent) != 0 {
+		return rcParam(tpm2.ErrorAttributes, 2)
+	}
+	if rc := checkPublic(pub, parent, 2); rc != tpm2.Success {
+		return rc
+	}
+	if code := checkSymmetric(&symmetricAlg, true, true); code != 0 {
+		return rcParam(code, 5)
+	}
+	if pub.Attrs&tpm2.AttrEncryptedDuplication != 0 {
+		if symmetricAlg.Algorithm == tpm2.SymObjectAlgorithmNull {
+			return rcParam(tpm2.ErrorAttributes, 5)
+		}
+		if len(inSymSeed) == 0 {
+			return rcParam(tpm2.ErrorAttributes, 4)
+		}
+	}
+
+	name, err := pub.Name()
+	if err != nil {
+		return rcParam(tpm2.ErrorHash, 2)
+	}
+
+	data := []byte(duplicate)
+	if len(inSymSeed) > 0 {
+		seed, err := decryptSecret(parent, []byte("DUPLICATE"), inSymSeed)
+		if err != nil {
+			return rcParam(tpm2.ErrorValue, 4)
+		}
+		var code tpm2.ErrorCode
+		data, code = unwrapOuter(parent.public.NameAlg, symmetricDef(parent.public), seed, name, data)
+		if code != 0 {
+			return rcParam(code, 3)
+		}
+	}
+
+	if symmetricAlg.Algorithm != tpm2.SymObjectAlgorithmNull {
+		if len(encryptionKey) != int(symmetricAlg.KeyBits.Sym)/8 {
+			return rcParam(tpm2.ErrorSize, 1)
+		}
+		data = append([]byte(nil), data...)
+		symmetricCrypt(encryptionKey, data, false)
+
+		var integrity []byte
+		n, err := mu.UnmarshalFromBytes(data, &integrity)
+		if err != nil {
+			return rcParam(tpm2.ErrorInsufficient, 3)
+		}
+		data = data[n:]
+		h := pub.NameAlg.NewHash()
+		h.Write(data)
+		h.Write(name)
+		if !bytes.Equal(h.Sum(nil), integrity) {
+			return rcParam(tpm2.ErrorIntegrity, 3)
+		}
+	}
+
+	sensitive, code := unmarshalSensitive(pub, data)
+	if code != 0 {
+		return rcParam(code, 3)
+	}
+	sensitive.AuthValue = trimAuth(sensitive.AuthValue)
+
+	o := &object{public: pub, sensitive: sensitive}
+	if code := finalizeObject(o); code != 0 {
+		return rcParam(code, 3)
+	}
+
+	outPrivate, err := protectSensitive(parent, o.name, sensitive)
+	if err != nil {
+		return rcError(tpm2.ErrorFailure)
+	}
+	return c.respond(outPrivate)
+}
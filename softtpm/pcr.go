@@ -0,0 +1,316 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"sort"
+
+	"github.com/canonical/go-tpm2"
+)
+
+// pcrMask is a bitmap of PCR indices.
+type pcrMask uint32
+
+func makePCRMask(pcrs ...int) (out pcrMask) {
+	for _, pcr := range pcrs {
+		out |= 1 << uint(pcr)
+	}
+	return out
+}
+
+func makePCRMaskRange(first, last int) (out pcrMask) {
+	for i := first; i <= last; i++ {
+		out |= 1 << uint(i)
+	}
+	return out
+}
+
+func (m pcrMask) contains(pcr int) bool {
+	return pcr >= 0 && pcr < numPCRs && m&(1<<uint(pcr)) != 0
+}
+
+func (m pcrMask) indices() (out []int) {
+	for i := 0; i < numPCRs; i++ {
+		if m.contains(i) {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// The PCR attributes, which are consistent with the PC Client Platform TPM Profile.
+var (
+	pcrSave        = makePCRMaskRange(0, 15)
+	pcrNoIncrement = makePCRMask(16, 21, 22, 23)
+	pcrDRTMReset   = makePCRMaskRange(17, 22)
+
+	pcrExtend = [5]pcrMask{
+		makePCRMaskRange(0, 16) | makePCRMask(23),
+		makePCRMaskRange(0, 16) | makePCRMask(20, 23),
+		makePCRMaskRange(0, 23),
+		makePCRMaskRange(0, 20) | makePCRMask(23),
+		makePCRMaskRange(0, 18) | makePCRMask(23)}
+	pcrReset = [5]pcrMask{
+		makePCRMask(16, 23),
+		makePCRMask(16, 23),
+		makePCRMask(16, 20, 21, 22, 23),
+		makePCRMask(16, 20, 21, 22, 23),
+		makePCRMaskRange(17, 22)}
+)
+
+// isPCRBankAllocated indicates whether the TPM has a PCR bank for the supplied algorithm.
+func isPCRBankAllocated(alg tpm2.HashAlgorithmId) bool {
+	for _, bank := range pcrBanks {
+		if bank == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// initPCRValue returns the initial value of the specified PCR.
+func initPCRValue(alg tpm2.HashAlgorithmId, pcr int) tpm2.Digest {
+	d := make(tpm2.Digest, alg.Size())
+	if pcrDRTMReset.contains(pcr) {
+		for i := range d {
+			d[i] = 0xff
+		}
+	}
+	return d
+}
+
+// initPCRs initializes the PCRs during TPM2_Startup. If saved is not nil, the values of PCRs that are preserved across
+// TPM2_Shutdown(STATE) are restored from it.
+func (t *TPM) initPCRs(saved map[tpm2.HashAlgorithmId][]tpm2.Digest) {
+	t.pcrs = make(map[tpm2.HashAlgorithmId][]tpm2.Digest)
+	for _, alg := range pcrBanks {
+		values := make([]tpm2.Digest, numPCRs)
+		for i := range values {
+			if saved != nil && pcrSave.contains(i) {
+				values[i] = saved[alg][i]
+				continue
+			}
+			values[i] = initPCRValue(alg, i)
+		}
+		t.pcrs[alg] = values
+	}
+	t.pcrCounter = 0
+}
+
+// savePCRs returns a copy of the PCR values that are preserved by TPM2_Shutdown(STATE).
+func (t *TPM) savePCRs() map[tpm2.HashAlgorithmId][]tpm2.Digest {
+	out := make(map[tpm2.HashAlgorithmId][]tpm2.Digest)
+	for alg, values := range t.pcrs {
+		out[alg] = append([]tpm2.Digest(nil), values...)
+	}
+	return out
+}
+
+// checkPCRSelection checks that the supplied selection only refers to supported digest algorithms and implemented PCRs.
+func (t *TPM) checkPCRSelection(pcrs tpm2.PCRSelectionList, index int) tpm2.ResponseCode {
+	for _, s := range pcrs {
+		if !isSupportedHash(s.Hash) {
+			return rcParam(tpm2.ErrorHash, index)
+		}
+		for _, i := range s.Select {
+			if i < 0 || i >= numPCRs {
+				return rcParam(tpm2.ErrorValue, index)
+			}
+		}
+	}
+	return tpm2.Success
+}
+
+// selectedPCRs returns the sorted and deduplicated list of PCRs in the supplied selection.
+func selectedPCRs(s tpm2.PCRSelection) []int {
+	var m pcrMask
+	for _, i := range s.Select {
+		if i >= 0 && i < numPCRs {
+			m |= 1 << uint(i)
+		}
+	}
+	return m.indices()
+}
+
+// computePCRDigest computes the digest of the selected PCR values with the specified algorithm. PCRs in banks that aren't
+// allocated are ignored.
+func (t *TPM) computePCRDigest(alg tpm2.HashAlgorithmId, pcrs tpm2.PCRSelectionList) tpm2.Digest {
+	h := alg.NewHash()
+	for _, s := range pcrs {
+		values, ok := t.pcrs[s.Hash]
+		if !ok {
+			continue
+		}
+		for _, i := range selectedPCRs(s) {
+			h.Write(values[i])
+		}
+	}
+	return h.Sum(nil)
+}
+
+// extendPCR extends the specified PCR bank with the supplied digest.
+func (t *TPM) extendPCR(pcr int, alg tpm2.HashAlgorithmId, digest tpm2.Digest) {
+	// Like the reference implementation, the update counter is incremented for
+	// each extend, even if the bank is not allocated.
+	t.pcrChanged(pcr)
+
+	values, ok := t.pcrs[alg]
+	if !ok {
+		return
+	}
+	h := alg.NewHash()
+	h.Write(values[pcr])
+	h.Write(digest)
+	values[pcr] = h.Sum(nil)
+}
+
+// pcrChanged records an update to the specified PCR.
+func (t *TPM) pcrChanged(pcr int) {
+	if !pcrNoIncrement.contains(pcr) {
+		t.pcrCounter++
+	}
+}
+
+// checkPCRExtend checks that the PCR referenced by the supplied command handle can be extended. It returns -1 if the
+// handle is TPM_RH_NULL.
+func (t *TPM) checkPCRExtend(c *command) (int, tpm2.ResponseCode) {
+	h := c.handles[0]
+	if h == tpm2.HandleNull {
+		return -1, tpm2.Success
+	}
+	if h.Type() != tpm2.HandleTypePCR {
+		return -1, rcHandle(tpm2.ErrorValue, 1)
+	}
+	pcr := int(h)
+	if !pcrExtend[c.locality].contains(pcr) {
+		return -1, rcWarning(tpm2.WarningLocality)
+	}
+	return pcr, tpm2.Success
+}
+
+func (t *TPM) pcrExtend(c *command) tpm2.ResponseCode {
+	var digests tpm2.TaggedHashList
+	if rc := c.unmarshal(&digests); rc != tpm2.Success {
+		return rc
+	}
+
+	pcr, rc := t.checkPCRExtend(c)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	for _, d := range digests {
+		if !isSupportedHash(d.HashAlg) {
+			return rcParam(tpm2.ErrorHash, 1)
+		}
+		if len(d.Digest) != d.HashAlg.Size() {
+			return rcParam(tpm2.ErrorSize, 1)
+		}
+	}
+	if pcr < 0 {
+		return c.respond()
+	}
+
+	for _, d := range digests {
+		t.extendPCR(pcr, d.HashAlg, d.Digest)
+	}
+	return c.respond()
+}
+
+func (t *TPM) pcrEvent(c *command) tpm2.ResponseCode {
+	var eventData tpm2.Event
+	if rc := c.unmarshal(&eventData); rc != tpm2.Success {
+		return rc
+	}
+
+	pcr, rc := t.checkPCRExtend(c)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	var digests tpm2.TaggedHashList
+	for _, alg := range supportedHashAlgs {
+		h := alg.NewHash()
+		h.Write(eventData)
+		digest := h.Sum(nil)
+		digests = append(digests, tpm2.TaggedHash{HashAlg: alg, Digest: digest})
+		if pcr >= 0 {
+			t.extendPCR(pcr, alg, digest)
+		}
+	}
+	return c.respond(digests)
+}
+
+func (t *TPM) pcrRead(c *command) tpm2.ResponseCode {
+	var pcrSelectionIn tpm2.PCRSelectionList
+	if rc := c.unmarshal(&pcrSelectionIn); rc != tpm2.Success {
+		return rc
+	}
+
+	var values tpm2.DigestList
+	pcrSelectionOut := tpm2.PCRSelectionList{}
+	for _, s := range pcrSelectionIn {
+		out := tpm2.PCRSelection{Hash: s.Hash, Select: []int{}}
+		if bank, ok := t.pcrs[s.Hash]; ok {
+			for _, i := range selectedPCRs(s) {
+				if len(values) >= maxPCRReadDigests {
+					break
+				}
+				out.Select = append(out.Select, i)
+				values = append(values, bank[i])
+			}
+		}
+		pcrSelectionOut = append(pcrSelectionOut, out)
+	}
+
+	return c.respond(t.pcrCounter, pcrSelectionOut, values)
+}
+
+func (t *TPM) pcrReset(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+
+	h := c.handles[0]
+	if h.Type() != tpm2.HandleTypePCR {
+		return rcHandle(tpm2.ErrorValue, 1)
+	}
+	pcr := int(h)
+	if !pcrReset[c.locality].contains(pcr) {
+		return rcWarning(tpm2.WarningLocality)
+	}
+
+	for alg, values := range t.pcrs {
+		values[pcr] = make(tpm2.Digest, alg.Size())
+	}
+	t.pcrChanged(pcr)
+	return c.respond()
+}
+
+func (t *TPM) pcrAllocate(c *command) tpm2.ResponseCode {
+	var pcrAllocation tpm2.PCRSelectionList
+	if rc := c.unmarshal(&pcrAllocation); rc != tpm2.Success {
+		return rc
+	}
+
+	if rc := t.checkPCRSelection(pcrAllocation, 1); rc != tpm2.Success {
+		return rc
+	}
+
+	// Changing the PCR allocation isn't supported, so only an allocation that matches the current one succeeds.
+	var banks []tpm2.HashAlgorithmId
+	for _, s := range pcrAllocation {
+		if len(selectedPCRs(s)) == numPCRs {
+			banks = append(banks, s.Hash)
+		}
+	}
+	sort.Slice(banks, func(i, j int) bool { return banks[i] < banks[j] })
+	allocationSuccess := len(banks) == len(pcrBanks)
+	for i := 0; allocationSuccess && i < len(banks); i++ {
+		allocationSuccess = banks[i] == pcrBanks[i]
+	}
+
+	return c.respond(allocationSuccess, uint32(numPCRs), uint32(len(banks)*numPCRs), uint32(0))
+}
@@ -0,0 +1,865 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+// expirationBit is set in the timeout returned from TPM2_PolicySigned and TPM2_PolicySecret if the corresponding ticket
+// expires on the next TPM reset.
+const expirationBit = uint64(1) << 63
+
+// policyState contains the policy specific state of a policy session.
+type policyState struct {
+	digest          tpm2.Digest
+	commandCode     tpm2.CommandCode // The command that the session is restricted to, or 0
+	cpHash          tpm2.Digest      // The command parameter digest that the session is restricted to, or nil
+	nameHash        tpm2.Digest      // The digest of the handle names that the session is restricted to, or nil
+	pcrCounter      uint32           // The value of the PCR update counter at the time of the last TPM2_PolicyPCR
+	pcrSet          bool
+	timeout         uint64 // The value of TPMS_TIME_INFO.time after which the session expires, or 0
+	authValueNeeded bool
+	passwordNeeded  bool
+	checkNVWritten  bool
+	nvWrittenState  bool
+	locality        uint8       // The TPMA_LOCALITY value that the session is restricted to, or 0
+	templateHash    tpm2.Digest // The digest of the object template that the session is restricted to, or nil
+}
+
+// resetPolicy restores the policy state of the session to its initial value.
+func (s *session) resetPolicy() {
+	s.policy = policyState{digest: make(tpm2.Digest, s.hashAlg.Size())}
+}
+
+// policyUpdate extends the supplied command code and arguments in to the policy digest of the session.
+func (s *session) policyUpdate(code tpm2.CommandCode, args ...[]byte) {
+	h := s.hashAlg.NewHash()
+	h.Write(s.policy.digest)
+	binary.Write(h, binary.BigEndian, code)
+	for _, arg := range args {
+		h.Write(arg)
+	}
+	s.policy.digest = h.Sum(nil)
+}
+
+// policyUpdateWithRef performs the 2-step policy digest update used by TPM2_PolicySigned, TPM2_PolicySecret and
+// TPM2_PolicyAuthorize.
+func (s *session) policyUpdateWithRef(code tpm2.CommandCode, name tpm2.Name, ref tpm2.Nonce) {
+	s.policyUpdate(code, name)
+	h := s.hashAlg.NewHash()
+	h.Write(s.policy.digest)
+	h.Write(ref)
+	s.policy.digest = h.Sum(nil)
+}
+
+// setTimeout restricts the session so that it expires at the supplied time, unless it is already restricted to an
+// earlier time.
+func (s *session) setTimeout(timeout uint64) {
+	if timeout != 0 && (s.policy.timeout == 0 || timeout < s.policy.timeout) {
+		s.policy.timeout = timeout
+	}
+}
+
+// lookupPolicySession returns the policy session for the handle at the specified index in the command handle area.
+func (t *TPM) lookupPolicySession(c *command, index int) (*session, tpm2.ResponseCode) {
+	h := c.handles[index-1]
+	if h.Type() != tpm2.HandleTypePolicySession {
+		return nil, rcHandle(tpm2.ErrorValue, index)
+	}
+	return t.lookupSession(h), tpm2.Success
+}
+
+// computeNameHash computes the digest of the names of the handles in the supplied command.
+func (t *TPM) computeNameHash(alg tpm2.HashAlgorithmId, c *command) tpm2.Digest {
+	h := alg.NewHash()
+	for _, handle := range c.handles {
+		h.Write(t.entityName(handle))
+	}
+	return h.Sum(nil)
+}
+
+// checkPolicySession checks that the supplied policy session satisfies the policy of the entity being authorized.
+func (t *TPM) checkPolicySession(c *command, cs *commandSession, index int) tpm2.ResponseCode {
+	s := cs.session
+	p := &s.policy
+
+	if p.pcrSet && p.pcrCounter != t.pcrCounter {
+		return rcError(tpm2.ErrorPCRChanged)
+	}
+
+	alg, authPolicy := t.entityAuthPolicy(cs.authHandle)
+	if alg != s.hashAlg || !bytes.Equal(p.digest, authPolicy) {
+		return rcSession(tpm2.ErrorPolicyFail, index)
+	}
+	if p.commandCode != 0 && p.commandCode != c.code {
+		return rcSession(tpm2.ErrorPolicyCC, index)
+	}
+	if (cs.role == roleAdmin || cs.role == roleDup) && p.commandCode == 0 {
+		return rcSession(tpm2.ErrorPolicyFail, index)
+	}
+	if p.timeout != 0 && p.timeout < t.time() {
+		return rcSession(tpm2.ErrorExpired, index)
+	}
+	if p.cpHash != nil && !bytes.Equal(p.cpHash, t.computeCpHash(s.hashAlg, c)) {
+		return rcSession(tpm2.ErrorPolicyFail, index)
+	}
+	if p.nameHash != nil && !bytes.Equal(p.nameHash, t.computeNameHash(s.hashAlg, c)) {
+		return rcSession(tpm2.ErrorPolicyFail, index)
+	}
+	if p.checkNVWritten {
+		n, ok := t.nvIndices[cs.authHandle]
+		if !ok || (n.public.Attrs&tpm2.AttrNVWritten != 0) != p.nvWrittenState {
+			return rcSession(tpm2.ErrorPolicyFail, index)
+		}
+	}
+	if p.locality != 0 && !localityMatches(p.locality, c.locality) {
+		return rcWarning(tpm2.WarningLocality)
+	}
+	if p.templateHash != nil {
+		template, ok := commandTemplate(c)
+		if !ok {
+			return rcSession(tpm2.ErrorPolicyFail, index)
+		}
+		h := s.hashAlg.NewHash()
+		h.Write(template)
+		if !bytes.Equal(p.templateHash, h.Sum(nil)) {
+			return rcSession(tpm2.ErrorPolicyFail, index)
+		}
+	}
+	return tpm2.Success
+}
+
+// localityMatches indicates whether the supplied locality is included in the supplied TPMA_LOCALITY value.
+func localityMatches(attr, locality uint8) bool {
+	if attr >= 32 {
+		return attr == locality
+	}
+	return locality < 5 && attr&(1<<locality) != 0
+}
+
+// commandTemplate returns the contents of the inPublic parameter of the supplied object creation command.
+func commandTemplate(c *command) ([]byte, bool) {
+	switch c.code {
+	case tpm2.CommandCreate, tpm2.CommandCreatePrimary, tpm2.CommandCreateLoaded:
+	default:
+		return nil, false
+	}
+	var inSensitive, inPublic []byte
+	if _, err := mu.UnmarshalFromBytes(c.cpBytes, &inSensitive, &inPublic); err != nil {
+		return nil, false
+	}
+	return inPublic, true
+}
+
+// computeAuthTimeout computes the time at which an authorization provided to TPM2_PolicySigned or TPM2_PolicySecret
+// expires.
+func (t *TPM) computeAuthTimeout(s *session, expiration int32, nonceTPM tpm2.Nonce) uint64 {
+	if expiration == 0 {
+		return 0
+	}
+	exp := int64(expiration)
+	if exp < 0 {
+		exp = -exp
+	}
+	if len(nonceTPM) == 0 {
+		return uint64(exp)*1000 + t.time()%1000
+	}
+	return s.startTime + uint64(exp)*1000
+}
+
+// policyParameterChecks performs the common parameter checks for TPM2_PolicySigned and TPM2_PolicySecret.
+func (t *TPM) policyParameterChecks(s *session, authTimeout uint64, cpHashA tpm2.Digest, nonceTPM tpm2.Nonce,
+	nonceIndex, cpHashIndex, expirationIndex int) tpm2.ResponseCode {
+	if len(nonceTPM) > 0 {
+		if !bytes.Equal(nonceTPM, s.nonceTPM) {
+			return rcParam(tpm2.ErrorNonce, nonceIndex)
+		}
+		if !s.trial && authTimeout != 0 && authTimeout < t.time() {
+			return rcParam(tpm2.ErrorExpired, expirationIndex)
+		}
+	}
+	if len(cpHashA) > 0 {
+		if len(cpHashA) != s.hashAlg.Size() {
+			return rcParam(tpm2.ErrorSize, cpHashIndex)
+		}
+		if s.policy.cpHash != nil && !bytes.Equal(cpHashA, s.policy.cpHash) {
+			return rcError(tpm2.ErrorCpHash)
+		}
+	}
+	return tpm2.Success
+}
+
+// policyAuthComplete updates the policy session after a successful TPM2_PolicySigned or TPM2_PolicySecret and returns the
+// response.
+func (t *TPM) policyAuthComplete(c *command, s *session, tag tpm2.StructTag, authHandle tpm2.Handle, authName tpm2.Name,
+	nonceTPM tpm2.Nonce, cpHashA tpm2.Digest, policyRef tpm2.Nonce, expiration int32, authTimeout uint64) tpm2.ResponseCode {
+	code := tpm2.CommandPolicySigned
+	if tag == tpm2.TagAuthSecret {
+		code = tpm2.CommandPolicySecret
+	}
+	s.policyUpdateWithRef(code, authName, policyRef)
+	if len(cpHashA) > 0 {
+		s.policy.cpHash = cpHashA
+	}
+	if !s.trial {
+		s.setTimeout(authTimeout)
+	}
+
+	if expiration >= 0 || s.trial {
+		return c.respond(tpm2.Timeout(nil), &tpm2.TkAuth{Tag: tag, Hierarchy: tpm2.HandleNull})
+	}
+
+	expiresOnReset := len(nonceTPM) == 0
+	hierarchy := t.entityHierarchy(authHandle)
+	ticket := &tpm2.TkAuth{
+		Tag:       tag,
+		Hierarchy: hierarchy,
+		Digest:    t.computeAuthTicket(tag, hierarchy, authTimeout, expiresOnReset, cpHashA, policyRef, authName)}
+	if expiresOnReset {
+		authTimeout |= expirationBit
+	}
+	timeout := make(tpm2.Timeout, 8)
+	binary.BigEndian.PutUint64(timeout, authTimeout)
+	return c.respond(timeout, ticket)
+}
+
+func (t *TPM) policySigned(c *command) tpm2.ResponseCode {
+	var nonceTPM tpm2.Nonce
+	var cpHashA tpm2.Digest
+	var policyRef tpm2.Nonce
+	var expiration int32
+	var auth tpm2.Signature
+	if rc := c.unmarshal(&nonceTPM, &cpHashA, &policyRef, &expiration, &auth); rc != tpm2.Success {
+		return rc
+	}
+
+	authObject := t.lookupObject(c.handles[0])
+	s, rc := t.lookupPolicySession(c, 2)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	authTimeout := t.computeAuthTimeout(s, expiration, nonceTPM)
+	if rc := t.policyParameterChecks(s, authTimeout, cpHashA, nonceTPM, 1, 2, 4); rc != tpm2.Success {
+		return rc
+	}
+
+	sigHash := signatureHashAlg(&auth)
+	if !isSupportedHash(sigHash) {
+		return rcParam(tpm2.ErrorScheme, 5)
+	}
+	h := sigHash.NewHash()
+	h.Write(nonceTPM)
+	binary.Write(h, binary.BigEndian, expiration)
+	h.Write(cpHashA)
+	h.Write(policyRef)
+	if rc := verifySignature(authObject, h.Sum(nil), &auth, 5); rc != tpm2.Success {
+		return rc
+	}
+
+	return t.policyAuthComplete(c, s, tpm2.TagAuthSigned, c.handles[0], authObject.name, nonceTPM, cpHashA, policyRef, expiration,
+		authTimeout)
+}
+
+func (t *TPM) policySecret(c *command) tpm2.ResponseCode {
+	var nonceTPM tpm2.Nonce
+	var cpHashA tpm2.Digest
+	var policyRef tpm2.Nonce
+	var expiration int32
+	if rc := c.unmarshal(&nonceTPM, &cpHashA, &policyRef, &expiration); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupPolicySession(c, 2)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	authTimeout := t.computeAuthTimeout(s, expiration, nonceTPM)
+	if rc := t.policyParameterChecks(s, authTimeout, cpHashA, nonceTPM, 1, 2, 4); rc != tpm2.Success {
+		return rc
+	}
+
+	return t.policyAuthComplete(c, s, tpm2.TagAuthSecret, c.handles[0], t.entityName(c.handles[0]), nonceTPM, cpHashA,
+		policyRef, expiration, authTimeout)
+}
+
+func (t *TPM) policyTicket(c *command) tpm2.ResponseCode {
+	var timeout tpm2.Timeout
+	var cpHashA tpm2.Digest
+	var policyRef tpm2.Nonce
+	var authName tpm2.Name
+	var ticket tpm2.TkAuth
+	if rc := c.unmarshal(&timeout, &cpHashA, &policyRef, &authName, &ticket); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupPolicySession(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	var code tpm2.CommandCode
+	switch ticket.Tag {
+	case tpm2.TagAuthSigned:
+		code = tpm2.CommandPolicySigned
+	case tpm2.TagAuthSecret:
+		code = tpm2.CommandPolicySecret
+	default:
+		return rcParam(tpm2.ErrorTag, 5)
+	}
+
+	if len(timeout) != 8 {
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+	authTimeout := binary.BigEndian.Uint64(timeout)
+	expiresOnReset := authTimeout&expirationBit != 0
+	authTimeout &^= expirationBit
+
+	if rc := t.policyParameterChecks(s, authTimeout, cpHashA, nil, 0, 2, 1); rc != tpm2.Success {
+		return rc
+	}
+	if authTimeout < t.time() {
+		return rcParam(tpm2.ErrorExpired, 1)
+	}
+
+	if ticket.Hierarchy == tpm2.HandleNull || !t.isValidTicketHierarchy(ticket.Hierarchy) {
+		return rcParam(tpm2.ErrorTicket, 5)
+	}
+	expected := t.computeAuthTicket(ticket.Tag, ticket.Hierarchy, authTimeout, expiresOnReset, cpHashA, policyRef, authName)
+	if !bytes.Equal(expected, ticket.Digest) {
+		return rcParam(tpm2.ErrorTicket, 5)
+	}
+
+	s.policyUpdateWithRef(code, authName, policyRef)
+	if len(cpHashA) > 0 {
+		s.policy.cpHash = cpHashA
+	}
+	s.setTimeout(authTimeout)
+
+	return c.respond()
+}
+
+func (t *TPM) policyOR(c *command) tpm2.ResponseCode {
+	var pHashList tpm2.DigestList
+	if rc := c.unmarshal(&pHashList); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupPolicySession(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	if len(pHashList) < 2 || len(pHashList) > 8 {
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+
+	found := s.trial
+	var digests []byte
+	for _, d := range pHashList {
+		if len(d) != s.hashAlg.Size() {
+			return rcParam(tpm2.ErrorSize, 1)
+		}
+		if bytes.Equal(d, s.policy.digest) {
+			found = true
+		}
+		digests = append(digests, d...)
+	}
+	if !found {
+		return rcParam(tpm2.ErrorValue, 1)
+	}
+
+	s.policy.digest = make(tpm2.Digest, s.hashAlg.Size())
+	s.policyUpdate(tpm2.CommandPolicyOR, digests)
+	return c.respond()
+}
+
+func (t *TPM) policyPCR(c *command) tpm2.ResponseCode {
+	var pcrDigest tpm2.Digest
+	var pcrs tpm2.PCRSelectionList
+	if rc := c.unmarshal(&pcrDigest, &pcrs); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupPolicySession(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	if rc := t.checkPCRSelection(pcrs, 2); rc != tpm2.Success {
+		return rc
+	}
+
+	digest := t.computePCRDigest(s.hashAlg, pcrs)
+	if !s.trial {
+		if len(pcrDigest) > 0 && !bytes.Equal(pcrDigest, digest) {
+			return rcParam(tpm2.ErrorValue, 1)
+		}
+		if s.policy.pcrSet && s.policy.pcrCounter != t.pcrCounter {
+			return rcError(tpm2.ErrorPCRChanged)
+		}
+		s.policy.pcrSet = true
+		s.policy.pcrCounter = t.pcrCounter
+	} else if len(pcrDigest) > 0 {
+		digest = pcrDigest
+	}
+
+	pcrBytes, err := mu.MarshalToBytes(pcrs)
+	if err != nil {
+		return rcError(tpm2.ErrorFailure)
+	}
+	s.policyUpdate(tpm2.CommandPolicyPCR, pcrBytes, digest)
+	return c.respond()
+}
+
+// checkCondition performs the comparison for TPM2_PolicyNV and TPM2_PolicyCounterTimer.
+func checkCondition(op tpm2.ArithmeticOp, a, b []byte) bool {
+	signedValue := func(x []byte) *big.Int {
+		v := new(big.Int).SetBytes(x)
+		if len(x) > 0 && x[0]&0x80 != 0 {
+			v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(len(x)*8)))
+		}
+		return v
+	}
+
+	switch op {
+	case tpm2.OpEq:
+		return bytes.Equal(a, b)
+	case tpm2.OpNeq:
+		return !bytes.Equal(a, b)
+	case tpm2.OpSignedGT:
+		return signedValue(a).Cmp(signedValue(b)) > 0
+	case tpm2.OpUnsignedGT:
+		return bytes.Compare(a, b) > 0
+	case tpm2.OpSignedLT:
+		return signedValue(a).Cmp(signedValue(b)) < 0
+	case tpm2.OpUnsignedLT:
+		return bytes.Compare(a, b) < 0
+	case tpm2.OpSignedGE:
+		return signedValue(a).Cmp(signedValue(b)) >= 0
+	case tpm2.OpUnsignedGE:
+		return bytes.Compare(a, b) >= 0
+	case tpm2.OpSignedLE:
+		return signedValue(a).Cmp(signedValue(b)) <= 0
+	case tpm2.OpUnsignedLE:
+		return bytes.Compare(a, b) <= 0
+	case tpm2.OpBitset:
+		for i := range a {
+			if a[i]&b[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	case tpm2.OpBitclear:
+		for i := range a {
+			if a[i]&b[i] != 0 {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// computeOperandArgs computes the argument digest for TPM2_PolicyNV and TPM2_PolicyCounterTimer.
+func computeOperandArgs(alg tpm2.HashAlgorithmId, operandB tpm2.Operand, offset uint16, operation tpm2.ArithmeticOp) []byte {
+	h := alg.NewHash()
+	h.Write(operandB)
+	binary.Write(h, binary.BigEndian, offset)
+	binary.Write(h, binary.BigEndian, operation)
+	return h.Sum(nil)
+}
+
+func isValidArithmeticOp(op tpm2.ArithmeticOp) bool {
+	return op <= tpm2.OpBitclear
+}
+
+func (t *TPM) policyNV(c *command) tpm2.ResponseCode {
+	var operandB tpm2.Operand
+	var offset uint16
+	var operation tpm2.ArithmeticOp
+	if rc := c.unmarshal(&operandB, &offset, &operation); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupPolicySession(c, 3)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if !isValidArithmeticOp(operation) {
+		return rcParam(tpm2.ErrorValue, 3)
+	}
+
+	n := t.nvIndices[c.handles[1]]
+	if !s.trial {
+		// The access checks are skipped for trial sessions so that a policy can be
+		// computed for an index that hasn't been written yet.
+		if rc := t.nvReadAccessChecks(c.handles[0], c.handles[1]); rc != tpm2.Success {
+			return rc
+		}
+	}
+	if int(offset) > int(n.public.Size) {
+		return rcParam(tpm2.ErrorValue, 2)
+	}
+	if int(n.public.Size)-int(offset) < len(operandB) {
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+
+	if !s.trial && !checkCondition(operation, n.data[offset:int(offset)+len(operandB)], operandB) {
+		return rcError(tpm2.ErrorPolicy)
+	}
+
+	s.policyUpdate(tpm2.CommandPolicyNV, computeOperandArgs(s.hashAlg, operandB, offset, operation), n.name())
+	return c.respond()
+}
+
+func (t *TPM) policyCounterTimer(c *command) tpm2.ResponseCode {
+	var operandB tpm2.Operand
+	var offset uint16
+	var operation tpm2.ArithmeticOp
+	if rc := c.unmarshal(&operandB, &offset, &operation); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupPolicySession(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if !isValidArithmeticOp(operation) {
+		return rcParam(tpm2.ErrorValue, 3)
+	}
+
+	if !s.trial {
+		info, err := mu.MarshalToBytes(tpm2.TimeInfo{Time: t.time(), ClockInfo: t.clockInfo()})
+		if err != nil {
+			return rcError(tpm2.ErrorFailure)
+		}
+		if int(offset)+len(operandB) > len(info) {
+			return rcParam(tpm2.ErrorRange, 2)
+		}
+		if !checkCondition(operation, info[offset:int(offset)+len(operandB)], operandB) {
+			return rcError(tpm2.ErrorPolicy)
+		}
+	}
+
+	s.policyUpdate(tpm2.CommandPolicyCounterTimer, computeOperandArgs(s.hashAlg, operandB, offset, operation))
+	return c.respond()
+}
+
+func (t *TPM) policyCommandCode(c *command) tpm2.ResponseCode {
+	var code tpm2.CommandCode
+	if rc := c.unmarshal(&code); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupPolicySession(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	if s.policy.commandCode != 0 && s.policy.commandCode != code {
+		return rcParam(tpm2.ErrorValue, 1)
+	}
+	if _, ok := commands[code]; !ok {
+		return rcParam(tpm2.ErrorPolicyCC, 1)
+	}
+
+	s.policy.commandCode = code
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(code))
+	s.policyUpdate(tpm2.CommandPolicyCommandCode, b)
+	return c.respond()
+}
+
+func (t *TPM) policyCpHash(c *command) tpm2.ResponseCode {
+	var cpHashA tpm2.Digest
+	if rc := c.unmarshal(&cpHashA); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupPolicySession(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	if (s.policy.cpHash != nil && !bytes.Equal(s.policy.cpHash, cpHashA)) || s.policy.nameHash != nil {
+		return rcError(tpm2.ErrorCpHash)
+	}
+	if len(cpHashA) != s.hashAlg.Size() {
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+
+	s.policy.cpHash = cpHashA
+	s.policyUpdate(tpm2.CommandPolicyCpHash, cpHashA)
+	return c.respond()
+}
+
+func (t *TPM) policyNameHash(c *command) tpm2.ResponseCode {
+	var nameHash tpm2.Digest
+	if rc := c.unmarshal(&nameHash); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupPolicySession(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	if s.policy.cpHash != nil || s.policy.nameHash != nil {
+		return rcError(tpm2.ErrorCpHash)
+	}
+	if len(nameHash) != s.hashAlg.Size() {
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+
+	s.policy.nameHash = nameHash
+	s.policyUpdate(tpm2.CommandPolicyNameHash, nameHash)
+	return c.respond()
+}
+
+func (t *TPM) policyDuplicationSelect(c *command) tpm2.ResponseCode {
+	var objectName, newParentName tpm2.Name
+	var includeObject bool
+	if rc := c.unmarshal(&objectName, &newParentName, &includeObject); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupPolicySession(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	if s.policy.cpHash != nil || s.policy.nameHash != nil {
+		return rcError(tpm2.ErrorCpHash)
+	}
+	if s.policy.commandCode != 0 && s.policy.commandCode != tpm2.CommandDuplicate {
+		return rcError(tpm2.ErrorCommandCode)
+	}
+
+	h := s.hashAlg.NewHash()
+	h.Write(objectName)
+	h.Write(newParentName)
+	s.policy.nameHash = h.Sum(nil)
+
+	var include byte
+	if includeObject {
+		include = 1
+	} else {
+		objectName = nil
+	}
+	s.policyUpdate(tpm2.CommandPolicyDuplicationSelect, objectName, newParentName, []byte{include})
+	s.policy.commandCode = tpm2.CommandDuplicate
+	return c.respond()
+}
+
+func (t *TPM) policyAuthorize(c *command) tpm2.ResponseCode {
+	var approvedPolicy tpm2.Digest
+	var policyRef tpm2.Nonce
+	var keySign tpm2.Name
+	var checkTicket tpm2.TkVerified
+	if rc := c.unmarshal(&approvedPolicy, &policyRef, &keySign, &checkTicket); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupPolicySession(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	if !s.trial {
+		if !bytes.Equal(approvedPolicy, s.policy.digest) {
+			return rcParam(tpm2.ErrorValue, 1)
+		}
+		if keySign.IsHandle() || !isSupportedHash(keySign.Algorithm()) {
+			return rcParam(tpm2.ErrorHash, 3)
+		}
+		if checkTicket.Tag != tpm2.TagVerified {
+			return rcParam(tpm2.ErrorValue, 4)
+		}
+
+		h := keySign.Algorithm().NewHash()
+		h.Write(approvedPolicy)
+		h.Write(policyRef)
+		aHash := h.Sum(nil)
+
+		if !t.isValidTicketHierarchy(checkTicket.Hierarchy) ||
+			!bytes.Equal(t.computeVerifiedTicket(checkTicket.Hierarchy, aHash, keySign), checkTicket.Digest) {
+			return rcParam(tpm2.ErrorValue, 4)
+		}
+	}
+
+	s.policy.digest = make(tpm2.Digest, s.hashAlg.Size())
+	s.policyUpdateWithRef(tpm2.CommandPolicyAuthorize, keySign, policyRef)
+	return c.respond()
+}
+
+func (t *TPM) policyAuthValue(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupPolicySession(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	s.policyUpdate(tpm2.CommandPolicyAuthValue)
+	s.policy.authValueNeeded = true
+	s.policy.passwordNeeded = false
+	return c.respond()
+}
+
+func (t *TPM) policyPassword(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupPolicySession(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	// TPM2_PolicyPassword and TPM2_PolicyAuthValue produce the same policy digest.
+	s.policyUpdate(tpm2.CommandPolicyAuthValue)
+	s.policy.passwordNeeded = true
+	s.policy.authValueNeeded = false
+	return c.respond()
+}
+
+func (t *TPM) policyGetDigest(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupPolicySession(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	return c.respond(s.policy.digest)
+}
+
+func (t *TPM) policyNvWritten(c *command) tpm2.ResponseCode {
+	var writtenSet bool
+	if rc := c.unmarshal(&writtenSet); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupPolicySession(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	if s.policy.checkNVWritten && s.policy.nvWrittenState != writtenSet {
+		return rcParam(tpm2.ErrorValue, 1)
+	}
+
+	s.policy.checkNVWritten = true
+	s.policy.nvWrittenState = writtenSet
+	var b byte
+	if writtenSet {
+		b = 1
+	}
+	s.policyUpdate(tpm2.CommandPolicyNvWritten, []byte{b})
+	return c.respond()
+}
+
+func (t *TPM) policyLocality(c *command) tpm2.ResponseCode {
+	var locality uint8
+	if rc := c.unmarshal(&locality); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupPolicySession(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	newLocality := locality
+	switch {
+	case locality == 0:
+		return rcParam(tpm2.ErrorRange, 1)
+	case s.policy.locality == 0:
+	case locality >= 32 || s.policy.locality >= 32:
+		if locality != s.policy.locality {
+			return rcParam(tpm2.ErrorRange, 1)
+		}
+	default:
+		newLocality &= s.policy.locality
+		if newLocality == 0 {
+			return rcParam(tpm2.ErrorRange, 1)
+		}
+	}
+
+	s.policy.locality = newLocality
+	s.policyUpdate(tpm2.CommandPolicyLocality, []byte{locality})
+	return c.respond()
+}
+
+func (t *TPM) policyTemplate(c *command) tpm2.ResponseCode {
+	var templateHash tpm2.Digest
+	if rc := c.unmarshal(&templateHash); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupPolicySession(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	switch {
+	case s.policy.cpHash != nil || s.policy.nameHash != nil:
+		return rcError(tpm2.ErrorCpHash)
+	case s.policy.templateHash != nil && !bytes.Equal(s.policy.templateHash, templateHash):
+		return rcParam(tpm2.ErrorValue, 1)
+	case len(templateHash) != s.hashAlg.Size():
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+
+	s.policy.templateHash = templateHash
+	s.policyUpdate(tpm2.CommandPolicyTemplate, templateHash)
+	return c.respond()
+}
+
+func (t *TPM) policyAuthorizeNV(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupPolicySession(c, 3)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	n := t.nvIndices[c.handles[1]]
+	if !s.trial {
+		if rc := t.nvReadAccessChecks(c.handles[0], c.handles[1]); rc != tpm2.Success {
+			return rc
+		}
+		if n.public.Attrs.Type() != tpm2.NVTypeOrdinary {
+			return rcHandle(tpm2.ErrorAttributes, 2)
+		}
+
+		var policy tpm2.TaggedHash
+		if _, err := mu.UnmarshalFromBytes(n.data, &policy); err != nil || policy.HashAlg != s.hashAlg {
+			return rcHandle(tpm2.ErrorHash, 2)
+		}
+		if !bytes.Equal(policy.Digest, s.policy.digest) {
+			return rcError(tpm2.ErrorValue)
+		}
+	}
+
+	s.policy.digest = make(tpm2.Digest, s.hashAlg.Size())
+	s.policyUpdate(tpm2.CommandPolicyAuthorizeNV, n.name())
+	return c.respond()
+}
@@ -0,0 +1,324 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"bytes"
+	"encoding"
+	"hash"
+
+	"github.com/canonical/go-tpm2"
+)
+
+// sequenceType describes the type of a sequence object.
+type sequenceType int
+
+const (
+	sequenceHash sequenceType = iota
+	sequenceHMAC
+	sequenceEvent
+)
+
+// sequence contains the state of a hash, HMAC or event sequence.
+type sequence struct {
+	typ        sequenceType
+	authValue  tpm2.Auth
+	hashAlg    tpm2.HashAlgorithmId // The digest algorithm for hash and HMAC sequences
+	hashes     []hash.Hash          // One for each supported algorithm for event sequences
+	hmacKey    []byte               // The padded HMAC key, used to compute the outer digest of a HMAC sequence
+	started    bool                 // Data has been added to the sequence
+	ticketable bool                 // The digest can be signed by a restricted key
+}
+
+// copy returns a copy of the sequence, including its hash state.
+func (s *sequence) copy() *sequence {
+	out := *s
+	out.hashes = nil
+	for i, h := range s.hashes {
+		alg := s.hashAlg
+		if s.typ == sequenceEvent {
+			alg = supportedHashAlgs[i]
+		}
+		state, _ := h.(encoding.BinaryMarshaler).MarshalBinary()
+		c := alg.NewHash()
+		c.(encoding.BinaryUnmarshaler).UnmarshalBinary(state)
+		out.hashes = append(out.hashes, c)
+	}
+	return &out
+}
+
+// update adds data to the sequence.
+func (s *sequence) update(data []byte) {
+	if !s.started && s.typ == sequenceHash {
+		// A digest of data that begins with TPM_GENERATED_VALUE can't be signed by a restricted key.
+		s.ticketable = len(data) < 4 || !bytes.Equal(data[:4], []byte{0xff, 'T', 'C', 'G'})
+	}
+	s.started = true
+	for _, h := range s.hashes {
+		h.Write(data)
+	}
+}
+
+// hmacPad returns the supplied HMAC key XORed with the supplied pad byte, after hashing it if it is longer than the block
+// size of the digest algorithm.
+func hmacPad(alg tpm2.HashAlgorithmId, key []byte, pad byte) []byte {
+	h := alg.NewHash()
+	if len(key) > h.BlockSize() {
+		h.Write(key)
+		key = h.Sum(nil)
+	}
+	out := make([]byte, h.BlockSize())
+	copy(out, key)
+	for i := range out {
+		out[i] ^= pad
+	}
+	return out
+}
+
+// loadSequence creates a new sequence object.
+func (t *TPM) loadSequence(c *command, s *sequence) tpm2.ResponseCode {
+	handle, rc := t.loadObject(&object{hierarchy: tpm2.HandleNull, seq: s})
+	if rc != tpm2.Success {
+		return rc
+	}
+	c.rHandle = handle
+	return c.respond()
+}
+
+// lookupSequence returns the sequence object associated with the specified command handle.
+func (t *TPM) lookupSequence(c *command, index int) (*sequence, tpm2.ResponseCode) {
+	o := t.lookupObject(c.handles[index-1])
+	if o == nil || o.seq == nil {
+		return nil, rcHandle(tpm2.ErrorMode, index)
+	}
+	return o.seq, tpm2.Success
+}
+
+func (t *TPM) hmacStart(c *command) tpm2.ResponseCode {
+	var auth tpm2.Auth
+	var hashAlg tpm2.HashAlgorithmId
+	if rc := c.unmarshal(&auth, &hashAlg); rc != tpm2.Success {
+		return rc
+	}
+
+	key := t.lookupObject(c.handles[0])
+	if key.seq != nil || key.sensitive == nil || key.public.Type != tpm2.ObjectTypeKeyedHash {
+		return rcHandle(tpm2.ErrorType, 1)
+	}
+	if key.public.Attrs&tpm2.AttrRestricted != 0 {
+		return rcHandle(tpm2.ErrorAttributes, 1)
+	}
+	if key.public.Attrs&tpm2.AttrSign == 0 {
+		return rcHandle(tpm2.ErrorKey, 1)
+	}
+
+	scheme := key.public.Params.KeyedHashDetail.Scheme
+	if scheme.Scheme == tpm2.KeyedHashSchemeHMAC {
+		switch {
+		case hashAlg == tpm2.HashAlgorithmNull:
+			hashAlg = scheme.Details.HMAC.HashAlg
+		case hashAlg != scheme.Details.HMAC.HashAlg:
+			return rcParam(tpm2.ErrorValue, 2)
+		}
+	}
+	if !isSupportedHash(hashAlg) {
+		return rcParam(tpm2.ErrorValue, 2)
+	}
+	if len(trimAuth(auth)) > hashAlg.Size() {
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+
+	inner := hashAlg.NewHash()
+	inner.Write(hmacPad(hashAlg, key.sensitive.Sensitive.Bits, 0x36))
+
+	return t.loadSequence(c, &sequence{
+		typ:       sequenceHMAC,
+		authValue: trimAuth(auth),
+		hashAlg:   hashAlg,
+		hashes:    []hash.Hash{inner},
+		hmacKey:   key.sensitive.Sensitive.Bits})
+}
+
+func (t *TPM) hashSequenceStart(c *command) tpm2.ResponseCode {
+	var auth tpm2.Auth
+	var hashAlg tpm2.HashAlgorithmId
+	if rc := c.unmarshal(&auth, &hashAlg); rc != tpm2.Success {
+		return rc
+	}
+
+	s := &sequence{authValue: trimAuth(auth), hashAlg: hashAlg}
+	if hashAlg == tpm2.HashAlgorithmNull {
+		s.typ = sequenceEvent
+		for _, alg := range supportedHashAlgs {
+			s.hashes = append(s.hashes, alg.NewHash())
+		}
+	} else {
+		if !isSupportedHash(hashAlg) {
+			return rcParam(tpm2.ErrorHash, 2)
+		}
+		s.typ = sequenceHash
+		s.hashes = []hash.Hash{hashAlg.NewHash()}
+	}
+	if len(s.authValue) > 64 {
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+
+	return t.loadSequence(c, s)
+}
+
+func (t *TPM) sequenceUpdate(c *command) tpm2.ResponseCode {
+	var buffer tpm2.MaxBuffer
+	if rc := c.unmarshal(&buffer); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupSequence(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	s.update(buffer)
+	return c.respond()
+}
+
+func (t *TPM) sequenceComplete(c *command) tpm2.ResponseCode {
+	var buffer tpm2.MaxBuffer
+	var hierarchy tpm2.Handle
+	if rc := c.unmarshal(&buffer, &hierarchy); rc != tpm2.Success {
+		return rc
+	}
+
+	s, rc := t.lookupSequence(c, 1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if s.typ == sequenceEvent {
+		return rcHandle(tpm2.ErrorMode, 1)
+	}
+	if !isHierarchy(hierarchy) {
+		return rcParam(tpm2.ErrorValue, 2)
+	}
+	if !t.hierarchyEnabled(hierarchy) {
+		return rcParam(tpm2.ErrorHierarchy, 2)
+	}
+
+	s.update(buffer)
+	result := s.hashes[0].Sum(nil)
+
+	validation := tpm2.TkHashcheck{Tag: tpm2.TagHashcheck, Hierarchy: tpm2.HandleNull}
+	switch s.typ {
+	case sequenceHMAC:
+		outer := s.hashAlg.NewHash()
+		outer.Write(hmacPad(s.hashAlg, s.hmacKey, 0x5c))
+		outer.Write(result)
+		result = outer.Sum(nil)
+	case sequenceHash:
+		if s.ticketable && hierarchy != tpm2.HandleNull {
+			validation.Hierarchy = hierarchy
+			validation.Digest = t.computeHashcheckTicket(hierarchy, s.hashAlg, result)
+		}
+	}
+
+	delete(t.objects, c.handles[0])
+	return c.respond(tpm2.Digest(result), validation)
+}
+
+func (t *TPM) eventSequenceComplete(c *command) tpm2.ResponseCode {
+	var buffer tpm2.MaxBuffer
+	if rc := c.unmarshal(&buffer); rc != tpm2.Success {
+		return rc
+	}
+
+	pcr, rc := t.checkPCRExtend(c)
+	if rc != tpm2.Success {
+		return rc
+	}
+	s, rc := t.lookupSequence(c, 2)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if s.typ != sequenceEvent {
+		return rcHandle(tpm2.ErrorMode, 2)
+	}
+
+	s.update(buffer)
+
+	var results tpm2.TaggedHashList
+	for i, alg := range supportedHashAlgs {
+		digest := s.hashes[i].Sum(nil)
+		results = append(results, tpm2.TaggedHash{HashAlg: alg, Digest: digest})
+		if pcr >= 0 {
+			t.extendPCR(pcr, alg, digest)
+		}
+	}
+
+	delete(t.objects, c.handles[1])
+	return c.respond(results)
+}
+
+func (t *TPM) hash(c *command) tpm2.ResponseCode {
+	var data tpm2.MaxBuffer
+	var hashAlg tpm2.HashAlgorithmId
+	var hierarchy tpm2.Handle
+	if rc := c.unmarshal(&data, &hashAlg, &hierarchy); rc != tpm2.Success {
+		return rc
+	}
+
+	if !isSupportedHash(hashAlg) {
+		return rcParam(tpm2.ErrorHash, 2)
+	}
+	if !isHierarchy(hierarchy) {
+		return rcParam(tpm2.ErrorValue, 3)
+	}
+	if !t.hierarchyEnabled(hierarchy) {
+		return rcParam(tpm2.ErrorHierarchy, 3)
+	}
+
+	h := hashAlg.NewHash()
+	h.Write(data)
+	outHash := h.Sum(nil)
+
+	validation := tpm2.TkHashcheck{Tag: tpm2.TagHashcheck, Hierarchy: tpm2.HandleNull}
+	if hierarchy != tpm2.HandleNull && (len(data) < 4 || !bytes.Equal(data[:4], []byte{0xff, 'T', 'C', 'G'})) {
+		validation.Hierarchy = hierarchy
+		validation.Digest = t.computeHashcheckTicket(hierarchy, hashAlg, outHash)
+	}
+
+	return c.respond(tpm2.Digest(outHash), validation)
+}
+
+func (t *TPM) hmac(c *command) tpm2.ResponseCode {
+	var buffer tpm2.MaxBuffer
+	var hashAlg tpm2.HashAlgorithmId
+	if rc := c.unmarshal(&buffer, &hashAlg); rc != tpm2.Success {
+		return rc
+	}
+
+	key := t.lookupObject(c.handles[0])
+	if key.seq != nil || key.sensitive == nil || key.public.Type != tpm2.ObjectTypeKeyedHash {
+		return rcHandle(tpm2.ErrorType, 1)
+	}
+	if key.public.Attrs&tpm2.AttrRestricted != 0 {
+		return rcHandle(tpm2.ErrorAttributes, 1)
+	}
+	if key.public.Attrs&tpm2.AttrSign == 0 {
+		return rcHandle(tpm2.ErrorKey, 1)
+	}
+
+	scheme := key.public.Params.KeyedHashDetail.Scheme
+	if scheme.Scheme == tpm2.KeyedHashSchemeHMAC {
+		switch {
+		case hashAlg == tpm2.HashAlgorithmNull:
+			hashAlg = scheme.Details.HMAC.HashAlg
+		case hashAlg != scheme.Details.HMAC.HashAlg:
+			return rcParam(tpm2.ErrorValue, 2)
+		}
+	}
+	if !isSupportedHash(hashAlg) {
+		return rcParam(tpm2.ErrorValue, 2)
+	}
+
+	return c.respond(tpm2.Digest(hmacDigest(hashAlg, key.sensitive.Sensitive.Bits, buffer)))
+}
@@ -0,0 +1,689 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"encoding/binary"
+	"hash"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/internal"
+)
+
+// sessionAttrs corresponds to the TPMA_SESSION type.
+type sessionAttrs uint8
+
+const (
+	attrContinueSession sessionAttrs = 1 << 0
+	attrAuditExclusive  sessionAttrs = 1 << 1
+	attrAuditReset      sessionAttrs = 1 << 2
+	attrReserved        sessionAttrs = 3 << 3
+	attrDecrypt         sessionAttrs = 1 << 5
+	attrEncrypt         sessionAttrs = 1 << 6
+	attrAudit           sessionAttrs = 1 << 7
+)
+
+// session contains the state of an HMAC, policy or trial session.
+type session struct {
+	handle      tpm2.Handle
+	trial       bool
+	hashAlg     tpm2.HashAlgorithmId
+	symmetric   tpm2.SymDef
+	sessionKey  []byte
+	nonceCaller tpm2.Nonce
+	nonceTPM    tpm2.Nonce
+	boundEntity tpm2.Name // For bound HMAC sessions, the bind name of the bound entity
+	loaded      bool      // false when the session has been context saved
+	contextSeq  uint64    // The sequence number of the last saved context
+	startTime   uint64    // The value of TPMS_TIME_INFO.time when the session was started
+
+	isAudit     bool
+	auditDigest tpm2.Digest
+
+	policy policyState
+}
+
+func (s *session) isPolicy() bool {
+	return s.handle.Type() == tpm2.HandleTypePolicySession
+}
+
+// commandSession describes a session in the authorization area of a command.
+type commandSession struct {
+	handle      tpm2.Handle
+	session     *session // nil for a password authorization
+	nonceCaller tpm2.Nonce
+	attrs       sessionAttrs
+	hmac        tpm2.Auth
+
+	isAuth           bool        // The session is used for authorization
+	authHandle       tpm2.Handle // The handle of the entity being authorized
+	role             authRole    // The authorization role
+	includeAuthValue bool        // The authorization value of the entity is included in the HMAC key
+}
+
+// sessionSlot returns the slot of the session associated with the supplied handle.
+func sessionSlot(h tpm2.Handle) uint32 {
+	return uint32(h & 0xffffff)
+}
+
+// lookupSession returns the session with the supplied handle, or nil if it doesn't exist. Any session can be referenced
+// using a HMAC session handle, regardless of its type.
+func (t *TPM) lookupSession(h tpm2.Handle) *session {
+	s, ok := t.sessions[sessionSlot(h)]
+	if !ok || (s.handle != h && h.Type() != tpm2.HandleTypeHMACSession) {
+		return nil
+	}
+	return s
+}
+
+// computeBindName computes the value used to identify the entity that a session is bound to.
+func computeBindName(name tpm2.Name, auth tpm2.Auth) tpm2.Name {
+	if len(auth) > len(name) {
+		auth = auth[:len(name)]
+	}
+	r := make(tpm2.Name, len(name))
+	copy(r, name)
+	j := 0
+	for i := len(name) - len(auth); i < len(name); i++ {
+		r[i] ^= auth[j]
+		j++
+	}
+	return r
+}
+
+// computeCpHash computes the command parameter digest for the supplied command and digest algorithm.
+func (t *TPM) computeCpHash(alg tpm2.HashAlgorithmId, c *command) []byte {
+	h := alg.NewHash()
+	binary.Write(h, binary.BigEndian, c.code)
+	for _, handle := range c.handles {
+		h.Write(t.entityName(handle))
+	}
+	h.Write(c.rawCpBytes)
+	return h.Sum(nil)
+}
+
+// computeRpHash computes the response parameter digest for the supplied command and digest algorithm.
+func computeRpHash(alg tpm2.HashAlgorithmId, c *command) []byte {
+	h := alg.NewHash()
+	binary.Write(h, binary.BigEndian, tpm2.Success)
+	binary.Write(h, binary.BigEndian, c.code)
+	h.Write(c.rpBytes)
+	return h.Sum(nil)
+}
+
+func computeSessionHMAC(alg tpm2.HashAlgorithmId, key, pHash []byte, nonces ...[]byte) []byte {
+	h := hmac.New(func() hash.Hash { return alg.NewHash() }, key)
+	h.Write(pHash)
+	for _, n := range nonces {
+		h.Write(n)
+	}
+	return h.Sum(nil)
+}
+
+func (t *TPM) startAuthSession(c *command) tpm2.ResponseCode {
+	var nonceCaller tpm2.Nonce
+	var encryptedSalt tpm2.EncryptedSecret
+	var sessionType tpm2.SessionType
+	var symmetric tpm2.SymDef
+	var authHash tpm2.HashAlgorithmId
+	if rc := c.unmarshal(&nonceCaller, &encryptedSalt, &sessionType, &symmetric, &authHash); rc != tpm2.Success {
+		return rc
+	}
+
+	tpmKey := c.handles[0]
+	bind := c.handles[1]
+
+	if !isSupportedHash(authHash) {
+		return rcParam(tpm2.ErrorHash, 5)
+	}
+	switch symmetric.Algorithm {
+	case tpm2.SymAlgorithmAES:
+		switch symmetric.KeyBits.Sym {
+		case 128, 192, 256:
+		default:
+			return rcParam(tpm2.ErrorKeySize, 4)
+		}
+		if symmetric.Mode.Sym != tpm2.SymModeCFB {
+			return rcParam(tpm2.ErrorMode, 4)
+		}
+	case tpm2.SymAlgorithmXOR:
+		if !isSupportedHash(symmetric.KeyBits.XOR) {
+			return rcParam(tpm2.ErrorHash, 4)
+		}
+	case tpm2.SymAlgorithmNull:
+	default:
+		return rcParam(tpm2.ErrorSymmetric, 4)
+	}
+	switch sessionType {
+	case tpm2.SessionTypeHMAC, tpm2.SessionTypePolicy, tpm2.SessionTypeTrial:
+	default:
+		return rcParam(tpm2.ErrorValue, 3)
+	}
+	if len(nonceCaller) < 16 || len(nonceCaller) > authHash.Size() {
+		return rcParam(tpm2.ErrorSize, 1)
+	}
+
+	var salt []byte
+	if tpmKey == tpm2.HandleNull {
+		if len(encryptedSalt) > 0 {
+			return rcParam(tpm2.ErrorValue, 2)
+		}
+	} else {
+		key := t.lookupObject(tpmKey)
+		if key.public == nil || key.sensitive == nil {
+			return rcHandle(tpm2.ErrorKey, 1)
+		}
+		if key.public.Attrs&tpm2.AttrDecrypt == 0 {
+			return rcHandle(tpm2.ErrorAttributes, 1)
+		}
+		var err error
+		salt, err = decryptSecret(key, []byte("SECRET"), encryptedSalt)
+		if err != nil {
+			return rcParam(tpm2.ErrorValue, 2)
+		}
+	}
+
+	slot, rc := t.allocateSessionSlot()
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	s := &session{
+		trial:       sessionType == tpm2.SessionTypeTrial,
+		hashAlg:     authHash,
+		symmetric:   symmetric,
+		nonceCaller: nonceCaller,
+		nonceTPM:    randomBytes(authHash.Size()),
+		loaded:      true,
+		startTime:   t.time()}
+	if sessionType == tpm2.SessionTypeHMAC {
+		s.handle = tpm2.Handle(tpm2.HandleTypeHMACSession)<<24 | tpm2.Handle(slot)
+	} else {
+		s.handle = tpm2.Handle(tpm2.HandleTypePolicySession)<<24 | tpm2.Handle(slot)
+	}
+
+	if tpmKey != tpm2.HandleNull || bind != tpm2.HandleNull {
+		var key []byte
+		if bind != tpm2.HandleNull {
+			key = append(key, t.entityAuthValue(bind)...)
+		}
+		key = append(key, salt...)
+		s.sessionKey = internal.KDFa(authHash.GetHash(), key, []byte("ATH"), s.nonceTPM, nonceCaller, authHash.Size()*8)
+	}
+	if bind != tpm2.HandleNull && sessionType == tpm2.SessionTypeHMAC {
+		s.boundEntity = computeBindName(t.entityName(bind), t.entityAuthValue(bind))
+	}
+	s.resetPolicy()
+
+	t.sessions[slot] = s
+
+	c.rHandle = s.handle
+	return c.respond(s.nonceTPM)
+}
+
+// allocateSessionSlot returns the lowest free session slot.
+func (t *TPM) allocateSessionSlot() (uint32, tpm2.ResponseCode) {
+	loaded := 0
+	for _, s := range t.sessions {
+		if s.loaded {
+			loaded++
+		}
+	}
+	if loaded >= maxLoadedSessions {
+		return 0, rcWarning(tpm2.WarningSessionMemory)
+	}
+	for slot := uint32(0); slot < maxActiveSessions; slot++ {
+		if _, ok := t.sessions[slot]; !ok {
+			return slot, tpm2.Success
+		}
+	}
+	return 0, rcWarning(tpm2.WarningSessionHandles)
+}
+
+func (t *TPM) policyRestart(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+	s := t.lookupSession(c.handles[0])
+	s.resetPolicy()
+	return tpm2.Success
+}
+
+// checkSessions validates the sessions in the authorization area of a command.
+func (t *TPM) checkSessions(c *command, auths []authCommand) tpm2.ResponseCode {
+	var decrypt, encrypt, audit bool
+
+	for i, auth := range auths {
+		index := i + 1
+		cs := &commandSession{
+			handle:      auth.SessionHandle,
+			nonceCaller: auth.Nonce,
+			attrs:       auth.SessionAttrs,
+			hmac:        auth.HMAC,
+			authHandle:  tpm2.HandleUnassigned}
+		if i < len(c.info.auths) {
+			cs.isAuth = true
+			cs.authHandle = c.handles[i]
+			cs.role = c.info.auths[i]
+		}
+
+		if cs.attrs&attrReserved != 0 {
+			return rcSession(tpm2.ErrorReservedBits, index)
+		}
+
+		if cs.handle == tpm2.HandlePW {
+			switch {
+			case !cs.isAuth:
+				return rcSession(tpm2.ErrorHandle, index)
+			case len(cs.nonceCaller) > 0:
+				return rcSession(tpm2.ErrorNonce, index)
+			case cs.attrs&^attrContinueSession != 0:
+				return rcSession(tpm2.ErrorAttributes, index)
+			case t.policyRequired(cs.authHandle, cs.role):
+				return rcSession(tpm2.ErrorAuthType, index)
+			case !t.authValueAvailable(c.code, cs.authHandle, cs.role):
+				return rcError(tpm2.ErrorAuthUnavailable)
+			}
+			c.sessions = append(c.sessions, cs)
+			continue
+		}
+
+		switch cs.handle.Type() {
+		case tpm2.HandleTypeHMACSession, tpm2.HandleTypePolicySession:
+		default:
+			// The reference implementation rejects this when unmarshalling the TPMI_SH_AUTH_SESSION type.
+			return rcSession(tpm2.ErrorValue, index)
+		}
+		s := t.lookupSession(cs.handle)
+		if s == nil || s.handle != cs.handle || !s.loaded {
+			return rcWarning(tpm2.WarningReferenceS0 + tpm2.WarningCode(i))
+		}
+		for _, other := range c.sessions {
+			if other.handle == cs.handle {
+				return rcSession(tpm2.ErrorHandle, index)
+			}
+		}
+		cs.session = s
+
+		if len(cs.nonceCaller) < 16 || len(cs.nonceCaller) > s.hashAlg.Size() {
+			return rcSession(tpm2.ErrorSize, index)
+		}
+
+		if cs.attrs&attrAudit == 0 && cs.attrs&(attrAuditExclusive|attrAuditReset) != 0 {
+			return rcSession(tpm2.ErrorAttributes, index)
+		}
+		if !cs.isAuth && cs.attrs&(attrDecrypt|attrEncrypt|attrAudit) == 0 {
+			return rcSession(tpm2.ErrorAttributes, index)
+		}
+		if cs.attrs&attrDecrypt != 0 {
+			if !c.info.decrypt || decrypt {
+				return rcSession(tpm2.ErrorAttributes, index)
+			}
+			if s.symmetric.Algorithm == tpm2.SymAlgorithmNull {
+				return rcSession(tpm2.ErrorSymmetric, index)
+			}
+			decrypt = true
+		}
+		if cs.attrs&attrEncrypt != 0 {
+			if !c.info.encrypt || encrypt {
+				return rcSession(tpm2.ErrorAttributes, index)
+			}
+			if s.symmetric.Algorithm == tpm2.SymAlgorithmNull {
+				return rcSession(tpm2.ErrorSymmetric, index)
+			}
+			encrypt = true
+		}
+		if cs.attrs&attrAudit != 0 {
+			if s.isPolicy() || audit {
+				return rcSession(tpm2.ErrorAttributes, index)
+			}
+			if cs.attrs&attrAuditExclusive != 0 && t.exclusiveAuditSession != s {
+				return rcError(tpm2.ErrorExclusive)
+			}
+			audit = true
+		}
+
+		if cs.isAuth {
+			if s.trial {
+				return rcSession(tpm2.ErrorAttributes, index)
+			}
+			switch {
+			case s.isPolicy():
+				if !t.authPolicyAvailable(c.code, cs.authHandle, cs.role) {
+					return rcError(tpm2.ErrorAuthUnavailable)
+				}
+			case t.policyRequired(cs.authHandle, cs.role):
+				return rcSession(tpm2.ErrorAuthType, index)
+			case !t.authValueAvailable(c.code, cs.authHandle, cs.role):
+				return rcError(tpm2.ErrorAuthUnavailable)
+			}
+		}
+
+		c.sessions = append(c.sessions, cs)
+	}
+
+	return tpm2.Success
+}
+
+// policyRequired indicates whether authorization of the supplied handle for the specified role can only be satisfied with
+// a policy session.
+func (t *TPM) policyRequired(h tpm2.Handle, role authRole) bool {
+	switch role {
+	case roleDup:
+		return true
+	case roleAdmin:
+		if o := t.lookupObject(h); o != nil && o.public != nil {
+			return o.public.Attrs&tpm2.AttrAdminWithPolicy != 0
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// isNVWriteCommand indicates whether the supplied command writes to a NV index.
+func isNVWriteCommand(code tpm2.CommandCode) bool {
+	switch code {
+	case tpm2.CommandNVWrite, tpm2.CommandNVIncrement, tpm2.CommandNVSetBits, tpm2.CommandNVExtend, tpm2.CommandNVWriteLock:
+		return true
+	default:
+		return false
+	}
+}
+
+// authValueAvailable indicates whether the supplied entity can be authorized with its authorization value for the specified
+// command and role.
+func (t *TPM) authValueAvailable(code tpm2.CommandCode, h tpm2.Handle, role authRole) bool {
+	switch h.Type() {
+	case tpm2.HandleTypePCR:
+		return true
+	case tpm2.HandleTypePermanent:
+		_, ok := t.hierarchies[h]
+		return ok || h == tpm2.HandleNull
+	case tpm2.HandleTypeTransient, tpm2.HandleTypePersistent:
+		o := t.lookupObject(h)
+		switch {
+		case o.seq != nil:
+			return true
+		case o.sensitive == nil:
+			return false
+		case o.public.Attrs&tpm2.AttrUserWithAuth != 0:
+			return true
+		default:
+			return role == roleAdmin && o.public.Attrs&tpm2.AttrAdminWithPolicy == 0
+		}
+	case tpm2.HandleTypeNVIndex:
+		attrs := t.nvIndices[h].public.Attrs
+		if isNVWriteCommand(code) {
+			return attrs&tpm2.AttrNVAuthWrite != 0
+		}
+		return attrs&tpm2.AttrNVAuthRead != 0
+	default:
+		return false
+	}
+}
+
+// authPolicyAvailable indicates whether the supplied entity can be authorized with a policy session for the specified
+// command and role.
+func (t *TPM) authPolicyAvailable(code tpm2.CommandCode, h tpm2.Handle, role authRole) bool {
+	switch h.Type() {
+	case tpm2.HandleTypePermanent:
+		hr, ok := t.hierarchies[h]
+		return ok && len(hr.authPolicy) > 0
+	case tpm2.HandleTypeTransient, tpm2.HandleTypePersistent:
+		o := t.lookupObject(h)
+		return o.seq == nil && o.sensitive != nil
+	case tpm2.HandleTypeNVIndex:
+		attrs := t.nvIndices[h].public.Attrs
+		switch {
+		case role == roleAdmin:
+			return true
+		case isNVWriteCommand(code):
+			return attrs&tpm2.AttrNVPolicyWrite != 0
+		default:
+			return attrs&tpm2.AttrNVPolicyRead != 0
+		}
+	default:
+		return false
+	}
+}
+
+// usesAuthValue indicates whether the supplied session authorizes the associated entity with its authorization value.
+func (cs *commandSession) usesAuthValue() bool {
+	switch {
+	case !cs.isAuth:
+		return false
+	case cs.session == nil:
+		return true
+	case cs.session.isPolicy():
+		return cs.session.policy.authValueNeeded || cs.session.policy.passwordNeeded
+	default:
+		return cs.includeAuthValue
+	}
+}
+
+// authorizeCommand checks the authorizations for each handle in the command that requires authorization.
+func (t *TPM) authorizeCommand(c *command) tpm2.ResponseCode {
+	var decryptSession, encryptSession int
+	for i, cs := range c.sessions {
+		if cs.attrs&attrDecrypt != 0 {
+			decryptSession = i
+		}
+		if cs.attrs&attrEncrypt != 0 {
+			encryptSession = i
+		}
+	}
+
+	for i, cs := range c.sessions {
+		if !cs.isAuth {
+			continue
+		}
+		index := i + 1
+		authValue := t.entityAuthValue(cs.authHandle)
+
+		if cs.session != nil && !cs.session.isPolicy() {
+			cs.includeAuthValue = cs.session.boundEntity == nil ||
+				!bytes.Equal(cs.session.boundEntity, computeBindName(t.entityName(cs.authHandle), authValue))
+		}
+
+		if cs.usesAuthValue() {
+			if rc := t.checkLockout(cs.authHandle); rc != tpm2.Success {
+				return rc
+			}
+		}
+
+		var ok bool
+		switch {
+		case cs.session == nil:
+			ok = bytes.Equal(trimAuth(cs.hmac), authValue)
+		case cs.session.isPolicy() && cs.session.policy.passwordNeeded:
+			if rc := t.checkPolicySession(c, cs, index); rc != tpm2.Success {
+				return rc
+			}
+			ok = bytes.Equal(trimAuth(cs.hmac), authValue)
+		default:
+			s := cs.session
+			if s.isPolicy() {
+				if rc := t.checkPolicySession(c, cs, index); rc != tpm2.Success {
+					return rc
+				}
+			}
+
+			var nonceDecrypt, nonceEncrypt tpm2.Nonce
+			if i == 0 {
+				if decryptSession > 0 {
+					nonceDecrypt = c.sessions[decryptSession].session.nonceTPM
+				}
+				if encryptSession > 0 && encryptSession != decryptSession {
+					nonceEncrypt = c.sessions[encryptSession].session.nonceTPM
+				}
+			}
+
+			key := append([]byte(nil), s.sessionKey...)
+			if cs.usesAuthValue() {
+				key = append(key, authValue...)
+			}
+			h := computeSessionHMAC(s.hashAlg, key, t.computeCpHash(s.hashAlg, c), cs.nonceCaller, s.nonceTPM,
+				nonceDecrypt, nonceEncrypt, []byte{uint8(cs.attrs)})
+			ok = hmac.Equal(h, cs.hmac) || (len(key) == 0 && len(cs.hmac) == 0)
+		}
+
+		if !ok {
+			return t.authFailure(cs, index)
+		}
+	}
+
+	return tpm2.Success
+}
+
+// computeSessionValue returns the key used for parameter encryption with the supplied session.
+func (t *TPM) computeSessionValue(cs *commandSession) []byte {
+	key := append([]byte(nil), cs.session.sessionKey...)
+	if cs.isAuth {
+		key = append(key, t.entityAuthValue(cs.authHandle)...)
+	}
+	return key
+}
+
+// cryptParameter encrypts or decrypts the first parameter in the supplied buffer using the parameters of the supplied
+// session.
+func (t *TPM) cryptParameter(cs *commandSession, params []byte, nonceNewer, nonceOlder tpm2.Nonce, encrypt bool) bool {
+	if len(params) < 2 {
+		return false
+	}
+	size := int(binary.BigEndian.Uint16(params))
+	if size > len(params)-2 {
+		return false
+	}
+	data := params[2 : size+2]
+
+	s := cs.session
+	key := t.computeSessionValue(cs)
+
+	switch s.symmetric.Algorithm {
+	case tpm2.SymAlgorithmAES:
+		k := internal.KDFa(s.hashAlg.GetHash(), key, []byte("CFB"), nonceNewer, nonceOlder, int(s.symmetric.KeyBits.Sym)+(aes.BlockSize*8))
+		offset := (s.symmetric.KeyBits.Sym + 7) / 8
+		block, err := aes.NewCipher(k[:offset])
+		if err != nil {
+			return false
+		}
+		if encrypt {
+			cipher.NewCFBEncrypter(block, k[offset:]).XORKeyStream(data, data)
+		} else {
+			cipher.NewCFBDecrypter(block, k[offset:]).XORKeyStream(data, data)
+		}
+	case tpm2.SymAlgorithmXOR:
+		internal.XORObfuscation(s.hashAlg.GetHash(), key, nonceNewer, nonceOlder, data)
+	default:
+		return false
+	}
+	return true
+}
+
+// decryptCommandParameter decrypts the first command parameter if there is a session with the decrypt attribute set.
+func (t *TPM) decryptCommandParameter(c *command) tpm2.ResponseCode {
+	for _, cs := range c.sessions {
+		if cs.attrs&attrDecrypt == 0 {
+			continue
+		}
+		if !t.cryptParameter(cs, c.cpBytes, cs.nonceCaller, cs.session.nonceTPM, false) {
+			return rcError(tpm2.ErrorSize)
+		}
+	}
+	return tpm2.Success
+}
+
+// completeSessions updates the state of the sessions used by a command that completed successfully, encrypts the first
+// response parameter if required and returns the response authorization area.
+func (t *TPM) completeSessions(c *command) []authResponse {
+	for _, cs := range c.sessions {
+		if cs.session == nil {
+			continue
+		}
+		cs.session.nonceCaller = cs.nonceCaller
+		cs.session.nonceTPM = randomBytes(cs.session.hashAlg.Size())
+	}
+
+	for _, cs := range c.sessions {
+		if cs.attrs&attrEncrypt == 0 {
+			continue
+		}
+		t.cryptParameter(cs, c.rpBytes, cs.session.nonceTPM, cs.session.nonceCaller, true)
+	}
+
+	t.updateAuditSessionStatus(c)
+
+	var out []authResponse
+	for _, cs := range c.sessions {
+		if cs.session == nil {
+			out = append(out, authResponse{SessionAttrs: attrContinueSession})
+			continue
+		}
+
+		s := cs.session
+		attrs := cs.attrs &^ (attrAuditReset | attrAuditExclusive)
+		if cs.attrs&attrAudit != 0 && t.exclusiveAuditSession == s {
+			attrs |= attrAuditExclusive
+		}
+
+		rsp := authResponse{Nonce: s.nonceTPM, SessionAttrs: attrs}
+		if !s.isPolicy() || !s.policy.passwordNeeded || !cs.isAuth {
+			key := append([]byte(nil), s.sessionKey...)
+			if cs.usesAuthValue() {
+				key = append(key, t.entityAuthValue(cs.authHandle)...)
+			}
+			rsp.HMAC = computeSessionHMAC(s.hashAlg, key, computeRpHash(s.hashAlg, c), s.nonceTPM, s.nonceCaller,
+				[]byte{uint8(attrs)})
+		}
+		out = append(out, rsp)
+
+		if cs.isAuth && s.isPolicy() {
+			s.resetPolicy()
+		}
+		if cs.attrs&attrContinueSession == 0 {
+			t.flushSession(s)
+		}
+	}
+
+	return out
+}
+
+// updateAuditSessionStatus extends the cpHash and rpHash of the current command in to the digest of the audit session
+// if there is one, and updates the exclusive audit session.
+func (t *TPM) updateAuditSessionStatus(c *command) {
+	var audit *session
+	for _, cs := range c.sessions {
+		if cs.attrs&attrAudit == 0 {
+			continue
+		}
+		audit = cs.session
+		if !audit.isAudit || cs.attrs&attrAuditReset != 0 {
+			audit.isAudit = true
+			audit.auditDigest = make(tpm2.Digest, audit.hashAlg.Size())
+			t.exclusiveAuditSession = audit
+		}
+		h := audit.hashAlg.NewHash()
+		h.Write(audit.auditDigest)
+		h.Write(t.computeCpHash(audit.hashAlg, c))
+		h.Write(computeRpHash(audit.hashAlg, c))
+		audit.auditDigest = h.Sum(nil)
+	}
+	if audit != t.exclusiveAuditSession {
+		t.exclusiveAuditSession = nil
+	}
+}
+
+// flushSession removes the supplied session from the TPM.
+func (t *TPM) flushSession(s *session) {
+	delete(t.sessions, sessionSlot(s.handle))
+	if t.exclusiveAuditSession == s {
+		t.exclusiveAuditSession = nil
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"github.com/canonical/go-tpm2"
+)
+
+func (t *TPM) signCommand(c *command) tpm2.ResponseCode {
+	var digest tpm2.Digest
+	var inScheme tpm2.SigScheme
+	var validation tpm2.TkHashcheck
+	if rc := c.unmarshal(&digest, &inScheme, &validation); rc != tpm2.Success {
+		return rc
+	}
+
+	key := t.lookupObject(c.handles[0])
+	if key.seq != nil || key.sensitive == nil || key.public.Attrs&tpm2.AttrSign == 0 {
+		return rcHandle(tpm2.ErrorKey, 1)
+	}
+	scheme, ok := selectSignScheme(key.public, &inScheme)
+	if !ok {
+		return rcParam(tpm2.ErrorScheme, 2)
+	}
+	if rc := t.checkSigningDigest(key, scheme, digest, &validation, 1, 3); rc != tpm2.Success {
+		return rc
+	}
+
+	sig, rc := t.sign(key, scheme, digest, 2)
+	if rc != tpm2.Success {
+		return rc
+	}
+	return c.respond(sig)
+}
+
+func (t *TPM) verifySignatureCommand(c *command) tpm2.ResponseCode {
+	var digest tpm2.Digest
+	var signature tpm2.Signature
+	if rc := c.unmarshal(&digest, &signature); rc != tpm2.Success {
+		return rc
+	}
+
+	key := t.lookupObject(c.handles[0])
+	if key.seq != nil || key.public.Attrs&tpm2.AttrSign == 0 {
+		return rcHandle(tpm2.ErrorAttributes, 1)
+	}
+	if rc := verifySignature(key, digest, &signature, 2); rc != tpm2.Success {
+		return rc
+	}
+
+	validation := tpm2.TkVerified{Tag: tpm2.TagVerified, Hierarchy: key.hierarchy}
+	if key.hierarchy != tpm2.HandleNull {
+		validation.Digest = t.computeVerifiedTicket(key.hierarchy, digest, key.name)
+	}
+	return c.respond(validation)
+}
@@ -0,0 +1,164 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm
+
+import (
+	"github.com/canonical/go-tpm2"
+)
+
+// maxSavedObjects is the maximum number of saved object contexts that the TPM keeps track of. The oldest contexts are
+// discarded once this limit is reached, after which they can no longer be loaded.
+const maxSavedObjects = 4096
+
+func (t *TPM) startup(c *command) tpm2.ResponseCode {
+	var startupType tpm2.StartupType
+	if rc := c.unmarshal(&startupType); rc != tpm2.Success {
+		return rc
+	}
+
+	stateSaved := t.shutdownType != nil && *t.shutdownType == tpm2.StartupState
+
+	switch startupType {
+	case tpm2.StartupClear:
+		if stateSaved {
+			// TPM Restart
+			t.restartCount++
+		} else {
+			// TPM Reset
+			t.resetCount++
+			t.restartCount = 0
+			t.nullSeed = randomBytes(64)
+			t.nullProof = randomBytes(64)
+			t.savedObjects = make(map[string]*object)
+			t.savedObjectIds = nil
+			t.commitNonce = randomBytes(32)
+			t.commitCounter = 0
+			t.commits = make(map[uint16]struct{})
+		}
+		t.initPCRs(nil)
+		t.shEnable = true
+		t.ehEnable = true
+		t.phEnableNV = true
+	case tpm2.StartupState:
+		if !stateSaved {
+			return rcParam(tpm2.ErrorValue, 1)
+		}
+		// TPM Resume
+		t.restartCount++
+		t.initPCRs(t.savedPCRs)
+	default:
+		return rcParam(tpm2.ErrorValue, 1)
+	}
+
+	t.phEnable = true
+	t.nvStartup(startupType == tpm2.StartupClear)
+	t.savedPCRs = nil
+	t.shutdownType = nil
+	t.started = true
+	return c.respond()
+}
+
+func (t *TPM) shutdown(c *command) tpm2.ResponseCode {
+	var shutdownType tpm2.StartupType
+	if rc := c.unmarshal(&shutdownType); rc != tpm2.Success {
+		return rc
+	}
+
+	switch shutdownType {
+	case tpm2.StartupClear:
+		t.savedPCRs = nil
+	case tpm2.StartupState:
+		t.savedPCRs = t.savePCRs()
+	default:
+		return rcParam(tpm2.ErrorValue, 1)
+	}
+
+	t.shutdownType = &shutdownType
+	return c.respond()
+}
+
+func (t *TPM) selfTest(c *command) tpm2.ResponseCode {
+	var fullTest bool
+	if rc := c.unmarshal(&fullTest); rc != tpm2.Success {
+		return rc
+	}
+	return c.respond()
+}
+
+func (t *TPM) incrementalSelfTest(c *command) tpm2.ResponseCode {
+	var toTest tpm2.AlgorithmList
+	if rc := c.unmarshal(&toTest); rc != tpm2.Success {
+		return rc
+	}
+	// All algorithms are always tested.
+	return c.respond(tpm2.AlgorithmList{})
+}
+
+func (t *TPM) getTestResult(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+	return c.respond(tpm2.MaxBuffer{}, tpm2.Success)
+}
+
+func (t *TPM) getRandom(c *command) tpm2.ResponseCode {
+	var bytesRequested uint16
+	if rc := c.unmarshal(&bytesRequested); rc != tpm2.Success {
+		return rc
+	}
+	if bytesRequested > 64 {
+		bytesRequested = 64
+	}
+	return c.respond(tpm2.Digest(randomBytes(int(bytesRequested))))
+}
+
+func (t *TPM) stirRandom(c *command) tpm2.ResponseCode {
+	var inData tpm2.SensitiveData
+	if rc := c.unmarshal(&inData); rc != tpm2.Success {
+		return rc
+	}
+	// The random number generator is the one provided by the operating system, and there is nothing to stir.
+	return c.respond()
+}
+
+func (t *TPM) readClock(c *command) tpm2.ResponseCode {
+	if rc := c.unmarshal(); rc != tpm2.Success {
+		return rc
+	}
+	return c.respond(tpm2.TimeInfo{Time: t.time(), ClockInfo: t.clockInfo()})
+}
+
+func (t *TPM) clockSet(c *command) tpm2.ResponseCode {
+	var newTime uint64
+	if rc := c.unmarshal(&newTime); rc != tpm2.Success {
+		return rc
+	}
+
+	if c.handles[0] != tpm2.HandleOwner && c.handles[0] != tpm2.HandlePlatform {
+		return rcHandle(tpm2.ErrorHierarchy, 1)
+	}
+	current := t.clock()
+	if newTime < current || newTime > 0xffff000000000000 {
+		return rcParam(tpm2.ErrorValue, 1)
+	}
+	t.clockAtPower += newTime - current
+	return c.respond()
+}
+
+func (t *TPM) clockRateAdjust(c *command) tpm2.ResponseCode {
+	var rateAdjust int8
+	if rc := c.unmarshal(&rateAdjust); rc != tpm2.Success {
+		return rc
+	}
+
+	if c.handles[0] != tpm2.HandleOwner && c.handles[0] != tpm2.HandlePlatform {
+		return rcHandle(tpm2.ErrorHierarchy, 1)
+	}
+	if rateAdjust < -3 || rateAdjust > 3 {
+		return rcParam(tpm2.ErrorValue, 1)
+	}
+	// The clock always runs at the rate of the host clock.
+	return c.respond()
+}
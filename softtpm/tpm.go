@@ -0,0 +1,500 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+/*
+Package softtpm implements a TPM 2.0 device in software, so that packages which use go-tpm2 can be tested without having to launch
+the TPM2 reference simulator.
+
+The TPM is implemented entirely in Go and executes commands in-process. It implements tpm2.TCTI, so it can be passed directly to
+tpm2.NewTPMContext:
+
+	 tpm, _ := tpm2.NewTPMContext(softtpm.New())
+	 if err := tpm.Startup(tpm2.StartupClear); err != nil {
+		...
+	 }
+
+It implements the commands that are required for startup, capability queries, random number generation, PCRs, HMAC and policy
+sessions, parameter encryption, the creation and loading of RSA, ECC, keyedhash and symmetric objects, signing and signature
+verification, sealing, duplication, attestation, hash and HMAC sequences, NV indices, context management, dictionary attack
+protection and hierarchy management. Other commands fail with a TPM_RC_COMMAND_CODE error.
+
+It aims to behave like the reference implementation closely enough to run the go-tpm2 test suites, but it is not intended to be
+a complete or conformant implementation of the TPM Library Specification. It provides no protection for secrets, which are held
+in ordinary memory, and all state is lost when the process exits. It must not be used outside of tests.
+*/
+package softtpm
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	maxCommandSize    = 4096
+	maxResponseSize   = 4096
+	maxDigestBuffer   = 1024
+	maxNVBuffer       = 1024
+	maxNVIndexSize    = 2048
+	maxCapBuffer      = 1024
+	maxLoadedObjects  = 16
+	maxLoadedSessions = 16
+	maxActiveSessions = 64
+	maxNVIndices      = 64
+	maxPersistent     = 16
+	maxNVSpace        = 16384
+	numPCRs           = 24
+	maxPCRReadDigests = 8
+	contextGapMax     = 0xffff
+
+	firmwareVersion1 = 0x20210101
+	firmwareVersion2 = 0x00000001
+)
+
+var (
+	// supportedHashAlgs is the set of hash algorithms supported by the TPM.
+	supportedHashAlgs = []tpm2.HashAlgorithmId{tpm2.HashAlgorithmSHA1, tpm2.HashAlgorithmSHA256, tpm2.HashAlgorithmSHA384, tpm2.HashAlgorithmSHA512}
+
+	// pcrBanks is the set of PCR banks allocated by the TPM.
+	pcrBanks = []tpm2.HashAlgorithmId{tpm2.HashAlgorithmSHA1, tpm2.HashAlgorithmSHA256}
+)
+
+type commandHeader struct {
+	Tag         tpm2.StructTag
+	CommandSize uint32
+	CommandCode tpm2.CommandCode
+}
+
+type responseHeader struct {
+	Tag          tpm2.StructTag
+	ResponseSize uint32
+	ResponseCode tpm2.ResponseCode
+}
+
+type authCommand struct {
+	SessionHandle tpm2.Handle
+	Nonce         tpm2.Nonce
+	SessionAttrs  sessionAttrs
+	HMAC          tpm2.Auth
+}
+
+type authResponse struct {
+	Nonce        tpm2.Nonce
+	SessionAttrs sessionAttrs
+	HMAC         tpm2.Auth
+}
+
+// authRole describes the authorization role required for a handle.
+type authRole int
+
+const (
+	roleUser authRole = iota
+	roleAdmin
+	roleDup
+)
+
+// commandFunc is the implementation of a command. It returns the response code for the command.
+type commandFunc func(t *TPM, c *command) tpm2.ResponseCode
+
+// commandInfo describes a command implemented by the TPM.
+type commandInfo struct {
+	handles int                    // The number of handles in the command handle area
+	auths   []authRole             // The authorization role for each handle that requires authorization, which are always the first handles
+	decrypt bool                   // The first command parameter is a sized buffer and may be encrypted
+	encrypt bool                   // The first response parameter is a sized buffer and may be encrypted
+	rHandle bool                   // The response contains a handle
+	attrs   tpm2.CommandAttributes // Other TPMA_CC attributes, reported by TPM2_GetCapability
+	fn      commandFunc
+}
+
+// command contains the state for a command during its execution.
+type command struct {
+	code     tpm2.CommandCode
+	info     *commandInfo
+	locality uint8
+	handles  []tpm2.Handle
+	sessions []*commandSession
+
+	cpBytes    []byte // The command parameters, decrypted if parameter encryption is in use
+	rawCpBytes []byte // The command parameters as they were supplied
+
+	params     *bytes.Reader
+	paramIndex int
+
+	rHandle tpm2.Handle
+	rpBytes []byte
+}
+
+// unmarshal unmarshals the command parameters in to the supplied values. It must be called once by every command
+// implementation, and returns an error if the parameter area is malformed or contains trailing bytes.
+func (c *command) unmarshal(vals ...interface{}) tpm2.ResponseCode {
+	for _, v := range vals {
+		c.paramIndex++
+		if _, err := mu.UnmarshalFromReader(c.params, v); err != nil {
+			return rcParam(unmarshalErrorCode(err), c.paramIndex)
+		}
+	}
+	if c.params.Len() > 0 {
+		return rcError(tpm2.ErrorSize)
+	}
+	return tpm2.Success
+}
+
+// respond marshals the supplied response parameters.
+func (c *command) respond(vals ...interface{}) tpm2.ResponseCode {
+	b, err := mu.MarshalToBytes(vals...)
+	if err != nil {
+		return rcError(tpm2.ErrorFailure)
+	}
+	c.rpBytes = b
+	return tpm2.Success
+}
+
+// unmarshalErrorCode returns an appropriate error code for the supplied unmarshalling error.
+func unmarshalErrorCode(err error) tpm2.ErrorCode {
+	var s *mu.InvalidSelectorError
+	switch {
+	case xerrors.Is(err, io.EOF) || xerrors.Is(err, io.ErrUnexpectedEOF):
+		return tpm2.ErrorInsufficient
+	case xerrors.As(err, &s):
+		switch s.Selector.Interface().(type) {
+		case tpm2.SymAlgorithmId, tpm2.SymObjectAlgorithmId:
+			return tpm2.ErrorSymmetric
+		case tpm2.HashAlgorithmId:
+			return tpm2.ErrorHash
+		case tpm2.ObjectTypeId:
+			return tpm2.ErrorType
+		case tpm2.KDFAlgorithmId:
+			return tpm2.ErrorKDF
+		case tpm2.SigSchemeId, tpm2.AsymSchemeId, tpm2.RSASchemeId, tpm2.ECCSchemeId, tpm2.KeyedHashSchemeId:
+			return tpm2.ErrorScheme
+		case tpm2.StructTag:
+			return tpm2.ErrorTag
+		default:
+			return tpm2.ErrorValue
+		}
+	default:
+		return tpm2.ErrorSize
+	}
+}
+
+// TPM is a TPM 2.0 device implemented in software. It implements tpm2.TCTI, and is safe to use from multiple goroutines.
+//
+// A new TPM is in the state that a physical TPM is in after _TPM_Init, and it must be started with TPM2_Startup before it will
+// execute any other command.
+type TPM struct {
+	mu sync.Mutex
+
+	// TCTI state
+	locality uint8
+	rsp      *bytes.Reader
+
+	// Volatile state
+	started               bool
+	objects               map[tpm2.Handle]*object
+	sessions              map[uint32]*session // indexed by session slot
+	exclusiveAuditSession *session
+	pcrs                  map[tpm2.HashAlgorithmId][]tpm2.Digest
+	pcrCounter            uint32
+	phEnable              bool
+	shEnable              bool
+	ehEnable              bool
+	phEnableNV            bool
+	powerOnTime           time.Time
+	clockAtPower          uint64
+	nullSeed              []byte
+	nullProof             []byte
+	contextKey            []byte // Used to protect saved session contexts
+	commitNonce           []byte // Used to derive the ephemeral values for TPM2_Commit
+	commitCounter         uint16
+	commits               map[uint16]struct{} // Outstanding TPM2_Commit values, indexed by counter
+
+	// Persistent state
+	shutdownType    *tpm2.StartupType
+	hierarchies     map[tpm2.Handle]*hierarchy
+	persistent      map[tpm2.Handle]*object
+	nvIndices       map[tpm2.Handle]*nvIndex
+	disableClear    bool
+	resetCount      uint32
+	restartCount    uint32
+	clockBase       uint64
+	contextSequence uint64
+	savedObjects    map[string]*object // Saved object contexts, indexed by the identifier in the context blob
+	savedObjectIds  []string           // The identifiers of saved object contexts, oldest first
+	savedPCRs       map[tpm2.HashAlgorithmId][]tpm2.Digest
+	da              daState
+	auditCommands   map[tpm2.CommandCode]struct{}
+	auditHashAlg    tpm2.HashAlgorithmId
+	auditDigest     tpm2.Digest
+	auditCounter    uint64
+	maxCounter      uint64
+	rsaKeyCache     map[string]*rsa.PrivateKey
+}
+
+// New returns a new software TPM. The TPM is manufactured with new primary seeds, and must be started with TPM2_Startup.
+func New() *TPM {
+	t := &TPM{
+		hierarchies:   make(map[tpm2.Handle]*hierarchy),
+		persistent:    make(map[tpm2.Handle]*object),
+		nvIndices:     make(map[tpm2.Handle]*nvIndex),
+		savedObjects:  make(map[string]*object),
+		auditCommands: make(map[tpm2.CommandCode]struct{}),
+		auditHashAlg:  tpm2.HashAlgorithmSHA256,
+		rsaKeyCache:   make(map[string]*rsa.PrivateKey),
+		da: daState{
+			maxTries:        3,
+			recoveryTime:    1000,
+			lockoutRecovery: 1000,
+			lockoutAuthOK:   true}}
+	for _, h := range []tpm2.Handle{tpm2.HandleOwner, tpm2.HandleEndorsement, tpm2.HandlePlatform, tpm2.HandleLockout} {
+		t.hierarchies[h] = newHierarchy(h)
+	}
+	t.auditCommands[tpm2.CommandSetCommandCodeAuditStatus] = struct{}{}
+	t.powerOn()
+	return t
+}
+
+// Reset simulates a power cycle of the TPM, after which it must be started again with TPM2_Startup. All transient objects and
+// sessions are lost. A TPM2_Shutdown command should be executed before calling this in order to preserve state that is
+// only saved on an orderly shutdown.
+func (t *TPM) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clockBase = t.clock()
+	t.powerOn()
+}
+
+func (t *TPM) powerOn() {
+	t.started = false
+	t.objects = make(map[tpm2.Handle]*object)
+	t.sessions = make(map[uint32]*session)
+	t.exclusiveAuditSession = nil
+	t.contextKey = randomBytes(32)
+	t.powerOnTime = time.Now()
+	t.clockAtPower = t.clockBase
+	t.rsp = nil
+}
+
+// clock returns the current value of TPMS_CLOCK_INFO.clock in milliseconds.
+func (t *TPM) clock() uint64 {
+	return t.clockAtPower + uint64(time.Since(t.powerOnTime)/time.Millisecond)
+}
+
+// time returns the number of milliseconds since the TPM was last powered on.
+func (t *TPM) time() uint64 {
+	return uint64(time.Since(t.powerOnTime) / time.Millisecond)
+}
+
+func (t *TPM) clockInfo() tpm2.ClockInfo {
+	return tpm2.ClockInfo{
+		Clock:        t.clock(),
+		ResetCount:   t.resetCount,
+		RestartCount: t.restartCount,
+		Safe:         true}
+}
+
+// ExecuteCommand executes the supplied command at the specified locality, and returns the response. The command must be a
+// single, complete command packet.
+func (t *TPM) ExecuteCommand(locality uint8, cmd []byte) []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.executeCommand(locality, cmd)
+}
+
+// Read implements tpm2.TCTI.Read. It returns the response to the last command that was written.
+func (t *TPM) Read(data []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.rsp == nil {
+		return 0, io.EOF
+	}
+	return t.rsp.Read(data)
+}
+
+// Write implements tpm2.TCTI.Write. The supplied data must be a single complete command, which is executed immediately.
+func (t *TPM) Write(data []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rsp = bytes.NewReader(t.executeCommand(t.locality, data))
+	return len(data), nil
+}
+
+// Close implements tpm2.TCTI.Close. It does nothing, and the TPM retains its state.
+func (t *TPM) Close() error {
+	return nil
+}
+
+// SetLocality implements tpm2.TCTI.SetLocality.
+func (t *TPM) SetLocality(locality uint8) error {
+	if locality > 4 {
+		return errors.New("invalid locality")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.locality = locality
+	return nil
+}
+
+// MakeSticky implements tpm2.TCTI.MakeSticky. It is not implemented because the TPM has no resource manager.
+func (t *TPM) MakeSticky(handle tpm2.Handle, sticky bool) error {
+	return errors.New("not implemented")
+}
+
+func makeErrorResponse(rc tpm2.ResponseCode) []byte {
+	b, _ := mu.MarshalToBytes(responseHeader{Tag: tpm2.TagNoSessions, ResponseSize: 10, ResponseCode: rc})
+	return b
+}
+
+func (t *TPM) executeCommand(locality uint8, b []byte) []byte {
+	if len(b) < binary.Size(commandHeader{}) || len(b) > maxCommandSize {
+		return makeErrorResponse(rcError(tpm2.ErrorCommandSize))
+	}
+
+	r := bytes.NewReader(b)
+	var hdr commandHeader
+	if _, err := mu.UnmarshalFromReader(r, &hdr); err != nil {
+		return makeErrorResponse(rcError(tpm2.ErrorCommandSize))
+	}
+	switch {
+	case hdr.Tag != tpm2.TagNoSessions && hdr.Tag != tpm2.TagSessions:
+		return makeErrorResponse(rcBadTag)
+	case int(hdr.CommandSize) != len(b):
+		return makeErrorResponse(rcError(tpm2.ErrorCommandSize))
+	}
+
+	info, ok := commands[hdr.CommandCode]
+	if !ok {
+		return makeErrorResponse(rcError(tpm2.ErrorCommandCode))
+	}
+	if t.started == (hdr.CommandCode == tpm2.CommandStartup) {
+		return makeErrorResponse(rcError(tpm2.ErrorInitialize))
+	}
+
+	c := &command{code: hdr.CommandCode, info: info, locality: locality}
+
+	for i := 0; i < info.handles; i++ {
+		var h tpm2.Handle
+		if _, err := mu.UnmarshalFromReader(r, &h); err != nil {
+			return makeErrorResponse(rcError(tpm2.ErrorInsufficient))
+		}
+		c.handles = append(c.handles, h)
+	}
+
+	var auths []authCommand
+	if hdr.Tag == tpm2.TagSessions {
+		switch hdr.CommandCode {
+		case tpm2.CommandStartup, tpm2.CommandContextLoad, tpm2.CommandContextSave, tpm2.CommandFlushContext:
+			return makeErrorResponse(rcError(tpm2.ErrorAuthContext))
+		}
+
+		var authSize uint32
+		if _, err := mu.UnmarshalFromReader(r, &authSize); err != nil || authSize < 9 || int(authSize) > r.Len() {
+			return makeErrorResponse(rcError(tpm2.ErrorAuthsize))
+		}
+		authArea := make([]byte, authSize)
+		r.Read(authArea)
+		ar := bytes.NewReader(authArea)
+		for ar.Len() > 0 {
+			if len(auths) == 3 {
+				return makeErrorResponse(rcError(tpm2.ErrorAuthsize))
+			}
+			var auth authCommand
+			if _, err := mu.UnmarshalFromReader(ar, &auth); err != nil {
+				return makeErrorResponse(rcError(tpm2.ErrorAuthsize))
+			}
+			auths = append(auths, auth)
+		}
+	}
+	if len(auths) < len(info.auths) {
+		return makeErrorResponse(rcError(tpm2.ErrorAuthMissing))
+	}
+
+	c.cpBytes = make([]byte, r.Len())
+	r.Read(c.cpBytes)
+	c.rawCpBytes = append([]byte(nil), c.cpBytes...)
+
+	for i, h := range c.handles {
+		if rc := t.checkHandle(h, i+1); rc != tpm2.Success {
+			return makeErrorResponse(rc)
+		}
+	}
+
+	if rc := t.checkSessions(c, auths); rc != tpm2.Success {
+		return makeErrorResponse(rc)
+	}
+	if rc := t.authorizeCommand(c); rc != tpm2.Success {
+		return makeErrorResponse(rc)
+	}
+	if rc := t.decryptCommandParameter(c); rc != tpm2.Success {
+		return makeErrorResponse(rc)
+	}
+
+	c.params = bytes.NewReader(c.cpBytes)
+	if rc := info.fn(t, c); rc != tpm2.Success {
+		return makeErrorResponse(rc)
+	}
+
+	rsp, err := t.buildResponse(c, hdr.Tag)
+	if err != nil {
+		return makeErrorResponse(rcError(tpm2.ErrorFailure))
+	}
+	return rsp
+}
+
+func (t *TPM) buildResponse(c *command, tag tpm2.StructTag) ([]byte, error) {
+	authArea := t.completeSessions(c)
+	t.updateCommandAudit(c)
+
+	var handleArea []byte
+	if c.info.rHandle {
+		handleArea, _ = mu.MarshalToBytes(c.rHandle)
+	}
+
+	var body []byte
+	if tag == tpm2.TagSessions {
+		var err error
+		body, err = mu.MarshalToBytes(mu.RawBytes(handleArea), uint32(len(c.rpBytes)), mu.RawBytes(c.rpBytes))
+		if err != nil {
+			return nil, err
+		}
+		for _, auth := range authArea {
+			b, err := mu.MarshalToBytes(auth)
+			if err != nil {
+				return nil, err
+			}
+			body = append(body, b...)
+		}
+	} else {
+		body = append(handleArea, c.rpBytes...)
+	}
+
+	hdr := responseHeader{Tag: tag, ResponseSize: uint32(binary.Size(responseHeader{}) + len(body)), ResponseCode: tpm2.Success}
+	if hdr.ResponseSize > maxResponseSize {
+		return makeErrorResponse(rcError(tpm2.ErrorFailure)), nil
+	}
+	return mu.MarshalToBytes(hdr, mu.RawBytes(body))
+}
+
+// readRandom fills the supplied buffer with random bytes.
+func readRandom(b []byte) {
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err)
+	}
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	readRandom(b)
+	return b
+}
@@ -0,0 +1,129 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package softtpm_test
+
+import (
+	"crypto"
+	_ "crypto/sha256"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/go-tpm2"
+	. "github.com/canonical/go-tpm2/softtpm"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+func init() {
+	testutil.AddCommandLineFlags()
+}
+
+func Test(t *testing.T) { TestingT(t) }
+
+type tpmSuite struct {
+	dev *TPM
+	tpm *tpm2.TPMContext
+}
+
+func (s *tpmSuite) SetUpTest(c *C) {
+	s.dev = New()
+	tpm, err := tpm2.NewTPMContext(s.dev)
+	c.Assert(err, IsNil)
+	s.tpm = tpm
+	c.Check(s.tpm.Startup(tpm2.StartupClear), IsNil)
+}
+
+var _ = Suite(&tpmSuite{})
+
+func (s *tpmSuite) storageKeyTemplate() *tpm2.Public {
+	return &tpm2.Public{
+		Type:    tpm2.ObjectTypeRSA,
+		NameAlg: tpm2.HashAlgorithmSHA256,
+		Attrs: tpm2.AttrFixedTPM | tpm2.AttrFixedParent | tpm2.AttrSensitiveDataOrigin | tpm2.AttrUserWithAuth |
+			tpm2.AttrRestricted | tpm2.AttrDecrypt,
+		Params: &tpm2.PublicParamsU{
+			RSADetail: &tpm2.RSAParams{
+				Symmetric: tpm2.SymDefObject{
+					Algorithm: tpm2.SymObjectAlgorithmAES,
+					KeyBits:   &tpm2.SymKeyBitsU{Sym: 128},
+					Mode:      &tpm2.SymModeU{Sym: tpm2.SymModeCFB}},
+				Scheme:   tpm2.RSAScheme{Scheme: tpm2.RSASchemeNull},
+				KeyBits:  2048,
+				Exponent: 0}}}
+}
+
+func (s *tpmSuite) TestCommandBeforeStartup(c *C) {
+	tpm, err := tpm2.NewTPMContext(New())
+	c.Assert(err, IsNil)
+	_, err = tpm.GetRandom(8)
+	c.Check(tpm2.IsTPMError(err, tpm2.ErrorInitialize, tpm2.AnyCommandCode), Equals, true)
+}
+
+func (s *tpmSuite) TestGetRandom(c *C) {
+	b, err := s.tpm.GetRandom(32)
+	c.Check(err, IsNil)
+	c.Check(b, HasLen, 32)
+}
+
+func (s *tpmSuite) TestPCRExtend(c *C) {
+	digest := make(tpm2.Digest, 32)
+	digest[0] = 1
+	c.Check(s.tpm.PCRExtend(s.tpm.PCRHandleContext(7), tpm2.TaggedHashList{{HashAlg: tpm2.HashAlgorithmSHA256, Digest: digest}}, nil), IsNil)
+
+	h := crypto.SHA256.New()
+	h.Write(make([]byte, 32))
+	h.Write(digest)
+
+	_, values, err := s.tpm.PCRRead(tpm2.PCRSelectionList{{Hash: tpm2.HashAlgorithmSHA256, Select: []int{7}}})
+	c.Check(err, IsNil)
+	c.Check(values[tpm2.HashAlgorithmSHA256][7], DeepEquals, tpm2.Digest(h.Sum(nil)))
+}
+
+func (s *tpmSuite) TestSealAndUnseal(c *C) {
+	srk, _, _, _, _, err := s.tpm.CreatePrimary(s.tpm.OwnerHandleContext(), nil, s.storageKeyTemplate(), nil, nil, nil)
+	c.Assert(err, IsNil)
+
+	template := &tpm2.Public{
+		Type:    tpm2.ObjectTypeKeyedHash,
+		NameAlg: tpm2.HashAlgorithmSHA256,
+		Attrs:   tpm2.AttrFixedTPM | tpm2.AttrFixedParent | tpm2.AttrUserWithAuth,
+		Params:  &tpm2.PublicParamsU{KeyedHashDetail: &tpm2.KeyedHashParams{Scheme: tpm2.KeyedHashScheme{Scheme: tpm2.KeyedHashSchemeNull}}}}
+	sensitive := &tpm2.SensitiveCreate{Data: []byte("secret")}
+
+	priv, pub, _, _, _, err := s.tpm.Create(srk, sensitive, template, nil, nil, nil)
+	c.Assert(err, IsNil)
+	object, err := s.tpm.Load(srk, priv, pub, nil)
+	c.Assert(err, IsNil)
+
+	data, err := s.tpm.Unseal(object, nil)
+	c.Check(err, IsNil)
+	c.Check(data, DeepEquals, tpm2.SensitiveData("secret"))
+}
+
+func (s *tpmSuite) TestPrimarySeedSurvivesReset(c *C) {
+	_, pub1, _, _, _, err := s.tpm.CreatePrimary(s.tpm.OwnerHandleContext(), nil, s.storageKeyTemplate(), nil, nil, nil)
+	c.Assert(err, IsNil)
+
+	c.Check(s.tpm.Shutdown(tpm2.StartupClear), IsNil)
+	s.dev.Reset()
+	c.Check(s.tpm.Startup(tpm2.StartupClear), IsNil)
+
+	_, pub2, _, _, _, err := s.tpm.CreatePrimary(s.tpm.OwnerHandleContext(), nil, s.storageKeyTemplate(), nil, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(pub2.Unique, DeepEquals, pub1.Unique)
+}
+
+func (s *tpmSuite) TestResetFlushesTransientObjects(c *C) {
+	srk, _, _, _, _, err := s.tpm.CreatePrimary(s.tpm.OwnerHandleContext(), nil, s.storageKeyTemplate(), nil, nil, nil)
+	c.Assert(err, IsNil)
+
+	c.Check(s.tpm.Shutdown(tpm2.StartupClear), IsNil)
+	s.dev.Reset()
+	c.Check(s.tpm.Startup(tpm2.StartupClear), IsNil)
+
+	handles, err := s.tpm.GetCapabilityHandles(srk.Handle(), 1)
+	c.Check(err, IsNil)
+	c.Check(handles, HasLen, 0)
+}
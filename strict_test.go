@@ -0,0 +1,195 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+type strictModeSuite struct {
+	testutil.TPMTest
+}
+
+var _ = Suite(&strictModeSuite{TPMTest: testutil.TPMTest{TPMFeatures: testutil.TPMFeatureOwnerPersist | testutil.TPMFeatureChangeOwnerAuth}})
+
+func (s *strictModeSuite) SetUpTest(c *C) {
+	s.TPMTest.SetUpTest(c)
+	c.Assert(s.TPM.InitProperties(), IsNil)
+	s.TPM.SetStrictMode(true)
+}
+
+// addCleanupOwnerAuth ensures that the authorization value of the storage hierarchy is cleared at the end of the test.
+func (s *strictModeSuite) addCleanupOwnerAuth(c *C) {
+	s.AddCleanup(func() {
+		s.TPM.SetStrictMode(false)
+		c.Check(s.TPM.HierarchyChangeAuth(s.TPM.OwnerHandleContext(), nil, nil), IsNil)
+	})
+}
+
+func (s *strictModeSuite) createPrimary(c *C) ResourceContext {
+	template := Public{
+		Type:    ObjectTypeECC,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrNoDA | AttrRestricted | AttrDecrypt,
+		Params: &PublicParamsU{
+			ECCDetail: &ECCParams{
+				Symmetric: SymDefObject{
+					Algorithm: SymObjectAlgorithmAES,
+					KeyBits:   &SymKeyBitsU{Sym: 128},
+					Mode:      &SymModeU{Sym: SymModeCFB}},
+				Scheme:  ECCScheme{Scheme: ECCSchemeNull},
+				CurveID: ECCCurveNIST_P256,
+				KDF:     KDFScheme{Scheme: KDFAlgorithmNull}}}}
+	session := s.startSession(c, nil, s.TPM.OwnerHandleContext())
+
+	primary, _, _, _, _, err := s.TPM.CreatePrimary(s.TPM.OwnerHandleContext(), nil, &template, nil, nil, session.WithAttrs(AttrCommandEncrypt))
+	c.Assert(err, IsNil)
+	s.AddCleanupFlushContext(c, primary)
+	return primary
+}
+
+func (s *strictModeSuite) startSession(c *C, tpmKey, bind ResourceContext) SessionContext {
+	session, err := s.TPM.StartAuthSession(tpmKey, bind, SessionTypeHMAC, &SymDef{
+		Algorithm: SymAlgorithmAES,
+		KeyBits:   &SymKeyBitsU{Sym: 128},
+		Mode:      &SymModeU{Sym: SymModeCFB}}, HashAlgorithmSHA256)
+	c.Assert(err, IsNil)
+	s.AddCleanupFlushContext(c, session)
+	return session
+}
+
+func (s *strictModeSuite) TestDisabled(c *C) {
+	s.addCleanupOwnerAuth(c)
+	s.TPM.SetStrictMode(false)
+	c.Check(s.TPM.HierarchyChangeAuth(s.TPM.OwnerHandleContext(), Auth("foo"), nil), IsNil)
+}
+
+func (s *strictModeSuite) TestUnencryptedCommandParam(c *C) {
+	session := s.startSession(c, s.createPrimary(c), nil)
+	err := s.TPM.HierarchyChangeAuth(s.TPM.OwnerHandleContext(), Auth("foo"), session)
+	c.Check(err, ErrorMatches, "command TPM_CC_HierarchyChangeAuth was not submitted because of an unprotected sensitive "+
+		"parameter: the first command parameter must be encrypted")
+	c.Assert(err, FitsTypeOf, &UnprotectedParameterError{})
+	c.Check(err.(*UnprotectedParameterError).Command, Equals, CommandHierarchyChangeAuth)
+	c.Check(err.(*UnprotectedParameterError).Response, testutil.IsFalse)
+
+	// Check that the command wasn't submitted.
+	s.TPM.SetStrictMode(false)
+	c.Check(s.TPM.HierarchyChangeAuth(s.TPM.OwnerHandleContext(), nil, nil), IsNil)
+}
+
+func (s *strictModeSuite) TestEmptyCommandParam(c *C) {
+	session := s.startSession(c, s.createPrimary(c), nil)
+	c.Check(s.TPM.HierarchyChangeAuth(s.TPM.OwnerHandleContext(), nil, session), IsNil)
+}
+
+func (s *strictModeSuite) TestPasswordAuth(c *C) {
+	session := s.startSession(c, s.createPrimary(c), nil)
+	err := s.TPM.HierarchyChangeAuth(s.TPM.OwnerHandleContext(), Auth("foo"), nil, session.WithAttrs(AttrCommandEncrypt))
+	c.Check(err, ErrorMatches, "command TPM_CC_HierarchyChangeAuth was not submitted because of an unprotected sensitive "+
+		"parameter: the authorization at index 0 is a password authorization")
+	c.Assert(err, FitsTypeOf, &UnprotectedParameterError{})
+	c.Check(err.(*UnprotectedParameterError).Response, testutil.IsFalse)
+
+	// Check that the command wasn't submitted.
+	s.TPM.SetStrictMode(false)
+	c.Check(s.TPM.HierarchyChangeAuth(s.TPM.OwnerHandleContext(), nil, nil), IsNil)
+}
+
+func (s *strictModeSuite) TestPasswordAuthEmptyCommandParam(c *C) {
+	err := s.TPM.HierarchyChangeAuth(s.TPM.OwnerHandleContext(), nil, nil)
+	c.Check(err, ErrorMatches, "command TPM_CC_HierarchyChangeAuth was not submitted because of an unprotected sensitive "+
+		"parameter: the authorization at index 0 is a password authorization")
+	c.Check(err, FitsTypeOf, &UnprotectedParameterError{})
+}
+
+func (s *strictModeSuite) TestUnsaltedUnboundSession(c *C) {
+	session := s.startSession(c, nil, nil)
+	err := s.TPM.HierarchyChangeAuth(s.TPM.OwnerHandleContext(), Auth("foo"), nil, session.WithAttrs(AttrCommandEncrypt))
+	c.Check(err, ErrorMatches, "command TPM_CC_HierarchyChangeAuth was not submitted because of an unprotected sensitive "+
+		"parameter: the session used for parameter encryption is unsalted and unbound")
+	c.Check(err, FitsTypeOf, &UnprotectedParameterError{})
+}
+
+func (s *strictModeSuite) TestUnsaltedUnboundAuthSession(c *C) {
+	session := s.startSession(c, nil, nil)
+	err := s.TPM.HierarchyChangeAuth(s.TPM.OwnerHandleContext(), nil, session)
+	c.Check(err, ErrorMatches, "command TPM_CC_HierarchyChangeAuth was not submitted because of an unprotected sensitive "+
+		"parameter: the HMAC session at index 0 is unsalted and unbound")
+	c.Check(err, FitsTypeOf, &UnprotectedParameterError{})
+}
+
+func (s *strictModeSuite) TestSaltedSession(c *C) {
+	primary := s.createPrimary(c)
+	session := s.startSession(c, primary, nil)
+	s.addCleanupOwnerAuth(c)
+	c.Check(s.TPM.HierarchyChangeAuth(s.TPM.OwnerHandleContext(), Auth("foo"), session.WithAttrs(AttrCommandEncrypt)), IsNil)
+}
+
+func (s *strictModeSuite) TestBoundSession(c *C) {
+	session := s.startSession(c, nil, s.TPM.EndorsementHandleContext())
+	s.addCleanupOwnerAuth(c)
+	c.Check(s.TPM.HierarchyChangeAuth(s.TPM.OwnerHandleContext(), Auth("foo"), session.WithAttrs(AttrCommandEncrypt)), IsNil)
+}
+
+func (s *strictModeSuite) TestUnencryptedResponseParam(c *C) {
+	primary := s.createPrimary(c)
+	session := s.startSession(c, primary, nil)
+
+	template := Public{
+		Type:    ObjectTypeKeyedHash,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrUserWithAuth | AttrNoDA,
+		Params:  &PublicParamsU{KeyedHashDetail: &KeyedHashParams{Scheme: KeyedHashScheme{Scheme: KeyedHashSchemeNull}}}}
+	priv, pub, _, _, _, err := s.TPM.Create(primary, &SensitiveCreate{Data: []byte("secret")}, &template, nil, nil,
+		session.WithAttrs(AttrCommandEncrypt|AttrContinueSession))
+	c.Assert(err, IsNil)
+	object, err := s.TPM.Load(primary, priv, pub, nil)
+	c.Assert(err, IsNil)
+	s.AddCleanupFlushContext(c, object)
+
+	_, err = s.TPM.Unseal(object, nil)
+	c.Check(err, ErrorMatches, "command TPM_CC_Unseal was not submitted because of an unprotected sensitive parameter: the "+
+		"first response parameter must be encrypted")
+	c.Assert(err, FitsTypeOf, &UnprotectedParameterError{})
+	c.Check(err.(*UnprotectedParameterError).Response, testutil.IsTrue)
+
+	data, err := s.TPM.Unseal(object, nil, session.WithAttrs(AttrResponseEncrypt))
+	c.Check(err, IsNil)
+	c.Check(data, DeepEquals, SensitiveData("secret"))
+}
+
+func (s *strictModeSuite) TestUnencryptedSecretResponseParam(c *C) {
+	primary := s.createPrimary(c)
+
+	var zPoint, pubPoint Data
+	err := s.TPM.RunCommand(CommandECDHKeyGen, nil, primary, Delimiter, Delimiter, Delimiter, &zPoint, &pubPoint)
+	c.Check(err, ErrorMatches, "command TPM_CC_ECDH_KeyGen was not submitted because of an unprotected sensitive parameter: the "+
+		"first response parameter must be encrypted")
+	c.Assert(err, FitsTypeOf, &UnprotectedParameterError{})
+	c.Check(err.(*UnprotectedParameterError).Response, testutil.IsTrue)
+}
+
+func (s *strictModeSuite) TestWithDefaultSession(c *C) {
+	primary := s.createPrimary(c)
+	srk, err := s.TPM.PersistObject(s.TPM.OwnerHandleContext(), primary, PersistentOwnerRange, nil)
+	c.Assert(err, IsNil)
+	s.AddCleanup(func() {
+		_, err := s.TPM.EvictControl(s.TPM.OwnerHandleContext(), srk, srk.Handle(), nil)
+		c.Check(err, IsNil)
+	})
+	c.Assert(s.TPM.EnableDefaultSession(srk), IsNil)
+	s.AddCleanup(func() {
+		c.Check(s.TPM.DisableDefaultSession(), IsNil)
+	})
+
+	// The default session provides parameter encryption, but a session is still required for authorization.
+	session := s.startSession(c, srk, nil)
+	s.addCleanupOwnerAuth(c)
+	c.Check(s.TPM.HierarchyChangeAuth(s.TPM.OwnerHandleContext(), Auth("foo"), session), IsNil)
+}
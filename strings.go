@@ -109,6 +109,8 @@ func (c CommandCode) String() string {
 		return "TPM_CC_PCR_Allocate"
 	case CommandSetPrimaryPolicy:
 		return "TPM_CC_SetPrimaryPolicy"
+	case CommandFieldUpgradeStart:
+		return "TPM_CC_FieldUpgradeStart"
 	case CommandClockRateAdjust:
 		return "TPM_CC_ClockRateAdjust"
 	case CommandCreatePrimary:
@@ -139,8 +141,12 @@ func (c CommandCode) String() string {
 		return "TPM_CC_PCR_Reset"
 	case CommandSequenceComplete:
 		return "TPM_CC_SequenceComplete"
+	case CommandSetAlgorithmSet:
+		return "TPM_CC_SetAlgorithmSet"
 	case CommandSetCommandCodeAuditStatus:
 		return "TPM_CC_SetCommandCodeAuditStatus"
+	case CommandFieldUpgradeData:
+		return "TPM_CC_FieldUpgradeData"
 	case CommandIncrementalSelfTest:
 		return "TPM_CC_IncrementalSelfTest"
 	case CommandSelfTest:
@@ -239,6 +245,8 @@ func (c CommandCode) String() string {
 		return "TPM_CC_VerifySignature"
 	case CommandECCParameters:
 		return "TPM_CC_ECC_Parameters"
+	case CommandFirmwareRead:
+		return "TPM_CC_FirmwareRead"
 	case CommandGetCapability:
 		return "TPM_CC_GetCapability"
 	case CommandGetRandom:
@@ -273,6 +281,8 @@ func (c CommandCode) String() string {
 		return "TPM_CC_Commit"
 	case CommandPolicyPassword:
 		return "TPM_CC_PolicyPassword"
+	case CommandZGen2Phase:
+		return "TPM_CC_ZGen_2Phase"
 	case CommandPolicyNvWritten:
 		return "TPM_CC_PolicyNvWritten"
 	case CommandPolicyTemplate:
@@ -281,7 +291,20 @@ func (c CommandCode) String() string {
 		return "TPM_CC_CreateLoaded"
 	case CommandPolicyAuthorizeNV:
 		return "TPM_CC_PolicyAuthorizeNV"
+	case CommandACGetCapability:
+		return "TPM_CC_AC_GetCapability"
+	case CommandACSend:
+		return "TPM_CC_AC_Send"
+	case CommandPolicyACSendSelect:
+		return "TPM_CC_Policy_AC_SendSelect"
+	case CommandCertifyX509:
+		return "TPM_CC_CertifyX509"
+	case CommandACTSetTimeout:
+		return "TPM_CC_ACT_SetTimeout"
 	default:
+		if cmd := lookupVendorCommand(c); cmd != nil {
+			return cmd.Name
+		}
 		return fmt.Sprintf("0x%08x", uint32(c))
 	}
 }
@@ -533,6 +556,9 @@ func (h Handle) String() string {
 	case HandlePlatformNV:
 		return "TPM_RH_PLATFORM_NV"
 	default:
+		if h >= HandleACT0 && h <= HandleACTF {
+			return fmt.Sprintf("TPM_RH_ACT_%X", uint32(h-HandleACT0))
+		}
 		return fmt.Sprintf("0x%08x", uint32(h))
 	}
 }
@@ -704,6 +730,8 @@ func (c Capability) String() string {
 		return "TPM_CAP_ECC_CURVES"
 	case CapabilityAuthPolicies:
 		return "TPM_CAP_AUTH_POLICIES"
+	case CapabilityACT:
+		return "TPM_CAP_ACT"
 	default:
 		return fmt.Sprintf("0x%08x", uint32(c))
 	}
@@ -0,0 +1,219 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	swtpmCmdGetCapability uint32 = 1
+	swtpmCmdInit          uint32 = 2
+	swtpmCmdShutdown      uint32 = 3
+	swtpmCmdSetLocality   uint32 = 5
+	swtpmCmdCancelTPMCmd  uint32 = 9
+
+	swtpmInitFlagDeleteVolatile uint32 = 1
+)
+
+// SwtpmCapabilities corresponds to the capabilities returned from the control channel of swtpm.
+type SwtpmCapabilities uint64
+
+const (
+	SwtpmCapInit                SwtpmCapabilities = 1 << 0  // CMD_INIT is supported
+	SwtpmCapShutdown            SwtpmCapabilities = 1 << 1  // CMD_SHUTDOWN is supported
+	SwtpmCapGetTPMEstablished   SwtpmCapabilities = 1 << 2  // CMD_GET_TPMESTABLISHED is supported
+	SwtpmCapSetLocality         SwtpmCapabilities = 1 << 3  // CMD_SET_LOCALITY is supported
+	SwtpmCapHashing             SwtpmCapabilities = 1 << 4  // CMD_HASH_START, CMD_HASH_DATA and CMD_HASH_END are supported
+	SwtpmCapCancelTPMCmd        SwtpmCapabilities = 1 << 5  // CMD_CANCEL_TPM_CMD is supported
+	SwtpmCapStoreVolatile       SwtpmCapabilities = 1 << 6  // CMD_STORE_VOLATILE is supported
+	SwtpmCapResetTPMEstablished SwtpmCapabilities = 1 << 7  // CMD_RESET_TPMESTABLISHED is supported
+	SwtpmCapGetStateBlob        SwtpmCapabilities = 1 << 8  // CMD_GET_STATEBLOB is supported
+	SwtpmCapSetStateBlob        SwtpmCapabilities = 1 << 9  // CMD_SET_STATEBLOB is supported
+	SwtpmCapStop                SwtpmCapabilities = 1 << 10 // CMD_STOP is supported
+)
+
+// TctiSwtpm represents a connection to a swtpm instance that is using its socket interface. Commands are sent to the TPM on
+// the data channel without any additional framing. The optional control channel is used to change the locality, cancel
+// commands and manage the state of the TPM.
+type TctiSwtpm struct {
+	tpm  net.Conn
+	ctrl net.Conn // Optional control channel
+
+	ctrlMu sync.Mutex // Protects the control channel, which may be used from another goroutine by Cancel
+
+	buf *bytes.Reader
+}
+
+func (t *TctiSwtpm) readMoreData() error {
+	var hdr responseHeader
+	hdrBytes := make([]byte, binary.Size(hdr))
+	if _, err := io.ReadFull(t.tpm, hdrBytes); err != nil {
+		return xerrors.Errorf("cannot read response header from TPM data channel: %w", err)
+	}
+	if _, err := mu.UnmarshalFromBytes(hdrBytes, &hdr); err != nil {
+		return xerrors.Errorf("cannot unmarshal response header: %w", err)
+	}
+	if int(hdr.ResponseSize) < len(hdrBytes) {
+		return fmt.Errorf("invalid response size (%d)", hdr.ResponseSize)
+	}
+
+	buf := make([]byte, hdr.ResponseSize)
+	copy(buf, hdrBytes)
+	if _, err := io.ReadFull(t.tpm, buf[len(hdrBytes):]); err != nil {
+		return xerrors.Errorf("cannot read response from TPM data channel: %w", err)
+	}
+
+	t.buf = bytes.NewReader(buf)
+	return nil
+}
+
+func (t *TctiSwtpm) Read(data []byte) (int, error) {
+	if t.buf == nil || t.buf.Len() == 0 {
+		if err := t.readMoreData(); err != nil {
+			return 0, err
+		}
+	}
+	return t.buf.Read(data)
+}
+
+func (t *TctiSwtpm) Write(data []byte) (int, error) {
+	return t.tpm.Write(data)
+}
+
+func (t *TctiSwtpm) Close() (out error) {
+	if t.ctrl != nil {
+		if err := t.ctrl.Close(); err != nil {
+			out = xerrors.Errorf("cannot close control channel: %w", err)
+		}
+	}
+	if err := t.tpm.Close(); err != nil {
+		out = xerrors.Errorf("cannot close TPM data channel: %w", err)
+	}
+	return
+}
+
+// SetLocality sets the locality of subsequent commands using the control channel. This will fail if there is no control
+// channel.
+func (t *TctiSwtpm) SetLocality(locality uint8) error {
+	return t.ctrlCommand(swtpmCmdSetLocality, locality)
+}
+
+func (t *TctiSwtpm) MakeSticky(handle Handle, sticky bool) error {
+	return errors.New("not implemented")
+}
+
+// Cancel implements TCTICanceler.Cancel, and requests that the currently executing command is canceled using the control
+// channel. This will fail if there is no control channel.
+func (t *TctiSwtpm) Cancel() error {
+	return t.ctrlCommand(swtpmCmdCancelTPMCmd)
+}
+
+// SetReadDeadline implements TCTIReadDeadliner.SetReadDeadline, and sets the read deadline on the TPM data channel.
+func (t *TctiSwtpm) SetReadDeadline(deadline time.Time) error {
+	return t.tpm.SetReadDeadline(deadline)
+}
+
+func (t *TctiSwtpm) ctrlTransaction(cmd uint32, params []interface{}, results ...interface{}) error {
+	t.ctrlMu.Lock()
+	defer t.ctrlMu.Unlock()
+
+	if t.ctrl == nil {
+		return errors.New("no control channel")
+	}
+
+	if _, err := mu.MarshalToWriter(t.ctrl, append([]interface{}{cmd}, params...)...); err != nil {
+		return xerrors.Errorf("cannot send command: %w", err)
+	}
+	if _, err := mu.UnmarshalFromReader(t.ctrl, results...); err != nil {
+		return xerrors.Errorf("cannot read response to command: %w", err)
+	}
+	return nil
+}
+
+func (t *TctiSwtpm) ctrlCommand(cmd uint32, params ...interface{}) error {
+	var resp uint32
+	if err := t.ctrlTransaction(cmd, params, &resp); err != nil {
+		return err
+	}
+	if resp != 0 {
+		return &PlatformCommandError{cmd, resp}
+	}
+	return nil
+}
+
+// GetCapabilities returns the capabilities of the control channel. This will fail if there is no control channel.
+func (t *TctiSwtpm) GetCapabilities() (SwtpmCapabilities, error) {
+	var caps SwtpmCapabilities
+	if err := t.ctrlTransaction(swtpmCmdGetCapability, nil, &caps); err != nil {
+		return 0, err
+	}
+	return caps, nil
+}
+
+// Init initializes the TPM using the control channel, which results in the execution of _TPM_Init(). If deleteVolatile is
+// true, any saved volatile state is deleted. This will fail if there is no control channel.
+func (t *TctiSwtpm) Init(deleteVolatile bool) error {
+	var flags uint32
+	if deleteVolatile {
+		flags |= swtpmInitFlagDeleteVolatile
+	}
+	return t.ctrlCommand(swtpmCmdInit, flags)
+}
+
+// Shutdown shuts down the TPM using the control channel, which causes swtpm to exit. This will fail if there is no control
+// channel.
+func (t *TctiSwtpm) Shutdown() error {
+	return t.ctrlCommand(swtpmCmdShutdown)
+}
+
+// NewSwtpmFromConn returns a new TctiSwtpm instance that uses the supplied connections to swtpm. The tpm argument is the
+// connection to the TPM data channel. The ctrl argument is the connection to the control channel, and is optional. If it is not
+// provided, methods that require the control channel will fail.
+//
+// The returned TctiSwtpm takes ownership of the supplied connections, and will close them when it is closed.
+func NewSwtpmFromConn(tpm, ctrl net.Conn) (*TctiSwtpm, error) {
+	if tpm == nil {
+		return nil, errors.New("no TPM data channel")
+	}
+	return &TctiSwtpm{tpm: tpm, ctrl: ctrl}, nil
+}
+
+// OpenSwtpmUnix attempts to open a connection to a swtpm instance that is listening on Unix domain sockets, such as one started
+// with "swtpm socket --tpm2 --server type=unixio,path=<tpmPath> --ctrl type=unixio,path=<ctrlPath>". If ctrlPath is an empty
+// string, no control channel is opened and methods that require it will fail.
+//
+// This does not initialize the TPM. A TPM that has been started without the --flags startup-clear option may need to be
+// initialized with TctiSwtpm.Init, and then started up with TPMContext.Startup.
+//
+// If successful, it returns a new TctiSwtpm instance which can be passed to NewTPMContext.
+func OpenSwtpmUnix(tpmPath, ctrlPath string) (*TctiSwtpm, error) {
+	tpm, err := net.Dial("unix", tpmPath)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot connect to TPM socket: %w", err)
+	}
+
+	var ctrl net.Conn
+	if ctrlPath != "" {
+		ctrl, err = net.Dial("unix", ctrlPath)
+		if err != nil {
+			tpm.Close()
+			return nil, xerrors.Errorf("cannot connect to control socket: %w", err)
+		}
+	}
+
+	return NewSwtpmFromConn(tpm, ctrl)
+}
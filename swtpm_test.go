@@ -0,0 +1,93 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"io"
+	"net"
+
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+type swtpmSuite struct {
+	testutil.BaseTest
+}
+
+var _ = Suite(&swtpmSuite{})
+
+func (s *swtpmSuite) TestCommand(c *C) {
+	client, server := net.Pipe()
+	tcti, err := NewSwtpmFromConn(client, nil)
+	c.Assert(err, IsNil)
+	defer tcti.Close()
+
+	go func() {
+		var tag StructTag
+		var size uint32
+		_, err := mu.UnmarshalFromReader(server, &tag, &size)
+		c.Check(err, IsNil)
+		_, err = io.ReadFull(server, make([]byte, size-6))
+		c.Check(err, IsNil)
+		_, err = server.Write(makeScriptedPacket(c, TagSessions, uint32(Success), uint32(0), uint16(0), uint8(1), uint16(0)))
+		c.Check(err, IsNil)
+	}()
+
+	tpm, _ := NewTPMContext(tcti)
+	c.Check(tpm.Clear(tpm.LockoutHandleContext(), nil), IsNil)
+}
+
+func (s *swtpmSuite) TestNoControlChannel(c *C) {
+	client, _ := net.Pipe()
+	tcti, err := NewSwtpmFromConn(client, nil)
+	c.Assert(err, IsNil)
+	defer tcti.Close()
+
+	c.Check(tcti.SetLocality(1), ErrorMatches, "no control channel")
+	c.Check(tcti.Cancel(), ErrorMatches, "no control channel")
+}
+
+func (s *swtpmSuite) TestControlCommands(c *C) {
+	client, _ := net.Pipe()
+	ctrlClient, ctrlServer := net.Pipe()
+	tcti, err := NewSwtpmFromConn(client, ctrlClient)
+	c.Assert(err, IsNil)
+	defer tcti.Close()
+
+	type exchange struct {
+		request  []interface{}
+		response []interface{}
+	}
+	script := []exchange{
+		{request: []interface{}{uint32(1)}, response: []interface{}{uint64(0x2f)}},
+		{request: []interface{}{uint32(2), uint32(1)}, response: []interface{}{uint32(0)}},
+		{request: []interface{}{uint32(5), uint8(3)}, response: []interface{}{uint32(0)}},
+		{request: []interface{}{uint32(9)}, response: []interface{}{uint32(0)}},
+		{request: []interface{}{uint32(3)}, response: []interface{}{uint32(0x101)}}}
+
+	go func() {
+		for _, e := range script {
+			expected, err := mu.MarshalToBytes(e.request...)
+			c.Check(err, IsNil)
+			req := make([]byte, len(expected))
+			_, err = io.ReadFull(ctrlServer, req)
+			c.Check(err, IsNil)
+			c.Check(req, DeepEquals, expected)
+			_, err = mu.MarshalToWriter(ctrlServer, e.response...)
+			c.Check(err, IsNil)
+		}
+	}()
+
+	caps, err := tcti.GetCapabilities()
+	c.Check(err, IsNil)
+	c.Check(caps, Equals, SwtpmCapInit|SwtpmCapShutdown|SwtpmCapGetTPMEstablished|SwtpmCapSetLocality|SwtpmCapCancelTPMCmd)
+	c.Check(tcti.Init(true), IsNil)
+	c.Check(tcti.SetLocality(3), IsNil)
+	c.Check(tcti.Cancel(), IsNil)
+	c.Check(tcti.Shutdown(), ErrorMatches, "received error code 257 in response to platform command 3")
+}
@@ -0,0 +1,154 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tcg
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
+)
+
+func decodeHexString(s string) tpm2.Digest {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// readNVIndex reads the entire contents of the NV index at the specified handle, using the index itself for authorization if it
+// permits this, else the owner hierarchy. Both are expected to have an empty authorization value. It returns a
+// tpm2.ResourceUnavailableError if the index doesn't exist.
+func readNVIndex(tpm *tpm2.TPMContext, handle tpm2.Handle, sessions ...tpm2.SessionContext) ([]byte, error) {
+	index, err := tpm.CreateResourceContextFromTPM(handle, sessions...)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, _, err := tpm.NVReadPublic(index, sessions...)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot read public area: %w", err)
+	}
+
+	auth := index
+	if pub.Attrs&tpm2.AttrNVAuthRead == 0 {
+		auth = tpm.OwnerHandleContext()
+	}
+
+	data, err := tpm.NVRead(auth, index, pub.Size, 0, nil, sessions...)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot read data: %w", err)
+	}
+	return data, nil
+}
+
+// EKTemplateFromTPM returns the template for the endorsement key described by the specified profile, taking in to account any
+// customization by the platform manufacturer. If the profile is a low range profile and a template is stored at the profile's
+// template index, that template is returned. Else if a nonce is stored at the profile's nonce index, the default template is
+// returned with the nonce inserted in to the unique field. Otherwise, the default template is returned.
+func EKTemplateFromTPM(tpm *tpm2.TPMContext, profile *EKProfile, sessions ...tpm2.SessionContext) (*tpm2.Public, error) {
+	if !profile.LowRange() {
+		return profile.Template(), nil
+	}
+
+	data, err := readNVIndex(tpm, profile.TemplateIndex, sessions...)
+	switch {
+	case tpm2.IsResourceUnavailableError(err, profile.TemplateIndex):
+		// No template
+	case err != nil:
+		return nil, xerrors.Errorf("cannot read template index: %w", err)
+	default:
+		var template *tpm2.Public
+		if _, err := mu.UnmarshalFromBytes(data, &template); err != nil {
+			return nil, xerrors.Errorf("cannot unmarshal template: %w", err)
+		}
+		return template, nil
+	}
+
+	template := profile.Template()
+
+	nonce, err := readNVIndex(tpm, profile.NonceIndex, sessions...)
+	switch {
+	case tpm2.IsResourceUnavailableError(err, profile.NonceIndex):
+		return template, nil
+	case err != nil:
+		return nil, xerrors.Errorf("cannot read nonce index: %w", err)
+	}
+
+	switch template.Type {
+	case tpm2.ObjectTypeRSA:
+		if len(nonce) > len(template.Unique.RSA) {
+			return nil, errors.New("nonce is too large")
+		}
+		copy(template.Unique.RSA, nonce)
+	case tpm2.ObjectTypeECC:
+		if len(nonce) > len(template.Unique.ECC.X) {
+			return nil, errors.New("nonce is too large")
+		}
+		copy(template.Unique.ECC.X, nonce)
+	}
+
+	return template, nil
+}
+
+// CreateEK creates the endorsement key described by the specified profile with TPMContext.CreatePrimary, using the template
+// returned from EKTemplateFromTPM. The endorsement hierarchy requires authorization with the user auth role, with session based
+// authorization provided via endorsementAuthSession.
+//
+// The endorsement key has the standard authorization policy from the EK Credential Profile, which is satisfied by a
+// TPM2_PolicySecret assertion for the endorsement hierarchy (and, for the high range profiles, a subsequent TPM2_PolicyOR
+// assertion).
+//
+// On success, a ResourceContext for the new transient object and its public area are returned.
+func CreateEK(tpm *tpm2.TPMContext, profile *EKProfile, endorsementAuthSession tpm2.SessionContext, sessions ...tpm2.SessionContext) (tpm2.ResourceContext, *tpm2.Public, error) {
+	template, err := EKTemplateFromTPM(tpm, profile, sessions...)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("cannot obtain template: %w", err)
+	}
+
+	ek, public, _, _, _, err := tpm.CreatePrimary(tpm.EndorsementHandleContext(), nil, template, nil, nil, endorsementAuthSession, sessions...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ek, public, nil
+}
+
+// ReadEKCertificateBytes reads the DER encoded EK certificate for the specified profile from its NV index. Any trailing padding
+// after the certificate is removed. A tpm2.ResourceUnavailableError is returned if there is no certificate for the profile.
+func ReadEKCertificateBytes(tpm *tpm2.TPMContext, profile *EKProfile, sessions ...tpm2.SessionContext) ([]byte, error) {
+	data, err := readNVIndex(tpm, profile.CertificateIndex, sessions...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Some indices are larger than the certificates stored in them.
+	var cert asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &cert); err != nil {
+		return nil, fmt.Errorf("cannot decode certificate: %v", err)
+	}
+	return cert.FullBytes, nil
+}
+
+// ReadEKCertificate reads and parses the EK certificate for the specified profile from its NV index. A
+// tpm2.ResourceUnavailableError is returned if there is no certificate for the profile.
+func ReadEKCertificate(tpm *tpm2.TPMContext, profile *EKProfile, sessions ...tpm2.SessionContext) (*x509.Certificate, error) {
+	data, err := ReadEKCertificateBytes(tpm, profile, sessions...)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot parse certificate: %w", err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,264 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tcg
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/canonical/go-tpm2"
+
+	"golang.org/x/xerrors"
+)
+
+var (
+	oidSubjectAltName              = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidSubjectDirectoryAttrs       = asn1.ObjectIdentifier{2, 5, 29, 9}
+	oidTCGAttributeTPMManufacturer = asn1.ObjectIdentifier{2, 23, 133, 2, 1}
+	oidTCGAttributeTPMModel        = asn1.ObjectIdentifier{2, 23, 133, 2, 2}
+	oidTCGAttributeTPMVersion      = asn1.ObjectIdentifier{2, 23, 133, 2, 3}
+
+	// OIDTCGKpEKCertificate is the tcg-kp-EKCertificate extended key usage from the EK Credential Profile.
+	OIDTCGKpEKCertificate = asn1.ObjectIdentifier{2, 23, 133, 8, 1}
+)
+
+// TPMDeviceInfo contains the TPM device attributes from the subject alternative name extension of an EK certificate.
+type TPMDeviceInfo struct {
+	Manufacturer tpm2.TPMManufacturer // The TPM manufacturer ID, from the tcg-at-tpmManufacturer attribute
+	Model        string               // The TPM model, from the tcg-at-tpmModel attribute
+	Version      string               // The TPM firmware version, from the tcg-at-tpmVersion attribute
+}
+
+// parseTPMManufacturer decodes the value of a tcg-at-tpmManufacturer attribute, which should be of the form "id:XXXXXXXX", where
+// XXXXXXXX is the hex encoded manufacturer ID. Some vendors omit the "id:" prefix or use the ASCII vendor ID instead.
+func parseTPMManufacturer(s string) (tpm2.TPMManufacturer, error) {
+	s = strings.TrimSpace(s)
+	if len(s) >= 3 && strings.EqualFold(s[:3], "id:") {
+		s = s[3:]
+	}
+
+	if len(s) == 8 {
+		if b, err := hex.DecodeString(s); err == nil {
+			return tpm2.TPMManufacturer(binary.BigEndian.Uint32(b)), nil
+		}
+	}
+	if len(s) > 0 && len(s) <= 4 {
+		var b [4]byte
+		copy(b[:], s)
+		return tpm2.TPMManufacturer(binary.BigEndian.Uint32(b[:])), nil
+	}
+
+	return 0, fmt.Errorf("invalid manufacturer ID %q", s)
+}
+
+// parseDirectoryName decodes the directoryName from a subject alternative name. This should be an explicitly tagged
+// RDNSequence, but some vendors use implicit tagging or emit a single SET of attributes without the outer SEQUENCE. Both of
+// these are decoded by treating the contents as the contents of a SEQUENCE.
+func parseDirectoryName(data []byte) (pkix.RDNSequence, error) {
+	var name pkix.RDNSequence
+	if rest, err := asn1.Unmarshal(data, &name); err == nil && len(rest) == 0 {
+		return name, nil
+	}
+
+	seq, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: data})
+	if err != nil {
+		return nil, err
+	}
+	if rest, err := asn1.Unmarshal(seq, &name); err == nil && len(rest) == 0 {
+		return name, nil
+	}
+
+	return nil, errors.New("invalid directoryName")
+}
+
+// ParseTPMDeviceInfo decodes the TPM manufacturer, model and version from the subject alternative name extension of the
+// supplied EK certificate.
+func ParseTPMDeviceInfo(cert *x509.Certificate) (*TPMDeviceInfo, error) {
+	var san []byte
+	for _, e := range cert.Extensions {
+		if e.Id.Equal(oidSubjectAltName) {
+			san = e.Value
+			break
+		}
+	}
+	if san == nil {
+		return nil, errors.New("no subject alternative name extension")
+	}
+
+	var names []asn1.RawValue
+	if rest, err := asn1.Unmarshal(san, &names); err != nil {
+		return nil, xerrors.Errorf("cannot decode subject alternative name: %w", err)
+	} else if len(rest) > 0 {
+		return nil, errors.New("trailing bytes after subject alternative name")
+	}
+
+	var info *TPMDeviceInfo
+	for _, n := range names {
+		if n.Class != asn1.ClassContextSpecific || n.Tag != 4 {
+			// Not a directoryName
+			continue
+		}
+
+		name, err := parseDirectoryName(n.Bytes)
+		if err != nil {
+			return nil, xerrors.Errorf("cannot decode subject alternative name: %w", err)
+		}
+
+		var manufacturer string
+		var found bool
+		current := new(TPMDeviceInfo)
+		for _, rdn := range name {
+			for _, attr := range rdn {
+				value, ok := attr.Value.(string)
+				switch {
+				case attr.Type.Equal(oidTCGAttributeTPMManufacturer):
+					if !ok {
+						return nil, errors.New("invalid tcg-at-tpmManufacturer attribute")
+					}
+					manufacturer = value
+					found = true
+				case attr.Type.Equal(oidTCGAttributeTPMModel):
+					if !ok {
+						return nil, errors.New("invalid tcg-at-tpmModel attribute")
+					}
+					current.Model = value
+				case attr.Type.Equal(oidTCGAttributeTPMVersion):
+					if !ok {
+						return nil, errors.New("invalid tcg-at-tpmVersion attribute")
+					}
+					current.Version = value
+				}
+			}
+		}
+		if !found {
+			continue
+		}
+
+		current.Manufacturer, err = parseTPMManufacturer(manufacturer)
+		if err != nil {
+			return nil, err
+		}
+		info = current
+		break
+	}
+
+	if info == nil {
+		return nil, errors.New("no TPM device attributes in subject alternative name")
+	}
+	return info, nil
+}
+
+// VerifyEKCertificateOptions contains options for VerifyEKCertificate.
+type VerifyEKCertificateOptions struct {
+	// Roots contains the trusted TPM manufacturer root certificates. This must be supplied.
+	Roots *x509.CertPool
+
+	// Intermediates contains any intermediate certificates that are required to build a chain to one of the roots.
+	Intermediates *x509.CertPool
+
+	// CurrentTime is the time at which the certificate chain is verified. If this is zero, the current time is used. As
+	// EK certificates are sometimes issued with short validity periods, this can be set to the time at which the certificate
+	// was issued in order to only check that the chain was valid at that time.
+	CurrentTime time.Time
+
+	// Manufacturer is the expected TPM manufacturer. If this is non-zero, the manufacturer in the EK certificate must match.
+	Manufacturer tpm2.TPMManufacturer
+}
+
+// VerifyEKCertificate verifies that the supplied EK certificate chains to one of the roots in opts, and that it certifies
+// the public key in the supplied public area, which should be obtained by creating the endorsement key (see CreateEK).
+//
+// The certificate must contain the TPM device attributes in its subject alternative name extension. If the certificate has
+// an extended key usage extension, it must contain tcg-kp-EKCertificate - anyExtendedKeyUsage is not accepted in its place. The
+// subject alternative name and subject directory attributes extensions are permitted to be critical, as recommended by the EK
+// Credential Profile for certificates with an empty subject.
+//
+// On success, the TPM device attributes from the certificate are returned.
+func VerifyEKCertificate(cert *x509.Certificate, ekPublic *tpm2.Public, opts *VerifyEKCertificateOptions) (*TPMDeviceInfo, error) {
+	if ekPublic == nil {
+		return nil, errors.New("no endorsement key public area supplied")
+	}
+	if opts == nil || opts.Roots == nil {
+		return nil, errors.New("no roots supplied")
+	}
+
+	// Remove the critical extensions that are expected in EK certificates but are not handled by crypto/x509.
+	certCopy := *cert
+	certCopy.UnhandledCriticalExtensions = nil
+	for _, e := range cert.UnhandledCriticalExtensions {
+		switch {
+		case e.Equal(oidSubjectAltName), e.Equal(oidSubjectDirectoryAttrs):
+		default:
+			certCopy.UnhandledCriticalExtensions = append(certCopy.UnhandledCriticalExtensions, e)
+		}
+	}
+
+	if _, err := certCopy.Verify(x509.VerifyOptions{
+		Roots:         opts.Roots,
+		Intermediates: opts.Intermediates,
+		CurrentTime:   opts.CurrentTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		return nil, xerrors.Errorf("cannot verify certificate chain: %w", err)
+	}
+
+	if len(cert.ExtKeyUsage) > 0 || len(cert.UnknownExtKeyUsage) > 0 {
+		found := false
+		for _, eku := range cert.UnknownExtKeyUsage {
+			if eku.Equal(OIDTCGKpEKCertificate) {
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.New("certificate has an extended key usage extension without tcg-kp-EKCertificate")
+		}
+	}
+
+	info, err := ParseTPMDeviceInfo(cert)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot obtain TPM device attributes: %w", err)
+	}
+	if opts.Manufacturer != 0 && info.Manufacturer != opts.Manufacturer {
+		return nil, fmt.Errorf("certificate is for a TPM from the wrong manufacturer (got %v, expected %v)", info.Manufacturer, opts.Manufacturer)
+	}
+
+	if !publicKeysEqual(cert.PublicKey, ekPublic.Public()) {
+		return nil, errors.New("certificate does not certify the endorsement key")
+	}
+
+	return info, nil
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	ka, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return false
+	}
+	return ka.Equal(b)
+}
+
+// VerifyEKCertificateForTPM verifies the supplied EK certificate with VerifyEKCertificate, and also checks that the TPM
+// manufacturer in the certificate matches the manufacturer reported by the supplied TPM with TPMContext.GetManufacturer. The
+// Manufacturer field of opts is ignored.
+func VerifyEKCertificateForTPM(tpm *tpm2.TPMContext, cert *x509.Certificate, ekPublic *tpm2.Public, opts *VerifyEKCertificateOptions, sessions ...tpm2.SessionContext) (*TPMDeviceInfo, error) {
+	manufacturer, err := tpm.GetManufacturer(sessions...)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot obtain TPM manufacturer: %w", err)
+	}
+
+	var o VerifyEKCertificateOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.Manufacturer = manufacturer
+	return VerifyEKCertificate(cert, ekPublic, &o)
+}
@@ -0,0 +1,217 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tcg_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/go-tpm2"
+	. "github.com/canonical/go-tpm2/tcg"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+var (
+	oidSubjectAltName              = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidTCGAttributeTPMManufacturer = asn1.ObjectIdentifier{2, 23, 133, 2, 1}
+	oidTCGAttributeTPMModel        = asn1.ObjectIdentifier{2, 23, 133, 2, 2}
+	oidTCGAttributeTPMVersion      = asn1.ObjectIdentifier{2, 23, 133, 2, 3}
+)
+
+type ekCertSuite struct {
+	testutil.TPMTest
+
+	rootKey  crypto.Signer
+	rootCert *x509.Certificate
+	roots    *x509.CertPool
+
+	ek       tpm2.ResourceContext
+	ekPublic *tpm2.Public
+}
+
+var _ = Suite(&ekCertSuite{TPMTest: testutil.TPMTest{TPMFeatures: testutil.TPMFeatureEndorsementHierarchy}})
+
+func (s *ekCertSuite) SetUpTest(c *C) {
+	s.TPMTest.SetUpTest(c)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	s.rootKey = key
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "TPM Manufacturer Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	c.Assert(err, IsNil)
+	s.rootCert, err = x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+	s.roots = x509.NewCertPool()
+	s.roots.AddCert(s.rootCert)
+
+	s.ek, s.ekPublic, err = CreateEK(s.TPM, EKProfileECCP256, nil)
+	c.Assert(err, IsNil)
+	s.AddFixtureCleanup(func(c *C) { c.Check(s.TPM.FlushContext(s.ek), IsNil) })
+}
+
+// makeSAN returns a subject alternative name extension value containing the supplied directoryName.
+func (s *ekCertSuite) makeSAN(c *C, dirName []byte) []byte {
+	san, err := asn1.Marshal([]asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: dirName}})
+	c.Assert(err, IsNil)
+	return san
+}
+
+// makeDeviceInfoName returns a RDNSequence containing the TPM device attributes, in the way recommended by the EK Credential
+// Profile.
+func (s *ekCertSuite) makeDeviceInfoName(c *C, manufacturer string) []byte {
+	name, err := asn1.Marshal(pkix.RDNSequence{
+		{{Type: oidTCGAttributeTPMManufacturer, Value: manufacturer}},
+		{{Type: oidTCGAttributeTPMModel, Value: "SLB9670"}},
+		{{Type: oidTCGAttributeTPMVersion, Value: "id:00070055"}}})
+	c.Assert(err, IsNil)
+	return name
+}
+
+// issueEKCert issues an EK certificate with an empty subject and a critical subject alternative name extension containing the
+// supplied value.
+func (s *ekCertSuite) issueEKCert(c *C, san []byte, public crypto.PublicKey, eku []asn1.ObjectIdentifier) *x509.Certificate {
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageKeyAgreement,
+		UnknownExtKeyUsage:    eku,
+		BasicConstraintsValid: true,
+		ExtraExtensions:       []pkix.Extension{{Id: oidSubjectAltName, Critical: true, Value: san}}}
+	der, err := x509.CreateCertificate(rand.Reader, &template, s.rootCert, public, s.rootKey)
+	c.Assert(err, IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+	return cert
+}
+
+func (s *ekCertSuite) TestVerifyEKCertificate(c *C) {
+	cert := s.issueEKCert(c, s.makeSAN(c, s.makeDeviceInfoName(c, "id:49424D00")), s.ekPublic.Public(),
+		[]asn1.ObjectIdentifier{OIDTCGKpEKCertificate})
+
+	info, err := VerifyEKCertificate(cert, s.ekPublic, &VerifyEKCertificateOptions{Roots: s.roots})
+	c.Assert(err, IsNil)
+	c.Check(info, DeepEquals, &TPMDeviceInfo{Manufacturer: tpm2.TPMManufacturerIBM, Model: "SLB9670", Version: "id:00070055"})
+}
+
+func (s *ekCertSuite) TestVerifyEKCertificateForTPM(c *C) {
+	cert := s.issueEKCert(c, s.makeSAN(c, s.makeDeviceInfoName(c, "id:49424D00")), s.ekPublic.Public(), nil)
+
+	info, err := VerifyEKCertificateForTPM(s.TPM, cert, s.ekPublic, &VerifyEKCertificateOptions{Roots: s.roots})
+	c.Assert(err, IsNil)
+	c.Check(info.Manufacturer, Equals, tpm2.TPMManufacturerIBM)
+}
+
+func (s *ekCertSuite) TestVerifyEKCertificateForTPMWrongManufacturer(c *C) {
+	cert := s.issueEKCert(c, s.makeSAN(c, s.makeDeviceInfoName(c, "id:49465800")), s.ekPublic.Public(), nil)
+
+	_, err := VerifyEKCertificateForTPM(s.TPM, cert, s.ekPublic, &VerifyEKCertificateOptions{Roots: s.roots})
+	c.Check(err, ErrorMatches, `certificate is for a TPM from the wrong manufacturer \(got .*, expected .*\)`)
+}
+
+func (s *ekCertSuite) TestVerifyEKCertificateWrongKey(c *C) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	cert := s.issueEKCert(c, s.makeSAN(c, s.makeDeviceInfoName(c, "id:49424D00")), key.Public(), nil)
+
+	_, err = VerifyEKCertificate(cert, s.ekPublic, &VerifyEKCertificateOptions{Roots: s.roots})
+	c.Check(err, ErrorMatches, "certificate does not certify the endorsement key")
+}
+
+func (s *ekCertSuite) TestVerifyEKCertificateUntrusted(c *C) {
+	cert := s.issueEKCert(c, s.makeSAN(c, s.makeDeviceInfoName(c, "id:49424D00")), s.ekPublic.Public(), nil)
+
+	_, err := VerifyEKCertificate(cert, s.ekPublic, &VerifyEKCertificateOptions{Roots: x509.NewCertPool()})
+	c.Check(err, ErrorMatches, "cannot verify certificate chain: .*")
+}
+
+func (s *ekCertSuite) TestVerifyEKCertificateWrongEKU(c *C) {
+	cert := s.issueEKCert(c, s.makeSAN(c, s.makeDeviceInfoName(c, "id:49424D00")), s.ekPublic.Public(),
+		[]asn1.ObjectIdentifier{{1, 2, 3, 4}})
+
+	_, err := VerifyEKCertificate(cert, s.ekPublic, &VerifyEKCertificateOptions{Roots: s.roots})
+	c.Check(err, ErrorMatches, "certificate has an extended key usage extension without tcg-kp-EKCertificate")
+}
+
+func (s *ekCertSuite) TestVerifyEKCertificateAnyEKU(c *C) {
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageKeyAgreement,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		BasicConstraintsValid: true,
+		ExtraExtensions: []pkix.Extension{{Id: oidSubjectAltName, Critical: true,
+			Value: s.makeSAN(c, s.makeDeviceInfoName(c, "id:49424D00"))}}}
+	der, err := x509.CreateCertificate(rand.Reader, &template, s.rootCert, s.ekPublic.Public(), s.rootKey)
+	c.Assert(err, IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+
+	_, err = VerifyEKCertificate(cert, s.ekPublic, &VerifyEKCertificateOptions{Roots: s.roots})
+	c.Check(err, ErrorMatches, "certificate has an extended key usage extension without tcg-kp-EKCertificate")
+}
+
+func (s *ekCertSuite) TestParseTPMDeviceInfoASCIIManufacturer(c *C) {
+	cert := s.issueEKCert(c, s.makeSAN(c, s.makeDeviceInfoName(c, "IFX")), s.ekPublic.Public(), nil)
+
+	info, err := ParseTPMDeviceInfo(cert)
+	c.Assert(err, IsNil)
+	c.Check(info.Manufacturer, Equals, tpm2.TPMManufacturerIFX)
+}
+
+func (s *ekCertSuite) TestParseTPMDeviceInfoSingleSet(c *C) {
+	// Some vendors emit a single SET of attributes without the outer SEQUENCE.
+	set, err := asn1.Marshal(pkix.RelativeDistinguishedNameSET{
+		{Type: oidTCGAttributeTPMManufacturer, Value: "id:53544D20"},
+		{Type: oidTCGAttributeTPMModel, Value: "ST33HTPHAHD4"},
+		{Type: oidTCGAttributeTPMVersion, Value: "id:00010102"}})
+	c.Assert(err, IsNil)
+	cert := s.issueEKCert(c, s.makeSAN(c, set), s.ekPublic.Public(), nil)
+
+	info, err := ParseTPMDeviceInfo(cert)
+	c.Assert(err, IsNil)
+	c.Check(info, DeepEquals, &TPMDeviceInfo{Manufacturer: tpm2.TPMManufacturerSTM, Model: "ST33HTPHAHD4", Version: "id:00010102"})
+}
+
+func (s *ekCertSuite) TestParseTPMDeviceInfoImplicitTag(c *C) {
+	// Some vendors use implicit tagging for the directoryName.
+	name := s.makeDeviceInfoName(c, "id:49424D00")
+	var raw asn1.RawValue
+	_, err := asn1.Unmarshal(name, &raw)
+	c.Assert(err, IsNil)
+	cert := s.issueEKCert(c, s.makeSAN(c, raw.Bytes), s.ekPublic.Public(), nil)
+
+	info, err := ParseTPMDeviceInfo(cert)
+	c.Assert(err, IsNil)
+	c.Check(info.Manufacturer, Equals, tpm2.TPMManufacturerIBM)
+}
+
+func (s *ekCertSuite) TestParseTPMDeviceInfoMissing(c *C) {
+	name, err := asn1.Marshal(pkix.RDNSequence{{{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: "foo"}}})
+	c.Assert(err, IsNil)
+	cert := s.issueEKCert(c, s.makeSAN(c, name), s.ekPublic.Public(), nil)
+
+	_, err = ParseTPMDeviceInfo(cert)
+	c.Check(err, ErrorMatches, "no TPM device attributes in subject alternative name")
+}
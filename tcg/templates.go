@@ -0,0 +1,226 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+/*
+Package tcg provides the standard key templates and NV indices defined by the TCG EK Credential Profile for TPM Family 2.0 and
+the TCG TPM v2.0 Provisioning Guidance, along with helpers for creating endorsement keys and reading their certificates.
+
+An endorsement key can be created and its certificate read with:
+
+	 ek, public, err := tcg.CreateEK(tpm, tcg.EKProfileRSA2048, nil)
+	 if err != nil {
+		...
+	 }
+	 cert, err := tcg.ReadEKCertificate(tpm, tcg.EKProfileRSA2048)
+	 if err != nil {
+		...
+	 }
+
+The certificate can then be verified against the TPM manufacturer's root certificates, which also checks that it certifies the
+endorsement key that was created:
+
+	 info, err := tcg.VerifyEKCertificateForTPM(tpm, cert, public, &tcg.VerifyEKCertificateOptions{Roots: roots})
+*/
+package tcg
+
+import (
+	"encoding/binary"
+
+	"github.com/canonical/go-tpm2"
+)
+
+// Well known persistent handles for the storage and endorsement primary keys, from the TCG TPM v2.0 Provisioning Guidance.
+const (
+	SRKHandle    tpm2.Handle = 0x81000001
+	EKHandleRSA  tpm2.Handle = 0x81010001
+	EKHandleECC  tpm2.Handle = 0x81010002
+	EKHandleBase tpm2.Handle = 0x81010000
+)
+
+const (
+	ekAttrsLowRange  = tpm2.AttrFixedTPM | tpm2.AttrFixedParent | tpm2.AttrSensitiveDataOrigin | tpm2.AttrAdminWithPolicy | tpm2.AttrRestricted | tpm2.AttrDecrypt
+	ekAttrsHighRange = ekAttrsLowRange | tpm2.AttrUserWithAuth
+
+	srkAttrs = tpm2.AttrFixedTPM | tpm2.AttrFixedParent | tpm2.AttrSensitiveDataOrigin | tpm2.AttrUserWithAuth | tpm2.AttrNoDA | tpm2.AttrRestricted | tpm2.AttrDecrypt
+)
+
+var (
+	// PolicyA_SHA256 is the PolicySecret(TPM_RH_ENDORSEMENT) policy digest used by the low range endorsement key templates, and
+	// the PolicyA_SHA256 digest from the EK Credential Profile.
+	PolicyA_SHA256 = computePolicyA(tpm2.HashAlgorithmSHA256)
+
+	// PolicyA_SHA384 is the PolicyA_SHA384 digest from the EK Credential Profile.
+	PolicyA_SHA384 = computePolicyA(tpm2.HashAlgorithmSHA384)
+
+	// PolicyA_SHA512 is the PolicyA_SHA512 digest from the EK Credential Profile.
+	PolicyA_SHA512 = computePolicyA(tpm2.HashAlgorithmSHA512)
+
+	// PolicyB_SHA256 is the PolicyB_SHA256 digest from the EK Credential Profile, which is used by the high range endorsement
+	// key templates with a SHA-256 name algorithm. It is the PolicyOR of PolicyA_SHA256 and PolicyC_SHA256.
+	PolicyB_SHA256 = decodeHexString("ca3d0a99a2b93906f7a3342414efcfb3a385d44cd1fd459089d19b5071c0b7a0")
+
+	// PolicyB_SHA384 is the PolicyB_SHA384 digest from the EK Credential Profile, which is used by the high range endorsement
+	// key templates with a SHA-384 name algorithm.
+	PolicyB_SHA384 = decodeHexString("b26e7d28d11a50bc53d882bcf5fd3a1a074148bb35d3b4e4cb1c0ad9bde419cacb47ba09699646150f9fc000f3f80e12")
+
+	// PolicyB_SHA512 is the PolicyB_SHA512 digest from the EK Credential Profile, which is used by the high range endorsement
+	// key templates with a SHA-512 name algorithm.
+	PolicyB_SHA512 = decodeHexString("b8221ca69e8550a4914de3faa6a18c072cc01208073a928d5d66d59ef79e49a429c41a6b269571d57edb25fbdb1838425608b413cd616a5f6db5b6071af99bea")
+)
+
+func computePolicyA(alg tpm2.HashAlgorithmId) tpm2.Digest {
+	trial, _ := tpm2.ComputeAuthPolicy(alg)
+	name := make(tpm2.Name, binary.Size(tpm2.Handle(0)))
+	binary.BigEndian.PutUint32(name, uint32(tpm2.HandleEndorsement))
+	trial.PolicySecret(name, nil)
+	return trial.GetDigest()
+}
+
+// EKProfile describes an endorsement key template from the TCG EK Credential Profile, along with the NV indices that are
+// associated with it.
+type EKProfile struct {
+	Name string // The name of the template in the EK Credential Profile, eg, "L-1"
+
+	CertificateIndex tpm2.Handle // The NV index at which the EK certificate is stored
+
+	// NonceIndex and TemplateIndex are the NV indices at which a nonce or template provided by the platform manufacturer can be
+	// stored. These only exist for the low range templates, and are zero for the high range templates.
+	NonceIndex    tpm2.Handle
+	TemplateIndex tpm2.Handle
+
+	template func() *tpm2.Public
+}
+
+// Template returns a new copy of the default template for this profile. It does not take in to account any nonce or template
+// that may be supplied by the platform manufacturer - see EKTemplateFromTPM.
+func (p *EKProfile) Template() *tpm2.Public {
+	return p.template()
+}
+
+// LowRange indicates whether this is a low range template, which may be customized by the platform manufacturer with a nonce or
+// template stored in NV.
+func (p *EKProfile) LowRange() bool {
+	return p.NonceIndex != 0
+}
+
+func rsaTemplate(nameAlg tpm2.HashAlgorithmId, attrs tpm2.ObjectAttributes, authPolicy tpm2.Digest, symKeyBits, keyBits uint16, unique tpm2.PublicKeyRSA) *tpm2.Public {
+	return &tpm2.Public{
+		Type:       tpm2.ObjectTypeRSA,
+		NameAlg:    nameAlg,
+		Attrs:      attrs,
+		AuthPolicy: append(tpm2.Digest(nil), authPolicy...),
+		Params: &tpm2.PublicParamsU{
+			RSADetail: &tpm2.RSAParams{
+				Symmetric: tpm2.SymDefObject{
+					Algorithm: tpm2.SymObjectAlgorithmAES,
+					KeyBits:   &tpm2.SymKeyBitsU{Sym: symKeyBits},
+					Mode:      &tpm2.SymModeU{Sym: tpm2.SymModeCFB}},
+				Scheme:   tpm2.RSAScheme{Scheme: tpm2.RSASchemeNull},
+				KeyBits:  keyBits,
+				Exponent: 0}},
+		Unique: &tpm2.PublicIDU{RSA: unique}}
+}
+
+func eccTemplate(nameAlg tpm2.HashAlgorithmId, attrs tpm2.ObjectAttributes, authPolicy tpm2.Digest, symKeyBits uint16, curve tpm2.ECCCurve, unique *tpm2.ECCPoint) *tpm2.Public {
+	return &tpm2.Public{
+		Type:       tpm2.ObjectTypeECC,
+		NameAlg:    nameAlg,
+		Attrs:      attrs,
+		AuthPolicy: append(tpm2.Digest(nil), authPolicy...),
+		Params: &tpm2.PublicParamsU{
+			ECCDetail: &tpm2.ECCParams{
+				Symmetric: tpm2.SymDefObject{
+					Algorithm: tpm2.SymObjectAlgorithmAES,
+					KeyBits:   &tpm2.SymKeyBitsU{Sym: symKeyBits},
+					Mode:      &tpm2.SymModeU{Sym: tpm2.SymModeCFB}},
+				Scheme:  tpm2.ECCScheme{Scheme: tpm2.ECCSchemeNull},
+				CurveID: curve,
+				KDF:     tpm2.KDFScheme{Scheme: tpm2.KDFAlgorithmNull}}},
+		Unique: &tpm2.PublicIDU{ECC: unique}}
+}
+
+// Endorsement key profiles from the TCG EK Credential Profile for TPM Family 2.0, Level 0, Version 2.3.
+var (
+	// EKProfileRSA2048 corresponds to the RSA 2048 low range template (L-1).
+	EKProfileRSA2048 = &EKProfile{
+		Name:             "L-1",
+		CertificateIndex: 0x01c00002,
+		NonceIndex:       0x01c00003,
+		TemplateIndex:    0x01c00004,
+		template: func() *tpm2.Public {
+			return rsaTemplate(tpm2.HashAlgorithmSHA256, ekAttrsLowRange, PolicyA_SHA256, 128, 2048, make(tpm2.PublicKeyRSA, 256))
+		}}
+
+	// EKProfileECCP256 corresponds to the ECC NIST P256 low range template (L-2).
+	EKProfileECCP256 = &EKProfile{
+		Name:             "L-2",
+		CertificateIndex: 0x01c0000a,
+		NonceIndex:       0x01c0000b,
+		TemplateIndex:    0x01c0000c,
+		template: func() *tpm2.Public {
+			return eccTemplate(tpm2.HashAlgorithmSHA256, ekAttrsLowRange, PolicyA_SHA256, 128, tpm2.ECCCurveNIST_P256,
+				&tpm2.ECCPoint{X: make(tpm2.ECCParameter, 32), Y: make(tpm2.ECCParameter, 32)})
+		}}
+
+	// EKProfileRSA2048HighRange corresponds to the RSA 2048 high range template (H-1).
+	EKProfileRSA2048HighRange = &EKProfile{
+		Name:             "H-1",
+		CertificateIndex: 0x01c00012,
+		template: func() *tpm2.Public {
+			return rsaTemplate(tpm2.HashAlgorithmSHA256, ekAttrsHighRange, PolicyB_SHA256, 128, 2048, nil)
+		}}
+
+	// EKProfileECCP256HighRange corresponds to the ECC NIST P256 high range template (H-2).
+	EKProfileECCP256HighRange = &EKProfile{
+		Name:             "H-2",
+		CertificateIndex: 0x01c00014,
+		template: func() *tpm2.Public {
+			return eccTemplate(tpm2.HashAlgorithmSHA256, ekAttrsHighRange, PolicyB_SHA256, 128, tpm2.ECCCurveNIST_P256, &tpm2.ECCPoint{})
+		}}
+
+	// EKProfileECCP384 corresponds to the ECC NIST P384 high range template (H-3).
+	EKProfileECCP384 = &EKProfile{
+		Name:             "H-3",
+		CertificateIndex: 0x01c00016,
+		template: func() *tpm2.Public {
+			return eccTemplate(tpm2.HashAlgorithmSHA384, ekAttrsHighRange, PolicyB_SHA384, 256, tpm2.ECCCurveNIST_P384, &tpm2.ECCPoint{})
+		}}
+
+	// EKProfileECCP521 corresponds to the ECC NIST P521 high range template (H-4).
+	EKProfileECCP521 = &EKProfile{
+		Name:             "H-4",
+		CertificateIndex: 0x01c00018,
+		template: func() *tpm2.Public {
+			return eccTemplate(tpm2.HashAlgorithmSHA512, ekAttrsHighRange, PolicyB_SHA512, 256, tpm2.ECCCurveNIST_P521, &tpm2.ECCPoint{})
+		}}
+
+	// EKProfileRSA3072 corresponds to the RSA 3072 high range template (H-6).
+	EKProfileRSA3072 = &EKProfile{
+		Name:             "H-6",
+		CertificateIndex: 0x01c0001c,
+		template: func() *tpm2.Public {
+			return rsaTemplate(tpm2.HashAlgorithmSHA384, ekAttrsHighRange, PolicyB_SHA384, 256, 3072, nil)
+		}}
+)
+
+// EKProfiles contains all of the supported endorsement key profiles, starting with the low range profiles.
+var EKProfiles = []*EKProfile{
+	EKProfileRSA2048,
+	EKProfileECCP256,
+	EKProfileRSA2048HighRange,
+	EKProfileECCP256HighRange,
+	EKProfileECCP384,
+	EKProfileECCP521,
+	EKProfileRSA3072}
+
+// SRKTemplateRSA2048 returns a new copy of the RSA 2048 storage primary key template from the TCG TPM v2.0 Provisioning Guidance.
+func SRKTemplateRSA2048() *tpm2.Public {
+	return rsaTemplate(tpm2.HashAlgorithmSHA256, srkAttrs, nil, 128, 2048, nil)
+}
+
+// SRKTemplateECCP256 returns a new copy of the ECC NIST P256 storage primary key template from the TCG TPM v2.0 Provisioning
+// Guidance.
+func SRKTemplateECCP256() *tpm2.Public {
+	return eccTemplate(tpm2.HashAlgorithmSHA256, srkAttrs, nil, 128, tpm2.ECCCurveNIST_P256, &tpm2.ECCPoint{})
+}
@@ -0,0 +1,150 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tcg_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+	. "github.com/canonical/go-tpm2/tcg"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+func init() {
+	testutil.AddCommandLineFlags()
+}
+
+func Test(t *testing.T) { TestingT(t) }
+
+type tcgSuite struct {
+	testutil.TPMTest
+}
+
+// definePlatformIndex defines a NV index in the same way as a platform manufacturer would for EK certificates, templates and
+// nonces, and writes the supplied data to it.
+func (s *tcgSuite) definePlatformIndex(c *C, handle tpm2.Handle, data []byte, size uint16) {
+	pub := tpm2.NVPublic{
+		Index:   handle,
+		NameAlg: tpm2.HashAlgorithmSHA256,
+		Attrs: tpm2.NVTypeOrdinary.WithAttrs(tpm2.AttrNVPPWrite | tpm2.AttrNVPPRead | tpm2.AttrNVOwnerRead | tpm2.AttrNVAuthRead |
+			tpm2.AttrNVNoDA | tpm2.AttrNVPlatformCreate),
+		Size: size}
+	index, err := s.TPM.NVDefineSpace(s.TPM.PlatformHandleContext(), nil, &pub, nil)
+	c.Assert(err, IsNil)
+	s.AddCleanupNVSpace(c, s.TPM.PlatformHandleContext(), index)
+	c.Assert(s.TPM.NVWrite(s.TPM.PlatformHandleContext(), index, data, 0, nil), IsNil)
+}
+
+// checkPublicEquals checks that the supplied public areas are equal. These are compared in their marshalled form because the
+// null schemes in the templates have no details, but are unmarshalled with empty details.
+func (s *tcgSuite) checkPublicEquals(c *C, public, expected *tpm2.Public) {
+	a, err := mu.MarshalToBytes(public)
+	c.Assert(err, IsNil)
+	b, err := mu.MarshalToBytes(expected)
+	c.Assert(err, IsNil)
+	c.Check(a, DeepEquals, b)
+}
+
+var _ = Suite(&tcgSuite{TPMTest: testutil.TPMTest{
+	TPMFeatures: testutil.TPMFeatureOwnerHierarchy | testutil.TPMFeatureEndorsementHierarchy | testutil.TPMFeaturePlatformPersist}})
+
+func (s *tcgSuite) TestPolicyA(c *C) {
+	c.Check(PolicyA_SHA256, DeepEquals, tpm2.Digest(testutil.DecodeHexString(c, "837197674484b3f81a90cc8d46a5d724fd52d76e06520b64f2a1da1b331469aa")))
+}
+
+func (s *tcgSuite) TestTemplateIsCopy(c *C) {
+	template := EKProfileRSA2048.Template()
+	template.Unique.RSA[0] = 1
+	c.Check(EKProfileRSA2048.Template().Unique.RSA[0], Equals, uint8(0))
+}
+
+func (s *tcgSuite) testCreateEK(c *C, profile *EKProfile) {
+	ek, public, err := CreateEK(s.TPM, profile, nil)
+	c.Assert(err, IsNil)
+	defer s.TPM.FlushContext(ek)
+
+	// The unique field contains the public key, so only compare the other fields.
+	template := profile.Template()
+	template.Unique = public.Unique
+	s.checkPublicEquals(c, public, template)
+}
+
+func (s *tcgSuite) TestCreateEKRSA2048(c *C) {
+	s.testCreateEK(c, EKProfileRSA2048)
+}
+
+func (s *tcgSuite) TestCreateEKECCP256(c *C) {
+	s.testCreateEK(c, EKProfileECCP256)
+}
+
+func (s *tcgSuite) TestCreateEKECCP384(c *C) {
+	s.testCreateEK(c, EKProfileECCP384)
+}
+
+func (s *tcgSuite) TestEKTemplateFromTPMWithNonce(c *C) {
+	nonce := []byte("1234567890")
+	s.definePlatformIndex(c, EKProfileECCP256.NonceIndex, nonce, uint16(len(nonce)))
+
+	template, err := EKTemplateFromTPM(s.TPM, EKProfileECCP256)
+	c.Assert(err, IsNil)
+
+	expected := EKProfileECCP256.Template()
+	copy(expected.Unique.ECC.X, nonce)
+	c.Check(template, DeepEquals, expected)
+}
+
+func (s *tcgSuite) TestEKTemplateFromTPMWithTemplate(c *C) {
+	expected := EKProfileRSA2048.Template()
+	expected.Attrs |= tpm2.AttrUserWithAuth
+	data, err := mu.MarshalToBytes(expected)
+	c.Assert(err, IsNil)
+	s.definePlatformIndex(c, EKProfileRSA2048.TemplateIndex, data, uint16(len(data)))
+
+	template, err := EKTemplateFromTPM(s.TPM, EKProfileRSA2048)
+	c.Assert(err, IsNil)
+	s.checkPublicEquals(c, template, expected)
+}
+
+func (s *tcgSuite) TestReadEKCertificate(c *C) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	c.Assert(err, IsNil)
+
+	// Make the index larger than the certificate to check that the padding is removed.
+	s.definePlatformIndex(c, EKProfileECCP256.CertificateIndex, der, uint16(len(der)+32))
+
+	cert, err := ReadEKCertificate(s.TPM, EKProfileECCP256)
+	c.Assert(err, IsNil)
+	c.Check(cert.Raw, DeepEquals, der)
+}
+
+func (s *tcgSuite) TestReadEKCertificateMissing(c *C) {
+	_, err := ReadEKCertificate(s.TPM, EKProfileRSA2048)
+	c.Check(tpm2.IsResourceUnavailableError(err, EKProfileRSA2048.CertificateIndex), testutil.IsTrue)
+}
+
+func (s *tcgSuite) TestSRKTemplates(c *C) {
+	for _, template := range []*tpm2.Public{SRKTemplateRSA2048(), SRKTemplateECCP256()} {
+		srk, _, _, _, _, err := s.TPM.CreatePrimary(s.TPM.OwnerHandleContext(), nil, template, nil, nil, nil)
+		c.Assert(err, IsNil)
+		c.Check(s.TPM.FlushContext(srk), IsNil)
+	}
+}
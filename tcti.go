@@ -6,6 +6,7 @@ package tpm2
 
 import (
 	"io"
+	"time"
 )
 
 // XXX: Note that the "TCG TSS 2.0 TPM Command Transmission Interface (TCTI) API Specification"
@@ -13,11 +14,8 @@ import (
 // - transmit, which is equivalent to io.Writer.
 // - receive, which is equivalent to io.Reader.
 // - finalize, which is equivalent to io.Closer.
-// - cancel, which we don't implement at the moment because there's not a mechanism to cancel
-//   an operation in go-tpm2. Perhaps if we move the call to Read() to a separate go routine and pass
-//   a context around, we could cancel using a deadline. Not today though, and the Linux character
-//   device driver doesn't provide a mechanism to cancel so it probably wouldn't be worth the effort
-//   anyway.
+// - cancel, which is implemented by the optional TCTICanceler interface and used by TPMContext when
+//   the context.Context associated with a command is done.
 // - getPollHandles, doesn't really make sense here because go's runtime does the polling on
 //   Read.
 // - setLocality.
@@ -34,3 +32,25 @@ type TCTI interface {
 	// associated with the supplied handle between commands.
 	MakeSticky(handle Handle, sticky bool) error
 }
+
+// TCTICanceler is an optional interface implemented by TCTI implementations that are able to request that the TPM cancels the
+// command that is currently executing. It is used by TPMContext when the context.Context associated with a command is done
+// before the command completes (see TPMContext.WithContext).
+type TCTICanceler interface {
+	// Cancel requests that the TPM cancels the command that is currently executing. This may be called from a different
+	// goroutine to the one that is reading the response. The TPM may still complete the command successfully if it is too late
+	// to cancel it, else it will respond with a TPM_RC_CANCELED warning. Either way, the response must still be read. An error
+	// should be returned if the request could not be made.
+	Cancel() error
+}
+
+// TCTIReadDeadliner is an optional interface implemented by TCTI implementations that support a deadline for reading a response.
+// It is used by TPMContext to abandon a command when the context.Context associated with it is done and the command cannot
+// be cancelled with TCTICanceler (see TPMContext.WithContext).
+type TCTIReadDeadliner interface {
+	// SetReadDeadline sets the deadline for future and currently blocked calls to Read. A zero value for t means that Read will
+	// not time out. This may be called from a different goroutine to the one that is reading the response. Once the deadline
+	// is exceeded, calls to Read should return an error that implements a Timeout method that returns true. The TCTI must
+	// remain usable after this in order for a subsequent response to be read.
+	SetReadDeadline(t time.Time) error
+}
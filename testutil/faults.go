@@ -0,0 +1,289 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package testutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/internal"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+// Fault describes a type of fault injected by FaultInjectionTCTI.
+type Fault int
+
+const (
+	// FaultShortWrite causes the write of a command to fail with io.ErrShortWrite after only half of it has been written. The
+	// command is not submitted to the TPM.
+	FaultShortWrite Fault = iota
+
+	// FaultShortRead causes the response to be returned in chunks of FaultRule.Size bytes (or 1 byte if Size is zero), so that
+	// every call to Read returns fewer bytes than requested. This is not an error, but it exercises the code that reassembles
+	// responses.
+	FaultShortRead
+
+	// FaultTruncateResponse causes the last FaultRule.Size bytes (or half of the response if Size is zero) to be removed from
+	// the response, without updating the size field in the response header.
+	FaultTruncateResponse
+
+	// FaultCorruptResponseHMAC causes the last byte of a successful response with an authorization area to be modified. This
+	// is the last byte of the HMAC of the last session.
+	FaultCorruptResponseHMAC
+
+	// FaultResponseCode causes the command to not be submitted to the TPM, and a response with no parameters and a response
+	// code of FaultRule.ResponseCode is returned instead. This is typically used with one of the warnings that a TPM may
+	// return for a command that has not been executed, such as tpm2.WarningRetry, tpm2.WarningYielded, tpm2.WarningTesting
+	// or tpm2.WarningNVRate.
+	FaultResponseCode
+
+	// FaultDelay causes the command to be delayed by FaultRule.Delay before it is submitted to the TPM.
+	FaultDelay
+)
+
+// FaultRule describes when and how FaultInjectionTCTI injects a fault.
+type FaultRule struct {
+	// Command is the command code that the rule applies to. Use tpm2.AnyCommandCode for a rule that applies to every command.
+	Command tpm2.CommandCode
+
+	Fault Fault
+
+	// Skip is the number of matching commands that the rule ignores before it starts injecting faults.
+	Skip int
+
+	// Count is the number of times that the rule injects a fault before it is exhausted. If this is zero, the rule injects a
+	// fault for every matching command.
+	Count int
+
+	ResponseCode tpm2.ResponseCode // The response code for FaultResponseCode
+	Delay        time.Duration     // The delay for FaultDelay
+	Size         int               // The chunk size for FaultShortRead, or the number of bytes removed by FaultTruncateResponse
+
+	matched int
+	fired   int
+}
+
+// match indicates whether the rule should inject a fault for the supplied command code, updating its counters if so.
+func (r *FaultRule) match(code tpm2.CommandCode) bool {
+	if r.Command != tpm2.AnyCommandCode && r.Command != code {
+		return false
+	}
+	r.matched++
+	if r.matched <= r.Skip {
+		return false
+	}
+	if r.Count > 0 && r.fired >= r.Count {
+		return false
+	}
+	r.fired++
+	return true
+}
+
+// FaultInjectionTCTI is a TCTI that wraps another TCTI in order to inject transport and TPM faults deterministically, based
+// on a set of rules keyed on the command code. It is used to test that code behaves correctly when the TPM or the transport
+// misbehaves.
+//
+// Rules are evaluated in the order in which they are supplied, and every rule that matches a command is applied. Faults that
+// prevent a command from being submitted to the TPM (FaultShortWrite and FaultResponseCode) take precedence over faults that
+// modify the response.
+type FaultInjectionTCTI struct {
+	tcti tpm2.TCTI
+
+	mu       sync.Mutex
+	rules    []*FaultRule
+	injected int
+
+	cmd      []byte
+	faults   []*FaultRule // Faults to apply to the response of the current command
+	buffered bool         // The response is being returned from rsp
+	rsp      *bytes.Reader
+	chunk    int
+}
+
+// NewFaultInjectionTCTI returns a new FaultInjectionTCTI that injects faults according to the supplied rules into commands
+// sent to and responses received from the supplied TCTI. The returned FaultInjectionTCTI takes ownership of the supplied TCTI,
+// and will close it when it is closed.
+func NewFaultInjectionTCTI(tcti tpm2.TCTI, rules ...*FaultRule) *FaultInjectionTCTI {
+	return &FaultInjectionTCTI{tcti: tcti, rules: rules}
+}
+
+// AddRule adds a rule to the end of the rule set.
+func (t *FaultInjectionTCTI) AddRule(rule *FaultRule) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rules = append(t.rules, rule)
+}
+
+// ClearRules removes all of the rules, so that no more faults are injected.
+func (t *FaultInjectionTCTI) ClearRules() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rules = nil
+}
+
+// Injected returns the number of faults that have been injected.
+func (t *FaultInjectionTCTI) Injected() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.injected
+}
+
+// readResponse reads a complete response from the underlying TCTI.
+func (t *FaultInjectionTCTI) readResponse() ([]byte, error) {
+	var rsp []byte
+	buf := make([]byte, 4096)
+	for !internal.IsCompletePacket(rsp) {
+		n, err := t.tcti.Read(buf)
+		rsp = append(rsp, buf[:n]...)
+		switch {
+		case err == io.EOF && n == 0:
+			return rsp, nil
+		case err == io.EOF:
+		case err != nil:
+			return nil, err
+		}
+	}
+	return rsp, nil
+}
+
+func (t *FaultInjectionTCTI) applyResponseFaults(rsp []byte) []byte {
+	for _, f := range t.faults {
+		switch f.Fault {
+		case FaultShortRead:
+			t.chunk = f.Size
+			if t.chunk == 0 {
+				t.chunk = 1
+			}
+		case FaultTruncateResponse:
+			n := f.Size
+			if n == 0 || n > len(rsp) {
+				n = len(rsp) / 2
+			}
+			rsp = rsp[:len(rsp)-n]
+		case FaultCorruptResponseHMAC:
+			var hdr struct {
+				Tag          tpm2.StructTag
+				ResponseSize uint32
+				ResponseCode tpm2.ResponseCode
+			}
+			if _, err := mu.UnmarshalFromBytes(rsp, &hdr); err != nil {
+				break
+			}
+			if hdr.Tag == tpm2.TagSessions && hdr.ResponseCode == tpm2.ResponseCode(tpm2.Success) {
+				rsp = append([]byte(nil), rsp...)
+				rsp[len(rsp)-1] ^= 0xff
+			}
+		}
+	}
+	return rsp
+}
+
+func (t *FaultInjectionTCTI) Read(data []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.buffered {
+		if len(t.faults) == 0 {
+			return t.tcti.Read(data)
+		}
+		rsp, err := t.readResponse()
+		if err != nil {
+			return 0, err
+		}
+		t.rsp = bytes.NewReader(t.applyResponseFaults(rsp))
+		t.buffered = true
+	}
+
+	if t.chunk > 0 && len(data) > t.chunk {
+		data = data[:t.chunk]
+	}
+	return t.rsp.Read(data)
+}
+
+func (t *FaultInjectionTCTI) Write(data []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.cmd = append(t.cmd, data...)
+	if !internal.IsCompletePacket(t.cmd) {
+		return len(data), nil
+	}
+
+	cmd := t.cmd
+	t.cmd = nil
+	t.faults = nil
+	t.buffered = false
+	t.rsp = nil
+	t.chunk = 0
+
+	code := tpm2.CommandCode(binary.BigEndian.Uint32(cmd[6:]))
+
+	var delay time.Duration
+	var rc *tpm2.ResponseCode
+	shortWrite := false
+
+	for _, r := range t.rules {
+		if !r.match(code) {
+			continue
+		}
+		t.injected++
+		switch r.Fault {
+		case FaultShortWrite:
+			shortWrite = true
+		case FaultResponseCode:
+			if rc == nil {
+				rc = &r.ResponseCode
+			}
+		case FaultDelay:
+			delay += r.Delay
+		default:
+			t.faults = append(t.faults, r)
+		}
+	}
+
+	time.Sleep(delay)
+
+	switch {
+	case shortWrite:
+		t.faults = nil
+		return len(data) / 2, io.ErrShortWrite
+	case rc != nil:
+		rsp, _ := mu.MarshalToBytes(tpm2.TagNoSessions, uint32(10), *rc)
+		t.faults = nil
+		t.rsp = bytes.NewReader(rsp)
+		t.buffered = true
+		return len(data), nil
+	}
+
+	if _, err := t.tcti.Write(cmd); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (t *FaultInjectionTCTI) Close() error {
+	return t.tcti.Close()
+}
+
+func (t *FaultInjectionTCTI) SetLocality(locality uint8) error {
+	return t.tcti.SetLocality(locality)
+}
+
+func (t *FaultInjectionTCTI) MakeSticky(handle tpm2.Handle, sticky bool) error {
+	return t.tcti.MakeSticky(handle, sticky)
+}
+
+func (t *FaultInjectionTCTI) SetReadDeadline(deadline time.Time) error {
+	d, ok := t.tcti.(tpm2.TCTIReadDeadliner)
+	if !ok {
+		return errors.New("read deadlines are not supported by the underlying TCTI")
+	}
+	return d.SetReadDeadline(deadline)
+}
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/canonical/go-tpm2"
 	"github.com/canonical/go-tpm2/mu"
@@ -23,6 +24,8 @@ func commandMayModifyPersistentStorage(command tpm2.CommandCode) bool {
 		return true
 	case tpm2.CommandClear:
 		return true
+	case tpm2.CommandFieldUpgradeStart, tpm2.CommandFieldUpgradeData:
+		return true
 	default:
 		return false
 	}
@@ -70,6 +73,7 @@ var numberOfCommandAuthHandles = map[tpm2.CommandCode]int{
 	tpm2.CommandCertify:                    2,
 	tpm2.CommandPolicyNV:                   1, // 3 handles total
 	tpm2.CommandCertifyCreation:            1, // 2 handles total
+	tpm2.CommandCertifyX509:                2,
 	tpm2.CommandDuplicate:                  1, // 2 handles total
 	tpm2.CommandGetTime:                    2,
 	tpm2.CommandGetSessionAuditDigest:      2, // 3 handles total
@@ -119,6 +123,14 @@ var numberOfCommandAuthHandles = map[tpm2.CommandCode]int{
 	tpm2.CommandPolicyPassword:             0, // 1 handle total
 	tpm2.CommandPolicyNvWritten:            0, // 1 handle total
 	tpm2.CommandCreateLoaded:               1,
+	tpm2.CommandSetAlgorithmSet:            1,
+	tpm2.CommandACTSetTimeout:              1,
+	tpm2.CommandFieldUpgradeStart:          1, // 2 handles total
+	tpm2.CommandFieldUpgradeData:           0,
+	tpm2.CommandFirmwareRead:               0,
+	tpm2.CommandACGetCapability:            0, // 1 handle total
+	tpm2.CommandACSend:                     2, // 3 handles total
+	tpm2.CommandPolicyACSendSelect:         0, // 1 handle total
 }
 
 type commandHeader struct {
@@ -172,7 +184,11 @@ func (t *tctiFilter) Write(data []byte) (int, error) {
 
 	numHandles, ok := numberOfCommandAuthHandles[h.CommandCode]
 	if !ok {
-		return 0, errors.New("unsupported command")
+		cmd, ok := tpm2.LookupVendorCommand(h.CommandCode)
+		if !ok {
+			return 0, errors.New("unsupported command")
+		}
+		numHandles = cmd.NumAuthHandles
 	}
 
 	for i := 0; i < numHandles; i++ {
@@ -212,3 +228,11 @@ func (t *tctiFilter) SetLocality(locality uint8) error {
 func (t *tctiFilter) MakeSticky(handle tpm2.Handle, sticky bool) error {
 	return t.tcti.MakeSticky(handle, sticky)
 }
+
+func (t *tctiFilter) SetReadDeadline(deadline time.Time) error {
+	d, ok := t.tcti.(tpm2.TCTIReadDeadliner)
+	if !ok {
+		return errors.New("read deadlines are not supported by the underlying TCTI")
+	}
+	return d.SetReadDeadline(deadline)
+}
@@ -0,0 +1,277 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package testutil
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/internal"
+
+	"golang.org/x/xerrors"
+)
+
+// Recordings are text files with one entry per line. Commands are prefixed with "> ", responses are prefixed with "< " and
+// locality changes are prefixed with "locality ". Commands and responses are hex encoded. Empty lines and lines starting
+// with "#" are ignored.
+const (
+	recordCommandPrefix  = "> "
+	recordResponsePrefix = "< "
+	recordLocalityPrefix = "locality "
+)
+
+// RecordingTCTI is a TCTI that wraps another TCTI in order to record every command and response to a file, so that the
+// sequence can be replayed later with ReplayTCTI.
+//
+// Commands sent by TPMContext contain nonces and other values that are generated randomly. In order for a recording to be
+// replayed successfully, a deterministic source of random bytes should be configured with tpm2.SetRandomReader (see
+// NewDeterministicRandomReader) both when recording and when replaying.
+type RecordingTCTI struct {
+	tcti tpm2.TCTI
+	f    *os.File
+	w    *bufio.Writer
+
+	cmd []byte
+	rsp []byte
+}
+
+// NewRecordingTCTI returns a new RecordingTCTI that records commands sent to and responses received from the supplied TCTI
+// to the file at the specified path, which will be created or truncated. The returned RecordingTCTI takes ownership of the
+// supplied TCTI, and will close it when it is closed.
+func NewRecordingTCTI(tcti tpm2.TCTI, path string) (*RecordingTCTI, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot create recording: %w", err)
+	}
+	return &RecordingTCTI{tcti: tcti, f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (t *RecordingTCTI) record(prefix, data string) error {
+	if _, err := fmt.Fprintf(t.w, "%s%s\n", prefix, data); err != nil {
+		return xerrors.Errorf("cannot write to recording: %w", err)
+	}
+	if err := t.w.Flush(); err != nil {
+		return xerrors.Errorf("cannot write to recording: %w", err)
+	}
+	return nil
+}
+
+func (t *RecordingTCTI) Read(data []byte) (int, error) {
+	n, err := t.tcti.Read(data)
+	t.rsp = append(t.rsp, data[:n]...)
+	if internal.IsCompletePacket(t.rsp) {
+		if err := t.record(recordResponsePrefix, hex.EncodeToString(t.rsp)); err != nil {
+			return n, err
+		}
+		t.rsp = nil
+	}
+	return n, err
+}
+
+func (t *RecordingTCTI) Write(data []byte) (int, error) {
+	t.rsp = nil
+	t.cmd = append(t.cmd, data...)
+	if internal.IsCompletePacket(t.cmd) {
+		if err := t.record(recordCommandPrefix, hex.EncodeToString(t.cmd)); err != nil {
+			return 0, err
+		}
+		t.cmd = nil
+	}
+	return t.tcti.Write(data)
+}
+
+func (t *RecordingTCTI) Close() error {
+	if err := t.f.Close(); err != nil {
+		t.tcti.Close()
+		return xerrors.Errorf("cannot close recording: %w", err)
+	}
+	return t.tcti.Close()
+}
+
+func (t *RecordingTCTI) SetLocality(locality uint8) error {
+	if err := t.tcti.SetLocality(locality); err != nil {
+		return err
+	}
+	return t.record(recordLocalityPrefix, strconv.Itoa(int(locality)))
+}
+
+func (t *RecordingTCTI) MakeSticky(handle tpm2.Handle, sticky bool) error {
+	return t.tcti.MakeSticky(handle, sticky)
+}
+
+func (t *RecordingTCTI) SetReadDeadline(deadline time.Time) error {
+	d, ok := t.tcti.(tpm2.TCTIReadDeadliner)
+	if !ok {
+		return errors.New("read deadlines are not supported by the underlying TCTI")
+	}
+	return d.SetReadDeadline(deadline)
+}
+
+type recordEntry struct {
+	line     int
+	prefix   string
+	data     []byte
+	locality uint8
+}
+
+// ReplayTCTI is a TCTI that replays a sequence of commands and responses recorded with RecordingTCTI, without requiring a
+// TPM. Each command written to it must match the next command in the recording, and the recorded response is returned. If a
+// command does not match the recording, the write fails with an error that describes the divergence.
+type ReplayTCTI struct {
+	entries []recordEntry
+	next    int
+
+	cmd []byte
+	rsp *bytes.Reader
+}
+
+// NewReplayTCTI returns a new ReplayTCTI that replays the recording from the file at the specified path.
+func NewReplayTCTI(path string) (*ReplayTCTI, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot open recording: %w", err)
+	}
+	defer f.Close()
+
+	var entries []recordEntry
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, recordCommandPrefix), strings.HasPrefix(line, recordResponsePrefix):
+			data, err := hex.DecodeString(line[2:])
+			if err != nil {
+				return nil, xerrors.Errorf("cannot decode line %d of recording: %w", n, err)
+			}
+			entries = append(entries, recordEntry{line: n, prefix: line[:2], data: data})
+		case strings.HasPrefix(line, recordLocalityPrefix):
+			locality, err := strconv.ParseUint(line[len(recordLocalityPrefix):], 10, 8)
+			if err != nil {
+				return nil, xerrors.Errorf("cannot decode line %d of recording: %w", n, err)
+			}
+			entries = append(entries, recordEntry{line: n, prefix: recordLocalityPrefix, locality: uint8(locality)})
+		default:
+			return nil, fmt.Errorf("invalid entry on line %d of recording", n)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, xerrors.Errorf("cannot read recording: %w", err)
+	}
+
+	return &ReplayTCTI{entries: entries}, nil
+}
+
+func (t *ReplayTCTI) nextEntry(prefix string) (*recordEntry, error) {
+	if t.next >= len(t.entries) {
+		return nil, errors.New("recording has no more entries")
+	}
+	e := &t.entries[t.next]
+	if e.prefix != prefix {
+		return nil, fmt.Errorf("recording diverges at line %d: unexpected %q", e.line, strings.TrimSpace(prefix))
+	}
+	t.next++
+	return e, nil
+}
+
+func (t *ReplayTCTI) Read(data []byte) (int, error) {
+	if t.rsp == nil {
+		return 0, io.EOF
+	}
+	return t.rsp.Read(data)
+}
+
+func (t *ReplayTCTI) Write(data []byte) (int, error) {
+	t.rsp = nil
+	t.cmd = append(t.cmd, data...)
+	if !internal.IsCompletePacket(t.cmd) {
+		return len(data), nil
+	}
+
+	cmd := t.cmd
+	t.cmd = nil
+
+	e, err := t.nextEntry(recordCommandPrefix)
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(cmd, e.data) {
+		return 0, fmt.Errorf("recording diverges at line %d: unexpected command %x (expected %x)", e.line, cmd, e.data)
+	}
+
+	e, err = t.nextEntry(recordResponsePrefix)
+	if err != nil {
+		return 0, err
+	}
+	t.rsp = bytes.NewReader(e.data)
+	return len(data), nil
+}
+
+// Close implements tpm2.TCTI.Close. It returns an error if the entire recording has not been replayed.
+func (t *ReplayTCTI) Close() error {
+	if t.next < len(t.entries) {
+		return fmt.Errorf("recording was not fully replayed (next entry is on line %d)", t.entries[t.next].line)
+	}
+	return nil
+}
+
+func (t *ReplayTCTI) SetLocality(locality uint8) error {
+	e, err := t.nextEntry(recordLocalityPrefix)
+	if err != nil {
+		return err
+	}
+	if e.locality != locality {
+		return fmt.Errorf("recording diverges at line %d: unexpected locality %d (expected %d)", e.line, locality, e.locality)
+	}
+	return nil
+}
+
+func (t *ReplayTCTI) MakeSticky(handle tpm2.Handle, sticky bool) error {
+	return errors.New("not implemented")
+}
+
+type deterministicRandomReader struct {
+	seed    []byte
+	counter uint64
+	buf     []byte
+}
+
+func (r *deterministicRandomReader) Read(data []byte) (int, error) {
+	n := 0
+	for n < len(data) {
+		if len(r.buf) == 0 {
+			h := sha256.New()
+			h.Write(r.seed)
+			binary.Write(h, binary.BigEndian, r.counter)
+			r.counter++
+			r.buf = h.Sum(nil)
+		}
+		c := copy(data[n:], r.buf)
+		r.buf = r.buf[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// NewDeterministicRandomReader returns an io.Reader that produces a deterministic stream of bytes derived from the supplied
+// seed. It can be passed to tpm2.SetRandomReader so that the commands sent by a TPMContext are reproducible, which is
+// required in order to replay a recording made with RecordingTCTI. It must not be used outside of tests.
+func NewDeterministicRandomReader(seed []byte) io.Reader {
+	return &deterministicRandomReader{seed: seed}
+}
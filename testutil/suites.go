@@ -16,7 +16,7 @@ var (
 
 // BaseTest is a base test suite for all tests.
 type BaseTest struct {
-	cleanupHandlers []func()
+	cleanupHandlers []func(c *C)
 }
 
 func (b *BaseTest) SetUpTest(c *C) {
@@ -30,11 +30,19 @@ func (b *BaseTest) TearDownTest(c *C) {
 		l := len(b.cleanupHandlers)
 		fn := b.cleanupHandlers[l-1]
 		b.cleanupHandlers = b.cleanupHandlers[:l-1]
-		fn()
+		fn(c)
 	}
 }
 
+// AddCleanup queues a function to be called at the end of the test.
 func (b *BaseTest) AddCleanup(fn func()) {
+	b.AddFixtureCleanup(func(_ *C) { fn() })
+}
+
+// AddFixtureCleanup queues a function to be called at the end of the test. The function is passed the *C for TearDownTest, so
+// this should be used instead of AddCleanup for cleanup functions that are registered from SetUpTest and that report failures -
+// failures reported with the *C for SetUpTest after it has completed are discarded.
+func (b *BaseTest) AddFixtureCleanup(fn func(c *C)) {
 	b.cleanupHandlers = append(b.cleanupHandlers, fn)
 }
 
@@ -42,18 +50,24 @@ func (b *BaseTest) AddCleanup(fn func()) {
 // creation of the TPMContext themselves. This base test suite will take care of cleaning up all
 // flushable resources (transient objects and sessions) at the end of each test, as well as closing
 // the supplied TPMContext.
+//
+// A test will fail if any transient objects, sequence objects or sessions created by the TPMContext
+// during the test have not been flushed by the end of it, unless PermitLeakedResources is set. Leaked
+// resources are flushed either way.
 type TPMTestBase struct {
 	BaseTest
 	TPM  *tpm2.TPMContext // Not anonymous because of TestParms. Should be set before SetUpTest is called
 	TCTI tpm2.TCTI        // Should be set before SetUpTest is called
+
+	PermitLeakedResources bool // Set to only log resources that are not flushed by a test, rather than failing it
 }
 
 func (b *TPMTestBase) SetUpTest(c *C) {
 	b.BaseTest.SetUpTest(c)
 
-	b.AddCleanup(func() { c.Assert(b.TPM.Close(), IsNil) })
+	b.AddFixtureCleanup(func(c *C) { c.Assert(b.TPM.Close(), IsNil) })
 
-	getFlushableHandles := func() (out []tpm2.Handle) {
+	getFlushableHandles := func(c *C) (out []tpm2.Handle) {
 		for _, t := range []tpm2.HandleType{tpm2.HandleTypeTransient, tpm2.HandleTypeLoadedSession, tpm2.HandleTypeSavedSession} {
 			h, err := b.TPM.GetCapabilityHandles(t.BaseHandle(), tpm2.CapabilityMaxProperties, nil)
 			c.Assert(err, IsNil)
@@ -66,10 +80,10 @@ func (b *TPMTestBase) SetUpTest(c *C) {
 		}
 		return
 	}
-	startFlushableHandles := getFlushableHandles()
+	startFlushableHandles := getFlushableHandles(c)
 
-	b.AddCleanup(func() {
-		for _, h := range getFlushableHandles() {
+	b.AddFixtureCleanup(func(c *C) {
+		for _, h := range getFlushableHandles(c) {
 			found := false
 			for _, sh := range startFlushableHandles {
 				if sh == h {
@@ -94,6 +108,20 @@ func (b *TPMTestBase) SetUpTest(c *C) {
 			c.Check(b.TPM.FlushContext(hc), IsNil)
 		}
 	})
+
+	b.AddFixtureCleanup(func(c *C) {
+		leaked := b.TPM.TrackedHandleContexts()
+		if len(leaked) == 0 {
+			return
+		}
+		for _, hc := range leaked {
+			c.Logf("Leaked resource with handle %v", hc.Handle())
+		}
+		if !b.PermitLeakedResources {
+			c.Errorf("%d resources were not flushed by the test", len(leaked))
+		}
+		c.Check(b.TPM.FlushAll(), IsNil)
+	})
 }
 
 // AddCleanupNVSpace ensures that the supplied NV index is undefined at the end of the test, using
@@ -104,6 +132,17 @@ func (b *TPMTestBase) AddCleanupNVSpace(c *C, authHandle, index tpm2.ResourceCon
 	})
 }
 
+// AddCleanupFlushContext ensures that the supplied context is flushed at the end of the test, if the
+// test hasn't already flushed it.
+func (b *TPMTestBase) AddCleanupFlushContext(c *C, context tpm2.HandleContext) {
+	b.AddCleanup(func() {
+		if context.Handle() == tpm2.HandleUnassigned {
+			return
+		}
+		c.Check(b.TPM.FlushContext(context), IsNil)
+	})
+}
+
 // SetHierarchyAuth sets the authorization value for the supplied hierarchy to TestAuth and automatically
 // clears it again at the end of the test.
 func (b *TPMTestBase) SetHierarchyAuth(c *C, hierarchy tpm2.Handle) {
@@ -142,6 +181,32 @@ func (b *TPMTest) SetUpTest(c *C) {
 	b.TPMTestBase.SetUpTest(c)
 }
 
+// FaultInjectionTPMTest is a base test suite for all tests that require a TPMContext created for them, with a
+// FaultInjectionTCTI between it and the TPM. The rules are removed at the end of each test, before the resources created by the
+// test are cleaned up.
+type FaultInjectionTPMTest struct {
+	TPMTestBase
+
+	// TPMFeatures defines the features required by this suite. It should be set before
+	// SetUpTest is called.
+	TPMFeatures TPMFeatureFlags
+
+	FaultTCTI *FaultInjectionTCTI // The TCTI used to inject faults, which is also available as TCTI
+}
+
+func (b *FaultInjectionTPMTest) SetUpTest(c *C) {
+	tcti, err := NewTCTI(b.TPMFeatures)
+	c.Assert(err, IsNil)
+	if tcti == nil {
+		c.Skip("no TPM available for the test")
+	}
+	b.FaultTCTI = NewFaultInjectionTCTI(tcti)
+	b.TPM, _ = tpm2.NewTPMContext(b.FaultTCTI)
+	b.TCTI = b.FaultTCTI
+	b.TPMTestBase.SetUpTest(c)
+	b.AddCleanup(b.FaultTCTI.ClearRules)
+}
+
 // TPMSimulatorTest is a base test suite for all tests that require a TPMContext and TctiMssim
 // created for them.
 type TPMSimulatorTest struct {
@@ -15,9 +15,11 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/softtpm"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/osutil/sys"
 	"github.com/snapcore/snapd/snap"
@@ -139,6 +141,7 @@ const (
 	TPMBackendNone TPMBackendType = iota
 	TPMBackendDevice
 	TPMBackendMssim
+	TPMBackendSoftware
 )
 
 var (
@@ -185,6 +188,7 @@ func (v *tpmBackendFlagValue) IsBoolFlag() bool { return true }
 func AddCommandLineFlags() {
 	flag.Var(&tpmBackendFlagValue{v: TPMBackendDevice, target: &TPMBackend}, "use-tpm", "Whether to use a TPM character device for testing (eg, /dev/tpm0)")
 	flag.Var(&tpmBackendFlagValue{v: TPMBackendMssim, target: &TPMBackend}, "use-mssim", "Whether to use the TPM simulator for testing")
+	flag.Var(&tpmBackendFlagValue{v: TPMBackendSoftware, target: &TPMBackend}, "use-softtpm", "Whether to use the in-process software TPM for testing")
 	flag.Var(&PermittedTPMFeatures, "tpm-permitted-features", "Comma-separated list of features that tests can use on a TPM character device")
 
 	flag.StringVar(&TPMDevicePath, "tpm-path", "/dev/tpm0", "The path of the TPM character device to use for testing (default: /dev/tpm0)")
@@ -428,13 +432,33 @@ Loop:
 	return cleanup, nil
 }
 
+var (
+	softTPMOnce sync.Once
+	softTPM     *softtpm.TPM
+	softTPMErr  error
+)
+
+// softwareTPM returns the in-process software TPM used when TPMBackend is TPMBackendSoftware. It is created and started
+// on first use, and is shared between all tests in the current process.
+func softwareTPM() (*softtpm.TPM, error) {
+	softTPMOnce.Do(func() {
+		softTPM = softtpm.New()
+		tpm, _ := tpm2.NewTPMContext(softTPM)
+		if err := tpm.Startup(tpm2.StartupClear); err != nil {
+			softTPMErr = xerrors.Errorf("software TPM startup failed: %w", err)
+		}
+	})
+	return softTPM, softTPMErr
+}
+
 // NewTCTI returns a new TCTI for testing, for integration with test suites that might have a custom way to create a
 // TPMContext. If TPMBackend is TPMBackendNone, no TCTI will be returned and the calling test should be skipped. If
 // TPMBackend is TPMBackendMssim, the returned TCTI will be of the type *tpm2.TctiMssim and will correspond to a
 // connection to the TPM simulator on the port specified by the MssimPort variable. If TPMBackend is TPMBackendDevice,
 // the returned TCTI will be of the type *tpm2.TctiDeviceLinux if the requested features are permitted, as defined by
 // the PermittedTPMFeatures variable. In this case, the TCTI will correspond to a connection to the Linux character
-// device at the path specified by the TPMDevicePath variable.
+// device at the path specified by the TPMDevicePath variable. If TPMBackend is TPMBackendSoftware, the returned TCTI
+// will correspond to an in-process software TPM that is shared by all tests.
 func NewTCTI(features TPMFeatureFlags) (tpm2.TCTI, error) {
 	switch TPMBackend {
 	case TPMBackendNone:
@@ -454,6 +478,12 @@ func NewTCTI(features TPMFeatureFlags) (tpm2.TCTI, error) {
 			return nil, err
 		}
 		return &tctiFilter{tcti, features}, nil
+	case TPMBackendSoftware:
+		tcti, err := softwareTPM()
+		if err != nil {
+			return nil, err
+		}
+		return &tctiFilter{tcti, features}, nil
 	}
 	panic("not reached")
 }
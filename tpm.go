@@ -6,11 +6,14 @@ package tpm2
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/canonical/go-tpm2/mu"
 
@@ -67,6 +70,8 @@ type responseHeader struct {
 
 type cmdContext struct {
 	commandCode      CommandCode
+	handleNames      []Name
+	cpBytes          []byte // The command parameters as sent to the TPM, after any parameter encryption
 	sessionParams    *sessionParams
 	responseCode     ResponseCode
 	responseTag      StructTag
@@ -92,8 +97,6 @@ type ResourceContextWithSession struct {
 // Section 15 - Symmetric Primitives
 // Section 17 - Hash/HMAC/Event Sequences
 // Section 19 - Ephemeral EC Keys
-// Section 26 - Miscellaneous Management Functions
-// Section 27 - Field Upgrade
 
 // TPMContext is the main entry point by which commands are executed on a TPM device using this package. It communicates with the
 // underlying device via a transmission interface, which is an implementation of io.ReadWriteCloser provided to NewTPMContext.
@@ -104,24 +107,90 @@ type ResourceContextWithSession struct {
 // Some methods also accept a variable number of optional SessionContext arguments - these are for sessions that don't provide
 // authorization for a corresponding TPM resource. These sessions may be used for the purposes of session based parameter encryption
 // or command auditing.
+//
+// Commands are executed without a deadline and cannot be cancelled, unless a context.Context is associated with the TPMContext using
+// TPMContext.WithContext.
+//
+// TPMContext is safe for concurrent use by multiple goroutines. Commands are submitted to the TPM one at a time, with each command
+// and its response being processed before the next command is submitted. A SessionContext must not be used by more than one
+// command at a time - an attempt to use a session that is already in use by a command executing in another goroutine will result
+// in an error.
 type TPMContext struct {
-	tcti                  TCTI
-	permanentResources    map[Handle]*permanentContext
-	maxSubmissions        uint
-	propertiesInitialized bool
-	maxBufferSize         int
-	maxDigestSize         int
-	maxNVBufferSize       int
-	exclusiveSession      *sessionContext
+	*tpmContextState
+	ctx context.Context
+}
+
+// tpmContextState contains the state that is shared between a TPMContext and any copies of it created by TPMContext.WithContext.
+type tpmContextState struct {
+	tcti TCTI
+
+	cmdMu           sync.Mutex       // Serializes the submission of commands and the processing of their responses
+	pendingResponse *partialResponse // The response to a previous command that was abandoned and needs to be read and discarded
+
+	mu                 sync.Mutex // Protects the fields below. If cmdMu is also required, it must be acquired first
+	permanentResources map[Handle]*permanentContext
+	maxSubmissions     uint
+	retryPolicy        RetryPolicy
+	retryHook          RetryHook
+	interceptors       []Interceptor
+	properties         *tpmProperties
+	exclusiveSession   *sessionContext
+	defaultSession     *defaultSession
+	strict             bool
+	commandAuditLog    *CommandAuditLog
+	trackedContexts    []HandleContext
+	flushOnClose       bool
 }
 
-// Close calls Close on the transmission interface.
+// tpmProperties contains properties of the TPM used internally by TPMContext.
+type tpmProperties struct {
+	maxBufferSize   int
+	maxDigestSize   int
+	maxNVBufferSize int
+}
+
+// WithContext returns a shallow copy of t that uses the supplied context for executing commands. The returned TPMContext shares
+// all of its state with t, including the transmission interface and any HandleContexts that it has created.
+//
+// If ctx is already done, commands will not be submitted to the TPM and methods that execute commands will return a
+// *CommandCanceledError. If ctx becomes done whilst a command is executing, an attempt is made to cancel the command if the
+// transmission interface implements TCTICanceler. If the TPM cancels the command, methods will return a *CommandCanceledError.
+// If the command cannot be cancelled but the transmission interface implements TCTIReadDeadliner, the response will be abandoned
+// and a *CommandCanceledError will be returned, with any sessions used for the command being invalidated. If the command can
+// neither be cancelled nor abandoned, then the method will wait for the command to complete.
+func (t *TPMContext) WithContext(ctx context.Context) *TPMContext {
+	if ctx == nil {
+		panic("nil context")
+	}
+	return &TPMContext{tpmContextState: t.tpmContextState, ctx: ctx}
+}
+
+// Context returns the context associated with t. If no context has been associated with t by TPMContext.WithContext, this returns
+// context.Background().
+func (t *TPMContext) Context() context.Context {
+	if t.ctx == nil {
+		return context.Background()
+	}
+	return t.ctx
+}
+
+// Close calls Close on the transmission interface. If enabled with TPMContext.SetFlushOnClose, TPMContext.FlushAll is called
+// first, and any error from it is returned after the transmission interface has been closed.
 func (t *TPMContext) Close() error {
+	t.mu.Lock()
+	flushOnClose := t.flushOnClose
+	t.mu.Unlock()
+
+	var flushErr error
+	if flushOnClose {
+		flushErr = t.FlushAll()
+	}
+
 	if err := t.tcti.Close(); err != nil {
 		return &TctiError{"close", err}
 	}
 
-	return nil
+	return flushErr
 }
 
 // RunCommandBytes is a low-level interface for executing the command defined by the specified commandCode. It will construct an
@@ -132,7 +201,34 @@ func (t *TPMContext) Close() error {
 // response structure (everything except for the header). It will not return an error if the TPM responds with an error as long as
 // the returned response structure is correctly formed, but will return an error if marshalling of the command header or
 // unmarshalling of the response header fails, or the transmission interface returns an error.
+//
+// If the context associated with t (see TPMContext.WithContext) is done before the command completes, a *CommandCanceledError
+// may be returned.
 func (t *TPMContext) RunCommandBytes(tag StructTag, commandCode CommandCode, commandBytes []byte) (ResponseCode, StructTag, []byte, error) {
+	t.cmdMu.Lock()
+	defer t.cmdMu.Unlock()
+	return t.runCommandBytes(tag, commandCode, commandBytes)
+}
+
+func (t *TPMContext) runCommandBytes(tag StructTag, commandCode CommandCode, commandBytes []byte) (ResponseCode, StructTag, []byte, error) {
+	ctx := t.Context()
+
+	if err := ctx.Err(); err != nil {
+		return 0, 0, nil, &CommandCanceledError{Command: commandCode, err: err}
+	}
+
+	if t.pendingResponse != nil {
+		// Discard the response to a previously abandoned command before submitting a new one. If this is abandoned again,
+		// readResponseWithContext keeps the part of the response that has been read so far.
+		if _, _, _, err := t.readResponseWithContext(ctx, commandCode); err != nil {
+			var e *CommandCanceledError
+			if xerrors.As(err, &e) {
+				return 0, 0, nil, &CommandCanceledError{Command: commandCode, err: e.err}
+			}
+			return 0, 0, nil, err
+		}
+	}
+
 	cHeader := commandHeader{tag, 0, commandCode}
 	cHeader.CommandSize = uint32(binary.Size(cHeader) + len(commandBytes))
 
@@ -145,31 +241,116 @@ func (t *TPMContext) RunCommandBytes(tag StructTag, commandCode CommandCode, com
 		return 0, 0, nil, &TctiError{"write", err}
 	}
 
+	return t.readResponseWithContext(ctx, commandCode)
+}
+
+func isTimeoutError(err error) bool {
+	var e interface{ Timeout() bool }
+	return xerrors.As(err, &e) && e.Timeout()
+}
+
+// partialResponse contains the part of a response that has been read from the TPM so far. This allows a response that was
+// abandoned part way through to be read to the end before the next command is submitted, so that the stream remains in sync.
+type partialResponse struct {
+	data []byte
+}
+
+// readFull reads from r until the partial response contains n bytes. On error, the bytes that were read are retained so that
+// it can be called again. It returns the number of bytes in the partial response.
+func (p *partialResponse) readFull(r io.Reader, n int) (int, error) {
+	start := len(p.data)
+	if start >= n {
+		return start, nil
+	}
+	p.data = append(p.data, make([]byte, n-start)...)
+	m, err := io.ReadFull(r, p.data[start:])
+	p.data = p.data[:start+m]
+	return len(p.data), err
+}
+
+// readResponseWithContext reads the response to the last command submitted to the TPM, resuming the read of an abandoned
+// response if there is one. If ctx is done before the response is received, it will attempt to cancel the command, or abandon
+// the response if the command cannot be cancelled.
+func (t *TPMContext) readResponseWithContext(ctx context.Context, commandCode CommandCode) (ResponseCode, StructTag, []byte, error) {
+	rsp := t.pendingResponse
+	t.pendingResponse = nil
+	if rsp == nil {
+		rsp = new(partialResponse)
+	}
+
+	done := ctx.Done()
+	if done == nil {
+		return t.readResponse(commandCode, rsp)
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	abandoning := false
+
+	go func() {
+		defer close(stopped)
+		select {
+		case <-stop:
+			return
+		case <-done:
+		}
+
+		if c, ok := t.tcti.(TCTICanceler); ok && c.Cancel() == nil {
+			// The TPM will still respond to the command.
+			return
+		}
+		if d, ok := t.tcti.(TCTIReadDeadliner); ok && d.SetReadDeadline(time.Now()) == nil {
+			abandoning = true
+		}
+	}()
+
+	rc, tag, data, err := t.readResponse(commandCode, rsp)
+	close(stop)
+	<-stopped
+
+	if abandoning {
+		if err := t.tcti.(TCTIReadDeadliner).SetReadDeadline(time.Time{}); err != nil {
+			return 0, 0, nil, &TctiError{"setReadDeadline", err}
+		}
+		if err != nil && isTimeoutError(err) {
+			t.pendingResponse = rsp
+			return 0, 0, nil, &CommandCanceledError{Command: commandCode, ResponseAbandoned: true, err: ctx.Err()}
+		}
+	}
+
+	return rc, tag, data, err
+}
+
+// readResponse reads the response to the last command submitted to the TPM into rsp, continuing from the bytes that rsp
+// already contains.
+func (t *TPMContext) readResponse(commandCode CommandCode, rsp *partialResponse) (ResponseCode, StructTag, []byte, error) {
 	var rHeader responseHeader
-	rHeaderSize := uint32(binary.Size(rHeader))
-	if n, err := mu.UnmarshalFromReader(t.tcti, &rHeader); err != nil {
+	rHeaderSize := binary.Size(rHeader)
+	if n, err := rsp.readFull(t.tcti, rHeaderSize); err != nil {
 		if xerrors.Is(err, io.ErrUnexpectedEOF) {
 			return 0, 0, nil, &InvalidResponseError{commandCode, fmt.Sprintf("insufficient bytes for response header (got %d, expected %d)", n, rHeaderSize)}
 		}
 		return 0, 0, nil, &TctiError{"read", err}
 	}
+	if _, err := mu.UnmarshalFromBytes(rsp.data[:rHeaderSize], &rHeader); err != nil {
+		panic(fmt.Sprintf("cannot unmarshal response header: %v", err))
+	}
 
-	if rHeader.ResponseSize < rHeaderSize {
+	if rHeader.ResponseSize < uint32(rHeaderSize) {
 		return 0, 0, nil, &InvalidResponseError{commandCode, fmt.Sprintf("invalid responseSize value (%d)", rHeader.ResponseSize)}
 	}
 
-	responseBytes := make([]byte, rHeader.ResponseSize-rHeaderSize)
-	if n, err := io.ReadFull(t.tcti, responseBytes); err != nil {
+	if n, err := rsp.readFull(t.tcti, int(rHeader.ResponseSize)); err != nil {
 		if xerrors.Is(err, io.ErrUnexpectedEOF) {
-			return 0, 0, nil, &InvalidResponseError{commandCode, fmt.Sprintf("insufficient bytes for response payload (got %d, expected %d)", n, len(responseBytes))}
+			return 0, 0, nil, &InvalidResponseError{commandCode, fmt.Sprintf("insufficient bytes for response payload (got %d, expected %d)", n-rHeaderSize, int(rHeader.ResponseSize)-rHeaderSize)}
 		}
 		return 0, 0, nil, &TctiError{"read", err}
 	}
 
-	return rHeader.ResponseCode, rHeader.Tag, responseBytes, nil
+	return rHeader.ResponseCode, rHeader.Tag, rsp.data[rHeaderSize:], nil
 }
 
-func (t *TPMContext) runCommandWithoutProcessingAuthResponse(commandCode CommandCode, sessionParams *sessionParams, resources, params, outHandles []interface{}) (*cmdContext, error) {
+func (t *TPMContext) runCommandWithoutProcessingAuthResponse(commandCode CommandCode, sessionParams *sessionParams, chain *interceptorChain, resources, params, outHandles []interface{}) (*cmdContext, error) {
 	handles := make([]interface{}, 0, len(resources))
 	handleNames := make([]Name, 0, len(resources))
 
@@ -213,6 +394,19 @@ func (t *TPMContext) runCommandWithoutProcessingAuthResponse(commandCode Command
 		return nil, xerrors.Errorf("cannot marshal command parameters for command %s: %w", commandCode, err)
 	}
 
+	t.mu.Lock()
+	strict := t.strict
+	t.mu.Unlock()
+	if strict {
+		if err := sessionParams.checkStrictParamEncryption(commandCode, cpBytes.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := chain.before(handleNames, cpBytes.Bytes()); err != nil {
+		return nil, err
+	}
+
 	tag := TagNoSessions
 	if len(sessionParams.sessions) > 0 {
 		tag = TagSessions
@@ -225,6 +419,7 @@ func (t *TPMContext) runCommandWithoutProcessingAuthResponse(commandCode Command
 		}
 	}
 
+	cp := cpBytes.Bytes()
 	if _, err := cpBytes.WriteTo(cBytes); err != nil {
 		panic(fmt.Sprintf("cannot write command parameter bytes to command buffer: %v", err))
 	}
@@ -233,24 +428,52 @@ func (t *TPMContext) runCommandWithoutProcessingAuthResponse(commandCode Command
 	var responseTag StructTag
 	var responseBytes []byte
 
+	t.mu.Lock()
+	maxSubmissions := t.maxSubmissions
+	retryPolicy := t.retryPolicy
+	retryHook := t.retryHook
+	t.mu.Unlock()
+
 	for tries := uint(1); ; tries++ {
 		var err error
-		responseCode, responseTag, responseBytes, err = t.RunCommandBytes(tag, commandCode, cBytes.Bytes())
+		responseCode, responseTag, responseBytes, err = t.runCommandBytes(tag, commandCode, cBytes.Bytes())
 		if err != nil {
+			var e *CommandCanceledError
+			if xerrors.As(err, &e) && e.ResponseAbandoned {
+				sessionParams.invalidateAllSessionContexts()
+			}
 			return nil, err
 		}
+		chain.responseReceived(responseCode)
 
 		err = DecodeResponseCode(commandCode, responseCode)
 		if err == nil {
 			break
 		}
 
-		if tries >= t.maxSubmissions {
+		if ctxErr := t.Context().Err(); ctxErr != nil && IsTPMWarning(err, WarningCanceled, commandCode) {
+			return nil, &CommandCanceledError{Command: commandCode, err: ctxErr}
+		}
+
+		if tries >= maxSubmissions {
 			return nil, err
 		}
-		if e, ok := err.(*TPMWarning); !ok || !(e.Code == WarningYielded || e.Code == WarningTesting || e.Code == WarningRetry) {
+		e, ok := err.(*TPMWarning)
+		if !ok {
 			return nil, err
 		}
+		retry, delay := retryPolicy.Retry(e, tries)
+		if !retry {
+			return nil, err
+		}
+		if retryHook != nil {
+			retryHook(commandCode, e, tries, delay)
+		}
+		// cmdMu is held whilst waiting to resubmit the command. The command's authorization area was computed from the
+		// current session nonces, so another command that uses the same sessions can't be permitted to run in between.
+		if err := t.sleep(delay); err != nil {
+			return nil, &CommandCanceledError{Command: commandCode, err: err}
+		}
 	}
 
 	buf := bytes.NewReader(responseBytes)
@@ -294,6 +517,8 @@ func (t *TPMContext) runCommandWithoutProcessingAuthResponse(commandCode Command
 
 	return &cmdContext{
 		commandCode:      commandCode,
+		handleNames:      handleNames,
+		cpBytes:          cp,
 		sessionParams:    sessionParams,
 		responseCode:     responseCode,
 		responseTag:      responseTag,
@@ -309,7 +534,8 @@ func (t *TPMContext) processAuthResponse(cmd *cmdContext, params []interface{})
 	}
 
 	if isSessionAllowed(cmd.commandCode) {
-		if t.exclusiveSession != nil {
+		t.mu.Lock()
+		if t.exclusiveSession != nil && t.exclusiveSession.Data() != nil {
 			t.exclusiveSession.Data().IsExclusive = false
 		}
 		var exclusive *sessionContext
@@ -326,6 +552,7 @@ func (t *TPMContext) processAuthResponse(cmd *cmdContext, params []interface{})
 		if t.exclusiveSession != nil {
 			t.exclusiveSession.Data().IsExclusive = true
 		}
+		t.mu.Unlock()
 	}
 
 	rpBuf := bytes.NewReader(cmd.rpBytes)
@@ -368,8 +595,8 @@ func (t *TPMContext) processAuthResponse(cmd *cmdContext, params []interface{})
 // Specification.
 //
 // If the TPM responds with a warning that indicates the command could not be started and should be retried, this function will
-// resubmit the command a finite number of times before returning an error. The maximum number of retries can be set via
-// TPMContext.SetMaxSubmissions.
+// resubmit the command a finite number of times before returning an error, according to the policy set with
+// TPMContext.SetRetryPolicy. The maximum number of submissions can be set via TPMContext.SetMaxSubmissions.
 //
 // The caller can provide additional sessions that aren't associated with a TPM entity (and therefore not used for authorization) via
 // the sessions parameter, for the purposes of command auditing or session based parameter encryption.
@@ -382,6 +609,7 @@ func (t *TPMContext) RunCommandWithResponseCallback(commandCode CommandCode, ses
 	var responseHandles []interface{}
 	var responseParams []interface{}
 	var sessionParams sessionParams
+	numAuthHandles := 0 // Number of leading command handles that require authorization
 
 	sentinels := 0
 	for _, param := range params {
@@ -394,6 +622,9 @@ func (t *TPMContext) RunCommandWithResponseCallback(commandCode CommandCode, ses
 		case 0:
 			switch p := param.(type) {
 			case ResourceContextWithSession:
+				if numAuthHandles == len(commandHandles) {
+					numAuthHandles++
+				}
 				commandHandles = append(commandHandles, p.Context)
 				if err := sessionParams.validateAndAppendAuth(p); err != nil {
 					return fmt.Errorf("cannot process ResourceContextWithSession for command %s at index %d: %v", commandCode, len(commandHandles), err)
@@ -410,20 +641,48 @@ func (t *TPMContext) RunCommandWithResponseCallback(commandCode CommandCode, ses
 		}
 	}
 
+	if cmd := lookupVendorCommand(commandCode); cmd != nil {
+		if err := cmd.checkCommand(len(commandHandles), numAuthHandles, len(sessionParams.sessions), commandParams); err != nil {
+			return fmt.Errorf("invalid arguments for vendor-specific command %s: %v", commandCode, err)
+		}
+		if err := cmd.checkResponse(len(responseHandles), responseParams); err != nil {
+			return fmt.Errorf("invalid arguments for vendor-specific command %s: %v", commandCode, err)
+		}
+	}
+
 	if err := sessionParams.validateAndAppendExtra(sessions); err != nil {
 		return fmt.Errorf("cannot process non-auth SessionContext parameters for command %s: %v", commandCode, err)
 	}
 
-	ctx, err := t.runCommandWithoutProcessingAuthResponse(commandCode, &sessionParams, commandHandles, commandParams, responseHandles)
+	return t.runCommandWithDefaultSession(commandCode, &sessionParams, responseCb, commandHandles, commandParams, responseHandles, responseParams)
+}
+
+func (t *TPMContext) runCommandWithSessions(commandCode CommandCode, sessionParams *sessionParams, responseCb func(), commandHandles, commandParams, responseHandles, responseParams []interface{}) error {
+	if err := sessionParams.acquireSessions(); err != nil {
+		return fmt.Errorf("cannot use SessionContext parameters for command %s: %v", commandCode, err)
+	}
+	defer sessionParams.releaseSessions()
+
+	t.cmdMu.Lock()
+	defer t.cmdMu.Unlock()
+
+	chain := t.newInterceptorChain(commandCode, sessionParams)
+
+	ctx, err := t.runCommandWithoutProcessingAuthResponse(commandCode, sessionParams, chain, commandHandles, commandParams, responseHandles)
 	if err != nil {
+		chain.after(nil, err)
 		return err
 	}
 
+	t.recordCommandAudit(ctx)
+
 	if responseCb != nil {
 		responseCb()
 	}
 
-	return t.processAuthResponse(ctx, responseParams)
+	err = t.processAuthResponse(ctx, responseParams)
+	chain.after(ctx, err)
+	return err
 }
 
 // RunCommand is the high-level generic interface for executing the command specified by commandCode. All of the methods on TPMContext
@@ -449,8 +708,8 @@ func (t *TPMContext) RunCommandWithResponseCallback(commandCode CommandCode, ses
 // Specification.
 //
 // If the TPM responds with a warning that indicates the command could not be started and should be retried, this function will
-// resubmit the command a finite number of times before returning an error. The maximum number of retries can be set via
-// TPMContext.SetMaxSubmissions.
+// resubmit the command a finite number of times before returning an error, according to the policy set with
+// TPMContext.SetRetryPolicy. The maximum number of submissions can be set via TPMContext.SetMaxSubmissions.
 //
 // The caller can provide additional sessions that aren't associated with a TPM entity (and therefore not used for authorization) via
 // the sessions parameter, for the purposes of command auditing or session based parameter encryption.
@@ -462,11 +721,74 @@ func (t *TPMContext) RunCommand(commandCode CommandCode, sessions []SessionConte
 }
 
 // SetMaxSubmissions sets the maximum number of times that RunCommand will attempt to submit a command before failing with an error.
-// The default value is 5.
+// The default value is 5. This limit applies regardless of the RetryPolicy set with TPMContext.SetRetryPolicy.
 func (t *TPMContext) SetMaxSubmissions(max uint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.maxSubmissions = max
 }
 
+// SetRetryPolicy sets the policy that decides whether RunCommand resubmits a command that the TPM responds to with a warning,
+// and how long it waits before doing so. If policy is nil, the default policy is restored, which is a zero value
+// BackoffRetryPolicy.
+func (t *TPMContext) SetRetryPolicy(policy RetryPolicy) {
+	if policy == nil {
+		policy = new(BackoffRetryPolicy)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.retryPolicy = policy
+}
+
+// SetRetryHook sets a callback that is called every time that RunCommand is about to resubmit a command because the TPM
+// responded to it with a warning. This can be used to log or count retries. Set it to nil to remove an existing hook.
+func (t *TPMContext) SetRetryHook(hook RetryHook) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.retryHook = hook
+}
+
+// SetStrictMode enables or disables strict mode. In strict mode, RunCommand refuses to submit a command with a sensitive
+// parameter unless a session that protects the parameter with session based parameter encryption is supplied, in order to
+// protect against an adversary that is able to observe the communication with the TPM. In this case, a
+// *UnprotectedParameterError is returned. The commands and parameters that are considered to be sensitive are:
+//  * The first command parameter (which is an authorization value or sensitive data) of TPM2_NV_DefineSpace,
+//    TPM2_HierarchyChangeAuth, TPM2_CreatePrimary, TPM2_NV_ChangeAuth, TPM2_Duplicate, TPM2_ObjectChangeAuth, TPM2_Create,
+//    TPM2_Import, TPM2_HMAC_Start, TPM2_LoadExternal, TPM2_HashSequenceStart and TPM2_CreateLoaded. An empty parameter is
+//    permitted without encryption.
+//  * The first response parameter of TPM2_ActivateCredential, TPM2_Duplicate, TPM2_ECDH_KeyGen, TPM2_ECDH_ZGen, TPM2_RSA_Decrypt,
+//    TPM2_Unseal and TPM2_ZGen_2Phase.
+//
+// A session that is used to encrypt a sensitive parameter must be salted or bound, else the session key can be derived from the
+// nonces exchanged with the TPM. For the same reason, RunCommand also refuses to submit any command with a HMAC session that is
+// neither salted nor bound, regardless of whether it is used for parameter encryption. The commands with a sensitive command
+// parameter listed above must not use password authorization either, as this sends the authorization value in the clear - a
+// session must be supplied for each resource that requires authorization.
+//
+// Strict mode can be combined with TPMContext.EnableDefaultSession, which supplies a suitable session for parameter encryption
+// automatically.
+func (t *TPMContext) SetStrictMode(strict bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.strict = strict
+}
+
+// sleep waits for the specified duration before returning, or returns an error if the context associated with t is done first.
+func (t *TPMContext) sleep(d time.Duration) error {
+	ctx := t.Context()
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
 // InitProperties executes a TPM2_GetCapability command to initialize properties used internally by TPMContext. This is normally done
 // automatically by functions that require these properties when they are used for the first time, but this function is provided so
 // that the command can be audited, and so the exclusivity of an audit session can be preserved.
@@ -476,42 +798,58 @@ func (t *TPMContext) InitProperties(sessions ...SessionContext) error {
 		return err
 	}
 
+	var properties tpmProperties
 	for _, prop := range props {
 		switch prop.Property {
 		case PropertyInputBuffer:
-			t.maxBufferSize = int(prop.Value)
+			properties.maxBufferSize = int(prop.Value)
 		case PropertyMaxDigest:
-			t.maxDigestSize = int(prop.Value)
+			properties.maxDigestSize = int(prop.Value)
 		case PropertyNVBufferMax:
-			t.maxNVBufferSize = int(prop.Value)
+			properties.maxNVBufferSize = int(prop.Value)
 		}
 	}
 
-	if t.maxBufferSize == 0 {
-		t.maxBufferSize = 1024
+	if properties.maxBufferSize == 0 {
+		properties.maxBufferSize = 1024
 	}
-	if t.maxDigestSize == 0 {
+	if properties.maxDigestSize == 0 {
 		return &InvalidResponseError{Command: CommandGetCapability, msg: "missing or invalid TPM_PT_MAX_DIGEST property"}
 	}
-	if t.maxNVBufferSize == 0 {
+	if properties.maxNVBufferSize == 0 {
 		return &InvalidResponseError{Command: CommandGetCapability, msg: "missing or invalid TPM_PT_NV_BUFFER_MAX property"}
 	}
-	t.propertiesInitialized = true
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.properties = &properties
 	return nil
 }
 
-func (t *TPMContext) initPropertiesIfNeeded() error {
-	if t.propertiesInitialized {
-		return nil
+func (t *TPMContext) initPropertiesIfNeeded() (*tpmProperties, error) {
+	t.mu.Lock()
+	properties := t.properties
+	t.mu.Unlock()
+
+	if properties != nil {
+		return properties, nil
 	}
-	return t.InitProperties()
+
+	if err := t.InitProperties(); err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.properties, nil
 }
 
 func newTpmContext(tcti TCTI) *TPMContext {
-	r := new(TPMContext)
+	r := &TPMContext{tpmContextState: new(tpmContextState)}
 	r.tcti = tcti
 	r.permanentResources = make(map[Handle]*permanentContext)
 	r.maxSubmissions = 5
+	r.retryPolicy = new(BackoffRetryPolicy)
 
 	return r
 }
@@ -0,0 +1,395 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/canonical/go-tpm2/internal"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+var (
+	stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	unionType    = reflect.TypeOf((*mu.Union)(nil)).Elem()
+
+	// sensitiveTypes are the types that are redacted by TctiTracer unless TctiTracer.SetShowSensitive is used.
+	sensitiveTypes = map[reflect.Type]bool{
+		reflect.TypeOf(Auth(nil)):          true,
+		reflect.TypeOf(SensitiveData(nil)): true,
+		reflect.TypeOf(Sensitive{}):        true}
+
+	// algorithmIdTypes are the types that are formatted as an AlgorithmId.
+	algorithmIdTypes = map[reflect.Type]bool{
+		reflect.TypeOf(HashAlgorithmId(0)):      true,
+		reflect.TypeOf(SymAlgorithmId(0)):       true,
+		reflect.TypeOf(SymObjectAlgorithmId(0)): true,
+		reflect.TypeOf(SymModeId(0)):            true,
+		reflect.TypeOf(KDFAlgorithmId(0)):       true,
+		reflect.TypeOf(SigSchemeId(0)):          true,
+		reflect.TypeOf(KeyedHashSchemeId(0)):    true,
+		reflect.TypeOf(AsymSchemeId(0)):         true,
+		reflect.TypeOf(ObjectTypeId(0)):         true}
+)
+
+// lookupCommandSchema returns a description of the handle and parameter areas of the specified command and its response. For
+// vendor-specific commands, this is derived from the description supplied to RegisterVendorCommand.
+func lookupCommandSchema(code CommandCode) *commandSchema {
+	if schema, ok := commandSchemas[code]; ok {
+		return schema
+	}
+
+	cmd := lookupVendorCommand(code)
+	if cmd == nil {
+		return nil
+	}
+
+	schema := new(commandSchema)
+	for i := 0; i < cmd.NumHandles; i++ {
+		schema.handles = append(schema.handles, fmt.Sprintf("handle%d", i+1))
+	}
+	for i, t := range cmd.CommandParams {
+		schema.params = append(schema.params, commandSchemaParam{fmt.Sprintf("param%d", i+1), t})
+	}
+	for i := 0; i < cmd.NumResponseHandles; i++ {
+		schema.rspHandles = append(schema.rspHandles, fmt.Sprintf("handle%d", i+1))
+	}
+	for i, t := range cmd.ResponseParams {
+		schema.rspParams = append(schema.rspParams, commandSchemaParam{fmt.Sprintf("param%d", i+1), t})
+	}
+	if cmd.CommandParams == nil {
+		schema.params = nil
+	}
+	if cmd.ResponseParams == nil {
+		schema.rspParams = nil
+	}
+	return schema
+}
+
+func formatStructTag(tag StructTag) string {
+	switch tag {
+	case TagSessions:
+		return "TPM_ST_SESSIONS"
+	case TagNoSessions:
+		return "TPM_ST_NO_SESSIONS"
+	default:
+		return fmt.Sprintf("0x%04x", uint16(tag))
+	}
+}
+
+func formatSessionAttrs(attrs SessionAttributes) string {
+	var names []string
+	for _, a := range []struct {
+		attr SessionAttributes
+		name string
+	}{
+		{AttrContinueSession, "continueSession"},
+		{AttrAuditExclusive, "auditExclusive"},
+		{AttrAuditReset, "auditReset"},
+		{AttrCommandEncrypt, "decrypt"},
+		{AttrResponseEncrypt, "encrypt"},
+		{AttrAudit, "audit"}} {
+		if attrs&a.attr != 0 {
+			names = append(names, a.name)
+		}
+	}
+	if len(names) == 0 {
+		return "0"
+	}
+	return strings.Join(names, "|")
+}
+
+// formatResponseCode returns a human readable representation of the supplied response code.
+func formatResponseCode(command CommandCode, rc ResponseCode) string {
+	var desc string
+	switch e := DecodeResponseCode(command, rc).(type) {
+	case nil:
+		desc = "TPM_RC_SUCCESS"
+	case *TPMParameterError:
+		desc = fmt.Sprintf("%s (parameter %d)", e.Code, e.Index)
+	case *TPMSessionError:
+		desc = fmt.Sprintf("%s (session %d)", e.Code, e.Index)
+	case *TPMHandleError:
+		if e.Index == 0 {
+			desc = e.Code.String()
+		} else {
+			desc = fmt.Sprintf("%s (handle %d)", e.Code, e.Index)
+		}
+	case *TPMError:
+		desc = e.Code.String()
+	case *TPMWarning:
+		desc = e.Code.String()
+	case *TPMVendorError:
+		desc = "vendor defined error"
+		if e.Vendor != "" {
+			desc += " (" + e.Vendor + ")"
+		}
+	case *TPM1Error:
+		desc = "TPM 1.2 error"
+	}
+	return fmt.Sprintf("0x%08x %s", uint32(rc), desc)
+}
+
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
+
+// traceFormatter formats decoded values for TctiTracer.
+type traceFormatter struct {
+	showSensitive bool
+}
+
+func (f *traceFormatter) formatBytes(b []byte) string {
+	if len(b) == 0 {
+		return "(empty)"
+	}
+	return hex.EncodeToString(b)
+}
+
+func (f *traceFormatter) format(v reflect.Value) string {
+	if !v.IsValid() {
+		return "<nil>"
+	}
+	if !f.showSensitive && sensitiveTypes[v.Type()] {
+		return "<redacted>"
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return "<nil>"
+		}
+		return f.format(v.Elem())
+	}
+
+	if !v.CanInterface() {
+		return "?"
+	}
+	if algorithmIdTypes[v.Type()] {
+		return AlgorithmId(v.Uint()).String()
+	}
+	if v.Type().Implements(stringerType) {
+		return v.Interface().(fmt.Stringer).String()
+	}
+
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return f.formatBytes(v.Bytes())
+		}
+		var elems []string
+		for i := 0; i < v.Len(); i++ {
+			elems = append(elems, f.format(v.Index(i)))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return f.formatBytes(b)
+		}
+		var elems []string
+		for i := 0; i < v.Len(); i++ {
+			elems = append(elems, f.format(v.Index(i)))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case reflect.Struct:
+		t := v.Type()
+		if t.NumField() == 1 && t.Field(0).Tag.Get("tpm2") == "sized" {
+			// Unwrap sized structures.
+			return f.format(v.Field(0))
+		}
+		isUnion := reflect.PtrTo(t).Implements(unionType)
+
+		var fields []string
+		for i := 0; i < t.NumField(); i++ {
+			field := v.Field(i)
+			if isUnion && isNilValue(field) {
+				// Only the selected member of a union is populated.
+				continue
+			}
+			fields = append(fields, fmt.Sprintf("%s: %s", t.Field(i).Name, f.format(field)))
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if t := v.Type(); t.PkgPath() != "" {
+			// Named types without a String method are generally bit fields.
+			return fmt.Sprintf("0x%0*x", t.Size()*2, v.Uint())
+		}
+		return fmt.Sprintf("%d", v.Uint())
+	default:
+		return fmt.Sprintf("%v", v.Interface())
+	}
+}
+
+// TctiTracer is a TCTI that wraps another TCTI in order to log every command and response to an io.Writer in a decoded, human
+// readable form. This is useful for debugging, as it avoids the need to decode command and response buffers by hand.
+//
+// Command and response codes are logged by name, along with the handles, the contents of the authorization area and the
+// decoded parameters. Values that may contain sensitive data, such as authorization values, session HMACs, symmetric keys and
+// the contents of sensitive areas, are redacted by default. This can be changed with TctiTracer.SetShowSensitive.
+//
+// Commands and responses that cannot be decoded are logged as a hex dump.
+type TctiTracer struct {
+	tcti TCTI
+	w    io.Writer
+	f    traceFormatter
+
+	cmd         []byte
+	commandCode CommandCode
+	rsp         []byte
+}
+
+// NewTctiTracer returns a new TctiTracer that logs commands sent to and responses received from the supplied TCTI to w. The
+// returned TctiTracer takes ownership of the supplied TCTI, and will close it when it is closed.
+func NewTctiTracer(tcti TCTI, w io.Writer) *TctiTracer {
+	return &TctiTracer{tcti: tcti, w: w}
+}
+
+// SetShowSensitive controls whether values that may contain sensitive data are logged. By default, these are redacted.
+func (t *TctiTracer) SetShowSensitive(show bool) {
+	t.f.showSensitive = show
+}
+
+func (t *TctiTracer) writeHandles(buf io.Writer, prefix string, handles []DecodedHandle) {
+	for _, h := range handles {
+		fmt.Fprintf(buf, "%s  %s: %s\n", prefix, h.Name, h.Handle)
+	}
+}
+
+func (t *TctiTracer) writeParams(buf io.Writer, prefix string, params []DecodedParam) {
+	for _, p := range params {
+		if p.Sensitive && !t.f.showSensitive {
+			fmt.Fprintf(buf, "%s  %s: <redacted>\n", prefix, p.Name)
+			continue
+		}
+		if p.Encrypted {
+			fmt.Fprintf(buf, "%s  %s: %s (encrypted)\n", prefix, p.Name, t.f.formatBytes(p.Value.([]byte)))
+			continue
+		}
+		fmt.Fprintf(buf, "%s  %s: %s\n", prefix, p.Name, t.f.format(reflect.ValueOf(p.Value)))
+	}
+}
+
+func (t *TctiTracer) writeTrailer(buf io.Writer, prefix string, err error, unparsed []byte) {
+	if err != nil {
+		fmt.Fprintf(buf, "%s  decode error: %v\n", prefix, err)
+	}
+	if len(unparsed) > 0 {
+		fmt.Fprintf(buf, "%s  undecoded: %x\n", prefix, unparsed)
+	}
+}
+
+func (t *TctiTracer) traceCommand(cmd []byte) {
+	buf := new(bytes.Buffer)
+	defer func() { buf.WriteTo(t.w) }()
+
+	d, err := DecodeCommand(cmd)
+	if d == nil {
+		fmt.Fprintf(buf, "> invalid command: %x\n", cmd)
+		return
+	}
+	t.commandCode = d.CommandCode
+
+	fmt.Fprintf(buf, "> %s (0x%08x) tag=%s size=%d\n", d.CommandCode, uint32(d.CommandCode), formatStructTag(d.Tag), d.CommandSize)
+	t.writeHandles(buf, ">", d.Handles)
+	for i, auth := range d.AuthArea {
+		fmt.Fprintf(buf, ">  session %d: handle=%s nonce=%s attrs=%s hmac=%s\n", i+1, auth.SessionHandle,
+			t.f.formatBytes(auth.Nonce), formatSessionAttrs(auth.SessionAttrs), t.f.format(reflect.ValueOf(auth.HMAC)))
+	}
+	t.writeParams(buf, ">", d.Params)
+	t.writeTrailer(buf, ">", err, d.Unparsed)
+}
+
+func (t *TctiTracer) traceResponse(rsp []byte) {
+	buf := new(bytes.Buffer)
+	defer func() { buf.WriteTo(t.w) }()
+
+	d, err := DecodeResponse(t.commandCode, rsp)
+	if d == nil {
+		fmt.Fprintf(buf, "< invalid response: %x\n", rsp)
+		return
+	}
+
+	fmt.Fprintf(buf, "< %s: %s tag=%s size=%d\n", t.commandCode, formatResponseCode(t.commandCode, d.ResponseCode),
+		formatStructTag(d.Tag), d.ResponseSize)
+	t.writeHandles(buf, "<", d.Handles)
+	t.writeParams(buf, "<", d.Params)
+	for i, auth := range d.AuthArea {
+		fmt.Fprintf(buf, "<  session %d: nonce=%s attrs=%s hmac=%s\n", i+1, t.f.formatBytes(auth.Nonce),
+			formatSessionAttrs(auth.SessionAttrs), t.f.format(reflect.ValueOf(auth.HMAC)))
+	}
+	t.writeTrailer(buf, "<", err, d.Unparsed)
+}
+
+func (t *TctiTracer) Read(data []byte) (int, error) {
+	n, err := t.tcti.Read(data)
+	t.rsp = append(t.rsp, data[:n]...)
+	if internal.IsCompletePacket(t.rsp) {
+		t.traceResponse(t.rsp)
+		t.rsp = nil
+	}
+	if err != nil && err != io.EOF {
+		fmt.Fprintf(t.w, "< read error: %v\n", err)
+	}
+	return n, err
+}
+
+func (t *TctiTracer) Write(data []byte) (int, error) {
+	t.rsp = nil
+	t.cmd = append(t.cmd, data...)
+	if internal.IsCompletePacket(t.cmd) {
+		t.traceCommand(t.cmd)
+		t.cmd = nil
+	}
+	n, err := t.tcti.Write(data)
+	if err != nil {
+		fmt.Fprintf(t.w, "> write error: %v\n", err)
+	}
+	return n, err
+}
+
+func (t *TctiTracer) Close() error {
+	return t.tcti.Close()
+}
+
+func (t *TctiTracer) SetLocality(locality uint8) error {
+	return t.tcti.SetLocality(locality)
+}
+
+func (t *TctiTracer) MakeSticky(handle Handle, sticky bool) error {
+	return t.tcti.MakeSticky(handle, sticky)
+}
+
+// Cancel implements TCTICanceler.Cancel, and forwards the request to the underlying TCTI if it is supported.
+func (t *TctiTracer) Cancel() error {
+	c, ok := t.tcti.(TCTICanceler)
+	if !ok {
+		return errors.New("cancellation is not supported by the underlying TCTI")
+	}
+	return c.Cancel()
+}
+
+// SetReadDeadline implements TCTIReadDeadliner.SetReadDeadline, and forwards the request to the underlying TCTI if it is
+// supported.
+func (t *TctiTracer) SetReadDeadline(deadline time.Time) error {
+	d, ok := t.tcti.(TCTIReadDeadliner)
+	if !ok {
+		return errors.New("read deadlines are not supported by the underlying TCTI")
+	}
+	return d.SetReadDeadline(deadline)
+}
@@ -0,0 +1,751 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"reflect"
+)
+
+// commandSchemaParam describes a single command or response parameter.
+type commandSchemaParam struct {
+	name string
+	typ  reflect.Type
+}
+
+// commandSchema describes the handle and parameter areas of a command and its response. It is used by DecodeCommand and
+// DecodeResponse. Parameter names correspond to those in part 3 of the TPM Library Specification.
+type commandSchema struct {
+	handles    []string
+	params     []commandSchemaParam
+	rspHandles []string
+	rspParams  []commandSchemaParam
+
+	// sensitive contains the names of command and response parameters that contain secrets, but which have types that
+	// aren't always sensitive (eg, symmetric keys passed as Data).
+	sensitive []string
+}
+
+func (s *commandSchema) isSensitive(name string) bool {
+	for _, n := range s.sensitive {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(p interface{}) reflect.Type {
+	return reflect.TypeOf(p).Elem()
+}
+
+var commandSchemas = map[CommandCode]*commandSchema{
+	CommandACGetCapability: {
+		handles: []string{"acHandle"},
+		params: []commandSchemaParam{
+			{"capability", typeOf((*ACAttribute)(nil))},
+			{"count", typeOf((*uint32)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"moreData", typeOf((*bool)(nil))},
+			{"data", typeOf((*ACCapabilityList)(nil))},
+		},
+	},
+	CommandACSend: {
+		handles: []string{"sendObjectHandle", "authHandle", "acHandle"},
+		params: []commandSchemaParam{
+			{"acDataIn", typeOf((*MaxBuffer)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"acDataOut", typeOf((**ACOutput)(nil))},
+		},
+	},
+	CommandACTSetTimeout: {
+		handles: []string{"actHandle"},
+		params: []commandSchemaParam{
+			{"startTimeout", typeOf((*uint32)(nil))},
+		},
+	},
+	CommandActivateCredential: {
+		handles: []string{"activateHandle", "keyHandle"},
+		params: []commandSchemaParam{
+			{"credentialBlob", typeOf((*IDObjectRaw)(nil))},
+			{"secret", typeOf((*EncryptedSecret)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"certInfo", typeOf((*Digest)(nil))},
+		},
+		sensitive: []string{"certInfo"},
+	},
+	CommandCertify: {
+		handles: []string{"objectHandle", "signHandle"},
+		params: []commandSchemaParam{
+			{"qualifyingData", typeOf((*Data)(nil))},
+			{"inScheme", typeOf((**SigScheme)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"certifyInfo", typeOf((*attestSized)(nil))},
+			{"signature", typeOf((**Signature)(nil))},
+		},
+	},
+	CommandCertifyCreation: {
+		handles: []string{"signHandle", "objectHandle"},
+		params: []commandSchemaParam{
+			{"qualifyingData", typeOf((*Data)(nil))},
+			{"creationHash", typeOf((*Digest)(nil))},
+			{"inScheme", typeOf((**SigScheme)(nil))},
+			{"creationTicket", typeOf((**TkCreation)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"certifyInfo", typeOf((*attestSized)(nil))},
+			{"signature", typeOf((**Signature)(nil))},
+		},
+	},
+	CommandCertifyX509: {
+		handles: []string{"objectHandle", "signHandle"},
+		params: []commandSchemaParam{
+			{"reserved", typeOf((*Data)(nil))},
+			{"inScheme", typeOf((**SigScheme)(nil))},
+			{"partialCertificate", typeOf((*MaxBuffer)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"addedToCertificate", typeOf((*MaxBuffer)(nil))},
+			{"tbsDigest", typeOf((*Digest)(nil))},
+			{"signature", typeOf((**Signature)(nil))},
+		},
+	},
+	CommandClear: {
+		handles: []string{"authHandle"},
+	},
+	CommandClearControl: {
+		handles: []string{"authHandle"},
+		params: []commandSchemaParam{
+			{"disable", typeOf((*bool)(nil))},
+		},
+	},
+	CommandContextLoad: {
+		params: []commandSchemaParam{
+			{"context", typeOf((*Context)(nil))},
+		},
+		rspHandles: []string{"loadedHandle"},
+	},
+	CommandContextSave: {
+		handles: []string{"saveHandle"},
+		rspParams: []commandSchemaParam{
+			{"context", typeOf((**Context)(nil))},
+		},
+	},
+	CommandCreate: {
+		handles: []string{"parentHandle"},
+		params: []commandSchemaParam{
+			{"inSensitive", typeOf((*sensitiveCreateSized)(nil))},
+			{"inPublic", typeOf((*publicSized)(nil))},
+			{"outsideInfo", typeOf((*Data)(nil))},
+			{"creationPCR", typeOf((*PCRSelectionList)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"outPrivate", typeOf((*Private)(nil))},
+			{"outPublic", typeOf((*publicSized)(nil))},
+			{"creationData", typeOf((*creationDataSized)(nil))},
+			{"creationHash", typeOf((*Digest)(nil))},
+			{"creationTicket", typeOf((**TkCreation)(nil))},
+		},
+	},
+	CommandCreateLoaded: {
+		handles: []string{"parentHandle"},
+		params: []commandSchemaParam{
+			{"inSensitive", typeOf((*sensitiveCreateSized)(nil))},
+			{"inTemplate", typeOf((*Template)(nil))},
+		},
+		rspHandles: []string{"objectHandle"},
+		rspParams: []commandSchemaParam{
+			{"outPrivate", typeOf((*Private)(nil))},
+			{"outPublic", typeOf((*publicSized)(nil))},
+			{"name", typeOf((*Name)(nil))},
+		},
+	},
+	CommandCreatePrimary: {
+		handles: []string{"primaryObject"},
+		params: []commandSchemaParam{
+			{"inSensitive", typeOf((*sensitiveCreateSized)(nil))},
+			{"inPublic", typeOf((*publicSized)(nil))},
+			{"outsideInfo", typeOf((*Data)(nil))},
+			{"creationPCR", typeOf((*PCRSelectionList)(nil))},
+		},
+		rspHandles: []string{"objectHandle"},
+		rspParams: []commandSchemaParam{
+			{"outPublic", typeOf((*publicSized)(nil))},
+			{"creationData", typeOf((*creationDataSized)(nil))},
+			{"creationHash", typeOf((*Digest)(nil))},
+			{"creationTicket", typeOf((**TkCreation)(nil))},
+			{"name", typeOf((*Name)(nil))},
+		},
+	},
+	CommandDictionaryAttackLockReset: {
+		handles: []string{"lockHandle"},
+	},
+	CommandDictionaryAttackParameters: {
+		handles: []string{"lockHandle"},
+		params: []commandSchemaParam{
+			{"newMaxTries", typeOf((*uint32)(nil))},
+			{"newRecoveryTime", typeOf((*uint32)(nil))},
+			{"lockoutRecovery", typeOf((*uint32)(nil))},
+		},
+	},
+	CommandDuplicate: {
+		handles: []string{"objectHandle", "newParentHandle"},
+		params: []commandSchemaParam{
+			{"encryptionKeyIn", typeOf((*Data)(nil))},
+			{"symmetricAlg", typeOf((**SymDefObject)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"encryptionKeyOut", typeOf((*Data)(nil))},
+			{"duplicate", typeOf((*Private)(nil))},
+			{"outSymSeed", typeOf((*EncryptedSecret)(nil))},
+		},
+		sensitive: []string{"encryptionKeyIn", "encryptionKeyOut"},
+	},
+	CommandEventSequenceComplete: {
+		handles: []string{"pcrHandle", "sequenceHandle"},
+		params: []commandSchemaParam{
+			{"buffer", typeOf((*MaxBuffer)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"results", typeOf((*TaggedHashList)(nil))},
+		},
+	},
+	CommandEvictControl: {
+		handles: []string{"auth", "object"},
+		params: []commandSchemaParam{
+			{"persistentHandle", typeOf((*Handle)(nil))},
+		},
+	},
+	CommandFieldUpgradeData: {
+		params: []commandSchemaParam{
+			{"fuData", typeOf((*MaxBuffer)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"nextDigest", typeOf((*fieldUpgradeDigest)(nil))},
+			{"firstDigest", typeOf((*fieldUpgradeDigest)(nil))},
+		},
+	},
+	CommandFieldUpgradeStart: {
+		handles: []string{"authHandle", "keyHandle"},
+		params: []commandSchemaParam{
+			{"fuDigest", typeOf((*Digest)(nil))},
+			{"manifestSignature", typeOf((**Signature)(nil))},
+		},
+	},
+	CommandFirmwareRead: {
+		params: []commandSchemaParam{
+			{"sequenceNumber", typeOf((*uint32)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"fuData", typeOf((*MaxBuffer)(nil))},
+		},
+	},
+	CommandFlushContext: {
+		params: []commandSchemaParam{
+			{"flushHandle", typeOf((*Handle)(nil))},
+		},
+	},
+	CommandGetCapability: {
+		params: []commandSchemaParam{
+			{"capability", typeOf((*Capability)(nil))},
+			{"property", typeOf((*uint32)(nil))},
+			{"propertyCount", typeOf((*uint32)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"moreData", typeOf((*bool)(nil))},
+			{"data", typeOf((*CapabilityData)(nil))},
+		},
+	},
+	CommandGetCommandAuditDigest: {
+		handles: []string{"privacyHandle", "signHandle"},
+		params: []commandSchemaParam{
+			{"qualifyingData", typeOf((*Data)(nil))},
+			{"inScheme", typeOf((**SigScheme)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"auditInfo", typeOf((*attestSized)(nil))},
+			{"signature", typeOf((**Signature)(nil))},
+		},
+	},
+	CommandGetRandom: {
+		params: []commandSchemaParam{
+			{"bytesRequested", typeOf((*uint16)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"randomBytes", typeOf((*Digest)(nil))},
+		},
+	},
+	CommandGetSessionAuditDigest: {
+		handles: []string{"privacyAdminHandle", "signHandle", "sessionHandle"},
+		params: []commandSchemaParam{
+			{"qualifyingData", typeOf((*Data)(nil))},
+			{"inScheme", typeOf((**SigScheme)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"auditInfo", typeOf((*attestSized)(nil))},
+			{"signature", typeOf((**Signature)(nil))},
+		},
+	},
+	CommandGetTestResult: {
+		rspParams: []commandSchemaParam{
+			{"outData", typeOf((*MaxBuffer)(nil))},
+			{"testResult", typeOf((*ResponseCode)(nil))},
+		},
+	},
+	CommandGetTime: {
+		handles: []string{"privacyAdminHandle", "signHandle"},
+		params: []commandSchemaParam{
+			{"qualifyingData", typeOf((*Data)(nil))},
+			{"inScheme", typeOf((**SigScheme)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"timeInfo", typeOf((*attestSized)(nil))},
+			{"signature", typeOf((**Signature)(nil))},
+		},
+	},
+	CommandHMACStart: {
+		handles: []string{"handle"},
+		params: []commandSchemaParam{
+			{"auth", typeOf((*Auth)(nil))},
+			{"hashAlg", typeOf((*HashAlgorithmId)(nil))},
+		},
+		rspHandles: []string{"sequenceHandle"},
+	},
+	CommandHashSequenceStart: {
+		params: []commandSchemaParam{
+			{"auth", typeOf((*Auth)(nil))},
+			{"hashAlg", typeOf((*HashAlgorithmId)(nil))},
+		},
+		rspHandles: []string{"sequenceHandle"},
+	},
+	CommandHierarchyChangeAuth: {
+		handles: []string{"authHandle"},
+		params: []commandSchemaParam{
+			{"newAuth", typeOf((*Auth)(nil))},
+		},
+	},
+	CommandHierarchyControl: {
+		handles: []string{"authHandle"},
+		params: []commandSchemaParam{
+			{"enable", typeOf((*Handle)(nil))},
+			{"state", typeOf((*bool)(nil))},
+		},
+	},
+	CommandImport: {
+		handles: []string{"parentHandle"},
+		params: []commandSchemaParam{
+			{"encryptionKey", typeOf((*Data)(nil))},
+			{"objectPublic", typeOf((*publicSized)(nil))},
+			{"duplicate", typeOf((*Private)(nil))},
+			{"inSymSeed", typeOf((*EncryptedSecret)(nil))},
+			{"symmetricAlg", typeOf((**SymDefObject)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"outPrivate", typeOf((*Private)(nil))},
+		},
+		sensitive: []string{"encryptionKey"},
+	},
+	CommandIncrementalSelfTest: {
+		params: []commandSchemaParam{
+			{"toTest", typeOf((*AlgorithmList)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"toDoList", typeOf((*AlgorithmList)(nil))},
+		},
+	},
+	CommandLoad: {
+		handles: []string{"parentHandle"},
+		params: []commandSchemaParam{
+			{"inPrivate", typeOf((*Private)(nil))},
+			{"inPublic", typeOf((*publicSized)(nil))},
+		},
+		rspHandles: []string{"objectHandle"},
+		rspParams: []commandSchemaParam{
+			{"name", typeOf((*Name)(nil))},
+		},
+	},
+	CommandLoadExternal: {
+		params: []commandSchemaParam{
+			{"inPrivate", typeOf((*sensitiveSized)(nil))},
+			{"inPublic", typeOf((*publicSized)(nil))},
+			{"hierarchy", typeOf((*Handle)(nil))},
+		},
+		rspHandles: []string{"objectHandle"},
+		rspParams: []commandSchemaParam{
+			{"name", typeOf((*Name)(nil))},
+		},
+	},
+	CommandMakeCredential: {
+		handles: []string{"handle"},
+		params: []commandSchemaParam{
+			{"credential", typeOf((*Digest)(nil))},
+			{"objectName", typeOf((*Name)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"credentialBlob", typeOf((*IDObjectRaw)(nil))},
+			{"secret", typeOf((*EncryptedSecret)(nil))},
+		},
+	},
+	CommandNVChangeAuth: {
+		handles: []string{"nvIndex"},
+		params: []commandSchemaParam{
+			{"newAuth", typeOf((*Auth)(nil))},
+		},
+	},
+	CommandNVDefineSpace: {
+		handles: []string{"authHandle"},
+		params: []commandSchemaParam{
+			{"auth", typeOf((*Auth)(nil))},
+			{"publicInfo", typeOf((*nvPublicSized)(nil))},
+		},
+	},
+	CommandNVExtend: {
+		handles: []string{"authHandle", "nvIndex"},
+		params: []commandSchemaParam{
+			{"data", typeOf((*MaxNVBuffer)(nil))},
+		},
+	},
+	CommandNVGlobalWriteLock: {
+		handles: []string{"authHandle"},
+	},
+	CommandNVIncrement: {
+		handles: []string{"authHandle", "nvIndex"},
+	},
+	CommandNVRead: {
+		handles: []string{"authHandle", "nvIndex"},
+		params: []commandSchemaParam{
+			{"size", typeOf((*uint16)(nil))},
+			{"offset", typeOf((*uint16)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"data", typeOf((*MaxNVBuffer)(nil))},
+		},
+	},
+	CommandNVReadLock: {
+		handles: []string{"authHandle", "nvIndex"},
+	},
+	CommandNVReadPublic: {
+		handles: []string{"nvIndex"},
+		rspParams: []commandSchemaParam{
+			{"nvPublic", typeOf((*nvPublicSized)(nil))},
+			{"nvName", typeOf((*Name)(nil))},
+		},
+	},
+	CommandNVSetBits: {
+		handles: []string{"authHandle", "nvIndex"},
+		params: []commandSchemaParam{
+			{"bits", typeOf((*uint64)(nil))},
+		},
+	},
+	CommandNVUndefineSpace: {
+		handles: []string{"authHandle", "nvIndex"},
+	},
+	CommandNVUndefineSpaceSpecial: {
+		handles: []string{"nvIndex", "platform"},
+	},
+	CommandNVWrite: {
+		handles: []string{"authHandle", "nvIndex"},
+		params: []commandSchemaParam{
+			{"data", typeOf((*MaxNVBuffer)(nil))},
+			{"offset", typeOf((*uint16)(nil))},
+		},
+	},
+	CommandNVWriteLock: {
+		handles: []string{"authHandle", "nvIndex"},
+	},
+	CommandObjectChangeAuth: {
+		handles: []string{"objectHandle", "parentHandle"},
+		params: []commandSchemaParam{
+			{"newAuth", typeOf((*Auth)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"outPrivate", typeOf((*Private)(nil))},
+		},
+	},
+	CommandPCREvent: {
+		handles: []string{"pcrHandle"},
+		params: []commandSchemaParam{
+			{"eventData", typeOf((*Event)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"digests", typeOf((*TaggedHashList)(nil))},
+		},
+	},
+	CommandPCRExtend: {
+		handles: []string{"pcrHandle"},
+		params: []commandSchemaParam{
+			{"digests", typeOf((*TaggedHashList)(nil))},
+		},
+	},
+	CommandPCRRead: {
+		params: []commandSchemaParam{
+			{"pcrSelectionIn", typeOf((*PCRSelectionList)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"updateCounter", typeOf((*uint32)(nil))},
+			{"pcrSelectionOut", typeOf((*PCRSelectionList)(nil))},
+			{"pcrValues", typeOf((*DigestList)(nil))},
+		},
+	},
+	CommandPCRReset: {
+		handles: []string{"pcrHandle"},
+	},
+	CommandPolicyACSendSelect: {
+		handles: []string{"policySession"},
+		params: []commandSchemaParam{
+			{"objectName", typeOf((*Name)(nil))},
+			{"authHandleName", typeOf((*Name)(nil))},
+			{"acName", typeOf((*Name)(nil))},
+			{"includeObject", typeOf((*bool)(nil))},
+		},
+	},
+	CommandPolicyAuthValue: {
+		handles: []string{"policySession"},
+	},
+	CommandPolicyAuthorize: {
+		handles: []string{"policySession"},
+		params: []commandSchemaParam{
+			{"approvedPolicy", typeOf((*Digest)(nil))},
+			{"policyRef", typeOf((*Nonce)(nil))},
+			{"keySign", typeOf((*Name)(nil))},
+			{"checkTicket", typeOf((**TkVerified)(nil))},
+		},
+	},
+	CommandPolicyCommandCode: {
+		handles: []string{"policySession"},
+		params: []commandSchemaParam{
+			{"code", typeOf((*CommandCode)(nil))},
+		},
+	},
+	CommandPolicyCounterTimer: {
+		handles: []string{"policySession"},
+		params: []commandSchemaParam{
+			{"operandB", typeOf((*Operand)(nil))},
+			{"offset", typeOf((*uint16)(nil))},
+			{"operation", typeOf((*ArithmeticOp)(nil))},
+		},
+	},
+	CommandPolicyCpHash: {
+		handles: []string{"policySession"},
+		params: []commandSchemaParam{
+			{"cpHashA", typeOf((*Digest)(nil))},
+		},
+	},
+	CommandPolicyDuplicationSelect: {
+		handles: []string{"policySession"},
+		params: []commandSchemaParam{
+			{"objectName", typeOf((*Name)(nil))},
+			{"newParentName", typeOf((*Name)(nil))},
+			{"includeObject", typeOf((*bool)(nil))},
+		},
+	},
+	CommandPolicyGetDigest: {
+		handles: []string{"policySession"},
+		rspParams: []commandSchemaParam{
+			{"policyDigest", typeOf((*Digest)(nil))},
+		},
+	},
+	CommandPolicyNV: {
+		handles: []string{"authHandle", "nvIndex", "policySession"},
+		params: []commandSchemaParam{
+			{"operandB", typeOf((*Operand)(nil))},
+			{"offset", typeOf((*uint16)(nil))},
+			{"operation", typeOf((*ArithmeticOp)(nil))},
+		},
+	},
+	CommandPolicyNameHash: {
+		handles: []string{"policySession"},
+		params: []commandSchemaParam{
+			{"nameHash", typeOf((*Digest)(nil))},
+		},
+	},
+	CommandPolicyNvWritten: {
+		handles: []string{"policySession"},
+		params: []commandSchemaParam{
+			{"writtenSet", typeOf((*bool)(nil))},
+		},
+	},
+	CommandPolicyOR: {
+		handles: []string{"policySession"},
+		params: []commandSchemaParam{
+			{"pHashList", typeOf((*DigestList)(nil))},
+		},
+	},
+	CommandPolicyPCR: {
+		handles: []string{"policySession"},
+		params: []commandSchemaParam{
+			{"pcrDigest", typeOf((*Digest)(nil))},
+			{"pcrs", typeOf((*PCRSelectionList)(nil))},
+		},
+	},
+	CommandPolicyPassword: {
+		handles: []string{"policySession"},
+	},
+	CommandPolicyRestart: {
+		handles: []string{"sessionHandle"},
+	},
+	CommandPolicySecret: {
+		handles: []string{"authHandle", "policySession"},
+		params: []commandSchemaParam{
+			{"nonceTPM", typeOf((*Nonce)(nil))},
+			{"cpHashA", typeOf((*Digest)(nil))},
+			{"policyRef", typeOf((*Nonce)(nil))},
+			{"expiration", typeOf((*int32)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"timeout", typeOf((*Timeout)(nil))},
+			{"policyTicket", typeOf((**TkAuth)(nil))},
+		},
+	},
+	CommandPolicySigned: {
+		handles: []string{"authHandle", "policySession"},
+		params: []commandSchemaParam{
+			{"nonceTPM", typeOf((*Nonce)(nil))},
+			{"cpHashA", typeOf((*Digest)(nil))},
+			{"policyRef", typeOf((*Nonce)(nil))},
+			{"expiration", typeOf((*int32)(nil))},
+			{"auth", typeOf((**Signature)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"timeout", typeOf((*Timeout)(nil))},
+			{"policyTicket", typeOf((**TkAuth)(nil))},
+		},
+	},
+	CommandPolicyTicket: {
+		handles: []string{"policySession"},
+		params: []commandSchemaParam{
+			{"timeout", typeOf((*Timeout)(nil))},
+			{"cpHashA", typeOf((*Digest)(nil))},
+			{"policyRef", typeOf((*Nonce)(nil))},
+			{"authName", typeOf((*Name)(nil))},
+			{"ticket", typeOf((**TkAuth)(nil))},
+		},
+	},
+	CommandQuote: {
+		handles: []string{"signHandle"},
+		params: []commandSchemaParam{
+			{"qualifyingData", typeOf((*Data)(nil))},
+			{"inScheme", typeOf((**SigScheme)(nil))},
+			{"pcrs", typeOf((*PCRSelectionList)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"quoted", typeOf((*attestSized)(nil))},
+			{"signature", typeOf((**Signature)(nil))},
+		},
+	},
+	CommandReadClock: {
+		rspParams: []commandSchemaParam{
+			{"currentTime", typeOf((**TimeInfo)(nil))},
+		},
+	},
+	CommandReadPublic: {
+		handles: []string{"objectHandle"},
+		rspParams: []commandSchemaParam{
+			{"outPublic", typeOf((*publicSized)(nil))},
+			{"name", typeOf((*Name)(nil))},
+			{"qualifiedName", typeOf((*Name)(nil))},
+		},
+	},
+	CommandSelfTest: {
+		params: []commandSchemaParam{
+			{"fullTest", typeOf((*bool)(nil))},
+		},
+	},
+	CommandSequenceComplete: {
+		handles: []string{"sequenceHandle"},
+		params: []commandSchemaParam{
+			{"buffer", typeOf((*MaxBuffer)(nil))},
+			{"hierarchy", typeOf((*Handle)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"result", typeOf((*Digest)(nil))},
+			{"validation", typeOf((**TkHashcheck)(nil))},
+		},
+	},
+	CommandSequenceUpdate: {
+		handles: []string{"sequenceHandle"},
+		params: []commandSchemaParam{
+			{"buffer", typeOf((*MaxBuffer)(nil))},
+		},
+	},
+	CommandSetAlgorithmSet: {
+		handles: []string{"platformHandle"},
+		params: []commandSchemaParam{
+			{"algorithmSet", typeOf((*uint32)(nil))},
+		},
+	},
+	CommandSetCommandCodeAuditStatus: {
+		handles: []string{"auth"},
+		params: []commandSchemaParam{
+			{"auditAlg", typeOf((*HashAlgorithmId)(nil))},
+			{"setList", typeOf((*CommandCodeList)(nil))},
+			{"clearList", typeOf((*CommandCodeList)(nil))},
+		},
+	},
+	CommandShutdown: {
+		params: []commandSchemaParam{
+			{"shutdownType", typeOf((*StartupType)(nil))},
+		},
+	},
+	CommandSign: {
+		handles: []string{"keyHandle"},
+		params: []commandSchemaParam{
+			{"digest", typeOf((*Digest)(nil))},
+			{"inScheme", typeOf((**SigScheme)(nil))},
+			{"validation", typeOf((**TkHashcheck)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"signature", typeOf((**Signature)(nil))},
+		},
+	},
+	CommandStartAuthSession: {
+		handles: []string{"tpmKey", "bind"},
+		params: []commandSchemaParam{
+			{"nonceCaller", typeOf((*Nonce)(nil))},
+			{"encryptedSalt", typeOf((*EncryptedSecret)(nil))},
+			{"sessionType", typeOf((*SessionType)(nil))},
+			{"symmetric", typeOf((**SymDef)(nil))},
+			{"authHash", typeOf((*HashAlgorithmId)(nil))},
+		},
+		rspHandles: []string{"sessionHandle"},
+		rspParams: []commandSchemaParam{
+			{"nonceTPM", typeOf((*Nonce)(nil))},
+		},
+	},
+	CommandStartup: {
+		params: []commandSchemaParam{
+			{"startupType", typeOf((*StartupType)(nil))},
+		},
+	},
+	CommandStirRandom: {
+		params: []commandSchemaParam{
+			{"inData", typeOf((*SensitiveData)(nil))},
+		},
+	},
+	CommandTestParms: {
+		params: []commandSchemaParam{
+			{"parameters", typeOf((**PublicParams)(nil))},
+		},
+	},
+	CommandUnseal: {
+		handles: []string{"itemHandle"},
+		rspParams: []commandSchemaParam{
+			{"outData", typeOf((*SensitiveData)(nil))},
+		},
+	},
+	CommandVerifySignature: {
+		handles: []string{"keyHandle"},
+		params: []commandSchemaParam{
+			{"digest", typeOf((*Digest)(nil))},
+			{"signature", typeOf((**Signature)(nil))},
+		},
+		rspParams: []commandSchemaParam{
+			{"validation", typeOf((**TkVerified)(nil))},
+		},
+	},
+}
@@ -0,0 +1,132 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+type tracerSuite struct {
+	testutil.BaseTest
+	tcti *scriptedTcti
+	log  *bytes.Buffer
+	tpm  *TPMContext
+}
+
+var _ = Suite(&tracerSuite{})
+
+func (s *tracerSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	s.tcti = &scriptedTcti{}
+	s.log = new(bytes.Buffer)
+	s.tpm, _ = NewTPMContext(NewTctiTracer(s.tcti, s.log))
+}
+
+func (s *tracerSuite) addHierarchyChangeAuthExchange(c *C) {
+	s.tcti.script = append(s.tcti.script, scriptedTctiExchange{
+		command: makeScriptedPacket(c, TagSessions, uint32(CommandHierarchyChangeAuth),
+			HandleOwner, uint32(9), HandlePW, uint16(0), uint8(1), uint16(0), Auth("foo")),
+		response: makeScriptedPacket(c, TagSessions, uint32(Success), uint32(0), uint16(0), uint8(1), uint16(0))})
+}
+
+func (s *tracerSuite) TestTraceCommandWithSessions(c *C) {
+	s.addHierarchyChangeAuthExchange(c)
+
+	c.Check(s.tpm.HierarchyChangeAuth(s.tpm.OwnerHandleContext(), Auth("foo"), nil), IsNil)
+	c.Check(s.log.String(), Equals,
+		"> TPM_CC_HierarchyChangeAuth (0x00000129) tag=TPM_ST_SESSIONS size=32\n"+
+			">  authHandle: TPM_RH_OWNER\n"+
+			">  session 1: handle=TPM_RS_PW nonce=(empty) attrs=continueSession hmac=<redacted>\n"+
+			">  newAuth: <redacted>\n"+
+			"< TPM_CC_HierarchyChangeAuth: 0x00000000 TPM_RC_SUCCESS tag=TPM_ST_SESSIONS size=19\n"+
+			"<  session 1: nonce=(empty) attrs=continueSession hmac=<redacted>\n")
+}
+
+func (s *tracerSuite) TestTraceShowSensitive(c *C) {
+	s.tcti = &scriptedTcti{}
+	tracer := NewTctiTracer(s.tcti, s.log)
+	tracer.SetShowSensitive(true)
+	s.tpm, _ = NewTPMContext(tracer)
+	s.addHierarchyChangeAuthExchange(c)
+
+	c.Check(s.tpm.HierarchyChangeAuth(s.tpm.OwnerHandleContext(), Auth("foo"), nil), IsNil)
+	c.Check(s.log.String(), Matches, `(?s).*>  newAuth: 666f6f\n.*`)
+	c.Check(s.log.String(), Matches, `(?s).*hmac=\(empty\)\n.*`)
+}
+
+// traceDuplicate sends a TPM2_Duplicate command and response through a new TctiTracer and returns the log.
+func (s *tracerSuite) traceDuplicate(c *C, showSensitive bool) string {
+	cmd := makeScriptedPacket(c, TagSessions, uint32(CommandDuplicate),
+		Handle(0x80000001), Handle(0x80000002), uint32(9), HandlePW, uint16(0), uint8(1), uint16(0),
+		Data{0xaa, 0xbb}, &SymDefObject{Algorithm: SymObjectAlgorithmNull})
+	rsp := makeScriptedPacket(c, TagSessions, uint32(Success), uint32(12),
+		Data{0xcc, 0xdd}, Private{1, 2}, EncryptedSecret{3, 4}, uint16(0), uint8(1), uint16(0))
+
+	log := new(bytes.Buffer)
+	tracer := NewTctiTracer(&scriptedTcti{script: []scriptedTctiExchange{{command: cmd, response: rsp}}}, log)
+	tracer.SetShowSensitive(showSensitive)
+	_, err := tracer.Write(cmd)
+	c.Assert(err, IsNil)
+	_, err = tracer.Read(make([]byte, len(rsp)))
+	c.Assert(err, IsNil)
+	return log.String()
+}
+
+func (s *tracerSuite) TestTraceSensitiveParameters(c *C) {
+	log := s.traceDuplicate(c, false)
+	c.Check(log, Matches, `(?s).*>  encryptionKeyIn: <redacted>\n.*`)
+	c.Check(log, Matches, `(?s).*<  encryptionKeyOut: <redacted>\n.*`)
+	c.Check(log, Matches, `(?s).*<  duplicate: 0102\n.*`)
+	c.Check(log, Not(Matches), `(?s).*(aabb|ccdd).*`)
+}
+
+func (s *tracerSuite) TestTraceSensitiveParametersShowSensitive(c *C) {
+	log := s.traceDuplicate(c, true)
+	c.Check(log, Matches, `(?s).*>  encryptionKeyIn: aabb\n.*`)
+	c.Check(log, Matches, `(?s).*<  encryptionKeyOut: ccdd\n.*`)
+}
+
+func (s *tracerSuite) TestTraceResponseParameters(c *C) {
+	s.tcti.script = []scriptedTctiExchange{{
+		command:  makeScriptedPacket(c, TagNoSessions, uint32(CommandGetRandom), uint16(4)),
+		response: makeScriptedPacket(c, TagNoSessions, uint32(Success), Digest{1, 2, 3, 4})}}
+
+	var randomBytes Digest
+	c.Check(s.tpm.RunCommand(CommandGetRandom, nil, Delimiter, uint16(4), Delimiter, Delimiter, &randomBytes), IsNil)
+	c.Check(s.log.String(), Equals,
+		"> TPM_CC_GetRandom (0x0000017b) tag=TPM_ST_NO_SESSIONS size=12\n"+
+			">  bytesRequested: 4\n"+
+			"< TPM_CC_GetRandom: 0x00000000 TPM_RC_SUCCESS tag=TPM_ST_NO_SESSIONS size=16\n"+
+			"<  randomBytes: 01020304\n")
+}
+
+func (s *tracerSuite) TestTraceStructuredParameters(c *C) {
+	s.tcti.script = []scriptedTctiExchange{{
+		command: makeScriptedPacket(c, TagNoSessions, uint32(CommandGetCapability),
+			CapabilityHandles, uint32(HandleTypePersistent)<<24, uint32(1)),
+		response: makeScriptedPacket(c, TagNoSessions, uint32(Success), false,
+			&CapabilityData{Capability: CapabilityHandles, Data: &CapabilitiesU{Handles: HandleList{0x81000001}}})}}
+
+	_, err := s.tpm.GetCapabilityHandles(HandleTypePersistent.BaseHandle(), 1)
+	c.Check(err, IsNil)
+	c.Check(s.log.String(), Matches, `(?s).*<  data: \{Capability: TPM_CAP_HANDLES, Data: \{Handles: \[0x81000001\]\}\}\n`)
+}
+
+func (s *tracerSuite) TestTraceErrorResponse(c *C) {
+	s.tcti.script = []scriptedTctiExchange{{
+		command:  makeScriptedPacket(c, TagNoSessions, uint32(CommandGetRandom), uint16(4)),
+		response: makeScriptedPacket(c, TagNoSessions, 0x1c4)}}
+
+	var randomBytes Digest
+	err := s.tpm.RunCommand(CommandGetRandom, nil, Delimiter, uint16(4), Delimiter, Delimiter, &randomBytes)
+	c.Check(IsTPMParameterError(err, ErrorValue, CommandGetRandom, 1), testutil.IsTrue)
+	c.Check(s.log.String(), Matches,
+		`(?s).*< TPM_CC_GetRandom: 0x000001c4 TPM_RC_VALUE \(parameter 1\) tag=TPM_ST_NO_SESSIONS size=10\n`)
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// trackHandleContext records a transient object, sequence object or session created by this TPMContext, so that it can be
+// flushed by TPMContext.FlushAll. Any existing record of a context with the same handle is discarded, as it must correspond to a
+// resource that no longer exists or, in the case of a session, to a saved context of the same session.
+func (t *TPMContext) trackHandleContext(hc HandleContext) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	handle := hc.Handle()
+	tracked := t.trackedContexts[:0]
+	for _, c := range t.trackedContexts {
+		if c.Handle() == handle || c.Handle() == HandleUnassigned {
+			continue
+		}
+		tracked = append(tracked, c)
+	}
+	t.trackedContexts = append(tracked, hc)
+}
+
+// untrackHandle discards any record of a context with the specified handle, which has been flushed from the TPM.
+func (t *TPMContext) untrackHandle(handle Handle) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tracked := t.trackedContexts[:0]
+	for _, c := range t.trackedContexts {
+		if c.Handle() == handle {
+			c.(handleContextPrivate).invalidate()
+			continue
+		}
+		tracked = append(tracked, c)
+	}
+	t.trackedContexts = tracked
+}
+
+// TrackedHandleContexts returns the HandleContexts for all of the transient objects, sequence objects and sessions created by this
+// TPMContext that have not been flushed. These are the resources that would be flushed by TPMContext.FlushAll. A resource that was
+// flushed without this TPMContext's knowledge, eg, by another process or because of a TPM reset, will still be returned.
+//
+// This can be used to detect resources that are leaked because TPMContext.FlushContext was not called for them.
+func (t *TPMContext) TrackedHandleContexts() []HandleContext {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var out []HandleContext
+	for _, c := range t.trackedContexts {
+		if c.Handle() == HandleUnassigned {
+			// The resource has been flushed or the session has been closed by the TPM.
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// FlushAll flushes all of the transient objects, sequence objects and sessions created by this TPMContext that have not already
+// been flushed, using TPMContext.FlushContext. The objects are created by TPMContext.CreatePrimary, TPMContext.Load,
+// TPMContext.LoadExternal, TPMContext.CreateLoaded, TPMContext.HMACStart, TPMContext.HashSequenceStart,
+// TPMContext.StartAuthSession and TPMContext.ContextLoad. Resources that no longer exist on the TPM are ignored.
+//
+// All resources are flushed even if an error occurs, in which case the first error is returned.
+func (t *TPMContext) FlushAll() error {
+	var firstErr error
+	for _, c := range t.TrackedHandleContexts() {
+		err := t.FlushContext(c)
+		switch {
+		case err == nil:
+		case IsTPMParameterError(err, ErrorHandle, CommandFlushContext, 1):
+			// The resource no longer exists.
+			c.(handleContextPrivate).invalidate()
+		case firstErr == nil:
+			firstErr = xerrors.Errorf("cannot flush context for handle %v: %w", c.Handle(), err)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tracked := t.trackedContexts[:0]
+	for _, c := range t.trackedContexts {
+		if c.Handle() != HandleUnassigned {
+			tracked = append(tracked, c)
+		}
+	}
+	t.trackedContexts = tracked
+
+	return firstErr
+}
+
+// SetFlushOnClose sets whether TPMContext.Close calls TPMContext.FlushAll before closing the transmission interface. This is
+// disabled by default.
+func (t *TPMContext) SetFlushOnClose(flush bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.flushOnClose = flush
+}
@@ -0,0 +1,144 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+type trackingSuite struct {
+	testutil.TPMTest
+}
+
+var _ = Suite(&trackingSuite{})
+
+func (s *trackingSuite) flushableHandles(c *C, tpm *TPMContext) (out []Handle) {
+	for _, t := range []HandleType{HandleTypeTransient, HandleTypeLoadedSession} {
+		h, err := tpm.GetCapabilityHandles(t.BaseHandle(), CapabilityMaxProperties)
+		c.Assert(err, IsNil)
+		out = append(out, h...)
+	}
+	return
+}
+
+func (s *trackingSuite) createResources(c *C, tpm *TPMContext) []HandleContext {
+	seq, err := tpm.HashSequenceStart(nil, HashAlgorithmSHA256)
+	c.Assert(err, IsNil)
+	session, err := tpm.StartAuthSession(nil, nil, SessionTypeHMAC, nil, HashAlgorithmSHA256)
+	c.Assert(err, IsNil)
+	return []HandleContext{seq, session}
+}
+
+// checkFlushed checks that none of the supplied handles exist on the TPM.
+func (s *trackingSuite) checkFlushed(c *C, tpm *TPMContext, handles []Handle) {
+	for _, h := range s.flushableHandles(c, tpm) {
+		for _, f := range handles {
+			c.Check(h, Not(Equals), f)
+		}
+	}
+}
+
+func (s *trackingSuite) TestTrackedHandleContexts(c *C) {
+	c.Check(s.TPM.TrackedHandleContexts(), HasLen, 0)
+
+	resources := s.createResources(c, s.TPM)
+	c.Check(s.TPM.TrackedHandleContexts(), DeepEquals, resources)
+
+	c.Check(s.TPM.FlushContext(resources[0]), IsNil)
+	c.Check(s.TPM.TrackedHandleContexts(), DeepEquals, resources[1:])
+	c.Check(s.TPM.FlushContext(resources[1]), IsNil)
+}
+
+func (s *trackingSuite) TestTrackedHandleContextsFlushedByHandle(c *C) {
+	resources := s.createResources(c, s.TPM)
+
+	// Flush the session using a different HandleContext.
+	c.Check(s.TPM.FlushContext(CreateIncompleteSessionContext(resources[1].Handle())), IsNil)
+	c.Check(s.TPM.TrackedHandleContexts(), DeepEquals, resources[:1])
+	c.Check(resources[1].Handle(), Equals, HandleUnassigned)
+	c.Check(s.TPM.FlushContext(resources[0]), IsNil)
+}
+
+func (s *trackingSuite) TestFlushAll(c *C) {
+	resources := s.createResources(c, s.TPM)
+	handles := []Handle{resources[0].Handle(), resources[1].Handle()}
+
+	c.Check(s.TPM.FlushAll(), IsNil)
+	s.checkFlushed(c, s.TPM, handles)
+	c.Check(s.TPM.TrackedHandleContexts(), HasLen, 0)
+}
+
+func (s *trackingSuite) TestFlushAllIgnoresMissingResources(c *C) {
+	resources := s.createResources(c, s.TPM)
+	handles := []Handle{resources[0].Handle(), resources[1].Handle()}
+
+	// Flush the session from another TPMContext, without this TPMContext's knowledge.
+	other, _ := NewTPMContext(s.TCTI)
+	c.Check(other.FlushContext(CreateIncompleteSessionContext(resources[1].Handle())), IsNil)
+
+	c.Check(s.TPM.FlushAll(), IsNil)
+	s.checkFlushed(c, s.TPM, handles)
+	c.Check(s.TPM.TrackedHandleContexts(), HasLen, 0)
+	for _, r := range resources {
+		c.Check(r.Handle(), Equals, HandleUnassigned)
+	}
+}
+
+func (s *trackingSuite) TestCloseWithFlushOnClose(c *C) {
+	// Use a separate connection, as closing the suite's TPMContext would break the test teardown.
+	tpm, _, err := testutil.NewTPMContext(s.TPMFeatures)
+	c.Assert(err, IsNil)
+	resources := s.createResources(c, tpm)
+	handles := []Handle{resources[0].Handle(), resources[1].Handle()}
+
+	tpm.SetFlushOnClose(true)
+	c.Check(tpm.Close(), IsNil)
+
+	s.checkFlushed(c, s.TPM, handles)
+}
+
+// leakingSuite is run by trackingSuite.TestLeakedResourcesFailTest to check that the teardown of testutil.TPMTestBase fails a test
+// that doesn't flush the resources that it creates.
+type leakingSuite struct {
+	testutil.TPMTest
+	handle Handle
+}
+
+func (s *leakingSuite) TestLeak(c *C) {
+	session, err := s.TPM.StartAuthSession(nil, nil, SessionTypeHMAC, nil, HashAlgorithmSHA256)
+	c.Assert(err, IsNil)
+	s.handle = session.Handle()
+}
+
+func (s *trackingSuite) TestLeakedResourcesFailTest(c *C) {
+	suite := &leakingSuite{TPMTest: testutil.TPMTest{TPMFeatures: s.TPMFeatures}}
+	output := new(bytes.Buffer)
+	result := Run(suite, &RunConf{Output: output})
+	c.Check(result.Succeeded, Equals, 0)
+	c.Check(result.Failed, Equals, 1)
+	c.Check(output.String(), Matches, `(?s).*Leaked resource with handle 0x[[:xdigit:]]{8}.*1 resources were not flushed by the test.*`)
+
+	// The leaked session should still have been flushed.
+	c.Assert(suite.handle, Not(Equals), Handle(0))
+	s.checkFlushed(c, s.TPM, []Handle{suite.handle})
+}
+
+func (s *trackingSuite) TestPermitLeakedResources(c *C) {
+	suite := &leakingSuite{TPMTest: testutil.TPMTest{TPMFeatures: s.TPMFeatures}}
+	suite.PermitLeakedResources = true
+	output := new(bytes.Buffer)
+	result := Run(suite, &RunConf{Output: output, Stream: true})
+	c.Check(result.Succeeded, Equals, 1)
+	c.Check(result.Failed, Equals, 0)
+	c.Check(output.String(), Matches, `(?s).*Leaked resource with handle 0x[[:xdigit:]]{8}.*`)
+
+	c.Assert(suite.handle, Not(Equals), Handle(0))
+	s.checkFlushed(c, s.TPM, []Handle{suite.handle})
+}
@@ -74,6 +74,9 @@ type Property uint32
 // PropertyPCR corresponds to the TPM_PT_PCR type.
 type PropertyPCR uint32
 
+// ACAttribute corresponds to the TPM_AT type, and identifies an attribute of an attached component (AC).
+type ACAttribute uint32
+
 // 7) Handles
 
 // Handle corresponds to the TPM_HANDLE type, and is a numeric identifier that references a resource on the TPM.
@@ -111,6 +114,10 @@ type PermanentAttributes uint32
 // with TPMContext.GetCapabilityTPMProperties.
 type StartupClearAttributes uint32
 
+// ACTAttributes corresponds to the TPMA_ACT type and represents the attributes of an authenticated countdown timer (ACT). It is
+// returned as part of the ACTData structure when querying the state of ACTs with TPMContext.GetCapabilityACT.
+type ACTAttributes uint32
+
 // CommandAttributes corresponds to the TPMA_CC type and represents the attributes of a command. It also encodes the command code to
 // which these attributes belong, and the number of command handles for the command.
 type CommandAttributes uint32
@@ -480,6 +487,20 @@ type TaggedPolicy struct {
 	PolicyHash TaggedHash // Policy algorithm and hash
 }
 
+// ACOutput corresponds to the TPMS_AC_OUTPUT type. It is used to report the value of an attribute of an attached component (AC), and
+// is also returned from TPMContext.ACSend.
+type ACOutput struct {
+	Tag  ACAttribute // Attribute identifier
+	Data uint32      // Value of the attribute
+}
+
+// ACTData corresponds to the TPMS_ACT_DATA type. It is used to report the state of an authenticated countdown timer (ACT).
+type ACTData struct {
+	Handle  Handle        // Handle of the ACT
+	Timeout uint32        // Current timeout of the ACT, in seconds
+	Attrs   ACTAttributes // State of the ACT
+}
+
 // 10.9) Lists
 
 // CommandCodeList is a slice of CommandCode values, and corresponds to the TPML_CC type.
@@ -641,6 +662,12 @@ type ECCCurveList []ECCCurve
 // TaggedPolicyList is a slice of TaggedPolicy values, and corresponds to the TPML_TAGGED_POLICY type.
 type TaggedPolicyList []TaggedPolicy
 
+// ACCapabilityList is a slice of ACOutput values, and corresponds to the TPML_AC_CAPABILITIES type.
+type ACCapabilityList []ACOutput
+
+// ACTList is a slice of ACTData values, and corresponds to the TPML_ACT_DATA type.
+type ACTList []ACTData
+
 // 10.10) Capabilities Structures
 
 // Capabilities is a union type that corresponds to the TPMU_CAPABILITIES type. The selector type is Capability.
@@ -655,6 +682,7 @@ type TaggedPolicyList []TaggedPolicy
 //  - CapabilityPCRProperties: PCRProperties
 //  - CapabilityECCCurves: ECCCurves
 //  - CapabilityAuthPolicies: AuthPolicies
+//  - CapabilityACT: ACTData
 type CapabilitiesU struct {
 	Algorithms    AlgorithmPropertyList
 	Handles       HandleList
@@ -666,6 +694,7 @@ type CapabilitiesU struct {
 	PCRProperties TaggedPCRPropertyList
 	ECCCurves     ECCCurveList
 	AuthPolicies  TaggedPolicyList
+	ACTData       ACTList
 }
 
 func (c *CapabilitiesU) Select(selector reflect.Value) interface{} {
@@ -690,6 +719,8 @@ func (c *CapabilitiesU) Select(selector reflect.Value) interface{} {
 		return &c.ECCCurves
 	case CapabilityAuthPolicies:
 		return &c.AuthPolicies
+	case CapabilityACT:
+		return &c.ACTData
 	default:
 		return nil
 	}
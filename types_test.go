@@ -410,6 +410,38 @@ func TestTaggedHash(t *testing.T) {
 	})
 }
 
+func TestCapabilityDataACT(t *testing.T) {
+	in := CapabilityData{
+		Capability: CapabilityACT,
+		Data: &CapabilitiesU{
+			ACTData: ACTList{
+				{Handle: HandleACT0, Timeout: 60, Attrs: 0},
+				{Handle: HandleACT0 + 1, Timeout: 0, Attrs: AttrACTSignaled | AttrACTPreserveSignaled}}}}
+	expected := []byte{0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x02,
+		0x40, 0x00, 0x01, 0x10, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x00, 0x00, 0x00,
+		0x40, 0x00, 0x01, 0x11, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03}
+
+	out, err := mu.MarshalToBytes(&in)
+	if err != nil {
+		t.Fatalf("MarshalToBytes failed: %v", err)
+	}
+	if !bytes.Equal(out, expected) {
+		t.Errorf("MarshalToBytes returned an unexpected sequence of bytes: %x", out)
+	}
+
+	var a CapabilityData
+	n, err := mu.UnmarshalFromBytes(out, &a)
+	if err != nil {
+		t.Fatalf("UnmarshalFromBytes failed: %v", err)
+	}
+	if n != len(out) {
+		t.Errorf("UnmarshalFromBytes consumed the wrong number of bytes (%d)", n)
+	}
+	if !reflect.DeepEqual(in, a) {
+		t.Errorf("UnmarshalFromBytes didn't return the original data")
+	}
+}
+
 func TestPublicName(t *testing.T) {
 	tpm := openTPMForTesting(t, testutil.TPMFeatureOwnerHierarchy)
 	defer closeTPM(t, tpm)
@@ -9,12 +9,12 @@ import (
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/hmac"
-	"crypto/rand"
 	"crypto/rsa"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash"
+	"io"
 	"io/ioutil"
 	"sort"
 
@@ -26,8 +26,12 @@ import (
 
 // ComputeCpHash computes a command parameter digest from the specified command code and provided command parameters, using the
 // digest algorithm specified by hashAlg. The params argument corresponds to the handle and parameters area of a command (in that
-// order), separated by the Delimiter sentinel value. Handle arguments must be represented by either the Handle type or
-// HandleContext type.
+// order), separated by the Delimiter sentinel value. Handle arguments must be represented by either the Handle type,
+// HandleContext type or ResourceContextWithSession type.
+//
+// For vendor-specific commands registered with RegisterVendorCommand, the supplied arguments are checked against the
+// registered description. In this case, handles that require authorization must be supplied as ResourceContextWithSession
+// in the same way as they would be for TPMContext.RunCommand.
 //
 // The number of command handles and number / type of command parameters can be determined by looking in part 3 of the TPM 2.0
 // Library Specification for the specific command.
@@ -41,6 +45,8 @@ func ComputeCpHash(hashAlg HashAlgorithmId, command CommandCode, params ...inter
 
 	var handles []Name
 	var i int
+	numLeadingAuthHandles := 0 // Number of leading command handles that require authorization
+	numAuthHandles := 0
 
 	for _, param := range params {
 		if param == Delimiter {
@@ -52,8 +58,27 @@ func ComputeCpHash(hashAlg HashAlgorithmId, command CommandCode, params ...inter
 			handles = append(handles, makeDummyContext(p).Name())
 		case HandleContext:
 			handles = append(handles, p.Name())
+		case ResourceContextWithSession:
+			if p.Context == nil {
+				return nil, makeInvalidArgError("params", "nil ResourceContext in handle area")
+			}
+			if numLeadingAuthHandles == len(handles) {
+				numLeadingAuthHandles++
+			}
+			numAuthHandles++
+			handles = append(handles, p.Context.Name())
 		default:
-			return nil, makeInvalidArgError("params", "parameter in handle area is not a Handle or HandleContext")
+			return nil, makeInvalidArgError("params", "parameter in handle area is not a Handle, HandleContext or ResourceContextWithSession")
+		}
+	}
+
+	if cmd := lookupVendorCommand(command); cmd != nil {
+		var cpParams []interface{}
+		if i < len(params)-1 {
+			cpParams = params[i+1:]
+		}
+		if err := cmd.checkCommand(len(handles), numLeadingAuthHandles, numAuthHandles, cpParams); err != nil {
+			return nil, makeInvalidArgError("params", err.Error())
 		}
 	}
 
@@ -261,6 +286,17 @@ func (p *TrialAuthPolicy) PolicyDuplicationSelect(objectName, newParentName Name
 	end()
 }
 
+func (p *TrialAuthPolicy) PolicyACSendSelect(objectName, authHandleName, acName Name, includeObject bool) {
+	h, end := p.beginUpdateForCommand(CommandPolicyACSendSelect)
+	if includeObject {
+		h.Write(objectName)
+	}
+	h.Write(authHandleName)
+	h.Write(acName)
+	binary.Write(h, binary.BigEndian, includeObject)
+	end()
+}
+
 func (p *TrialAuthPolicy) PolicyAuthorize(policyRef Nonce, keySign Name) {
 	p.update(CommandPolicyAuthorize, keySign, policyRef)
 }
@@ -495,7 +531,7 @@ func CreateDuplicationObjectFromSensitive(sensitive *Sensitive, public, parentPu
 
 		if len(encryptionKeyIn) == 0 {
 			encryptionKeyIn = make([]byte, symmetricAlg.KeyBits.Sym/8)
-			if _, err := rand.Read(encryptionKeyIn); err != nil {
+			if _, err := io.ReadFull(randomSource{}, encryptionKeyIn); err != nil {
 				return nil, nil, nil, xerrors.Errorf("cannot read random bytes for key for inner wrapper: %w", err)
 			}
 			encryptionKeyOut = encryptionKeyIn
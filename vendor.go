@@ -0,0 +1,167 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// VendorCommand describes a vendor-specific command. Vendor-specific commands have a command code with the V bit set (see
+// AttrV), and are not defined by the TPM Library Specification. In order for TPMContext.RunCommand, ComputeCpHash and
+// DecodeResponseCode to handle these commands correctly, they must be registered with RegisterVendorCommand.
+type VendorCommand struct {
+	Vendor string // Name of the vendor that defines the command
+	Name   string // Name of the command, used when formatting the command code
+
+	NumHandles     int // Number of handles in the command handle area
+	NumAuthHandles int // Number of handles in the command handle area that require authorization. These must be first
+
+	// CommandParams contains the types of the command parameters, in order. If this is nil, the command parameters
+	// are not checked.
+	CommandParams []reflect.Type
+
+	NumResponseHandles int // Number of handles in the response handle area
+
+	// ResponseParams contains the types of the response parameters, in order. If this is nil, the response parameters
+	// are not checked. Note that response parameters are supplied to TPMContext.RunCommand as pointers to these types.
+	ResponseParams []reflect.Type
+}
+
+func (c *VendorCommand) checkParams(kind string, params []interface{}, types []reflect.Type, pointers bool) error {
+	if types == nil {
+		return nil
+	}
+	if len(params) != len(types) {
+		return fmt.Errorf("unexpected number of %s (got %d, expected %d)", kind, len(params), len(types))
+	}
+	for i, p := range params {
+		t := reflect.TypeOf(p)
+		if pointers {
+			if t == nil || t.Kind() != reflect.Ptr {
+				return fmt.Errorf("%s at index %d is not a pointer", kind, i)
+			}
+			t = t.Elem()
+		}
+		if t == nil || !t.AssignableTo(types[i]) {
+			return fmt.Errorf("%s at index %d has the wrong type (got %v, expected %v)", kind, i, t, types[i])
+		}
+	}
+	return nil
+}
+
+func (c *VendorCommand) checkCommand(numHandles, numLeadingAuthHandles, numAuthHandles int, params []interface{}) error {
+	if numHandles != c.NumHandles {
+		return fmt.Errorf("unexpected number of command handles (got %d, expected %d)", numHandles, c.NumHandles)
+	}
+	if numLeadingAuthHandles != numAuthHandles {
+		return errors.New("command handles that require authorization must precede those that don't")
+	}
+	if numAuthHandles != c.NumAuthHandles {
+		return fmt.Errorf("unexpected number of command handles with authorization (got %d, expected %d)", numAuthHandles, c.NumAuthHandles)
+	}
+	return c.checkParams("command parameters", params, c.CommandParams, false)
+}
+
+func (c *VendorCommand) checkResponse(numHandles int, params []interface{}) error {
+	if numHandles != c.NumResponseHandles {
+		return fmt.Errorf("unexpected number of response handles (got %d, expected %d)", numHandles, c.NumResponseHandles)
+	}
+	return c.checkParams("response parameters", params, c.ResponseParams, true)
+}
+
+var (
+	vendorCommandsMu sync.RWMutex
+	vendorCommands   = make(map[CommandCode]*VendorCommand)
+)
+
+func isVendorCommand(code CommandCode) bool {
+	return CommandAttributes(code)&AttrV != 0
+}
+
+func lookupVendorCommand(code CommandCode) *VendorCommand {
+	if !isVendorCommand(code) {
+		return nil
+	}
+	vendorCommandsMu.RLock()
+	defer vendorCommandsMu.RUnlock()
+	return vendorCommands[code]
+}
+
+// RegisterVendorCommand registers the vendor-specific command described by cmd with the command code specified by code. The
+// command code must have the V bit set (see AttrV). An error will be returned if a command is already registered with the
+// specified command code.
+//
+// Once registered, the command can be executed with TPMContext.RunCommand, which will check the supplied arguments against
+// the description in cmd. The name of the command will be used when formatting the command code, and errors returned from
+// DecodeResponseCode for vendor defined response codes will be associated with the vendor that defines the command.
+func RegisterVendorCommand(code CommandCode, cmd VendorCommand) error {
+	if !isVendorCommand(code) {
+		return makeInvalidArgError("code", "not a vendor-specific command code")
+	}
+	if cmd.NumHandles < 0 || cmd.NumAuthHandles < 0 || cmd.NumResponseHandles < 0 {
+		return makeInvalidArgError("cmd", "negative number of handles")
+	}
+	if cmd.NumAuthHandles > cmd.NumHandles {
+		return makeInvalidArgError("cmd", "more authorization handles than handles")
+	}
+	if cmd.NumAuthHandles > 3 {
+		return makeInvalidArgError("cmd", "too many authorization handles")
+	}
+	for _, t := range cmd.CommandParams {
+		if t == nil {
+			return makeInvalidArgError("cmd", "nil command parameter type")
+		}
+	}
+	for _, t := range cmd.ResponseParams {
+		if t == nil {
+			return makeInvalidArgError("cmd", "nil response parameter type")
+		}
+	}
+
+	vendorCommandsMu.Lock()
+	defer vendorCommandsMu.Unlock()
+
+	if _, exists := vendorCommands[code]; exists {
+		return fmt.Errorf("a vendor-specific command is already registered with command code 0x%08x", uint32(code))
+	}
+	c := cmd
+	vendorCommands[code] = &c
+	return nil
+}
+
+// UnregisterVendorCommand removes the registration for the vendor-specific command with the specified command code.
+func UnregisterVendorCommand(code CommandCode) error {
+	vendorCommandsMu.Lock()
+	defer vendorCommandsMu.Unlock()
+
+	if _, exists := vendorCommands[code]; !exists {
+		return errors.New("no vendor-specific command is registered with the specified command code")
+	}
+	delete(vendorCommands, code)
+	return nil
+}
+
+// LookupVendorCommand returns the description of the vendor-specific command registered with the specified command code.
+// If no command is registered, this returns false.
+func LookupVendorCommand(code CommandCode) (VendorCommand, bool) {
+	cmd := lookupVendorCommand(code)
+	if cmd == nil {
+		return VendorCommand{}, false
+	}
+	return *cmd, true
+}
+
+func init() {
+	if err := RegisterVendorCommand(CommandVendorTCGTest, VendorCommand{
+		Vendor:         "TCG",
+		Name:           "TPM_CC_Vendor_TCG_Test",
+		CommandParams:  []reflect.Type{reflect.TypeOf(Data(nil))},
+		ResponseParams: []reflect.Type{reflect.TypeOf(Data(nil))}}); err != nil {
+		panic(err)
+	}
+}
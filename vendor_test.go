@@ -0,0 +1,199 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"crypto"
+	_ "crypto/sha256"
+	"fmt"
+	"reflect"
+
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+const testVendorCommand CommandCode = 0x20000123
+
+type vendorSuite struct {
+	testutil.BaseTest
+	tcti *scriptedTcti
+	tpm  *TPMContext
+}
+
+var _ = Suite(&vendorSuite{})
+
+func (s *vendorSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	s.tcti = &scriptedTcti{}
+	s.tpm, _ = NewTPMContext(s.tcti)
+
+	c.Assert(RegisterVendorCommand(testVendorCommand, VendorCommand{
+		Vendor:         "Acme",
+		Name:           "ACME_CC_Diagnostics",
+		NumHandles:     2,
+		NumAuthHandles: 1,
+		CommandParams:  []reflect.Type{reflect.TypeOf(uint32(0)), reflect.TypeOf(MaxBuffer(nil))},
+		ResponseParams: []reflect.Type{reflect.TypeOf(MaxBuffer(nil))}}), IsNil)
+	s.AddCleanup(func() {
+		c.Check(UnregisterVendorCommand(testVendorCommand), IsNil)
+	})
+}
+
+func (s *vendorSuite) TestRegisterNotVendorCommand(c *C) {
+	c.Check(RegisterVendorCommand(0x123, VendorCommand{}), ErrorMatches, "invalid code argument: not a vendor-specific command code")
+}
+
+func (s *vendorSuite) TestRegisterDuplicate(c *C) {
+	c.Check(RegisterVendorCommand(testVendorCommand, VendorCommand{}), ErrorMatches,
+		"a vendor-specific command is already registered with command code 0x20000123")
+}
+
+func (s *vendorSuite) TestRegisterTooManyAuthHandles(c *C) {
+	c.Check(RegisterVendorCommand(0x20000124, VendorCommand{NumHandles: 1, NumAuthHandles: 2}), ErrorMatches,
+		"invalid cmd argument: more authorization handles than handles")
+}
+
+func (s *vendorSuite) TestLookup(c *C) {
+	cmd, ok := LookupVendorCommand(testVendorCommand)
+	c.Check(ok, testutil.IsTrue)
+	c.Check(cmd.Vendor, Equals, "Acme")
+	c.Check(cmd.NumHandles, Equals, 2)
+
+	_, ok = LookupVendorCommand(0x20000124)
+	c.Check(ok, testutil.IsFalse)
+}
+
+func (s *vendorSuite) TestCommandCodeString(c *C) {
+	c.Check(testVendorCommand.String(), Equals, "ACME_CC_Diagnostics")
+	c.Check(CommandVendorTCGTest.String(), Equals, "TPM_CC_Vendor_TCG_Test")
+	c.Check(fmt.Sprintf("%v", CommandCode(0x20000124)), Equals, "0x20000124")
+}
+
+func (s *vendorSuite) TestDecodeResponseCode(c *C) {
+	err := DecodeResponseCode(testVendorCommand, 0x57e)
+	c.Assert(err, FitsTypeOf, &TPMVendorError{})
+	c.Check(err.(*TPMVendorError).Vendor, Equals, "Acme")
+	c.Check(err, ErrorMatches, "TPM returned a vendor defined error \\(Acme\\) whilst executing command ACME_CC_Diagnostics: 0x0000057e")
+
+	err = DecodeResponseCode(CommandLoad, 0x57e)
+	c.Assert(err, FitsTypeOf, &TPMVendorError{})
+	c.Check(err.(*TPMVendorError).Vendor, Equals, "")
+}
+
+func (s *vendorSuite) TestRunCommand(c *C) {
+	s.tcti.script = []scriptedTctiExchange{
+		{
+			command: makeScriptedPacket(c, TagSessions, uint32(testVendorCommand),
+				HandleOwner, HandleNull, uint32(9),
+				HandlePW, uint16(0), uint8(1), uint16(0),
+				uint32(5), MaxBuffer("foo")),
+			response: makeScriptedPacket(c, TagSessions, uint32(Success), uint32(5),
+				MaxBuffer("bar"),
+				uint16(0), uint8(1), uint16(0))}}
+
+	var out MaxBuffer
+	c.Check(s.tpm.RunCommand(testVendorCommand, nil,
+		ResourceContextWithSession{Context: s.tpm.OwnerHandleContext()}, nil, Delimiter,
+		uint32(5), MaxBuffer("foo"), Delimiter,
+		Delimiter,
+		&out), IsNil)
+	c.Check(out, DeepEquals, MaxBuffer("bar"))
+	c.Check(s.tcti.script, HasLen, 0)
+}
+
+func (s *vendorSuite) TestRunCommandWrongHandles(c *C) {
+	var out MaxBuffer
+	c.Check(s.tpm.RunCommand(testVendorCommand, nil,
+		ResourceContextWithSession{Context: s.tpm.OwnerHandleContext()}, Delimiter,
+		uint32(5), MaxBuffer("foo"), Delimiter,
+		Delimiter,
+		&out), ErrorMatches, "invalid arguments for vendor-specific command ACME_CC_Diagnostics: "+
+		"unexpected number of command handles \\(got 1, expected 2\\)")
+}
+
+func (s *vendorSuite) TestRunCommandAuthHandleOrder(c *C) {
+	var out MaxBuffer
+	c.Check(s.tpm.RunCommand(testVendorCommand, nil,
+		nil, ResourceContextWithSession{Context: s.tpm.OwnerHandleContext()}, Delimiter,
+		uint32(5), MaxBuffer("foo"), Delimiter,
+		Delimiter,
+		&out), ErrorMatches, "invalid arguments for vendor-specific command ACME_CC_Diagnostics: "+
+		"command handles that require authorization must precede those that don't")
+}
+
+func (s *vendorSuite) TestRunCommandWrongParamType(c *C) {
+	var out MaxBuffer
+	c.Check(s.tpm.RunCommand(testVendorCommand, nil,
+		ResourceContextWithSession{Context: s.tpm.OwnerHandleContext()}, nil, Delimiter,
+		uint16(5), MaxBuffer("foo"), Delimiter,
+		Delimiter,
+		&out), ErrorMatches, "invalid arguments for vendor-specific command ACME_CC_Diagnostics: "+
+		"command parameters at index 0 has the wrong type \\(got uint16, expected uint32\\)")
+}
+
+func (s *vendorSuite) TestRunCommandWrongResponseParamType(c *C) {
+	var out Data
+	c.Check(s.tpm.RunCommand(testVendorCommand, nil,
+		ResourceContextWithSession{Context: s.tpm.OwnerHandleContext()}, nil, Delimiter,
+		uint32(5), MaxBuffer("foo"), Delimiter,
+		Delimiter,
+		&out), ErrorMatches, "invalid arguments for vendor-specific command ACME_CC_Diagnostics: "+
+		"response parameters at index 0 has the wrong type \\(got tpm2.Data, expected tpm2.MaxBuffer\\)")
+}
+
+func (s *vendorSuite) TestComputeCpHash(c *C) {
+	owner := ResourceContextWithSession{Context: s.tpm.OwnerHandleContext()}
+
+	cpHash, err := ComputeCpHash(HashAlgorithmSHA256, testVendorCommand, owner, HandleNull, Delimiter, uint32(5), MaxBuffer("foo"))
+	c.Check(err, IsNil)
+	h := crypto.SHA256.New()
+	_, err = mu.MarshalToWriter(h, testVendorCommand, mu.RawBytes(s.tpm.OwnerHandleContext().Name()), HandleNull, uint32(5), MaxBuffer("foo"))
+	c.Check(err, IsNil)
+	c.Check(cpHash, DeepEquals, Digest(h.Sum(nil)))
+
+	_, err = ComputeCpHash(HashAlgorithmSHA256, testVendorCommand, owner, HandleNull, Delimiter, uint32(5))
+	c.Check(err, ErrorMatches, "invalid params argument: unexpected number of command parameters \\(got 1, expected 2\\)")
+}
+
+func (s *vendorSuite) TestComputeCpHashAuthHandles(c *C) {
+	owner := ResourceContextWithSession{Context: s.tpm.OwnerHandleContext()}
+
+	for _, data := range []struct {
+		handles []interface{}
+		err     string
+	}{
+		{
+			handles: []interface{}{HandleOwner, HandleNull},
+			err:     "unexpected number of command handles with authorization \\(got 0, expected 1\\)",
+		},
+		{
+			handles: []interface{}{owner, owner},
+			err:     "unexpected number of command handles with authorization \\(got 2, expected 1\\)",
+		},
+		{
+			handles: []interface{}{HandleNull, owner},
+			err:     "command handles that require authorization must precede those that don't",
+		},
+	} {
+		params := append(data.handles, Delimiter, uint32(5), MaxBuffer("foo"))
+		_, err := ComputeCpHash(HashAlgorithmSHA256, testVendorCommand, params...)
+		c.Check(err, ErrorMatches, "invalid params argument: "+data.err)
+	}
+}
+
+func (s *vendorSuite) TestVendorTCGTest(c *C) {
+	s.tcti.script = []scriptedTctiExchange{
+		{
+			command:  makeScriptedPacket(c, TagNoSessions, uint32(CommandVendorTCGTest), Data("foo")),
+			response: makeScriptedPacket(c, TagNoSessions, uint32(Success), Data("foo"))}}
+
+	out, err := s.tpm.VendorTCGTest(Data("foo"))
+	c.Check(err, IsNil)
+	c.Check(out, DeepEquals, Data("foo"))
+	c.Check(s.tcti.script, HasLen, 0)
+}
@@ -0,0 +1,242 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/xerrors"
+)
+
+func decodeASN1Sequence(data []byte) ([]asn1.RawValue, error) {
+	var seq asn1.RawValue
+	rest, err := asn1.Unmarshal(data, &seq)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing bytes")
+	}
+	if seq.Class != asn1.ClassUniversal || seq.Tag != asn1.TagSequence || !seq.IsCompound {
+		return nil, errors.New("not a SEQUENCE")
+	}
+
+	var elems []asn1.RawValue
+	for b := seq.Bytes; len(b) > 0; {
+		var elem asn1.RawValue
+		b, err = asn1.Unmarshal(b, &elem)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
+
+func encodeASN1Sequence(elems ...[]byte) []byte {
+	b, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: bytes.Join(elems, nil)})
+	if err != nil {
+		panic(fmt.Sprintf("cannot marshal SEQUENCE: %v", err))
+	}
+	return b
+}
+
+// checkCertificateField returns an error if the supplied field from the partial certificate or addedToCertificate
+// doesn't have the expected class and tag.
+func checkCertificateField(source, name string, field asn1.RawValue, class, tag int) error {
+	if field.Class != class || field.Tag != tag {
+		return fmt.Errorf("invalid %s: unexpected encoding for %s field (class: %d, tag: %d)", source, name, field.Class, field.Tag)
+	}
+	return nil
+}
+
+// CreatePartialCertificate creates a DER encoded partial certificate from the supplied template, suitable for passing to
+// TPMContext.CertifyX509. The partial certificate contains the issuer, validity, subject and extensions fields. The issuer field
+// is obtained from the subject of parent, and the authority key identifier extension is obtained from the subject key identifier of
+// parent. If parent is nil, then the issuer field is obtained from the subject of template.
+//
+// The public argument corresponds to the public area of the object that the certificate will be created for. This is used to
+// compute a subject key identifier if template corresponds to a CA and doesn't already specify one.
+//
+// The extensions are created from the template in the same way as x509.CreateCertificate. The SerialNumber, SignatureAlgorithm and
+// PublicKey fields of the template are ignored, as these are added by the TPM.
+func CreatePartialCertificate(template, parent *x509.Certificate, public *Public) (MaxBuffer, error) {
+	switch public.Type {
+	case ObjectTypeRSA, ObjectTypeECC:
+	default:
+		return nil, errors.New("public area does not correspond to an asymmetric key")
+	}
+
+	tmpl := *template
+	tmpl.SerialNumber = big.NewInt(1)
+	tmpl.SignatureAlgorithm = x509.UnknownSignatureAlgorithm
+
+	if parent == nil {
+		parent = template
+	}
+	issuer := &x509.Certificate{
+		Subject:      parent.Subject,
+		RawSubject:   parent.RawSubject,
+		SubjectKeyId: parent.SubjectKeyId}
+
+	// Use x509.CreateCertificate to do the hard work of encoding the template, signed with an ephemeral key. The fields that
+	// make up the partial certificate are then extracted from the TBSCertificate.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot create ephemeral key: %w", err)
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &tmpl, issuer, public.Public(), key)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot encode template: %w", err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot parse encoded template: %w", err)
+	}
+
+	// TBSCertificate ::= SEQUENCE {
+	//     version [0] EXPLICIT Version DEFAULT v1,
+	//     serialNumber CertificateSerialNumber,
+	//     signature AlgorithmIdentifier,
+	//     issuer Name,
+	//     validity Validity,
+	//     subject Name,
+	//     subjectPublicKeyInfo SubjectPublicKeyInfo,
+	//     issuerUniqueID [1] IMPLICIT UniqueIdentifier OPTIONAL,
+	//     subjectUniqueID [2] IMPLICIT UniqueIdentifier OPTIONAL,
+	//     extensions [3] EXPLICIT Extensions OPTIONAL }
+	tbs, err := decodeASN1Sequence(cert.RawTBSCertificate)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot decode TBSCertificate: %w", err)
+	}
+	if len(tbs) < 7 {
+		return nil, errors.New("cannot decode TBSCertificate: too few fields")
+	}
+
+	fields := [][]byte{tbs[3].FullBytes, tbs[4].FullBytes, tbs[5].FullBytes}
+	for _, f := range tbs[7:] {
+		if f.Class == asn1.ClassContextSpecific && f.Tag == 3 {
+			fields = append(fields, f.FullBytes)
+		}
+	}
+
+	return encodeASN1Sequence(fields...), nil
+}
+
+// CreateCertificateFromCertifyX509 assembles a complete X.509 certificate from the partialCertificate supplied to
+// TPMContext.CertifyX509 and the addedToCertificate, tbsDigest and signature values that it returned. The digest of the assembled
+// TBSCertificate is checked against tbsDigest, and an error is returned if it doesn't match.
+//
+// RSASSA, RSAPSS and ECDSA signatures are supported.
+func CreateCertificateFromCertifyX509(partialCertificate, addedToCertificate MaxBuffer, tbsDigest Digest, signature *Signature) (*x509.Certificate, error) {
+	partial, err := decodeASN1Sequence(partialCertificate)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot decode partial certificate: %w", err)
+	}
+	added, err := decodeASN1Sequence(addedToCertificate)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot decode addedToCertificate: %w", err)
+	}
+
+	// The partial certificate contains issuer, validity, subject and extensions, optionally preceded by the signature algorithm.
+	// The TPM adds version, serialNumber and subjectPublicKeyInfo, as well as the signature algorithm if the partial certificate
+	// doesn't contain it.
+	var sigAlg asn1.RawValue
+	var sigAlgSource string
+	switch {
+	case len(partial) == 5 && len(added) == 3:
+		sigAlg, sigAlgSource = partial[0], "partial certificate"
+		partial = partial[1:]
+	case len(partial) == 4 && len(added) == 4:
+		sigAlg, sigAlgSource = added[2], "addedToCertificate"
+		added = []asn1.RawValue{added[0], added[1], added[3]}
+	default:
+		return nil, fmt.Errorf("unsupported certificate layout: partial certificate has %d fields and addedToCertificate has %d "+
+			"fields (expected 4 and 4 if the TPM adds the signature algorithm, or 5 and 3 if it is in the partial certificate)",
+			len(partial), len(added))
+	}
+
+	for _, f := range []struct {
+		source string
+		name   string
+		field  asn1.RawValue
+		class  int
+		tag    int
+	}{
+		{source: "addedToCertificate", name: "version", field: added[0], class: asn1.ClassContextSpecific, tag: 0},
+		{source: "addedToCertificate", name: "serialNumber", field: added[1], class: asn1.ClassUniversal, tag: asn1.TagInteger},
+		{source: sigAlgSource, name: "signature", field: sigAlg, class: asn1.ClassUniversal, tag: asn1.TagSequence},
+		{source: "addedToCertificate", name: "subjectPublicKeyInfo", field: added[2], class: asn1.ClassUniversal, tag: asn1.TagSequence},
+		{source: "partial certificate", name: "issuer", field: partial[0], class: asn1.ClassUniversal, tag: asn1.TagSequence},
+		{source: "partial certificate", name: "validity", field: partial[1], class: asn1.ClassUniversal, tag: asn1.TagSequence},
+		{source: "partial certificate", name: "subject", field: partial[2], class: asn1.ClassUniversal, tag: asn1.TagSequence},
+		{source: "partial certificate", name: "extensions", field: partial[3], class: asn1.ClassContextSpecific, tag: 3},
+	} {
+		if err := checkCertificateField(f.source, f.name, f.field, f.class, f.tag); err != nil {
+			return nil, err
+		}
+	}
+
+	tbs := encodeASN1Sequence(
+		added[0].FullBytes,   // version
+		added[1].FullBytes,   // serialNumber
+		sigAlg.FullBytes,     // signature
+		partial[0].FullBytes, // issuer
+		partial[1].FullBytes, // validity
+		partial[2].FullBytes, // subject
+		added[2].FullBytes,   // subjectPublicKeyInfo
+		partial[3].FullBytes) // extensions
+
+	if signature == nil || signature.Signature == nil || signature.Signature.Any() == nil {
+		return nil, errors.New("invalid signature")
+	}
+	hashAlg := signature.Signature.Any().HashAlg
+	if !hashAlg.Available() {
+		return nil, fmt.Errorf("digest algorithm %v is not available", hashAlg)
+	}
+	h := hashAlg.NewHash()
+	h.Write(tbs)
+	if !bytes.Equal(h.Sum(nil), tbsDigest) {
+		return nil, errors.New("digest of assembled TBSCertificate does not match tbsDigest")
+	}
+
+	var sig []byte
+	switch signature.SigAlg {
+	case SigSchemeAlgRSASSA:
+		sig = signature.Signature.RSASSA.Sig
+	case SigSchemeAlgRSAPSS:
+		sig = signature.Signature.RSAPSS.Sig
+	case SigSchemeAlgECDSA:
+		sig, err = asn1.Marshal(struct {
+			R, S *big.Int
+		}{
+			R: new(big.Int).SetBytes(signature.Signature.ECDSA.SignatureR),
+			S: new(big.Int).SetBytes(signature.Signature.ECDSA.SignatureS)})
+		if err != nil {
+			return nil, xerrors.Errorf("cannot encode ECDSA signature: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm %v", signature.SigAlg)
+	}
+
+	sigValue, err := asn1.Marshal(asn1.BitString{Bytes: sig, BitLength: len(sig) * 8})
+	if err != nil {
+		return nil, xerrors.Errorf("cannot encode signature: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(encodeASN1Sequence(tbs, sigAlg.FullBytes, sigValue))
+	if err != nil {
+		return nil, xerrors.Errorf("cannot parse assembled certificate: %w", err)
+	}
+	return cert, nil
+}
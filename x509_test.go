@@ -0,0 +1,359 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+	"github.com/canonical/go-tpm2/testutil"
+)
+
+var oidSHA256WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+
+type x509Suite struct {
+	testutil.BaseTest
+	caKey  *rsa.PrivateKey
+	caCert *x509.Certificate
+	public *Public
+}
+
+var _ = Suite(&x509Suite{})
+
+func (s *x509Suite) SetUpSuite(c *C) {
+	var err error
+	s.caKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+
+	caTemplate := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          []byte{1, 2, 3, 4}}
+	caDER, err := x509.CreateCertificate(rand.Reader, &caTemplate, &caTemplate, &s.caKey.PublicKey, s.caKey)
+	c.Assert(err, IsNil)
+	s.caCert, err = x509.ParseCertificate(caDER)
+	c.Assert(err, IsNil)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	s.public = &Public{
+		Type:    ObjectTypeECC,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrSign,
+		Params: &PublicParamsU{
+			ECCDetail: &ECCParams{
+				Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+				Scheme:    ECCScheme{Scheme: ECCSchemeNull},
+				CurveID:   ECCCurveNIST_P256,
+				KDF:       KDFScheme{Scheme: KDFAlgorithmNull}}},
+		Unique: &PublicIDU{ECC: &ECCPoint{X: key.X.Bytes(), Y: key.Y.Bytes()}}}
+}
+
+func (s *x509Suite) template() *x509.Certificate {
+	return &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "TPM key"},
+		NotBefore: time.Now().Add(-time.Minute).Truncate(time.Second).UTC(),
+		NotAfter:  time.Now().Add(time.Hour).Truncate(time.Second).UTC(),
+		KeyUsage:  x509.KeyUsageDigitalSignature,
+		DNSNames:  []string{"tpm.example.com"}}
+}
+
+// certifyX509 emulates the behaviour of TPM2_CertifyX509, returning addedToCertificate, tbsDigest and signature.
+func (s *x509Suite) certifyX509(c *C, partialCertificate MaxBuffer, addSigAlg bool) (MaxBuffer, Digest, *Signature) {
+	var partial []asn1.RawValue
+	rest, err := asn1.Unmarshal(partialCertificate, &partial)
+	c.Assert(err, IsNil)
+	c.Assert(rest, HasLen, 0)
+
+	version, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: []byte{0x02, 0x01, 0x02}})
+	c.Assert(err, IsNil)
+	serial, err := asn1.Marshal(big.NewInt(12345))
+	c.Assert(err, IsNil)
+	sigAlg, err := asn1.Marshal(pkix.AlgorithmIdentifier{Algorithm: oidSHA256WithRSA, Parameters: asn1.NullRawValue})
+	c.Assert(err, IsNil)
+	spki, err := x509.MarshalPKIXPublicKey(s.public.Public())
+	c.Assert(err, IsNil)
+
+	added := []asn1.RawValue{{FullBytes: version}, {FullBytes: serial}}
+	if addSigAlg {
+		added = append(added, asn1.RawValue{FullBytes: sigAlg})
+	}
+	added = append(added, asn1.RawValue{FullBytes: spki})
+	addedToCertificate, err := asn1.Marshal(added)
+	c.Assert(err, IsNil)
+
+	if !addSigAlg {
+		c.Assert(partial[0].FullBytes, DeepEquals, sigAlg)
+		partial = partial[1:]
+	}
+	tbs, err := asn1.Marshal([]asn1.RawValue{
+		{FullBytes: version}, {FullBytes: serial}, {FullBytes: sigAlg},
+		partial[0], partial[1], partial[2], {FullBytes: spki}, partial[3]})
+	c.Assert(err, IsNil)
+
+	tbsDigest := sha256.Sum256(tbs)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.caKey, crypto.SHA256, tbsDigest[:])
+	c.Assert(err, IsNil)
+
+	return addedToCertificate, tbsDigest[:], &Signature{
+		SigAlg: SigSchemeAlgRSASSA,
+		Signature: &SignatureU{
+			RSASSA: &SignatureRSASSA{Hash: HashAlgorithmSHA256, Sig: sig}}}
+}
+
+func (s *x509Suite) checkCertificate(c *C, cert *x509.Certificate, template *x509.Certificate) {
+	c.Check(cert.CheckSignatureFrom(s.caCert), IsNil)
+	c.Check(cert.SerialNumber, DeepEquals, big.NewInt(12345))
+	c.Check(cert.Subject.CommonName, Equals, template.Subject.CommonName)
+	c.Check(cert.Issuer.CommonName, Equals, s.caCert.Subject.CommonName)
+	c.Check(cert.NotBefore.Equal(template.NotBefore), testutil.IsTrue)
+	c.Check(cert.NotAfter.Equal(template.NotAfter), testutil.IsTrue)
+	c.Check(cert.KeyUsage, Equals, template.KeyUsage)
+	c.Check(cert.DNSNames, DeepEquals, template.DNSNames)
+	c.Check(cert.AuthorityKeyId, DeepEquals, s.caCert.SubjectKeyId)
+	c.Check(cert.PublicKey, DeepEquals, s.public.Public())
+}
+
+func (s *x509Suite) TestCreatePartialCertificate(c *C) {
+	template := s.template()
+	partialCertificate, err := CreatePartialCertificate(template, s.caCert, s.public)
+	c.Assert(err, IsNil)
+
+	var partial struct {
+		Issuer     asn1.RawValue
+		Validity   asn1.RawValue
+		Subject    asn1.RawValue
+		Extensions asn1.RawValue `asn1:"explicit,tag:3"`
+	}
+	rest, err := asn1.Unmarshal(partialCertificate, &partial)
+	c.Check(err, IsNil)
+	c.Check(rest, HasLen, 0)
+	c.Check(partial.Issuer.FullBytes, DeepEquals, s.caCert.RawSubject)
+}
+
+func (s *x509Suite) TestCreatePartialCertificateNotAsymmetric(c *C) {
+	_, err := CreatePartialCertificate(s.template(), s.caCert, &Public{Type: ObjectTypeKeyedHash})
+	c.Check(err, ErrorMatches, "public area does not correspond to an asymmetric key")
+}
+
+func (s *x509Suite) TestCreateCertificateFromCertifyX509(c *C) {
+	template := s.template()
+	partialCertificate, err := CreatePartialCertificate(template, s.caCert, s.public)
+	c.Assert(err, IsNil)
+
+	addedToCertificate, tbsDigest, signature := s.certifyX509(c, partialCertificate, true)
+
+	cert, err := CreateCertificateFromCertifyX509(partialCertificate, addedToCertificate, tbsDigest, signature)
+	c.Assert(err, IsNil)
+	s.checkCertificate(c, cert, template)
+}
+
+func (s *x509Suite) TestCreateCertificateFromCertifyX509WithSigAlgInPartial(c *C) {
+	template := s.template()
+	partialCertificate, err := CreatePartialCertificate(template, s.caCert, s.public)
+	c.Assert(err, IsNil)
+
+	var partial []asn1.RawValue
+	_, err = asn1.Unmarshal(partialCertificate, &partial)
+	c.Assert(err, IsNil)
+	sigAlg, err := asn1.Marshal(pkix.AlgorithmIdentifier{Algorithm: oidSHA256WithRSA, Parameters: asn1.NullRawValue})
+	c.Assert(err, IsNil)
+	partialCertificate, err = asn1.Marshal(append([]asn1.RawValue{{FullBytes: sigAlg}}, partial...))
+	c.Assert(err, IsNil)
+
+	addedToCertificate, tbsDigest, signature := s.certifyX509(c, partialCertificate, false)
+
+	cert, err := CreateCertificateFromCertifyX509(partialCertificate, addedToCertificate, tbsDigest, signature)
+	c.Assert(err, IsNil)
+	s.checkCertificate(c, cert, template)
+}
+
+func (s *x509Suite) TestCreateCertificateFromCertifyX509InvalidDigest(c *C) {
+	partialCertificate, err := CreatePartialCertificate(s.template(), s.caCert, s.public)
+	c.Assert(err, IsNil)
+
+	addedToCertificate, _, signature := s.certifyX509(c, partialCertificate, true)
+
+	_, err = CreateCertificateFromCertifyX509(partialCertificate, addedToCertificate, make(Digest, 32), signature)
+	c.Check(err, ErrorMatches, "digest of assembled TBSCertificate does not match tbsDigest")
+}
+
+func (s *x509Suite) TestCreateCertificateFromCertifyX509MalformedAddedToCertificate(c *C) {
+	partialCertificate, err := CreatePartialCertificate(s.template(), s.caCert, s.public)
+	c.Assert(err, IsNil)
+
+	addedToCertificate, tbsDigest, signature := s.certifyX509(c, partialCertificate, true)
+	var added []asn1.RawValue
+	_, err = asn1.Unmarshal(addedToCertificate, &added)
+	c.Assert(err, IsNil)
+
+	marshal := func(fields ...asn1.RawValue) MaxBuffer {
+		b, err := asn1.Marshal(fields)
+		c.Assert(err, IsNil)
+		return b
+	}
+	integer, err := asn1.Marshal(5)
+	c.Assert(err, IsNil)
+
+	for _, data := range []struct {
+		desc  string
+		added MaxBuffer
+		err   string
+	}{
+		{
+			desc:  "not a sequence",
+			added: integer,
+			err:   "cannot decode addedToCertificate: not a SEQUENCE",
+		},
+		{
+			desc:  "trailing bytes",
+			added: append(addedToCertificate, 0x00),
+			err:   "cannot decode addedToCertificate: trailing bytes",
+		},
+		{
+			desc:  "truncated",
+			added: addedToCertificate[:len(addedToCertificate)-1],
+			err:   "cannot decode addedToCertificate: .*",
+		},
+		{
+			desc:  "too few fields",
+			added: marshal(added[:3]...),
+			err: "unsupported certificate layout: partial certificate has 4 fields and addedToCertificate has 3 fields \\(expected 4 " +
+				"and 4 if the TPM adds the signature algorithm, or 5 and 3 if it is in the partial certificate\\)",
+		},
+		{
+			desc:  "too many fields",
+			added: marshal(append(added, added[3])...),
+			err: "unsupported certificate layout: partial certificate has 4 fields and addedToCertificate has 5 fields \\(expected 4 " +
+				"and 4 if the TPM adds the signature algorithm, or 5 and 3 if it is in the partial certificate\\)",
+		},
+		{
+			desc:  "missing version",
+			added: marshal(added[1], added[1], added[2], added[3]),
+			err:   "invalid addedToCertificate: unexpected encoding for version field \\(class: 0, tag: 2\\)",
+		},
+		{
+			desc:  "fields out of order",
+			added: marshal(added[0], added[2], added[1], added[3]),
+			err:   "invalid addedToCertificate: unexpected encoding for serialNumber field \\(class: 0, tag: 16\\)",
+		},
+		{
+			desc:  "invalid signature algorithm",
+			added: marshal(added[0], added[1], added[1], added[3]),
+			err:   "invalid addedToCertificate: unexpected encoding for signature field \\(class: 0, tag: 2\\)",
+		},
+		{
+			desc:  "invalid subjectPublicKeyInfo",
+			added: marshal(added[0], added[1], added[2], added[1]),
+			err:   "invalid addedToCertificate: unexpected encoding for subjectPublicKeyInfo field \\(class: 0, tag: 2\\)",
+		},
+	} {
+		_, err := CreateCertificateFromCertifyX509(partialCertificate, data.added, tbsDigest, signature)
+		c.Check(err, ErrorMatches, data.err, Commentf(data.desc))
+	}
+}
+
+func (s *x509Suite) TestCreateCertificateFromCertifyX509SigAlgInBoth(c *C) {
+	partialCertificate, err := CreatePartialCertificate(s.template(), s.caCert, s.public)
+	c.Assert(err, IsNil)
+
+	var partial []asn1.RawValue
+	_, err = asn1.Unmarshal(partialCertificate, &partial)
+	c.Assert(err, IsNil)
+	sigAlg, err := asn1.Marshal(pkix.AlgorithmIdentifier{Algorithm: oidSHA256WithRSA, Parameters: asn1.NullRawValue})
+	c.Assert(err, IsNil)
+	partialWithSigAlg, err := asn1.Marshal(append([]asn1.RawValue{{FullBytes: sigAlg}}, partial...))
+	c.Assert(err, IsNil)
+
+	addedToCertificate, tbsDigest, signature := s.certifyX509(c, partialCertificate, true)
+
+	_, err = CreateCertificateFromCertifyX509(partialWithSigAlg, addedToCertificate, tbsDigest, signature)
+	c.Check(err, ErrorMatches, "unsupported certificate layout: partial certificate has 5 fields and addedToCertificate has 4 fields "+
+		"\\(expected 4 and 4 if the TPM adds the signature algorithm, or 5 and 3 if it is in the partial certificate\\)")
+}
+
+type certifyX509Suite struct {
+	testutil.BaseTest
+	tcti *scriptedTcti
+	tpm  *TPMContext
+}
+
+var _ = Suite(&certifyX509Suite{})
+
+func (s *certifyX509Suite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	s.tcti = &scriptedTcti{}
+	s.tpm, _ = NewTPMContext(s.tcti)
+}
+
+func (s *certifyX509Suite) TestCertifyX509(c *C) {
+	object := Handle(0x80000001)
+	sign := Handle(0x80000002)
+
+	inScheme := SigScheme{
+		Scheme:  SigSchemeAlgECDSA,
+		Details: &SigSchemeU{ECDSA: &SigSchemeECDSA{HashAlg: HashAlgorithmSHA256}}}
+	signature := Signature{
+		SigAlg: SigSchemeAlgECDSA,
+		Signature: &SignatureU{
+			ECDSA: &SignatureECDSA{
+				Hash:       HashAlgorithmSHA256,
+				SignatureR: ECCParameter{1, 2, 3},
+				SignatureS: ECCParameter{4, 5, 6}}}}
+
+	rspParams, err := mu.MarshalToBytes(MaxBuffer("added"), Digest{7, 8, 9}, &signature)
+	c.Assert(err, IsNil)
+
+	s.tcti.script = []scriptedTctiExchange{
+		{
+			command: makeScriptedPacket(c, TagSessions, uint32(CommandCertifyX509),
+				object, sign, uint32(24),
+				HandlePW, uint16(0), uint8(1), Auth("foo"),
+				HandlePW, uint16(0), uint8(1), Auth("bar"),
+				Data{}, &inScheme, MaxBuffer("partial")),
+			response: makeScriptedPacket(c, TagSessions, uint32(Success), uint32(len(rspParams)),
+				mu.RawBytes(rspParams),
+				uint16(0), uint8(1), uint16(0),
+				uint16(0), uint8(1), uint16(0))}}
+
+	pub := Public{
+		Type:    ObjectTypeKeyedHash,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrUserWithAuth,
+		Params:  &PublicParamsU{KeyedHashDetail: &KeyedHashParams{Scheme: KeyedHashScheme{Scheme: KeyedHashSchemeNull}}},
+		Unique:  &PublicIDU{KeyedHash: make(Digest, 32)}}
+	objectContext, err := CreateObjectResourceContextFromPublic(object, &pub)
+	c.Assert(err, IsNil)
+	objectContext.SetAuthValue([]byte("foo"))
+
+	signContext, err := CreateObjectResourceContextFromPublic(sign, &pub)
+	c.Assert(err, IsNil)
+	signContext.SetAuthValue([]byte("bar"))
+
+	added, tbsDigest, sig, err := s.tpm.CertifyX509(objectContext, signContext, nil, &inScheme, MaxBuffer("partial"), nil, nil)
+	c.Check(err, IsNil)
+	c.Check(added, DeepEquals, MaxBuffer("added"))
+	c.Check(tbsDigest, DeepEquals, Digest{7, 8, 9})
+	c.Check(sig, DeepEquals, &signature)
+	c.Check(s.tcti.script, HasLen, 0)
+}